@@ -1,6 +1,8 @@
 package v0
 
 import (
+	"time"
+
 	"github.com/m-lab/gcp-service-discovery/discovery"
 	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/uuid-annotator/annotator"
@@ -15,22 +17,293 @@ type LookupResponse struct {
 // Lookup is returned for a successful lookup request.
 type Lookup struct {
 	IATA string
+	// CrossBorder is true when the airport is in another country, because
+	// the country of the request has no airports.
+	CrossBorder bool `json:",omitempty"`
+	// Airports are the nearest airports, closest first, when requested
+	// with results.
+	Airports []Airport `json:",omitempty"`
+}
+
+// Airport describes a candidate airport of a lookup.
+type Airport struct {
+	IATA      string
+	ICAO      string `json:",omitempty"`
+	Name      string
+	Country   string
+	Latitude  float64
+	Longitude float64
+	// DistanceKm is the distance from the location of a lookup, and is
+	// omitted by searches.
+	DistanceKm float64 `json:",omitempty"`
+	// CrossBorder is true for airports outside of the requested country.
+	CrossBorder bool `json:",omitempty"`
+}
+
+// BatchLookup is one location of a batch lookup request. It has either an
+// IP, which is geolocated, or a Country with a Latitude and Longitude.
+type BatchLookup struct {
+	IP        string  `json:",omitempty"`
+	Country   string  `json:",omitempty"`
+	Latitude  float64 `json:",omitempty"`
+	Longitude float64 `json:",omitempty"`
+}
+
+// BatchLookupResponse is returned by a batch lookup request.
+type BatchLookupResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Results has the result of each location of the request, in order.
+	Results []BatchLookupResult `json:",omitempty"`
+}
+
+// BatchLookupResult is the result of one location of a batch lookup request.
+// Error reports a location that could not be looked up.
+type BatchLookupResult struct {
+	Error     *v2.Error `json:",omitempty"`
+	IP        string    `json:",omitempty"`
+	IATA      string    `json:",omitempty"`
+	Country   string    `json:",omitempty"`
+	Latitude  float64   `json:",omitempty"`
+	Longitude float64   `json:",omitempty"`
+	// CrossBorder is true when the airport is outside of Country.
+	CrossBorder bool `json:",omitempty"`
+	// ASN is only known for locations given by IP.
+	ASN uint32 `json:",omitempty"`
+}
+
+// Verdicts of a metro validation.
+const (
+	MetroPass = "pass"
+	MetroWarn = "warn"
+	MetroFail = "fail"
+)
+
+// MetroValidationResponse is returned by a lookup validate request.
+type MetroValidationResponse struct {
+	Error      *v2.Error        `json:",omitempty"`
+	Validation *MetroValidation `json:",omitempty"`
+}
+
+// MetroValidation compares the IATA airport claimed by a node with the
+// geolocation of its IP.
+type MetroValidation struct {
+	IATA string
+	IP   string
+	// AirportCountry and Country are the countries of the airport and of
+	// the IP.
+	AirportCountry string
+	Country        string
+	DistanceKm     float64
+	// Verdict is MetroPass, MetroWarn, or MetroFail.
+	Verdict string
 }
 
 // RegisterResponse is returned by a register request.
 type RegisterResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Invalid lists every missing or invalid request parameter when Error
+	// reports a bad request.
+	Invalid []InvalidParam `json:",omitempty"`
+	// MinClientVersion is the oldest client version accepted for the service
+	// when Error reports an outdated client.
+	MinClientVersion string `json:",omitempty"`
+	// Warnings report conditions that do not fail the request but need the
+	// attention of the node operator, e.g. a deprecated client version.
+	Warnings     []Warning     `json:",omitempty"`
+	Registration *Registration `json:",omitempty"`
+	// DNS is the DNS status of the node, as in GetResponse.
+	DNS string `json:",omitempty"`
+}
+
+// Types of Warning.
+const (
+	// WarnClientDeprecated is reported to clients older than the deprecated
+	// client version, which will be rejected after the sunset date.
+	WarnClientDeprecated = "client_deprecated"
+	// WarnDNSPending is reported when the DNS records of the node are
+	// changed after the registration, either because Cloud DNS was
+	// unavailable or because the server registers DNS asynchronously. Its
+	// hostname resolves once the records are changed.
+	WarnDNSPending = "dns_pending"
+)
+
+// Warning describes a condition that did not fail a request.
+type Warning struct {
+	// Type is one of the warning types.
+	Type    string
+	Message string
+	// Sunset is the date, as YYYY-MM-DD, after which the condition fails
+	// requests, if known.
+	Sunset string `json:",omitempty"`
+}
+
+// Codes of InvalidParam.
+const (
+	ParamMissing    = "missing"
+	ParamInvalid    = "invalid"
+	ParamOutOfRange = "out_of_range"
+)
+
+// InvalidParam describes a request parameter that failed validation.
+type InvalidParam struct {
+	// Param is the name of the request parameter, e.g. "uplink".
+	Param string
+	// Code is one of ParamMissing, ParamInvalid, or ParamOutOfRange.
+	Code string
+	// Detail is a human readable description of the expected value.
+	Detail string `json:",omitempty"`
+}
+
+// UpdateResponse is returned by an update request.
+type UpdateResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Invalid lists every invalid request parameter when Error reports a bad
+	// request.
+	Invalid      []InvalidParam `json:",omitempty"`
+	Registration *Registration  `json:",omitempty"`
+}
+
+// DNS statuses of registered nodes.
+const (
+	// DNSApplied means the DNS records of the node match its registration.
+	DNSApplied = "applied"
+	// DNSPending means the DNS records of the node are not changed yet.
+	DNSPending = "pending"
+	// DNSPropagating means the DNS records of the node are changed, but
+	// Cloud DNS has not reported the change as done yet. Only reported by
+	// servers that verify DNS changes.
+	DNSPropagating = "propagating"
+)
+
+// GetResponse is returned by a get request.
+type GetResponse struct {
 	Error        *v2.Error     `json:",omitempty"`
 	Registration *Registration `json:",omitempty"`
+	// DNS is the DNS status of the node, DNSApplied, DNSPending or
+	// DNSPropagating.
+	DNS string `json:",omitempty"`
+}
+
+// MaintenanceResponse is returned by a maintenance request.
+type MaintenanceResponse struct {
+	Error       *v2.Error    `json:",omitempty"`
+	Hostname    string       `json:",omitempty"`
+	Maintenance *Maintenance `json:",omitempty"`
+}
+
+// Maintenance is the maintenance window of a node.
+type Maintenance struct {
+	Start time.Time
+	End   time.Time
+}
+
+// TokenResponse is returned by a token request.
+type TokenResponse struct {
+	Error       *v2.Error    `json:",omitempty"`
+	Hostname    string       `json:",omitempty"`
+	Credentials *Credentials `json:",omitempty"`
+}
+
+// DiffResponse is returned by a diff request.
+type DiffResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Invalid lists every missing or invalid request parameter when Error
+	// reports a bad request.
+	Invalid []InvalidParam `json:",omitempty"`
+	// Hostname is the hostname a registration would return now.
+	Hostname string `json:",omitempty"`
+	// Changes lists every heartbeat registration field whose local value
+	// differs from what a registration would return now. Changes is empty if
+	// the local registration is current.
+	Changes []FieldDiff `json:",omitempty"`
+}
+
+// FieldDiff describes a registration field that differs between the node and
+// the server.
+type FieldDiff struct {
+	Field  string
+	Local  string
+	Server string
 }
 
 // DeleteResponse is returned by a delete request.
 type DeleteResponse struct {
 	Error *v2.Error `json:",omitempty"`
+	// Operation is returned by asynchronous deletes and may be polled for
+	// completion.
+	Operation *Operation `json:",omitempty"`
+}
+
+// Operation states.
+const (
+	OperationPending = "pending"
+	OperationDone    = "done"
+	OperationFailed  = "failed"
+)
+
+// Operation describes a long running request.
+type Operation struct {
+	ID string
+	// Type is the kind of request, e.g. "delete".
+	Type     string
+	Hostname string
+	Org      string
+	// State is one of OperationPending, OperationDone, or OperationFailed.
+	State string
+	// Error describes why a failed operation did not complete.
+	Error   string `json:",omitempty" datastore:",noindex"`
+	Created time.Time
+	Updated time.Time
+}
+
+// OperationResponse is returned by an operation request.
+type OperationResponse struct {
+	Error     *v2.Error  `json:",omitempty"`
+	Operation *Operation `json:",omitempty"`
+}
+
+// DeleteSiteResponse is returned by a delete site request.
+type DeleteSiteResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Results contains the outcome for each hostname at the site.
+	Results []DeleteResult `json:",omitempty"`
+}
+
+// DeleteResult is the outcome of deleting a single hostname.
+type DeleteResult struct {
+	Hostname string
+	// Error is empty if the hostname was deleted.
+	Error string `json:",omitempty"`
+}
+
+// RecordsResponse is returned by an org records request.
+type RecordsResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	Org   string    `json:",omitempty"`
+	// Records contains the extra records of the organization zone, sorted by
+	// name and type.
+	Records []ExtraRecord `json:",omitempty"`
+	// Quota is the number of extra records the organization may add.
+	Quota int `json:",omitempty"`
+}
+
+// ExtraRecord is a record added by an organization to its zone, next to the
+// records of its nodes.
+type ExtraRecord struct {
+	// Name is relative to the organization zone, e.g. "*.lab".
+	Name string
+	// Type is one of "A", "AAAA", "CNAME", or "TXT".
+	Type   string
+	Values []string
+	TTL    int64
 }
 
 // ListResponse is returned by a list request.
 type ListResponse struct {
-	Error        *v2.Error                `json:",omitempty"`
+	Error *v2.Error `json:",omitempty"`
+	// Invalid names the invalid filter parameter when Error reports a bad
+	// request.
+	Invalid      []InvalidParam           `json:",omitempty"`
 	StaticConfig []discovery.StaticConfig `json:",omitempty"`
 	Servers      []string                 `json:",omitempty"`
 	Sites        []string                 `json:",omitempty"`
@@ -48,19 +321,79 @@ type ServerAnnotation struct {
 	Annotation annotator.ServerAnnotations
 	Network    Network
 	Type       string
+	// Traits flags addresses whose geolocation may be unreliable, if any.
+	Traits *IPTraits `json:",omitempty"`
+	// OperatorLocation is true if the coordinates, and the city if given,
+	// of the geolocation were given by the operator of the node.
+	OperatorLocation bool `json:",omitempty"`
+	// Cloud is the hosting of nodes of type "cloud", if given.
+	Cloud *Cloud `json:",omitempty"`
+}
+
+// Cloud describes where a cloud-hosted node runs.
+type Cloud struct {
+	// Provider is the cloud provider, e.g. "gcp".
+	Provider string `json:",omitempty"`
+	// Region is the region of the provider, e.g. "us-east1".
+	Region string `json:",omitempty"`
+}
+
+// IPTraits are the Maxmind flags of the address of a node.
+type IPTraits struct {
+	// Hosting is true for hosting providers and datacenters.
+	Hosting bool `json:",omitempty"`
+	// Proxy is true for VPNs, Tor exit nodes, and public, residential, or
+	// anonymous proxies.
+	Proxy bool `json:",omitempty"`
+}
+
+// SiteinfoAnnotation is returned for each node by List with format=siteinfo.
+// Results are keyed by hostname, like the siteinfo annotations.json used by
+// the uuid-annotator, so siteinfo consumers can read autojoined nodes.
+// From: https://github.com/m-lab/uuid-annotator/blob/main/siteannotator/server.go
+type SiteinfoAnnotation struct {
+	ServerAnnotation
+	// Org is the organization that registered the node.
+	Org string
 }
 
 // Credentials contains public or private key data needed for node operations.
 type Credentials struct {
 	// ServiceAccountKey contains the base64 encoded service account key for use
 	// by the node after registration.
-	ServiceAccountKey string
+	ServiceAccountKey string `json:",omitempty"`
+	// AccessToken is a short-lived OAuth access token for the service
+	// account of the node, returned instead of ServiceAccountKey when
+	// enabled for the organization. Nodes refresh it before AccessTokenExpiry.
+	AccessToken       string     `json:",omitempty"`
+	AccessTokenExpiry *time.Time `json:",omitempty"`
+	// APIKey is a new API key for the node, returned only when registering
+	// with a provisioning token.
+	APIKey string `json:",omitempty"`
+	// WorkloadIdentity is returned instead of ServiceAccountKey for
+	// organizations using workload identity federation.
+	WorkloadIdentity *WorkloadIdentity `json:",omitempty"`
+}
+
+// WorkloadIdentity contains the workload identity federation parameters nodes
+// use to exchange their OIDC tokens for service account credentials.
+type WorkloadIdentity struct {
+	// Audience is the audience of the OIDC tokens accepted by the provider.
+	Audience string
+	// Provider is the resource name of the workload identity provider.
+	Provider string
+	// ServiceAccount is the email of the service account to impersonate.
+	ServiceAccount string
 }
 
 // Registration is returned for a successful registration request.
 type Registration struct {
 	// Hostname is the dynamic DNS name. Hostname should be available immediately.
 	Hostname string
+	// Aliases are the hostnames of the other services of the node, e.g.
+	// msak-lga12345-01020304.foo.sandbox.measurement-lab.org. Each is a
+	// CNAME of Hostname.
+	Aliases []string `json:",omitempty"`
 
 	// Annotation is the metadata used by the uuid-annotator for all server annotations.
 	Annotation *ServerAnnotation `json:",omitempty"`
@@ -70,3 +403,253 @@ type Registration struct {
 	// Credentials contains node key data.
 	Credentials *Credentials `json:",omitempty"`
 }
+
+// ConfigResponse is returned by an admin config request.
+type ConfigResponse struct {
+	Error  *v2.Error `json:",omitempty"`
+	Config *Config   `json:",omitempty"`
+}
+
+// OrgResponse is returned by an admin org request.
+type OrgResponse struct {
+	Error    *v2.Error    `json:",omitempty"`
+	Org      string       `json:",omitempty"`
+	Settings *OrgSettings `json:",omitempty"`
+}
+
+// OrgSettings contains the options of one organization.
+type OrgSettings struct {
+	// Status is "active" or "suspended".
+	Status string
+	// Email is the operator contact of the organization.
+	Email string `json:",omitempty"`
+	// ProbabilityMultiplier scales the probability requested by nodes.
+	ProbabilityMultiplier float64 `json:",omitempty"`
+	// VerifySourceIP requires the ipv4 given at registration to match the
+	// source address of the request.
+	VerifySourceIP bool
+	// AllowSharedIP allows registration of addresses that are already
+	// registered by another organization.
+	AllowSharedIP bool
+	// AllowedASNs limits registrations to nodes in the given ASNs.
+	AllowedASNs []int64 `json:",omitempty"`
+	// AllowedPrefixes limits registrations to addresses within the given
+	// CIDR prefixes.
+	AllowedPrefixes []string `json:",omitempty"`
+	// AllowedServices limits registrations to nodes of the given services.
+	AllowedServices []string `json:",omitempty"`
+	// NodeKeys issues a separate service account key to each node.
+	NodeKeys bool
+	// AccessTokens returns short-lived access tokens to nodes instead of
+	// service account keys.
+	AccessTokens bool
+	// WorkloadIdentityProvider is the workload identity federation provider
+	// of the organization, if any.
+	WorkloadIdentityProvider string `json:",omitempty"`
+	// MaxRecords is the number of extra records the organization may add to
+	// its zone.
+	MaxRecords int
+	// NameServers are the name servers the organization zone is delegated
+	// to, if any.
+	NameServers []string `json:",omitempty"`
+	// DNSTTL is the TTL in seconds of the DNS records of nodes that do not
+	// request another one. Zero means the default of 300.
+	DNSTTL int64 `json:",omitempty"`
+	// MaxMetroDistance is the farthest distance in km of the IATA airport of
+	// a registration from the geolocation of the node, if limited.
+	MaxMetroDistance int `json:",omitempty"`
+	// RejectHosting rejects registrations of addresses of hosting providers
+	// and proxies, instead of only flagging them in the annotation.
+	RejectHosting bool
+}
+
+// OrgApplicationResponse is returned by an org apply request, and by admin
+// org application requests.
+type OrgApplicationResponse struct {
+	Error        *v2.Error         `json:",omitempty"`
+	Applications []*OrgApplication `json:",omitempty"`
+	// Key is the API key of the organization created by an approval.
+	Key string `json:",omitempty"`
+}
+
+// OrgApplication describes the application of a new organization.
+type OrgApplication struct {
+	Org   string
+	Email string
+	ASN   int64
+	// Nodes is the number of nodes the organization expects to register.
+	Nodes int64
+	// Status is "pending", "approved", or "rejected".
+	Status   string
+	Created  time.Time
+	Reviewed time.Time `json:",omitempty"`
+	Reviewer string    `json:",omitempty"`
+	Reason   string    `json:",omitempty"`
+}
+
+// VerifyResponse is returned by an org email verification request.
+type VerifyResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	Org   string    `json:",omitempty"`
+}
+
+// OrgHistoryResponse is returned by an admin org history request.
+type OrgHistoryResponse struct {
+	Error   *v2.Error          `json:",omitempty"`
+	Org     string             `json:",omitempty"`
+	Changes []MultiplierChange `json:",omitempty"`
+}
+
+// MultiplierChange describes one change of the probability multiplier of an
+// organization.
+type MultiplierChange struct {
+	Old float64
+	New float64
+	// Actor is the API key or user that made the change.
+	Actor string
+	Time  time.Time
+}
+
+// KeysResponse is returned by an admin keys request.
+type KeysResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	Org   string    `json:",omitempty"`
+	Keys  []APIKey  `json:",omitempty"`
+	// Key is the key string of a new API key. It is only returned when the
+	// key is created.
+	Key string `json:",omitempty"`
+}
+
+// APIKey describes an API key, without the key string.
+type APIKey struct {
+	ID      string
+	Scopes  []string
+	Created time.Time
+	// Age is the time since the key was created, e.g. "72h0m0s".
+	Age       string
+	ExpiresAt *time.Time `json:",omitempty"`
+	Revoked   *time.Time `json:",omitempty"`
+}
+
+// RotateResponse is returned by an admin rotate request.
+type RotateResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	Org   string    `json:",omitempty"`
+}
+
+// OverrideResponse is returned by an admin override request.
+type OverrideResponse struct {
+	Error    *v2.Error `json:",omitempty"`
+	Hostname string    `json:",omitempty"`
+	// Override is nil once the override is cleared.
+	Override *Override `json:",omitempty"`
+}
+
+// ExpireResponse is returned by an admin expire request.
+type ExpireResponse struct {
+	Error    *v2.Error `json:",omitempty"`
+	Hostname string    `json:",omitempty"`
+}
+
+// ClientVersionsResponse is returned by an admin client versions request.
+type ClientVersionsResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Orgs maps each org to the number of active nodes of each client
+	// version. Nodes that did not report a version are counted as "unknown".
+	Orgs map[string]map[string]int `json:",omitempty"`
+	// Older maps each org to the number of active nodes older than the
+	// "min_client_version" parameter, i.e. the nodes that would be rejected
+	// with that minimum version. Nodes of unknown versions are not counted.
+	Older map[string]int `json:",omitempty"`
+}
+
+// DatasetsResponse is returned by an admin datasets request.
+type DatasetsResponse struct {
+	Error    *v2.Error `json:",omitempty"`
+	Datasets []Dataset `json:",omitempty"`
+}
+
+// Dataset reports the freshness of a dataset used to annotate registrations.
+type Dataset struct {
+	// Name is one of "iata", "maxmind", "asn", or "geonames".
+	Name string
+	// Source is the URL the dataset is loaded from, if known.
+	Source string `json:",omitempty"`
+	// Loaded is the time of the last successful load, if any, and AgeSeconds
+	// the seconds since.
+	Loaded     *time.Time `json:",omitempty"`
+	AgeSeconds int64      `json:",omitempty"`
+	// Entries is the size of the dataset in use, e.g. the number of airports,
+	// if known.
+	Entries int `json:",omitempty"`
+	// Status is "ok", or why the dataset is not ready, as reported by the
+	// readiness check.
+	Status string
+}
+
+// VersionResponse is returned by a version request.
+type VersionResponse struct {
+	Error *v2.Error `json:",omitempty"`
+	// Version is the build version of the server.
+	Version string `json:",omitempty"`
+	// MinClientVersion is the oldest version of the client package accepted
+	// by the server, if any. With the "service" parameter, it is the oldest
+	// version accepted for the clients of that service.
+	MinClientVersion string `json:",omitempty"`
+	// Features are the names of the enabled feature flags, if any.
+	Features []string `json:",omitempty"`
+}
+
+// Node event types.
+const (
+	// EventRegister is sent when a new hostname registers.
+	EventRegister = "register"
+	// EventRenew is sent when a registered hostname registers again.
+	EventRenew = "renew"
+	// EventDelete is sent when a hostname is deleted by a request, or
+	// removed because its organization is suspended.
+	EventDelete = "delete"
+	// EventExpire is sent when a hostname stops registering and is removed,
+	// or is expired by an operator.
+	EventExpire = "expire"
+)
+
+// NodeEvent is a change of a registered node, streamed by the events request.
+type NodeEvent struct {
+	// Type is one of the Event constants.
+	Type     string
+	Hostname string
+	Org      string
+	// Reason is the reason a hostname was removed, e.g. "suspended".
+	Reason string `json:",omitempty"`
+	Time   time.Time
+}
+
+// Override is a probability set by operators that replaces the probability
+// requested by a node.
+type Override struct {
+	Probability float64
+	Reason      string `json:",omitempty"`
+}
+
+// Config contains the runtime adjustable settings of the Autojoin API.
+// Durations use Go duration syntax, e.g. "3h0m0s".
+type Config struct {
+	// GCTTL is how long a registration remains active without being renewed.
+	GCTTL string
+	// GCInterval is the time between garbage collection runs.
+	GCInterval string
+	// MinClientVersion is the oldest version of the client package accepted
+	// by the server, if any.
+	MinClientVersion string `json:",omitempty"`
+	// ServiceVersions overrides MinClientVersion for the clients of some
+	// services, by service name.
+	ServiceVersions map[string]string `json:",omitempty"`
+	// Features are the names of the enabled feature flags.
+	Features []string `json:",omitempty"`
+	// DeprecatedClientVersion is the oldest client version that is not
+	// deprecated, and ClientSunset the date, as YYYY-MM-DD, after which older
+	// clients are no longer supported.
+	DeprecatedClientVersion string `json:",omitempty"`
+	ClientSunset            string `json:",omitempty"`
+}
@@ -0,0 +1,61 @@
+package v0
+
+// Error types returned in the Type of every error response. Types are stable,
+// so clients may compare them to decide how to handle an error; the Title and
+// Detail are only meant for humans.
+const (
+	// Request errors.
+	ErrMethodNotAllowed   = "method_not_allowed"
+	ErrNotEnabled         = "not_enabled"
+	ErrInvalidParam       = "invalid_param"
+	ErrInvalidBody        = "invalid_body"
+	ErrInvalidOrg         = "invalid_org"
+	ErrInvalidHostname    = "invalid_hostname"
+	ErrInvalidProbability = "invalid_probability"
+	ErrInvalidConfig      = "invalid_config"
+	ErrMissingAPIKey      = "missing_api_key"
+	ErrInvalidAPIKey      = "invalid_api_key"
+	ErrMissingScope       = "missing_scope"
+	ErrMissingToken       = "missing_token"
+	ErrInvalidToken       = "invalid_token"
+	ErrIdempotencyKey     = "idempotency_key"
+	ErrIdempotencyBusy    = "idempotency_busy"
+	ErrIdempotencyReused  = "idempotency_reused"
+	ErrInvalidProject     = "invalid_project"
+
+	// Policy and state errors.
+	ErrNotFound          = "not_found"
+	ErrExists            = "exists"
+	ErrNotPending        = "not_pending"
+	ErrOrgSuspended      = "org_suspended"
+	ErrWrongOrg          = "wrong_org"
+	ErrSourceIPMismatch  = "source_ip_mismatch"
+	ErrServiceNotAllowed = "service_not_allowed"
+	ErrASNNotAllowed     = "asn_not_allowed"
+	ErrIPNotAllowed      = "ip_not_allowed"
+	ErrIPRegistered      = "ip_registered"
+	ErrProjectNotAllowed = "project_not_allowed"
+	ErrClientVersion     = "client_version"
+	ErrQuotaExceeded     = "quota_exceeded"
+	ErrMetroTooFar       = "metro_too_far"
+	ErrHostingIP         = "hosting_ip"
+
+	// Internal errors, named by the failed dependency.
+	ErrIATALookup   = "iata_lookup"
+	ErrGeoLookup    = "geo_lookup"
+	ErrDNSRegister  = "dns_register"
+	ErrDNSDelete    = "dns_delete"
+	ErrTracker      = "tracker"
+	ErrOrgSettings  = "org_settings"
+	ErrCredentials  = "credentials"
+	ErrAPIKeys      = "api_keys"
+	ErrConfig       = "config"
+	ErrOperation    = "operation"
+	ErrProvision    = "provision"
+	ErrApplications = "applications"
+	ErrOrgSetup     = "org_setup"
+	ErrEmail        = "email"
+	ErrList         = "list"
+	// ErrInternal is returned for unexpected server errors, e.g. panics.
+	ErrInternal = "internal"
+)
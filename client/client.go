@@ -0,0 +1,485 @@
+// Package client implements a client of the Autojoin API.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/gcp-service-discovery/discovery"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// Version is the version of this package, sent in the User-Agent header of
+// every request. Servers report the oldest version they accept.
+const Version = "0.1.0"
+
+// maxResponseBytes limits the size of responses read by the client.
+const maxResponseBytes = 64 << 20
+
+// ErrNoResult is returned when a successful response does not contain the
+// requested result.
+var ErrNoResult = errors.New("response has no result")
+
+// Error is returned for error responses of the API.
+type Error struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Problem is the error of the response, if any. Problem.Type is one of the
+	// error types of api/v0.
+	Problem *v2.Error
+	// Invalid lists the invalid parameters of register requests.
+	Invalid []v0.InvalidParam
+	// RequestID identifies the request in the server logs, if known.
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	var msg string
+	switch {
+	case e.Problem == nil:
+		msg = fmt.Sprintf("autojoin: %d %s", e.Status, http.StatusText(e.Status))
+	case e.Problem.Detail != "":
+		msg = fmt.Sprintf("autojoin: %d %s: %s: %s", e.Status, e.Problem.Type, e.Problem.Title, e.Problem.Detail)
+	default:
+		msg = fmt.Sprintf("autojoin: %d %s: %s", e.Status, e.Problem.Type, e.Problem.Title)
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Client calls the Autojoin API. Failed requests are retried with
+// exponential backoff if the failure may be temporary.
+type Client struct {
+	// BaseURL is the URL of the API without a path, e.g.
+	// https://autojoin.measurementlab.net.
+	BaseURL *url.URL
+	// APIKey is sent as the "key" parameter of every request, if set.
+	APIKey string
+	// Token returns a bearer token for the Authorization header of every
+	// request, if set.
+	Token func(ctx context.Context) (string, error)
+	// HTTP sends requests.
+	HTTP *http.Client
+	// Retries is the number of times a failed request is retried.
+	Retries int
+	// MinBackoff is the delay before the first retry. The delay doubles for
+	// each retry, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Warn is called with every warning of register responses, e.g. of a
+	// deprecated client version, if set.
+	Warn func(w v0.Warning)
+}
+
+// New creates a new Client of the API at baseURL that authenticates with the
+// given API key. Use the Token field for bearer token authentication.
+func New(baseURL *url.URL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		APIKey:     apiKey,
+		HTTP:       http.DefaultClient,
+		Retries:    3,
+		MinBackoff: time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// RegisterRequest contains the parameters of a register request.
+type RegisterRequest struct {
+	Service      string
+	Organization string
+	IATA         string
+	// ICAO is the ICAO code of the airport, used when IATA is empty.
+	ICAO string
+	// IPv4 defaults to the source address of the request.
+	IPv4 string
+	IPv6 string
+	// Type is the machine type, "physical", "virtual", or "cloud".
+	Type string
+	// Provider and Region optionally describe the hosting of cloud nodes,
+	// e.g. "gcp" and "us-east1".
+	Provider string
+	Region   string
+	// Uplink is the uplink speed, e.g. "10g".
+	Uplink string
+	// Probability defaults to 1 if nil.
+	Probability *float64
+	Ports       []string
+	// Labels are node labels of the form <key>=<value>.
+	Labels []string
+	// Services are the other services of the node, each registered with an
+	// alias of the hostname.
+	Services []string
+	// DNSTTL is the TTL in seconds of the DNS records of the node. Zero uses
+	// the TTL of the organization.
+	DNSTTL int64
+	// Location is the location of the node, if known better than the
+	// geolocation of its address. It must be near the airport.
+	Location *Location
+	// DryRun returns the registration without registering the node.
+	DryRun bool
+}
+
+// Location is the location of a node given by its operator.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	// City is optional.
+	City string
+}
+
+// Register registers a node and returns its registration, including
+// credentials. Nodes register periodically, which also serves as their
+// heartbeat; nodes that stop registering expire.
+func (c *Client) Register(ctx context.Context, r *RegisterRequest) (*v0.Registration, error) {
+	q := url.Values{}
+	q.Set("service", r.Service)
+	q["services"] = r.Services
+	q.Set("organization", r.Organization)
+	setIfNotEmpty(q, "iata", r.IATA)
+	setIfNotEmpty(q, "icao", r.ICAO)
+	setIfNotEmpty(q, "ipv4", r.IPv4)
+	setIfNotEmpty(q, "ipv6", r.IPv6)
+	q.Set("type", r.Type)
+	setIfNotEmpty(q, "provider", r.Provider)
+	setIfNotEmpty(q, "region", r.Region)
+	q.Set("uplink", r.Uplink)
+	if r.Probability != nil {
+		q.Set("probability", strconv.FormatFloat(*r.Probability, 'f', -1, 64))
+	}
+	q["ports"] = r.Ports
+	q["label"] = r.Labels
+	if r.DNSTTL != 0 {
+		q.Set("dns_ttl", strconv.FormatInt(r.DNSTTL, 10))
+	}
+	if r.Location != nil {
+		q.Set("lat", strconv.FormatFloat(r.Location.Latitude, 'f', -1, 64))
+		q.Set("lon", strconv.FormatFloat(r.Location.Longitude, 'f', -1, 64))
+		setIfNotEmpty(q, "city", r.Location.City)
+	}
+	if r.DryRun {
+		q.Set("dry_run", "true")
+	}
+	resp := v0.RegisterResponse{}
+	if err := c.do(ctx, http.MethodPost, "/autojoin/v0/node/register", q, true, &resp); err != nil {
+		return nil, err
+	}
+	if c.Warn != nil {
+		for _, w := range resp.Warnings {
+			c.Warn(w)
+		}
+	}
+	if resp.Registration == nil {
+		return nil, ErrNoResult
+	}
+	return resp.Registration, nil
+}
+
+// UpdateRequest contains the parameters of an update request. Only the given
+// ports or probability are changed.
+type UpdateRequest struct {
+	Hostname    string
+	Ports       []string
+	Probability *float64
+}
+
+// Update changes the ports or probability of a registered node without
+// registering it again.
+func (c *Client) Update(ctx context.Context, r *UpdateRequest) (*v0.Registration, error) {
+	q := url.Values{}
+	q.Set("hostname", r.Hostname)
+	q["ports"] = r.Ports
+	if r.Probability != nil {
+		q.Set("probability", strconv.FormatFloat(*r.Probability, 'f', -1, 64))
+	}
+	resp := v0.UpdateResponse{}
+	if err := c.do(ctx, http.MethodPost, "/autojoin/v0/node/update", q, false, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Registration == nil {
+		return nil, ErrNoResult
+	}
+	return resp.Registration, nil
+}
+
+// Get returns the registration of a registered node and the status of its DNS
+// records, v0.DNSApplied, v0.DNSPending or v0.DNSPropagating.
+func (c *Client) Get(ctx context.Context, hostname string) (*v0.GetResponse, error) {
+	q := url.Values{}
+	q.Set("hostname", hostname)
+	resp := v0.GetResponse{}
+	if err := c.do(ctx, http.MethodGet, "/autojoin/v0/node/get", q, false, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AccessToken returns new credentials with an access token for a registered
+// node of an organization that uses access tokens.
+func (c *Client) AccessToken(ctx context.Context, hostname string) (*v0.Credentials, error) {
+	q := url.Values{}
+	q.Set("hostname", hostname)
+	resp := v0.TokenResponse{}
+	if err := c.do(ctx, http.MethodPost, "/autojoin/v0/node/token", q, false, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Credentials == nil {
+		return nil, ErrNoResult
+	}
+	return resp.Credentials, nil
+}
+
+// Delete deletes a registered node. If async is true, the node is deleted in
+// the background and the returned operation may be polled for completion;
+// otherwise the returned operation is nil.
+func (c *Client) Delete(ctx context.Context, hostname string, async bool) (*v0.Operation, error) {
+	q := url.Values{}
+	q.Set("hostname", hostname)
+	if async {
+		q.Set("async", "true")
+	}
+	resp := v0.DeleteResponse{}
+	if err := c.do(ctx, http.MethodPost, "/autojoin/v0/node/delete", q, true, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Operation, nil
+}
+
+// ListRequest contains the filters of a list request. Empty filters match all
+// nodes.
+type ListRequest struct {
+	Org     string
+	Site    string
+	Metro   string
+	Service string
+	Type    string
+	Country string
+	// Labels are node label filters of the form <key>:<value>.
+	Labels []string
+	// Sites lists site names instead of hostnames.
+	Sites bool
+	// TargetLabels are the node fields added as labels to Targets, e.g.
+	// "metro" or "uplink".
+	TargetLabels []string
+}
+
+// query returns the list parameters of the request filters.
+func (r *ListRequest) query() url.Values {
+	q := url.Values{}
+	setIfNotEmpty(q, "org", r.Org)
+	setIfNotEmpty(q, "site", r.Site)
+	setIfNotEmpty(q, "metro", r.Metro)
+	setIfNotEmpty(q, "node_service", r.Service)
+	setIfNotEmpty(q, "type", r.Type)
+	setIfNotEmpty(q, "country", r.Country)
+	q["label"] = r.Labels
+	return q
+}
+
+// List returns the hostnames, or site names, of the registered nodes that
+// match the request filters.
+func (c *Client) List(ctx context.Context, r *ListRequest) ([]string, error) {
+	q := r.query()
+	if r.Sites {
+		q.Set("format", "sites")
+	}
+	resp := v0.ListResponse{}
+	if err := c.do(ctx, http.MethodGet, "/autojoin/v0/node/list", q, false, &resp); err != nil {
+		return nil, err
+	}
+	if r.Sites {
+		return resp.Sites, nil
+	}
+	return resp.Servers, nil
+}
+
+// Annotations returns the siteinfo annotations of the registered nodes that
+// match the request filters, keyed by hostname. Nodes registered by earlier
+// versions of the API have no annotation.
+func (c *Client) Annotations(ctx context.Context, r *ListRequest) (map[string]v0.SiteinfoAnnotation, error) {
+	q := r.query()
+	q.Set("format", "siteinfo")
+	resp := map[string]v0.SiteinfoAnnotation{}
+	if err := c.do(ctx, http.MethodGet, "/autojoin/v0/node/list", q, false, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Targets returns the Prometheus targets of the registered nodes that match
+// the request filters, with one target for each port of a node.
+func (c *Client) Targets(ctx context.Context, r *ListRequest) ([]discovery.StaticConfig, error) {
+	q := r.query()
+	q.Set("format", "prometheus")
+	if len(r.TargetLabels) > 0 {
+		q.Set("labels", strings.Join(r.TargetLabels, ","))
+	}
+	resp := []discovery.StaticConfig{}
+	if err := c.do(ctx, http.MethodGet, "/autojoin/v0/node/list", q, false, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Expire removes a registered node from DNS now, as if it had stopped
+// registering. This requires an API key for the admin API.
+func (c *Client) Expire(ctx context.Context, hostname string) error {
+	q := url.Values{}
+	q.Set("hostname", hostname)
+	resp := v0.ExpireResponse{}
+	return c.do(ctx, http.MethodPost, "/autojoin/v0/admin/expire", q, false, &resp)
+}
+
+// Lookup returns the IATA code of the metro nearest to the given location.
+// If country is empty, the location of the request is used instead.
+func (c *Client) Lookup(ctx context.Context, country string, lat, lon float64) (string, error) {
+	q := url.Values{}
+	if country != "" {
+		q.Set("country", country)
+		q.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+		q.Set("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	}
+	resp := v0.LookupResponse{}
+	if err := c.do(ctx, http.MethodGet, "/autojoin/v0/lookup", q, false, &resp); err != nil {
+		return "", err
+	}
+	if resp.Lookup == nil {
+		return "", ErrNoResult
+	}
+	return resp.Lookup.IATA, nil
+}
+
+// ServerVersion returns the build version of the server and the oldest
+// client version it accepts.
+func (c *Client) ServerVersion(ctx context.Context) (*v0.VersionResponse, error) {
+	resp := v0.VersionResponse{}
+	if err := c.do(ctx, http.MethodGet, "/autojoin/v0/version", url.Values{}, false, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// do sends the request, retrying temporary failures, and decodes the JSON
+// response into result. Requests with idempotent set include an
+// Idempotency-Key header that is the same for all retries, so that a retry
+// after a lost response does not repeat the request.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, idempotent bool, result interface{}) error {
+	if c.APIKey != "" {
+		q.Set("key", c.APIKey)
+	}
+	u := *c.BaseURL
+	u.Path = path
+	u.RawQuery = q.Encode()
+	key := ""
+	if idempotent {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to create idempotency key: %w", err)
+		}
+		key = hex.EncodeToString(b)
+	}
+
+	backoff := c.MinBackoff
+	for i := 0; ; i++ {
+		err := c.send(ctx, method, u.String(), key, result)
+		if err == nil || i >= c.Retries || !temporary(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// send sends one request and decodes the JSON response into result.
+func (c *Client) send(ctx context.Context, method, u, key string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "autojoin-client/"+Version)
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if c.Token != nil {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	// Asynchronous requests return 202 Accepted.
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Error{Status: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+		errResp := struct {
+			Error   *v2.Error
+			Invalid []v0.InvalidParam
+		}{}
+		if json.Unmarshal(body, &errResp) == nil {
+			e.Problem = errResp.Error
+			e.Invalid = errResp.Invalid
+		}
+		return e
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// temporary returns true if the error of a request may be resolved by
+// retrying it, i.e. network errors, rate limits, and server errors other than
+// features that are not implemented.
+func temporary(err error) bool {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch {
+	case e.Status == http.StatusTooManyRequests:
+		return true
+	case e.Status == http.StatusNotImplemented:
+		return false
+	default:
+		return e.Status >= http.StatusInternalServerError
+	}
+}
+
+func setIfNotEmpty(q url.Values, key, value string) {
+	if value != "" {
+		q.Set(key, value)
+	}
+}
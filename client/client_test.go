@@ -0,0 +1,410 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/gcp-service-discovery/discovery"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// fakeAPI responds with the given statuses and responses in order, and
+// records the requests.
+type fakeAPI struct {
+	statuses []int
+	resps    []interface{}
+	reqs     []*http.Request
+}
+
+func (f *fakeAPI) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	i := len(f.reqs)
+	f.reqs = append(f.reqs, req)
+	rw.Header().Set("X-Request-ID", "fake-id")
+	rw.WriteHeader(f.statuses[i])
+	json.NewEncoder(rw).Encode(f.resps[i])
+}
+
+func newTestClient(t *testing.T, f *fakeAPI) *Client {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server url: %v", err)
+	}
+	c := New(u, "fake-key")
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = time.Millisecond
+	return c
+}
+
+func TestClient_Register(t *testing.T) {
+	reg := &v0.Registration{Hostname: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org"}
+	invalid := v0.RegisterResponse{
+		Error:   &v2.Error{Type: v0.ErrInvalidParam, Title: "invalid parameters from request", Status: http.StatusBadRequest},
+		Invalid: []v0.InvalidParam{{Param: "uplink", Code: v0.ParamInvalid}},
+	}
+	unavailable := v0.RegisterResponse{
+		Error: &v2.Error{Type: v0.ErrDNSRegister, Title: "could not register dynamic hostname", Status: http.StatusInternalServerError},
+	}
+	tests := []struct {
+		name         string
+		api          *fakeAPI
+		wantCalls    int
+		wantErr      bool
+		wantType     string
+		wantWarnings int
+	}{
+		{
+			name: "success",
+			api: &fakeAPI{
+				statuses: []int{http.StatusOK},
+				resps:    []interface{}{v0.RegisterResponse{Registration: reg}},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "success-after-retry",
+			api: &fakeAPI{
+				statuses: []int{http.StatusInternalServerError, http.StatusOK},
+				resps:    []interface{}{unavailable, v0.RegisterResponse{Registration: reg}},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "success-warnings",
+			api: &fakeAPI{
+				statuses: []int{http.StatusOK},
+				resps: []interface{}{v0.RegisterResponse{Registration: reg, Warnings: []v0.Warning{
+					{Type: v0.WarnClientDeprecated, Message: "client version 0.1.0 is deprecated", Sunset: "2030-01-01"},
+				}}},
+			},
+			wantCalls:    1,
+			wantWarnings: 1,
+		},
+		{
+			name: "error-invalid-not-retried",
+			api: &fakeAPI{
+				statuses: []int{http.StatusBadRequest},
+				resps:    []interface{}{invalid},
+			},
+			wantCalls: 1,
+			wantErr:   true,
+			wantType:  v0.ErrInvalidParam,
+		},
+		{
+			name: "error-retries-exhausted",
+			api: &fakeAPI{
+				statuses: []int{500, 500, 500, 500},
+				resps:    []interface{}{unavailable, unavailable, unavailable, unavailable},
+			},
+			wantCalls: 4,
+			wantErr:   true,
+			wantType:  v0.ErrDNSRegister,
+		},
+		{
+			name: "error-no-registration",
+			api: &fakeAPI{
+				statuses: []int{http.StatusOK},
+				resps:    []interface{}{v0.RegisterResponse{}},
+			},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.api)
+			warnings := []v0.Warning{}
+			c.Warn = func(w v0.Warning) { warnings = append(warnings, w) }
+			p := 0.5
+			got, err := c.Register(context.Background(), &RegisterRequest{
+				Service:      "ndt",
+				Organization: "foo",
+				IATA:         "lga",
+				Type:         "physical",
+				Uplink:       "10g",
+				Probability:  &p,
+				Ports:        []string{"9990", "9991"},
+				Labels:       []string{"provider=acme", "rack=r1"},
+				Services:     []string{"msak"},
+				Location:     &Location{Latitude: 40.7433, Longitude: -73.9196},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(tt.api.reqs) != tt.wantCalls {
+				t.Errorf("Register() sent %d requests, want %d", len(tt.api.reqs), tt.wantCalls)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("Register() reported %d warnings, want %d", len(warnings), tt.wantWarnings)
+			}
+			var e *Error
+			if tt.wantType != "" && (!errors.As(err, &e) || e.Problem == nil || e.Problem.Type != tt.wantType) {
+				t.Errorf("Register() returned wrong error; got %v, want type %q", err, tt.wantType)
+			}
+			if tt.wantType == v0.ErrInvalidParam && len(e.Invalid) != 1 {
+				t.Errorf("Register() returned wrong invalid params; got %v", e.Invalid)
+			}
+			// Retries reuse the idempotency key of the first request.
+			key := tt.api.reqs[0].Header.Get("Idempotency-Key")
+			for _, req := range tt.api.reqs {
+				if k := req.Header.Get("Idempotency-Key"); k == "" || k != key {
+					t.Errorf("Register() sent wrong idempotency key; got %q, want %q", k, key)
+				}
+			}
+			q := tt.api.reqs[0].URL.Query()
+			if q.Get("key") != "fake-key" || q.Get("probability") != "0.5" || len(q["ports"]) != 2 ||
+				len(q["label"]) != 2 || q["label"][0] != "provider=acme" || q.Has("ipv4") || q.Get("services") != "msak" ||
+				q.Get("lat") != "40.7433" || q.Get("lon") != "-73.9196" || q.Has("city") || q.Has("provider") || q.Has("region") {
+				t.Errorf("Register() sent wrong parameters; got %v", q)
+			}
+			if err == nil && got.Hostname != reg.Hostname {
+				t.Errorf("Register() returned wrong hostname; got %q, want %q", got.Hostname, reg.Hostname)
+			}
+		})
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK},
+		resps:    []interface{}{v0.TokenResponse{Credentials: &v0.Credentials{AccessToken: "fake-token"}}},
+	}
+	c := newTestClient(t, api)
+	c.APIKey = ""
+	c.Token = func(ctx context.Context) (string, error) {
+		return "fake-bearer", nil
+	}
+	creds, err := c.AccessToken(context.Background(), "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org")
+	if err != nil || creds.AccessToken != "fake-token" {
+		t.Fatalf("AccessToken() = %v, %v; want fake-token", creds, err)
+	}
+	if h := api.reqs[0].Header.Get("Authorization"); h != "Bearer fake-bearer" {
+		t.Errorf("AccessToken() sent wrong authorization; got %q", h)
+	}
+	if api.reqs[0].URL.Query().Has("key") {
+		t.Errorf("AccessToken() sent api key without APIKey")
+	}
+
+	c.Token = func(ctx context.Context) (string, error) {
+		return "", errors.New("fake token error")
+	}
+	if _, err := c.AccessToken(context.Background(), "foo"); err == nil || len(api.reqs) != 1 {
+		t.Errorf("AccessToken() = %v after %d requests; want error without request", err, len(api.reqs))
+	}
+}
+
+func TestClient_Update(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK, http.StatusNotFound},
+		resps: []interface{}{
+			v0.UpdateResponse{Registration: &v0.Registration{Hostname: "foo"}},
+			v0.UpdateResponse{Error: &v2.Error{Type: v0.ErrNotFound, Status: http.StatusNotFound}},
+		},
+	}
+	c := newTestClient(t, api)
+	p := 0.0
+	r, err := c.Update(context.Background(), &UpdateRequest{Hostname: "foo", Probability: &p})
+	if err != nil || r.Hostname != "foo" {
+		t.Fatalf("Update() = %v, %v; want foo", r, err)
+	}
+	if q := api.reqs[0].URL.Query(); q.Get("probability") != "0" || q.Has("ports") {
+		t.Errorf("Update() sent wrong parameters; got %v", q)
+	}
+	var e *Error
+	_, err = c.Update(context.Background(), &UpdateRequest{Hostname: "foo", Ports: []string{"9990"}})
+	if !errors.As(err, &e) || e.RequestID != "fake-id" {
+		t.Errorf("Update() returned wrong error; got %v, want request id fake-id", err)
+	}
+}
+
+func TestClient_Get(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK},
+		resps:    []interface{}{v0.GetResponse{Registration: &v0.Registration{Hostname: "foo"}, DNS: v0.DNSPending}},
+	}
+	c := newTestClient(t, api)
+	r, err := c.Get(context.Background(), "foo")
+	if err != nil || r.DNS != v0.DNSPending {
+		t.Fatalf("Get() = %v, %v; want pending", r, err)
+	}
+	if q := api.reqs[0].URL.Query(); q.Get("hostname") != "foo" || api.reqs[0].Method != http.MethodGet {
+		t.Errorf("Get() sent wrong request; got %s %v", api.reqs[0].Method, q)
+	}
+}
+
+func TestClient_Delete(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusAccepted, http.StatusOK},
+		resps:    []interface{}{v0.DeleteResponse{Operation: &v0.Operation{ID: "op1"}}, v0.DeleteResponse{}},
+	}
+	c := newTestClient(t, api)
+	op, err := c.Delete(context.Background(), "foo", true)
+	if err != nil || op == nil || op.ID != "op1" {
+		t.Fatalf("Delete() = %v, %v; want op1", op, err)
+	}
+	if q := api.reqs[0].URL.Query(); q.Get("async") != "true" || api.reqs[0].Method != http.MethodPost {
+		t.Errorf("Delete() sent wrong request; got %s %v", api.reqs[0].Method, q)
+	}
+	op, err = c.Delete(context.Background(), "foo", false)
+	if err != nil || op != nil {
+		t.Errorf("Delete() = %v, %v; want no operation", op, err)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK, http.StatusOK, http.StatusBadRequest},
+		resps: []interface{}{
+			v0.ListResponse{Servers: []string{"a", "b"}},
+			v0.ListResponse{Sites: []string{"lga12345"}},
+			v0.ListResponse{Error: &v2.Error{Type: v0.ErrInvalidParam, Status: http.StatusBadRequest}},
+		},
+	}
+	c := newTestClient(t, api)
+	got, err := c.List(context.Background(), &ListRequest{Org: "foo"})
+	if err != nil || len(got) != 2 {
+		t.Errorf("List() = %v, %v; want 2 servers", got, err)
+	}
+	got, err = c.List(context.Background(), &ListRequest{Sites: true})
+	if err != nil || len(got) != 1 || api.reqs[1].URL.Query().Get("format") != "sites" {
+		t.Errorf("List() = %v, %v; want 1 site", got, err)
+	}
+	if _, err := c.List(context.Background(), &ListRequest{Org: "-BAD-"}); err == nil {
+		t.Errorf("List() expected error")
+	}
+}
+
+func TestClient_Annotations(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK},
+		resps: []interface{}{map[string]v0.SiteinfoAnnotation{
+			"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org": {Org: "foo"},
+		}},
+	}
+	c := newTestClient(t, api)
+	got, err := c.Annotations(context.Background(), &ListRequest{Org: "foo", Labels: []string{"rack:r1"}})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Annotations() = %v, %v; want 1 annotation", got, err)
+	}
+	if q := api.reqs[0].URL.Query(); q.Get("format") != "siteinfo" || q.Get("org") != "foo" || q.Get("label") != "rack:r1" {
+		t.Errorf("Annotations() sent wrong parameters; got %v", q)
+	}
+}
+
+func TestClient_Targets(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK},
+		resps: []interface{}{[]discovery.StaticConfig{
+			{Targets: []string{"foo:9990"}, Labels: map[string]string{"org": "foo"}},
+		}},
+	}
+	c := newTestClient(t, api)
+	got, err := c.Targets(context.Background(), &ListRequest{Site: "lga12345", TargetLabels: []string{"metro", "uplink"}})
+	if err != nil || len(got) != 1 || got[0].Labels["org"] != "foo" {
+		t.Fatalf("Targets() = %v, %v; want 1 target", got, err)
+	}
+	if q := api.reqs[0].URL.Query(); q.Get("format") != "prometheus" || q.Get("labels") != "metro,uplink" {
+		t.Errorf("Targets() sent wrong parameters; got %v", q)
+	}
+}
+
+func TestClient_Expire(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK, http.StatusNotFound},
+		resps: []interface{}{
+			v0.ExpireResponse{Hostname: "foo"},
+			v0.ExpireResponse{Error: &v2.Error{Type: v0.ErrNotFound, Status: http.StatusNotFound}},
+		},
+	}
+	c := newTestClient(t, api)
+	if err := c.Expire(context.Background(), "foo"); err != nil {
+		t.Fatalf("Expire() = %v; want nil", err)
+	}
+	if req := api.reqs[0]; req.Method != http.MethodPost || req.URL.Path != "/autojoin/v0/admin/expire" {
+		t.Errorf("Expire() sent wrong request; got %s %s", req.Method, req.URL.Path)
+	}
+	var e *Error
+	if err := c.Expire(context.Background(), "foo"); !errors.As(err, &e) || e.Problem.Type != v0.ErrNotFound {
+		t.Errorf("Expire() returned wrong error; got %v", err)
+	}
+}
+
+func TestClient_Lookup(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK, http.StatusOK},
+		resps: []interface{}{
+			v0.LookupResponse{Lookup: &v0.Lookup{IATA: "lga"}},
+			v0.LookupResponse{},
+		},
+	}
+	c := newTestClient(t, api)
+	got, err := c.Lookup(context.Background(), "US", 40.7, -74)
+	if err != nil || got != "lga" {
+		t.Errorf("Lookup() = %q, %v; want lga", got, err)
+	}
+	if q := api.reqs[0].URL.Query(); q.Get("country") != "US" || q.Get("lat") != "40.7" || q.Get("lon") != "-74" {
+		t.Errorf("Lookup() sent wrong parameters; got %v", q)
+	}
+	if _, err := c.Lookup(context.Background(), "", 0, 0); !errors.Is(err, ErrNoResult) {
+		t.Errorf("Lookup() returned wrong error; got %v, want %v", err, ErrNoResult)
+	}
+}
+
+func TestClient_ServerVersion(t *testing.T) {
+	api := &fakeAPI{
+		statuses: []int{http.StatusOK},
+		resps:    []interface{}{v0.VersionResponse{Version: "v1.2.3", MinClientVersion: "0.1.0"}},
+	}
+	c := newTestClient(t, api)
+	got, err := c.ServerVersion(context.Background())
+	if err != nil || got.Version != "v1.2.3" || got.MinClientVersion != "0.1.0" {
+		t.Fatalf("ServerVersion() = %v, %v; want v1.2.3", got, err)
+	}
+	if ua := api.reqs[0].Header.Get("User-Agent"); ua != "autojoin-client/"+Version {
+		t.Errorf("ServerVersion() sent wrong user agent; got %q", ua)
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{
+			name: "no-problem",
+			err:  &Error{Status: http.StatusBadGateway},
+			want: "autojoin: 502 Bad Gateway",
+		},
+		{
+			name: "problem",
+			err:  &Error{Status: 404, Problem: &v2.Error{Type: v0.ErrNotFound, Title: "hostname is not registered"}},
+			want: "autojoin: 404 not_found: hostname is not registered",
+		},
+		{
+			name: "problem-detail",
+			err:  &Error{Status: 500, Problem: &v2.Error{Type: v0.ErrEmail, Title: "failed", Detail: "boom"}},
+			want: "autojoin: 500 email: failed: boom",
+		},
+		{
+			name: "request-id",
+			err:  &Error{Status: 404, Problem: &v2.Error{Type: v0.ErrNotFound, Title: "not registered"}, RequestID: "abc"},
+			want: "autojoin: 404 not_found: not registered (request abc)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	apikeys "cloud.google.com/go/apikeys/apiv2"
+	"cloud.google.com/go/datastore"
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/autojoin/handler"
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/adminx/crmiface"
+	"github.com/m-lab/autojoin/internal/adminx/iamiface"
+	"github.com/m-lab/autojoin/internal/adminx/keysiface"
+	"github.com/m-lab/autojoin/internal/adminx/kmsiface"
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/memory"
+	"github.com/m-lab/autojoin/internal/provision"
+	"github.com/m-lab/autojoin/internal/signup"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/rtx"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// datastoreClient is the subset of the Datastore client used by the server.
+// It is implemented by *datastore.Client and *memory.Datastore.
+type datastoreClient interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+}
+
+// clients are the clients of the Google Cloud and Redis services used by the
+// server.
+type clients struct {
+	dns      dnsiface.Service
+	iam      adminx.IAMService
+	creds    adminx.CredentialsService
+	secrets  adminx.SecretManagerClient
+	kms      adminx.KMSService
+	keys     adminx.KeysClient
+	ds       datastoreClient
+	signups  signup.Datastore
+	tokens   provision.Datastore
+	tracker  tracker.MemorystoreClient[tracker.Status]
+	checks   map[string]handler.Check
+	httpOpts []option.ClientOption
+	// pool is the Redis pool of the tracker, or nil without -redis-address and
+	// in -dev mode. Idempotency keys, the shared cache, read replicas and
+	// Redis events need a pool.
+	pool *redis.Pool
+	// entries is the Datastore of tracker entries with -tracker=datastore.
+	entries tracker.Datastore
+	crm     func(project string) adminx.CRM
+	closer  []func() error
+}
+
+// Close closes the clients.
+func (c *clients) Close() {
+	for _, f := range c.closer {
+		f()
+	}
+}
+
+// newCloudClients creates the clients of the Google Cloud services of the
+// project and of the Memorystore at redisAddr. DNS entries are tracked in the
+// store of -tracker. Calls of the clients are traced.
+func newCloudClients(ctx context.Context) *clients {
+	c := &clients{}
+	var err error
+	c.httpOpts, err = tracing.HTTPOptions(ctx)
+	rtx.Must(err, "failed to create traced http client")
+	grpcOpts := tracing.GRPCOptions()
+
+	// Setup DNS service.
+	ds, err := dns.NewService(ctx, c.httpOpts...)
+	rtx.Must(err, "failed to create new dns service")
+	c.dns = dnsx.NewBreaker(dnsiface.NewRetryService(dnsiface.NewCloudDNSService(ds), dnsRetries), dnsThreshold, dnsCooldown)
+
+	// Secret Manager & Service Accounts
+	sc, err := secretmanager.NewClient(ctx, grpcOpts...)
+	rtx.Must(err, "failed to create secretmanager client")
+	c.closer = append(c.closer, sc.Close)
+	c.secrets = sc
+	ic, err := iam.NewService(ctx, c.httpOpts...)
+	rtx.Must(err, "failed to create iam service client")
+	c.iam = iamiface.NewIAM(ic)
+	if kmsKey != "" {
+		kc, err := cloudkms.NewService(ctx, c.httpOpts...)
+		rtx.Must(err, "failed to create kms service client")
+		c.kms = kmsiface.NewKMS(kc)
+	}
+	cs, err := iamcredentials.NewService(ctx, c.httpOpts...)
+	rtx.Must(err, "failed to create iam credentials service client")
+	c.creds = iamiface.NewCredentials(cs)
+	ac, err := apikeys.NewClient(ctx, grpcOpts...)
+	rtx.Must(err, "failed to create apikeys client")
+	c.closer = append(c.closer, ac.Close)
+	c.keys = keysiface.NewKeys(ac)
+	c.crm = func(project string) adminx.CRM {
+		rs, err := cloudresourcemanager.NewService(ctx)
+		rtx.Must(err, "failed to create cloud resource manager client")
+		return crmiface.NewCRM(project, rs)
+	}
+
+	dc, err := datastore.NewClient(ctx, project, grpcOpts...)
+	rtx.Must(err, "failed to create datastore client")
+	c.closer = append(c.closer, dc.Close)
+	c.ds = dc
+	c.signups = signup.NewDatastore(dc)
+	c.tokens = provision.NewDatastore(dc)
+
+	if redisAddr != "" {
+		c.pool, c.tracker = connectMemorystore()
+		c.closer = append(c.closer, c.pool.Close)
+	}
+	switch {
+	case trackerStore == "datastore":
+		log.Printf("Tracking DNS entries in Datastore namespace %s", dsNamespace)
+		c.entries = tracker.NewDatastore(dc)
+		c.tracker = tracker.NewDatastoreClient(c.entries, dsNamespace, trackerTTL)
+	case c.pool == nil:
+		// Registrations are only known to this instance, and are lost on
+		// restart until nodes register again.
+		log.Printf("No -redis-address; tracking DNS entries in memory")
+		c.tracker = memory.NewMemorystore[tracker.Status]()
+	}
+
+	// Ready fails while a dependency is unavailable.
+	c.checks = map[string]handler.Check{
+		"datastore": func(ctx context.Context) error {
+			// Any lookup reaches Datastore; the entity need not exist.
+			k := datastore.NameKey("Readiness", "ready", nil)
+			k.Namespace = dsNamespace
+			err := dc.Get(ctx, k, &datastore.PropertyList{})
+			if errors.Is(err, datastore.ErrNoSuchEntity) {
+				return nil
+			}
+			return err
+		},
+	}
+	if c.pool != nil {
+		c.checks["memorystore"] = func(ctx context.Context) error {
+			conn, err := c.pool.GetContext(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = redis.DoContext(conn, ctx, "PING")
+			return err
+		}
+	}
+	return c
+}
+
+// newTracker returns the tracker of the DNS entries of a project of the
+// -projects registry, in the store of the tracker of the primary project.
+func (c *clients) newTracker(p projectConfig) tracker.MemorystoreClient[tracker.Status] {
+	switch {
+	case c.entries != nil:
+		return tracker.NewDatastoreClient(c.entries, p.Namespace, trackerTTL)
+	case c.pool != nil:
+		pool, err := redisCfg.NewPool("tracker-"+p.Project, redisAddr, p.RedisDB)
+		rtx.Must(err, "failed to create redis pool of %s", p.Project)
+		c.closer = append(c.closer, pool.Close)
+		return tracker.NewRedisClient[tracker.Status](pool)
+	}
+	return memory.NewMemorystore[tracker.Status]()
+}
+
+// connectMemorystore connects to the memorystore at redisAddr with the
+// -redis-* flags, and returns its pool and tracker client.
+func connectMemorystore() (*redis.Pool, tracker.MemorystoreClient[tracker.Status]) {
+	pool, err := redisCfg.NewPool("tracker", redisAddr, 0)
+	rtx.Must(err, "failed to create redis pool")
+	msClient := tracker.NewRedisClient[tracker.Status](pool)
+
+	// Test connection by calling GetAll
+	entries, err := msClient.GetAll()
+	rtx.Must(err, "Could not connect to memorystore")
+	log.Printf("Connected to memorystore at %s", redisAddr)
+	log.Printf("Number of tracked DNS entries: %d", len(entries))
+	return pool, msClient
+}
+
+// newDevClients creates in-memory clients for local development. The zones of
+// the project and of the registry projects are created, and so are the given
+// organizations of the project, whose API keys are logged. State is lost when
+// the server stops.
+func newDevClients(ctx context.Context, orgs []string, registry []projectConfig) *clients {
+	mi := memory.NewIAM()
+	md := memory.NewDatastore()
+	c := &clients{
+		dns:     memory.NewDNS(),
+		iam:     mi,
+		creds:   memory.NewCredentials(mi),
+		secrets: memory.NewSecretManager(),
+		keys:    memory.NewKeys(),
+		ds:      md,
+		signups: md.Signups(),
+		tokens:  md.Tokens(),
+		tracker: memory.NewMemorystore[tracker.Status](),
+	}
+	if trackerStore == "datastore" {
+		c.entries = md.Tracker()
+		c.tracker = tracker.NewDatastoreClient(c.entries, dsNamespace, trackerTTL)
+	}
+	crms := map[string]adminx.CRM{}
+	c.crm = func(project string) adminx.CRM {
+		if crms[project] == nil {
+			crms[project] = memory.NewCRM(project)
+		}
+		return crms[project]
+	}
+	if kmsKey != "" {
+		log.Printf("Ignoring -kms-key in -dev mode; keys are stored unencrypted")
+	}
+
+	for _, p := range registry {
+		z := dnsx.NewManager(c.dns, p.Project, dnsname.ProjectZone(p.Project, p.Domain))
+		_, err := z.RegisterZone(ctx, &dns.ManagedZone{
+			Name:        z.Zone,
+			DnsName:     dnsname.ProjectDNS(p.Project, p.Domain),
+			Description: "Autojoin development zone",
+		})
+		rtx.Must(err, "failed to create project zone of %s", p.Project)
+	}
+	pz := dnsx.NewManager(c.dns, project, dnsname.ProjectZone(project, domain))
+	_, err := pz.RegisterZone(ctx, &dns.ManagedZone{
+		Name:        pz.Zone,
+		DnsName:     dnsname.ProjectDNS(project, domain),
+		Description: "Autojoin development zone",
+	})
+	rtx.Must(err, "failed to create project zone")
+	n := adminx.NewNamer(project)
+	sa := adminx.NewServiceAccountsManager(c.iam, n)
+	o := adminx.NewOrg(project, c.crm(project), sa, adminx.NewSecretManager(c.secrets, n, sa), pz,
+		adminx.NewAPIKeys(project, c.keys, n), false)
+	o.Domain = domain
+	for _, org := range orgs {
+		key, err := o.Setup(ctx, org)
+		rtx.Must(err, "failed to create organization %s", org)
+		log.Printf("Created organization %s with API key %s", org, key)
+	}
+	return c
+}
@@ -0,0 +1,377 @@
+// autojoin-cli manages the registered nodes of the Autojoin API.
+//
+// Usage:
+//
+//	autojoin-cli <command> [flags] [hostnames]
+//
+// Run "autojoin-cli help" for the list of commands, and
+// "autojoin-cli <command> -help" for the flags of a command. The API key is
+// read from -key or the AUTOJOIN_API_KEY environment variable.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/m-lab/autojoin/client"
+	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/exp/slices"
+)
+
+const defaultEndpoint = "https://autojoin-dot-mlab-sandbox.appspot.com"
+
+var (
+	endpoint string
+	apiKey   string
+	filter   = &client.ListRequest{}
+	labels   = flagx.StringArray{}
+	sites    bool
+	asJSON   bool
+	async    bool
+
+	ac *client.Client
+)
+
+// command is an autojoin-cli subcommand.
+type command struct {
+	name  string
+	usage string
+	// args describes the positional arguments of the command, if any.
+	args string
+	// filters is true for commands that accept the list filter flags.
+	filters bool
+	// flags registers the flags of the command, besides the common flags.
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{
+		name:    "list",
+		usage:   "List the hostnames, or sites, of registered nodes that match the filters",
+		filters: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&sites, "sites", false, "List site names instead of hostnames")
+		},
+		run: list,
+	},
+	{
+		name:  "show",
+		usage: "Show the registration and targets of registered nodes",
+		args:  "<hostname>...",
+		run:   show,
+	},
+	{
+		name:  "delete",
+		usage: "Delete registered nodes from DNS",
+		args:  "<hostname>...",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&async, "async", false, "Delete in the background and print the operation IDs")
+		},
+		run: remove,
+	},
+	{
+		name:  "expire",
+		usage: "Expire registered nodes now, as if they had stopped registering. Requires an admin API key",
+		args:  "<hostname>...",
+		run:   expire,
+	},
+	{
+		name:    "orgs",
+		usage:   "Summarize the registered nodes of each org that match the filters",
+		filters: true,
+		run:     orgs,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: autojoin-cli <command> [flags] [hostnames]\n\nCommands:\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.usage)
+	}
+	w.Flush()
+}
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.LUTC)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage()
+		if os.Args[1] == "help" || os.Args[1] == "-help" || os.Args[1] == "-h" {
+			return
+		}
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.StringVar(&endpoint, "endpoint", defaultEndpoint, "URL of the autojoin service. Only the scheme and host are used")
+	fs.StringVar(&apiKey, "key", os.Getenv("AUTOJOIN_API_KEY"), "API key for the autojoin service")
+	fs.BoolVar(&asJSON, "json", false, "Print results as JSON")
+	if cmd.filters {
+		fs.StringVar(&filter.Org, "org", "", "Only include nodes of this organization")
+		fs.StringVar(&filter.Site, "site", "", "Only include nodes at this site, e.g. lga3269")
+		fs.StringVar(&filter.Metro, "metro", "", "Only include nodes in this metro, e.g. lga")
+		fs.StringVar(&filter.Service, "service", "", "Only include nodes of this service, e.g. ndt")
+		fs.StringVar(&filter.Type, "type", "", "Only include nodes of this machine type: physical, virtual, or cloud")
+		fs.StringVar(&filter.Country, "country", "", "Only include nodes in this country, e.g. US")
+		fs.Var(&labels, "label", "Only include nodes with this label, of the form <key>:<value>. May be repeated")
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(os.Args[2:])
+	filter.Labels = labels
+
+	if cmd.args != "" && fs.NArg() == 0 {
+		log.Fatalf("%s requires at least one hostname", cmd.name)
+	}
+	if cmd.args == "" && fs.NArg() > 0 {
+		log.Fatalf("%s does not accept arguments: %v", cmd.name, fs.Args())
+	}
+	u, err := url.Parse(endpoint)
+	rtx.Must(err, "failed to parse -endpoint")
+	ac = client.New(&url.URL{Scheme: u.Scheme, Host: u.Host}, apiKey)
+
+	if err := cmd.run(context.Background(), fs.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// list prints the hostnames, or sites, of the nodes that match the filters.
+func list(ctx context.Context, args []string) error {
+	filter.Sites = sites
+	names, err := ac.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		return printJSON(names)
+	}
+	for _, n := range names {
+		fmt.Println(n)
+	}
+	return nil
+}
+
+// node contains everything the API reports about a registered node.
+type node struct {
+	Hostname   string
+	Annotation interface{} `json:",omitempty"`
+	// Targets and Labels are empty for nodes without ports.
+	Targets []string          `json:",omitempty"`
+	Labels  map[string]string `json:",omitempty"`
+}
+
+// show prints the registration and targets of each node.
+func show(ctx context.Context, args []string) error {
+	var nodes []node
+	for _, hostname := range args {
+		name, err := host.Parse(hostname)
+		if err != nil {
+			return fmt.Errorf("invalid hostname %q: %w", hostname, err)
+		}
+		hostname = name.StringAll()
+		// Nodes are only listed by filters, so select the site of the node.
+		r := &client.ListRequest{
+			Org:          name.Org,
+			Site:         name.Site,
+			Service:      name.Service,
+			TargetLabels: []string{"site", "metro", "country", "machine_type", "uplink"},
+		}
+		hostnames, err := ac.List(ctx, r)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(hostnames, hostname) {
+			return fmt.Errorf("%s is not registered", hostname)
+		}
+		annotations, err := ac.Annotations(ctx, r)
+		if err != nil {
+			return err
+		}
+		targets, err := ac.Targets(ctx, r)
+		if err != nil {
+			return err
+		}
+		n := node{Hostname: hostname}
+		if a, ok := annotations[hostname]; ok {
+			n.Annotation = a
+		}
+		for _, t := range targets {
+			if t.Labels["machine"] != hostname {
+				continue
+			}
+			n.Targets = append(n.Targets, t.Targets...)
+			n.Labels = t.Labels
+		}
+		nodes = append(nodes, n)
+	}
+	if asJSON {
+		return printJSON(nodes)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	for i, n := range nodes {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Hostname:\t%s\n", n.Hostname)
+		fmt.Fprintf(w, "Targets:\t%s\n", strings.Join(n.Targets, " "))
+		keys := make([]string, 0, len(n.Labels))
+		for k := range n.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "Label %s:\t%s\n", k, n.Labels[k])
+		}
+		if n.Annotation == nil {
+			fmt.Fprintf(w, "Annotation:\tnone\n")
+			continue
+		}
+		b, _ := json.Marshal(n.Annotation)
+		fmt.Fprintf(w, "Annotation:\t%s\n", b)
+	}
+	return nil
+}
+
+// remove deletes each node, continuing after failures.
+func remove(ctx context.Context, args []string) error {
+	return forEach(args, func(hostname string) error {
+		op, err := ac.Delete(ctx, hostname, async)
+		if err != nil {
+			return err
+		}
+		if op != nil {
+			fmt.Printf("%s: deleting, operation %s\n", hostname, op.ID)
+			return nil
+		}
+		fmt.Printf("%s: deleted\n", hostname)
+		return nil
+	})
+}
+
+// expire expires each node, continuing after failures.
+func expire(ctx context.Context, args []string) error {
+	return forEach(args, func(hostname string) error {
+		if err := ac.Expire(ctx, hostname); err != nil {
+			return err
+		}
+		fmt.Printf("%s: expired\n", hostname)
+		return nil
+	})
+}
+
+// forEach calls f for each hostname and returns an error if any call failed.
+func forEach(hostnames []string, f func(hostname string) error) error {
+	failed := 0
+	for _, h := range hostnames {
+		if err := f(h); err != nil {
+			log.Printf("%s: %v", h, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d nodes failed", failed, len(hostnames))
+	}
+	return nil
+}
+
+// orgSummary counts the registered nodes of an org.
+type orgSummary struct {
+	Org         string
+	Nodes       int
+	Sites       int
+	Metros      int
+	Services    []string
+	Maintenance int
+
+	sites  map[string]bool
+	metros map[string]bool
+}
+
+// orgs prints a summary of the nodes of each org that match the filters.
+func orgs(ctx context.Context, args []string) error {
+	hostnames, err := ac.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+	// Maintenance is only reported by the targets of nodes with ports.
+	targets, err := ac.Targets(ctx, filter)
+	if err != nil {
+		return err
+	}
+	maintenance := map[string]bool{}
+	for _, t := range targets {
+		if t.Labels["maintenance"] == "true" {
+			maintenance[t.Labels["machine"]] = true
+		}
+	}
+	summaries := map[string]*orgSummary{}
+	for _, hostname := range hostnames {
+		name, err := host.Parse(hostname)
+		if err != nil {
+			continue
+		}
+		s, ok := summaries[name.Org]
+		if !ok {
+			s = &orgSummary{Org: name.Org, sites: map[string]bool{}, metros: map[string]bool{}}
+			summaries[name.Org] = s
+		}
+		s.Nodes++
+		s.sites[name.Site] = true
+		s.metros[name.Site[:3]] = true
+		if !slices.Contains(s.Services, name.Service) {
+			s.Services = append(s.Services, name.Service)
+		}
+		if maintenance[hostname] {
+			s.Maintenance++
+		}
+	}
+	result := make([]*orgSummary, 0, len(summaries))
+	for _, s := range summaries {
+		s.Sites = len(s.sites)
+		s.Metros = len(s.metros)
+		sort.Strings(s.Services)
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Org < result[j].Org
+	})
+	if asJSON {
+		return printJSON(result)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "ORG\tNODES\tSITES\tMETROS\tMAINTENANCE\tSERVICES")
+	for _, s := range result {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", s.Org, s.Nodes, s.Sites, s.Metros, s.Maintenance, strings.Join(s.Services, ","))
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -1,73 +1,1040 @@
+// orgadm manages the organizations of the Autojoin API.
+//
+// Usage:
+//
+//	orgadm <command> [flags]
+//
+// Run "orgadm help" for the list of commands, and "orgadm <command> -help" for
+// the flags of a command.
 package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	apikeys "cloud.google.com/go/apikeys/apiv2"
+	"cloud.google.com/go/datastore"
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/adminx/bqiface"
 	"github.com/m-lab/autojoin/internal/adminx/crmiface"
+	"github.com/m-lab/autojoin/internal/adminx/dryrun"
 	"github.com/m-lab/autojoin/internal/adminx/iamiface"
 	"github.com/m-lab/autojoin/internal/adminx/keysiface"
+	"github.com/m-lab/autojoin/internal/adminx/kmsiface"
+	"github.com/m-lab/autojoin/internal/adminx/serviceusageiface"
+	"github.com/m-lab/autojoin/internal/config"
 	"github.com/m-lab/autojoin/internal/dnsname"
 	"github.com/m-lab/autojoin/internal/dnsx"
 	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/notify"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/provision"
 	"github.com/m-lab/go/rtx"
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudkms/v1"
 	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/dns/v1"
 	iam "google.golang.org/api/iam/v1"
+	"google.golang.org/api/serviceusage/v1"
 )
 
 var (
 	org           string
 	project       string
+	domain        string
 	locateProject string
 	updateTables  bool
+	status        string
+	scopes        string
+	dsNamespace   string
+	keyExpires    time.Duration
+	keyID         string
+	keyGrace      time.Duration
+	tokenTTL      time.Duration
+	kmsKey        string
+	wifIssuer     string
+	dryRun        bool
+	repair        bool
+	deleteZones   bool
+	defFile       string
+	email         string
+	multiplier    float64
+	gcTTL         time.Duration
+	createDataset bool
+	nameServers   string
+	datasetLoc    string
+	gcInterval    time.Duration
+	smtpAddr      string
+	smtpFrom      string
+	smtpUser      string
+	verifyURL     string
+	verifyTTL     time.Duration
+
+	// plan records the changes of commands run with -dry-run.
+	plan = &dryrun.Plan{}
 )
 
-func init() {
-	flag.StringVar(&org, "org", "", "Organization name. Must match name assigned by M-Lab")
-	flag.StringVar(&project, "project", "", "GCP project to create organization resources")
-	flag.StringVar(&locateProject, "locate-project", "", "GCP project for Locate API")
-	flag.BoolVar(&updateTables, "update-tables", false, "Allow this org's service account to update table schemas")
+// command is an orgadm subcommand.
+type command struct {
+	name  string
+	usage string
+	// needsOrg is true for commands that operate on a single org.
+	needsOrg bool
+	// mutates is true for commands that change resources, which accept -dry-run.
+	mutates bool
+	// flags registers the flags of the command, besides the common flags.
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context)
+}
+
+var commands = []command{
+	{
+		name:  "bootstrap",
+		usage: "Verify the APIs and permissions of a new project, and create the resources shared by all orgs",
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&gcTTL, "gc-ttl", 3*time.Hour, "Initial time to live for DNS entries. Must match the -gc-ttl of the autojoin service")
+			fs.DurationVar(&gcInterval, "gc-interval", 30*time.Minute, "Initial interval between garbage collection runs. Must match the -gc-interval of the autojoin service")
+		},
+		mutates: true,
+		run:     bootstrap,
+	},
+	{
+		name:     "create",
+		usage:    "Create the Google Cloud resources of a new org, or report and -repair drift of an existing org",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&updateTables, "update-tables", false, "Allow this org's service account to update table schemas")
+			fs.StringVar(&kmsKey, "kms-key", "", "Cloud KMS key used to encrypt the service account key before storing it. Must match the -kms-key of the autojoin service")
+			fs.BoolVar(&repair, "repair", false, "Create the missing resources of an existing org. Existing resources are not changed")
+			fs.BoolVar(&createDataset, "create-dataset", false, "Create a BigQuery dataset for the org, writable by the org service account. May be used with existing orgs")
+			fs.StringVar(&datasetLoc, "dataset-location", "US", "Location of the BigQuery dataset created with -create-dataset")
+			fs.StringVar(&nameServers, "name-servers", "", "Comma separated name servers run by the org, e.g. 'ns1.foo.example,ns2.foo.example'. Delegates the org zone to them instead of creating a Cloud DNS zone")
+			fs.StringVar(&wifIssuer, "workload-identity-issuer", "", "OIDC issuer URI of node identities, e.g. https://sts.windows.net/<tenant>/. Sets up workload identity federation so nodes receive a federation config instead of service account keys")
+		},
+		mutates: true,
+		run:     create,
+	},
+	{
+		name:     "delete",
+		usage:    "Delete all resources of an org without registered nodes",
+		needsOrg: true,
+		mutates:  true,
+		run:      teardown,
+	},
+	{
+		name:  "list",
+		usage: "List orgs with their status and number of registered nodes",
+		run:   list,
+	},
+	{
+		name:  "orphans",
+		usage: "List org DNS zones without a backing org, e.g. of deleted or renamed orgs, and -delete them",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&deleteZones, "delete", false, "Delete the orphaned zones without registered nodes, with their zone split and DS record")
+		},
+		mutates: true,
+		run:     orphans,
+	},
+	{
+		name:     "show",
+		usage:    "Show the resources and settings of an org",
+		needsOrg: true,
+		run:      show,
+	},
+	{
+		name:     "rotate-key",
+		usage:    "Replace an API key of an org with a new key with the same scopes, expiring the old key after -grace",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&keyID, "key-id", "", "ID of the API key to replace. Defaults to the key created with the org")
+			fs.DurationVar(&keyGrace, "grace", 7*24*time.Hour, "Duration after which the replaced key is rejected")
+		},
+		mutates: true,
+		run:     rotateKey,
+	},
+	{
+		name:     "export",
+		usage:    "Print the definition of an org as YAML, e.g. for version control",
+		needsOrg: true,
+		run:      export,
+	},
+	{
+		name:  "apply",
+		usage: "Create or repair the org of a YAML definition, and save its settings",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&defFile, "f", "", "YAML definition of the org, e.g. as printed by export")
+			fs.StringVar(&kmsKey, "kms-key", "", "Cloud KMS key used to encrypt the service account key before storing it. Must match the -kms-key of the autojoin service")
+		},
+		mutates: true,
+		run:     apply,
+	},
+	{
+		name:  "onboard",
+		usage: "Create the orgs of a YAML or CSV manifest, continuing on errors, and print a summary",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&defFile, "f", "", "Manifest of org definitions, as a YAML list or a CSV file ending in .csv")
+			fs.StringVar(&kmsKey, "kms-key", "", "Cloud KMS key used to encrypt the service account key before storing it. Must match the -kms-key of the autojoin service")
+		},
+		mutates: true,
+		run:     onboard,
+	},
+	{
+		name:     "status",
+		usage:    "Set the org status to 'active' or 'suspended'",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&status, "status", "", "Org status: 'active' or 'suspended'")
+		},
+		mutates: true,
+		run:     setStatus,
+	},
+	{
+		name:     "metadata",
+		usage:    "Set the contact email or probability multiplier of the org",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&email, "email", "", "Operator contact email of the org")
+			fs.Float64Var(&multiplier, "probability-multiplier", 0, fmt.Sprintf("Factor applied to the probability requested by nodes, within (0, %d]", orgs.MaxProbabilityMultiplier))
+		},
+		mutates: true,
+		run:     setMetadata,
+	},
+	{
+		name:     "verify-email",
+		usage:    "Send a verification link to the contact email of the org. Verified emails are notified of key rotations and expired nodes",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&smtpAddr, "smtp-address", "", "SMTP server, e.g. smtp.sendgrid.net:587. The password is read from SMTP_PASSWORD")
+			fs.StringVar(&smtpFrom, "smtp-from", "", "Sender address of the email. Must match the -smtp-from of the autojoin service")
+			fs.StringVar(&smtpUser, "smtp-username", "", "SMTP username, e.g. apikey for SendGrid. Authentication is disabled if empty")
+			fs.StringVar(&verifyURL, "verify-url", "", "URL of the org/verify endpoint. Defaults to the App Engine URL of the project")
+			fs.DurationVar(&verifyTTL, "verify-ttl", 72*time.Hour, "How long the verification link is valid")
+		},
+		mutates: true,
+		run:     verifyEmail,
+	},
+	{
+		name:     "scopes",
+		usage:    "Set the scopes of the API key created with the org",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&scopes, "key-scopes", "", "Comma separated scopes of the org API key, e.g. 'register'")
+		},
+		mutates: true,
+		run:     setScopes,
+	},
+	{
+		name:     "create-key",
+		usage:    "Create an additional API key for the org",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&scopes, "key-scopes", "", "Comma separated scopes of the new key. Empty grants every scope but admin")
+			fs.DurationVar(&keyExpires, "key-expires", 0, "Duration after which the new key is rejected. Zero never expires")
+		},
+		mutates: true,
+		run:     createKey,
+	},
+	{
+		name:     "list-keys",
+		usage:    "List the additional API keys of the org with their age",
+		needsOrg: true,
+		run:      listKeys,
+	},
+	{
+		name:     "revoke-key",
+		usage:    "Revoke an additional API key of the org",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&keyID, "key-id", "", "ID of the API key to revoke")
+		},
+		mutates: true,
+		run:     revokeKey,
+	},
+	{
+		name:     "mint-token",
+		usage:    "Mint a single-use provisioning token for registering a new node of the org",
+		needsOrg: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&tokenTTL, "token-ttl", 24*time.Hour, "Duration after which the token is rejected")
+		},
+		run: mint,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: orgadm <command> [flags]\n\nCommands:\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.usage)
+	}
+	w.Flush()
 }
 
 func main() {
-	flag.Parse()
 	log.SetFlags(log.Lshortfile | log.LUTC)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage()
+		if os.Args[1] == "help" || os.Args[1] == "-help" || os.Args[1] == "-h" {
+			return
+		}
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.StringVar(&project, "project", "", "GCP project of the Autojoin API")
+	fs.StringVar(&locateProject, "locate-project", "", "GCP project for Locate API")
+	fs.StringVar(&dsNamespace, "datastore-namespace", "autojoin", "Datastore namespace of organization settings and API keys")
+	fs.StringVar(&domain, "domain", dnsname.DefaultDomain, "Base domain of node hostnames and DNS zones, e.g. measurement-lab.org")
+	if cmd.needsOrg {
+		fs.StringVar(&org, "org", "", "Organization name. Must match name assigned by M-Lab")
+	}
+	if cmd.mutates {
+		fs.BoolVar(&dryRun, "dry-run", false, "Print the changes the command would make without making them")
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(os.Args[2:])
 
-	if org == "" || project == "" {
-		log.Fatalf("-org and -project are required flags")
+	if project == "" {
+		log.Fatalf("-project is a required flag")
+	}
+	if cmd.needsOrg && org == "" {
+		log.Fatalf("-org is a required flag")
+	}
+	rtx.Must(dnsname.ValidateDomain(domain), "invalid -domain")
+	if project == "mlab-autojoin" && locateProject == "" {
+		locateProject = "mlab-ns"
+	}
+	cmd.run(context.Background())
+	if dryRun {
+		fmt.Println("Planned changes:")
+		plan.Print(os.Stdout)
 	}
+}
+
+// admin contains the clients and managers of org resources.
+type admin struct {
+	namer *adminx.Namer
+	sam   *adminx.ServiceAccountsManager
+	sm    *adminx.SecretManager
+	org   *adminx.Org
+	keys  *keys.Manager
+	orgs  *orgs.Store
+	hist  *orgs.History
 
-	ctx := context.Background()
+	iam     dryrun.IAMClient
+	closers []func() error
+}
+
+// newAdmin creates the clients and managers of org resources. Callers must
+// call Close when done. With -dry-run, changes are recorded in the plan.
+func newAdmin(ctx context.Context) *admin {
+	a := &admin{namer: adminx.NewNamer(project)}
 	sc, err := secretmanager.NewClient(ctx)
 	rtx.Must(err, "failed to create secretmanager client")
-	defer sc.Close()
-	ic, err := iam.NewService(ctx)
+	a.closers = append(a.closers, sc.Close)
+	is, err := iam.NewService(ctx)
 	rtx.Must(err, "failed to create iam service client")
-	nn := adminx.NewNamer(project)
-	crm, err := cloudresourcemanager.NewService(ctx)
+	cs, err := cloudresourcemanager.NewService(ctx)
 	rtx.Must(err, "failed to allocate new cloud resource manager client")
-	sa := adminx.NewServiceAccountsManager(iamiface.NewIAM(ic), nn)
-	rtx.Must(err, "failed to create sam")
-	sm := adminx.NewSecretManager(sc, nn, sa)
 	ds, err := dns.NewService(ctx)
 	rtx.Must(err, "failed to create new dns service")
-	d := dnsx.NewManager(dnsiface.NewCloudDNSService(ds), project, dnsname.ProjectZone(project))
 	ac, err := apikeys.NewClient(ctx)
 	rtx.Must(err, "failed to create new apikey client")
-	if project == "mlab-autojoin" && locateProject == "" {
-		locateProject = "mlab-ns"
+	a.closers = append(a.closers, ac.Close)
+
+	a.iam = iamiface.NewIAM(is)
+	var smc adminx.SecretManagerClient = sc
+	var crm adminx.CRM = crmiface.NewCRM(project, cs)
+	var dnss dnsiface.Service = dnsiface.NewCloudDNSService(ds)
+	var kc adminx.KeysClient = keysiface.NewKeys(ac)
+	if dryRun {
+		a.iam = dryrun.NewIAM(a.iam, plan)
+		smc = dryrun.NewSecretManager(smc, plan)
+		crm = dryrun.NewCRM(crm, plan)
+		dnss = dryrun.NewDNS(dnss, plan)
+		kc = dryrun.NewKeys(kc, plan)
 	}
+
+	a.sam = adminx.NewServiceAccountsManager(a.iam, a.namer)
+	a.sm = adminx.NewSecretManager(smc, a.namer, a.sam)
+	if kmsKey != "" {
+		kc, err := cloudkms.NewService(ctx)
+		rtx.Must(err, "failed to create kms service client")
+		a.sm.EncryptWith(kmsiface.NewKMS(kc), kmsKey)
+	}
+	d := dnsx.NewManager(dnss, project, dnsname.ProjectZone(project, domain))
 	// Local project names are taken from the namer.
-	k := adminx.NewAPIKeys(locateProject, keysiface.NewKeys(ac), nn)
-	defer ac.Close()
+	k := adminx.NewAPIKeys(locateProject, kc, a.namer)
+	a.org = adminx.NewOrg(project, crm, a.sam, a.sm, d, k, updateTables)
+	a.org.Domain = domain
+	if createDataset {
+		bs, err := bigquery.NewService(ctx)
+		rtx.Must(err, "failed to create bigquery service client")
+		var bq adminx.BigQuery = bqiface.NewBigQuery(project, bs)
+		if dryRun {
+			bq = dryrun.NewBigQuery(bq, plan)
+		}
+		a.org.WithDataset(bq, datasetLoc)
+	}
+	dc, closer := newDatastore(ctx)
+	a.closers = append(a.closers, closer)
+	a.keys = keys.NewManager(k, keys.NewStore(dc, dsNamespace))
+	a.orgs = orgs.NewStore(dc, dsNamespace)
+	a.hist = orgs.NewHistory(dc, dsNamespace)
+	return a
+}
+
+// Close closes all clients.
+func (a *admin) Close() {
+	for _, c := range a.closers {
+		c()
+	}
+}
 
-	o := adminx.NewOrg(project, crmiface.NewCRM(project, crm), sa, sm, d, k, updateTables)
-	key, err := o.Setup(ctx, org)
+// create sets up the Google Cloud resources of a new org. For existing orgs,
+// create reports the resources that exist or are missing, and with -repair
+// creates the missing resources.
+func create(ctx context.Context) {
+	a := newAdmin(ctx)
+	defer a.Close()
+	servers := orgNameServers(ctx, a)
+	if len(servers) > 0 {
+		a.org.WithNameServers(servers)
+	}
+	drift, err := a.org.Check(ctx, org)
+	rtx.Must(err, "failed to check organization: "+org)
+	if !drift[0].Missing {
+		// The service account exists, so the org was created before.
+		checkDrift(ctx, a, drift)
+		if nameServers != "" && repair {
+			saveNameServers(ctx, a, servers)
+		}
+		if createDataset {
+			sa, err := a.sam.GetServiceAccount(ctx, org)
+			rtx.Must(err, "failed to get service account: "+org)
+			rtx.Must(a.org.SetupDataset(ctx, org, sa), "failed to set up dataset: "+org)
+			log.Println("Dataset okay - org:", org, "dataset:", a.namer.GetDatasetID(org))
+		}
+		return
+	}
+	if wifIssuer != "" {
+		a.org.WithWorkloadIdentity(a.iam, wifIssuer)
+	}
+	key, err := a.org.Setup(ctx, org)
 	rtx.Must(err, "failed to set up new organization: "+org)
+	if updateTables {
+		settings, err := a.orgs.Get(ctx, org)
+		rtx.Must(err, "failed to load org settings: "+org)
+		settings.UpdateTables = true
+		rtx.Must(a.orgs.Set(ctx, org, settings), "failed to save org settings: "+org)
+	}
+	if wifIssuer != "" {
+		enableWorkloadIdentity(ctx, a)
+	}
+	if nameServers != "" {
+		saveNameServers(ctx, a, servers)
+	}
 	log.Println("Setup okay - org:", org, "key:", key)
 }
+
+// orgNameServers returns the name servers of -name-servers, or those saved
+// in the settings of an org with a delegated zone, exiting if they are
+// invalid.
+func orgNameServers(ctx context.Context, a *admin) []string {
+	if nameServers == "" {
+		settings, err := a.orgs.Get(ctx, org)
+		rtx.Must(err, "failed to load org settings: "+org)
+		return settings.NameServers
+	}
+	servers, err := dnsx.NameServers(strings.Split(nameServers, ","), dnsname.OrgDNS(org, project, domain))
+	rtx.Must(err, "invalid -name-servers")
+	return servers
+}
+
+// saveNameServers saves the name servers of the delegated org zone in the org
+// settings, so later commands check the delegation.
+func saveNameServers(ctx context.Context, a *admin, servers []string) {
+	settings, err := a.orgs.Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	settings.NameServers = servers
+	rtx.Must(a.orgs.Set(ctx, org, settings), "failed to save org settings: "+org)
+	log.Println("Delegation okay - org:", org, "name servers:", strings.Join(servers, ", "))
+}
+
+// checkDrift prints the drift of an existing org, and repairs it with -repair.
+func checkDrift(ctx context.Context, a *admin, drift []adminx.Drift) {
+	missing := 0
+	for _, d := range drift {
+		if d.Missing {
+			missing++
+		}
+	}
+	if repair && missing > 0 {
+		var err error
+		drift, err = a.org.Repair(ctx, org, drift)
+		rtx.Must(err, "failed to repair organization: "+org)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tNAME\tSTATE\tDETAIL")
+	for _, d := range drift {
+		state := "exists"
+		switch {
+		case d.Repaired:
+			state = "created"
+		case d.Missing:
+			state = "missing"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Resource, d.Name, state, d.Detail)
+	}
+	w.Flush()
+	switch {
+	case missing == 0:
+		log.Println("Org exists with no drift - org:", org)
+	case repair:
+		log.Println("Repair okay - org:", org, "created:", missing)
+	default:
+		log.Println("Org exists with", missing, "missing resources; run with -repair to create them - org:", org)
+	}
+}
+
+// export prints the definition of the org as YAML.
+func export(ctx context.Context) {
+	dc, closer := newDatastore(ctx)
+	defer closer()
+	settings, err := orgs.NewStore(dc, dsNamespace).Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	rtx.Must(orgs.NewDefinition(org, settings).Write(os.Stdout), "failed to write definition: "+org)
+}
+
+// apply creates the org of the -f definition, or creates the missing
+// resources of an existing org, and saves the settings of the definition.
+func apply(ctx context.Context) {
+	if defFile == "" {
+		log.Fatalf("-f is a required flag")
+	}
+	f, err := os.Open(defFile)
+	rtx.Must(err, "failed to open definition: "+defFile)
+	d, err := orgs.ReadDefinition(f)
+	f.Close()
+	rtx.Must(err, "failed to read definition: "+defFile)
+	org = d.Name
+	updateTables = d.UpdateTables
+
+	a := newAdmin(ctx)
+	defer a.Close()
+	drift, err := a.org.Check(ctx, org)
+	rtx.Must(err, "failed to check organization: "+org)
+	if drift[0].Missing {
+		key, err := a.org.Setup(ctx, org)
+		rtx.Must(err, "failed to set up new organization: "+org)
+		log.Println("Setup okay - org:", org, "key:", key)
+	} else {
+		repair = true
+		checkDrift(ctx, a, drift)
+	}
+	rtx.Must(saveDefinition(ctx, a, d), "failed to save org settings: "+org)
+	log.Println("Apply okay - org:", org)
+}
+
+// saveDefinition saves the settings of the definition.
+func saveDefinition(ctx context.Context, a *admin, d *orgs.Definition) error {
+	before, err := a.orgs.Get(ctx, d.Name)
+	if err != nil {
+		return err
+	}
+	settings := d.Settings(before)
+	if err := a.orgs.Set(ctx, d.Name, settings); err != nil {
+		return err
+	}
+	return addHistory(ctx, a.hist, d.Name, before, settings)
+}
+
+// addHistory records a change of the probability multiplier of the org, if
+// any, made by the current user.
+func addHistory(ctx context.Context, h *orgs.History, org string, before, after orgs.Settings) error {
+	if before.ProbabilityMultiplier == after.ProbabilityMultiplier {
+		return nil
+	}
+	return h.Add(ctx, org, orgs.MultiplierChange{
+		Old:   before.ProbabilityMultiplier,
+		New:   after.ProbabilityMultiplier,
+		Actor: "user " + os.Getenv("USER"),
+		Time:  time.Now().UTC(),
+	})
+}
+
+// onboard creates the orgs of the -f manifest. Errors are reported in the
+// summary and do not stop the remaining orgs.
+func onboard(ctx context.Context) {
+	if defFile == "" {
+		log.Fatalf("-f is a required flag")
+	}
+	f, err := os.Open(defFile)
+	rtx.Must(err, "failed to open manifest: "+defFile)
+	var defs []*orgs.Definition
+	if strings.HasSuffix(defFile, ".csv") {
+		defs, err = orgs.ReadDefinitionsCSV(f)
+	} else {
+		defs, err = orgs.ReadDefinitions(f)
+	}
+	f.Close()
+	rtx.Must(err, "failed to read manifest: "+defFile)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tRESULT\tCREATED\tAPI KEY")
+	failed := 0
+	for _, d := range defs {
+		created, key, err := onboardOrg(ctx, d)
+		result := "ok"
+		if err != nil {
+			failed++
+			result = "error: " + err.Error()
+			key = "-"
+		}
+		if len(created) == 0 {
+			created = []string{"-"}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Name, result, strings.Join(created, ", "), key)
+	}
+	w.Flush()
+	if failed > 0 {
+		log.Fatalf("Onboard failed for %d of %d orgs", failed, len(defs))
+	}
+	log.Println("Onboard okay - orgs:", len(defs))
+}
+
+// onboardOrg sets up the org of the definition and saves its settings. It
+// returns the kinds of resources that were missing before setup, and the org
+// API key.
+func onboardOrg(ctx context.Context, d *orgs.Definition) ([]string, string, error) {
+	updateTables = d.UpdateTables
+	a := newAdmin(ctx)
+	defer a.Close()
+	drift, err := a.org.Check(ctx, d.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	created := []string{}
+	for _, dr := range drift {
+		if dr.Missing && !slices.Contains(created, dr.Resource) {
+			created = append(created, dr.Resource)
+		}
+	}
+	key, err := a.org.Setup(ctx, d.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, key, saveDefinition(ctx, a, d)
+}
+
+// teardown deletes the Google Cloud resources, API keys, and settings of the
+// org. Orgs with registered nodes are not deleted.
+func teardown(ctx context.Context) {
+	a := newAdmin(ctx)
+	defer a.Close()
+	err := a.org.Teardown(ctx, org)
+	if errors.Is(err, adminx.ErrOrgHasNodes) {
+		log.Fatalf("Delete the nodes of org %s first: %v", org, err)
+	}
+	rtx.Must(err, "failed to delete organization: "+org)
+	l, err := a.keys.List(ctx, org)
+	rtx.Must(err, "failed to list api keys: "+org)
+	for _, k := range l {
+		if k.Created.IsZero() || !k.Revoked.IsZero() {
+			// The key created with the org was deleted by Teardown, and
+			// revoked keys were deleted already, e.g. by an earlier run.
+			continue
+		}
+		rtx.Must(a.keys.Revoke(ctx, org, k.ID), "failed to revoke api key: "+k.ID)
+	}
+	rtx.Must(a.orgs.Delete(ctx, org), "failed to delete org settings: "+org)
+	log.Println("Delete okay - org:", org)
+}
+
+// bootstrap prepares a new project for the Autojoin API. It verifies that the
+// required APIs are enabled and the caller may create orgs, then creates the
+// project DNS zone and the runtime config of the Datastore namespace.
+func bootstrap(ctx context.Context) {
+	su, err := serviceusage.NewService(ctx)
+	rtx.Must(err, "failed to create service usage client")
+	cs, err := cloudresourcemanager.NewService(ctx)
+	rtx.Must(err, "failed to allocate new cloud resource manager client")
+	ds, err := dns.NewService(ctx)
+	rtx.Must(err, "failed to create new dns service")
+	var dnss dnsiface.Service = dnsiface.NewCloudDNSService(ds)
+	if dryRun {
+		dnss = dryrun.NewDNS(dnss, plan)
+	}
+	d := dnsx.NewManager(dnss, project, dnsname.ProjectZone(project, domain))
+	b := adminx.NewBootstrap(project, crmiface.NewCRM(project, cs), serviceusageiface.NewServiceUsage(project, su), d)
+	b.Domain = domain
+
+	services, err := b.MissingServices(ctx)
+	rtx.Must(err, "failed to list enabled services: "+project)
+	if len(services) > 0 {
+		log.Fatalf("Required APIs are not enabled; enable with: gcloud services enable --project %s %s",
+			project, strings.Join(services, " "))
+	}
+	perms, err := b.MissingPermissions(ctx)
+	rtx.Must(err, "failed to test permissions: "+project)
+	if len(perms) > 0 {
+		log.Fatalf("Missing permissions on project %s: %s", project, strings.Join(perms, ", "))
+	}
+
+	zone, err := b.RegisterProjectZone(ctx)
+	rtx.Must(err, "failed to register project zone: "+project)
+	log.Println("Zone okay - zone:", zone.Name, "dns:", dnsname.ProjectDNS(project, domain))
+	log.Println("Delegate", dnsname.ProjectDNS(project, domain), "in its parent zone to:", strings.Join(zone.NameServers, " "))
+
+	dc, closer := newDatastore(ctx)
+	defer closer()
+	m := config.NewManager(dc, dsNamespace, config.Config{GCTTL: gcTTL, GCInterval: gcInterval}, nil)
+	saved, err := m.Initialize(ctx)
+	rtx.Must(err, "failed to initialize datastore namespace: "+dsNamespace)
+	log.Println("Bootstrap okay - project:", project, "namespace:", dsNamespace, "initialized:", saved)
+}
+
+// list prints every org of the project with its status and node count.
+func list(ctx context.Context) {
+	a := newAdmin(ctx)
+	defer a.Close()
+	l, err := a.sam.ListOrgs(ctx)
+	rtx.Must(err, "failed to list organizations")
+	sort.Strings(l)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tSTATUS\tNODES")
+	for _, o := range l {
+		settings, err := a.orgs.Get(ctx, o)
+		rtx.Must(err, "failed to load org settings: "+o)
+		n, err := a.org.Nodes(ctx, o)
+		nodes := fmt.Sprint(n)
+		if err != nil {
+			nodes = "unknown: " + err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", o, orgStatus(settings), nodes)
+	}
+	w.Flush()
+}
+
+// orphans prints the org zones of the project without a backing org, i.e.
+// neither saved settings nor a service account, and deletes them with -delete.
+func orphans(ctx context.Context) {
+	a := newAdmin(ctx)
+	defer a.Close()
+	saved, err := a.orgs.List(ctx)
+	rtx.Must(err, "failed to list organization settings")
+	accounts, err := a.sam.ListOrgs(ctx)
+	rtx.Must(err, "failed to list organizations")
+	zones, err := a.org.OrphanedZones(ctx, append(saved, accounts...))
+	rtx.Must(err, "failed to list orphaned zones")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tORG\tNODES\tRESULT")
+	failed := 0
+	for _, z := range zones {
+		result := "orphaned"
+		if deleteZones {
+			result = "deleted"
+			if err := a.org.DeleteOrphanedZone(ctx, z); err != nil {
+				result = "error: " + err.Error()
+				failed++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", z.Zone.Name, z.Org, z.Nodes, result)
+	}
+	w.Flush()
+	if failed > 0 {
+		log.Fatalf("Failed to delete %d of %d orphaned zones", failed, len(zones))
+	}
+	log.Println("Orphans okay - project:", project, "zones:", len(zones))
+}
+
+// show prints the resources and settings of the org.
+func show(ctx context.Context) {
+	a := newAdmin(ctx)
+	defer a.Close()
+	settings, err := a.orgs.Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Org:\t%s\n", org)
+	fmt.Fprintf(w, "Status:\t%s\n", orgStatus(settings))
+	fmt.Fprintf(w, "Settings:\t%+v\n", settings)
+	fmt.Fprintf(w, "Service account:\t%s\n", a.namer.GetServiceAccountEmail(org))
+	sakeys, err := a.sam.ListKeys(ctx, org)
+	if err != nil {
+		fmt.Fprintf(w, "Service account keys:\tunknown: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "Service account keys:\t%d\n", len(sakeys))
+	}
+	age, err := adminx.NewRotator(a.sm, 0).Age(ctx, org)
+	if err != nil {
+		fmt.Fprintf(w, "Secret:\t%s (unknown key age: %v)\n", a.namer.GetSecretName(org), err)
+	} else {
+		fmt.Fprintf(w, "Secret:\t%s (key age %s)\n", a.namer.GetSecretName(org), age.Round(time.Second))
+	}
+	if len(settings.NameServers) > 0 {
+		fmt.Fprintf(w, "DNS zone:\t%s (delegated to %s)\n", dnsname.OrgDNS(org, project, domain), strings.Join(settings.NameServers, ", "))
+	} else {
+		fmt.Fprintf(w, "DNS zone:\t%s (%s)\n", dnsname.OrgZone(org, project, domain), dnsname.OrgDNS(org, project, domain))
+		problems, err := a.org.CheckDNSSEC(ctx, org)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "DNSSEC:\tunknown: %v\n", err)
+		case len(problems) > 0:
+			fmt.Fprintf(w, "DNSSEC:\t%s\n", strings.Join(problems, "; "))
+		default:
+			fmt.Fprintf(w, "DNSSEC:\tok\n")
+		}
+	}
+	n, err := a.org.Nodes(ctx, org)
+	if err != nil {
+		fmt.Fprintf(w, "Nodes:\tunknown: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "Nodes:\t%d\n", n)
+	}
+	fmt.Fprintf(w, "API key:\t%s\n", a.namer.GetAPIKeyID(org))
+	l, err := a.keys.List(ctx, org)
+	rtx.Must(err, "failed to list api keys: "+org)
+	for _, k := range l {
+		fmt.Fprintf(w, "API key:\t%s scopes: %v created: %s expires: %s revoked: %s\n",
+			k.ID, k.Scopes, formatTime(k.Created), formatTime(k.ExpiresAt), formatTime(k.Revoked))
+	}
+}
+
+// rotateKey replaces an API key of the org, expiring the old key after the
+// grace period so nodes can be updated with the new key.
+func rotateKey(ctx context.Context) {
+	if keyGrace < 0 {
+		log.Fatalf("-grace must not be negative")
+	}
+	if keyID == "" {
+		keyID = adminx.NewNamer(project).GetAPIKeyID(org)
+	}
+	m := newKeyManager(ctx)
+	k, key, err := m.Rotate(ctx, org, keyID, keyGrace)
+	if k != nil {
+		log.Println("Created - org:", org, "id:", k.ID, "key:", key)
+	}
+	rtx.Must(err, "failed to rotate api key: "+keyID)
+	log.Println("Rotate okay - org:", org, "old id:", keyID, "expires:", time.Now().Add(keyGrace).UTC())
+}
+
+// setStatus changes the status of the org in the Datastore of the project.
+// Registrations from suspended orgs are rejected by the Autojoin API.
+func setStatus(ctx context.Context) {
+	if status != orgs.StatusActive && status != orgs.StatusSuspended {
+		log.Fatalf("-status must be %q or %q", orgs.StatusActive, orgs.StatusSuspended)
+	}
+	dc, closer := newDatastore(ctx)
+	defer closer()
+	s := orgs.NewStore(dc, dsNamespace)
+	settings, err := s.Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	settings.Status = status
+	rtx.Must(s.Set(ctx, org, settings), "failed to save org settings: "+org)
+	log.Println("Status okay - org:", org, "status:", status)
+}
+
+// setMetadata changes the contact email or probability multiplier of the org
+// in the Datastore of the project, and logs the changes.
+func setMetadata(ctx context.Context) {
+	if email == "" && multiplier == 0 {
+		log.Fatalf("-email or -probability-multiplier is required")
+	}
+	rtx.Must(orgs.ValidateEmail(email), "invalid -email")
+	if multiplier != 0 {
+		rtx.Must(orgs.ValidateProbabilityMultiplier(multiplier), "invalid -probability-multiplier")
+	}
+	dc, closer := newDatastore(ctx)
+	defer closer()
+	s := orgs.NewStore(dc, dsNamespace)
+	before, err := s.Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	settings := before
+	if email != "" {
+		settings.Email = email
+	}
+	if multiplier != 0 {
+		settings.ProbabilityMultiplier = multiplier
+	}
+	rtx.Must(s.Set(ctx, org, settings), "failed to save org settings: "+org)
+	err = addHistory(ctx, orgs.NewHistory(dc, dsNamespace), org, before, settings)
+	rtx.Must(err, "failed to save org history: "+org)
+	log.Printf("AUDIT organization %s by %s: %s", org, os.Getenv("USER"), strings.Join(orgs.Diff(before, settings), "; "))
+}
+
+// verifyEmail sends a verification link to the contact email of the org, e.g.
+// for orgs created before the autojoin service sent emails.
+func verifyEmail(ctx context.Context) {
+	if smtpAddr == "" || smtpFrom == "" {
+		log.Fatalf("-smtp-address and -smtp-from are required")
+	}
+	if verifyURL == "" {
+		verifyURL = "https://autojoin-dot-" + project + ".appspot.com/autojoin/v0/org/verify"
+	}
+	dc, closer := newDatastore(ctx)
+	defer closer()
+	s := orgs.NewStore(dc, dsNamespace)
+	settings, err := s.Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	if settings.Email == "" {
+		log.Fatalf("org %s has no email; set one with metadata -email", org)
+	}
+	var sender notify.Sender = notify.NewSMTP(smtpAddr, smtpFrom, smtpUser, os.Getenv("SMTP_PASSWORD"))
+	if dryRun {
+		sender = dryrun.NewSender(plan)
+	}
+	n := notify.NewNotifier(sender, dc, dsNamespace, s, verifyURL, verifyTTL)
+	rtx.Must(n.SendVerification(ctx, org, settings.Email), "failed to send verification: "+org)
+	log.Println("Verification sent - org:", org, "email:", settings.Email)
+}
+
+// enableWorkloadIdentity saves the workload identity provider of the org in
+// its settings, so that registrations return a federation config to nodes.
+func enableWorkloadIdentity(ctx context.Context, a *admin) {
+	provider, err := a.org.WorkloadIdentityProvider(ctx, org)
+	rtx.Must(err, "failed to get workload identity provider: "+org)
+	settings, err := a.orgs.Get(ctx, org)
+	rtx.Must(err, "failed to load org settings: "+org)
+	settings.WorkloadIdentityProvider = provider
+	settings.WorkloadIdentityServiceAccount = a.namer.GetServiceAccountEmail(org)
+	rtx.Must(a.orgs.Set(ctx, org, settings), "failed to save org settings: "+org)
+	log.Println("Workload identity okay - org:", org, "provider:", provider)
+}
+
+// setScopes changes the scopes of the org API key in the Datastore of the
+// project. Requests with the key to endpoints outside its scopes are rejected
+// by the Autojoin API.
+func setScopes(ctx context.Context) {
+	if scopes == "" {
+		log.Fatalf("-key-scopes is a required flag")
+	}
+	s := parseScopes()
+	dc, closer := newDatastore(ctx)
+	defer closer()
+	id := adminx.NewNamer(project).GetAPIKeyID(org)
+	err := keys.NewStore(dc, dsNamespace).Put(ctx, id, &keys.Key{Org: org, Scopes: s})
+	rtx.Must(err, "failed to save api key scopes: "+org)
+	log.Println("Scopes okay - org:", org, "key:", id, "scopes:", s)
+}
+
+// createKey creates an additional API key for the org.
+func createKey(ctx context.Context) {
+	s := parseScopes()
+	expires := time.Time{}
+	if keyExpires > 0 {
+		expires = time.Now().Add(keyExpires).UTC()
+	}
+	k, key, err := newKeyManager(ctx).Create(ctx, org, s, expires)
+	rtx.Must(err, "failed to create api key: "+org)
+	log.Println("Create okay - org:", org, "id:", k.ID, "key:", key)
+}
+
+// listKeys prints the additional API keys of the org.
+func listKeys(ctx context.Context) {
+	l, err := newKeyManager(ctx).List(ctx, org)
+	rtx.Must(err, "failed to list api keys: "+org)
+	for _, k := range l {
+		log.Println("id:", k.ID, "scopes:", k.Scopes, "age:", time.Since(k.Created).Round(time.Second),
+			"expires:", k.ExpiresAt, "revoked:", k.Revoked)
+	}
+}
+
+// revokeKey revokes an additional API key of the org.
+func revokeKey(ctx context.Context) {
+	if keyID == "" {
+		log.Fatalf("-key-id is a required flag")
+	}
+	rtx.Must(newKeyManager(ctx).Revoke(ctx, org, keyID), "failed to revoke api key: "+keyID)
+	log.Println("Revoke okay - org:", org, "id:", keyID)
+}
+
+// newKeyManager creates a keys.Manager for the API keys of the project. The
+// clients are closed when the process exits.
+func newKeyManager(ctx context.Context) *keys.Manager {
+	ac, err := apikeys.NewClient(ctx)
+	rtx.Must(err, "failed to create new apikey client")
+	var kc adminx.KeysClient = keysiface.NewKeys(ac)
+	if dryRun {
+		kc = dryrun.NewKeys(kc, plan)
+	}
+	dc, _ := newDatastore(ctx)
+	ak := adminx.NewAPIKeys(locateProject, kc, adminx.NewNamer(project))
+	return keys.NewManager(ak, keys.NewStore(dc, dsNamespace))
+}
+
+// newDatastore creates a Datastore client for the project and returns it with
+// its Close function. With -dry-run, changes are recorded in the plan.
+func newDatastore(ctx context.Context) (dryrun.DatastoreClient, func() error) {
+	dc, err := datastore.NewClient(ctx, project)
+	rtx.Must(err, "failed to create datastore client")
+	if dryRun {
+		return dryrun.NewDatastore(dc, plan), dc.Close
+	}
+	return dc, dc.Close
+}
+
+// mint creates a single-use provisioning token for the org. A new node may
+// register once with the token, in exchange for its own API key.
+func mint(ctx context.Context) {
+	if tokenTTL <= 0 {
+		log.Fatalf("-token-ttl must be positive")
+	}
+	dc, err := datastore.NewClient(ctx, project)
+	rtx.Must(err, "failed to create datastore client")
+	defer dc.Close()
+	token, err := provision.NewStore(provision.NewDatastore(dc), dsNamespace).Mint(ctx, org, tokenTTL)
+	rtx.Must(err, "failed to mint provisioning token: "+org)
+	log.Println("Mint okay - org:", org, "token:", token, "expires:", time.Now().Add(tokenTTL).UTC())
+}
+
+// parseScopes returns the scopes of -key-scopes, exiting if any are invalid.
+func parseScopes() []string {
+	s := []string{}
+	if scopes != "" {
+		s = strings.Split(scopes, ",")
+	}
+	for _, scope := range s {
+		if !keys.ValidScope(scope) {
+			log.Fatalf("-key-scopes must be a subset of %v", keys.AllScopes)
+		}
+	}
+	return s
+}
+
+func orgStatus(settings orgs.Settings) string {
+	if settings.Status == "" {
+		return orgs.StatusActive
+	}
+	return settings.Status
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
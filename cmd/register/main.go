@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
@@ -14,10 +13,13 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/client"
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/memoryless"
 	"github.com/m-lab/go/rtx"
@@ -29,33 +31,56 @@ const (
 	heartbeatFilename      = "registration.json"
 	annotationFilename     = "annotation.json"
 	serviceAccountFilename = "service-account-autojoin.json"
+	accessTokenFilename    = "access-token-autojoin.json"
 	hostnameFilename       = "hostname"
+
+	// minRefreshWait limits retries of failed access token refreshes.
+	minRefreshWait = 10 * time.Second
 )
 
 var (
-	endpoint    = flag.String("endpoint", registerEndpoint, "Endpoint of the autojoin service")
+	endpoint    = flag.String("endpoint", registerEndpoint, "Endpoint of the autojoin service. Only the scheme and host are used")
+	tokenURL    = flag.String("token-endpoint", "", "Deprecated: access tokens are refreshed at the host of -endpoint")
 	apiKey      = flag.String("key", "", "API key for the autojoin service")
 	service     = flag.String("service", "ndt", "Service name to register with the autojoin service")
 	org         = flag.String("organization", "", "Organization to register with the autojoin service")
 	iata        = flagx.StringFile{}
 	ipv4        = flagx.StringFile{}
 	ipv6        = flagx.StringFile{}
-	machineType = flag.String("type", "", "The type of machine: physical or virtual")
-	uplink      = flag.String("uplink", "", "The speed of the uplink e.g., 1g, 10g, etc.")
+	machineType = flag.String("type", "", "The type of machine: physical, virtual, or cloud")
+	provider    = flag.String("provider", "", "The cloud provider of this node, e.g. gcp, for -type=cloud")
+	region      = flag.String("region", "", "The cloud region of this node, e.g. us-east1, for -type=cloud")
+	uplink      = flag.String("uplink", "", "The speed of the uplink, one of 100m, 1g, 2.5g, 10g, 25g, 40g, or 100g")
 	interval    = flag.Duration("interval.expected", 1*time.Hour, "Expected registration interval")
 	intervalMin = flag.Duration("interval.min", 55*time.Minute, "Minimum registration interval")
 	intervalMax = flag.Duration("interval.max", 65*time.Minute, "Maximum registration interval")
 	outputPath  = flag.String("output", "", "Output folder")
+	dnsTTL      = flag.Int64("dns-ttl", 0, "TTL in seconds of the DNS records of this node. Zero uses the TTL of the organization")
+	oidcToken   = flag.String("oidc-token-file", "", "File containing the OIDC identity token of this node, for organizations using workload identity federation")
 	siteProb    = flagx.StringFile{}
 	defaultProb = 1.0
 	ports       = flagx.StringArray{}
+	services    = flagx.StringArray{}
+	labels      = flagx.StringArray{}
+	location    = flag.String("location", "", "Location of this node as <lat>,<lon>, if known better than the geolocation of its address. It must be near the IATA airport")
+	city        = flag.String("city", "", "City of this node, used with -location")
+	nodeLoc     *client.Location
 
 	hcAddr          = flag.String("healthcheck-addr", "localhost:8001", "Address to serve the /ready endpoint on")
+	ac              *client.Client
 	registerSuccess atomic.Bool
+
+	// Access tokens are written by both register and refreshTokens.
+	tokenMu       sync.Mutex
+	tokenHostname string
+	tokenExpiry   time.Time
+	refreshOnce   sync.Once
 )
 
 func init() {
 	flag.Var(&ports, "ports", "Ports to monitor for this service")
+	flag.Var(&services, "services", "Other services of this node, each registered with an alias of the hostname")
+	flag.Var(&labels, "label", "Node labels of the form <key>=<value>, e.g. rack=r1")
 	flag.Var(&iata, "iata", "IATA code to register with the autojoin service")
 	flag.Var(&ipv4, "ipv4", "IPv4 address to register with the autojoin service")
 	flag.Var(&ipv6, "ipv6", "IPv6 address to register with the autojoin service")
@@ -94,6 +119,22 @@ func main() {
 
 	siteProb.Value = fmt.Sprintf("%f", probability)
 
+	if *location != "" {
+		nodeLoc, err = parseLocation(*location, *city)
+		rtx.Must(err, "Failed to parse -location")
+	}
+
+	u, err := url.Parse(*endpoint)
+	rtx.Must(err, "Failed to parse autojoin service URL")
+	if *tokenURL != "" {
+		log.Println("-token-endpoint is deprecated and ignored")
+	}
+	ac = client.New(&url.URL{Scheme: u.Scheme, Host: u.Host}, *apiKey)
+	ac.HTTP = ipv4HTTPClient()
+	ac.Warn = func(w v0.Warning) {
+		log.Printf("Warning from autojoin service: %s", w.Message)
+	}
+
 	// Set up health server.
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ready", Ready)
@@ -120,46 +161,35 @@ func main() {
 // autojoin API and will just touch the output files' last-modified time.
 func register() {
 	// Make a HTTP call to the autojoin service to register this node.
-	registerURL, err := url.Parse(*endpoint)
-	rtx.Must(err, "Failed to parse autojoin service URL")
-	q := registerURL.Query()
-	q.Add("api_key", *apiKey)
-	q.Add("service", *service)
-	q.Add("organization", *org)
-	q.Add("iata", iata.Value)
-	q.Add("ipv4", ipv4.Value)
-	q.Add("ipv6", ipv6.Value)
-	q.Add("type", *machineType)
-	q.Add("uplink", *uplink)
-	q.Add("probability", siteProb.Value)
-	for _, port := range ports {
-		q.Add("ports", port)
-	}
-	registerURL.RawQuery = q.Encode()
-
-	log.Printf("Registering with %s", registerURL)
-	resp, err := ipv4HTTPClient().Post(registerURL.String(), "application/json", nil)
-	rtx.Must(err, "POST autojoin/v0/node/register failed")
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		panic("Failed to register with autojoin service")
+	probability, err := strconv.ParseFloat(siteProb.Value, 64)
+	rtx.Must(err, "Failed to parse probability")
+	req := &client.RegisterRequest{
+		Service:      *service,
+		Services:     services,
+		Organization: *org,
+		IATA:         iata.Value,
+		IPv4:         ipv4.Value,
+		IPv6:         ipv6.Value,
+		Type:         *machineType,
+		Provider:     *provider,
+		Region:       *region,
+		Uplink:       *uplink,
+		Probability:  &probability,
+		Ports:        ports,
+		Labels:       labels,
+		DNSTTL:       *dnsTTL,
+		Location:     nodeLoc,
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	rtx.Must(err, "Failed to read response body")
-
-	var r v0.RegisterResponse
-	json.Unmarshal(body, &r)
-	if r.Error != nil {
-		panic(r.Error.Title)
-	}
+	log.Printf("Registering with %s", ac.BaseURL)
+	reg, err := ac.Register(context.Background(), req)
+	rtx.Must(err, "Failed to register with autojoin service")
 
-	heartbeat := map[string]v2.Registration{r.Registration.Hostname: *r.Registration.Heartbeat}
-	annotation := map[string]v0.ServerAnnotation{r.Registration.Hostname: *r.Registration.Annotation}
+	heartbeat := map[string]v2.Registration{reg.Hostname: *reg.Heartbeat}
+	annotation := map[string]v0.ServerAnnotation{reg.Hostname: *reg.Annotation}
 
 	// Write the hostname to a file.
-	err = os.WriteFile(path.Join(*outputPath, hostnameFilename), []byte(r.Registration.Hostname), 0644)
+	err = os.WriteFile(path.Join(*outputPath, hostnameFilename), []byte(reg.Hostname), 0644)
 	rtx.Must(err, "Failed to write hostname to file")
 
 	// Marshall and write the heartbeat and annotation config files.
@@ -173,19 +203,109 @@ func register() {
 	err = os.WriteFile(path.Join(*outputPath, annotationFilename), annotationJSON, 0644)
 	rtx.Must(err, "Failed to write annotation file")
 
-	if r.Registration.Credentials == nil {
-		log.Fatalf("Registration credentials are nil:\n%s", body)
+	if reg.Credentials == nil {
+		log.Fatalf("Registration credentials are nil for %s", reg.Hostname)
+	}
+	if reg.Credentials.WorkloadIdentity != nil {
+		// Federation config that exchanges the node OIDC token for credentials.
+		writeWorkloadIdentity(reg.Credentials.WorkloadIdentity)
+	} else if reg.Credentials.AccessToken != "" {
+		// Short-lived access token, refreshed before it expires.
+		writeAccessToken(reg.Hostname, reg.Credentials)
+		refreshOnce.Do(func() { go refreshTokens() })
+	} else {
+		// Service account credentials.
+		key, err := base64.StdEncoding.DecodeString(reg.Credentials.ServiceAccountKey)
+		rtx.Must(err, "Failed to decode service account key")
+		err = os.WriteFile(path.Join(*outputPath, serviceAccountFilename), key, 0644)
+		rtx.Must(err, "Failed to write annotation file")
 	}
-	// Service account credentials.
-	key, err := base64.StdEncoding.DecodeString(r.Registration.Credentials.ServiceAccountKey)
-	rtx.Must(err, "Failed to decode service account key")
-	err = os.WriteFile(path.Join(*outputPath, serviceAccountFilename), key, 0644)
-	rtx.Must(err, "Failed to write annotation file")
 
-	log.Printf("Registration successful with hostname: %s", r.Registration.Hostname)
+	log.Printf("Registration successful with hostname: %s", reg.Hostname)
+	for _, alias := range reg.Aliases {
+		log.Printf("Registered alias: %s", alias)
+	}
 	registerSuccess.Store(true)
 }
 
+// writeWorkloadIdentity saves an external account credentials file for the
+// given federation parameters, in place of the service account key. Clients
+// using the file read the node OIDC token from -oidc-token-file.
+func writeWorkloadIdentity(wi *v0.WorkloadIdentity) {
+	if *oidcToken == "" {
+		log.Fatalf("-oidc-token-file is required for workload identity federation")
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"type":               "external_account",
+		"audience":           wi.Audience,
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url":          "https://sts.googleapis.com/v1/token",
+		"service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" +
+			wi.ServiceAccount + ":generateAccessToken",
+		"credential_source": map[string]string{
+			"file": *oidcToken,
+		},
+	})
+	rtx.Must(err, "Failed to marshal workload identity config")
+	err = os.WriteFile(path.Join(*outputPath, serviceAccountFilename), b, 0644)
+	rtx.Must(err, "Failed to write workload identity config")
+}
+
+// writeAccessToken saves the access token of the given credentials. The file
+// uses the JSON encoding of golang.org/x/oauth2.Token.
+func writeAccessToken(hostname string, creds *v0.Credentials) {
+	if creds.AccessTokenExpiry == nil {
+		log.Fatalf("Access token expiry is nil")
+	}
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	b, err := json.Marshal(map[string]interface{}{
+		"access_token": creds.AccessToken,
+		"token_type":   "Bearer",
+		"expiry":       creds.AccessTokenExpiry,
+	})
+	rtx.Must(err, "Failed to marshal access token")
+	err = os.WriteFile(path.Join(*outputPath, accessTokenFilename), b, 0600)
+	rtx.Must(err, "Failed to write access token file")
+	tokenHostname = hostname
+	tokenExpiry = *creds.AccessTokenExpiry
+}
+
+// refreshTokens refreshes the access token halfway to its expiration, so that
+// failed refreshes are retried before the token expires.
+func refreshTokens() {
+	for {
+		tokenMu.Lock()
+		hostname, expiry := tokenHostname, tokenExpiry
+		tokenMu.Unlock()
+		wait := time.Until(expiry) / 2
+		if wait < minRefreshWait {
+			wait = minRefreshWait
+		}
+		time.Sleep(wait)
+
+		creds, err := refreshToken(hostname)
+		if err != nil {
+			log.Printf("Failed to refresh access token: %v", err)
+			continue
+		}
+		writeAccessToken(hostname, creds)
+		log.Printf("Access token refreshed, expires %s", creds.AccessTokenExpiry)
+	}
+}
+
+// refreshToken requests a new access token for hostname.
+func refreshToken(hostname string) (*v0.Credentials, error) {
+	creds, err := ac.AccessToken(context.Background(), hostname)
+	if err != nil {
+		return nil, err
+	}
+	if creds.AccessToken == "" || creds.AccessTokenExpiry == nil {
+		return nil, fmt.Errorf("response has no access token")
+	}
+	return creds, nil
+}
+
 // ipv4HTTPClient returns an HTTP client that always uses IPv4.
 // Default timeouts are from https://go.dev/src/net/http/transport.go
 func ipv4HTTPClient() *http.Client {
@@ -205,3 +325,20 @@ func ipv4HTTPClient() *http.Client {
 		},
 	}
 }
+
+// parseLocation parses a location of the form <lat>,<lon>.
+func parseLocation(s, city string) (*client.Location, error) {
+	lat, lon, ok := strings.Cut(s, ",")
+	if !ok {
+		return nil, fmt.Errorf("location %q is not of the form <lat>,<lon>", s)
+	}
+	loc := &client.Location{City: city}
+	var err error
+	if loc.Latitude, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil {
+		return nil, err
+	}
+	if loc.Longitude, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
@@ -0,0 +1,1103 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/config"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/host"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// RuntimeConfig is an interface used by the Server to inspect and change
+// runtime settings.
+type RuntimeConfig interface {
+	Get() config.Config
+	Set(ctx context.Context, c config.Config) error
+}
+
+// Config handler is used by operators to inspect and change runtime settings
+// without a redeploy. A GET returns the current settings. A POST sets any of
+// the "gc_ttl", "gc_interval", "min_client_version", "service_versions" and
+// "features" parameters given, keeping all others. The lists replace the
+// current ones, and an empty parameter clears the setting.
+func (s *Server) Config(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.ConfigResponse{}
+	if s.RuntimeConfig == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "runtime configuration is not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	c := s.RuntimeConfig.Get()
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var err error
+		if c.GCTTL, err = getDuration(req, "gc_ttl", c.GCTTL); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid gc ttl from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if c.GCInterval, err = getDuration(req, "gc_interval", c.GCInterval); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid gc interval from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if q := req.URL.Query(); q.Has("min_client_version") {
+			c.MinClientVersion = q.Get("min_client_version")
+		}
+		if l, ok := getList(req, "service_versions"); ok {
+			if c.ServiceVersions, err = parseServiceVersions(l); err != nil {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid service versions from request",
+					Detail: err.Error(),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+		}
+		if l, ok := getList(req, "features"); ok {
+			c.Features = l
+		}
+		if q := req.URL.Query(); q.Has("deprecated_client_version") {
+			c.DeprecatedClientVersion = q.Get("deprecated_client_version")
+		}
+		if c.ClientSunset, err = getDate(req, "client_sunset", c.ClientSunset); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid client sunset from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if err := c.Validate(); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidConfig,
+				Title:  "invalid runtime configuration",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if err := s.RuntimeConfig.Set(req.Context(), c); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrConfig,
+				Title:  "failed to save runtime configuration",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("config set failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		log.Printf("Runtime config changed: %+v", c)
+	default:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	resp.Config = &v0.Config{
+		GCTTL:                   c.GCTTL.String(),
+		GCInterval:              c.GCInterval.String(),
+		MinClientVersion:        c.MinClientVersion,
+		Features:                c.Features,
+		DeprecatedClientVersion: c.DeprecatedClientVersion,
+	}
+	if !c.ClientSunset.IsZero() {
+		resp.Config.ClientSunset = c.ClientSunset.Format(time.DateOnly)
+	}
+	if len(c.ServiceVersions) > 0 {
+		resp.Config.ServiceVersions = map[string]string{}
+		for _, v := range c.ServiceVersions {
+			resp.Config.ServiceVersions[v.Service] = v.MinClientVersion
+		}
+	}
+	writeResponse(rw, resp)
+}
+
+// parseServiceVersions parses a list of "service:version" pairs.
+func parseServiceVersions(l []string) ([]config.ServiceVersion, error) {
+	versions := []config.ServiceVersion{}
+	for _, v := range l {
+		service, version, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not service:version", v)
+		}
+		versions = append(versions, config.ServiceVersion{Service: service, MinClientVersion: version})
+	}
+	return versions, nil
+}
+
+// Org handler is used by operators to inspect and change the settings of an
+// organization. A GET returns the current settings. A POST sets any of the
+// "status", "email", "probability_multiplier", "verify_source_ip",
+// "allow_shared_ip", "allowed_asns", "allowed_prefixes", "node_keys",
+// "access_tokens" and "reject_hosting" parameters given, keeping all others. Changes are written to
+// the audit log.
+func (s *Server) Org(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.OrgResponse{}
+	if s.Orgs == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "organization settings are not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org := req.URL.Query().Get("org")
+	if !isValidName(org) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	settings, err := s.Orgs.Get(req.Context(), org)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSettings,
+			Title:  "failed to load organization settings",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("org settings get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		before := settings
+		switch status := req.URL.Query().Get("status"); status {
+		case "":
+		case orgs.StatusActive, orgs.StatusSuspended:
+			settings.Status = status
+		default:
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid status from request",
+				Detail: fmt.Sprintf("status must be %q or %q", orgs.StatusActive, orgs.StatusSuspended),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if req.URL.Query().Has("email") {
+			settings.Email = req.URL.Query().Get("email")
+			if err := orgs.ValidateEmail(settings.Email); err != nil {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid email from request",
+					Detail: err.Error(),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+		}
+		if v := req.URL.Query().Get("probability_multiplier"); v != "" {
+			m, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				err = orgs.ValidateProbabilityMultiplier(m)
+			}
+			if err != nil {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid probability_multiplier from request",
+					Detail: err.Error(),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+			settings.ProbabilityMultiplier = m
+		}
+		if settings.VerifySourceIP, err = getBool(req, "verify_source_ip", settings.VerifySourceIP); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid verify_source_ip from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if settings.AllowSharedIP, err = getBool(req, "allow_shared_ip", settings.AllowSharedIP); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid allow_shared_ip from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if v, ok := getList(req, "allowed_asns"); ok {
+			asns := []int64{}
+			for _, a := range v {
+				asn, err := strconv.ParseInt(strings.TrimPrefix(strings.ToUpper(a), "AS"), 10, 64)
+				if err != nil {
+					resp.Error = &v2.Error{
+						Type:   v0.ErrInvalidParam,
+						Title:  "invalid allowed_asns from request",
+						Detail: err.Error(),
+						Status: http.StatusBadRequest,
+					}
+					rw.WriteHeader(resp.Error.Status)
+					writeResponse(rw, resp)
+					return
+				}
+				asns = append(asns, asn)
+			}
+			settings.AllowedASNs = asns
+		}
+		if v, ok := getList(req, "allowed_prefixes"); ok {
+			for _, p := range v {
+				if _, _, err := net.ParseCIDR(p); err != nil {
+					resp.Error = &v2.Error{
+						Type:   v0.ErrInvalidParam,
+						Title:  "invalid allowed_prefixes from request",
+						Detail: err.Error(),
+						Status: http.StatusBadRequest,
+					}
+					rw.WriteHeader(resp.Error.Status)
+					writeResponse(rw, resp)
+					return
+				}
+			}
+			settings.AllowedPrefixes = v
+		}
+		if settings.NodeKeys, err = getBool(req, "node_keys", settings.NodeKeys); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid node_keys from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if settings.AccessTokens, err = getBool(req, "access_tokens", settings.AccessTokens); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid access_tokens from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if settings.RejectHosting, err = getBool(req, "reject_hosting", settings.RejectHosting); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid reject_hosting from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if v := req.URL.Query().Get("max_records"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid max_records from request",
+					Detail: err.Error(),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+			settings.MaxRecords = n
+		}
+		if v := req.URL.Query().Get("dns_ttl"); v != "" {
+			ttl, err := strconv.ParseInt(v, 10, 64)
+			if err == nil && ttl != 0 {
+				err = dnsx.ValidateTTL(ttl)
+			}
+			if err != nil {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid dns_ttl from request",
+					Detail: err.Error(),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+			settings.DNSTTL = ttl
+		}
+		if v := req.URL.Query().Get("max_metro_distance"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err == nil && n < 0 {
+				err = errors.New("max_metro_distance must not be negative")
+			}
+			if err != nil {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid max_metro_distance from request",
+					Detail: err.Error(),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+			settings.MaxMetroDistance = n
+		}
+		if err := s.Orgs.Set(req.Context(), org, settings); err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrOrgSettings,
+				Title:  "failed to save organization settings",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("org settings set failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		audit(req, "organization "+org, orgs.Diff(before, settings))
+		if s.History != nil && settings.ProbabilityMultiplier != before.ProbabilityMultiplier {
+			c := orgs.MultiplierChange{
+				Old:   before.ProbabilityMultiplier,
+				New:   settings.ProbabilityMultiplier,
+				Actor: caller(req),
+				Time:  time.Now().UTC(),
+			}
+			// Settings are saved already, so the request does not fail.
+			if err := s.History.Add(req.Context(), org, c); err != nil {
+				log.Println("org history add failure:", err)
+			}
+		}
+		if s.Emails != nil && settings.Email != "" && settings.Email != before.Email {
+			if err := s.Emails.SendVerification(req.Context(), org, settings.Email); err != nil {
+				log.Println("org email verification failure:", err)
+			}
+		}
+	default:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	resp.Org = org
+	status := settings.Status
+	if status == "" {
+		status = orgs.StatusActive
+	}
+	resp.Settings = &v0.OrgSettings{
+		Status:          status,
+		Email:           settings.Email,
+		VerifySourceIP:  settings.VerifySourceIP,
+		AllowSharedIP:   settings.AllowSharedIP,
+		AllowedASNs:     settings.AllowedASNs,
+		AllowedPrefixes: settings.AllowedPrefixes,
+		AllowedServices: settings.AllowedServices,
+		NodeKeys:        settings.NodeKeys,
+		AccessTokens:    settings.AccessTokens,
+		MaxRecords:      settings.RecordQuota(),
+		NameServers:     settings.NameServers,
+		DNSTTL:          settings.DNSTTL,
+
+		ProbabilityMultiplier:    settings.ProbabilityMultiplier,
+		MaxMetroDistance:         settings.MaxMetroDistance,
+		RejectHosting:            settings.RejectHosting,
+		WorkloadIdentityProvider: settings.WorkloadIdentityProvider,
+	}
+	writeResponse(rw, resp)
+}
+
+// OrgHistory handler is used by operators to list the probability multiplier
+// changes of an organization, most recent first.
+func (s *Server) OrgHistory(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.OrgHistoryResponse{}
+	if s.History == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "organization history is not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org := req.URL.Query().Get("org")
+	if !isValidName(org) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	changes, err := s.History.List(req.Context(), org)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSettings,
+			Title:  "failed to list organization history",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("org history list failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Org = org
+	for _, c := range changes {
+		resp.Changes = append(resp.Changes, v0.MultiplierChange{
+			Old:   c.Old,
+			New:   c.New,
+			Actor: c.Actor,
+			Time:  c.Time,
+		})
+	}
+	writeResponse(rw, resp)
+}
+
+// Override handler is used by operators to force the probability of a node,
+// e.g. zero to drain it during an incident, regardless of the probability the
+// node requests. A POST sets the "probability" and optional "reason". A DELETE
+// clears the override. The override applies to subsequent registrations.
+func (s *Server) Override(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.OverrideResponse{}
+	hostname := req.URL.Query().Get("hostname")
+	name, err := host.Parse(hostname)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	hostname = name.StringAll()
+
+	o := &tracker.Override{}
+	switch req.Method {
+	case http.MethodPost:
+		prob, err := strconv.ParseFloat(req.URL.Query().Get("probability"), 64)
+		if err != nil || prob < 0 || prob > 1 {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidProbability,
+				Title:  "probability must be a number between 0 and 1",
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		o = &tracker.Override{
+			Enabled:     true,
+			Probability: prob,
+			Reason:      req.URL.Query().Get("reason"),
+		}
+	case http.MethodDelete:
+	default:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	err = s.dnsTracker.SetOverride(hostname, o)
+	if err != nil {
+		// NOTE: memorystore does not distinguish a missing hostname from
+		// other errors, so check whether the hostname is registered.
+		if _, gerr := s.dnsTracker.Get(hostname); errors.Is(gerr, tracker.ErrNotFound) {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrNotFound,
+				Title:  "hostname is not registered",
+				Status: http.StatusNotFound,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "could not save probability override",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns gc override failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	log.Printf("Probability override for %s: %+v", hostname, o)
+	resp.Hostname = hostname
+	if o.Enabled {
+		resp.Override = &v0.Override{Probability: o.Probability, Reason: o.Reason}
+	}
+	writeResponse(rw, resp)
+}
+
+// Expire handler is used by operators to expire a registered node now,
+// rather than waiting for the garbage collector. The hostname is removed from
+// DNS and the tracker, and reported as expired, as if the node had stopped
+// registering. A node that is still running registers again.
+func (s *Server) Expire(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.ExpireResponse{}
+	if req.Method != http.MethodPost {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	name, err := host.Parse(req.URL.Query().Get("hostname"))
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	hostname := name.StringAll()
+
+	_, err = s.dnsTracker.Get(hostname)
+	switch {
+	case errors.Is(err, tracker.ErrNotFound):
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotFound,
+			Title:  "hostname is not registered",
+			Status: http.StatusNotFound,
+		}
+	case err != nil:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "could not read hostname from DNS tracker",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+	default:
+		resp.Error = s.deleteHostname(req.Context(), name, "expired")
+	}
+	if resp.Error != nil {
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	audit(req, "node "+hostname, []string{"expired"})
+	resp.Hostname = hostname
+	writeResponse(rw, resp)
+}
+
+// ClientVersions handler returns the number of active nodes of each client
+// version by org, e.g. to find the nodes that would break before raising the
+// minimum client version. The "org" parameter limits the result to one org.
+// With the "min_client_version" parameter, the nodes older than it are also
+// counted.
+func (s *Server) ClientVersions(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.ClientVersionsResponse{}
+	if req.Method != http.MethodGet {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	q := req.URL.Query()
+	min := q.Get("min_client_version")
+	if min != "" && !config.ValidVersion(min) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid min client version from request",
+			Detail: config.ErrInvalidVersion.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	nodes, status, err := s.dnsTracker.List()
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrList,
+			Title:  "could not list nodes",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("client versions list failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Orgs = map[string]map[string]int{}
+	for i, hostname := range nodes {
+		name, err := host.Parse(hostname)
+		if err != nil || (q.Get("org") != "" && name.Org != q.Get("org")) {
+			continue
+		}
+		v := ""
+		if status[i].DNS != nil {
+			v = status[i].DNS.ClientVersion
+		}
+		if resp.Orgs[name.Org] == nil {
+			resp.Orgs[name.Org] = map[string]int{}
+		}
+		resp.Orgs[name.Org][versionLabel(v)]++
+		if min != "" && config.Older(v, min) {
+			if resp.Older == nil {
+				resp.Older = map[string]int{}
+			}
+			resp.Older[name.Org]++
+		}
+	}
+	writeResponse(rw, resp)
+}
+
+// KeyManager is an interface used by the Server to manage API keys.
+type KeyManager interface {
+	Create(ctx context.Context, org string, scopes []string, expires time.Time) (*keys.Key, string, error)
+	List(ctx context.Context, org string) ([]*keys.Key, error)
+	Revoke(ctx context.Context, org, id string) error
+}
+
+// Keys handler is used by operators to manage the API keys of an
+// organization. A GET lists the keys created by this handler. A POST creates
+// a key with the given "scopes", or all scopes, that expires after the given
+// "expires" duration, or never. The key string is only returned by the POST.
+// A DELETE revokes the key with the given "id". New keys may only be granted
+// the scopes of the key of the request.
+func (s *Server) Keys(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.KeysResponse{}
+	if s.APIKeys == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "api key management is not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org := req.URL.Query().Get("org")
+	if !isValidName(org) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Org = org
+
+	switch req.Method {
+	case http.MethodGet:
+		l, err := s.APIKeys.List(req.Context(), org)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrAPIKeys,
+				Title:  "failed to list api keys",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("api keys list failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		now := time.Now()
+		for _, k := range l {
+			resp.Keys = append(resp.Keys, toAPIKey(k, now))
+		}
+	case http.MethodPost:
+		scopes, _ := getList(req, "scopes")
+		for _, scope := range scopes {
+			if !keys.ValidScope(scope) {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrInvalidParam,
+					Title:  "invalid scopes from request",
+					Detail: fmt.Sprintf("scopes must be a subset of %v", keys.AllScopes),
+					Status: http.StatusBadRequest,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+		}
+		// Keys may only grant the scopes of the key that creates them.
+		granted := scopes
+		if len(granted) == 0 {
+			granted = keys.DefaultScopes
+		}
+		info, _ := req.Context().Value(keyInfoKey{}).(*keys.Info)
+		for _, scope := range granted {
+			if info == nil || !info.Allows(scope) {
+				resp.Error = &v2.Error{
+					Type:   v0.ErrMissingScope,
+					Title:  "api key may not grant the " + scope + " scope",
+					Status: http.StatusForbidden,
+				}
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+		}
+		d, err := getDuration(req, "expires", 0)
+		if err != nil || d < 0 {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid expires from request",
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		expires := time.Time{}
+		if d > 0 {
+			expires = time.Now().Add(d).UTC()
+		}
+		k, key, err := s.APIKeys.Create(req.Context(), org, scopes, expires)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrAPIKeys,
+				Title:  "failed to create api key",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("api keys create failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		log.Printf("API key %s created for %s with scopes %v, expires %v", k.ID, org, scopes, expires)
+		resp.Keys = []v0.APIKey{toAPIKey(k, time.Now())}
+		resp.Key = key
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		err := s.APIKeys.Revoke(req.Context(), org, id)
+		if errors.Is(err, keys.ErrNotFound) {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrNotFound,
+				Title:  "api key not found",
+				Status: http.StatusNotFound,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrAPIKeys,
+				Title:  "failed to revoke api key",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("api keys revoke failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if s.KeyCache != nil {
+			// The key is revoked already, so the request does not fail.
+			if err := s.KeyCache.InvalidateKey(id); err != nil {
+				log.Println("api key cache invalidate failure:", err)
+			}
+		}
+		log.Printf("API key %s revoked for %s", id, org)
+	default:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	writeResponse(rw, resp)
+}
+
+// toAPIKey converts a saved key to its API representation at time now.
+func toAPIKey(k *keys.Key, now time.Time) v0.APIKey {
+	a := v0.APIKey{
+		ID:      k.ID,
+		Scopes:  k.Scopes,
+		Created: k.Created,
+		Age:     now.Sub(k.Created).Round(time.Second).String(),
+	}
+	if len(a.Scopes) == 0 {
+		a.Scopes = keys.DefaultScopes
+	}
+	if !k.ExpiresAt.IsZero() {
+		a.ExpiresAt = &k.ExpiresAt
+	}
+	if !k.Revoked.IsZero() {
+		a.Revoked = &k.Revoked
+	}
+	return a
+}
+
+// audit logs the changes made by an admin request to the given resource, with
+// the API key and source address of the request.
+func audit(req *http.Request, resource string, changes []string) {
+	if len(changes) == 0 {
+		changes = []string{"no changes"}
+	}
+	log.Printf("AUDIT %s %s by %s from %s: %s", req.Method, resource, caller(req), getSourceIP(req, defaultProxyHops), strings.Join(changes, "; "))
+}
+
+// caller returns the API key of the request, e.g. "key autojoin-key-foo-1".
+func caller(req *http.Request) string {
+	if info, ok := req.Context().Value(keyInfoKey{}).(*keys.Info); ok {
+		return "key " + info.ID
+	}
+	return "unknown key"
+}
+
+// getDuration parses the named duration parameter, returning def if it is not
+// present.
+func getDuration(req *http.Request, name string, def time.Duration) (time.Duration, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// getDate parses the named YYYY-MM-DD parameter, returning def if it is not
+// present. The result is zero if the parameter is present without a value.
+func getDate(req *http.Request, name string, def time.Time) (time.Time, error) {
+	q := req.URL.Query()
+	if !q.Has(name) {
+		return def, nil
+	}
+	if q.Get(name) == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, q.Get(name))
+}
+
+// getBool parses the named boolean parameter, returning def if it is not
+// present.
+func getBool(req *http.Request, name string, def bool) (bool, error) {
+	v := req.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// getList parses the named comma-separated parameter. The result is empty if
+// the parameter is present without a value, and ok is false if it is absent.
+func getList(req *http.Request, name string) ([]string, bool) {
+	q := req.URL.Query()
+	if !q.Has(name) {
+		return nil, false
+	}
+	l := []string{}
+	for _, v := range strings.Split(q.Get(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l, true
+}
+
+// KeyRotator is an interface used by the Server to rotate the service account
+// key of an organization.
+type KeyRotator interface {
+	Rotate(ctx context.Context, org string) error
+}
+
+// Rotate handler is used by operators to replace the service account key of
+// an organization immediately, e.g. after a key is exposed. A POST rotates
+// the key of the given "org". Nodes receive the new key on their next
+// registration.
+func (s *Server) Rotate(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.RotateResponse{}
+	if s.KeyRotation == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "key rotation is not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if req.Method != http.MethodPost {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org := req.URL.Query().Get("org")
+	if !isValidName(org) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if err := s.KeyRotation.Rotate(req.Context(), org); err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrCredentials,
+			Title:  "failed to rotate service account key",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("key rotation failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	log.Printf("Service account key of %s rotated by operator", org)
+	resp.Org = org
+	writeResponse(rw, resp)
+}
+
+// Datasets handler reports the freshness of the datasets that annotate
+// registrations: their source, the time of their last successful load, and
+// their size.
+func (s *Server) Datasets(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.DatasetsResponse{}
+	if req.Method != http.MethodGet {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	for _, name := range s.datasetNames() {
+		d := v0.Dataset{
+			Name:   name,
+			Source: s.DatasetSources[name],
+			Status: s.datasetStatus(name),
+		}
+		s.datasetsMu.Lock()
+		loaded, ok := s.datasets[name]
+		s.datasetsMu.Unlock()
+		if ok {
+			t := loaded.UTC()
+			d.Loaded = &t
+			d.AgeSeconds = int64(time.Since(loaded).Seconds())
+		}
+		if c, ok := s.dataset(name).(DatasetCounter); ok {
+			d.Entries = c.Len()
+		}
+		resp.Datasets = append(resp.Datasets, d)
+	}
+	writeResponse(rw, resp)
+}
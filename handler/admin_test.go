@@ -0,0 +1,1287 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/config"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/tracker"
+)
+
+type fakeRuntimeConfig struct {
+	c      config.Config
+	setErr error
+}
+
+func (f *fakeRuntimeConfig) Get() config.Config {
+	return f.c
+}
+
+func (f *fakeRuntimeConfig) Set(ctx context.Context, c config.Config) error {
+	if f.setErr != nil {
+		return f.setErr
+	}
+	f.c = c
+	return nil
+}
+
+type fakeOrgSettings struct {
+	settings orgs.Settings
+	byOrg    map[string]orgs.Settings
+	getErr   error
+	setErr   error
+}
+
+func (f *fakeOrgSettings) Get(ctx context.Context, org string) (orgs.Settings, error) {
+	if f.byOrg != nil {
+		return f.byOrg[org], f.getErr
+	}
+	return f.settings, f.getErr
+}
+
+func (f *fakeOrgSettings) Set(ctx context.Context, org string, s orgs.Settings) error {
+	if f.setErr != nil {
+		return f.setErr
+	}
+	f.settings = s
+	return nil
+}
+
+type fakeHistory struct {
+	changes []orgs.MultiplierChange
+	addErr  error
+	listErr error
+}
+
+func (f *fakeHistory) Add(ctx context.Context, org string, c orgs.MultiplierChange) error {
+	if f.addErr != nil {
+		return f.addErr
+	}
+	f.changes = append(f.changes, c)
+	return nil
+}
+
+func (f *fakeHistory) List(ctx context.Context, org string) ([]orgs.MultiplierChange, error) {
+	return f.changes, f.listErr
+}
+
+type fakeKeyManager struct {
+	keys      []*keys.Key
+	created   *keys.Key
+	createErr error
+	listErr   error
+	revokeErr error
+	revoked   string
+}
+
+func (f *fakeKeyManager) Create(ctx context.Context, org string, scopes []string, expires time.Time) (*keys.Key, string, error) {
+	if f.createErr != nil {
+		return nil, "", f.createErr
+	}
+	f.created = &keys.Key{ID: "autojoin-key-" + org + "-1234", Org: org, Scopes: scopes, Created: time.Now(), ExpiresAt: expires}
+	return f.created, "secret", nil
+}
+
+func (f *fakeKeyManager) List(ctx context.Context, org string) ([]*keys.Key, error) {
+	return f.keys, f.listErr
+}
+
+func (f *fakeKeyManager) Revoke(ctx context.Context, org, id string) error {
+	f.revoked = id
+	return f.revokeErr
+}
+
+type fakeKeyCache struct {
+	invalidated string
+	err         error
+}
+
+func (f *fakeKeyCache) InvalidateKey(id string) error {
+	f.invalidated = id
+	return f.err
+}
+
+func TestServer_Config(t *testing.T) {
+	defaults := config.Config{GCTTL: 3 * time.Hour, GCInterval: 30 * time.Minute}
+	tests := []struct {
+		name     string
+		rc       *fakeRuntimeConfig
+		method   string
+		params   string
+		wantCode int
+		want     *v0.Config
+	}{
+		{
+			name:     "success-get",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			want:     &v0.Config{GCTTL: "3h0m0s", GCInterval: "30m0s"},
+		},
+		{
+			name:     "success-post-ttl",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?gc_ttl=336h",
+			wantCode: http.StatusOK,
+			want:     &v0.Config{GCTTL: "336h0m0s", GCInterval: "30m0s"},
+		},
+		{
+			name:     "success-post-interval",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?gc_interval=5m",
+			wantCode: http.StatusOK,
+			want:     &v0.Config{GCTTL: "3h0m0s", GCInterval: "5m0s"},
+		},
+		{
+			name:     "success-post-versions-features",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?min_client_version=0.2.0&service_versions=ndt:0.3.0,wehe:0.1.0&features=a,b",
+			wantCode: http.StatusOK,
+			want: &v0.Config{
+				GCTTL: "3h0m0s", GCInterval: "30m0s", MinClientVersion: "0.2.0",
+				ServiceVersions: map[string]string{"ndt": "0.3.0", "wehe": "0.1.0"},
+				Features:        []string{"a", "b"},
+			},
+		},
+		{
+			name: "success-post-clear",
+			rc: &fakeRuntimeConfig{c: config.Config{
+				GCTTL: 3 * time.Hour, GCInterval: 30 * time.Minute, MinClientVersion: "0.2.0",
+				ServiceVersions: []config.ServiceVersion{{Service: "ndt", MinClientVersion: "0.3.0"}},
+				Features:        []string{"a"},
+			}},
+			method:   http.MethodPost,
+			params:   "?min_client_version=&service_versions=&features=",
+			wantCode: http.StatusOK,
+			want:     &v0.Config{GCTTL: "3h0m0s", GCInterval: "30m0s"},
+		},
+		{
+			name:     "error-disabled",
+			method:   http.MethodGet,
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-bad-service-versions",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?service_versions=ndt",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "success-post-deprecated",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?deprecated_client_version=0.3.0&client_sunset=2030-01-02",
+			wantCode: http.StatusOK,
+			want: &v0.Config{
+				GCTTL: "3h0m0s", GCInterval: "30m0s",
+				DeprecatedClientVersion: "0.3.0", ClientSunset: "2030-01-02",
+			},
+		},
+		{
+			name:     "error-bad-client-sunset",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?client_sunset=tomorrow",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-min-client-version",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?min_client_version=latest",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-method",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodDelete,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-bad-ttl",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?gc_ttl=forever",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-interval",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?gc_interval=soon",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-out-of-range",
+			rc:       &fakeRuntimeConfig{c: defaults},
+			method:   http.MethodPost,
+			params:   "?gc_ttl=1m",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-set",
+			rc:       &fakeRuntimeConfig{c: defaults, setErr: errors.New("fake datastore error")},
+			method:   http.MethodPost,
+			params:   "?gc_ttl=6h",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.rc != nil {
+				s.RuntimeConfig = tt.rc
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/config"+tt.params, nil)
+			s.Config(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Config() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.ConfigResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Config() returned invalid json: %v", err)
+			}
+			if tt.want == nil {
+				if resp.Error == nil {
+					t.Errorf("Config() returned no error, want error")
+				}
+				return
+			}
+			if resp.Config == nil || !reflect.DeepEqual(*resp.Config, *tt.want) {
+				t.Errorf("Config() = %v, want %v", resp.Config, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Override(t *testing.T) {
+	const hostname = "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
+	tests := []struct {
+		name         string
+		Tracker      *fakeStatusTracker
+		method       string
+		params       string
+		wantCode     int
+		wantOverride *tracker.Override
+	}{
+		{
+			name:         "success-drain",
+			Tracker:      &fakeStatusTracker{},
+			method:       http.MethodPost,
+			params:       "?hostname=" + hostname + "&probability=0&reason=incident",
+			wantCode:     http.StatusOK,
+			wantOverride: &tracker.Override{Enabled: true, Probability: 0, Reason: "incident"},
+		},
+		{
+			name:         "success-clear",
+			Tracker:      &fakeStatusTracker{override: &tracker.Override{Enabled: true}},
+			method:       http.MethodDelete,
+			params:       "?hostname=" + hostname,
+			wantCode:     http.StatusOK,
+			wantOverride: &tracker.Override{},
+		},
+		{
+			name:     "error-hostname",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodPost,
+			params:   "?hostname=invalid&probability=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-probability",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodPost,
+			params:   "?hostname=" + hostname + "&probability=2",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-method",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodGet,
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-not-found",
+			Tracker:  &fakeStatusTracker{overrideErr: errors.New("fake error"), getErr: tracker.ErrNotFound},
+			method:   http.MethodPost,
+			params:   "?hostname=" + hostname + "&probability=0",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-set",
+			Tracker:  &fakeStatusTracker{overrideErr: errors.New("fake error"), record: &tracker.DNSRecord{}},
+			method:   http.MethodPost,
+			params:   "?hostname=" + hostname + "&probability=0",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, tt.Tracker, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/override"+tt.params, nil)
+
+			s.Override(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Override() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.OverrideResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			if !reflect.DeepEqual(tt.Tracker.override, tt.wantOverride) {
+				t.Errorf("Override() saved wrong override; got %v, want %v", tt.Tracker.override, tt.wantOverride)
+			}
+			if (resp.Override != nil) != tt.wantOverride.Enabled {
+				t.Errorf("Override() returned wrong override; got %v", resp.Override)
+			}
+		})
+	}
+}
+
+func TestServer_Expire(t *testing.T) {
+	const hostname = "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
+	tests := []struct {
+		name       string
+		DNS        *fakeDNS
+		Tracker    *fakeStatusTracker
+		method     string
+		params     string
+		wantCode   int
+		wantReport bool
+	}{
+		{
+			name:       "success",
+			DNS:        &fakeDNS{},
+			Tracker:    &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			method:     http.MethodPost,
+			params:     "?hostname=" + hostname,
+			wantCode:   http.StatusOK,
+			wantReport: true,
+		},
+		{
+			name:     "error-method",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodGet,
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-hostname",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodPost,
+			params:   "?hostname=invalid",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-not-found",
+			Tracker:  &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			method:   http.MethodPost,
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-get",
+			Tracker:  &fakeStatusTracker{getErr: errors.New("fake error")},
+			method:   http.MethodPost,
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-dns-delete",
+			DNS:      &fakeDNS{getErr: errors.New("fake error")},
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			method:   http.MethodPost,
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, tt.DNS, tt.Tracker, nil)
+			r := &fakeReporter{}
+			s.Decommission = r
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/expire"+tt.params, nil)
+
+			s.Expire(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Expire() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.ExpireResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if tt.wantReport && (len(r.reasons) != 1 || r.reasons[0] != "expired") {
+				t.Errorf("Expire() reported wrong reasons; got %v, want [expired]", r.reasons)
+			}
+			if !tt.wantReport && len(r.reasons) != 0 {
+				t.Errorf("Expire() reported unexpectedly; got %v", r.reasons)
+			}
+		})
+	}
+}
+
+func TestServer_Org(t *testing.T) {
+	tests := []struct {
+		name       string
+		orgs       *fakeOrgSettings
+		method     string
+		params     string
+		wantCode   int
+		want       bool
+		wantIP     bool
+		wantKeys   bool
+		wantTokens bool
+		wantStatus string
+	}{
+		{
+			name:     "success-get",
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusOK,
+			want:     true,
+		},
+		{
+			name:     "success-set",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&verify_source_ip=true",
+			wantCode: http.StatusOK,
+			want:     true,
+		},
+		{
+			name:     "success-set-allow-shared-ip",
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			method:   http.MethodPost,
+			params:   "?org=mlab&allow_shared_ip=true",
+			wantCode: http.StatusOK,
+			want:     true,
+			wantIP:   true,
+		},
+		{
+			name:     "error-allow-shared-ip-value",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&allow_shared_ip=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "success-set-node-keys",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&node_keys=true",
+			wantCode: http.StatusOK,
+			wantKeys: true,
+		},
+		{
+			name:       "success-set-access-tokens",
+			orgs:       &fakeOrgSettings{},
+			method:     http.MethodPost,
+			params:     "?org=mlab&access_tokens=true",
+			wantCode:   http.StatusOK,
+			wantTokens: true,
+		},
+		{
+			name:     "error-access-tokens-value",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&access_tokens=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-reject-hosting-value",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&reject_hosting=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-node-keys-value",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&node_keys=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:       "success-suspend",
+			orgs:       &fakeOrgSettings{},
+			method:     http.MethodPost,
+			params:     "?org=mlab&status=suspended",
+			wantCode:   http.StatusOK,
+			wantStatus: orgs.StatusSuspended,
+		},
+		{
+			name:     "error-status",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&status=deleted",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-not-enabled",
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-org",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodGet,
+			params:   "?org=-BAD-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-get",
+			orgs:     &fakeOrgSettings{getErr: errors.New("fake get error")},
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-value",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&verify_source_ip=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-set",
+			orgs:     &fakeOrgSettings{setErr: errors.New("fake set error")},
+			method:   http.MethodPost,
+			params:   "?org=mlab&verify_source_ip=true",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-method",
+			orgs:     &fakeOrgSettings{},
+			method:   http.MethodDelete,
+			params:   "?org=mlab",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/org"+tt.params, nil)
+
+			s.Org(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Org() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.OrgResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			if resp.Settings == nil || resp.Settings.VerifySourceIP != tt.want || resp.Settings.AllowSharedIP != tt.wantIP ||
+				resp.Settings.NodeKeys != tt.wantKeys || resp.Settings.AccessTokens != tt.wantTokens {
+				t.Errorf("Org() returned wrong settings; got %v", resp.Settings)
+			}
+			if tt.orgs.settings.VerifySourceIP != tt.want || tt.orgs.settings.AllowSharedIP != tt.wantIP ||
+				tt.orgs.settings.NodeKeys != tt.wantKeys || tt.orgs.settings.AccessTokens != tt.wantTokens {
+				t.Errorf("Org() saved wrong settings; got %v", tt.orgs.settings)
+			}
+			wantStatus := tt.wantStatus
+			if wantStatus == "" {
+				wantStatus = orgs.StatusActive
+			}
+			if resp.Settings.Status != wantStatus {
+				t.Errorf("Org() returned wrong status; got %q, want %q", resp.Settings.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestServer_OrgAllowlist(t *testing.T) {
+	tests := []struct {
+		name         string
+		orgs         *fakeOrgSettings
+		params       string
+		wantCode     int
+		wantASNs     []int64
+		wantPrefixes []string
+	}{
+		{
+			name:         "success-set",
+			orgs:         &fakeOrgSettings{},
+			params:       "?org=mlab&allowed_asns=AS12345,%206789&allowed_prefixes=192.168.0.0/16,2001:db8::/32",
+			wantCode:     http.StatusOK,
+			wantASNs:     []int64{12345, 6789},
+			wantPrefixes: []string{"192.168.0.0/16", "2001:db8::/32"},
+		},
+		{
+			name:         "success-keep",
+			orgs:         &fakeOrgSettings{settings: orgs.Settings{AllowedASNs: []int64{1}, AllowedPrefixes: []string{"10.0.0.0/8"}}},
+			params:       "?org=mlab&verify_source_ip=true",
+			wantCode:     http.StatusOK,
+			wantASNs:     []int64{1},
+			wantPrefixes: []string{"10.0.0.0/8"},
+		},
+		{
+			name:         "success-clear",
+			orgs:         &fakeOrgSettings{settings: orgs.Settings{AllowedASNs: []int64{1}, AllowedPrefixes: []string{"10.0.0.0/8"}}},
+			params:       "?org=mlab&allowed_asns=&allowed_prefixes=",
+			wantCode:     http.StatusOK,
+			wantASNs:     []int64{},
+			wantPrefixes: []string{},
+		},
+		{
+			name:     "error-asn",
+			orgs:     &fakeOrgSettings{},
+			params:   "?org=mlab&allowed_asns=ASX",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-prefix",
+			orgs:     &fakeOrgSettings{},
+			params:   "?org=mlab&allowed_prefixes=192.168.0.1",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			s.Orgs = tt.orgs
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/admin/org"+tt.params, nil)
+
+			s.Org(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Org() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			if !reflect.DeepEqual(tt.orgs.settings.AllowedASNs, tt.wantASNs) {
+				t.Errorf("Org() saved wrong ASNs; got %v, want %v", tt.orgs.settings.AllowedASNs, tt.wantASNs)
+			}
+			if !reflect.DeepEqual(tt.orgs.settings.AllowedPrefixes, tt.wantPrefixes) {
+				t.Errorf("Org() saved wrong prefixes; got %v, want %v", tt.orgs.settings.AllowedPrefixes, tt.wantPrefixes)
+			}
+		})
+	}
+}
+
+func TestServer_OrgMetadata(t *testing.T) {
+	tests := []struct {
+		name           string
+		orgs           *fakeOrgSettings
+		params         string
+		wantCode       int
+		wantEmail      string
+		wantMultiplier float64
+		wantHistory    int
+		wantVerify     string
+	}{
+		{
+			name:           "success-set",
+			orgs:           &fakeOrgSettings{},
+			params:         "?org=mlab&email=ops@mlab.example&probability_multiplier=0.5",
+			wantCode:       http.StatusOK,
+			wantEmail:      "ops@mlab.example",
+			wantMultiplier: 0.5,
+			wantHistory:    1,
+			wantVerify:     "ops@mlab.example",
+		},
+		{
+			name:           "success-keep",
+			orgs:           &fakeOrgSettings{settings: orgs.Settings{Email: "ops@mlab.example", ProbabilityMultiplier: 2}},
+			params:         "?org=mlab&verify_source_ip=true",
+			wantCode:       http.StatusOK,
+			wantEmail:      "ops@mlab.example",
+			wantMultiplier: 2,
+		},
+		{
+			name:           "success-clear-email",
+			orgs:           &fakeOrgSettings{settings: orgs.Settings{Email: "ops@mlab.example", ProbabilityMultiplier: 2}},
+			params:         "?org=mlab&email=",
+			wantCode:       http.StatusOK,
+			wantMultiplier: 2,
+		},
+		{
+			name:     "error-email",
+			orgs:     &fakeOrgSettings{},
+			params:   "?org=mlab&email=ops",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-multiplier-zero",
+			orgs:     &fakeOrgSettings{},
+			params:   "?org=mlab&probability_multiplier=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-multiplier-too-large",
+			orgs:     &fakeOrgSettings{},
+			params:   "?org=mlab&probability_multiplier=11",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-multiplier-value",
+			orgs:     &fakeOrgSettings{},
+			params:   "?org=mlab&probability_multiplier=half",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			s.Orgs = tt.orgs
+			h := &fakeHistory{}
+			s.History = h
+			e := &fakeEmails{}
+			s.Emails = e
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/admin/org"+tt.params, nil)
+
+			s.Org(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Org() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if len(h.changes) != tt.wantHistory {
+				t.Errorf("Org() recorded %d multiplier changes, want %d", len(h.changes), tt.wantHistory)
+			}
+			if e.sent != tt.wantVerify {
+				t.Errorf("Org() sent verification to %q, want %q", e.sent, tt.wantVerify)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			if tt.orgs.settings.Email != tt.wantEmail || tt.orgs.settings.ProbabilityMultiplier != tt.wantMultiplier {
+				t.Errorf("Org() saved wrong metadata; got %v", tt.orgs.settings)
+			}
+			resp := v0.OrgResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Settings.Email != tt.wantEmail || resp.Settings.ProbabilityMultiplier != tt.wantMultiplier {
+				t.Errorf("Org() returned wrong metadata; got %v", resp.Settings)
+			}
+		})
+	}
+}
+
+func TestServer_OrgActor(t *testing.T) {
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+	s.Orgs = &fakeOrgSettings{}
+	h := &fakeHistory{}
+	s.History = h
+	v := &fakeKeyValidator{org: "admins", scopes: []string{keys.ScopeAdmin}}
+	org := WithAPIKeyValidation(v, RequireScope(keys.ScopeAdmin, s.Org))
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/admin/org?key=abc&org=mlab&probability_multiplier=2", nil)
+
+	org(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Org() returned wrong code; got %d, want %d", rw.Code, http.StatusOK)
+	}
+	if len(h.changes) != 1 || h.changes[0].Actor != "key autojoin-key-admins" {
+		t.Errorf("Org() recorded wrong multiplier changes; got %+v, want actor key autojoin-key-admins", h.changes)
+	}
+}
+
+func TestServer_OrgHistory(t *testing.T) {
+	changed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		history  *fakeHistory
+		params   string
+		wantCode int
+		want     []v0.MultiplierChange
+	}{
+		{
+			name: "success",
+			history: &fakeHistory{changes: []orgs.MultiplierChange{
+				{Old: 0.5, New: 2, Actor: "key foo", Time: changed},
+			}},
+			params:   "?org=mlab",
+			wantCode: http.StatusOK,
+			want:     []v0.MultiplierChange{{Old: 0.5, New: 2, Actor: "key foo", Time: changed}},
+		},
+		{
+			name:     "success-empty",
+			history:  &fakeHistory{},
+			params:   "?org=mlab",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-disabled",
+			params:   "?org=mlab",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-org",
+			history:  &fakeHistory{},
+			params:   "?org=-BAD-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-list",
+			history:  &fakeHistory{listErr: errors.New("fake list error")},
+			params:   "?org=mlab",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.history != nil {
+				s.History = tt.history
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/admin/org/history"+tt.params, nil)
+
+			s.OrgHistory(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("OrgHistory() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.OrgHistoryResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Changes, tt.want) {
+				t.Errorf("OrgHistory() returned wrong changes; got %v, want %v", resp.Changes, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Keys(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name     string
+		keys     *fakeKeyManager
+		method   string
+		params   string
+		scopes   []string
+		cacheErr error
+		wantCode int
+		wantKeys int
+		wantKey  string
+		wantGone string
+	}{
+		{
+			name:     "success-list",
+			keys:     &fakeKeyManager{keys: []*keys.Key{{ID: "autojoin-key-mlab-1234", Org: "mlab", Created: created}}},
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusOK,
+			wantKeys: 1,
+		},
+		{
+			name:     "success-create",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&scopes=register&expires=720h",
+			wantCode: http.StatusOK,
+			wantKeys: 1,
+			wantKey:  "secret",
+		},
+		{
+			name:     "success-revoke",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodDelete,
+			params:   "?org=mlab&id=autojoin-key-mlab-1234",
+			wantCode: http.StatusOK,
+			wantGone: "autojoin-key-mlab-1234",
+		},
+		{
+			name:     "success-revoke-invalidate-error",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodDelete,
+			params:   "?org=mlab&id=autojoin-key-mlab-1234",
+			cacheErr: errors.New("fake invalidate error"),
+			wantCode: http.StatusOK,
+			wantGone: "autojoin-key-mlab-1234",
+		},
+		{
+			name:     "error-not-enabled",
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-org",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodGet,
+			params:   "?org=-BAD-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-list",
+			keys:     &fakeKeyManager{listErr: errors.New("fake list error")},
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-scopes",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&scopes=owner",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-scopes-not-held",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&scopes=register,delete",
+			scopes:   []string{keys.ScopeAdmin, keys.ScopeRegister},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-default-scopes-not-held",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodPost,
+			params:   "?org=mlab",
+			scopes:   []string{keys.ScopeAdmin, keys.ScopeRegister},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-expires",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodPost,
+			params:   "?org=mlab&expires=-1h",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-create",
+			keys:     &fakeKeyManager{createErr: errors.New("fake create error")},
+			method:   http.MethodPost,
+			params:   "?org=mlab",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-revoke-not-found",
+			keys:     &fakeKeyManager{revokeErr: keys.ErrNotFound},
+			method:   http.MethodDelete,
+			params:   "?org=mlab&id=autojoin-key-mlab",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-revoke",
+			keys:     &fakeKeyManager{revokeErr: errors.New("fake revoke error")},
+			method:   http.MethodDelete,
+			params:   "?org=mlab&id=autojoin-key-mlab-1234",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-method",
+			keys:     &fakeKeyManager{},
+			method:   http.MethodPut,
+			params:   "?org=mlab",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.keys != nil {
+				s.APIKeys = tt.keys
+			}
+			kc := &fakeKeyCache{err: tt.cacheErr}
+			s.KeyCache = kc
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/keys"+tt.params, nil)
+			scopes := tt.scopes
+			if scopes == nil {
+				scopes = keys.AllScopes
+			}
+			req = req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{ID: "autojoin-key-admin", Scopes: scopes}))
+
+			s.Keys(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Keys() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.KeysResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if len(resp.Keys) != tt.wantKeys || resp.Key != tt.wantKey {
+				t.Errorf("Keys() returned wrong keys; got %v, %q", resp.Keys, resp.Key)
+			}
+			if kc.invalidated != tt.wantGone {
+				t.Errorf("Keys() invalidated wrong key; got %q, want %q", kc.invalidated, tt.wantGone)
+			}
+		})
+	}
+}
+
+func Test_toAPIKey(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	k := &keys.Key{ID: "autojoin-key-mlab-1234", Created: created, ExpiresAt: created.Add(time.Hour)}
+	got := toAPIKey(k, created.Add(72*time.Hour))
+	if got.Age != "72h0m0s" || !reflect.DeepEqual(got.Scopes, keys.DefaultScopes) || got.ExpiresAt == nil || got.Revoked != nil {
+		t.Errorf("toAPIKey() = %+v", got)
+	}
+}
+
+type fakeKeyRotator struct {
+	rotated string
+	err     error
+}
+
+func (f *fakeKeyRotator) Rotate(ctx context.Context, org string) error {
+	f.rotated = org
+	return f.err
+}
+
+func TestServer_Rotate(t *testing.T) {
+	tests := []struct {
+		name        string
+		rotator     *fakeKeyRotator
+		method      string
+		params      string
+		wantCode    int
+		wantRotated string
+	}{
+		{
+			name:        "success",
+			rotator:     &fakeKeyRotator{},
+			method:      http.MethodPost,
+			params:      "?org=mlab",
+			wantCode:    http.StatusOK,
+			wantRotated: "mlab",
+		},
+		{
+			name:     "error-not-enabled",
+			method:   http.MethodPost,
+			params:   "?org=mlab",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-method",
+			rotator:  &fakeKeyRotator{},
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-org",
+			rotator:  &fakeKeyRotator{},
+			method:   http.MethodPost,
+			params:   "?org=-BAD-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "error-rotate",
+			rotator:     &fakeKeyRotator{err: errors.New("fake rotate error")},
+			method:      http.MethodPost,
+			params:      "?org=mlab",
+			wantCode:    http.StatusInternalServerError,
+			wantRotated: "mlab",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.rotator != nil {
+				s.KeyRotation = tt.rotator
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/rotate"+tt.params, nil)
+
+			s.Rotate(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Rotate() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.RotateResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if tt.rotator != nil && tt.rotator.rotated != tt.wantRotated {
+				t.Errorf("Rotate() rotated wrong org; got %q, want %q", tt.rotator.rotated, tt.wantRotated)
+			}
+		})
+	}
+}
+
+func TestServer_ClientVersions(t *testing.T) {
+	nodes := []string{
+		"ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
+		"ndt-lga3270-4f20bd8a.mlab.sandbox.measurement-lab.org",
+		"ndt-lga3271-4f20bd8b.mlab.sandbox.measurement-lab.org",
+		"ndt-lga3272-4f20bd8c.foo.sandbox.measurement-lab.org",
+	}
+	status := []tracker.Status{
+		{DNS: &tracker.DNSRecord{ClientVersion: "0.1.0"}},
+		{DNS: &tracker.DNSRecord{ClientVersion: "0.2.0"}},
+		{DNS: &tracker.DNSRecord{}},
+		{DNS: &tracker.DNSRecord{ClientVersion: "0.1.0"}},
+	}
+	tests := []struct {
+		name      string
+		Tracker   *fakeStatusTracker
+		method    string
+		params    string
+		wantCode  int
+		wantOrgs  map[string]map[string]int
+		wantOlder map[string]int
+	}{
+		{
+			name:     "success",
+			Tracker:  &fakeStatusTracker{nodes: nodes, status: status},
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			wantOrgs: map[string]map[string]int{
+				"mlab": {"0.1.0": 1, "0.2.0": 1, "unknown": 1},
+				"foo":  {"0.1.0": 1},
+			},
+		},
+		{
+			name:      "success-org-older",
+			Tracker:   &fakeStatusTracker{nodes: nodes, status: status},
+			method:    http.MethodGet,
+			params:    "?org=mlab&min_client_version=0.2.0",
+			wantCode:  http.StatusOK,
+			wantOrgs:  map[string]map[string]int{"mlab": {"0.1.0": 1, "0.2.0": 1, "unknown": 1}},
+			wantOlder: map[string]int{"mlab": 1},
+		},
+		{
+			name:     "error-method",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodPost,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-version",
+			Tracker:  &fakeStatusTracker{},
+			method:   http.MethodGet,
+			params:   "?min_client_version=latest",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-list",
+			Tracker:  &fakeStatusTracker{listErr: errors.New("fake list error")},
+			method:   http.MethodGet,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, tt.Tracker, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/client-versions"+tt.params, nil)
+
+			s.ClientVersions(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("ClientVersions() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.ClientVersionsResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Orgs, tt.wantOrgs) || !reflect.DeepEqual(resp.Older, tt.wantOlder) {
+				t.Errorf("ClientVersions() = %v, %v; want %v, %v", resp.Orgs, resp.Older, tt.wantOrgs, tt.wantOlder)
+			}
+		})
+	}
+}
+
+func TestServer_Datasets(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		reload   bool
+		wantCode int
+		want     []v0.Dataset
+	}{
+		{
+			name:     "success",
+			method:   http.MethodGet,
+			reload:   true,
+			wantCode: http.StatusOK,
+			want: []v0.Dataset{
+				{Name: "iata", Source: "https://example.com/iata.csv", Status: "ok"},
+				{Name: "maxmind", Status: "ok"},
+				{Name: "asn", Status: "ok"},
+				{Name: "geonames", Entries: 3, Status: "ok"},
+			},
+		},
+		{
+			name:     "success-not-loaded",
+			method:   http.MethodGet,
+			wantCode: http.StatusOK,
+			want: []v0.Dataset{
+				{Name: "iata", Source: "https://example.com/iata.csv", Status: "not loaded"},
+				{Name: "maxmind", Status: "not loaded"},
+				{Name: "asn", Status: "not loaded"},
+				{Name: "geonames", Entries: 3, Status: "not loaded"},
+			},
+		},
+		{
+			name:     "error-method",
+			method:   http.MethodPost,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{}, &fakeMaxmind{}, &fakeAsn{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+			s.Geonames = &fakeGeonames{cities: 3}
+			s.DatasetSources = map[string]string{"iata": "https://example.com/iata.csv"}
+			if tt.reload {
+				s.Reload(context.Background())
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/datasets", nil)
+
+			s.Datasets(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Datasets() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.DatasetsResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			for i := range resp.Datasets {
+				d := &resp.Datasets[i]
+				if tt.reload && (d.Loaded == nil || time.Since(*d.Loaded) > time.Minute) {
+					t.Errorf("Datasets() returned wrong load time of %s; got %v", d.Name, d.Loaded)
+				}
+				d.Loaded, d.AgeSeconds = nil, 0
+			}
+			if !reflect.DeepEqual(resp.Datasets, tt.want) {
+				t.Errorf("Datasets() = %+v, want %+v", resp.Datasets, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/cache"
+	"github.com/m-lab/autojoin/internal/keys"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// APIKeyValidator is an interface used to find the organization that owns an
+// API key and the scopes granted to it.
+type APIKeyValidator interface {
+	ValidateKey(ctx context.Context, key string) (*keys.Info, error)
+}
+
+// APIKeyInvalidator is an interface used to forget the cached validation of
+// an API key, e.g. after it is revoked.
+type APIKeyInvalidator interface {
+	InvalidateKey(id string) error
+}
+
+type keyInfoKey struct{}
+
+// errKeyNotCached is returned to the ID cache for keys that are not cached.
+var errKeyNotCached = errors.New("api key is not cached")
+
+// CachedAPIKeyValidator is an APIKeyValidator that caches validated keys.
+type CachedAPIKeyValidator struct {
+	v     APIKeyValidator
+	cache *cache.Cache[keys.Info]
+	// ids caches the hash of the key string of each key ID, so that keys may
+	// be invalidated by ID.
+	ids *cache.Cache[string]
+}
+
+// NewCachedAPIKeyValidator returns an APIKeyValidator that caches the keys
+// validated by v in c, and their hashes by key ID in ids. Keys are hashed so
+// that key strings are never saved in the cache. Unknown keys are not cached,
+// and cached keys are rejected once they expire. Keys revoked with
+// InvalidateKey may still be accepted by other instances until their cache
+// entry expires.
+func NewCachedAPIKeyValidator(v APIKeyValidator, c *cache.Cache[keys.Info], ids *cache.Cache[string]) *CachedAPIKeyValidator {
+	return &CachedAPIKeyValidator{v: v, cache: c, ids: ids}
+}
+
+func (c *CachedAPIKeyValidator) ValidateKey(ctx context.Context, key string) (*keys.Info, error) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	info, err := c.cache.Get(hash, func() (keys.Info, error) {
+		info, err := c.v.ValidateKey(ctx, key)
+		if err != nil {
+			return keys.Info{}, err
+		}
+		return *info, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.ID != "" {
+		c.ids.Get(info.ID, func() (string, error) { return hash, nil })
+	}
+	if info.Expired(time.Now()) {
+		return nil, keys.ErrExpired
+	}
+	return &info, nil
+}
+
+// InvalidateKey removes the key with the given ID from the cache of this
+// instance and the shared cache.
+func (c *CachedAPIKeyValidator) InvalidateKey(id string) error {
+	hash, err := c.ids.Get(id, func() (string, error) { return "", errKeyNotCached })
+	if errors.Is(err, errKeyNotCached) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.cache.Invalidate(hash); err != nil {
+		return err
+	}
+	return c.ids.Invalidate(id)
+}
+
+// WithAPIKeyValidation returns a handler that finds the organization and
+// scopes of the "key" parameter before calling next. Handlers may use the
+// organization to verify ownership of the resources they modify. Requests
+// without a key or with an unknown key are rejected. Requests validated
+// already, e.g. by ProjectRouter, are not validated again.
+//
+// NOTE: Cloud Endpoints verifies that the key is valid for this API; this
+// handler only establishes which organization the key belongs to.
+func WithAPIKeyValidation(v APIKeyValidator, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if _, ok := req.Context().Value(keyInfoKey{}).(*keys.Info); ok {
+			next(rw, req)
+			return
+		}
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+			return
+		}
+		info, err := v.ValidateKey(req.Context(), key)
+		switch {
+		case errors.Is(err, keys.ErrExpired), errors.Is(err, keys.ErrRevoked):
+			writeAuthError(rw, http.StatusUnauthorized, v0.ErrInvalidAPIKey, err.Error())
+			return
+		case err != nil:
+			log.Println("api key validation failure:", err)
+			writeAuthError(rw, http.StatusUnauthorized, v0.ErrInvalidAPIKey, "api key does not belong to a known organization")
+			return
+		}
+		setRequestOrg(req.Context(), info.Org)
+		ctx := context.WithValue(req.Context(), keyInfoKey{}, info)
+		next(rw, req.WithContext(ctx))
+	}
+}
+
+// RequireScope returns a handler that calls next only if the API key found by
+// WithAPIKeyValidation is granted the scope.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		info, ok := req.Context().Value(keyInfoKey{}).(*keys.Info)
+		if !ok || !info.Allows(scope) {
+			writeAuthError(rw, http.StatusForbidden, v0.ErrMissingScope, "api key is not granted the "+scope+" scope")
+			return
+		}
+		next(rw, req)
+	}
+}
+
+// orgFromContext returns the organization found by WithAPIKeyValidation.
+func orgFromContext(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(keyInfoKey{}).(*keys.Info)
+	if !ok {
+		return "", false
+	}
+	return info.Org, true
+}
+
+func writeAuthError(rw http.ResponseWriter, status int, errType, title string) {
+	rw.Header().Set("Content-Type", "application/json")
+	resp := struct {
+		Error *v2.Error
+	}{
+		Error: &v2.Error{
+			Type:   errType,
+			Title:  title,
+			Status: status,
+		},
+	}
+	rw.WriteHeader(resp.Error.Status)
+	writeResponse(rw, resp)
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/cache"
+	"github.com/m-lab/autojoin/internal/keys"
+)
+
+type fakeKeyValidator struct {
+	org     string
+	scopes  []string
+	expires time.Time
+	err     error
+	calls   int
+}
+
+func (f *fakeKeyValidator) ValidateKey(ctx context.Context, key string) (*keys.Info, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	scopes := f.scopes
+	if scopes == nil {
+		scopes = keys.DefaultScopes
+	}
+	return &keys.Info{ID: "autojoin-key-" + f.org, Org: f.org, Scopes: scopes, ExpiresAt: f.expires}, nil
+}
+
+func TestWithAPIKeyValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator *fakeKeyValidator
+		qs        string
+		wantCode  int
+		wantOrg   string
+	}{
+		{
+			name:      "success",
+			validator: &fakeKeyValidator{org: "mlab"},
+			qs:        "?key=12345",
+			wantCode:  http.StatusOK,
+			wantOrg:   "mlab",
+		},
+		{
+			name:      "error-missing-key",
+			validator: &fakeKeyValidator{org: "mlab"},
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "error-unknown-key",
+			validator: &fakeKeyValidator{err: errors.New("fake lookup error")},
+			qs:        "?key=12345",
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "error-expired-key",
+			validator: &fakeKeyValidator{err: keys.ErrExpired},
+			qs:        "?key=12345",
+			wantCode:  http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotOrg := ""
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				gotOrg, _ = orgFromContext(req.Context())
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete"+tt.qs, nil)
+			WithAPIKeyValidation(tt.validator, next)(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("WithAPIKeyValidation() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if gotOrg != tt.wantOrg {
+				t.Errorf("WithAPIKeyValidation() set wrong org; got %q, want %q", gotOrg, tt.wantOrg)
+			}
+		})
+	}
+}
+
+func TestWithAPIKeyValidation_validated(t *testing.T) {
+	v := &fakeKeyValidator{org: "other"}
+	gotOrg := ""
+	next := func(rw http.ResponseWriter, req *http.Request) {
+		gotOrg, _ = orgFromContext(req.Context())
+	}
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/admin/org?key=12345", nil)
+	req = req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{Org: "mlab"}))
+	WithAPIKeyValidation(v, next)(rw, req)
+
+	if v.calls != 0 || gotOrg != "mlab" {
+		t.Errorf("WithAPIKeyValidation() validated key again; got %d calls, org %q", v.calls, gotOrg)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator *fakeKeyValidator
+		scope     string
+		wantCode  int
+	}{
+		{
+			name:      "success-all-scopes",
+			validator: &fakeKeyValidator{org: "mlab"},
+			scope:     keys.ScopeDelete,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "success-register",
+			validator: &fakeKeyValidator{org: "mlab", scopes: []string{keys.ScopeRegister}},
+			scope:     keys.ScopeRegister,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "error-register-only",
+			validator: &fakeKeyValidator{org: "mlab", scopes: []string{keys.ScopeRegister}},
+			scope:     keys.ScopeDelete,
+			wantCode:  http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := func(rw http.ResponseWriter, req *http.Request) {}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete?key=12345", nil)
+			WithAPIKeyValidation(tt.validator, RequireScope(tt.scope, next))(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("RequireScope() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+
+	// Requests without a validated key are rejected.
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete", nil)
+	RequireScope(keys.ScopeDelete, func(rw http.ResponseWriter, req *http.Request) {})(rw, req)
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("RequireScope() returned wrong code; got %d, want %d", rw.Code, http.StatusForbidden)
+	}
+}
+
+func TestServer_DeleteOwnership(t *testing.T) {
+	s := NewServer("mlab-sandbox", nil, nil, nil, &fakeDNS{}, &fakeStatusTracker{}, nil)
+	tests := []struct {
+		name     string
+		org      string
+		wantCode int
+	}{
+		{name: "success-owner", org: "mlab", wantCode: http.StatusOK},
+		{name: "error-other-org", org: "other", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := WithAPIKeyValidation(&fakeKeyValidator{org: tt.org}, s.Delete)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete?key=12345&hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org", nil)
+			h(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Delete() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNewCachedAPIKeyValidator(t *testing.T) {
+	f := &fakeKeyValidator{org: "mlab"}
+	v := NewCachedAPIKeyValidator(f, cache.New[keys.Info]("keys", time.Minute, nil), cache.New[string]("key-ids", time.Minute, nil))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if info, err := v.ValidateKey(ctx, "12345"); err != nil || info.Org != "mlab" {
+			t.Errorf("ValidateKey() = %v, %v; want mlab, nil", info, err)
+		}
+	}
+	if f.calls != 1 {
+		t.Errorf("ValidateKey() called validator %d times; want 1", f.calls)
+	}
+
+	// Unknown keys are not cached.
+	f.err = errors.New("fake unknown key")
+	for i := 0; i < 2; i++ {
+		if _, err := v.ValidateKey(ctx, "67890"); err != f.err {
+			t.Errorf("ValidateKey() returned wrong error; got %v, want %v", err, f.err)
+		}
+	}
+	if f.calls != 3 {
+		t.Errorf("ValidateKey() called validator %d times; want 3", f.calls)
+	}
+
+	// Invalidated keys are validated again.
+	f.err = nil
+	if err := v.InvalidateKey("autojoin-key-mlab"); err != nil {
+		t.Errorf("InvalidateKey() returned error: %v", err)
+	}
+	if _, err := v.ValidateKey(ctx, "12345"); err != nil {
+		t.Errorf("ValidateKey() returned error: %v", err)
+	}
+	if f.calls != 4 {
+		t.Errorf("ValidateKey() called validator %d times; want 4", f.calls)
+	}
+	if err := v.InvalidateKey("autojoin-key-unknown"); err != nil {
+		t.Errorf("InvalidateKey() returned error for uncached key: %v", err)
+	}
+
+	// Cached keys are rejected once expired.
+	v = NewCachedAPIKeyValidator(&fakeKeyValidator{org: "mlab", expires: time.Now().Add(-time.Hour)}, cache.New[keys.Info]("keys", time.Minute, nil), cache.New[string]("key-ids", time.Minute, nil))
+	if _, err := v.ValidateKey(ctx, "12345"); err != keys.ErrExpired {
+		t.Errorf("ValidateKey() returned wrong error; got %v, want %v", err, keys.ErrExpired)
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultListCacheTTL is short enough that newly registered nodes appear
+// promptly, while absorbing monitoring systems that poll every 15s.
+const defaultListCacheTTL = 10 * time.Second
+
+// maxListCacheBytes bounds the size of each cached List result. Larger results
+// are streamed to clients without being cached.
+const maxListCacheBytes = 16 << 20
+
+// listEntry is a rendered List result.
+type listEntry struct {
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// listCache maintains rendered List results keyed by query parameters.
+type listCache struct {
+	mu      sync.Mutex
+	entries map[string]*listEntry
+}
+
+func newListCache() *listCache {
+	return &listCache{entries: map[string]*listEntry{}}
+}
+
+// get returns the cached entry for key if it has not yet expired.
+func (c *listCache) get(key string) (*listEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e, true
+}
+
+// put saves the rendered body for key and returns the new entry. Expired
+// entries are removed on every put so the cache size is bounded by the
+// number of distinct queries within one TTL.
+func (c *listCache) put(key string, body []byte, ttl time.Duration) *listEntry {
+	sum := sha256.Sum256(body)
+	now := time.Now()
+	e := &listEntry{
+		body:    body,
+		etag:    `"` + hex.EncodeToString(sum[:16]) + `"`,
+		expires: now.Add(ttl),
+	}
+	if ttl <= 0 {
+		return e
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.entries {
+		if now.After(v.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+	return e
+}
+
+// boundedBuffer saves up to limit bytes written to it. Writes past the limit
+// discard the saved bytes but never fail, so that a boundedBuffer may be
+// combined with the response in an io.MultiWriter.
+type boundedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if b.overflow || b.buf.Len()+len(p) > b.limit {
+		b.overflow = true
+		b.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the saved bytes, or nil after an overflow.
+func (b *boundedBuffer) Bytes() []byte {
+	if b.overflow {
+		return nil
+	}
+	return b.buf.Bytes()
+}
+
+// etagMatches reports whether the If-None-Match header value matches etag.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_listCache(t *testing.T) {
+	c := newListCache()
+	if _, ok := c.get("format=servers"); ok {
+		t.Errorf("get() found entry in empty cache")
+	}
+	e := c.put("format=servers", []byte("body"), time.Minute)
+	got, ok := c.get("format=servers")
+	if !ok || got != e {
+		t.Errorf("get() = %v, %t; want %v, true", got, ok, e)
+	}
+	// An identical body produces an identical etag.
+	e2 := c.put("format=sites", []byte("body"), time.Minute)
+	if e.etag != e2.etag {
+		t.Errorf("put() etags differ for identical bodies; %q != %q", e.etag, e2.etag)
+	}
+
+	// Expired entries are not returned, and are removed by the next put.
+	c.entries["expired"] = &listEntry{expires: time.Now().Add(-time.Second)}
+	if _, ok := c.get("expired"); ok {
+		t.Errorf("get() returned expired entry")
+	}
+	c.put("format=prometheus", []byte("new"), time.Minute)
+	if _, ok := c.entries["expired"]; ok {
+		t.Errorf("put() did not remove expired entry")
+	}
+
+	// A zero ttl disables caching.
+	c.put("uncached", []byte("body"), 0)
+	if _, ok := c.entries["uncached"]; ok {
+		t.Errorf("put() cached entry with zero ttl")
+	}
+}
+
+func Test_etagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: `"abc"`, want: true},
+		{header: `W/"abc"`, want: true},
+		{header: `"xyz", "abc"`, want: true},
+		{header: `"xyz"`, want: false},
+		{header: "*", want: true},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestServer_ListCached(t *testing.T) {
+	lister := &fakeStatusTracker{
+		nodes:  []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+		status: withPorts([]string{"9990"}),
+	}
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, lister, nil)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=prometheus", nil)
+	s.List(rw, req)
+	if rw.Code != http.StatusOK || rw.Header().Get("ETag") != "" {
+		t.Fatalf("List() = %d with etag %q, want streamed 200 without etag", rw.Code, rw.Header().Get("ETag"))
+	}
+	streamed := rw.Body.String()
+
+	// The streamed results were cached, and are served with their etag.
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=prometheus", nil)
+	s.List(rw, req)
+	etag := rw.Header().Get("ETag")
+	if rw.Code != http.StatusOK || etag == "" || rw.Body.String() != streamed {
+		t.Fatalf("List() = %d with etag %q, want cached 200 with etag", rw.Code, etag)
+	}
+
+	// A second request with the etag is served from the cache as not modified.
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=prometheus", nil)
+	req.Header.Set("If-None-Match", etag)
+	s.List(rw, req)
+	if rw.Code != http.StatusNotModified || rw.Body.Len() != 0 {
+		t.Errorf("List() = %d with %d bytes, want 304 with empty body", rw.Code, rw.Body.Len())
+	}
+	if lister.lists != 1 {
+		t.Errorf("List() read tracker %d times, want 1", lister.lists)
+	}
+
+	// A different query is rendered separately. Conditional requests are
+	// rendered completely first to return the etag.
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=servers", nil)
+	req.Header.Set("If-None-Match", etag)
+	s.List(rw, req)
+	if rw.Code != http.StatusOK || lister.lists != 2 || rw.Header().Get("ETag") == "" {
+		t.Errorf("List() = %d with etag %q after %d tracker reads, want 200 with etag after 2",
+			rw.Code, rw.Header().Get("ETag"), lister.lists)
+	}
+}
+
+func Test_boundedBuffer(t *testing.T) {
+	b := &boundedBuffer{limit: 8}
+	for _, s := range []string{"abcd", "efgh"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Errorf("Write() = %d, %v; want %d, nil", n, err, len(s))
+		}
+	}
+	if string(b.Bytes()) != "abcdefgh" {
+		t.Errorf("Bytes() = %q, want %q", b.Bytes(), "abcdefgh")
+	}
+
+	// Writes past the limit do not fail, but nothing is saved.
+	if n, err := b.Write([]byte("i")); n != 1 || err != nil {
+		t.Errorf("Write() = %d, %v; want 1, nil", n, err)
+	}
+	if !b.overflow || b.Bytes() != nil {
+		t.Errorf("Bytes() = %q after overflow, want nil", b.Bytes())
+	}
+}
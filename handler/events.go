@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+const (
+	// eventsBuffer is how many events a stream may fall behind before it is
+	// closed.
+	eventsBuffer = 256
+	// eventsKeepAlive is the interval of comments that keep idle streams open
+	// through proxies.
+	eventsKeepAlive = 30 * time.Second
+)
+
+// Events handler streams node events as Server-Sent Events until the client
+// disconnects. Each event is sent with the event type as the SSE event name,
+// and the v0.NodeEvent as JSON data. The optional "org" parameter limits the
+// stream to the nodes of one organization. Streams that fall behind are
+// closed; clients should reconnect and use List to resynchronize.
+func (s *Server) Events(rw http.ResponseWriter, req *http.Request) {
+	f, ok := rw.(http.Flusher)
+	var e *v2.Error
+	org := req.URL.Query().Get("org")
+	switch {
+	case s.NodeEvents == nil:
+		e = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "node events are not enabled",
+			Status: http.StatusNotImplemented,
+		}
+	case req.Method != http.MethodGet:
+		e = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+	case org != "" && !isValidName(org):
+		e = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid organization filter",
+			Status: http.StatusBadRequest,
+		}
+	case !ok:
+		e = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "streaming is not supported",
+			Status: http.StatusInternalServerError,
+		}
+	}
+	if e != nil {
+		// The stream has no response type of its own.
+		resp := struct {
+			Error *v2.Error
+		}{Error: e}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(e.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	events, cancel := s.NodeEvents.Subscribe(eventsBuffer)
+	defer cancel()
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	// Send the headers before the first event.
+	fmt.Fprint(rw, ": connected\n\n")
+	f.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.closing:
+			// The server is shutting down; clients should reconnect.
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(rw, ": keep-alive\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				// The stream fell behind.
+				return
+			}
+			if org != "" && ev.Org != org {
+				continue
+			}
+			b, _ := json.Marshal(ev)
+			fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", ev.Type, b)
+		}
+		f.Flush()
+	}
+}
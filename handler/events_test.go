@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/uuid-annotator/annotator"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// fakeEventStream records published events, and streams the given events to
+// subscribers before closing their channel, unless open.
+type fakeEventStream struct {
+	published []v0.NodeEvent
+	stream    []v0.NodeEvent
+	err       error
+	open      bool
+}
+
+func (f *fakeEventStream) Publish(ctx context.Context, e v0.NodeEvent) error {
+	f.published = append(f.published, e)
+	return f.err
+}
+
+func (f *fakeEventStream) Subscribe(buffer int) (<-chan v0.NodeEvent, func()) {
+	c := make(chan v0.NodeEvent, len(f.stream))
+	for _, e := range f.stream {
+		c <- e
+	}
+	if !f.open {
+		close(c)
+	}
+	return c, func() {}
+}
+
+func TestServer_Events(t *testing.T) {
+	stream := []v0.NodeEvent{
+		{Type: v0.EventRegister, Hostname: "ndt-lga3269-4f20bd89.foo.sandbox.measurement-lab.org", Org: "foo"},
+		{Type: v0.EventExpire, Hostname: "ndt-lga3269-4f20bd8a.bar.sandbox.measurement-lab.org", Org: "bar", Reason: "expired"},
+	}
+	tests := []struct {
+		name       string
+		events     EventStream
+		method     string
+		params     string
+		wantCode   int
+		wantEvents []string
+	}{
+		{
+			name:       "success",
+			events:     &fakeEventStream{stream: stream},
+			method:     http.MethodGet,
+			wantCode:   http.StatusOK,
+			wantEvents: []string{v0.EventRegister, v0.EventExpire},
+		},
+		{
+			name:       "success-org",
+			events:     &fakeEventStream{stream: stream},
+			method:     http.MethodGet,
+			params:     "?org=bar",
+			wantCode:   http.StatusOK,
+			wantEvents: []string{v0.EventExpire},
+		},
+		{
+			name:     "error-not-enabled",
+			method:   http.MethodGet,
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-method",
+			events:   &fakeEventStream{},
+			method:   http.MethodPost,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-org",
+			events:   &fakeEventStream{},
+			method:   http.MethodGet,
+			params:   "?org=-BAD-",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, &fakeStatusTracker{}, nil)
+			s.NodeEvents = tt.events
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/node/events"+tt.params, nil)
+
+			s.Events(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Events() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			if ct := rw.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Events() returned wrong content type; got %q", ct)
+			}
+			got := []string{}
+			for _, line := range strings.Split(rw.Body.String(), "\n") {
+				if name, ok := strings.CutPrefix(line, "event: "); ok {
+					got = append(got, name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("Events() streamed wrong events; got %v, want %v", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterEvents(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g"
+	tests := []struct {
+		name      string
+		tracker   *fakeStatusTracker
+		events    *fakeEventStream
+		wantEvent string
+	}{
+		{
+			name:      "success-register",
+			tracker:   &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			events:    &fakeEventStream{},
+			wantEvent: v0.EventRegister,
+		},
+		{
+			name:      "success-renew",
+			tracker:   &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			events:    &fakeEventStream{},
+			wantEvent: v0.EventRenew,
+		},
+		{
+			name:      "success-publish-error",
+			tracker:   &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			events:    &fakeEventStream{err: errors.New("fake error")},
+			wantEvent: v0.EventRegister,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{}, tt.tracker, &fakeSecretManager{key: "fake key data"})
+			s.NodeEvents = tt.events
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != http.StatusOK {
+				t.Fatalf("Register() returned wrong code; got %d, want %d", rw.Code, http.StatusOK)
+			}
+			if len(tt.events.published) != 1 {
+				t.Fatalf("Register() published wrong number of events; got %d, want 1", len(tt.events.published))
+			}
+			e := tt.events.published[0]
+			if e.Type != tt.wantEvent || e.Org != "mlab" || e.Time.IsZero() {
+				t.Errorf("Register() published wrong event; got %+v, want type %q", e, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -1,36 +1,131 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	v0 "github.com/m-lab/autojoin/api/v0"
 	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/config"
 	"github.com/m-lab/autojoin/internal/dnsname"
 	"github.com/m-lab/autojoin/internal/dnsx"
 	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/geonames"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/operation"
+	"github.com/m-lab/autojoin/internal/orgs"
 	"github.com/m-lab/autojoin/internal/register"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/autojoin/internal/uplink"
 	"github.com/m-lab/gcp-service-discovery/discovery"
 	"github.com/m-lab/go/host"
 	"github.com/m-lab/go/rtx"
 	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/uuid-annotator/annotator"
 	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/sync/errgroup"
 )
 
 var (
 	errLocationNotFound = errors.New("location not found")
 	errLocationFormat   = errors.New("location could not be parsed")
 
-	validName = regexp.MustCompile(`[a-z0-9]+`)
+	validName    = regexp.MustCompile(`[a-z0-9]+`)
+	validSite    = regexp.MustCompile(`^[a-z]{3}[0-9]+$`)
+	validMetro   = regexp.MustCompile(`^[a-z]{3}$`)
+	validCountry = regexp.MustCompile(`^[A-Z]{2}$`)
+	validLabel   = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	validCloud   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+	// asyncDeleteBackoff is the delay after the first failed asynchronous
+	// delete attempt. It doubles after each attempt.
+	asyncDeleteBackoff = 5 * time.Second
+	// asyncDeleteTimeout bounds all attempts of an asynchronous delete.
+	asyncDeleteTimeout = 5 * time.Minute
+
+	// defaultProxyHops is the number of proxy addresses after the client
+	// address in X-Forwarded-For, e.g. "<client>, 169.254.1.1" on App Engine.
+	defaultProxyHops = 1
+
+	errLabelFormat   = errors.New("label must have the form <key>=<value>")
+	errLabelKey      = errors.New("label key must match [a-z_][a-z0-9_]*")
+	errLabelReserved = errors.New("label key is reserved")
+	errLabelValue    = errors.New("label value is too long")
+	errLabelCount    = errors.New("too many labels")
+
+	// targetLabels are the optional node fields that List may add as target
+	// labels, selected with the "labels" parameter.
+	targetLabels = map[string]func(h host.Name, r *tracker.DNSRecord) string{
+		"site":           func(h host.Name, r *tracker.DNSRecord) string { return h.Site },
+		"metro":          func(h host.Name, r *tracker.DNSRecord) string { return h.Site[:3] },
+		"country":        func(h host.Name, r *tracker.DNSRecord) string { return r.Country },
+		"machine_type":   func(h host.Name, r *tracker.DNSRecord) string { return r.Type },
+		"uplink":         func(h host.Name, r *tracker.DNSRecord) string { return r.Uplink },
+		"cloud_provider": func(h host.Name, r *tracker.DNSRecord) string { return r.CloudProvider },
+		"cloud_region":   func(h host.Name, r *tracker.DNSRecord) string { return r.CloudRegion },
+	}
+
+	// reservedLabels are set by List and may not be overridden by nodes.
+	reservedLabels = map[string]bool{
+		"machine":     true,
+		"type":        true,
+		"deployment":  true,
+		"managed":     true,
+		"org":         true,
+		"service":     true,
+		"maintenance": true,
+		"dns_pending": true,
+	}
+)
+
+const (
+	maxLabels          = 10
+	maxLabelValueBytes = 64
+	maxDiffBodyBytes   = 1 << 20
+
+	// maxAliases limits the other services of a node, whose names are all
+	// listed in the TXT record of the node.
+	maxAliases = 8
+	// maxCityBytes limits the city name given by the operator of a node.
+	maxCityBytes = 64
+
+	// maxLookupResults limits the airports returned by a lookup.
+	maxLookupResults = 10
+	// maxBatchLookups limits the locations of a batch lookup.
+	maxBatchLookups   = 1000
+	maxBatchBodyBytes = 1 << 20
+
+	// Distances in km of an IATA airport from the geolocation of a node
+	// above which the metro is suspicious or wrong.
+	metroWarnDistance = 300
+	metroFailDistance = 1000
+
+	// maxMaintenanceWindow limits how long a node may be kept without
+	// registering.
+	maxMaintenanceWindow = 30 * 24 * time.Hour
+
+	// asyncDeleteAttempts is how many times an asynchronous delete is tried
+	// before the operation fails.
+	asyncDeleteAttempts = 3
+	// operationFinishTimeout bounds saving the result of an operation, which
+	// is saved even after the operation itself timed out.
+	operationFinishTimeout = 10 * time.Second
 )
 
 // Server maintains shared state for the server.
@@ -41,8 +136,137 @@ type Server struct {
 	ASN     ASNFinder
 	DNS     dnsiface.Service
 
+	// Domain is the base domain of registered hostnames, e.g.
+	// "measurement-lab.org". NewServer sets dnsname.DefaultDomain.
+	Domain string
+
+	// SiteRecords enables the round-robin site records with the addresses
+	// of every node of a service at a site.
+	SiteRecords bool
+
+	// VerifyDNS is how long Register waits for Cloud DNS to report the
+	// change of the DNS records of a node as done. Zero disables
+	// verification, and changes are assumed to be done once created.
+	VerifyDNS time.Duration
+
+	// RegisterTimeout limits the time Register waits for the backend calls
+	// of a registration. Zero means no limit beyond the request context.
+	RegisterTimeout time.Duration
+
+	// ListCacheTTL is how long rendered List results are reused before
+	// reading the tracker again. Zero disables caching.
+	ListCacheTTL time.Duration
+
+	// ProxyHops is the number of addresses that trusted proxies append to
+	// the X-Forwarded-For header after the address of the client. On App
+	// Engine, the front end appends the client address and its own address.
+	ProxyHops int
+
+	// Decommission records hostnames removed by Delete. When nil, no
+	// records are kept.
+	Decommission tracker.Reporter
+
+	// RuntimeConfig manages settings that operators may change at runtime.
+	// When nil, the Config handler is disabled.
+	RuntimeConfig RuntimeConfig
+
+	// DNSQueue changes the DNS records of registrations after Register
+	// responds. When nil, Register changes DNS records before responding.
+	DNSQueue DNSQueue
+
+	// Operations saves the status of asynchronous requests. When nil,
+	// asynchronous deletes are disabled.
+	Operations OperationStore
+
+	// Orgs manages per-organization settings. When nil, all organizations
+	// use the default settings.
+	Orgs OrgSettings
+
+	// History records changes of organization probability multipliers.
+	// When nil, changes are not recorded and the OrgHistory handler is
+	// disabled.
+	History MultiplierHistory
+
+	// APIKeys creates, lists, and revokes API keys. When nil, the Keys
+	// handler is disabled.
+	APIKeys KeyManager
+
+	// KeyCache forgets the cached validation of API keys revoked by the Keys
+	// handler. When nil, revoked keys are accepted until their cache entry
+	// expires.
+	KeyCache APIKeyInvalidator
+
+	// NodeKeys issues a separate service account key to each node of
+	// organizations with the NodeKeys setting. When nil, all nodes receive
+	// the key of their organization.
+	NodeKeys NodeKeyIssuer
+
+	// AccessTokens generates short-lived access tokens for nodes of
+	// organizations with the AccessTokens setting. When nil, nodes receive
+	// service account keys and the Token handler is disabled.
+	AccessTokens AccessTokenGenerator
+
+	// KeyRotation replaces organization service account keys on request.
+	// When nil, the Rotate handler is disabled.
+	KeyRotation KeyRotator
+
+	// Tokens redeems single-use provisioning tokens. When nil, the
+	// Provision handler is disabled.
+	Tokens ProvisioningTokens
+
+	// Signups records applications of new organizations. When nil, the
+	// Apply and Applications handlers are disabled.
+	Signups OrgApplications
+
+	// OrgSetup creates the organizations of approved applications. When
+	// nil, applications may be rejected but not approved.
+	OrgSetup OrgCreator
+
+	// Emails verifies the contact emails of organizations. When nil, emails
+	// are not verified and the Verify handler is disabled.
+	Emails EmailVerifier
+
+	// NodeEvents publishes registrations and streams them, along with the
+	// removals published by the Decommission reporter, to the Events
+	// handler. When nil, events are disabled.
+	NodeEvents EventStream
+
+	// OpenAPI is the OpenAPI document of the API as JSON. When nil, the Spec
+	// handler is disabled.
+	OpenAPI []byte
+
+	// BuildVersion is the version of the server, and MinClientVersion the
+	// oldest version of the client package it accepts. Both are reported by
+	// the Version handler. MinClientVersion is unused with a RuntimeConfig.
+	BuildVersion     string
+	MinClientVersion string
+
+	// Checks verify the dependencies of the server, e.g. memorystore, by
+	// name. Ready fails while any check fails.
+	Checks map[string]Check
+
+	// Geonames names the city and region of nodes whose address Maxmind
+	// cannot name, from the city nearest their metro. When nil, the names
+	// are left empty.
+	Geonames GeonamesFinder
+
+	// DatasetMaxAge is the age after which datasets that could not be
+	// reloaded are reported as stale by Ready. Zero disables the check.
+	DatasetMaxAge time.Duration
+
+	// DatasetSources are the URLs of the datasets by name, e.g. "iata",
+	// reported by the Datasets handler.
+	DatasetSources map[string]string
+
+	datasetsMu sync.Mutex
+	datasets   map[string]time.Time
+
 	sm         ServiceAccountSecretManager
 	dnsTracker DNSTracker
+	listCache  *listCache
+	ops        sync.WaitGroup
+	closing    chan struct{}
+	closeOnce  sync.Once
 }
 
 // ASNFinder is an interface used by the Server to manage ASN information.
@@ -51,23 +275,78 @@ type ASNFinder interface {
 	Reload(ctx context.Context)
 }
 
+// ASNLoader is implemented by ASNFinders that report errors when loading their
+// dataset, e.g. the GeoLite2 ASN dataset.
+type ASNLoader interface {
+	Load(ctx context.Context) error
+}
+
 // MaxmindFinder is an interface used by the Server to manage Maxmind information.
 type MaxmindFinder interface {
 	City(ip net.IP) (*geoip2.City, error)
+	AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error)
 	Reload(ctx context.Context) error
 }
 
+// GeonamesFinder is an interface used by the Server to manage GeoNames
+// information.
+type GeonamesFinder interface {
+	Nearest(country string, lat, lon float64) (geonames.Place, error)
+	Load(ctx context.Context) error
+}
+
+// DatasetCounter is implemented by datasets that report their size, e.g. the
+// number of airports, to the Datasets handler.
+type DatasetCounter interface {
+	Len() int
+}
+
 // IataFinder is an interface used by the Server to manage IATA information.
 type IataFinder interface {
-	Lookup(country string, lat, lon float64) (string, error)
+	Nearest(country string, lat, lon float64, n int) ([]iata.Airport, error)
 	Find(iata string) (iata.Row, error)
+	FindByICAO(icao string) (iata.Row, error)
+	Search(query string, n int) []iata.Row
 	Load(ctx context.Context) error
 }
 
+// DNSTracker is an interface used by the Server to track registered hostnames.
 type DNSTracker interface {
-	Update(string, []string) error
+	Get(string) (*tracker.Status, error)
+	Update(string, *tracker.DNSRecord) error
 	Delete(string) error
-	List() ([]string, [][]string, error)
+	List() ([]string, []tracker.Status, error)
+	Hostnames(string) []string
+	SetMaintenance(string, *tracker.Window) error
+	SetOverride(string, *tracker.Override) error
+}
+
+// DNSQueue is an interface used by the Server to change the DNS records of
+// registrations in the background.
+type DNSQueue interface {
+	Enqueue(hostname string)
+}
+
+// OperationStore is an interface used by the Server to save the status of
+// asynchronous requests.
+type OperationStore interface {
+	Create(ctx context.Context, opType, hostname, org string) (*v0.Operation, error)
+	Get(ctx context.Context, id string) (*v0.Operation, error)
+	Finish(ctx context.Context, op *v0.Operation, err error) error
+}
+
+// OrgSettings is an interface used by the Server to read and change
+// per-organization settings.
+type OrgSettings interface {
+	Get(ctx context.Context, org string) (orgs.Settings, error)
+	Set(ctx context.Context, org string, s orgs.Settings) error
+}
+
+// MultiplierHistory is an interface used by the Server to record and list
+// changes of organization probability multipliers.
+type MultiplierHistory interface {
+	Add(ctx context.Context, org string, c orgs.MultiplierChange) error
+	List(ctx context.Context, org string) ([]orgs.MultiplierChange, error)
 }
 
 // ServiceAccountSecretManager is an interface used by the server to allocate service account keys.
@@ -75,6 +354,19 @@ type ServiceAccountSecretManager interface {
 	LoadOrCreateKey(ctx context.Context, org string) (string, error)
 }
 
+// NodeKeyIssuer is an interface used by the Server to issue a separate service
+// account key to each node.
+type NodeKeyIssuer interface {
+	IssueKey(ctx context.Context, org, hostname string) (string, error)
+}
+
+// EventStream is an interface used by the Server to publish node events and
+// subscribe to the events of all instances.
+type EventStream interface {
+	Publish(ctx context.Context, e v0.NodeEvent) error
+	Subscribe(buffer int) (<-chan v0.NodeEvent, func())
+}
+
 // NewServer creates a new Server instance for request handling.
 func NewServer(project string, finder IataFinder, maxmind MaxmindFinder, asn ASNFinder,
 	ds dnsiface.Service, tracker DNSTracker, sm ServiceAccountSecretManager) *Server {
@@ -84,16 +376,35 @@ func NewServer(project string, finder IataFinder, maxmind MaxmindFinder, asn ASN
 		Maxmind: maxmind,
 		ASN:     asn,
 		DNS:     ds,
+		Domain:  dnsname.DefaultDomain,
 		sm:      sm,
 
+		ListCacheTTL: defaultListCacheTTL,
+		ProxyHops:    defaultProxyHops,
+
 		dnsTracker: tracker,
+		listCache:  newListCache(),
+		datasets:   map[string]time.Time{},
+		closing:    make(chan struct{}),
 	}
 }
 
-// Reload reloads all resources used by the Server.
+// Reload reloads all resources used by the Server. The time of every
+// successful load is recorded for Ready.
 func (s *Server) Reload(ctx context.Context) {
-	s.Iata.Load(ctx)
-	s.Maxmind.Reload(ctx)
+	s.datasetLoaded("iata", s.Iata.Load(ctx))
+	s.datasetLoaded("maxmind", s.Maxmind.Reload(ctx))
+	if l, ok := s.ASN.(ASNLoader); ok {
+		s.datasetLoaded("asn", l.Load(ctx))
+	} else {
+		// The routeview ASN dataset is loaded when the annotator is created,
+		// and reloads do not report errors.
+		s.ASN.Reload(ctx)
+		s.datasetLoaded("asn", nil)
+	}
+	if s.Geonames != nil {
+		s.datasetLoaded("geonames", s.Geonames.Load(ctx))
+	}
 }
 
 // Lookup is a handler used to find the nearest IATA given client IP or lat/lon metadata.
@@ -102,305 +413,1878 @@ func (s *Server) Lookup(rw http.ResponseWriter, req *http.Request) {
 	country, err := s.getCountry(req)
 	if country == "" || err != nil {
 		resp.Error = &v2.Error{
-			Type:   "?country=<country>",
-			Title:  "could not determine country from request",
-			Status: http.StatusBadRequest,
+			Type:   v0.ErrInvalidParam,
+			Title:  "could not determine country from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	lat, lon, err := s.getLocation(req)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "could not determine lat/lon from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	// Several results let clients check the nearest airport or choose
+	// another metro.
+	results := 1
+	if raw := req.URL.Query().Get("results"); raw != "" {
+		results, err = strconv.Atoi(raw)
+		if err != nil || results < 1 || results > maxLookupResults {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid results from request",
+				Detail: fmt.Sprintf("results must be between 1 and %d", maxLookupResults),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+	}
+	airports, err := s.Iata.Nearest(country, lat, lon, results)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrIATALookup,
+			Title:  "could not determine iata from request",
+			Status: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Lookup = &v0.Lookup{
+		IATA:        airports[0].IATA,
+		CrossBorder: airports[0].CrossBorder,
+	}
+	if req.URL.Query().Has("results") {
+		for _, a := range airports {
+			resp.Lookup.Airports = append(resp.Lookup.Airports, v0.Airport{
+				IATA:        a.IATA,
+				ICAO:        a.ICAO,
+				Name:        a.Airport,
+				Country:     a.CountryCode,
+				Latitude:    a.Latitude,
+				Longitude:   a.Longitude,
+				DistanceKm:  a.Distance,
+				CrossBorder: a.CrossBorder,
+			})
+		}
+	}
+	writeResponse(rw, resp)
+}
+
+// SearchAirports handler is used by partners that only know the name or ICAO
+// code of their airport to find its IATA code. The best matches of the name
+// parameter are listed, and the IATA code is the best one.
+func (s *Server) SearchAirports(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.LookupResponse{}
+	name := req.URL.Query().Get("name")
+	results := 5
+	var err error
+	if raw := req.URL.Query().Get("results"); raw != "" {
+		results, err = strconv.Atoi(raw)
+	}
+	if name == "" || err != nil || results < 1 || results > maxLookupResults {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid name or results from request",
+			Detail: fmt.Sprintf("name is required, and results must be between 1 and %d", maxLookupResults),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	rows := s.Iata.Search(name, results)
+	if len(rows) == 0 {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotFound,
+			Title:  "no airports match the given name",
+			Status: http.StatusNotFound,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Lookup = &v0.Lookup{IATA: rows[0].IATA}
+	for _, r := range rows {
+		resp.Lookup.Airports = append(resp.Lookup.Airports, v0.Airport{
+			IATA:      r.IATA,
+			ICAO:      r.ICAO,
+			Name:      r.Airport,
+			Country:   r.CountryCode,
+			Latitude:  r.Latitude,
+			Longitude: r.Longitude,
+		})
+	}
+	writeResponse(rw, resp)
+}
+
+// LookupBatch handler is used by operators to find the nearest IATA code,
+// country, and ASN of many IPs or locations in one request, e.g. to plan the
+// site names of a fleet.
+func (s *Server) LookupBatch(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.BatchLookupResponse{}
+	locations := []v0.BatchLookup{}
+	err := json.NewDecoder(io.LimitReader(req.Body, maxBatchBodyBytes)).Decode(&locations)
+	if err != nil || len(locations) == 0 || len(locations) > maxBatchLookups {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidBody,
+			Title:  fmt.Sprintf("body must contain a list of 1 to %d locations", maxBatchLookups),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Results = make([]v0.BatchLookupResult, len(locations))
+	for i, l := range locations {
+		resp.Results[i] = s.lookupLocation(l)
+	}
+	writeResponse(rw, resp)
+}
+
+// lookupLocation returns the nearest IATA code of the given location. Errors
+// are reported in the result, so that one location does not fail a batch.
+func (s *Server) lookupLocation(l v0.BatchLookup) v0.BatchLookupResult {
+	r := v0.BatchLookupResult{IP: l.IP, Country: l.Country, Latitude: l.Latitude, Longitude: l.Longitude}
+	if l.IP != "" {
+		ip := net.ParseIP(l.IP)
+		if ip == nil {
+			r.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid ip",
+				Status: http.StatusBadRequest,
+			}
+			return r
+		}
+		record, err := s.Maxmind.City(ip)
+		if err != nil {
+			r.Error = &v2.Error{
+				Type:   v0.ErrGeoLookup,
+				Title:  "could not find city metadata from ip",
+				Status: http.StatusInternalServerError,
+			}
+			return r
+		}
+		r.Country = record.Country.IsoCode
+		r.Latitude, r.Longitude = record.Location.Latitude, record.Location.Longitude
+		if s.ASN != nil {
+			if n := s.ASN.AnnotateIP(l.IP); n != nil {
+				r.ASN = n.ASNumber
+			}
+		}
+	}
+	if r.Country == "" {
+		r.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "could not determine country of location",
+			Detail: "a location must have an ip, or a country with lat/lon",
+			Status: http.StatusBadRequest,
+		}
+		return r
+	}
+	airports, err := s.Iata.Nearest(r.Country, r.Latitude, r.Longitude, 1)
+	if err != nil {
+		r.Error = &v2.Error{
+			Type:   v0.ErrIATALookup,
+			Title:  "could not determine iata from location",
+			Status: http.StatusInternalServerError,
+		}
+		return r
+	}
+	r.IATA, r.CrossBorder = airports[0].IATA, airports[0].CrossBorder
+	return r
+}
+
+// ValidateMetro handler is used to check the IATA code claimed by a node
+// against the geolocation of its IP, given by the ipv4 parameter or the
+// source address of the request.
+func (s *Server) ValidateMetro(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.MetroValidationResponse{}
+	code := req.URL.Query().Get("iata")
+	rawIP := getClientIP(req)
+	ip := net.ParseIP(rawIP)
+	if code == "" || ip == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "iata and ipv4 are required",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	row, err := s.Iata.Find(code)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "could not find given iata in dataset",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	record, err := s.Maxmind.City(ip)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrGeoLookup,
+			Title:  "could not find city metadata from ip",
+			Status: http.StatusInternalServerError,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	v := &v0.MetroValidation{
+		IATA:           row.IATA,
+		IP:             rawIP,
+		AirportCountry: row.CountryCode,
+		Country:        record.Country.IsoCode,
+		DistanceKm:     row.Distance(record.Location.Latitude, record.Location.Longitude),
+	}
+	switch {
+	case v.DistanceKm > metroFailDistance:
+		v.Verdict = v0.MetroFail
+	case v.DistanceKm > metroWarnDistance || v.Country != v.AirportCountry:
+		v.Verdict = v0.MetroWarn
+	default:
+		v.Verdict = v0.MetroPass
+	}
+	resp.Validation = v
+	writeResponse(rw, resp)
+}
+
+// getRegisterParams reads and validates the registration parameters from the
+// request and looks up the metro, geo, and network metadata of the node. If
+// any parameter is invalid, all invalid parameters are returned with a bad
+// request error.
+func (s *Server) getRegisterParams(req *http.Request) (*register.Params, []v0.InvalidParam, *v2.Error) {
+	param, invalid := parseRegisterParams(req)
+	if len(invalid) > 0 {
+		details := []string{}
+		for _, p := range invalid {
+			details = append(details, p.Param+" ("+p.Code+")")
+		}
+		return nil, invalid, &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid parameters from request",
+			Detail: strings.Join(details, ", "),
+			Status: http.StatusBadRequest,
+		}
+	}
+	param.Project = s.Project
+	param.Domain = s.Domain
+	ip := net.ParseIP(param.IPv4)
+	var row iata.Row
+	var err error
+	if param.Metro.ICAO != "" {
+		row, err = s.Iata.FindByICAO(param.Metro.ICAO)
+	} else {
+		row, err = s.Iata.Find(param.Metro.IATA)
+	}
+	if err != nil {
+		return nil, nil, &v2.Error{
+			Type:   v0.ErrIATALookup,
+			Title:  "could not find given iata or icao in dataset",
+			Status: http.StatusInternalServerError,
+		}
+	}
+	param.Metro = row
+	// Operators may place nodes anywhere in their metro, but not elsewhere.
+	if loc := param.Location; loc != nil {
+		if d := row.Distance(loc.Latitude, loc.Longitude); d > metroWarnDistance {
+			return nil, []v0.InvalidParam{{
+				Param:  "lat",
+				Code:   v0.ParamOutOfRange,
+				Detail: fmt.Sprintf("location is %.0f km from %s, at most %d km allowed", d, row.IATA, metroWarnDistance),
+			}}, &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid parameters from request",
+				Detail: "lat (" + v0.ParamOutOfRange + ")",
+				Status: http.StatusBadRequest,
+			}
+		}
+	}
+	// The ASN annotation does not depend on the geolocation.
+	var annotate errgroup.Group
+	if s.ASN != nil {
+		annotate.Go(func() error {
+			param.Network = s.ASN.AnnotateIP(param.IPv4)
+			return nil
+		})
+	}
+	record, err := s.Maxmind.City(ip)
+	annotate.Wait()
+	if err != nil {
+		return nil, nil, &v2.Error{
+			Type:   v0.ErrGeoLookup,
+			Title:  "could not find city metadata from ip",
+			Status: http.StatusInternalServerError,
+		}
+	}
+	param.Geo = record
+	param.Traits = s.ipTraits(ip, record)
+	param.Place = s.nearestPlace(param, record)
+	return param, nil, nil
+}
+
+// nearestPlace returns the GeoNames city nearest the node if Maxmind does not
+// name the city or region of a node, or nil. Nodes are at their metro unless
+// their operator gives their location.
+func (s *Server) nearestPlace(param *register.Params, record *geoip2.City) *geonames.Place {
+	if s.Geonames == nil || (record.City.Names["en"] != "" && len(record.Subdivisions) > 0) {
+		return nil
+	}
+	metro := param.Metro
+	lat, lon := metro.Latitude, metro.Longitude
+	if param.Location != nil {
+		lat, lon = param.Location.Latitude, param.Location.Longitude
+	}
+	p, err := s.Geonames.Nearest(metro.CountryCode, lat, lon)
+	if err != nil {
+		log.Printf("No GeoNames city near %s: %v", metro.IATA, err)
+		return nil
+	}
+	return &p
+}
+
+// ipTraits returns the Maxmind flags of the address of a node. Addresses are
+// not flagged if the lookup fails.
+func (s *Server) ipTraits(ip net.IP, record *geoip2.City) v0.IPTraits {
+	t := v0.IPTraits{Proxy: record.Traits.IsAnonymousProxy}
+	anon, err := s.Maxmind.AnonymousIP(ip)
+	if err != nil {
+		log.Printf("Anonymous IP lookup of %s failed: %v", ip, err)
+		return t
+	}
+	t.Hosting = anon.IsHostingProvider
+	t.Proxy = t.Proxy || anon.IsAnonymousVPN || anon.IsPublicProxy || anon.IsResidentialProxy || anon.IsTorExitNode
+	return t
+}
+
+// parseRegisterParams reads the registration parameters from the request and
+// returns every parameter that is missing or invalid. The metro of the
+// returned params only has the IATA code of the request.
+func parseRegisterParams(req *http.Request) (*register.Params, []v0.InvalidParam) {
+	q := req.URL.Query()
+	param := &register.Params{}
+	invalid := []v0.InvalidParam{}
+	add := func(name, code, detail string) {
+		invalid = append(invalid, v0.InvalidParam{Param: name, Code: code, Detail: detail})
+	}
+	// check reports a required parameter that is empty or not ok.
+	check := func(name, value string, ok bool, detail string) {
+		switch {
+		case value == "":
+			add(name, v0.ParamMissing, detail)
+		case !ok:
+			add(name, v0.ParamInvalid, detail)
+		}
+	}
+
+	param.Service = q.Get("service")
+	check("service", param.Service, isValidName(param.Service), "lowercase letters and digits, at most 10 characters")
+	// Other services of the node are optional, and each has an alias of the
+	// hostname.
+	seen := map[string]bool{param.Service: true}
+	for _, service := range q["services"] {
+		switch {
+		case !isValidName(service):
+			add("services", v0.ParamInvalid, fmt.Sprintf("service %q is not lowercase letters and digits, at most 10 characters", service))
+		case seen[service]:
+			add("services", v0.ParamInvalid, fmt.Sprintf("service %q is given more than once", service))
+		default:
+			seen[service] = true
+			param.Services = append(param.Services, service)
+		}
+	}
+	if len(param.Services) > maxAliases {
+		add("services", v0.ParamOutOfRange, fmt.Sprintf("at most %d services", maxAliases))
+	}
+	// The organization is that of the API key. Clients may still name it, but
+	// only to confirm it.
+	param.Org, _ = orgFromContext(req.Context())
+	check("organization", param.Org, isValidName(param.Org), "lowercase letters and digits, at most 10 characters")
+	if org := q.Get("organization"); org != "" && param.Org != "" && org != param.Org {
+		add("organization", v0.ParamInvalid, fmt.Sprintf("organization %q does not match the api key", org))
+	}
+	param.IPv6 = checkIP(q.Get("ipv6")) // optional.
+	rawIP := getClientIP(req)
+	param.IPv4 = checkIP(rawIP)
+	ip := net.ParseIP(param.IPv4)
+	check("ipv4", rawIP, ip != nil && ip.To4() != nil, "an IPv4 address")
+	param.Type = q.Get("type")
+	check("type", param.Type, isValidType(param.Type), "physical, virtual, or cloud")
+	// The provider and region of cloud nodes are optional.
+	checkCloud := func(name, value string) {
+		switch {
+		case value == "":
+		case param.Type != "cloud":
+			add(name, v0.ParamInvalid, "only for type cloud")
+		case !validCloud.MatchString(value):
+			add(name, v0.ParamInvalid, "lowercase letters, digits, and dashes, at most 32 characters")
+		}
+	}
+	param.Cloud.Provider = q.Get("provider")
+	checkCloud("provider", param.Cloud.Provider)
+	param.Cloud.Region = q.Get("region")
+	checkCloud("region", param.Cloud.Region)
+	// Uplink speeds are stored by their canonical name, e.g. "10G" is "10g".
+	speed, err := uplink.Parse(q.Get("uplink"))
+	param.Uplink = speed.Name
+	check("uplink", q.Get("uplink"), err == nil, "one of "+strings.Join(uplink.Names(), ", "))
+	if q.Get("iata") == "" && q.Get("icao") != "" {
+		// Partners may only know the ICAO code of the airport.
+		param.Metro.ICAO = getClientIcao(req)
+		check("icao", q.Get("icao"), param.Metro.ICAO != "", "four letter ICAO airport code, e.g. klga")
+	} else {
+		param.Metro.IATA = getClientIata(req)
+		check("iata", q.Get("iata"), param.Metro.IATA != "", "three letter IATA airport code, e.g. lga")
+	}
+
+	// Probability and ports are optional.
+	param.Probability = 1.0
+	if raw := q.Get("probability"); raw != "" {
+		p, err := strconv.ParseFloat(raw, 64)
+		switch {
+		case err != nil:
+			add("probability", v0.ParamInvalid, "a number between 0 and 1")
+		case !(p >= 0 && p <= 1):
+			add("probability", v0.ParamOutOfRange, "a number between 0 and 1")
+		default:
+			param.Probability = p
+		}
+	}
+	for _, port := range q["ports"] {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			add("ports", v0.ParamInvalid, fmt.Sprintf("port %q is not a number between 1 and 65535", port))
+		}
+	}
+	// Operators may give the location of the node, which getRegisterParams
+	// checks against the metro.
+	rawLat, rawLon, city := q.Get("lat"), q.Get("lon"), strings.TrimSpace(q.Get("city"))
+	if rawLat != "" || rawLon != "" || city != "" {
+		lat, errLat := strconv.ParseFloat(rawLat, 64)
+		lon, errLon := strconv.ParseFloat(rawLon, 64)
+		check("lat", rawLat, errLat == nil, "latitude in degrees, given with lon")
+		check("lon", rawLon, errLon == nil, "longitude in degrees, given with lat")
+		if errLat == nil && !(lat >= -90 && lat <= 90) {
+			add("lat", v0.ParamOutOfRange, "a number between -90 and 90")
+		}
+		if errLon == nil && !(lon >= -180 && lon <= 180) {
+			add("lon", v0.ParamOutOfRange, "a number between -180 and 180")
+		}
+		if len(city) > maxCityBytes || !utf8.ValidString(city) || strings.IndexFunc(city, unicode.IsControl) >= 0 {
+			add("city", v0.ParamInvalid, fmt.Sprintf("printable text, at most %d bytes", maxCityBytes))
+		}
+		param.Location = &register.Location{Latitude: lat, Longitude: lon, City: city}
+	}
+	// Shorter TTLs suit nodes whose addresses change often.
+	if raw := q.Get("dns_ttl"); raw != "" {
+		ttl, err := strconv.ParseInt(raw, 10, 64)
+		detail := fmt.Sprintf("seconds between %d and %d", dnsx.MinTTL, dnsx.MaxTTL)
+		switch {
+		case err != nil:
+			add("dns_ttl", v0.ParamInvalid, detail)
+		case dnsx.ValidateTTL(ttl) != nil:
+			add("dns_ttl", v0.ParamOutOfRange, detail)
+		default:
+			param.DNSTTL = ttl
+		}
+	}
+	return param, invalid
+}
+
+// Register handler is used by autonodes to register their hostname with M-Lab
+// on startup and receive additional needed configuration metadata.
+func (s *Server) Register(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.RegisterResponse{}
+	defer func() {
+		if resp.Error != nil {
+			metrics.RegistrationsRejected.WithLabelValues(requestOrg(req), resp.Error.Type).Inc()
+		}
+	}()
+	ctx := req.Context()
+	if s.RegisterTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.RegisterTimeout)
+		defer cancel()
+	}
+	labels, err := getLabels(req)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid label from request",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	// Settings of the organization of the API key are loaded while the
+	// parameters are annotated.
+	org, _ := orgFromContext(ctx)
+	var settings orgs.Settings
+	var lookups errgroup.Group
+	lookups.Go(func() (err error) {
+		settings, err = s.getOrgSettings(ctx, org)
+		return err
+	})
+	param, invalid, perr := s.getRegisterParams(req)
+	if perr != nil {
+		resp.Error = perr
+		resp.Invalid = invalid
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	// Outdated Go clients are rejected. Other clients do not report a version.
+	if min := s.minClientVersion(param.Service); config.Older(clientVersion(req), min) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrClientVersion,
+			Title:  "client version is too old",
+			Detail: fmt.Sprintf("service %q requires client version %s or newer, got %s", param.Service, min, clientVersion(req)),
+			Status: http.StatusForbidden,
+		}
+		resp.MinClientVersion = min
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Warnings = s.clientWarnings(req)
+	if err := lookups.Wait(); err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSettings,
+			Title:  "could not load organization settings",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("org settings get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if settings.Suspended() {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSuspended,
+			Title:  "organization is suspended",
+			Detail: fmt.Sprintf("organization %q cannot register nodes", param.Org),
+			Status: http.StatusForbidden,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if resp.Error = verifySourceIP(req, s.ProxyHops, param, settings); resp.Error != nil {
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if resp.Error = verifyAllowlist(param, settings); resp.Error != nil {
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if resp.Error = verifyMetroDistance(param, settings); resp.Error != nil {
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if resp.Error = verifyTraits(param, settings); resp.Error != nil {
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if resp.Error = s.checkIPCollision(param, settings); resp.Error != nil {
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	param.Probability = settings.Probability(param.Probability)
+	r := register.CreateRegisterResponse(param)
+	r.Warnings = resp.Warnings
+	if req.URL.Query().Get("dry_run") == "true" {
+		// Return the would-be registration without changing DNS, loading
+		// credentials, or updating the DNS tracker.
+		s.applyOverride(r.Registration, s.trackedOverride(r.Registration.Hostname))
+		writeResponse(rw, r)
+		return
+	}
+
+	// Credentials and DNS records are independent, so both are requested
+	// concurrently. Registrations of hostnames already in the DNS tracker are
+	// renewals, and renewals with unchanged addresses skip Cloud DNS.
+	// While Cloud DNS is unavailable, the registration succeeds and its DNS
+	// records are changed by the tracker once Cloud DNS is available again.
+	// With a DNSQueue, the records are always changed after responding.
+	var credErr, dnsErr error
+	cached, renewal := false, false
+	var override *tracker.Override
+	// dnsChange is the ID of a DNS change that is not verified as done.
+	dnsChange := ""
+	rrdata := &tracker.Rrdata{A: param.IPv4, AAAA: param.IPv6, TTL: param.DNSTTL, Registered: time.Now().Unix()}
+	if rrdata.TTL == 0 {
+		rrdata.TTL = settings.DNSTTL
+	}
+	var calls errgroup.Group
+	calls.Go(func() error {
+		r.Registration.Credentials, credErr = s.getCredentials(ctx, param.Org, r.Registration.Hostname, settings)
+		return credErr
+	})
+	calls.Go(func() error {
+		_, span := tracing.Start(ctx, "tracker.Get")
+		status, err := s.dnsTracker.Get(r.Registration.Hostname)
+		span.End()
+		renewal = err == nil
+		if renewal && status != nil {
+			override = status.Override
+		}
+		prev := &tracker.DNSRecord{PendingDNS: true}
+		if renewal && status != nil && status.DNS != nil {
+			prev = status.DNS
+			// The TXT record keeps the time of the first registration.
+			if prev.Rrdata != nil && prev.Rrdata.Registered != 0 {
+				rrdata.Registered = prev.Rrdata.Registered
+			} else if status.Registered != nil {
+				rrdata.Registered = status.Registered.Unix
+			}
+		}
+		setRegistrationTXT(rrdata, param)
+		cached = !prev.PendingDNS && prev.Rrdata.Matches(rrdata)
+		m := s.dnsManager(param.Org, s.Domain)
+		m.TTL = rrdata.TTL
+		if cached && prev.DNSChange != "" && s.VerifyDNS > 0 {
+			// The previous change may be done by now.
+			if done, err := m.ChangeDone(ctx, prev.DNSChange); err != nil || !done {
+				dnsChange = prev.DNSChange
+			}
+		}
+		if cached || s.DNSQueue != nil {
+			return nil
+		}
+		// Register the hostname under the organization zone.
+		chg, err := m.Register(ctx, r.Registration.Hostname+".", rrdata.A, rrdata.AAAA, rrdata.TXT)
+		if err != nil || chg == nil || s.VerifyDNS <= 0 {
+			dnsErr = err
+			return err
+		}
+		done, err := m.WaitChange(ctx, chg, s.VerifyDNS)
+		if err != nil {
+			log.Printf("DNS change verification of %s failed: %v", r.Registration.Hostname, err)
+		}
+		if !done {
+			dnsChange = chg.Id
+		}
+		return nil
+	})
+	// Each error is reported below. As when the tracker update fails, a
+	// failure does not undo the changes of the other calls.
+	calls.Wait()
+
+	if credErr != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrCredentials,
+			Title:  "could not load service account key for node",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("loading service account key failure:", credErr)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if apiKey, ok := nodeKeyFromContext(req.Context()); ok {
+		r.Registration.Credentials.APIKey = apiKey
+	}
+
+	pending := false
+	switch {
+	case cached:
+		metrics.DNSCachedRegistrations.Inc()
+	case s.DNSQueue != nil:
+		pending = true
+		r.Warnings = append(r.Warnings, v0.Warning{
+			Type:    v0.WarnDNSPending,
+			Message: "DNS records are changed in the background; the hostname will resolve shortly",
+		})
+	case errors.Is(dnsErr, dnsx.ErrCircuitOpen):
+		pending = true
+		log.Printf("DNS registration of %s is pending: %v", r.Registration.Hostname, dnsErr)
+		r.Warnings = append(r.Warnings, v0.Warning{
+			Type:    v0.WarnDNSPending,
+			Message: "Cloud DNS is unavailable; the hostname will resolve once it recovers",
+		})
+	case dnsErr != nil:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrDNSRegister,
+			Title:  "could not register dynamic hostname",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns register failure:", dnsErr)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	r.DNS = v0.DNSApplied
+	switch {
+	case pending:
+		r.DNS = v0.DNSPending
+	case dnsChange != "":
+		r.DNS = v0.DNSPropagating
+	}
+
+	// Operator overrides replace the probability requested by the node.
+	s.applyOverride(r.Registration, override)
+
+	event := v0.EventRegister
+	if renewal {
+		event = v0.EventRenew
+	}
+
+	// Add the hostname to the DNS tracker. Credentials are never stored.
+	saved := *r.Registration
+	saved.Credentials = nil
+	_, span := tracing.Start(req.Context(), "tracker.Update")
+	err = s.dnsTracker.Update(r.Registration.Hostname, &tracker.DNSRecord{
+		Ports:         getPorts(req),
+		Type:          param.Type,
+		Country:       param.Geo.Country.IsoCode,
+		Uplink:        param.Uplink,
+		UplinkMbps:    uplink.Mbps(param.Uplink),
+		CloudProvider: param.Cloud.Provider,
+		CloudRegion:   param.Cloud.Region,
+		Labels:        labels,
+		Registration:  &saved,
+		ClientVersion: clientVersion(req),
+		PendingDNS:    pending,
+		Rrdata:        rrdata,
+		DNSChange:     dnsChange,
+	})
+	tracing.End(span, err)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "could not update DNS tracker",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns gc update failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if pending && s.DNSQueue != nil {
+		s.DNSQueue.Enqueue(r.Registration.Hostname)
+	}
+	metrics.NodeRegistrations.WithLabelValues(param.Org).Inc()
+	metrics.ClientVersions.WithLabelValues(param.Org, versionLabel(clientVersion(req))).Inc()
+	if s.NodeEvents != nil {
+		err = s.NodeEvents.Publish(ctx, v0.NodeEvent{
+			Type:     event,
+			Hostname: r.Registration.Hostname,
+			Org:      param.Org,
+			Time:     time.Now().UTC(),
+		})
+		if err != nil {
+			log.Println("node event publish failure:", err)
+		}
+	}
+
+	b, _ := json.MarshalIndent(r, "", " ")
+	rw.Write(b)
+}
+
+// setRegistrationTXT sets the TXT data of rr to the registration metadata of
+// the node, so that operators can identify nodes from DNS.
+func setRegistrationTXT(rr *tracker.Rrdata, param *register.Params) {
+	kv := []string{
+		"org", param.Org,
+		"service", param.Service,
+		"type", param.Type,
+		"uplink", param.Uplink,
+		"registered", time.Unix(rr.Registered, 0).UTC().Format(time.RFC3339),
+	}
+	if len(param.Services) > 0 {
+		// The aliases are managed by dnsx from this list.
+		kv = append(kv, dnsx.TXTAliases, strings.Join(param.Services, ","))
+	}
+	rr.TXT = dnsx.TXTData(kv...)
+}
+
+// getCredentials returns the service account credentials for a node: an
+// access token, a key issued to the node, or the key shared by the
+// organization, depending on the organization settings.
+func (s *Server) getCredentials(ctx context.Context, org, hostname string, settings orgs.Settings) (*v0.Credentials, error) {
+	switch {
+	case settings.WorkloadIdentityProvider != "":
+		return &v0.Credentials{
+			WorkloadIdentity: &v0.WorkloadIdentity{
+				Audience:       "//iam.googleapis.com/" + settings.WorkloadIdentityProvider,
+				Provider:       settings.WorkloadIdentityProvider,
+				ServiceAccount: settings.WorkloadIdentityServiceAccount,
+			},
+		}, nil
+	case settings.AccessTokens && s.AccessTokens != nil:
+		token, expiry, err := s.AccessTokens.Generate(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		return &v0.Credentials{AccessToken: token, AccessTokenExpiry: &expiry}, nil
+	case settings.NodeKeys && s.NodeKeys != nil:
+		key, err := s.NodeKeys.IssueKey(ctx, org, hostname)
+		if err != nil {
+			return nil, err
+		}
+		return &v0.Credentials{ServiceAccountKey: key}, nil
+	default:
+		key, err := s.sm.LoadOrCreateKey(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		return &v0.Credentials{ServiceAccountKey: key}, nil
+	}
+}
+
+// getOrgSettings returns the settings of the organization, or the defaults if
+// organization settings are not enabled.
+func (s *Server) getOrgSettings(ctx context.Context, org string) (orgs.Settings, error) {
+	if s.Orgs == nil {
+		return orgs.Settings{}, nil
+	}
+	return s.Orgs.Get(ctx, org)
+}
+
+// verifySourceIP checks that the registered ipv4 matches the source address
+// of the request, for organizations that require it.
+func verifySourceIP(req *http.Request, hops int, param *register.Params, settings orgs.Settings) *v2.Error {
+	if !settings.VerifySourceIP {
+		return nil
+	}
+	if src := getSourceIP(req, hops); src != param.IPv4 {
+		return &v2.Error{
+			Type:   v0.ErrSourceIPMismatch,
+			Title:  "ipv4 does not match the source address of the request",
+			Detail: fmt.Sprintf("organization %q requires registrations from the registered address; ipv4 %s, source %s", param.Org, param.IPv4, src),
+			Status: http.StatusForbidden,
+		}
+	}
+	return nil
+}
+
+// verifyAllowlist checks that the node ASN and addresses are allowed by the
+// organization, limiting the nodes a leaked API key can register.
+func verifyAllowlist(param *register.Params, settings orgs.Settings) *v2.Error {
+	for _, service := range append([]string{param.Service}, param.Services...) {
+		if !settings.AllowsService(service) {
+			return &v2.Error{
+				Type:   v0.ErrServiceNotAllowed,
+				Title:  "service is not allowed for organization",
+				Detail: fmt.Sprintf("organization %q does not allow registrations of service %q", param.Org, service),
+				Status: http.StatusForbidden,
+			}
+		}
+	}
+	if !settings.AllowsASN(int64(param.Network.ASNumber)) {
+		return &v2.Error{
+			Type:   v0.ErrASNNotAllowed,
+			Title:  "ASN is not allowed for organization",
+			Detail: fmt.Sprintf("organization %q does not allow registrations from AS%d", param.Org, param.Network.ASNumber),
+			Status: http.StatusForbidden,
+		}
+	}
+	for _, ip := range []string{param.IPv4, param.IPv6} {
+		if ip == "" {
+			continue
+		}
+		if !settings.AllowsIP(net.ParseIP(ip)) {
+			return &v2.Error{
+				Type:   v0.ErrIPNotAllowed,
+				Title:  "address is not allowed for organization",
+				Detail: fmt.Sprintf("organization %q does not allow registrations of %s", param.Org, ip),
+				Status: http.StatusForbidden,
+			}
+		}
+	}
+	return nil
+}
+
+// verifyMetroDistance rejects registrations whose IATA airport is farther from
+// the geolocation of the node than the organization allows. Nodes without a
+// known location are accepted.
+func verifyMetroDistance(param *register.Params, settings orgs.Settings) *v2.Error {
+	loc := param.Geo.Location
+	if settings.MaxMetroDistance == 0 || (loc.Latitude == 0 && loc.Longitude == 0) {
+		return nil
+	}
+	d := param.Metro.Distance(loc.Latitude, loc.Longitude)
+	if d <= float64(settings.MaxMetroDistance) {
+		return nil
+	}
+	return &v2.Error{
+		Type:   v0.ErrMetroTooFar,
+		Title:  "iata is too far from the node location",
+		Detail: fmt.Sprintf("%s is %.0f km from the node, organization %q allows %d km", param.Metro.IATA, d, param.Org, settings.MaxMetroDistance),
+		Status: http.StatusForbidden,
+	}
+}
+
+// verifyTraits rejects registrations of addresses of hosting providers and
+// proxies, if the organization does not allow them.
+func verifyTraits(param *register.Params, settings orgs.Settings) *v2.Error {
+	if !settings.RejectHosting || (!param.Traits.Hosting && !param.Traits.Proxy) {
+		return nil
+	}
+	return &v2.Error{
+		Type:   v0.ErrHostingIP,
+		Title:  "ipv4 belongs to a hosting provider or proxy",
+		Detail: fmt.Sprintf("organization %q does not allow registrations of hosting providers or proxies", param.Org),
+		Status: http.StatusForbidden,
+	}
+}
+
+// checkIPCollision rejects registrations of an ipv4 that is active under a
+// different organization, unless the organization allows shared addresses.
+func (s *Server) checkIPCollision(param *register.Params, settings orgs.Settings) *v2.Error {
+	others := []string{}
+	for _, hostname := range s.dnsTracker.Hostnames(param.IPv4) {
+		if h, err := host.Parse(hostname); err == nil && h.Org != param.Org {
+			others = append(others, hostname)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	if settings.AllowSharedIP {
+		log.Printf("Registration of %s for %s shares the address of %v", param.IPv4, param.Org, others)
+		return nil
+	}
+	return &v2.Error{
+		Type:   v0.ErrIPRegistered,
+		Title:  "ipv4 is registered by another organization",
+		Detail: fmt.Sprintf("%s is active as %s", param.IPv4, strings.Join(others, ", ")),
+		Status: http.StatusConflict,
+	}
+}
+
+// applyOverride replaces the heartbeat probability of the registration with
+// the override set by operators for its hostname, if enabled.
+func (s *Server) applyOverride(r *v0.Registration, o *tracker.Override) {
+	if o == nil || !o.Enabled || r.Heartbeat == nil {
+		return
+	}
+	r.Heartbeat.Probability = o.Probability
+}
+
+// trackedOverride returns the override of the tracked hostname, or nil for
+// hostnames that are not tracked.
+func (s *Server) trackedOverride(hostname string) *tracker.Override {
+	status, err := s.dnsTracker.Get(hostname)
+	if err != nil || status == nil {
+		return nil
+	}
+	return status.Override
+}
+
+// Diff handler is used by autonodes to detect drift between their local
+// heartbeat registration and what a registration would return now, e.g. after
+// geo or dataset updates, without re-registering. The request parameters are
+// the same as for Register, and the body contains the node's registration.json.
+// Diff does not change DNS, credentials, or tracked state.
+func (s *Server) Diff(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.DiffResponse{}
+	local := map[string]v2.Registration{}
+	err := json.NewDecoder(io.LimitReader(req.Body, maxDiffBodyBytes)).Decode(&local)
+	if err != nil || len(local) != 1 {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidBody,
+			Title:  "body must contain exactly one registration from registration.json",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	param, invalid, perr := s.getRegisterParams(req)
+	if perr != nil {
+		resp.Error = perr
+		resp.Invalid = invalid
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	r := register.CreateRegisterResponse(param)
+	s.applyOverride(r.Registration, s.trackedOverride(r.Registration.Hostname))
+	resp.Hostname = r.Registration.Hostname
+	for _, l := range local {
+		resp.Changes = register.Diff(&l, r.Registration.Heartbeat)
+	}
+	writeResponse(rw, resp)
+}
+
+// Update handler is used by autonodes to change the monitored ports or
+// probability of a previously registered hostname without re-registering.
+// Only the "ports" and "probability" parameters given are changed.
+func (s *Server) Update(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.UpdateResponse{}
+	hostname := req.URL.Query().Get("hostname")
+	name, err := host.Parse(hostname)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
+	}
+	if org != name.Org {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrWrongOrg,
+			Title:  "hostname does not belong to organization",
+			Status: http.StatusForbidden,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	_, hasPorts := req.URL.Query()["ports"]
+	rawProb := req.URL.Query().Get("probability")
+	if !hasPorts && rawProb == "" {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "no ports or probability given to update",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	prob, err := strconv.ParseFloat(rawProb, 64)
+	if rawProb != "" && (err != nil || !(prob >= 0 && prob <= 1)) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidProbability,
+			Title:  "probability must be a number between 0 and 1",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	for _, port := range req.URL.Query()["ports"] {
+		if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+			resp.Invalid = append(resp.Invalid, v0.InvalidParam{
+				Param:  "ports",
+				Code:   v0.ParamInvalid,
+				Detail: fmt.Sprintf("port %q is not a number between 1 and 65535", port),
+			})
+		}
+	}
+	if resp.Invalid != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid ports",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if rawProb != "" {
+		// As for Register, the probability is scaled for the organization.
+		settings, err := s.getOrgSettings(req.Context(), org)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrOrgSettings,
+				Title:  "could not load organization settings",
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("org settings get failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		prob = settings.Probability(prob)
+	}
+
+	hostname = name.StringAll()
+	status, err := s.dnsTracker.Get(hostname)
+	if errors.Is(err, tracker.ErrNotFound) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotFound,
+			Title:  "hostname is not registered",
+			Status: http.StatusNotFound,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "failed to read hostname from DNS tracker",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns gc get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	record := status.DNS
+	if hasPorts {
+		record.Ports = getPorts(req)
+	}
+	if record.Registration == nil {
+		// Records saved by earlier versions do not include the registration.
+		record.Registration = &v0.Registration{Hostname: hostname}
+	}
+	if rawProb != "" {
+		if record.Registration.Heartbeat == nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidProbability,
+				Title:  "registration has no heartbeat probability to update",
+				Detail: "register the hostname again to set its probability",
+				Status: http.StatusConflict,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		record.Registration.Heartbeat.Probability = prob
+	}
+	s.applyOverride(record.Registration, status.Override)
+	err = s.dnsTracker.Update(hostname, record)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "could not update DNS tracker",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns gc update failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Registration = record.Registration
+	writeResponse(rw, resp)
+}
+
+// Get handler returns the registration of a registered hostname and whether
+// its DNS records are changed yet.
+func (s *Server) Get(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.GetResponse{}
+	name, err := host.Parse(req.URL.Query().Get("hostname"))
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
+	}
+	if org != name.Org {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrWrongOrg,
+			Title:  "hostname does not belong to organization",
+			Status: http.StatusForbidden,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	status, err := s.dnsTracker.Get(name.StringAll())
+	if errors.Is(err, tracker.ErrNotFound) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotFound,
+			Title:  "hostname is not registered",
+			Status: http.StatusNotFound,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "failed to read hostname from DNS tracker",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns gc get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Registration = status.DNS.Registration
+	resp.DNS = v0.DNSApplied
+	switch {
+	case status.DNS.PendingDNS:
+		resp.DNS = v0.DNSPending
+	case status.DNS.DNSChange != "":
+		// Changes that were not done at registration may be done by now.
+		m := s.dnsManager(name.Org, name.Domain)
+		if done, err := m.ChangeDone(req.Context(), status.DNS.DNSChange); err != nil || !done {
+			resp.DNS = v0.DNSPropagating
+		}
+	}
+	writeResponse(rw, resp)
+}
+
+// Maintenance handler is used by operators to declare a maintenance window for
+// a registered hostname. During the window the hostname is not expired and
+// List marks it with a "maintenance" label. The window begins at "start", or
+// now, and ends at "end" or after "duration". A zero duration ends any current
+// window.
+func (s *Server) Maintenance(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.MaintenanceResponse{}
+	hostname := req.URL.Query().Get("hostname")
+	name, err := host.Parse(hostname)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
+	}
+	if org != name.Org {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrWrongOrg,
+			Title:  "hostname does not belong to organization",
+			Status: http.StatusForbidden,
 		}
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	lat, lon, err := s.getLocation(req)
+	start, end, err := getWindow(req, time.Now().UTC())
 	if err != nil {
 		resp.Error = &v2.Error{
-			Type:   "?lat=<lat>&lon=<lon>",
-			Title:  "could not determine lat/lon from request",
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid maintenance window",
+			Detail: err.Error(),
 			Status: http.StatusBadRequest,
 		}
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	code, err := s.Iata.Lookup(country, lat, lon)
+
+	hostname = name.StringAll()
+	w := &tracker.Window{Start: start.Unix(), End: end.Unix()}
+	err = s.dnsTracker.SetMaintenance(hostname, w)
 	if err != nil {
+		// NOTE: memorystore does not distinguish a missing hostname from
+		// other errors, so check whether the hostname is registered.
+		if _, gerr := s.dnsTracker.Get(hostname); errors.Is(gerr, tracker.ErrNotFound) {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrNotFound,
+				Title:  "hostname is not registered",
+				Status: http.StatusNotFound,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
 		resp.Error = &v2.Error{
-			Type:   "internal error",
-			Title:  "could not determine iata from request",
+			Type:   v0.ErrTracker,
+			Title:  "could not save maintenance window",
+			Detail: err.Error(),
 			Status: http.StatusInternalServerError,
 		}
+		log.Println("dns gc maintenance failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	resp.Lookup = &v0.Lookup{
-		IATA: code,
-	}
+	log.Printf("Maintenance for %s from %s to %s", hostname, start, end)
+	resp.Hostname = hostname
+	resp.Maintenance = &v0.Maintenance{Start: start, End: end}
 	writeResponse(rw, resp)
 }
 
-// Register handler is used by autonodes to register their hostname with M-Lab
-// on startup and receive additional needed configuration metadata.
-func (s *Server) Register(rw http.ResponseWriter, req *http.Request) {
+// getWindow parses the maintenance window parameters from the request. Exactly
+// one of "end" or "duration" is required.
+func getWindow(req *http.Request, now time.Time) (time.Time, time.Time, error) {
+	q := req.URL.Query()
+	start := now
+	if v := q.Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return start, start, err
+		}
+		start = t.UTC()
+	}
+	var end time.Time
+	switch {
+	case q.Get("end") != "" && q.Get("duration") != "":
+		return start, start, errors.New("only one of end or duration may be given")
+	case q.Get("end") != "":
+		t, err := time.Parse(time.RFC3339, q.Get("end"))
+		if err != nil {
+			return start, start, err
+		}
+		end = t.UTC()
+	case q.Get("duration") != "":
+		d, err := time.ParseDuration(q.Get("duration"))
+		if err != nil {
+			return start, start, err
+		}
+		end = start.Add(d)
+	default:
+		return start, start, errors.New("end or duration is required")
+	}
+	switch {
+	case end.Before(start):
+		return start, end, errors.New("window ends before it starts")
+	case end.Sub(now) > maxMaintenanceWindow:
+		return start, end, fmt.Errorf("window must end within %s", maxMaintenanceWindow)
+	}
+	return start, end, nil
+}
+
+// Delete handler is used by operators to delete a previously registered
+// hostname from DNS. Only hostnames of the organization of the API key found
+// by WithAPIKeyValidation may be deleted.
+func (s *Server) Delete(rw http.ResponseWriter, req *http.Request) {
 	// All replies, errors and successes, should be json.
 	rw.Header().Set("Content-Type", "application/json")
 
-	resp := v0.RegisterResponse{}
-	param := &register.Params{Project: s.Project}
-	param.Service = req.URL.Query().Get("service")
-	if !isValidName(param.Service) {
+	resp := v0.DeleteResponse{}
+	hostname := req.URL.Query().Get("hostname")
+	name, err := host.Parse(hostname)
+	if err != nil {
 		resp.Error = &v2.Error{
-			Type:   "?service=<service>",
-			Title:  "could not determine service from request",
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
 			Status: http.StatusBadRequest,
 		}
+		log.Println("dns delete (parse) failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	// TODO(soltesz): discover this from a given API key.
-	param.Org = req.URL.Query().Get("organization")
-	if !isValidName(param.Org) {
-		resp.Error = &v2.Error{
-			Type:   "?organization=<organization>",
-			Title:  "could not determine organization from request",
-			Status: http.StatusBadRequest,
-		}
-		rw.WriteHeader(resp.Error.Status)
-		writeResponse(rw, resp)
+	// Callers may only delete the nodes of the organization of their API key.
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
 		return
 	}
-	param.IPv6 = checkIP(req.URL.Query().Get("ipv6")) // optional.
-	param.IPv4 = checkIP(getClientIP(req))
-	ip := net.ParseIP(param.IPv4)
-	if ip == nil || ip.To4() == nil {
+	if org != name.Org {
 		resp.Error = &v2.Error{
-			Type:   "?ipv4=<ipv4>",
-			Title:  "could not determine client ipv4 from request",
-			Status: http.StatusBadRequest,
+			Type:   v0.ErrWrongOrg,
+			Title:  "hostname does not belong to organization",
+			Status: http.StatusForbidden,
 		}
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	param.Type = req.URL.Query().Get("type")
-	if !isValidType(param.Type) {
-		resp.Error = &v2.Error{
-			Type:   "?type=<type>",
-			Title:  "invalid machine type from request",
-			Status: http.StatusBadRequest,
-		}
-		rw.WriteHeader(resp.Error.Status)
-		writeResponse(rw, resp)
+
+	if req.URL.Query().Get("async") == "true" {
+		s.deleteAsync(rw, req, name)
 		return
 	}
-	param.Uplink = req.URL.Query().Get("uplink")
-	if !isValidUplink(param.Uplink) {
-		resp.Error = &v2.Error{
-			Type:   "?uplink=<uplink>",
-			Title:  "invalid uplink speed from request",
-			Status: http.StatusBadRequest,
-		}
+	if resp.Error = s.deleteHostname(req.Context(), name, "deleted"); resp.Error != nil {
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	iata := getClientIata(req)
-	if iata == "" {
+
+	writeResponse(rw, resp)
+}
+
+// deleteAsync saves a pending operation, starts deleting the hostname in the
+// background, and returns the operation for the client to poll.
+func (s *Server) deleteAsync(rw http.ResponseWriter, req *http.Request, name host.Name) {
+	resp := v0.DeleteResponse{}
+	if s.Operations == nil {
 		resp.Error = &v2.Error{
-			Type:   "?iata=<iata>",
-			Title:  "could not determine iata from request",
-			Status: http.StatusBadRequest,
+			Type:   v0.ErrNotEnabled,
+			Title:  "asynchronous delete is not enabled",
+			Status: http.StatusNotImplemented,
 		}
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	row, err := s.Iata.Find(iata)
+	op, err := s.Operations.Create(req.Context(), "delete", name.StringAll(), name.Org)
 	if err != nil {
 		resp.Error = &v2.Error{
-			Type:   "iata.find",
-			Title:  "could not find given iata in dataset",
+			Type:   v0.ErrOperation,
+			Title:  "failed to create delete operation",
+			Detail: err.Error(),
 			Status: http.StatusInternalServerError,
 		}
+		log.Println("operation create failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	param.Metro = row
-	record, err := s.Maxmind.City(ip)
+	// Respond with a copy, since the operation is updated in the background.
+	pending := *op
+	s.ops.Add(1)
+	go func() {
+		defer s.ops.Done()
+		// The request context is canceled once the response is written.
+		ctx, cancel := context.WithTimeout(context.Background(), asyncDeleteTimeout)
+		defer cancel()
+		var opErr error
+		if derr := s.deleteWithRetry(ctx, name); derr != nil {
+			opErr = fmt.Errorf("%s: %s", derr.Title, derr.Detail)
+		}
+		// The result is saved even if the delete timed out.
+		fctx, fcancel := context.WithTimeout(context.Background(), operationFinishTimeout)
+		defer fcancel()
+		if err := s.Operations.Finish(fctx, op, opErr); err != nil {
+			log.Println("operation finish failure:", err)
+		}
+	}()
+	resp.Operation = &pending
+	rw.WriteHeader(http.StatusAccepted)
+	writeResponse(rw, resp)
+}
+
+// deleteWithRetry deletes the hostname, and retries internal errors with
+// exponential backoff until asyncDeleteAttempts fail or ctx is done.
+func (s *Server) deleteWithRetry(ctx context.Context, name host.Name) *v2.Error {
+	for i := 0; ; i++ {
+		derr := s.deleteHostname(ctx, name, "deleted")
+		if derr == nil || derr.Status != http.StatusInternalServerError || i+1 >= asyncDeleteAttempts {
+			return derr
+		}
+		log.Printf("delete %s attempt %d failed: %s", name.StringAll(), i+1, derr.Detail)
+		select {
+		case <-ctx.Done():
+			return derr
+		case <-time.After(asyncDeleteBackoff << i):
+		}
+	}
+}
+
+// dnsManager returns a Manager of the zone of the given organization.
+func (s *Server) dnsManager(org, domain string) *dnsx.Manager {
+	m := dnsx.NewManager(s.DNS, s.Project, dnsname.OrgZone(org, s.Project, domain))
+	m.Sites = s.SiteRecords
+	return m
+}
+
+// deleteHostname removes the hostname from DNS and the tracker, and reports
+// the removal with the given reason when configured.
+func (s *Server) deleteHostname(ctx context.Context, name host.Name, reason string) *v2.Error {
+	// Read the final state of the hostname before it is removed.
+	var status *tracker.Status
+	var err error
+	if s.Decommission != nil {
+		status, err = s.dnsTracker.Get(name.StringAll())
+		if err != nil && !errors.Is(err, tracker.ErrNotFound) {
+			log.Println("dns gc get failure:", err)
+		}
+	}
+
+	m := s.dnsManager(name.Org, name.Domain)
+	_, err = m.Delete(ctx, name.StringAll()+".")
 	if err != nil {
-		resp.Error = &v2.Error{
-			Type:   "maxmind.city",
-			Title:  "could not find city metadata from ip",
+		log.Println("dns delete failure:", err)
+		return &v2.Error{
+			Type:   v0.ErrDNSDelete,
+			Title:  "failed to delete hostname",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+	}
+
+	err = s.dnsTracker.Delete(name.StringAll())
+	if err != nil {
+		log.Println("dns gc delete failure:", err)
+		return &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "failed to delete hostname from DNS tracker",
+			Detail: err.Error(),
 			Status: http.StatusInternalServerError,
 		}
+	}
+	metrics.NodeDeletions.WithLabelValues(name.Org, reason).Inc()
+	if status != nil {
+		err = s.Decommission.Report(ctx, name.StringAll(), *status, reason)
+		if err != nil {
+			log.Println("decommission report failure:", err)
+		}
+	}
+	return nil
+}
+
+// Operation handler returns the status of a long running request, such as an
+// asynchronous delete. Only operations of the organization of the API key
+// found by WithAPIKeyValidation are returned.
+func (s *Server) Operation(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.OperationResponse{}
+	if s.Operations == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "operations are not enabled",
+			Status: http.StatusNotImplemented,
+		}
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	param.Geo = record
-	param.Network = s.ASN.AnnotateIP(param.IPv4)
-	// Override site probability with user-provided parameter.
-	// TODO(soltesz): include M-Lab override option
-	param.Probability = getProbability(req)
-	r := register.CreateRegisterResponse(param)
-
-	key, err := s.sm.LoadOrCreateKey(req.Context(), param.Org)
-	if err != nil {
+	id := req.URL.Query().Get("id")
+	if id == "" {
 		resp.Error = &v2.Error{
-			Type:   "load.serviceaccount.key",
-			Title:  "could not load service account key for node",
-			Status: http.StatusInternalServerError,
+			Type:   v0.ErrInvalidParam,
+			Title:  "missing operation id",
+			Status: http.StatusBadRequest,
 		}
-		log.Println("loading service account key failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-	r.Registration.Credentials = &v0.Credentials{
-		ServiceAccountKey: key,
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
 	}
-
-	// Register the hostname under the organization zone.
-	m := dnsx.NewManager(s.DNS, s.Project, dnsname.OrgZone(param.Org, s.Project))
-	_, err = m.Register(req.Context(), r.Registration.Hostname+".", param.IPv4, param.IPv6)
-	if err != nil {
+	op, err := s.Operations.Get(req.Context(), id)
+	if errors.Is(err, operation.ErrNotFound) || (err == nil && org != op.Org) {
+		// Operations of other organizations are reported as not found.
 		resp.Error = &v2.Error{
-			Type:   "dns.register",
-			Title:  "could not register dynamic hostname",
-			Status: http.StatusInternalServerError,
+			Type:   v0.ErrNotFound,
+			Title:  "operation not found",
+			Status: http.StatusNotFound,
 		}
-		log.Println("dns register failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-
-	// Add the hostname to the DNS tracker.
-	err = s.dnsTracker.Update(r.Registration.Hostname, getPorts(req))
 	if err != nil {
 		resp.Error = &v2.Error{
-			Type:   "tracker.gc",
-			Title:  "could not update DNS tracker",
+			Type:   v0.ErrOperation,
+			Title:  "failed to read operation",
+			Detail: err.Error(),
 			Status: http.StatusInternalServerError,
 		}
-		log.Println("dns gc update failure:", err)
+		log.Println("operation get failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-
-	b, _ := json.MarshalIndent(r, "", " ")
-	rw.Write(b)
+	resp.Operation = op
+	writeResponse(rw, resp)
 }
 
-// Delete handler is used by operators to delete a previously registered
-// hostname from DNS.
-func (s *Server) Delete(rw http.ResponseWriter, req *http.Request) {
+// DeleteSite handler is used by operators to delete all hostnames of an
+// organization at the given site, e.g. to decommission the site. DNS records
+// are removed with a single change, and the result for every hostname is
+// returned. The organization is taken from the API key found by
+// WithAPIKeyValidation.
+func (s *Server) DeleteSite(rw http.ResponseWriter, req *http.Request) {
 	// All replies, errors and successes, should be json.
 	rw.Header().Set("Content-Type", "application/json")
 
-	resp := v0.DeleteResponse{}
-	hostname := req.URL.Query().Get("hostname")
-	name, err := host.Parse(hostname)
-	if err != nil {
+	resp := v0.DeleteSiteResponse{}
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
+	}
+	if !isValidName(org) {
 		resp.Error = &v2.Error{
-			Type:   "dns.delete",
-			Title:  "failed to parse hostname",
-			Detail: err.Error(),
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
 			Status: http.StatusBadRequest,
 		}
-		log.Println("dns delete (parse) failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
-
-	m := dnsx.NewManager(s.DNS, s.Project, dnsname.OrgZone(name.Org, s.Project))
-	_, err = m.Delete(req.Context(), name.StringAll()+".")
-	if err != nil {
+	site := req.URL.Query().Get("site")
+	if !validSite.MatchString(site) {
 		resp.Error = &v2.Error{
-			Type:   "dns.delete",
-			Title:  "failed to delete hostname",
-			Detail: err.Error(),
-			Status: http.StatusInternalServerError,
+			Type:   v0.ErrInvalidParam,
+			Title:  "could not determine site from request",
+			Status: http.StatusBadRequest,
 		}
-		log.Println("dns delete failure:", err)
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
 
-	err = s.dnsTracker.Delete(name.StringAll())
+	hosts, status, err := s.dnsTracker.List()
 	if err != nil {
 		resp.Error = &v2.Error{
-			Type:   "tracker.gc",
-			Title:  "failed to delete hostname from DNS tracker",
+			Type:   v0.ErrList,
+			Title:  "failed to list node records",
 			Detail: err.Error(),
 			Status: http.StatusInternalServerError,
 		}
-		log.Println("dns gc delete failure:", err)
+		log.Println("list failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	matched := map[string]tracker.Status{}
+	fqdns := []string{}
+	for i := range hosts {
+		h, err := host.Parse(hosts[i])
+		if err != nil || h.Org != org || h.Site != site {
+			continue
+		}
+		matched[h.StringAll()] = status[i]
+		fqdns = append(fqdns, h.StringAll()+".")
+	}
+	if len(fqdns) == 0 {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotFound,
+			Title:  "no hostnames found at site",
+			Status: http.StatusNotFound,
+		}
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
+	sort.Strings(fqdns)
 
-	b, err := json.MarshalIndent(resp, "", " ")
-	rtx.Must(err, "failed to marshal DNS delete response")
-	rw.Write(b)
+	m := s.dnsManager(org, s.Domain)
+	dnsResults := m.DeleteAll(req.Context(), fqdns)
+	for _, fqdn := range fqdns {
+		hostname := strings.TrimSuffix(fqdn, ".")
+		err := dnsResults[fqdn]
+		if err == nil {
+			err = s.dnsTracker.Delete(hostname)
+		}
+		result := v0.DeleteResult{Hostname: hostname}
+		if err != nil {
+			log.Printf("delete site failure for %s: %v", hostname, err)
+			result.Error = err.Error()
+			resp.Results = append(resp.Results, result)
+			continue
+		}
+		metrics.NodeDeletions.WithLabelValues(org, "deleted").Inc()
+		if s.Decommission != nil {
+			err = s.Decommission.Report(req.Context(), hostname, matched[hostname], "deleted")
+			if err != nil {
+				log.Println("decommission report failure:", err)
+			}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	writeResponse(rw, resp)
 }
 
 // List handler is used by monitoring to generate a list of known, active
-// hostnames previously registered with the Autojoin API.
+// hostnames previously registered with the Autojoin API. Rendered results are
+// cached for ListCacheTTL and include an ETag so that clients polling
+// frequently may use If-None-Match to avoid transferring unchanged results.
+// When caching is disabled, results are streamed to the client as they are
+// rendered. Responses are gzip compressed if the client accepts it.
 func (s *Server) List(rw http.ResponseWriter, req *http.Request) {
 	// Set CORS policy to allow third-party websites to use returned resources.
 	rw.Header().Set("Content-Type", "application/json")
 	rw.Header().Set("Access-Control-Allow-Origin", "*")
-	rw.Header().Set("Cache-Control", "no-store") // Prevent caching of result.
+	// Require clients to revalidate cached results using the ETag.
+	rw.Header().Set("Cache-Control", "no-cache")
 
-	configs := []discovery.StaticConfig{}
 	resp := v0.ListResponse{}
-	hosts, ports, err := s.dnsTracker.List()
-	if err != nil {
-		resp.Error = &v2.Error{
-			Type:   "list",
-			Title:  "failed to list node records",
-			Detail: err.Error(),
-			Status: http.StatusInternalServerError,
-		}
-		log.Println("list failure:", err)
+	format := req.URL.Query().Get("format")
+	filter, invalid, ferr := parseListFilter(req)
+	if ferr != nil {
+		resp.Error = ferr
+		resp.Invalid = invalid
 		rw.WriteHeader(resp.Error.Status)
 		writeResponse(rw, resp)
 		return
 	}
 
-	org := req.URL.Query().Get("org")
-	format := req.URL.Query().Get("format")
+	key := req.URL.Query().Encode()
+	entry, ok := s.listCache.get(key)
+	if !ok {
+		hosts, status, err := s.dnsTracker.List()
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrList,
+				Title:  "failed to list node records",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("list failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if s.ListCacheTTL <= 0 || req.Header.Get("If-None-Match") == "" {
+			s.streamList(rw, req, key, format, filter, hosts, status)
+			return
+		}
+		// Conditional requests need the ETag of the complete results, so
+		// the results are rendered before responding.
+		buf := &bytes.Buffer{}
+		// NOTE: writes to a bytes.Buffer do not fail.
+		renderList(buf, req, format, filter, hosts, status)
+		ttl := s.ListCacheTTL
+		if buf.Len() > maxListCacheBytes {
+			ttl = 0
+		}
+		entry = s.listCache.put(key, buf.Bytes(), ttl)
+	}
+
+	rw.Header().Set("ETag", entry.etag)
+	if etagMatches(req.Header.Get("If-None-Match"), entry.etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w := responseWriter(rw, req)
+	defer w.Close()
+	w.Write(entry.body)
+}
+
+// streamList writes the List results to the client as they are rendered. With
+// a ListCacheTTL, the results are also cached unless they are larger than
+// maxListCacheBytes. Streamed results have no ETag, which is only known once
+// the results are complete; later requests served from the cache include it.
+func (s *Server) streamList(rw http.ResponseWriter, req *http.Request, key, format string, filter *listFilter, hosts []string, status []tracker.Status) {
+	w := responseWriter(rw, req)
+	defer w.Close()
+	buf := &boundedBuffer{limit: maxListCacheBytes}
+	var out io.Writer = w
+	if s.ListCacheTTL > 0 {
+		out = io.MultiWriter(w, buf)
+	}
+	if err := renderList(out, req, format, filter, hosts, status); err != nil {
+		// Incomplete results are never cached.
+		log.Println("list write failure:", err)
+		return
+	}
+	if s.ListCacheTTL > 0 && !buf.overflow {
+		s.listCache.put(key, buf.Bytes(), s.ListCacheTTL)
+	}
+}
+
+// renderList writes the JSON List results in the given format for all hosts
+// that match the filter. Results are sorted by hostname so that equal tracker
+// state always produces identical output. Each result is encoded as it is
+// generated rather than building the complete response in memory.
+func renderList(w io.Writer, req *http.Request, format string, filter *listFilter, hosts []string, status []tracker.Status) error {
+	var configs, servers, siteinfo *arrayEncoder
+	switch format {
+	case "script-exporter", "blackbox", "prometheus":
+		configs = newArrayEncoder(w, "[", "]", "[]")
+	case "siteinfo":
+		siteinfo = newArrayEncoder(w, "{", "}", "{}")
+	case "sites":
+		// Sites are deduplicated and written after all hosts are read.
+	default:
+		servers = newArrayEncoder(w, `{"Servers":[`, "]}", "{}")
+	}
 	sites := map[string]bool{}
+	now := time.Now()
+
+	order := make([]int, len(hosts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return hosts[order[a]] < hosts[order[b]]
+	})
 
 	// Create a prometheus StaticConfig for each known host.
-	for i := range hosts {
+	for _, i := range order {
 		h, err := host.Parse(hosts[i])
 		if err != nil {
 			continue
 		}
-		if org != "" && org != h.Org {
-			// Skip hosts that are not part of the given org.
+		if !filter.matches(h, status[i].DNS) {
+			// Skip hosts that do not match all given filters.
 			continue
 		}
+		record := status[i].DNS
+		if record == nil {
+			record = &tracker.DNSRecord{}
+		}
 		sites[h.Site] = true
+		if servers != nil {
+			servers.Encode(hosts[i])
+		}
+		if siteinfo != nil && record.Registration != nil && record.Registration.Annotation != nil {
+			// Records saved by earlier versions do not include annotations.
+			siteinfo.EncodeField(hosts[i], v0.SiteinfoAnnotation{
+				ServerAnnotation: *record.Registration.Annotation,
+				Org:              h.Org,
+			})
+		}
+		if configs == nil {
+			continue
+		}
+		ports := []string{}
 		if format == "script-exporter" {
 			// NOTE: do not assign any ports for script exporter.
-			ports[i] = []string{""}
+			ports = []string{""}
 		} else {
 			// Convert port strings to ":<port>".
-			p := []string{}
-			for j := range ports[i] {
-				p = append(p, ":"+ports[i][j])
+			for _, p := range record.Ports {
+				ports = append(ports, ":"+p)
 			}
-			ports[i] = p
 		}
-		for _, port := range ports[i] {
+		for _, port := range ports {
 			labels := map[string]string{
 				"machine":    hosts[i],
 				"type":       "virtual",
@@ -408,51 +2292,160 @@ func (s *Server) List(rw http.ResponseWriter, req *http.Request) {
 				"managed":    "none",
 				"org":        h.Org,
 			}
+			// Node labels never replace the reserved labels above.
+			for k, v := range record.Labels {
+				if !reservedLabels[k] {
+					labels[k] = v
+				}
+			}
+			for _, k := range filter.targetLabels {
+				// Empty values are omitted, as in Prometheus.
+				if v := targetLabels[k](h, record); v != "" {
+					labels[k] = v
+				}
+			}
 			if req.URL.Query().Get("service") != "" {
 				labels["service"] = req.URL.Query().Get("service")
 			}
+			if status[i].Maintenance.Active(now) {
+				labels["maintenance"] = "true"
+			}
+			if record.PendingDNS {
+				labels["dns_pending"] = "true"
+			}
 			// We create one record per host to add a unique "machine" label to each one.
-			configs = append(configs, discovery.StaticConfig{
+			configs.Encode(discovery.StaticConfig{
 				Targets: []string{hosts[i] + port},
 				Labels:  labels,
 			})
 		}
 	}
 
-	var results interface{}
-	switch format {
-	case "script-exporter":
-		fallthrough
-	case "blackbox":
-		fallthrough
-	case "prometheus":
-		results = configs
-	case "servers":
-		resp.Servers = hosts
-		results = resp
-	case "sites":
-		for k := range sites {
-			resp.Sites = append(resp.Sites, k)
-		}
-		results = resp
-	default:
-		resp.Servers = hosts
-		results = resp
+	switch {
+	case configs != nil:
+		return configs.Close()
+	case servers != nil:
+		return servers.Close()
+	case siteinfo != nil:
+		return siteinfo.Close()
 	}
-	// Generate as JSON; the list may be empty.
-	b, err := json.MarshalIndent(results, "", " ")
-	rtx.Must(err, "failed to marshal DNS delete response")
-	rw.Write(b)
+	names := make([]string, 0, len(sites))
+	for k := range sites {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	enc := newArrayEncoder(w, `{"Sites":[`, "]}", "{}")
+	for _, n := range names {
+		enc.Encode(n)
+	}
+	return enc.Close()
+}
+
+// listFilter contains the optional parameters used to select the nodes
+// returned by List. Empty fields match all nodes.
+type listFilter struct {
+	org      string
+	site     string
+	metro    string
+	service  string
+	nodeType string
+	country  string
+	labels   map[string]string
+
+	// targetLabels are the optional node fields added as target labels.
+	// They do not affect which nodes match.
+	targetLabels []string
 }
 
-// Live reports whether the system is live.
-func (s *Server) Live(rw http.ResponseWriter, req *http.Request) {
-	fmt.Fprintf(rw, "ok")
+// parseListFilter reads and validates all List filter parameters from the
+// request. The service parameter sets the service label of targets, so nodes
+// are filtered by service with node_service.
+func parseListFilter(req *http.Request) (*listFilter, []v0.InvalidParam, *v2.Error) {
+	q := req.URL.Query()
+	f := &listFilter{
+		org:      q.Get("org"),
+		site:     strings.ToLower(q.Get("site")),
+		metro:    strings.ToLower(q.Get("metro")),
+		service:  q.Get("node_service"),
+		nodeType: q.Get("type"),
+		country:  strings.ToUpper(q.Get("country")),
+		labels:   map[string]string{},
+	}
+	invalid := func(param, title, detail string) ([]v0.InvalidParam, *v2.Error) {
+		return []v0.InvalidParam{{Param: param, Code: v0.ParamInvalid, Detail: detail}}, &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  title,
+			Detail: fmt.Sprintf("invalid value of the %q parameter", param),
+			Status: http.StatusBadRequest,
+		}
+	}
+	var params []v0.InvalidParam
+	var err *v2.Error
+	switch {
+	case f.org != "" && !isValidName(f.org):
+		params, err = invalid("org", "invalid organization filter", "lowercase letters and digits")
+	case f.site != "" && !validSite.MatchString(f.site):
+		params, err = invalid("site", "invalid site filter", "a metro and a number, e.g. lga3356")
+	case f.metro != "" && !validMetro.MatchString(f.metro):
+		params, err = invalid("metro", "invalid metro filter", "three letters, e.g. lga")
+	case f.service != "" && !isValidName(f.service):
+		params, err = invalid("node_service", "invalid service filter", "lowercase letters and digits")
+	case f.nodeType != "" && !isValidType(f.nodeType):
+		params, err = invalid("type", "invalid machine type filter", "physical, virtual, or cloud")
+	case f.country != "" && !validCountry.MatchString(f.country):
+		params, err = invalid("country", "invalid country filter", "an ISO country code, e.g. US")
+	}
+	if err != nil {
+		return nil, params, err
+	}
+	for _, l := range q["labels"] {
+		for _, k := range strings.Split(l, ",") {
+			if _, ok := targetLabels[k]; !ok {
+				params, err := invalid("labels", "invalid target label",
+					"supported labels are site, metro, country, machine_type, uplink, cloud_provider, cloud_region")
+				return nil, params, err
+			}
+			f.targetLabels = append(f.targetLabels, k)
+		}
+	}
+	for _, l := range q["label"] {
+		k, v, found := strings.Cut(l, ":")
+		if !found || !validLabel.MatchString(k) {
+			params, err := invalid("label", "invalid label filter", "<key>:<value>")
+			return nil, params, err
+		}
+		f.labels[k] = v
+	}
+	return f, nil, nil
 }
 
-// Ready reports whether the server is ready.
-func (s *Server) Ready(rw http.ResponseWriter, req *http.Request) {
-	fmt.Fprintf(rw, "ok")
+// matches reports whether the given host and tracker record satisfy all
+// filter parameters. Records without metadata never match type or country
+// filters.
+func (f *listFilter) matches(h host.Name, r *tracker.DNSRecord) bool {
+	if r == nil {
+		r = &tracker.DNSRecord{}
+	}
+	switch {
+	case f.org != "" && f.org != h.Org:
+		return false
+	case f.site != "" && f.site != h.Site:
+		return false
+	case f.metro != "" && !strings.HasPrefix(h.Site, f.metro):
+		return false
+	case f.service != "" && f.service != h.Service:
+		return false
+	case f.nodeType != "" && f.nodeType != r.Type:
+		return false
+	case f.country != "" && f.country != r.Country:
+		return false
+	}
+	for k, v := range f.labels {
+		if r.Labels[k] != v {
+			return false
+		}
+	}
+	return true
 }
 
 func getClientIata(req *http.Request) string {
@@ -463,6 +2456,14 @@ func getClientIata(req *http.Request) string {
 	return ""
 }
 
+func getClientIcao(req *http.Request) string {
+	icao := req.URL.Query().Get("icao")
+	if len(icao) == 4 && isValidName(icao) {
+		return strings.ToLower(icao)
+	}
+	return ""
+}
+
 func isValidName(s string) bool {
 	if s == "" {
 		return false
@@ -475,20 +2476,13 @@ func isValidName(s string) bool {
 
 func isValidType(s string) bool {
 	switch s {
-	case "physical", "virtual":
+	case "physical", "virtual", "cloud":
 		return true
 	default:
 		return false
 	}
 }
 
-func isValidUplink(s string) bool {
-	// Minimally make sure the uplink speed specification looks like some
-	// numbers followed by "g".
-	matched, _ := regexp.MatchString("[0-9]+g", s)
-	return matched
-}
-
 func (s *Server) getCountry(req *http.Request) (string, error) {
 	c := req.URL.Query().Get("country")
 	if c != "" {
@@ -542,7 +2536,7 @@ func (s *Server) getLocation(req *http.Request) (float64, float64, error) {
 func writeResponse(rw http.ResponseWriter, resp interface{}) {
 	b, err := json.MarshalIndent(resp, "", "  ")
 	// NOTE: marshal can only fail on incompatible types, like functions. The
-	// panic will be caught by the http server handler.
+	// panic is recovered by WithRecovery.
 	rtx.PanicOnError(err, "failed to marshal response")
 	rw.Write(b)
 }
@@ -570,16 +2564,49 @@ func getClientIP(req *http.Request) string {
 	return hip
 }
 
-func getProbability(req *http.Request) float64 {
-	prob := req.URL.Query().Get("probability")
-	if prob == "" {
-		return 1.0
+// getSourceIP returns the address the request was sent from. Clients may send
+// any X-Forwarded-For header, so only the address appended by the trusted
+// front end is used, which is followed by the given number of proxy hops.
+// Headers with fewer addresses did not pass the trusted proxies and return "".
+func getSourceIP(req *http.Request, hops int) string {
+	fwd := req.Header.Get("X-Forwarded-For")
+	if fwd == "" {
+		// Without a proxy, use the remote client address.
+		hip, _, _ := net.SplitHostPort(req.RemoteAddr)
+		return hip
 	}
-	p, err := strconv.ParseFloat(prob, 64)
-	if err != nil {
-		return 1.0
+	addrs := strings.Split(fwd, ",")
+	if len(addrs) <= hops {
+		return ""
+	}
+	return strings.TrimSpace(addrs[len(addrs)-1-hops])
+}
+
+// getLabels parses all "label" parameters of the form <key>=<value>. Keys
+// must be valid Prometheus label names and may not be reserved.
+func getLabels(req *http.Request) (map[string]string, error) {
+	raw := req.URL.Query()["label"]
+	if len(raw) > maxLabels {
+		return nil, errLabelCount
+	}
+	labels := map[string]string{}
+	for _, l := range raw {
+		k, v, found := strings.Cut(l, "=")
+		switch {
+		case !found:
+			return nil, errLabelFormat
+		case !validLabel.MatchString(k):
+			return nil, errLabelKey
+		case reservedLabels[k], strings.HasPrefix(k, "__"):
+			// Prometheus reserves labels with a "__" prefix, e.g.
+			// __address__, for internal use.
+			return nil, errLabelReserved
+		case len(v) > maxLabelValueBytes:
+			return nil, errLabelValue
+		}
+		labels[k] = v
 	}
-	return p
+	return labels, nil
 }
 
 func getPorts(req *http.Request) []string {
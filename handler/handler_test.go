@@ -8,50 +8,80 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	v0 "github.com/m-lab/autojoin/api/v0"
 	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/config"
+	"github.com/m-lab/autojoin/internal/dnsx"
 	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/geonames"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/operation"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/tracker"
 	"github.com/m-lab/gcp-service-discovery/discovery"
 	"github.com/m-lab/go/host"
 	"github.com/m-lab/go/testingx"
+	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/uuid-annotator/annotator"
 	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"google.golang.org/api/dns/v1"
 )
 
 type fakeIataFinder struct {
-	iata      string
-	lookupErr error
-	loads     int
-	findRow   iata.Row
-	findErr   error
+	iata        string
+	crossBorder bool
+	lookupErr   error
+	loads       int
+	findRow     iata.Row
+	findErr     error
+	loadErr     error
+	search      []iata.Row
 }
 
-func (f *fakeIataFinder) Lookup(country string, lat, lon float64) (string, error) {
+func (f *fakeIataFinder) Nearest(country string, lat, lon float64, n int) ([]iata.Airport, error) {
 	if f.lookupErr != nil {
-		return "", f.lookupErr
+		return nil, f.lookupErr
 	}
-	return f.iata, nil
+	airports := []iata.Airport{{Row: iata.Row{IATA: f.iata, CountryCode: country}, CrossBorder: f.crossBorder}}
+	for i := 1; i < n; i++ {
+		airports = append(airports, iata.Airport{Row: iata.Row{IATA: "xyz", CountryCode: country}, Distance: float64(i)})
+	}
+	return airports, nil
 }
 func (f *fakeIataFinder) Find(airport string) (iata.Row, error) {
 	return f.findRow, f.findErr
 }
+func (f *fakeIataFinder) FindByICAO(icao string) (iata.Row, error) {
+	return f.findRow, f.findErr
+}
+func (f *fakeIataFinder) Search(query string, n int) []iata.Row {
+	return f.search
+}
 func (f *fakeIataFinder) Load(ctx context.Context) error {
 	f.loads++
-	return nil
+	return f.loadErr
 }
 
 type fakeMaxmind struct {
-	city *geoip2.City
-	err  error
+	city    *geoip2.City
+	err     error
+	anon    geoip2.AnonymousIP
+	anonErr error
 }
 
 func (f *fakeMaxmind) City(ip net.IP) (*geoip2.City, error) {
 	return f.city, f.err
 }
+func (f *fakeMaxmind) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	return &f.anon, f.anonErr
+}
 func (f *fakeMaxmind) Reload(ctx context.Context) error {
 	return nil
 }
@@ -65,12 +95,49 @@ func (f *fakeAsn) AnnotateIP(src string) *annotator.Network {
 }
 func (f *fakeAsn) Reload(ctx context.Context) {}
 
+// fakeAsnLoader is an ASNFinder that reports the errors of loads.
+type fakeAsnLoader struct {
+	fakeAsn
+	err error
+}
+
+func (f *fakeAsnLoader) Load(ctx context.Context) error {
+	return f.err
+}
+
+type fakeGeonames struct {
+	place  geonames.Place
+	err    error
+	loads  int
+	cities int
+}
+
+func (f *fakeGeonames) Nearest(country string, lat, lon float64) (geonames.Place, error) {
+	return f.place, f.err
+}
+
+func (f *fakeGeonames) Load(ctx context.Context) error {
+	f.loads++
+	return nil
+}
+
+func (f *fakeGeonames) Len() int {
+	return f.cities
+}
+
 type fakeDNS struct {
-	chgErr error
-	getErr error
+	chgErr  error
+	getErr  error
+	records []*dns.ResourceRecordSet
+	listErr error
 }
 
 func (f *fakeDNS) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (*dns.ResourceRecordSet, error) {
+	for _, rr := range f.records {
+		if rr.Name == name && rr.Type == rtype {
+			return rr, nil
+		}
+	}
 	return nil, f.getErr
 }
 func (f *fakeDNS) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
@@ -82,16 +149,58 @@ func (f *fakeDNS) CreateManagedZone(ctx context.Context, project string, zone *d
 func (f *fakeDNS) GetManagedZone(ctx context.Context, project, zoneName string) (*dns.ManagedZone, error) {
 	return nil, nil
 }
+func (f *fakeDNS) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	return nil
+}
+func (f *fakeDNS) ResourceRecordSetsList(ctx context.Context, project string, zone string) ([]*dns.ResourceRecordSet, error) {
+	return f.records, f.listErr
+}
+func (f *fakeDNS) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	rrs := []*dns.ResourceRecordSet{}
+	for _, rr := range f.records {
+		if rr.Name == name {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, f.getErr
+}
+func (f *fakeDNS) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	return &dns.Change{Id: id, Status: "done"}, nil
+}
+func (f *fakeDNS) ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error) {
+	return nil, nil
+}
+func (f *fakeDNS) DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error) {
+	return nil, nil
+}
 
 type fakeStatusTracker struct {
+	record    *tracker.DNSRecord
+	getErr    error
+	updated   *tracker.DNSRecord
 	updateErr error
 	deleteErr error
 	nodes     []string
-	ports     [][]string
+	status    []tracker.Status
 	listErr   error
+	lists     int
+
+	window         *tracker.Window
+	maintenanceErr error
+	override       *tracker.Override
+	overrideErr    error
+	hostnames      []string
+}
+
+func (f *fakeStatusTracker) Get(string) (*tracker.Status, error) {
+	if f.record == nil && f.override == nil {
+		return nil, f.getErr
+	}
+	return &tracker.Status{DNS: f.record, Override: f.override}, f.getErr
 }
 
-func (f *fakeStatusTracker) Update(string, []string) error {
+func (f *fakeStatusTracker) Update(hostname string, r *tracker.DNSRecord) error {
+	f.updated = r
 	return f.updateErr
 }
 
@@ -99,8 +208,35 @@ func (f *fakeStatusTracker) Delete(string) error {
 	return f.deleteErr
 }
 
-func (f *fakeStatusTracker) List() ([]string, [][]string, error) {
-	return f.nodes, f.ports, f.listErr
+func (f *fakeStatusTracker) SetMaintenance(hostname string, w *tracker.Window) error {
+	f.window = w
+	return f.maintenanceErr
+}
+
+func (f *fakeStatusTracker) SetOverride(hostname string, o *tracker.Override) error {
+	if f.overrideErr != nil {
+		return f.overrideErr
+	}
+	f.override = o
+	return nil
+}
+
+func (f *fakeStatusTracker) Hostnames(ipv4 string) []string {
+	return f.hostnames
+}
+
+func (f *fakeStatusTracker) List() ([]string, []tracker.Status, error) {
+	f.lists++
+	return f.nodes, f.status, f.listErr
+}
+
+// withPorts returns a tracker Status for each list of ports.
+func withPorts(ports ...[]string) []tracker.Status {
+	s := []tracker.Status{}
+	for _, p := range ports {
+		s = append(s, tracker.Status{DNS: &tracker.DNSRecord{Ports: p}})
+	}
+	return s
 }
 
 type fakeSecretManager struct {
@@ -114,13 +250,15 @@ func (f *fakeSecretManager) LoadOrCreateKey(ctx context.Context, org string) (st
 
 func TestServer_Lookup(t *testing.T) {
 	tests := []struct {
-		name     string
-		iata     *fakeIataFinder
-		maxmind  *fakeMaxmind
-		request  string
-		headers  map[string]string
-		wantCode int
-		wantIata string
+		name         string
+		iata         *fakeIataFinder
+		maxmind      *fakeMaxmind
+		request      string
+		headers      map[string]string
+		wantCode     int
+		wantIata     string
+		wantAirports int
+		wantCross    bool
 	}{
 		{
 			name:     "success-parameters",
@@ -178,6 +316,28 @@ func TestServer_Lookup(t *testing.T) {
 			request:  "?country=US&lat=ten&lon=twelve",
 			wantCode: http.StatusBadRequest,
 		},
+		{
+			name:         "success-results",
+			iata:         &fakeIataFinder{iata: "jfk"},
+			request:      "?country=US&lat=43&lon=-70&results=3",
+			wantCode:     http.StatusOK,
+			wantIata:     "jfk",
+			wantAirports: 3,
+		},
+		{
+			name:      "success-cross-border",
+			iata:      &fakeIataFinder{iata: "jfk", crossBorder: true},
+			request:   "?country=PM&lat=46.8&lon=-56.2",
+			wantCode:  http.StatusOK,
+			wantIata:  "jfk",
+			wantCross: true,
+		},
+		{
+			name:     "error-results",
+			iata:     &fakeIataFinder{iata: "jfk"},
+			request:  "?country=US&lat=43&lon=-70&results=100",
+			wantCode: http.StatusBadRequest,
+		},
 		{
 			name:     "error-lookup",
 			iata:     &fakeIataFinder{lookupErr: errors.New("fake error")},
@@ -256,6 +416,236 @@ func TestServer_Lookup(t *testing.T) {
 			if rw.Code == http.StatusOK && (resp.Lookup == nil || resp.Lookup.IATA != tt.wantIata) {
 				t.Errorf("Lookup() returned wrong iata; got %#v, want %s", resp, tt.wantIata)
 			}
+			if resp.Lookup != nil && resp.Lookup.CrossBorder != tt.wantCross {
+				t.Errorf("Lookup() returned wrong cross border; got %v, want %v", resp.Lookup.CrossBorder, tt.wantCross)
+			}
+			if resp.Lookup != nil && len(resp.Lookup.Airports) != tt.wantAirports {
+				t.Errorf("Lookup() returned wrong airports; got %d, want %d", len(resp.Lookup.Airports), tt.wantAirports)
+			}
+		})
+	}
+}
+
+func TestServer_SearchAirports(t *testing.T) {
+	found := []iata.Row{
+		{IATA: "lga", ICAO: "klga", Airport: "LaGuardia Airport", CountryCode: "US"},
+		{IATA: "jfk", ICAO: "kjfk", Airport: "John F. Kennedy International Airport", CountryCode: "US"},
+	}
+	tests := []struct {
+		name     string
+		request  string
+		search   []iata.Row
+		wantCode int
+		wantIata string
+	}{
+		{
+			name:     "success",
+			request:  "?name=new+york&results=2",
+			search:   found,
+			wantCode: http.StatusOK,
+			wantIata: "lga",
+		},
+		{
+			name:     "error-not-found",
+			request:  "?name=nowhere",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-missing-name",
+			request:  "?results=2",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-results",
+			request:  "?name=new+york&results=0",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{search: tt.search}, &fakeMaxmind{}, &fakeAsn{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/lookup/search"+tt.request, nil)
+			s.SearchAirports(rw, req)
+			if rw.Code != tt.wantCode {
+				t.Errorf("SearchAirports() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := &v0.LookupResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), resp), "failed to parse response")
+			if rw.Code == http.StatusOK && (resp.Lookup.IATA != tt.wantIata || len(resp.Lookup.Airports) != len(tt.search)) {
+				t.Errorf("SearchAirports() returned wrong lookup; got %#v, want %s", resp.Lookup, tt.wantIata)
+			}
+		})
+	}
+}
+
+func TestServer_LookupBatch(t *testing.T) {
+	city := &geoip2.City{}
+	city.Country.IsoCode = "US"
+	tests := []struct {
+		name       string
+		body       string
+		iata       *fakeIataFinder
+		maxmind    *fakeMaxmind
+		wantCode   int
+		wantIata   []string
+		wantErrors int
+	}{
+		{
+			name:     "success",
+			body:     `[{"IP": "192.0.2.1"}, {"Country": "US", "Latitude": 40.7, "Longitude": -73.9}]`,
+			iata:     &fakeIataFinder{iata: "lga"},
+			maxmind:  &fakeMaxmind{city: city},
+			wantCode: http.StatusOK,
+			wantIata: []string{"lga", "lga"},
+		},
+		{
+			name:       "success-with-errors",
+			body:       `[{"IP": "invalid"}, {"Latitude": 40.7, "Longitude": -73.9}, {"IP": "192.0.2.1"}]`,
+			iata:       &fakeIataFinder{iata: "lga"},
+			maxmind:    &fakeMaxmind{err: errors.New("fake error")},
+			wantCode:   http.StatusOK,
+			wantIata:   []string{"", "", ""},
+			wantErrors: 3,
+		},
+		{
+			name:       "success-lookup-error",
+			body:       `[{"Country": "US"}]`,
+			iata:       &fakeIataFinder{lookupErr: iata.ErrNoAirports},
+			wantCode:   http.StatusOK,
+			wantIata:   []string{""},
+			wantErrors: 1,
+		},
+		{
+			name:     "error-empty",
+			body:     `[]`,
+			iata:     &fakeIataFinder{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-body",
+			body:     `{`,
+			iata:     &fakeIataFinder{},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", tt.iata, tt.maxmind, &fakeAsn{ann: &annotator.Network{ASNumber: 64512}}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/lookup/batch", strings.NewReader(tt.body))
+			s.LookupBatch(rw, req)
+			if rw.Code != tt.wantCode {
+				t.Errorf("LookupBatch() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := &v0.BatchLookupResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), resp), "failed to parse response")
+			if len(resp.Results) != len(tt.wantIata) {
+				t.Fatalf("LookupBatch() returned wrong results; got %d, want %d", len(resp.Results), len(tt.wantIata))
+			}
+			errs := 0
+			for i, r := range resp.Results {
+				if r.IATA != tt.wantIata[i] {
+					t.Errorf("LookupBatch() returned wrong iata; got %q, want %q", r.IATA, tt.wantIata[i])
+				}
+				if r.Error != nil {
+					errs++
+				}
+				if r.IP != "" && r.Error == nil && r.ASN != 64512 {
+					t.Errorf("LookupBatch() returned wrong asn; got %d, want 64512", r.ASN)
+				}
+			}
+			if errs != tt.wantErrors {
+				t.Errorf("LookupBatch() returned wrong errors; got %d, want %d", errs, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestServer_ValidateMetro(t *testing.T) {
+	// LGA is about 9 km from New York, 440 km from Portland, Maine, and
+	// 1,770 km from Miami.
+	lga := iata.Row{IATA: "lga", CountryCode: "US", Latitude: 40.775, Longitude: -73.875}
+	city := func(country string, lat, lon float64) *geoip2.City {
+		c := &geoip2.City{}
+		c.Country.IsoCode = country
+		c.Location.Latitude, c.Location.Longitude = lat, lon
+		return c
+	}
+	tests := []struct {
+		name        string
+		request     string
+		iata        *fakeIataFinder
+		maxmind     *fakeMaxmind
+		wantCode    int
+		wantVerdict string
+	}{
+		{
+			name:        "pass",
+			request:     "?iata=lga&ipv4=192.0.2.1",
+			iata:        &fakeIataFinder{findRow: lga},
+			maxmind:     &fakeMaxmind{city: city("US", 40.7, -73.9)},
+			wantCode:    http.StatusOK,
+			wantVerdict: v0.MetroPass,
+		},
+		{
+			name:        "warn-distance",
+			request:     "?iata=lga&ipv4=192.0.2.1",
+			iata:        &fakeIataFinder{findRow: lga},
+			maxmind:     &fakeMaxmind{city: city("US", 43.66, -70.26)},
+			wantCode:    http.StatusOK,
+			wantVerdict: v0.MetroWarn,
+		},
+		{
+			name:        "warn-country",
+			request:     "?iata=lga&ipv4=192.0.2.1",
+			iata:        &fakeIataFinder{findRow: lga},
+			maxmind:     &fakeMaxmind{city: city("CA", 40.7, -73.9)},
+			wantCode:    http.StatusOK,
+			wantVerdict: v0.MetroWarn,
+		},
+		{
+			name:        "fail",
+			request:     "?iata=lga&ipv4=192.0.2.1",
+			iata:        &fakeIataFinder{findRow: lga},
+			maxmind:     &fakeMaxmind{city: city("US", 25.76, -80.19)},
+			wantCode:    http.StatusOK,
+			wantVerdict: v0.MetroFail,
+		},
+		{
+			name:     "error-missing-iata",
+			request:  "?ipv4=192.0.2.1",
+			iata:     &fakeIataFinder{findRow: lga},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-unknown-iata",
+			request:  "?iata=xyz&ipv4=192.0.2.1",
+			iata:     &fakeIataFinder{findErr: iata.ErrNoAirports},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-geo",
+			request:  "?iata=lga&ipv4=192.0.2.1",
+			iata:     &fakeIataFinder{findRow: lga},
+			maxmind:  &fakeMaxmind{err: errors.New("fake error")},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", tt.iata, tt.maxmind, &fakeAsn{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/lookup/validate"+tt.request, nil)
+			s.ValidateMetro(rw, req)
+			if rw.Code != tt.wantCode {
+				t.Errorf("ValidateMetro() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := &v0.MetroValidationResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), resp), "failed to parse response")
+			if rw.Code == http.StatusOK && (resp.Validation == nil || resp.Validation.Verdict != tt.wantVerdict) {
+				t.Errorf("ValidateMetro() returned wrong verdict; got %#v, want %s", resp.Validation, tt.wantVerdict)
+			}
 		})
 	}
 }
@@ -269,16 +659,34 @@ func TestServer_Reload(t *testing.T) {
 			t.Errorf("Reload failed to call iata loader")
 		}
 	})
+	t.Run("success-geonames", func(t *testing.T) {
+		g := &fakeGeonames{}
+		s := NewServer("mlab-sandbox", &fakeIataFinder{}, &fakeMaxmind{}, &fakeAsnLoader{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+		s.Geonames = g
+		s.Reload(context.Background())
+		if g.loads != 1 {
+			t.Errorf("Reload failed to call geonames loader")
+		}
+	})
 }
 
-func TestServer_LiveAndReady(t *testing.T) {
-	t.Run("success", func(t *testing.T) {
-		s := NewServer("mlab-sandbox", &fakeIataFinder{}, &fakeMaxmind{}, &fakeAsn{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
-		rw := httptest.NewRecorder()
-		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		s.Live(rw, req)
-		s.Ready(rw, req)
-	})
+// withKeyOrg returns req with the key info WithAPIKeyValidation would add for
+// a key of the organization named by the request, if any.
+func withKeyOrg(req *http.Request) *http.Request {
+	org := req.URL.Query().Get("organization")
+	if org == "" {
+		org = req.URL.Query().Get("org")
+	}
+	if org == "" {
+		return req
+	}
+	return withKey(req, org)
+}
+
+// withKey returns req with the key info WithAPIKeyValidation would add for a
+// key of the given organization.
+func withKey(req *http.Request, org string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{Org: org, Scopes: keys.DefaultScopes}))
 }
 
 func TestServer_Register(t *testing.T) {
@@ -328,16 +736,18 @@ func TestServer_Register(t *testing.T) {
 	}
 
 	tests := []struct {
-		name     string
-		Iata     IataFinder
-		Maxmind  MaxmindFinder
-		ASN      ASNFinder
-		DNS      dnsiface.Service
-		Tracker  DNSTracker
-		sm       ServiceAccountSecretManager
-		params   string
-		wantName string
-		wantCode int
+		name        string
+		Iata        IataFinder
+		Maxmind     MaxmindFinder
+		ASN         ASNFinder
+		DNS         dnsiface.Service
+		Tracker     DNSTracker
+		sm          ServiceAccountSecretManager
+		params      string
+		wantName    string
+		wantAliases []string
+		wantCode    int
+		wantInvalid []string
 	}{
 		{
 			name:    "success",
@@ -354,8 +764,8 @@ func TestServer_Register(t *testing.T) {
 			wantCode: http.StatusOK,
 		},
 		{
-			name:    "success-probability-invalid-ports-invalid",
-			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=invalid&ports=invalid&type=virtual&uplink=10g",
+			name:    "success-services",
+			params:  "?service=foo&services=msak&services=wehe&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
 			Iata:    iataFinder,
 			Maxmind: maxmind,
 			ASN:     fakeASN,
@@ -365,188 +775,2202 @@ func TestServer_Register(t *testing.T) {
 				key: "fake key data",
 			},
 			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantAliases: []string{
+				"msak-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+				"wehe-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			},
 			wantCode: http.StatusOK,
 		},
 		{
-			name:     "error-service-empty",
-			params:   "?service=",
-			wantCode: http.StatusBadRequest,
+			name:     "success-icao",
+			params:   "?service=foo&organization=bar&icao=klga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			Iata:     iataFinder,
+			Maxmind:  maxmind,
+			ASN:      fakeASN,
+			DNS:      &fakeDNS{},
+			Tracker:  &fakeStatusTracker{},
+			sm:       &fakeSecretManager{key: "fake key data"},
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
 		},
 		{
-			name:     "error-service-too-long",
-			params:   "?service=abcdefghijklm",
-			wantCode: http.StatusBadRequest,
+			name:     "success-location",
+			params:   "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&lat=-10.5&lon=-9.5&city=Somewhere",
+			Iata:     iataFinder,
+			Maxmind:  maxmind,
+			ASN:      fakeASN,
+			DNS:      &fakeDNS{},
+			Tracker:  &fakeStatusTracker{},
+			sm:       &fakeSecretManager{key: "fake key data"},
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
 		},
 		{
-			name:     "error-bad-organization",
-			params:   "?service=foo&organization=-BAD-NAME-",
-			wantCode: http.StatusBadRequest,
+			name:        "error-location-too-far",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&lat=40.7&lon=-73.9",
+			Iata:        iataFinder,
+			Maxmind:     maxmind,
+			ASN:         fakeASN,
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"lat:out_of_range"},
 		},
 		{
-			name:     "error-bad-type",
-			params:   "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=0.5&ports=9990&type=dell&uplink=50g",
-			wantCode: http.StatusBadRequest,
+			name:        "error-location-invalid",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&lat=91&city=%00",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"lon:missing", "lat:out_of_range", "city:invalid"},
 		},
 		{
-			name:     "error-bad-uplink",
-			params:   "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=0.5&ports=9990&type=virtual&uplink=10",
-			wantCode: http.StatusBadRequest,
+			name:        "error-icao-invalid",
+			params:      "?service=foo&organization=bar&icao=lga&ipv4=192.168.0.1&type=virtual&uplink=10g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"icao:invalid"},
 		},
 		{
-			name:     "error-bad-ip",
-			params:   "?service=foo&organization=bar&ipv4=-BAD-IP-",
-			wantCode: http.StatusBadRequest,
+			name:        "error-services-invalid",
+			params:      "?service=foo&services=foo&services=BAD&organization=bar&iata=lga&ipv4=192.168.0.1&type=virtual&uplink=10g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"services:invalid", "services:invalid"},
 		},
 		{
-			name:     "error-invalid-iata",
-			params:   "?service=foo&organization=bar&ipv4=192.168.0.1&iata=-invalid-",
-			wantCode: http.StatusBadRequest,
+			name:        "error-probability-invalid-ports-invalid",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=invalid&ports=invalid&type=virtual&uplink=10g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"probability:invalid", "ports:invalid"},
 		},
 		{
-			name:     "error-bad-iata-find",
-			Iata:     &fakeIataFinder{findErr: errors.New("find err")},
-			params:   "?service=foo&organization=bar&ipv4=192.168.0.1&iata=123&type=physical&uplink=20g",
-			wantCode: http.StatusInternalServerError,
+			name:        "error-probability-out-of-range-port-zero",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=1.5&ports=9990&ports=0&type=virtual&uplink=10g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"probability:out_of_range", "ports:invalid"},
 		},
 		{
-			name:     "error-bad-maxmind-city",
-			Iata:     &fakeIataFinder{findRow: iata.Row{}},
-			Maxmind:  &fakeMaxmind{err: errors.New("fake maxmind error")},
-			params:   "?service=foo&organization=bar&ipv4=192.168.0.1&iata=abc&type=virtual&uplink=1000g",
-			wantCode: http.StatusInternalServerError,
+			name:        "error-dns-ttl",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=virtual&uplink=10g&dns_ttl=5",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"dns_ttl:out_of_range"},
 		},
 		{
-			name:    "error-loading-key",
-			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			name:        "error-all-invalid",
+			params:      "?service=abcdefghijklm&ipv4=-BAD-IP-&iata=-invalid-&type=dell&uplink=10",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"service:invalid", "organization:missing", "ipv4:invalid", "type:invalid", "uplink:invalid", "iata:invalid"},
+		},
+		{
+			name:        "error-cloud-invalid",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=cloud&uplink=10g&provider=GCP&region=us_east1",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"provider:invalid", "region:invalid"},
+		},
+		{
+			name:        "error-cloud-not-cloud-type",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&region=us-east1",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"region:invalid"},
+		},
+		{
+			name:        "error-unknown-uplink",
+			params:      "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=20g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: []string{"uplink:invalid"},
+		},
+		{
+			name:    "success-labels",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&label=rack=r1&label=provider=acme",
 			Iata:    iataFinder,
 			Maxmind: maxmind,
 			ASN:     fakeASN,
-			DNS:     &fakeDNS{getErr: errors.New("fake get error")},
+			DNS:     &fakeDNS{},
+			Tracker: &fakeStatusTracker{},
 			sm: &fakeSecretManager{
-				err: fmt.Errorf("fake key load error"),
+				key: "fake key data",
 			},
-			wantCode: http.StatusInternalServerError,
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
 		},
 		{
-			name:    "error-registration",
-			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=virtual&uplink=1g",
+			name:    "success-dns-ttl",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dns_ttl=60",
 			Iata:    iataFinder,
 			Maxmind: maxmind,
 			ASN:     fakeASN,
-			DNS:     &fakeDNS{getErr: errors.New("fake get error")},
+			DNS:     &fakeDNS{},
+			Tracker: &fakeStatusTracker{},
 			sm: &fakeSecretManager{
 				key: "fake key data",
 			},
-			wantCode: http.StatusInternalServerError,
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
 		},
 		{
-			name:    "error-tracker-update-error",
-			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=20g",
+			name:    "success-override",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=1.0&type=physical&uplink=10g",
 			Iata:    iataFinder,
 			Maxmind: maxmind,
 			ASN:     fakeASN,
 			DNS:     &fakeDNS{},
-			Tracker: &fakeStatusTracker{updateErr: errors.New("update error")},
+			Tracker: &fakeStatusTracker{override: &tracker.Override{Enabled: true, Probability: 0}},
 			sm: &fakeSecretManager{
 				key: "fake key data",
 			},
 			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
-			wantCode: http.StatusInternalServerError,
+			wantCode: http.StatusOK,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := NewServer("mlab-sandbox", tt.Iata, tt.Maxmind, tt.ASN, tt.DNS, tt.Tracker, tt.sm)
-			rw := httptest.NewRecorder()
-			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+tt.params, nil)
-
-			s.Register(rw, req)
-
-			if rw.Code != tt.wantCode {
-				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+		{
+			name:    "success-dry-run-override",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true",
+			Iata:    iataFinder,
+			Maxmind: maxmind,
+			ASN:     fakeASN,
+			DNS:     &fakeDNS{},
+			Tracker: &fakeStatusTracker{override: &tracker.Override{Enabled: true, Probability: 0.25}},
+			sm: &fakeSecretManager{
+				err: fmt.Errorf("fake key load error"),
+			},
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:    "success-dry-run",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true",
+			Iata:    iataFinder,
+			Maxmind: maxmind,
+			ASN:     fakeASN,
+			DNS:     &fakeDNS{getErr: errors.New("fake get error")},
+			Tracker: &fakeStatusTracker{updateErr: errors.New("update error")},
+			sm: &fakeSecretManager{
+				err: fmt.Errorf("fake key load error"),
+			},
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-bad-label",
+			params:   "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&label=machine=foo",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-service-empty",
+			params:   "?service=",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-service-too-long",
+			params:   "?service=abcdefghijklm",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-organization",
+			params:   "?service=foo&organization=-BAD-NAME-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-type",
+			params:   "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=0.5&ports=9990&type=dell&uplink=10g",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-uplink",
+			params:   "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&probability=0.5&ports=9990&type=virtual&uplink=10",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-ip",
+			params:   "?service=foo&organization=bar&ipv4=-BAD-IP-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-invalid-iata",
+			params:   "?service=foo&organization=bar&ipv4=192.168.0.1&iata=-invalid-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-bad-iata-find",
+			Iata:     &fakeIataFinder{findErr: errors.New("find err")},
+			params:   "?service=foo&organization=bar&ipv4=192.168.0.1&iata=123&type=physical&uplink=25g",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-bad-maxmind-city",
+			Iata:     &fakeIataFinder{findRow: iata.Row{}},
+			Maxmind:  &fakeMaxmind{err: errors.New("fake maxmind error")},
+			params:   "?service=foo&organization=bar&ipv4=192.168.0.1&iata=abc&type=virtual&uplink=100g",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:    "error-loading-key",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			Iata:    iataFinder,
+			Maxmind: maxmind,
+			ASN:     fakeASN,
+			DNS:     &fakeDNS{getErr: errors.New("fake get error")},
+			Tracker: &fakeStatusTracker{},
+			sm: &fakeSecretManager{
+				err: fmt.Errorf("fake key load error"),
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:    "error-registration",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=virtual&uplink=1g",
+			Iata:    iataFinder,
+			Maxmind: maxmind,
+			ASN:     fakeASN,
+			DNS:     &fakeDNS{getErr: errors.New("fake get error")},
+			Tracker: &fakeStatusTracker{},
+			sm: &fakeSecretManager{
+				key: "fake key data",
+			},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:    "error-tracker-update-error",
+			params:  "?service=foo&organization=bar&iata=lga&ipv4=192.168.0.1&type=physical&uplink=25g",
+			Iata:    iataFinder,
+			Maxmind: maxmind,
+			ASN:     fakeASN,
+			DNS:     &fakeDNS{},
+			Tracker: &fakeStatusTracker{updateErr: errors.New("update error")},
+			sm: &fakeSecretManager{
+				key: "fake key data",
+			},
+			wantName: "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", tt.Iata, tt.Maxmind, tt.ASN, tt.DNS, tt.Tracker, tt.sm)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+tt.params, nil)
+			req = withKeyOrg(req)
+			org := requestOrg(req)
+			registered := testutil.ToFloat64(metrics.NodeRegistrations.WithLabelValues(org))
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+
+			// Check response content is valid.
+			resp := v0.RegisterResponse{}
+			raw := rw.Body.Bytes()
+			err := json.Unmarshal(raw, &resp)
+			testingx.Must(t, err, "failed to unmarshal response")
+
+			wantRegistered := registered
+			if resp.Error == nil && !strings.Contains(tt.params, "dry_run=true") {
+				wantRegistered++
+			}
+			if got := testutil.ToFloat64(metrics.NodeRegistrations.WithLabelValues(org)); got != wantRegistered {
+				t.Errorf("Register() counted wrong registrations; got %v, want %v", got, wantRegistered)
+			}
+			if resp.Error != nil && testutil.ToFloat64(metrics.RegistrationsRejected.WithLabelValues(org, resp.Error.Type)) == 0 {
+				t.Errorf("Register() did not count rejection %q", resp.Error.Type)
+			}
+
+			// One or the other should be defined.
+			if resp.Error == nil && resp.Registration == nil {
+				t.Errorf("Register() returned empty result; got %q", raw)
+			}
+			if tt.wantInvalid != nil {
+				if resp.Error.Type != v0.ErrInvalidParam {
+					t.Errorf("Register() returned wrong error type; got %q, want %q", resp.Error.Type, v0.ErrInvalidParam)
+				}
+				got := []string{}
+				for _, p := range resp.Invalid {
+					got = append(got, p.Param+":"+p.Code)
+				}
+				if strings.Join(got, ",") != strings.Join(tt.wantInvalid, ",") {
+					t.Errorf("Register() returned wrong invalid params; got %v, want %v", got, tt.wantInvalid)
+				}
+			}
+			// Do not value check error cases.
+			if rw.Code != http.StatusOK {
+				return
+			}
+
+			if resp.Registration.Hostname != tt.wantName {
+				t.Errorf("Register() returned wrong hostname; got %s, want %s", resp.Registration.Hostname, tt.wantName)
+			}
+
+			if !reflect.DeepEqual(resp.Registration.Aliases, tt.wantAliases) {
+				t.Errorf("Register() returned wrong aliases; got %v, want %v", resp.Registration.Aliases, tt.wantAliases)
+			}
+			if _, err := host.Parse(resp.Registration.Hostname); err != nil {
+				t.Errorf("Register() returned unparsable hostname; got %v, want nil", err)
+			}
+			if ft, ok := tt.Tracker.(*fakeStatusTracker); ok && ft.updated != nil && ft.updated.Registration.Credentials != nil {
+				t.Errorf("Register() saved credentials in the DNS tracker")
+			}
+			if strings.Contains(tt.params, "dry_run=true") {
+				if resp.Registration.Credentials != nil || tt.Tracker.(*fakeStatusTracker).updated != nil {
+					t.Errorf("Register() dry run loaded credentials or updated the DNS tracker")
+				}
+			}
+			if ft, ok := tt.Tracker.(*fakeStatusTracker); ok && ft.override != nil {
+				if p := resp.Registration.Heartbeat.Probability; p != ft.override.Probability {
+					t.Errorf("Register() did not apply override; got %f, want %f", p, ft.override.Probability)
+				}
+			}
+
+		})
+	}
+}
+
+func TestServer_RegisterPendingDNS(t *testing.T) {
+	ft := &fakeStatusTracker{}
+	s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+		&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{getErr: dnsx.ErrCircuitOpen}, ft,
+		&fakeSecretManager{key: "fake key data"})
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g", nil)
+	req = withKeyOrg(req)
+
+	s.Register(rw, req)
+
+	resp := v0.RegisterResponse{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+	if rw.Code != http.StatusOK || resp.Registration == nil {
+		t.Fatalf("Register() = %d %+v, want registration", rw.Code, resp.Error)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0].Type != v0.WarnDNSPending {
+		t.Errorf("Register() Warnings = %v, want %s", resp.Warnings, v0.WarnDNSPending)
+	}
+	if ft.updated == nil || !ft.updated.PendingDNS {
+		t.Errorf("Register() did not save pending DNS record; got %+v", ft.updated)
+	}
+}
+
+func TestServer_RegisterUplink(t *testing.T) {
+	ft := &fakeStatusTracker{}
+	s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+		&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{}, ft, &fakeSecretManager{key: "fake key data"})
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=2.5Gbps", nil)
+	req = withKeyOrg(req)
+
+	s.Register(rw, req)
+
+	resp := v0.RegisterResponse{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+	if rw.Code != http.StatusOK || resp.Registration == nil {
+		t.Fatalf("Register() = %d %+v, want registration", rw.Code, resp.Error)
+	}
+	if resp.Registration.Heartbeat.Uplink != "2.5g" {
+		t.Errorf("Register() Uplink = %q, want 2.5g", resp.Registration.Heartbeat.Uplink)
+	}
+	if ft.updated == nil || ft.updated.Uplink != "2.5g" || ft.updated.UplinkMbps != 2500 {
+		t.Errorf("Register() saved wrong uplink; got %+v", ft.updated)
+	}
+}
+
+func TestServer_RegisterOrganization(t *testing.T) {
+	tests := []struct {
+		name        string
+		keyOrg      string
+		params      string
+		wantCode    int
+		wantInvalid string
+		wantHost    string
+	}{
+		{
+			name:     "success-from-key",
+			keyOrg:   "mlab",
+			params:   "?service=ndt&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			wantCode: http.StatusOK,
+			wantHost: "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org",
+		},
+		{
+			name:     "success-matching",
+			keyOrg:   "mlab",
+			params:   "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			wantCode: http.StatusOK,
+			wantHost: "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org",
+		},
+		{
+			name:        "error-mismatched",
+			keyOrg:      "mlab",
+			params:      "?service=ndt&organization=other&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "organization",
+		},
+		{
+			name:        "error-no-key",
+			params:      "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g",
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "organization",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{}, &fakeStatusTracker{}, &fakeSecretManager{key: "fake key data"})
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+tt.params, nil)
+			if tt.keyOrg != "" {
+				req = req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{Org: tt.keyOrg}))
+			}
+
+			s.Register(rw, req)
+
+			resp := v0.RegisterResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if rw.Code != tt.wantCode {
+				t.Fatalf("Register() = %d %+v, want %d", rw.Code, resp.Error, tt.wantCode)
+			}
+			if tt.wantInvalid != "" && (len(resp.Invalid) != 1 || resp.Invalid[0].Param != tt.wantInvalid) {
+				t.Errorf("Register() Invalid = %+v, want %s", resp.Invalid, tt.wantInvalid)
+			}
+			if tt.wantHost != "" && (resp.Registration == nil || resp.Registration.Hostname != tt.wantHost) {
+				t.Errorf("Register() Registration = %+v, want hostname %s", resp.Registration, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterCloud(t *testing.T) {
+	ft := &fakeStatusTracker{}
+	s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+		&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{}, ft, &fakeSecretManager{key: "fake key data"})
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=cloud&uplink=10g&provider=gcp&region=us-east1", nil)
+	req = withKeyOrg(req)
+
+	s.Register(rw, req)
+
+	resp := v0.RegisterResponse{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+	if rw.Code != http.StatusOK || resp.Registration == nil {
+		t.Fatalf("Register() = %d %+v, want registration", rw.Code, resp.Error)
+	}
+	a := resp.Registration.Annotation
+	if a.Type != "cloud" || a.Cloud == nil || *a.Cloud != (v0.Cloud{Provider: "gcp", Region: "us-east1"}) {
+		t.Errorf("Register() annotation Type = %q, Cloud = %+v, want cloud in gcp us-east1", a.Type, a.Cloud)
+	}
+	if ft.updated == nil || ft.updated.Type != "cloud" || ft.updated.CloudProvider != "gcp" || ft.updated.CloudRegion != "us-east1" {
+		t.Errorf("Register() saved wrong cloud hosting; got %+v", ft.updated)
+	}
+}
+
+func TestServer_RegisterCachedDNS(t *testing.T) {
+	txt := `"org=mlab" "service=ndt" "type=physical" "uplink=10g" "registered=1970-01-01T00:00:01Z"`
+	tests := []struct {
+		name     string
+		rrdata   *tracker.Rrdata
+		pending  bool
+		wantCode int
+	}{
+		{
+			name:     "success-cached",
+			rrdata:   &tracker.Rrdata{A: "192.168.0.1", TXT: txt, Registered: 1},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-changed-address",
+			rrdata:   &tracker.Rrdata{A: "192.168.0.2", TXT: txt, Registered: 1},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-changed-metadata",
+			rrdata:   &tracker.Rrdata{A: "192.168.0.1", TXT: strings.Replace(txt, "physical", "virtual", 1), Registered: 1},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-pending",
+			rrdata:   &tracker.Rrdata{A: "192.168.0.1", TXT: txt, Registered: 1},
+			pending:  true,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := &fakeStatusTracker{record: &tracker.DNSRecord{Rrdata: tt.rrdata, PendingDNS: tt.pending}}
+			// Cloud DNS fails whenever it is used.
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{getErr: errors.New("fake get error")}, ft,
+				&fakeSecretManager{key: "fake key data"})
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g", nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Fatalf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if rw.Code == http.StatusOK && !reflect.DeepEqual(ft.updated.Rrdata, tt.rrdata) {
+				t.Errorf("Register() saved wrong rrdata; got %+v, want %+v", ft.updated.Rrdata, tt.rrdata)
+			}
+		})
+	}
+}
+
+// changeDNS creates changes with the given status, e.g. "pending".
+type changeDNS struct {
+	fakeDNS
+	status string
+}
+
+func (c *changeDNS) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
+	return &dns.Change{Id: "1", Status: c.status}, nil
+}
+
+func (c *changeDNS) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	return &dns.Change{Id: id, Status: c.status}, nil
+}
+
+func TestServer_RegisterVerifyDNS(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		wantDNS    string
+		wantChange string
+	}{
+		{
+			name:    "success-done",
+			status:  "done",
+			wantDNS: v0.DNSApplied,
+		},
+		{
+			name:       "success-propagating",
+			status:     "pending",
+			wantDNS:    v0.DNSPropagating,
+			wantChange: "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := &fakeStatusTracker{}
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &changeDNS{status: tt.status}, ft,
+				&fakeSecretManager{key: "fake key data"})
+			s.VerifyDNS = 10 * time.Millisecond
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g", nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != http.StatusOK {
+				t.Fatalf("Register() returned wrong code; got %d, want %d", rw.Code, http.StatusOK)
+			}
+			resp := v0.RegisterResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if resp.DNS != tt.wantDNS {
+				t.Errorf("Register() returned wrong DNS status; got %q, want %q", resp.DNS, tt.wantDNS)
+			}
+			if ft.updated.DNSChange != tt.wantChange {
+				t.Errorf("Register() saved wrong DNS change; got %q, want %q", ft.updated.DNSChange, tt.wantChange)
+			}
+		})
+	}
+}
+
+// blockingDNS blocks listings of records until the context is done.
+type blockingDNS struct {
+	fakeDNS
+}
+
+func (b *blockingDNS) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestServer_RegisterTimeout(t *testing.T) {
+	s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+		&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &blockingDNS{}, &fakeStatusTracker{},
+		&fakeSecretManager{key: "fake key data"})
+	s.RegisterTimeout = 10 * time.Millisecond
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g", nil)
+	req = withKeyOrg(req)
+
+	s.Register(rw, req)
+
+	resp := v0.RegisterResponse{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+	if rw.Code != http.StatusInternalServerError || resp.Error == nil || resp.Error.Type != v0.ErrDNSRegister {
+		t.Errorf("Register() = %d %+v, want %s", rw.Code, resp.Error, v0.ErrDNSRegister)
+	}
+}
+
+type fakeDNSQueue struct {
+	hostnames []string
+}
+
+func (f *fakeDNSQueue) Enqueue(hostname string) {
+	f.hostnames = append(f.hostnames, hostname)
+}
+
+func TestServer_RegisterAsyncDNS(t *testing.T) {
+	ft := &fakeStatusTracker{}
+	q := &fakeDNSQueue{}
+	// DNS changes would fail if Register made them.
+	s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+		&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{chgErr: errors.New("fake change error")}, ft,
+		&fakeSecretManager{key: "fake key data"})
+	s.DNSQueue = q
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g", nil)
+	req = withKeyOrg(req)
+
+	s.Register(rw, req)
+
+	resp := v0.RegisterResponse{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+	if rw.Code != http.StatusOK || resp.Registration == nil {
+		t.Fatalf("Register() = %d %+v, want registration", rw.Code, resp.Error)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0].Type != v0.WarnDNSPending {
+		t.Errorf("Register() Warnings = %v, want %s", resp.Warnings, v0.WarnDNSPending)
+	}
+	if ft.updated == nil || !ft.updated.PendingDNS {
+		t.Errorf("Register() did not save pending DNS record; got %+v", ft.updated)
+	}
+	if !reflect.DeepEqual(q.hostnames, []string{resp.Registration.Hostname}) {
+		t.Errorf("Register() queued %v, want %s", q.hostnames, resp.Registration.Hostname)
+	}
+}
+
+func TestServer_RegisterVerifySourceIP(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true"
+	tests := []struct {
+		name      string
+		orgs      *fakeOrgSettings
+		forwarded string
+		remote    string
+		// hops of -1 means zero proxy hops.
+		hops     int
+		wantCode int
+	}{
+		{
+			name:      "success-not-required",
+			orgs:      &fakeOrgSettings{},
+			forwarded: "10.0.0.1",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "success-match",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			forwarded: "192.168.0.1, 169.254.1.1",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "success-match-proxy-appended",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			forwarded: "10.0.0.1, 192.168.0.1, 169.254.1.1",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "success-match-no-hops",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			forwarded: "10.0.0.1, 192.168.0.1",
+			hops:      -1,
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:     "success-match-remote-addr",
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			remote:   "192.168.0.1:4321",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "error-mismatch",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			forwarded: "10.0.0.1, 169.254.1.1",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "error-spoofed-header",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			forwarded: "192.168.0.1, 10.0.0.1, 169.254.1.1",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "error-missing-proxy",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			forwarded: "192.168.0.1",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:     "error-mismatch-remote-addr",
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{VerifySourceIP: true}},
+			remote:   "10.0.0.1:4321",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:      "error-settings",
+			orgs:      &fakeOrgSettings{getErr: errors.New("fake get error")},
+			forwarded: "192.168.0.1, 169.254.1.1",
+			wantCode:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			s.Orgs = tt.orgs
+			if tt.hops < 0 {
+				s.ProxyHops = 0
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.remote != "" {
+				req.RemoteAddr = tt.remote
+			}
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterAllowlist(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true"
+	tests := []struct {
+		name     string
+		settings orgs.Settings
+		services string
+		wantCode int
+	}{
+		{
+			name:     "success-no-restrictions",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "success-allowed",
+			settings: orgs.Settings{AllowedASNs: []int64{12345}, AllowedPrefixes: []string{"192.168.0.0/24"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-suspended",
+			settings: orgs.Settings{Status: orgs.StatusSuspended},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-asn",
+			settings: orgs.Settings{AllowedASNs: []int64{1}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-prefix",
+			settings: orgs.Settings{AllowedPrefixes: []string{"10.0.0.0/8"}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "success-service",
+			settings: orgs.Settings{AllowedServices: []string{"ndt", "wehe"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-service",
+			settings: orgs.Settings{AllowedServices: []string{"wehe"}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "success-alias-service",
+			settings: orgs.Settings{AllowedServices: []string{"ndt", "wehe"}},
+			services: "&services=wehe",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-alias-service",
+			settings: orgs.Settings{AllowedServices: []string{"ndt"}},
+			services: "&services=wehe",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "success-metro-distance",
+			settings: orgs.Settings{MaxMetroDistance: 100},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-metro-distance",
+			settings: orgs.Settings{MaxMetroDistance: 1},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	// The node is about 9 km from the airport.
+	city := &geoip2.City{}
+	city.Location.Latitude, city.Location.Longitude = 40.7, -73.9
+	row := iata.Row{IATA: "lga", Latitude: 40.775, Longitude: -73.875}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: row}, &fakeMaxmind{city: city},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			s.Orgs = &fakeOrgSettings{settings: tt.settings}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params+tt.services, nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterAllowlistOrg(t *testing.T) {
+	settings := map[string]orgs.Settings{
+		"mlab":  {AllowedASNs: []int64{1}},
+		"other": {},
+	}
+	tests := []struct {
+		name     string
+		keyOrg   string
+		params   string
+		wantCode int
+	}{
+		{
+			name:     "success-key-org-unrestricted",
+			keyOrg:   "other",
+			params:   "?service=ndt&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-key-org-restricted",
+			keyOrg:   "mlab",
+			params:   "?service=ndt&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-mismatched-org",
+			keyOrg:   "mlab",
+			params:   "?service=ndt&organization=other&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			s.Orgs = &fakeOrgSettings{byOrg: settings}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+tt.params, nil)
+			req = req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{Org: tt.keyOrg}))
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterHosting(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true"
+	proxy := &geoip2.City{}
+	proxy.Traits.IsAnonymousProxy = true
+	tests := []struct {
+		name       string
+		settings   orgs.Settings
+		maxmind    *fakeMaxmind
+		wantCode   int
+		wantTraits *v0.IPTraits
+	}{
+		{
+			name:     "success-not-flagged",
+			settings: orgs.Settings{RejectHosting: true},
+			maxmind:  &fakeMaxmind{city: &geoip2.City{}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:       "success-labeled",
+			maxmind:    &fakeMaxmind{city: &geoip2.City{}, anon: geoip2.AnonymousIP{IsHostingProvider: true}},
+			wantCode:   http.StatusOK,
+			wantTraits: &v0.IPTraits{Hosting: true},
+		},
+		{
+			name:     "success-lookup-error",
+			settings: orgs.Settings{RejectHosting: true},
+			maxmind:  &fakeMaxmind{city: &geoip2.City{}, anonErr: errors.New("fake lookup error")},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-hosting",
+			settings: orgs.Settings{RejectHosting: true},
+			maxmind:  &fakeMaxmind{city: &geoip2.City{}, anon: geoip2.AnonymousIP{IsHostingProvider: true}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-vpn",
+			settings: orgs.Settings{RejectHosting: true},
+			maxmind:  &fakeMaxmind{city: &geoip2.City{}, anon: geoip2.AnonymousIP{IsAnonymousVPN: true}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-city-proxy",
+			settings: orgs.Settings{RejectHosting: true},
+			maxmind:  &fakeMaxmind{city: proxy},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, tt.maxmind,
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			s.Orgs = &fakeOrgSettings{settings: tt.settings}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			resp := v0.RegisterResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Register() returned invalid json: %v", err)
+			}
+			if got := resp.Registration.Annotation.Traits; !reflect.DeepEqual(got, tt.wantTraits) {
+				t.Errorf("Register() Traits = %v, want %v", got, tt.wantTraits)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterGeonames(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true"
+	named := &geoip2.City{}
+	named.City.Names = map[string]string{"en": "Queens"}
+	named.Subdivisions = append(named.Subdivisions, struct {
+		GeoNameID uint              `maxminddb:"geoname_id"`
+		IsoCode   string            `maxminddb:"iso_code"`
+		Names     map[string]string `maxminddb:"names"`
+	}{IsoCode: "NY", Names: map[string]string{"en": "New York"}})
+	place := geonames.Place{City: "New York City", CountryCode: "US", Admin1: "New York"}
+	tests := []struct {
+		name       string
+		city       *geoip2.City
+		geonames   GeonamesFinder
+		wantCity   string
+		wantRegion string
+	}{
+		{
+			name:       "success-place",
+			city:       &geoip2.City{},
+			geonames:   &fakeGeonames{place: place},
+			wantCity:   "New York City",
+			wantRegion: "New York",
+		},
+		{
+			name:       "success-maxmind-names",
+			city:       named,
+			geonames:   &fakeGeonames{place: geonames.Place{City: "Elsewhere", Admin1: "Elsewhere"}},
+			wantCity:   "Queens",
+			wantRegion: "New York",
+		},
+		{
+			name:     "success-not-found",
+			city:     &geoip2.City{},
+			geonames: &fakeGeonames{err: geonames.ErrNotFound},
+		},
+		{
+			name: "success-disabled",
+			city: &geoip2.City{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga", CountryCode: "US"}}, &fakeMaxmind{city: tt.city},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			s.Geonames = tt.geonames
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != http.StatusOK {
+				t.Fatalf("Register() returned wrong code; got %d, want %d", rw.Code, http.StatusOK)
+			}
+			resp := v0.RegisterResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Register() returned invalid json: %v", err)
+			}
+			geo := resp.Registration.Annotation.Annotation.Geo
+			if geo.City != tt.wantCity || geo.Subdivision1Name != tt.wantRegion {
+				t.Errorf("Register() city = %q, %q; want %q, %q", geo.City, geo.Subdivision1Name, tt.wantCity, tt.wantRegion)
+			}
+			if resp.Registration.Heartbeat.City != tt.wantCity {
+				t.Errorf("Register() heartbeat city = %q, want %q", resp.Registration.Heartbeat.City, tt.wantCity)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterClientVersion(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true"
+	rc := &fakeRuntimeConfig{c: config.Config{
+		MinClientVersion:        "0.1.0",
+		ServiceVersions:         []config.ServiceVersion{{Service: "ndt", MinClientVersion: "0.3.0"}},
+		DeprecatedClientVersion: "0.4.0",
+		ClientSunset:            time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC),
+	}}
+	tests := []struct {
+		name         string
+		rc           RuntimeConfig
+		minClient    string
+		agent        string
+		wantCode     int
+		wantMin      string
+		wantWarnings []v0.Warning
+	}{
+		{
+			name:     "success-service-version",
+			rc:       rc,
+			agent:    "autojoin-client/0.3.0",
+			wantCode: http.StatusOK,
+			wantWarnings: []v0.Warning{{
+				Type:    v0.WarnClientDeprecated,
+				Message: "client version 0.3.0 is deprecated, upgrade to 0.4.0 or newer before 2030-01-02",
+				Sunset:  "2030-01-02",
+			}},
+		},
+		{
+			name:     "success-not-deprecated",
+			rc:       rc,
+			agent:    "autojoin-client/0.4.0",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "success-other-client",
+			rc:       rc,
+			agent:    "curl/8.0.0",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-service-version",
+			rc:       rc,
+			agent:    "autojoin-client/0.2.0",
+			wantCode: http.StatusForbidden,
+			wantMin:  "0.3.0",
+		},
+		{
+			name:      "error-flag-version",
+			minClient: "0.2.0",
+			agent:     "autojoin-client/0.1.0",
+			wantCode:  http.StatusForbidden,
+			wantMin:   "0.2.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			s.RuntimeConfig = tt.rc
+			s.MinClientVersion = tt.minClient
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+			req.Header.Set("User-Agent", tt.agent)
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.RegisterResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Register() returned invalid json: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Warnings, tt.wantWarnings) {
+				t.Errorf("Register() Warnings = %v, want %v", resp.Warnings, tt.wantWarnings)
+			}
+			if resp.MinClientVersion != tt.wantMin {
+				t.Errorf("Register() MinClientVersion = %q, want %q", resp.MinClientVersion, tt.wantMin)
+			}
+			if tt.wantMin != "" && (resp.Error == nil || resp.Error.Type != v0.ErrClientVersion) {
+				t.Errorf("Register() error = %v, want %s", resp.Error, v0.ErrClientVersion)
+			}
+		})
+	}
+}
+
+func TestServer_RegisterIPCollision(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g&dry_run=true"
+	tests := []struct {
+		name      string
+		hostnames []string
+		orgs      *fakeOrgSettings
+		wantCode  int
+	}{
+		{
+			name:     "success-no-collision",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:      "success-same-org",
+			hostnames: []string{"ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org"},
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "success-allow-shared-ip",
+			hostnames: []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org"},
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{AllowSharedIP: true}},
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "error-other-org",
+			hostnames: []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org"},
+			wantCode:  http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{hostnames: tt.hostnames}, nil)
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+type fakeNodeKeys struct {
+	hostname string
+	err      error
+}
+
+func (f *fakeNodeKeys) IssueKey(ctx context.Context, org, hostname string) (string, error) {
+	f.hostname = hostname
+	return "node key data", f.err
+}
+
+type fakeAccessTokens struct {
+	expiry time.Time
+	err    error
+}
+
+func (f *fakeAccessTokens) Generate(ctx context.Context, org string) (string, time.Time, error) {
+	if f.err != nil {
+		return "", time.Time{}, f.err
+	}
+	return "token-" + org, f.expiry, nil
+}
+
+func TestServer_RegisterCredentials(t *testing.T) {
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g"
+	tests := []struct {
+		name         string
+		orgs         *fakeOrgSettings
+		nodeKeys     *fakeNodeKeys
+		tokens       *fakeAccessTokens
+		wantCode     int
+		wantKey      string
+		wantToken    string
+		wantWIF      *v0.WorkloadIdentity
+		wantHostname string
+	}{
+		{
+			name:     "success-org-key",
+			nodeKeys: &fakeNodeKeys{},
+			wantCode: http.StatusOK,
+			wantKey:  "org key data",
+		},
+		{
+			name:         "success-node-key",
+			orgs:         &fakeOrgSettings{settings: orgs.Settings{NodeKeys: true}},
+			nodeKeys:     &fakeNodeKeys{},
+			wantCode:     http.StatusOK,
+			wantKey:      "node key data",
+			wantHostname: "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org",
+		},
+		{
+			name:      "success-access-token",
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{AccessTokens: true, NodeKeys: true}},
+			nodeKeys:  &fakeNodeKeys{},
+			tokens:    &fakeAccessTokens{expiry: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			wantCode:  http.StatusOK,
+			wantToken: "token-mlab",
+		},
+		{
+			name: "success-workload-identity",
+			orgs: &fakeOrgSettings{settings: orgs.Settings{
+				AccessTokens:                   true,
+				WorkloadIdentityProvider:       "projects/123/locations/global/workloadIdentityPools/autojoin-mlab/providers/oidc",
+				WorkloadIdentityServiceAccount: "autonode-mlab@mlab-sandbox.iam.gserviceaccount.com",
+			}},
+			nodeKeys: &fakeNodeKeys{},
+			tokens:   &fakeAccessTokens{},
+			wantCode: http.StatusOK,
+			wantWIF: &v0.WorkloadIdentity{
+				Audience:       "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/autojoin-mlab/providers/oidc",
+				Provider:       "projects/123/locations/global/workloadIdentityPools/autojoin-mlab/providers/oidc",
+				ServiceAccount: "autonode-mlab@mlab-sandbox.iam.gserviceaccount.com",
+			},
+		},
+		{
+			name:     "success-access-tokens-not-enabled",
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{AccessTokens: true}},
+			nodeKeys: &fakeNodeKeys{},
+			wantCode: http.StatusOK,
+			wantKey:  "org key data",
+		},
+		{
+			name:     "error-access-token",
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{AccessTokens: true}},
+			nodeKeys: &fakeNodeKeys{},
+			tokens:   &fakeAccessTokens{err: errors.New("fake generate error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:         "error-node-key",
+			orgs:         &fakeOrgSettings{settings: orgs.Settings{NodeKeys: true}},
+			nodeKeys:     &fakeNodeKeys{err: errors.New("fake issue error")},
+			wantCode:     http.StatusInternalServerError,
+			wantHostname: "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{}, &fakeStatusTracker{}, &fakeSecretManager{key: "org key data"})
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
+			}
+			s.NodeKeys = tt.nodeKeys
+			if tt.tokens != nil {
+				s.AccessTokens = tt.tokens
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+params, nil)
+			req = withKeyOrg(req)
+
+			s.Register(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Register() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if tt.nodeKeys.hostname != tt.wantHostname {
+				t.Errorf("Register() issued key for wrong hostname; got %q, want %q", tt.nodeKeys.hostname, tt.wantHostname)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			resp := &v0.RegisterResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), resp), "failed to parse response")
+			creds := resp.Registration.Credentials
+			if creds.ServiceAccountKey != tt.wantKey || creds.AccessToken != tt.wantToken {
+				t.Errorf("Register() returned wrong credentials; got %+v, want key %q, token %q", creds, tt.wantKey, tt.wantToken)
+			}
+			if !reflect.DeepEqual(creds.WorkloadIdentity, tt.wantWIF) {
+				t.Errorf("Register() returned wrong workload identity; got %+v, want %+v", creds.WorkloadIdentity, tt.wantWIF)
+			}
+			if tt.wantToken != "" && (creds.AccessTokenExpiry == nil || !creds.AccessTokenExpiry.Equal(tt.tokens.expiry)) {
+				t.Errorf("Register() returned wrong token expiry; got %v, want %v", creds.AccessTokenExpiry, tt.tokens.expiry)
+			}
+		})
+	}
+}
+
+func TestServer_Delete(t *testing.T) {
+	tests := []struct {
+		name     string
+		DNS      dnsiface.Service
+		Tracker  DNSTracker
+		qs       string
+		noKey    bool
+		wantName string
+		wantCode int
+	}{
+		{
+			name:     "success",
+			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
+			wantCode: http.StatusOK,
+			DNS:      &fakeDNS{},
+			Tracker:  &fakeStatusTracker{},
+		},
+		{
+			name:     "error-hostname-empty",
+			qs:       "?hostname=",
+			wantCode: http.StatusBadRequest,
+			Tracker:  &fakeStatusTracker{},
+		},
+		{
+			name:     "error-hostname-invalid",
+			qs:       "?hostname=this-is-not-valid.foo",
+			wantCode: http.StatusBadRequest,
+			Tracker:  &fakeStatusTracker{},
+		},
+		{
+			name:     "error-request-failed",
+			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
+			wantCode: http.StatusInternalServerError,
+			DNS:      &fakeDNS{getErr: errors.New("fake error")},
+			Tracker:  &fakeStatusTracker{},
+		},
+		{
+			name: "error-tracker-failed",
+
+			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
+			wantCode: http.StatusInternalServerError,
+			DNS:      &fakeDNS{},
+			Tracker:  &fakeStatusTracker{deleteErr: errors.New("delete failed")},
+		},
+		{
+			name:     "error-missing-key",
+			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
+			noKey:    true,
+			wantCode: http.StatusUnauthorized,
+			DNS:      &fakeDNS{},
+			Tracker:  &fakeStatusTracker{},
+		},
+		{
+			name:     "error-other-org",
+			qs:       "?hostname=ndt-lga3269-4f20bd89.other.sandbox.measurement-lab.org",
+			wantCode: http.StatusForbidden,
+			DNS:      &fakeDNS{},
+			Tracker:  &fakeStatusTracker{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, tt.DNS, tt.Tracker, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete"+tt.qs, nil)
+			if !tt.noKey {
+				req = withKey(req, "mlab")
+			}
+			s.Delete(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Delete() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+type fakeOperationStore struct {
+	op        *v0.Operation
+	createErr error
+	getErr    error
+	finishErr error
+	finished  error
+	// finishCtxErr is the error of the context given to Finish.
+	finishCtxErr error
+}
+
+func (f *fakeOperationStore) Create(ctx context.Context, opType, hostname, org string) (*v0.Operation, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	f.op = &v0.Operation{ID: "1234", Type: opType, Hostname: hostname, Org: org, State: v0.OperationPending}
+	return f.op, nil
+}
+
+func (f *fakeOperationStore) Get(ctx context.Context, id string) (*v0.Operation, error) {
+	if f.op == nil || f.op.ID != id {
+		return nil, f.getErr
+	}
+	return f.op, f.getErr
+}
+
+func (f *fakeOperationStore) Finish(ctx context.Context, op *v0.Operation, err error) error {
+	f.finished = err
+	f.finishCtxErr = ctx.Err()
+	op.State = v0.OperationDone
+	if err != nil {
+		op.State = v0.OperationFailed
+	}
+	return f.finishErr
+}
+
+func TestServer_DeleteAsync(t *testing.T) {
+	defer func(b, d time.Duration) {
+		asyncDeleteBackoff, asyncDeleteTimeout = b, d
+	}(asyncDeleteBackoff, asyncDeleteTimeout)
+	tests := []struct {
+		name      string
+		DNS       dnsiface.Service
+		ops       *fakeOperationStore
+		backoff   time.Duration
+		timeout   time.Duration
+		wantCode  int
+		wantState string
+	}{
+		{
+			name:      "success",
+			DNS:       &fakeDNS{},
+			ops:       &fakeOperationStore{},
+			wantCode:  http.StatusAccepted,
+			wantState: v0.OperationDone,
+		},
+		{
+			name:      "success-delete-failed",
+			DNS:       &fakeDNS{getErr: errors.New("fake error")},
+			ops:       &fakeOperationStore{finishErr: errors.New("fake finish error")},
+			wantCode:  http.StatusAccepted,
+			wantState: v0.OperationFailed,
+		},
+		{
+			// The backoff ends with the timeout, and the result is still saved.
+			name:      "success-delete-timeout",
+			DNS:       &fakeDNS{getErr: errors.New("fake error")},
+			ops:       &fakeOperationStore{},
+			backoff:   time.Hour,
+			timeout:   10 * time.Millisecond,
+			wantCode:  http.StatusAccepted,
+			wantState: v0.OperationFailed,
+		},
+		{
+			name:     "error-not-enabled",
+			DNS:      &fakeDNS{},
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-create",
+			DNS:      &fakeDNS{},
+			ops:      &fakeOperationStore{createErr: errors.New("fake create error")},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asyncDeleteBackoff, asyncDeleteTimeout = time.Millisecond, time.Minute
+			if tt.backoff != 0 {
+				asyncDeleteBackoff, asyncDeleteTimeout = tt.backoff, tt.timeout
+			}
+			s := NewServer("mlab-sandbox", nil, nil, nil, tt.DNS, &fakeStatusTracker{}, nil)
+			if tt.ops != nil {
+				s.Operations = tt.ops
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete?async=true&hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org", nil)
+			req = withKey(req, "mlab")
+			s.Delete(rw, req)
+			s.ops.Wait()
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Delete() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.DeleteResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if rw.Code != http.StatusAccepted {
+				return
+			}
+			if resp.Operation == nil || resp.Operation.ID != "1234" || resp.Operation.State != v0.OperationPending {
+				t.Errorf("Delete() returned wrong operation; got %v", resp.Operation)
+			}
+			if tt.ops.op.State != tt.wantState {
+				t.Errorf("Delete() finished with wrong state; got %q, want %q", tt.ops.op.State, tt.wantState)
+			}
+			if tt.ops.finishCtxErr != nil {
+				t.Errorf("Delete() finished operation with done context: %v", tt.ops.finishCtxErr)
+			}
+		})
+	}
+}
+
+func TestServer_Operation(t *testing.T) {
+	op := &v0.Operation{ID: "1234", Org: "mlab", State: v0.OperationDone}
+	tests := []struct {
+		name     string
+		ops      *fakeOperationStore
+		qs       string
+		org      string
+		wantCode int
+	}{
+		{
+			name:     "success",
+			ops:      &fakeOperationStore{op: op},
+			qs:       "?id=1234",
+			org:      "mlab",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-not-enabled",
+			qs:       "?id=1234",
+			org:      "mlab",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-missing-id",
+			ops:      &fakeOperationStore{op: op},
+			org:      "mlab",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-missing-key",
+			ops:      &fakeOperationStore{op: op},
+			qs:       "?id=1234",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-not-found",
+			ops:      &fakeOperationStore{getErr: operation.ErrNotFound},
+			qs:       "?id=1234",
+			org:      "mlab",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-other-org",
+			ops:      &fakeOperationStore{op: op},
+			qs:       "?id=1234",
+			org:      "other",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-get",
+			ops:      &fakeOperationStore{getErr: errors.New("fake get error")},
+			qs:       "?id=1234",
+			org:      "mlab",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.ops != nil {
+				s.Operations = tt.ops
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/operation"+tt.qs, nil)
+			if tt.org != "" {
+				req = withKey(req, tt.org)
+			}
+			s.Operation(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Operation() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.OperationResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if rw.Code == http.StatusOK && (resp.Operation == nil || resp.Operation.ID != "1234") {
+				t.Errorf("Operation() returned wrong operation; got %v", resp.Operation)
+			}
+		})
+	}
+}
+
+func TestServer_Diff(t *testing.T) {
+	const hostname = "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org"
+	params := "?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g"
+	current := `{"` + hostname + `": {"Experiment": "ndt", "Hostname": "` + hostname + `",
+		"Machine": "c0a80001", "Metro": "lga", "Project": "mlab-sandbox", "Probability": 1,
+		"Site": "lga12345", "Type": "physical", "Uplink": "10g"}}`
+	tests := []struct {
+		name        string
+		params      string
+		body        string
+		Iata        IataFinder
+		wantCode    int
+		wantChanges int
+	}{
+		{
+			name:     "success-current",
+			params:   params,
+			body:     current,
+			Iata:     &fakeIataFinder{findRow: iata.Row{IATA: "lga"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:        "success-drift",
+			params:      params,
+			body:        current,
+			Iata:        &fakeIataFinder{findRow: iata.Row{IATA: "lga", Latitude: 40.7, Longitude: -73.9}},
+			wantCode:    http.StatusOK,
+			wantChanges: 2,
+		},
+		{
+			name:     "error-body",
+			params:   params,
+			body:     "{}",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-params",
+			params:   "?service=ndt",
+			body:     current,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", tt.Iata, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, nil, &fakeStatusTracker{}, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/diff"+tt.params, strings.NewReader(tt.body))
+			req = withKeyOrg(req)
+
+			s.Diff(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Diff() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.DiffResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if rw.Code != http.StatusOK {
+				return
+			}
+			if resp.Hostname != hostname {
+				t.Errorf("Diff() returned wrong hostname; got %q, want %q", resp.Hostname, hostname)
+			}
+			if len(resp.Changes) != tt.wantChanges {
+				t.Errorf("Diff() returned wrong changes; got %v, want %d", resp.Changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+type fakeReporter struct {
+	hostnames []string
+	reasons   []string
+	err       error
+}
+
+func (f *fakeReporter) Report(ctx context.Context, hostname string, s tracker.Status, reason string) error {
+	f.hostnames = append(f.hostnames, hostname)
+	f.reasons = append(f.reasons, reason)
+	return f.err
+}
+
+func TestServer_DeleteReport(t *testing.T) {
+	const hostname = "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
+	tests := []struct {
+		name     string
+		Tracker  *fakeStatusTracker
+		reporter *fakeReporter
+		want     int
+	}{
+		{
+			name:     "success",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			reporter: &fakeReporter{},
+			want:     1,
+		},
+		{
+			name:     "success-report-error",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			reporter: &fakeReporter{err: errors.New("fake report error")},
+			want:     1,
+		},
+		{
+			name:     "success-not-tracked",
+			Tracker:  &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			reporter: &fakeReporter{},
+		},
+		{
+			name:     "success-get-error",
+			Tracker:  &fakeStatusTracker{getErr: errors.New("fake get error")},
+			reporter: &fakeReporter{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, &fakeDNS{}, tt.Tracker, nil)
+			s.Decommission = tt.reporter
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete?hostname="+hostname, nil)
+			req = withKey(req, "mlab")
+			s.Delete(rw, req)
+
+			// Reporting never causes Delete to fail.
+			if rw.Code != http.StatusOK {
+				t.Errorf("Delete() returned wrong code; got %d, want %d", rw.Code, http.StatusOK)
+			}
+			if len(tt.reporter.hostnames) != tt.want {
+				t.Errorf("Delete() reported %d hostnames, want %d", len(tt.reporter.hostnames), tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Get(t *testing.T) {
+	const hostname = "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
+	tests := []struct {
+		name     string
+		Tracker  *fakeStatusTracker
+		DNS      dnsiface.Service
+		qs       string
+		wantCode int
+		wantDNS  string
+	}{
+		{
+			name:     "success-applied",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{Registration: &v0.Registration{Hostname: hostname}}},
+			wantCode: http.StatusOK,
+			wantDNS:  v0.DNSApplied,
+		},
+		{
+			name:     "success-pending",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{PendingDNS: true}},
+			wantCode: http.StatusOK,
+			wantDNS:  v0.DNSPending,
+		},
+		{
+			name:     "success-change-done",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{DNSChange: "1"}},
+			DNS:      &changeDNS{status: "done"},
+			wantCode: http.StatusOK,
+			wantDNS:  v0.DNSApplied,
+		},
+		{
+			name:     "success-propagating",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{DNSChange: "1"}},
+			DNS:      &changeDNS{status: "pending"},
+			wantCode: http.StatusOK,
+			wantDNS:  v0.DNSPropagating,
+		},
+		{
+			name:     "error-hostname-invalid",
+			qs:       "?hostname=this-is-not-valid.foo&organization=mlab",
+			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-wrong-organization",
+			qs:       "?hostname=" + hostname + "&organization=other",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-missing-key",
+			qs:       "?hostname=" + hostname,
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-not-found",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-get",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{getErr: errors.New("fake get error")},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, tt.DNS, tt.Tracker, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/get"+tt.qs, nil)
+			req = withKeyOrg(req)
+
+			s.Get(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Get() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.GetResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if resp.DNS != tt.wantDNS {
+				t.Errorf("Get() returned wrong DNS status; got %q, want %q", resp.DNS, tt.wantDNS)
+			}
+		})
+	}
+}
+
+func TestServer_Update(t *testing.T) {
+	const hostname = "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
+	registered := func() *tracker.DNSRecord {
+		return &tracker.DNSRecord{
+			Ports: []string{"9990"},
+			Registration: &v0.Registration{
+				Hostname:  hostname,
+				Heartbeat: &v2.Registration{Hostname: hostname, Probability: 1.0},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		Tracker   *fakeStatusTracker
+		orgs      *fakeOrgSettings
+		qs        string
+		wantCode  int
+		wantPorts []string
+		wantProb  float64
+	}{
+		{
+			name:      "success-ports",
+			qs:        "?hostname=" + hostname + "&organization=mlab&ports=9991&ports=9992",
+			Tracker:   &fakeStatusTracker{record: registered()},
+			wantCode:  http.StatusOK,
+			wantPorts: []string{"9991", "9992"},
+			wantProb:  1.0,
+		},
+		{
+			name:      "success-probability",
+			qs:        "?hostname=" + hostname + "&organization=mlab&probability=0.5",
+			Tracker:   &fakeStatusTracker{record: registered()},
+			wantCode:  http.StatusOK,
+			wantPorts: []string{"9990"},
+			wantProb:  0.5,
+		},
+		{
+			name:      "success-probability-multiplier",
+			qs:        "?hostname=" + hostname + "&organization=mlab&probability=1",
+			Tracker:   &fakeStatusTracker{record: registered()},
+			orgs:      &fakeOrgSettings{settings: orgs.Settings{ProbabilityMultiplier: 0.1}},
+			wantCode:  http.StatusOK,
+			wantPorts: []string{"9990"},
+			wantProb:  0.1,
+		},
+		{
+			name:      "success-without-saved-registration",
+			qs:        "?hostname=" + hostname + "&organization=mlab&ports=9991",
+			Tracker:   &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			wantCode:  http.StatusOK,
+			wantPorts: []string{"9991"},
+		},
+		{
+			name:      "success-probability-override",
+			qs:        "?hostname=" + hostname + "&organization=mlab&probability=0.5",
+			Tracker:   &fakeStatusTracker{record: registered(), override: &tracker.Override{Enabled: true, Probability: 0.1}},
+			wantCode:  http.StatusOK,
+			wantPorts: []string{"9990"},
+			wantProb:  0.1,
+		},
+		{
+			name:     "error-hostname-invalid",
+			qs:       "?hostname=this-is-not-valid.foo&organization=mlab&ports=9991",
+			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-wrong-organization",
+			qs:       "?hostname=" + hostname + "&organization=other&ports=9991",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-missing-key",
+			qs:       "?hostname=" + hostname + "&ports=9991",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-nothing-to-update",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-invalid-probability",
+			qs:       "?hostname=" + hostname + "&organization=mlab&probability=2",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-nan-probability",
+			qs:       "?hostname=" + hostname + "&organization=mlab&probability=NaN",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-invalid-ports",
+			qs:       "?hostname=" + hostname + "&organization=mlab&ports=9991&ports=http",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-settings",
+			qs:       "?hostname=" + hostname + "&organization=mlab&probability=0.5",
+			Tracker:  &fakeStatusTracker{record: registered()},
+			orgs:     &fakeOrgSettings{getErr: errors.New("fake get error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-probability-without-heartbeat",
+			qs:       "?hostname=" + hostname + "&organization=mlab&probability=0.5",
+			Tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "error-not-found",
+			qs:       "?hostname=" + hostname + "&organization=mlab&ports=9991",
+			Tracker:  &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-get",
+			qs:       "?hostname=" + hostname + "&organization=mlab&ports=9991",
+			Tracker:  &fakeStatusTracker{getErr: errors.New("fake get error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-update",
+			qs:       "?hostname=" + hostname + "&organization=mlab&ports=9991",
+			Tracker:  &fakeStatusTracker{record: registered(), updateErr: errors.New("fake update error")},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, tt.Tracker, nil)
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
 			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/update"+tt.qs, nil)
+			req = withKeyOrg(req)
 
-			// Check response content is valid.
-			resp := v0.RegisterResponse{}
-			raw := rw.Body.Bytes()
-			err := json.Unmarshal(raw, &resp)
-			testingx.Must(t, err, "failed to unmarshal response")
+			s.Update(rw, req)
 
-			// One or the other should be defined.
-			if resp.Error == nil && resp.Registration == nil {
-				t.Errorf("Register() returned empty result; got %q", raw)
+			if rw.Code != tt.wantCode {
+				t.Errorf("Update() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
 			}
-			// Do not value check error cases.
+			resp := v0.UpdateResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
 			if rw.Code != http.StatusOK {
+				if rw.Code < http.StatusInternalServerError && tt.Tracker.updated != nil {
+					t.Errorf("Update() saved record after error")
+				}
 				return
 			}
-
-			if resp.Registration.Hostname != tt.wantName {
-				t.Errorf("Register() returned wrong hostname; got %s, want %s", resp.Registration.Hostname, tt.wantName)
+			if resp.Registration == nil || resp.Registration.Hostname != hostname {
+				t.Fatalf("Update() returned wrong registration; got %v", resp.Registration)
 			}
-
-			if _, err := host.Parse(resp.Registration.Hostname); err != nil {
-				t.Errorf("Register() returned unparsable hostname; got %v, want nil", err)
+			if !reflect.DeepEqual(tt.Tracker.updated.Ports, tt.wantPorts) {
+				t.Errorf("Update() saved wrong ports; got %v, want %v", tt.Tracker.updated.Ports, tt.wantPorts)
+			}
+			if hb := resp.Registration.Heartbeat; hb != nil && hb.Probability != tt.wantProb {
+				t.Errorf("Update() returned wrong probability; got %f, want %f", hb.Probability, tt.wantProb)
 			}
-
 		})
 	}
 }
 
-func TestServer_Delete(t *testing.T) {
+func TestServer_Maintenance(t *testing.T) {
+	const hostname = "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
 	tests := []struct {
-		name     string
-		DNS      dnsiface.Service
-		Tracker  DNSTracker
-		qs       string
-		wantName string
-		wantCode int
+		name      string
+		Tracker   *fakeStatusTracker
+		qs        string
+		wantCode  int
+		wantStart int64
+		wantEnd   int64
 	}{
 		{
-			name:     "success",
-			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
-			wantCode: http.StatusOK,
-			DNS:      &fakeDNS{},
+			name:      "success-end",
+			qs:        "?hostname=" + hostname + "&organization=mlab&start=2023-01-02T15:00:00Z&end=2023-01-02T17:00:00Z",
+			Tracker:   &fakeStatusTracker{},
+			wantCode:  http.StatusOK,
+			wantStart: 1672671600,
+			wantEnd:   1672678800,
+		},
+		{
+			name:      "success-duration",
+			qs:        "?hostname=" + hostname + "&organization=mlab&start=2023-01-02T15:00:00Z&duration=1h",
+			Tracker:   &fakeStatusTracker{},
+			wantCode:  http.StatusOK,
+			wantStart: 1672671600,
+			wantEnd:   1672675200,
+		},
+		{
+			name:     "error-hostname-invalid",
+			qs:       "?hostname=this-is-not-valid.foo&organization=mlab&duration=1h",
 			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusBadRequest,
 		},
 		{
-			name:     "error-hostname-empty",
-			qs:       "?hostname=",
+			name:     "error-wrong-organization",
+			qs:       "?hostname=" + hostname + "&organization=other&duration=1h",
+			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-missing-key",
+			qs:       "?hostname=" + hostname + "&duration=1h",
+			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-missing-end",
+			qs:       "?hostname=" + hostname + "&organization=mlab",
+			Tracker:  &fakeStatusTracker{},
 			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-end-and-duration",
+			qs:       "?hostname=" + hostname + "&organization=mlab&end=2023-01-02T17:00:00Z&duration=1h",
 			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusBadRequest,
 		},
 		{
-			name:     "error-hostname-invalid",
-			qs:       "?hostname=this-is-not-valid.foo",
+			name:     "error-invalid-start",
+			qs:       "?hostname=" + hostname + "&organization=mlab&start=yesterday&duration=1h",
+			Tracker:  &fakeStatusTracker{},
 			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-ends-before-start",
+			qs:       "?hostname=" + hostname + "&organization=mlab&duration=-1h",
 			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusBadRequest,
 		},
 		{
-			name:     "error-request-failed",
-			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
-			wantCode: http.StatusInternalServerError,
-			DNS:      &fakeDNS{getErr: errors.New("fake error")},
+			name:     "error-too-long",
+			qs:       "?hostname=" + hostname + "&organization=mlab&duration=1000h",
 			Tracker:  &fakeStatusTracker{},
+			wantCode: http.StatusBadRequest,
 		},
 		{
-			name: "error-tracker-failed",
+			name:     "error-not-found",
+			qs:       "?hostname=" + hostname + "&organization=mlab&duration=1h",
+			Tracker:  &fakeStatusTracker{maintenanceErr: errors.New("fake error"), getErr: tracker.ErrNotFound},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-set-maintenance",
+			qs:       "?hostname=" + hostname + "&organization=mlab&duration=1h",
+			Tracker:  &fakeStatusTracker{maintenanceErr: errors.New("fake error"), record: &tracker.DNSRecord{}},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, tt.Tracker, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/maintenance"+tt.qs, nil)
+			req = withKeyOrg(req)
 
-			qs:       "?hostname=ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org",
+			s.Maintenance(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Maintenance() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.MaintenanceResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if rw.Code != http.StatusOK {
+				return
+			}
+			want := &tracker.Window{Start: tt.wantStart, End: tt.wantEnd}
+			if !reflect.DeepEqual(tt.Tracker.window, want) {
+				t.Errorf("Maintenance() saved wrong window; got %v, want %v", tt.Tracker.window, want)
+			}
+			if resp.Hostname != hostname || resp.Maintenance == nil || resp.Maintenance.End.Unix() != tt.wantEnd {
+				t.Errorf("Maintenance() returned wrong response; got %v", resp)
+			}
+		})
+	}
+}
+
+func TestServer_DeleteSite(t *testing.T) {
+	nodes := []string{
+		"ndt-lga3356-040e9f4b.mlab.sandbox.measurement-lab.org",
+		"msak-lga3356-040e9f4b.mlab.sandbox.measurement-lab.org",
+		"ndt-lga1234-040e9f4b.mlab.sandbox.measurement-lab.org",
+		"ndt-lga3356-040e9f4b.other.sandbox.measurement-lab.org",
+	}
+	tests := []struct {
+		name        string
+		qs          string
+		DNS         *fakeDNS
+		Tracker     *fakeStatusTracker
+		wantCode    int
+		wantResults int
+		wantErrors  int
+	}{
+		{
+			name:        "success",
+			qs:          "?org=mlab&site=lga3356",
+			DNS:         &fakeDNS{},
+			Tracker:     &fakeStatusTracker{nodes: nodes, status: withPorts(nil, nil, nil, nil)},
+			wantCode:    http.StatusOK,
+			wantResults: 2,
+		},
+		{
+			name:        "success-with-dns-errors",
+			qs:          "?org=mlab&site=lga3356",
+			DNS:         &fakeDNS{getErr: errors.New("fake get error")},
+			Tracker:     &fakeStatusTracker{nodes: nodes, status: withPorts(nil, nil, nil, nil)},
+			wantCode:    http.StatusOK,
+			wantResults: 2,
+			wantErrors:  2,
+		},
+		{
+			name:        "success-with-tracker-errors",
+			qs:          "?org=mlab&site=lga1234",
+			DNS:         &fakeDNS{},
+			Tracker:     &fakeStatusTracker{nodes: nodes, status: withPorts(nil, nil, nil, nil), deleteErr: errors.New("fake delete error")},
+			wantCode:    http.StatusOK,
+			wantResults: 1,
+			wantErrors:  1,
+		},
+		{
+			name:     "error-no-hosts",
+			qs:       "?org=mlab&site=den1234",
+			Tracker:  &fakeStatusTracker{nodes: nodes, status: withPorts(nil, nil, nil, nil)},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-invalid-org",
+			qs:       "?org=-BAD-&site=lga3356",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-missing-key",
+			qs:       "?site=lga3356",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-invalid-site",
+			qs:       "?org=mlab&site=lga",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-list",
+			qs:       "?org=mlab&site=lga3356",
+			Tracker:  &fakeStatusTracker{listErr: errors.New("fake list error")},
 			wantCode: http.StatusInternalServerError,
-			DNS:      &fakeDNS{},
-			Tracker:  &fakeStatusTracker{deleteErr: errors.New("delete failed")},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := NewServer("mlab-sandbox", nil, nil, nil, tt.DNS, tt.Tracker, nil)
 			rw := httptest.NewRecorder()
-			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete"+tt.qs, nil)
-			s.Delete(rw, req)
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete-site"+tt.qs, nil)
+			req = withKeyOrg(req)
+			s.DeleteSite(rw, req)
 
 			if rw.Code != tt.wantCode {
-				t.Errorf("Delete() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+				t.Errorf("DeleteSite() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.DeleteSiteResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			errs := 0
+			for _, r := range resp.Results {
+				if r.Error != "" {
+					errs++
+				}
+			}
+			if len(resp.Results) != tt.wantResults || errs != tt.wantErrors {
+				t.Errorf("DeleteSite() returned %d results with %d errors, want %d with %d",
+					len(resp.Results), errs, tt.wantResults, tt.wantErrors)
 			}
 		})
 	}
@@ -554,19 +2978,20 @@ func TestServer_Delete(t *testing.T) {
 
 func TestServer_List(t *testing.T) {
 	tests := []struct {
-		name       string
-		params     string
-		lister     DNSTracker
-		wantCode   int
-		wantLength int
+		name        string
+		params      string
+		lister      DNSTracker
+		wantCode    int
+		wantLength  int
+		wantInvalid string
 	}{
 		{
 			name:   "success",
 			params: "",
 			lister: &fakeStatusTracker{
 				// Fake node name must parse correctly.
-				nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
-				ports: [][]string{{"9990", "9991"}},
+				nodes:  []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990", "9991"}),
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 1,
@@ -575,8 +3000,8 @@ func TestServer_List(t *testing.T) {
 			name:   "success-prometheus",
 			params: "?format=prometheus",
 			lister: &fakeStatusTracker{
-				nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
-				ports: [][]string{{"9990"}},
+				nodes:  []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}),
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 1,
@@ -585,8 +3010,8 @@ func TestServer_List(t *testing.T) {
 			name:   "success-prometheus",
 			params: "?format=prometheus",
 			lister: &fakeStatusTracker{
-				nodes: []string{"test1"},
-				ports: [][]string{{}},
+				nodes:  []string{"test1"},
+				status: withPorts([]string{}),
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 0,
@@ -595,8 +3020,8 @@ func TestServer_List(t *testing.T) {
 			name:   "success-servers",
 			params: "?format=servers",
 			lister: &fakeStatusTracker{
-				nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
-				ports: [][]string{{"9990"}},
+				nodes:  []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}),
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 1,
@@ -605,8 +3030,8 @@ func TestServer_List(t *testing.T) {
 			name:   "success-sites",
 			params: "?format=sites&org=foo",
 			lister: &fakeStatusTracker{
-				nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
-				ports: [][]string{{"9990"}},
+				nodes:  []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}),
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 0,
@@ -618,7 +3043,7 @@ func TestServer_List(t *testing.T) {
 				nodes: []string{
 					"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
 					"ndt-lga3356-abcdef12.mlab.autojoin.measurement-lab.org"},
-				ports: [][]string{{"9990"}, {"9990"}},
+				status: withPorts([]string{"9990"}, []string{"9990"}),
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 1,
@@ -627,12 +3052,130 @@ func TestServer_List(t *testing.T) {
 			name:   "success-script-exporter",
 			params: "?format=script-exporter&service=ndt7_client_byos",
 			lister: &fakeStatusTracker{
-				nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
-				ports: [][]string{{"9990"}},
+				nodes:  []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}),
+			},
+			wantCode:   http.StatusOK,
+			wantLength: 1,
+		},
+		{
+			name:   "success-filter-site-and-metro",
+			params: "?format=servers&site=lga3356&metro=LGA",
+			lister: &fakeStatusTracker{
+				nodes: []string{
+					"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+					"ndt-lga1234-abcdef12.mlab.autojoin.measurement-lab.org",
+					"ndt-den3356-abcdef12.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}, []string{"9990"}, []string{"9990"}),
+			},
+			wantCode:   http.StatusOK,
+			wantLength: 1,
+		},
+		{
+			name:   "success-filter-service",
+			params: "?format=prometheus&node_service=msak",
+			lister: &fakeStatusTracker{
+				nodes: []string{
+					"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+					"msak-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}, []string{"9990", "9991"}),
+			},
+			wantCode:   http.StatusOK,
+			wantLength: 2,
+		},
+		{
+			name:   "success-service-label-only",
+			params: "?format=prometheus&service=msak",
+			lister: &fakeStatusTracker{
+				nodes: []string{
+					"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+					"msak-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+				status: withPorts([]string{"9990"}, []string{"9990", "9991"}),
+			},
+			wantCode:   http.StatusOK,
+			wantLength: 3,
+		},
+		{
+			name:   "success-filter-type-and-country",
+			params: "?format=servers&type=physical&country=us",
+			lister: &fakeStatusTracker{
+				nodes: []string{
+					"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+					"ndt-lga3356-abcdef12.mlab.autojoin.measurement-lab.org",
+					"ndt-lga3356-12345678.mlab.autojoin.measurement-lab.org"},
+				status: []tracker.Status{
+					{DNS: &tracker.DNSRecord{Type: "physical", Country: "US"}},
+					{DNS: &tracker.DNSRecord{Type: "virtual", Country: "US"}},
+					{DNS: &tracker.DNSRecord{Type: "physical", Country: "DE"}},
+				},
+			},
+			wantCode:   http.StatusOK,
+			wantLength: 1,
+		},
+		{
+			name:   "success-filter-labels",
+			params: "?format=prometheus&label=rack:r1&label=provider:acme",
+			lister: &fakeStatusTracker{
+				nodes: []string{
+					"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+					"ndt-lga3356-abcdef12.mlab.autojoin.measurement-lab.org"},
+				status: []tracker.Status{
+					{DNS: &tracker.DNSRecord{Ports: []string{"9990"}, Labels: map[string]string{"rack": "r1", "provider": "acme"}}},
+					{DNS: &tracker.DNSRecord{Ports: []string{"9990"}, Labels: map[string]string{"rack": "r2", "provider": "acme"}}},
+				},
 			},
 			wantCode:   http.StatusOK,
 			wantLength: 1,
 		},
+		{
+			name:        "error-invalid-label",
+			params:      "?label=rack",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "label",
+		},
+		{
+			name:        "error-invalid-site",
+			params:      "?site=lga",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "site",
+		},
+		{
+			name:        "error-invalid-metro",
+			params:      "?metro=lg",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "metro",
+		},
+		{
+			name:        "error-invalid-service",
+			params:      "?node_service=-BAD-",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "node_service",
+		},
+		{
+			name:        "error-invalid-type",
+			params:      "?type=dell",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "type",
+		},
+		{
+			name:        "error-invalid-country",
+			params:      "?country=USA",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "country",
+		},
+		{
+			name:        "error-invalid-org",
+			params:      "?org=-BAD-",
+			lister:      &fakeStatusTracker{},
+			wantCode:    http.StatusBadRequest,
+			wantInvalid: "org",
+		},
 		{
 			name:   "error-internal",
 			params: "",
@@ -680,6 +3223,202 @@ func TestServer_List(t *testing.T) {
 			if length != tt.wantLength {
 				t.Errorf("List() returned wrong length; got %d, want %d", length, tt.wantLength)
 			}
+			if tt.wantInvalid != "" {
+				resp := v0.ListResponse{}
+				testingx.Must(t, json.Unmarshal(raw, &resp), "failed to unmarshal response")
+				if len(resp.Invalid) != 1 || resp.Invalid[0].Param != tt.wantInvalid {
+					t.Errorf("List() returned wrong invalid params; got %v, want %s", resp.Invalid, tt.wantInvalid)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ListLabels(t *testing.T) {
+	lister := &fakeStatusTracker{
+		nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+		status: []tracker.Status{
+			{
+				DNS: &tracker.DNSRecord{
+					Ports:      []string{"9990"},
+					Labels:     map[string]string{"rack": "r1", "org": "override", "maintenance": "false"},
+					PendingDNS: true,
+				},
+				Maintenance: &tracker.Window{End: time.Now().Add(time.Hour).Unix()},
+			},
+		},
+	}
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, lister, nil)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=prometheus", nil)
+	s.List(rw, req)
+
+	configs := []discovery.StaticConfig{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &configs), "failed to unmarshal response")
+	if len(configs) != 1 {
+		t.Fatalf("List() returned wrong length; got %d, want 1", len(configs))
+	}
+	if configs[0].Labels["rack"] != "r1" {
+		t.Errorf("List() missing node label; got %q, want %q", configs[0].Labels["rack"], "r1")
+	}
+	if configs[0].Labels["org"] != "mlab" {
+		t.Errorf("List() node label replaced reserved label; got %q, want %q", configs[0].Labels["org"], "mlab")
+	}
+	if configs[0].Labels["maintenance"] != "true" {
+		t.Errorf("List() did not mark node in maintenance; got %q, want %q", configs[0].Labels["maintenance"], "true")
+	}
+	if configs[0].Labels["dns_pending"] != "true" {
+		t.Errorf("List() did not mark node with pending DNS; got %q, want %q", configs[0].Labels["dns_pending"], "true")
+	}
+}
+
+func TestServer_ListSiteinfo(t *testing.T) {
+	ann := &v0.ServerAnnotation{
+		Annotation: annotator.ServerAnnotations{
+			Site:    "lga3356",
+			Machine: "040e9f4b",
+			Geo:     &annotator.Geolocation{City: "New York", CountryCode: "US"},
+			Network: &annotator.Network{ASNumber: 12345},
+		},
+		Network: v0.Network{IPv4: "192.168.0.1"},
+		Type:    "virtual",
+	}
+	lister := &fakeStatusTracker{
+		nodes: []string{
+			"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+			"ndt-lga3356-abcdef12.mlab.autojoin.measurement-lab.org",
+		},
+		status: []tracker.Status{
+			{DNS: &tracker.DNSRecord{Registration: &v0.Registration{Annotation: ann}}},
+			// Records without annotations are skipped.
+			{DNS: &tracker.DNSRecord{}},
+		},
+	}
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, lister, nil)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=siteinfo", nil)
+	s.List(rw, req)
+
+	got := map[string]v0.SiteinfoAnnotation{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &got), "failed to unmarshal response")
+	want := map[string]v0.SiteinfoAnnotation{
+		"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org": {ServerAnnotation: *ann, Org: "mlab"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() returned wrong siteinfo; got %v, want %v", got, want)
+	}
+}
+
+func Test_getLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name:   "success",
+			params: "?label=rack=r1&label=contact=ops%40example.com",
+			want:   map[string]string{"rack": "r1", "contact": "ops@example.com"},
+		},
+		{
+			name:   "success-empty",
+			params: "",
+			want:   map[string]string{},
+		},
+		{
+			name:    "error-format",
+			params:  "?label=rack",
+			wantErr: errLabelFormat,
+		},
+		{
+			name:    "error-key",
+			params:  "?label=Rack-1=r1",
+			wantErr: errLabelKey,
+		},
+		{
+			name:    "error-reserved",
+			params:  "?label=org=foo",
+			wantErr: errLabelReserved,
+		},
+		{
+			name:    "error-reserved-prefix",
+			params:  "?label=__address__=10.0.0.1:80",
+			wantErr: errLabelReserved,
+		},
+		{
+			name:    "error-value",
+			params:  "?label=rack=" + strings.Repeat("a", maxLabelValueBytes+1),
+			wantErr: errLabelValue,
+		},
+		{
+			name:    "error-count",
+			params:  "?" + strings.Repeat("label=a=b&", maxLabels+1),
+			wantErr: errLabelCount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+tt.params, nil)
+			req = withKeyOrg(req)
+			got, err := getLabels(req)
+			if err != tt.wantErr {
+				t.Errorf("getLabels() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getLabels() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
+
+func TestServer_ListTargetLabels(t *testing.T) {
+	lister := &fakeStatusTracker{
+		nodes: []string{"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org"},
+		status: []tracker.Status{
+			{DNS: &tracker.DNSRecord{
+				Ports:         []string{"9990"},
+				Type:          "cloud",
+				Country:       "US",
+				CloudProvider: "gcp",
+				Labels:        map[string]string{"site": "override"},
+			}},
+		},
+	}
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, lister, nil)
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=prometheus&labels=site,metro&labels=country,machine_type,uplink&labels=cloud_provider,cloud_region", nil)
+	s.List(rw, req)
+
+	configs := []discovery.StaticConfig{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &configs), "failed to unmarshal response")
+	if len(configs) != 1 {
+		t.Fatalf("List() returned wrong length; got %d, want 1", len(configs))
+	}
+	want := map[string]string{
+		"site":           "lga3356",
+		"metro":          "lga",
+		"country":        "US",
+		"machine_type":   "cloud",
+		"cloud_provider": "gcp",
+	}
+	for k, v := range want {
+		if configs[0].Labels[k] != v {
+			t.Errorf("List() returned wrong %q label; got %q, want %q", k, configs[0].Labels[k], v)
+		}
+	}
+	for _, k := range []string{"uplink", "cloud_region"} {
+		if _, ok := configs[0].Labels[k]; ok {
+			t.Errorf("List() included empty %s label", k)
+		}
+	}
+
+	// Unknown labels are rejected.
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=prometheus&labels=rack", nil)
+	s.List(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("List() returned wrong code; got %d, want %d", rw.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+)
+
+// readyTimeout limits the time of the dependency checks of Ready, within the
+// 4s timeout of App Engine readiness checks.
+const readyTimeout = 3 * time.Second
+
+// datasets are the datasets that must be loaded before the server is ready.
+var datasets = []string{"iata", "maxmind", "asn"}
+
+// Check reports an error if a dependency of the server is unavailable.
+type Check func(ctx context.Context) error
+
+// readiness is the response of Ready. Checks contains "ok" or the error of
+// every dependency check and dataset.
+type readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// datasetLoaded records a successful load of the named dataset, or logs the
+// error of a failed load.
+func (s *Server) datasetLoaded(name string, err error) {
+	if err != nil {
+		log.Printf("Failed to load %s dataset: %v", name, err)
+		return
+	}
+	now := time.Now()
+	s.datasetsMu.Lock()
+	s.datasets[name] = now
+	s.datasetsMu.Unlock()
+	metrics.DatasetLastLoad.WithLabelValues(name).Set(float64(now.Unix()))
+	metrics.DatasetAge.WithLabelValues(name).Set(0)
+	if c, ok := s.dataset(name).(DatasetCounter); ok {
+		metrics.DatasetEntries.WithLabelValues(name).Set(float64(c.Len()))
+	}
+}
+
+// datasetNames returns the names of the datasets used by the server.
+func (s *Server) datasetNames() []string {
+	names := append([]string{}, datasets...)
+	if s.Geonames != nil {
+		names = append(names, "geonames")
+	}
+	return names
+}
+
+// dataset returns the named dataset, or nil.
+func (s *Server) dataset(name string) interface{} {
+	switch name {
+	case "iata":
+		return s.Iata
+	case "maxmind":
+		return s.Maxmind
+	case "asn":
+		return s.ASN
+	case "geonames":
+		return s.Geonames
+	}
+	return nil
+}
+
+// MonitorDatasets exports the time since the last load of every dataset as a
+// metric every interval, so that alerts fire before stale datasets cause bad
+// registrations. It returns when the context is canceled.
+func (s *Server) MonitorDatasets(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.exportDatasetAges()
+		}
+	}
+}
+
+func (s *Server) exportDatasetAges() {
+	s.datasetsMu.Lock()
+	defer s.datasetsMu.Unlock()
+	for name, loaded := range s.datasets {
+		metrics.DatasetAge.WithLabelValues(name).Set(time.Since(loaded).Seconds())
+	}
+}
+
+// datasetStatus returns "ok", or why the named dataset is not ready.
+func (s *Server) datasetStatus(name string) string {
+	s.datasetsMu.Lock()
+	loaded, ok := s.datasets[name]
+	s.datasetsMu.Unlock()
+	switch {
+	case !ok:
+		return "not loaded"
+	case s.DatasetMaxAge > 0 && time.Since(loaded) > s.DatasetMaxAge:
+		return fmt.Sprintf("stale: last loaded %s", loaded.UTC().Format(time.RFC3339))
+	}
+	return "ok"
+}
+
+// Live reports whether the system is live. Live does not check dependencies,
+// so that instances are not restarted during their outages.
+func (s *Server) Live(rw http.ResponseWriter, req *http.Request) {
+	fmt.Fprintf(rw, "ok")
+}
+
+// Ready reports whether the server is ready: all datasets are loaded and
+// fresh, and every dependency check succeeds. Otherwise, Ready returns 503
+// with the status of every dependency.
+func (s *Server) Ready(rw http.ResponseWriter, req *http.Request) {
+	resp := readiness{Status: "ok", Checks: map[string]string{}}
+	for _, name := range datasets {
+		resp.Checks[name] = s.datasetStatus(name)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range s.Checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			status := "ok"
+			if err := check(ctx); err != nil {
+				status = err.Error()
+			}
+			mu.Lock()
+			resp.Checks[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, status := range resp.Checks {
+		if status != "ok" {
+			resp.Status = "unavailable"
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ok" {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	b, _ := json.Marshal(resp)
+	rw.Write(b)
+}
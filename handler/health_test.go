@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/go/testingx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServer_Live(t *testing.T) {
+	s := NewServer("mlab-sandbox", &fakeIataFinder{}, &fakeMaxmind{}, &fakeAsn{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+	rw := httptest.NewRecorder()
+	s.Live(rw, httptest.NewRequest(http.MethodGet, "/v0/live", nil))
+	if rw.Code != http.StatusOK || rw.Body.String() != "ok" {
+		t.Errorf("Live() = %d %q, want 200 ok", rw.Code, rw.Body.String())
+	}
+}
+
+func TestServer_Ready(t *testing.T) {
+	tests := []struct {
+		name       string
+		iata       *fakeIataFinder
+		asn        ASNFinder
+		reload     bool
+		loadedAt   time.Time
+		checks     map[string]Check
+		wantCode   int
+		wantChecks map[string]string
+	}{
+		{
+			name:   "success",
+			iata:   &fakeIataFinder{},
+			reload: true,
+			checks: map[string]Check{
+				"memorystore": func(ctx context.Context) error { return nil },
+			},
+			wantCode: http.StatusOK,
+			wantChecks: map[string]string{
+				"iata": "ok", "maxmind": "ok", "asn": "ok", "memorystore": "ok",
+			},
+		},
+		{
+			name:     "error-not-loaded",
+			iata:     &fakeIataFinder{},
+			wantCode: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{
+				"iata": "not loaded", "maxmind": "not loaded", "asn": "not loaded",
+			},
+		},
+		{
+			name:     "error-load-failed",
+			iata:     &fakeIataFinder{loadErr: errors.New("fake load error")},
+			reload:   true,
+			wantCode: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{
+				"iata": "not loaded", "maxmind": "ok", "asn": "ok",
+			},
+		},
+		{
+			name:     "error-asn-load-failed",
+			iata:     &fakeIataFinder{},
+			asn:      &fakeAsnLoader{err: errors.New("fake load error")},
+			reload:   true,
+			wantCode: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{
+				"iata": "ok", "maxmind": "ok", "asn": "not loaded",
+			},
+		},
+		{
+			name:     "error-stale",
+			iata:     &fakeIataFinder{},
+			loadedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			wantCode: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{
+				"iata":    "stale: last loaded 2024-01-02T03:04:05Z",
+				"maxmind": "stale: last loaded 2024-01-02T03:04:05Z",
+				"asn":     "stale: last loaded 2024-01-02T03:04:05Z",
+			},
+		},
+		{
+			name:   "error-check",
+			iata:   &fakeIataFinder{},
+			reload: true,
+			checks: map[string]Check{
+				"memorystore": func(ctx context.Context) error { return nil },
+				"datastore":   func(ctx context.Context) error { return errors.New("fake datastore error") },
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{
+				"iata": "ok", "maxmind": "ok", "asn": "ok", "memorystore": "ok",
+				"datastore": "fake datastore error",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var asn ASNFinder = &fakeAsn{}
+			if tt.asn != nil {
+				asn = tt.asn
+			}
+			s := NewServer("mlab-sandbox", tt.iata, &fakeMaxmind{}, asn, &fakeDNS{}, &fakeStatusTracker{}, nil)
+			s.Checks = tt.checks
+			s.DatasetMaxAge = 24 * time.Hour
+			if tt.reload {
+				s.Reload(context.Background())
+			}
+			if !tt.loadedAt.IsZero() {
+				for _, name := range datasets {
+					s.datasets[name] = tt.loadedAt
+				}
+			}
+			rw := httptest.NewRecorder()
+			s.Ready(rw, httptest.NewRequest(http.MethodGet, "/v0/ready", nil))
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Ready() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := readiness{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to parse response")
+			if len(resp.Checks) != len(tt.wantChecks) {
+				t.Errorf("Ready() returned wrong checks; got %v, want %v", resp.Checks, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				if resp.Checks[name] != want {
+					t.Errorf("Ready() returned wrong %s status; got %q, want %q", name, resp.Checks[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_MonitorDatasets(t *testing.T) {
+	s := NewServer("mlab-sandbox", &fakeIataFinder{}, &fakeMaxmind{}, &fakeAsn{}, &fakeDNS{}, &fakeStatusTracker{}, nil)
+	s.datasetLoaded("iata", nil)
+	if got := testutil.ToFloat64(metrics.DatasetLastLoad.WithLabelValues("iata")); got < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("datasetLoaded() exported wrong load time; got %v", got)
+	}
+	s.datasets["iata"] = time.Now().Add(-time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.MonitorDatasets(ctx, time.Millisecond)
+
+	if got := testutil.ToFloat64(metrics.DatasetAge.WithLabelValues("iata")); got < 3600 {
+		t.Errorf("MonitorDatasets() exported wrong age; got %v, want at least 3600", got)
+	}
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/idempotency"
+	"github.com/m-lab/autojoin/internal/keys"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+const maxIdempotencyKeyBytes = 255
+
+// IdempotencyStore is an interface used to save responses by idempotency key.
+type IdempotencyStore interface {
+	Start(key, fingerprint string) (*idempotency.Entry, error)
+	Finish(key string, e *idempotency.Entry) error
+	Cancel(key string) error
+}
+
+// WithIdempotency returns a handler that saves the response of requests with
+// an "Idempotency-Key" header and replays it for later requests with the same
+// key, so that clients may safely retry after a timeout. Requests without the
+// header are always handled. Server errors are not saved, so that they may be
+// retried. If store is nil, next is returned unchanged.
+//
+// Keys are scoped to the API key found by WithAPIKeyValidation, so callers
+// with different API keys never replay each other's responses.
+//
+// NOTE: requests are identified by method, path, and parameters. Request
+// bodies are not compared.
+func WithIdempotency(store IdempotencyStore, next http.HandlerFunc) http.HandlerFunc {
+	return WithRedactedIdempotency(store, nil, next)
+}
+
+// Redactor removes secrets from responses before they are saved, and adds
+// fresh secrets to the responses replayed for later requests.
+type Redactor interface {
+	Redact(body []byte) ([]byte, error)
+	Restore(req *http.Request, body []byte) ([]byte, error)
+}
+
+// WithRedactedIdempotency is WithIdempotency for handlers with secrets in their
+// responses, e.g. the credentials of Register. Responses are saved after
+// r.Redact and replayed after r.Restore. Responses that fail to redact are not
+// saved, and requests that fail to restore are handled again. If r is nil,
+// responses are saved unchanged.
+func WithRedactedIdempotency(store IdempotencyStore, r Redactor, next http.HandlerFunc) http.HandlerFunc {
+	if store == nil {
+		return next
+	}
+	return func(rw http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(rw, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyBytes {
+			writeIdempotencyError(rw, http.StatusBadRequest, v0.ErrIdempotencyKey, "idempotency key is too long")
+			return
+		}
+		key = scopedIdempotencyKey(req, key)
+		fingerprint := requestFingerprint(req)
+		e, err := store.Start(key, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			writeIdempotencyError(rw, http.StatusConflict, v0.ErrIdempotencyBusy, "request with idempotency key is in progress")
+			return
+		case err != nil:
+			// Prefer handling the request to failing it.
+			log.Println("idempotency start failure:", err)
+			next(rw, req)
+			return
+		case e != nil && e.Fingerprint != fingerprint:
+			writeIdempotencyError(rw, http.StatusUnprocessableEntity, v0.ErrIdempotencyReused, "idempotency key was used by a different request")
+			return
+		case e != nil:
+			body := e.Body
+			if r != nil {
+				if body, err = r.Restore(req, body); err != nil {
+					log.Println("idempotency restore failure:", err)
+					next(rw, req)
+					return
+				}
+			}
+			rw.Header().Set("Content-Type", e.ContentType)
+			rw.Header().Set("Idempotent-Replayed", "true")
+			rw.WriteHeader(e.Status)
+			rw.Write(body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: rw, status: http.StatusOK}
+		next(rec, req)
+		body := rec.body.Bytes()
+		if r != nil && rec.status < http.StatusInternalServerError {
+			if body, err = r.Redact(body); err != nil {
+				log.Println("idempotency redact failure:", err)
+			}
+		}
+		if rec.status >= http.StatusInternalServerError || err != nil {
+			err = store.Cancel(key)
+		} else {
+			err = store.Finish(key, &idempotency.Entry{
+				Fingerprint: fingerprint,
+				Status:      rec.status,
+				ContentType: rw.Header().Get("Content-Type"),
+				Body:        body,
+			})
+		}
+		if err != nil {
+			log.Println("idempotency save failure:", err)
+		}
+	}
+}
+
+// scopedIdempotencyKey returns the key prefixed by the ID of the API key of the
+// request, if any.
+func scopedIdempotencyKey(req *http.Request, key string) string {
+	info, ok := req.Context().Value(keyInfoKey{}).(*keys.Info)
+	if !ok {
+		return key
+	}
+	return info.ID + ":" + key
+}
+
+// requestFingerprint identifies the method, path, and parameters of a request.
+func requestFingerprint(req *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "?" + req.URL.Query().Encode()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeIdempotencyError(rw http.ResponseWriter, status int, errType, title string) {
+	rw.Header().Set("Content-Type", "application/json")
+	resp := struct {
+		Error *v2.Error
+	}{
+		Error: &v2.Error{
+			Type:   errType,
+			Title:  title,
+			Status: status,
+		},
+	}
+	rw.WriteHeader(resp.Error.Status)
+	writeResponse(rw, resp)
+}
+
+// recordingWriter copies the status and body of a response.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recordingWriter) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recordingWriter) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// RegisterRedactor returns a Redactor of Register responses. Credentials are
+// never saved, and replayed registrations are issued fresh credentials.
+func (s *Server) RegisterRedactor() Redactor {
+	return &registerRedactor{s: s}
+}
+
+type registerRedactor struct {
+	s *Server
+}
+
+// Redact replaces the credentials of a registration with an empty value, which
+// marks the registration for new credentials on Restore.
+func (r *registerRedactor) Redact(body []byte) ([]byte, error) {
+	resp := v0.RegisterResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Registration == nil || resp.Registration.Credentials == nil {
+		return body, nil
+	}
+	resp.Registration.Credentials = &v0.Credentials{}
+	return json.MarshalIndent(resp, "", "  ")
+}
+
+// Restore issues new credentials to registrations redacted by Redact.
+func (r *registerRedactor) Restore(req *http.Request, body []byte) ([]byte, error) {
+	resp := v0.RegisterResponse{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Registration == nil || resp.Registration.Credentials == nil {
+		return body, nil
+	}
+	ctx := req.Context()
+	org, _ := orgFromContext(ctx)
+	settings, err := r.s.getOrgSettings(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	resp.Registration.Credentials, err = r.s.getCredentials(ctx, org, resp.Registration.Hostname, settings)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(resp, "", "  ")
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/idempotency"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/uuid-annotator/annotator"
+	"github.com/oschwald/geoip2-golang"
+)
+
+type fakeIdempotencyStore struct {
+	entries  map[string]*idempotency.Entry
+	startErr error
+	canceled bool
+}
+
+func (f *fakeIdempotencyStore) Start(key, fingerprint string) (*idempotency.Entry, error) {
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+	if e, ok := f.entries[key]; ok {
+		return e, nil
+	}
+	f.entries[key] = &idempotency.Entry{Fingerprint: fingerprint}
+	return nil, nil
+}
+
+func (f *fakeIdempotencyStore) Finish(key string, e *idempotency.Entry) error {
+	e.Done = true
+	f.entries[key] = e
+	return nil
+}
+
+func (f *fakeIdempotencyStore) Cancel(key string) error {
+	f.canceled = true
+	delete(f.entries, key)
+	return nil
+}
+
+func TestWithIdempotency(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     *fakeIdempotencyStore
+		key       string
+		qs        string
+		code      int
+		wantCode  int
+		wantCalls int
+		wantBody  string
+	}{
+		{
+			name:      "success-no-key",
+			store:     &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{}},
+			code:      http.StatusOK,
+			wantCode:  http.StatusOK,
+			wantCalls: 2,
+			wantBody:  "2",
+		},
+		{
+			name:      "success-replay",
+			store:     &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{}},
+			key:       "abc",
+			code:      http.StatusOK,
+			wantCode:  http.StatusOK,
+			wantCalls: 1,
+			wantBody:  "1",
+		},
+		{
+			name:      "success-replay-client-error",
+			store:     &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{}},
+			key:       "abc",
+			code:      http.StatusBadRequest,
+			wantCode:  http.StatusBadRequest,
+			wantCalls: 1,
+			wantBody:  "1",
+		},
+		{
+			name:      "success-retry-server-error",
+			store:     &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{}},
+			key:       "abc",
+			code:      http.StatusInternalServerError,
+			wantCode:  http.StatusInternalServerError,
+			wantCalls: 2,
+			wantBody:  "2",
+		},
+		{
+			name:      "success-store-error",
+			store:     &fakeIdempotencyStore{startErr: errors.New("fake redis error")},
+			key:       "abc",
+			code:      http.StatusOK,
+			wantCode:  http.StatusOK,
+			wantCalls: 2,
+			wantBody:  "2",
+		},
+		{
+			name:     "error-in-progress",
+			store:    &fakeIdempotencyStore{startErr: idempotency.ErrInProgress},
+			key:      "abc",
+			wantCode: http.StatusConflict,
+		},
+		{
+			name: "error-different-request",
+			store: &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{
+				"abc": {Fingerprint: "other", Done: true},
+			}},
+			key:      "abc",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "error-key-too-long",
+			store:    &fakeIdempotencyStore{},
+			key:      strings.Repeat("a", maxIdempotencyKeyBytes+1),
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(tt.code)
+				rw.Write([]byte{byte('0' + calls)})
+			}
+			h := WithIdempotency(tt.store, next)
+			var rw *httptest.ResponseRecorder
+			// Send the same request twice, as a client retry would.
+			for i := 0; i < 2; i++ {
+				rw = httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete?hostname=foo", nil)
+				if tt.key != "" {
+					req.Header.Set("Idempotency-Key", tt.key)
+				}
+				h(rw, req)
+			}
+			if rw.Code != tt.wantCode {
+				t.Errorf("WithIdempotency() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("WithIdempotency() called handler wrong times; got %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantBody != "" && rw.Body.String() != tt.wantBody {
+				t.Errorf("WithIdempotency() returned wrong body; got %q, want %q", rw.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithIdempotency_nil(t *testing.T) {
+	calls := 0
+	h := WithIdempotency(nil, func(rw http.ResponseWriter, req *http.Request) { calls++ })
+	req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete", nil)
+	req.Header.Set("Idempotency-Key", "abc")
+	h(httptest.NewRecorder(), req)
+	if calls != 1 {
+		t.Errorf("WithIdempotency() called handler wrong times; got %d, want 1", calls)
+	}
+}
+
+func TestWithIdempotency_scoped(t *testing.T) {
+	store := &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{}}
+	calls := 0
+	h := WithIdempotency(store, func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.Write([]byte(`{}`))
+	})
+	send := func(id string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/delete?hostname=foo", nil)
+		req = req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{ID: id, Org: "mlab"}))
+		req.Header.Set("Idempotency-Key", "abc")
+		h(rw, req)
+		return rw
+	}
+
+	send("key-1")
+	// The same idempotency key of another API key is a different request.
+	if rw := send("key-2"); rw.Header().Get("Idempotent-Replayed") != "" || calls != 2 {
+		t.Errorf("WithIdempotency() replayed the response of another API key; calls = %d", calls)
+	}
+	if rw := send("key-1"); rw.Header().Get("Idempotent-Replayed") != "true" || calls != 2 {
+		t.Errorf("WithIdempotency() did not replay the response; calls = %d", calls)
+	}
+	if store.entries["key-1:abc"] == nil || store.entries["key-2:abc"] == nil {
+		t.Errorf("WithIdempotency() saved wrong keys; got %v", store.entries)
+	}
+}
+
+func TestWithRedactedIdempotency_Register(t *testing.T) {
+	sm := &fakeSecretManager{key: "fake key data"}
+	s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+		&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, &fakeDNS{}, &fakeStatusTracker{}, sm)
+	store := &fakeIdempotencyStore{entries: map[string]*idempotency.Entry{}}
+	h := WithRedactedIdempotency(store, s.RegisterRedactor(), s.Register)
+	send := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register?service=ndt&organization=mlab&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g", nil)
+		req = req.WithContext(context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{ID: "key-1", Org: "mlab", Scopes: keys.DefaultScopes}))
+		req.Header.Set("Idempotency-Key", "abc")
+		h(rw, req)
+		return rw
+	}
+
+	rw := send()
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "fake key data") {
+		t.Fatalf("Register() = %d %s, want credentials", rw.Code, rw.Body.String())
+	}
+	if e := store.entries["key-1:abc"]; e == nil || !e.Done || strings.Contains(string(e.Body), "fake key data") {
+		t.Fatalf("WithRedactedIdempotency() saved wrong entry; got %+v", e)
+	}
+
+	// Replays are issued the current credentials.
+	sm.key = "new key data"
+	rw = send()
+	resp := v0.RegisterResponse{}
+	testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+	if rw.Header().Get("Idempotent-Replayed") != "true" || resp.Registration == nil || resp.Registration.Credentials == nil {
+		t.Fatalf("Register() replay = %s, want registration", rw.Body.String())
+	}
+	if resp.Registration.Credentials.ServiceAccountKey != "new key data" {
+		t.Errorf("Register() replay ServiceAccountKey = %q, want new key data", resp.Registration.Credentials.ServiceAccountKey)
+	}
+
+	// Replays that cannot be issued credentials are handled again.
+	sm.err = errors.New("fake secret error")
+	rw = send()
+	if rw.Code != http.StatusInternalServerError || rw.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("Register() replay = %d, want %d", rw.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader is the header of request IDs assigned by clients or
+// WithRequestLogging.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request IDs accepted from clients.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestLogOutput receives the request log entries. Cloud Logging parses
+// JSON lines written to stdout as structured entries.
+var (
+	requestLogOutput io.Writer = os.Stdout
+	requestLogMu     sync.Mutex
+)
+
+type requestIDKey struct{}
+type requestLogKey struct{}
+
+// requestLog holds the fields of the request log entry that are set by inner
+// handlers, e.g. the org of a validated API key.
+type requestLog struct {
+	mu  sync.Mutex
+	org string
+}
+
+// setRequestOrg records the org of the request in its log entry.
+func setRequestOrg(ctx context.Context, org string) {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		rl.org = org
+		rl.mu.Unlock()
+	}
+}
+
+// requestEntry is a Cloud Logging structured log entry of a request.
+// See https://cloud.google.com/logging/docs/structured-logging.
+type requestEntry struct {
+	Severity    string      `json:"severity"`
+	Message     string      `json:"message"`
+	HTTPRequest httpRequest `json:"httpRequest"`
+	Trace       string      `json:"logging.googleapis.com/trace,omitempty"`
+	RequestID   string      `json:"request_id"`
+	Org         string      `json:"org,omitempty"`
+}
+
+// httpRequest is the HttpRequest of a Cloud Logging entry. The URL omits the
+// query, which may include API keys.
+type httpRequest struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	ResponseSize  int64  `json:"responseSize,string"`
+	Latency       string `json:"latency"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	UserAgent     string `json:"userAgent,omitempty"`
+}
+
+// WithRequestLogging returns a handler that assigns every request an ID and
+// logs the method, path, org, status and latency of the request as a
+// structured JSON entry. The ID is the X-Request-ID header of the request if
+// valid, or the trace ID of the App Engine request, or a random ID. It is
+// returned in the X-Request-ID header of the response, and used by the
+// problem details and recovered panics of the request.
+func WithRequestLogging(project string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = requestID(req)
+		}
+		rl := &requestLog{}
+		ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, requestLogKey{}, rl)
+		rw.Header().Set(requestIDHeader, id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request_id", id))
+		sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+		next(sw, req.WithContext(ctx))
+
+		e := requestEntry{
+			Severity: "INFO",
+			Message:  fmt.Sprintf("%s %s %d", req.Method, req.URL.Path, sw.status),
+			HTTPRequest: httpRequest{
+				RequestMethod: req.Method,
+				RequestURL:    req.URL.Path,
+				Status:        sw.status,
+				ResponseSize:  sw.size,
+				Latency:       fmt.Sprintf("%.3fs", time.Since(start).Seconds()),
+				RemoteIP:      getSourceIP(req, defaultProxyHops),
+				UserAgent:     req.UserAgent(),
+			},
+			RequestID: id,
+		}
+		switch {
+		case sw.status >= http.StatusInternalServerError:
+			e.Severity = "ERROR"
+		case sw.status >= http.StatusBadRequest:
+			e.Severity = "WARNING"
+		}
+		if trace, _, _ := strings.Cut(req.Header.Get("X-Cloud-Trace-Context"), "/"); trace != "" {
+			e.Trace = "projects/" + project + "/traces/" + trace
+		}
+		rl.mu.Lock()
+		e.Org = rl.org
+		rl.mu.Unlock()
+		if e.Org == "" {
+			e.Org = requestOrg(req)
+		}
+		b, _ := json.Marshal(e)
+		requestLogMu.Lock()
+		defer requestLogMu.Unlock()
+		requestLogOutput.Write(append(b, '\n'))
+	}
+}
+
+// requestOrg returns the org of the API key of the request, or else the org
+// named by its parameters, if any.
+func requestOrg(req *http.Request) string {
+	if org, ok := orgFromContext(req.Context()); ok {
+		return org
+	}
+	q := req.URL.Query()
+	if org := q.Get("organization"); org != "" {
+		return org
+	}
+	if org := q.Get("org"); org != "" {
+		return org
+	}
+	if name, err := host.Parse(q.Get("hostname")); err == nil {
+		return name.Org
+	}
+	return ""
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.size += int64(n)
+	return n, err
+}
+
+// Flush supports streaming responses.
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		s.wroteHeader = true
+		f.Flush()
+	}
+}
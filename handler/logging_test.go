@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestLogging(t *testing.T) {
+	tests := []struct {
+		name         string
+		params       string
+		header       map[string]string
+		next         http.HandlerFunc
+		wantID       string
+		wantOrg      string
+		wantStatus   int
+		wantSeverity string
+		wantTrace    string
+	}{
+		{
+			name:   "success-propagate-id",
+			params: "?organization=foo&key=secret",
+			header: map[string]string{"X-Request-ID": "client-id-1"},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Write([]byte("ok"))
+			},
+			wantID:       "client-id-1",
+			wantOrg:      "foo",
+			wantStatus:   http.StatusOK,
+			wantSeverity: "INFO",
+		},
+		{
+			name:   "success-trace-id",
+			params: "?hostname=ndt-lga3269-4f20bd89.bar.sandbox.measurement-lab.org",
+			header: map[string]string{
+				"X-Request-ID":          "not a valid id",
+				"X-Cloud-Trace-Context": "0123abcd/1;o=1",
+			},
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusNotFound)
+			},
+			wantID:       "0123abcd",
+			wantOrg:      "bar",
+			wantStatus:   http.StatusNotFound,
+			wantSeverity: "WARNING",
+			wantTrace:    "projects/mlab-sandbox/traces/0123abcd",
+		},
+		{
+			name: "success-org-from-handler",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				setRequestOrg(req.Context(), "baz")
+				rw.WriteHeader(http.StatusInternalServerError)
+			},
+			wantOrg:      "baz",
+			wantStatus:   http.StatusInternalServerError,
+			wantSeverity: "ERROR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			orig := requestLogOutput
+			requestLogOutput = buf
+			defer func() { requestLogOutput = orig }()
+
+			innerID := ""
+			next := func(rw http.ResponseWriter, req *http.Request) {
+				innerID = requestID(req)
+				tt.next(rw, req)
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register"+tt.params, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			WithRequestLogging("mlab-sandbox", next)(rw, req)
+
+			id := rw.Header().Get("X-Request-ID")
+			if id == "" || (tt.wantID != "" && id != tt.wantID) {
+				t.Errorf("WithRequestLogging() returned wrong request id; got %q, want %q", id, tt.wantID)
+			}
+			if innerID != id {
+				t.Errorf("requestID() = %q, want %q", innerID, id)
+			}
+			e := requestEntry{}
+			if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+				t.Fatalf("WithRequestLogging() logged invalid json %q: %v", buf.String(), err)
+			}
+			if e.RequestID != id || e.Org != tt.wantOrg || e.Severity != tt.wantSeverity || e.Trace != tt.wantTrace {
+				t.Errorf("WithRequestLogging() logged wrong entry; got %+v", e)
+			}
+			if e.HTTPRequest.Status != tt.wantStatus || e.HTTPRequest.RequestMethod != http.MethodPost ||
+				e.HTTPRequest.RequestURL != "/autojoin/v0/node/register" || !strings.HasSuffix(e.HTTPRequest.Latency, "s") {
+				t.Errorf("WithRequestLogging() logged wrong request; got %+v", e.HTTPRequest)
+			}
+			if strings.Contains(buf.String(), "secret") {
+				t.Errorf("WithRequestLogging() logged the api key: %s", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	// problemTypePrefix turns the error types of api/v0 into URIs.
+	problemTypePrefix = "urn:autojoin:error:"
+)
+
+// problem is an RFC 7807 problem details object. Invalid is an extension
+// member with the invalid parameters of register requests.
+type problem struct {
+	*v2.Error
+	Invalid []v0.InvalidParam `json:"invalid,omitempty"`
+}
+
+// WithProblemDetails returns a handler that rewrites the error responses of
+// next as RFC 7807 "application/problem+json" for requests that accept it.
+// Each problem has an instance ID that is also logged, so that reports from
+// clients may be found in the server logs. Other requests and successful
+// responses are unchanged.
+func WithProblemDetails(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if !acceptsProblem(req) {
+			next(rw, req)
+			return
+		}
+		pw := &problemWriter{ResponseWriter: rw}
+		next(pw, req)
+		if !pw.buffered {
+			return
+		}
+		resp := struct {
+			Error   *v2.Error
+			Invalid []v0.InvalidParam
+		}{}
+		err := json.Unmarshal(pw.body.Bytes(), &resp)
+		if err != nil || resp.Error == nil {
+			// Not an error response of this package, e.g. a plain text error.
+			rw.WriteHeader(pw.status)
+			rw.Write(pw.body.Bytes())
+			return
+		}
+		e := resp.Error
+		e.Type = problemTypePrefix + e.Type
+		e.Status = pw.status
+		e.Instance = req.URL.Path + "#" + requestID(req)
+		log.Printf("problem %s: %d %s %s", e.Instance, e.Status, e.Type, e.Title)
+		b, _ := json.MarshalIndent(problem{Error: e, Invalid: resp.Invalid}, "", "  ")
+		rw.Header().Set("Content-Type", problemContentType)
+		rw.Header().Del("Content-Length")
+		rw.WriteHeader(pw.status)
+		rw.Write(b)
+	}
+}
+
+// acceptsProblem returns true if the Accept header of the request includes
+// application/problem+json with a non-zero quality.
+func acceptsProblem(req *http.Request) bool {
+	for _, a := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err != nil || mt != problemContentType {
+			continue
+		}
+		if q, ok := params["q"]; ok && strings.Trim(q, "0.") == "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// requestID returns the ID assigned by WithRequestLogging, or the trace ID of
+// the App Engine request, which groups the request logs, or a random ID
+// otherwise.
+func requestID(req *http.Request) string {
+	if id, ok := req.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	trace, _, _ := strings.Cut(req.Header.Get("X-Cloud-Trace-Context"), "/")
+	if trace != "" {
+		return trace
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// problemWriter buffers error responses so that they may be rewritten. Other
+// responses are written through.
+type problemWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buffered    bool
+	body        bytes.Buffer
+}
+
+func (p *problemWriter) WriteHeader(code int) {
+	if p.wroteHeader {
+		return
+	}
+	p.wroteHeader = true
+	p.status = code
+	if code >= http.StatusBadRequest {
+		p.buffered = true
+		return
+	}
+	p.ResponseWriter.WriteHeader(code)
+}
+
+func (p *problemWriter) Write(b []byte) (int, error) {
+	if !p.wroteHeader {
+		p.WriteHeader(http.StatusOK)
+	}
+	if p.buffered {
+		return p.body.Write(b)
+	}
+	return p.ResponseWriter.Write(b)
+}
+
+// Flush supports streaming successful responses.
+func (p *problemWriter) Flush() {
+	if f, ok := p.ResponseWriter.(http.Flusher); ok && !p.buffered {
+		f.Flush()
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+func TestWithProblemDetails(t *testing.T) {
+	failing := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		resp := v0.RegisterResponse{
+			Error: &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid parameters from request",
+				Status: http.StatusBadRequest,
+			},
+			Invalid: []v0.InvalidParam{{Param: "uplink", Code: v0.ParamInvalid}},
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+	}
+	ok := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		writeResponse(rw, v0.RegisterResponse{})
+	}
+	plain := func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "not found", http.StatusNotFound)
+	}
+	tests := []struct {
+		name            string
+		next            http.HandlerFunc
+		accept          string
+		trace           string
+		wantCode        int
+		wantContentType string
+		wantInstance    string
+	}{
+		{
+			name:            "success-problem",
+			next:            failing,
+			accept:          "application/problem+json",
+			trace:           "0123abcd/1;o=1",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/problem+json",
+			wantInstance:    "/autojoin/v0/node/register#0123abcd",
+		},
+		{
+			name:            "success-problem-quality",
+			next:            failing,
+			accept:          "application/json, application/problem+json;q=0.5",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/problem+json",
+		},
+		{
+			name:            "success-not-accepted",
+			next:            failing,
+			accept:          "application/json",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "success-zero-quality",
+			next:            failing,
+			accept:          "application/problem+json;q=0",
+			wantCode:        http.StatusBadRequest,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "success-ok-unchanged",
+			next:            ok,
+			accept:          "application/problem+json",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+		},
+		{
+			name:            "success-plain-error-unchanged",
+			next:            plain,
+			accept:          "application/problem+json",
+			wantCode:        http.StatusNotFound,
+			wantContentType: "text/plain; charset=utf-8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/register", nil)
+			req.Header.Set("Accept", tt.accept)
+			if tt.trace != "" {
+				req.Header.Set("X-Cloud-Trace-Context", tt.trace)
+			}
+			WithProblemDetails(tt.next)(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("WithProblemDetails() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if ct := rw.Header().Get("Content-Type"); ct != tt.wantContentType {
+				t.Errorf("WithProblemDetails() returned wrong content type; got %q, want %q", ct, tt.wantContentType)
+			}
+			if tt.wantContentType != problemContentType {
+				return
+			}
+			p := map[string]interface{}{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &p); err != nil {
+				t.Fatalf("WithProblemDetails() returned invalid json: %v", err)
+			}
+			if p["type"] != "urn:autojoin:error:invalid_param" || p["status"] != float64(tt.wantCode) {
+				t.Errorf("WithProblemDetails() returned wrong problem; got %v", p)
+			}
+			instance, _ := p["instance"].(string)
+			if !strings.HasPrefix(instance, "/autojoin/v0/node/register#") {
+				t.Errorf("WithProblemDetails() returned wrong instance; got %q", instance)
+			}
+			if tt.wantInstance != "" && instance != tt.wantInstance {
+				t.Errorf("WithProblemDetails() returned wrong instance; got %q, want %q", instance, tt.wantInstance)
+			}
+			if _, ok := p["invalid"]; !ok {
+				t.Errorf("WithProblemDetails() did not include invalid params; got %v", p)
+			}
+		})
+	}
+}
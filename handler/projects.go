@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/keys"
+)
+
+// adminPrefix is the path prefix of the admin APIs, whose callers may select a
+// project with the "project" parameter.
+const adminPrefix = "/autojoin/v0/admin/"
+
+// ProjectRouter routes requests of the Autojoin API to the handler of their
+// target project, so that one instance can serve several projects. Admin
+// requests select a project with the "project" parameter, and other requests
+// are routed by their host. Requests for other hosts, and requests outside
+// the Autojoin API, e.g. health checks, are served by the default project.
+type ProjectRouter struct {
+	def       http.Handler
+	validator APIKeyValidator
+	projects  map[string]http.Handler
+	hosts     map[string]string
+}
+
+// NewProjectRouter creates a new ProjectRouter that routes requests to the
+// handler of the default project unless another project is added. Only keys
+// of the default project with the admin scope, found by v, may select a
+// project.
+func NewProjectRouter(project string, h http.Handler, v APIKeyValidator) *ProjectRouter {
+	return &ProjectRouter{
+		def:       h,
+		validator: v,
+		projects:  map[string]http.Handler{project: h},
+		hosts:     map[string]string{},
+	}
+}
+
+// Add routes the requests of the given hosts, and admin requests for the
+// project, to h.
+func (r *ProjectRouter) Add(project string, hosts []string, h http.Handler) {
+	r.projects[project] = h
+	for _, host := range hosts {
+		r.hosts[strings.ToLower(host)] = project
+	}
+}
+
+func (r *ProjectRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, "/autojoin/") {
+		r.def.ServeHTTP(rw, req)
+		return
+	}
+	if project := req.URL.Query().Get("project"); project != "" {
+		if !strings.HasPrefix(req.URL.Path, adminPrefix) {
+			writeAuthError(rw, http.StatusForbidden, v0.ErrProjectNotAllowed, "project parameter is only allowed for admin APIs")
+			return
+		}
+		WithAPIKeyValidation(r.validator, RequireScope(keys.ScopeAdmin, func(rw http.ResponseWriter, req *http.Request) {
+			h, ok := r.projects[project]
+			if !ok {
+				writeAuthError(rw, http.StatusBadRequest, v0.ErrInvalidProject, "unknown project: "+project)
+				return
+			}
+			h.ServeHTTP(rw, req)
+		}))(rw, req)
+		return
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if project, ok := r.hosts[strings.ToLower(host)]; ok {
+		r.projects[project].ServeHTTP(rw, req)
+		return
+	}
+	r.def.ServeHTTP(rw, req)
+}
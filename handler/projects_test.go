@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/keys"
+)
+
+func TestProjectRouter(t *testing.T) {
+	project := func(name string) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Project", name)
+		})
+	}
+	admin := []string{keys.ScopeAdmin}
+	tests := []struct {
+		name     string
+		host     string
+		target   string
+		scopes   []string
+		want     string
+		wantCode int
+		wantType string
+	}{
+		{
+			name:   "default",
+			host:   "autojoin-dot-mlab-sandbox.appspot.com",
+			target: "/autojoin/v0/node/list",
+			want:   "mlab-sandbox",
+		},
+		{
+			name:   "host",
+			host:   "Autojoin-dot-mlab-staging.appspot.com:443",
+			target: "/autojoin/v0/node/list",
+			want:   "mlab-staging",
+		},
+		{
+			name:   "admin-project",
+			host:   "autojoin-dot-mlab-sandbox.appspot.com",
+			target: "/autojoin/v0/admin/org?key=abc&org=foo&project=mlab-staging",
+			scopes: admin,
+			want:   "mlab-staging",
+		},
+		{
+			name:   "admin-default-project",
+			host:   "autojoin-dot-mlab-staging.appspot.com",
+			target: "/autojoin/v0/admin/org?key=abc&org=foo&project=mlab-sandbox",
+			scopes: admin,
+			want:   "mlab-sandbox",
+		},
+		{
+			name:   "health-check",
+			host:   "autojoin-dot-mlab-staging.appspot.com",
+			target: "/v0/ready",
+			want:   "mlab-sandbox",
+		},
+		{
+			name:     "error-unknown-project",
+			target:   "/autojoin/v0/admin/org?key=abc&project=mlab-oti",
+			scopes:   admin,
+			wantCode: http.StatusBadRequest,
+			wantType: v0.ErrInvalidProject,
+		},
+		{
+			name:     "error-project-missing-key",
+			target:   "/autojoin/v0/admin/org?org=foo&project=mlab-staging",
+			scopes:   admin,
+			wantCode: http.StatusUnauthorized,
+			wantType: v0.ErrMissingAPIKey,
+		},
+		{
+			name:     "error-project-not-admin-key",
+			target:   "/autojoin/v0/admin/org?key=abc&org=foo&project=mlab-staging",
+			scopes:   keys.DefaultScopes,
+			wantCode: http.StatusForbidden,
+			wantType: v0.ErrMissingScope,
+		},
+		{
+			name:     "error-not-admin",
+			target:   "/autojoin/v0/node/register?project=mlab-staging",
+			wantCode: http.StatusForbidden,
+			wantType: v0.ErrProjectNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewProjectRouter("mlab-sandbox", project("mlab-sandbox"), &fakeKeyValidator{org: "mlab", scopes: tt.scopes})
+			r.Add("mlab-staging", []string{"autojoin-dot-mlab-staging.appspot.com"}, project("mlab-staging"))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if got := rw.Header().Get("X-Project"); got != tt.want {
+				t.Errorf("ServeHTTP() routed to %q, want %q", got, tt.want)
+			}
+			if tt.wantCode == 0 {
+				return
+			}
+			if rw.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := struct{ Error *struct{ Type string } }{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Type != tt.wantType {
+				t.Errorf("ServeHTTP() body = %s, want error type %q", rw.Body, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/provision"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// ProvisioningTokens is an interface used by the Server to redeem single-use
+// provisioning tokens.
+type ProvisioningTokens interface {
+	Redeem(ctx context.Context, token string) (string, error)
+	Release(ctx context.Context, token string) error
+}
+
+type nodeKeyKey struct{}
+
+// Provision handler registers a new node using a single-use provisioning
+// "token" in place of an organization API key. The token is exchanged for a
+// new API key with the "register" scope, which is returned with the node
+// credentials and should be used for later requests. All other parameters
+// are the same as Register. If the registration fails, the token may be used
+// again and the new API key is revoked.
+func (s *Server) Provision(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.RegisterResponse{}
+	if s.Tokens == nil || s.APIKeys == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "provisioning tokens are not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMissingToken,
+			Title:  "provisioning token is required",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if req.URL.Query().Get("dry_run") == "true" {
+		// A dry run would use the token without returning credentials.
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "dry runs are not supported with provisioning tokens",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	org, err := s.Tokens.Redeem(req.Context(), token)
+	switch {
+	case errors.Is(err, provision.ErrInvalidToken):
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidToken,
+			Title:  "provisioning token is unknown, expired, or already used",
+			Status: http.StatusUnauthorized,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	case err != nil:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrProvision,
+			Title:  "could not redeem provisioning token",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("provisioning token redeem failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	k, key, err := s.APIKeys.Create(req.Context(), org, []string{keys.ScopeRegister}, time.Time{})
+	if err != nil {
+		s.releaseToken(req.Context(), token)
+		resp.Error = &v2.Error{
+			Type:   v0.ErrAPIKeys,
+			Title:  "could not create api key for node",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("provisioning api key create failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	// Register with the new key of the organization of the token, and never
+	// pass the token on.
+	q := req.URL.Query()
+	q.Del("token")
+	ctx := context.WithValue(req.Context(), keyInfoKey{}, &keys.Info{ID: k.ID, Org: org, Scopes: k.Scopes})
+	r := req.Clone(context.WithValue(ctx, nodeKeyKey{}, key))
+	r.URL.RawQuery = q.Encode()
+
+	rec := &recordingWriter{ResponseWriter: rw, status: http.StatusOK}
+	s.Register(rec, r)
+	if rec.status >= http.StatusBadRequest {
+		s.releaseToken(req.Context(), token)
+		if err := s.APIKeys.Revoke(req.Context(), org, k.ID); err != nil {
+			log.Println("provisioning api key revoke failure:", err)
+		}
+		return
+	}
+	log.Printf("Provisioning token for %s exchanged for API key %s", org, k.ID)
+}
+
+func (s *Server) releaseToken(ctx context.Context, token string) {
+	if err := s.Tokens.Release(ctx, token); err != nil {
+		log.Println("provisioning token release failure:", err)
+	}
+}
+
+// nodeKeyFromContext returns the API key created by Provision.
+func nodeKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(nodeKeyKey{}).(string)
+	return key, ok
+}
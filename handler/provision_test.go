@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/provision"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/uuid-annotator/annotator"
+	"github.com/oschwald/geoip2-golang"
+)
+
+type fakeTokens struct {
+	org       string
+	redeemErr error
+	released  bool
+}
+
+func (f *fakeTokens) Redeem(ctx context.Context, token string) (string, error) {
+	return f.org, f.redeemErr
+}
+
+func (f *fakeTokens) Release(ctx context.Context, token string) error {
+	f.released = true
+	return nil
+}
+
+func TestServer_Provision(t *testing.T) {
+	params := "?token=abc&service=ndt&iata=lga&ipv4=192.168.0.1&type=physical&uplink=10g"
+	tests := []struct {
+		name         string
+		tokens       *fakeTokens
+		keys         *fakeKeyManager
+		dns          *fakeDNS
+		smErr        error
+		params       string
+		wantCode     int
+		wantName     string
+		wantReleased bool
+		wantRevoked  string
+	}{
+		{
+			name:     "success",
+			tokens:   &fakeTokens{org: "mlab"},
+			keys:     &fakeKeyManager{},
+			dns:      &fakeDNS{},
+			params:   params,
+			wantCode: http.StatusOK,
+			wantName: "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org",
+		},
+		{
+			name:     "error-not-enabled",
+			params:   params,
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-missing-token",
+			tokens:   &fakeTokens{org: "mlab"},
+			keys:     &fakeKeyManager{},
+			params:   "?service=ndt&iata=lga",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-dry-run",
+			tokens:   &fakeTokens{org: "mlab"},
+			keys:     &fakeKeyManager{},
+			params:   params + "&dry_run=true",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-invalid-token",
+			tokens:   &fakeTokens{redeemErr: provision.ErrInvalidToken},
+			keys:     &fakeKeyManager{},
+			params:   params,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-redeem",
+			tokens:   &fakeTokens{redeemErr: errors.New("fake redeem error")},
+			keys:     &fakeKeyManager{},
+			params:   params,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:         "error-create-key",
+			tokens:       &fakeTokens{org: "mlab"},
+			keys:         &fakeKeyManager{createErr: errors.New("fake create error")},
+			params:       params,
+			wantCode:     http.StatusInternalServerError,
+			wantReleased: true,
+		},
+		{
+			name:         "error-register",
+			tokens:       &fakeTokens{org: "mlab"},
+			keys:         &fakeKeyManager{},
+			dns:          &fakeDNS{},
+			smErr:        errors.New("fake load key error"),
+			params:       params,
+			wantCode:     http.StatusInternalServerError,
+			wantReleased: true,
+			wantRevoked:  "autojoin-key-mlab-1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", &fakeIataFinder{findRow: iata.Row{IATA: "lga"}}, &fakeMaxmind{city: &geoip2.City{}},
+				&fakeAsn{ann: &annotator.Network{ASNumber: 12345}}, tt.dns, &fakeStatusTracker{}, &fakeSecretManager{key: "fake key data", err: tt.smErr})
+			if tt.tokens != nil {
+				s.Tokens = tt.tokens
+			}
+			if tt.keys != nil {
+				s.APIKeys = tt.keys
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/provision"+tt.params, nil)
+
+			s.Provision(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Provision() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if tt.tokens != nil && tt.tokens.released != tt.wantReleased {
+				t.Errorf("Provision() released token = %t, want %t", tt.tokens.released, tt.wantReleased)
+			}
+			if tt.keys != nil && tt.keys.revoked != tt.wantRevoked {
+				t.Errorf("Provision() revoked wrong key; got %q, want %q", tt.keys.revoked, tt.wantRevoked)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			resp := &v0.RegisterResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), resp), "failed to parse response")
+			if resp.Registration.Hostname != tt.wantName {
+				t.Errorf("Provision() returned wrong hostname; got %q, want %q", resp.Registration.Hostname, tt.wantName)
+			}
+			if resp.Registration.Credentials.APIKey != "secret" {
+				t.Errorf("Provision() returned wrong api key; got %q, want %q", resp.Registration.Credentials.APIKey, "secret")
+			}
+			if len(tt.keys.created.Scopes) != 1 || tt.keys.created.Scopes[0] != "register" {
+				t.Errorf("Provision() created key with wrong scopes; got %v", tt.keys.created.Scopes)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	v2 "github.com/m-lab/locate/api/v2"
+	"google.golang.org/api/dns/v1"
+)
+
+// Records handler is used by operators to manage the extra records of their
+// organization zone, e.g. a wildcard for auxiliary services. GET lists the
+// extra records, POST adds or replaces the record with the given name, type
+// and values, and DELETE removes the record with the given name and type. New
+// records are limited by the record quota of the organization. The
+// organization is taken from the API key found by WithAPIKeyValidation.
+func (s *Server) Records(rw http.ResponseWriter, req *http.Request) {
+	// All replies, errors and successes, should be json.
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.RecordsResponse{}
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
+	}
+	if !isValidName(org) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	settings, err := s.getOrgSettings(req.Context(), org)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSettings,
+			Title:  "could not load organization settings",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("org settings get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	zoneDNS := dnsname.OrgDNS(org, s.Project, s.Domain)
+	m := s.dnsManager(org, s.Domain)
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if settings.Suspended() {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrOrgSuspended,
+				Title:  "organization is suspended",
+				Detail: fmt.Sprintf("organization %q cannot add records", org),
+				Status: http.StatusForbidden,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		rr, err := getExtraRecord(req, zoneDNS)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid record from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		extra, err := m.ListExtra(req.Context(), zoneDNS)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrList,
+				Title:  "failed to list extra records",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("records list failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		replace := false
+		for _, e := range extra {
+			replace = replace || (e.Name == rr.Name && e.Type == rr.Type)
+		}
+		if quota := settings.RecordQuota(); !replace && len(extra) >= quota {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrQuotaExceeded,
+				Title:  "record quota exceeded",
+				Detail: fmt.Sprintf("organization %q may add at most %d records", org, quota),
+				Status: http.StatusForbidden,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		err = m.SetExtra(req.Context(), rr)
+		switch {
+		case errors.Is(err, dnsx.ErrExtraConflict):
+			resp.Error = &v2.Error{
+				Type:   v0.ErrExists,
+				Title:  "record conflicts with an existing record",
+				Detail: err.Error(),
+				Status: http.StatusConflict,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		case err != nil:
+			resp.Error = &v2.Error{
+				Type:   v0.ErrDNSRegister,
+				Title:  "failed to set extra record",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("records set failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		audit(req, "record "+rr.Name, []string{rr.Type + ": " + strings.Join(rr.Rrdatas, " ")})
+	case http.MethodDelete:
+		name, err := dnsx.ExtraName(req.URL.Query().Get("name"), zoneDNS)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid name from request",
+				Detail: err.Error(),
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		rtype := strings.ToUpper(req.URL.Query().Get("type"))
+		err = m.DeleteExtra(req.Context(), name, rtype)
+		switch {
+		case errors.Is(err, dnsx.ErrExtraNotFound):
+			resp.Error = &v2.Error{
+				Type:   v0.ErrNotFound,
+				Title:  "record not found",
+				Detail: fmt.Sprintf("no %s record named %q", rtype, name),
+				Status: http.StatusNotFound,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		case err != nil:
+			resp.Error = &v2.Error{
+				Type:   v0.ErrDNSDelete,
+				Title:  "failed to delete extra record",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("records delete failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		audit(req, "record "+name, []string{rtype + ": deleted"})
+	default:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	extra, err := m.ListExtra(req.Context(), zoneDNS)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrList,
+			Title:  "failed to list extra records",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("records list failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Org = org
+	resp.Quota = settings.RecordQuota()
+	for _, rr := range extra {
+		resp.Records = append(resp.Records, v0.ExtraRecord{
+			Name:   strings.TrimSuffix(rr.Name, "."+zoneDNS),
+			Type:   rr.Type,
+			Values: rr.Rrdatas,
+			TTL:    rr.Ttl,
+		})
+	}
+	writeResponse(rw, resp)
+}
+
+// getExtraRecord returns the record set of the extra record with the name,
+// type and repeated value parameters of the request.
+func getExtraRecord(req *http.Request, zoneDNS string) (*dns.ResourceRecordSet, error) {
+	q := req.URL.Query()
+	name, err := dnsx.ExtraName(q.Get("name"), zoneDNS)
+	if err != nil {
+		return nil, err
+	}
+	return dnsx.ExtraRecord(name, strings.ToUpper(q.Get("type")), q["value"])
+}
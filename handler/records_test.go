@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/go/testingx"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestServer_Records(t *testing.T) {
+	const zoneDNS = "mlab.sandbox.measurement-lab.org."
+	www := &dns.ResourceRecordSet{Name: "www." + zoneDNS, Type: "A", Ttl: 300, Rrdatas: []string{"192.0.2.1"}}
+	records := []*dns.ResourceRecordSet{
+		www,
+		{Name: "ndt-lga3356-040e9f4b." + zoneDNS, Type: "A", Ttl: 300, Rrdatas: []string{"192.0.2.2"}},
+	}
+	tests := []struct {
+		name        string
+		method      string
+		params      string
+		dns         *fakeDNS
+		orgs        *fakeOrgSettings
+		wantCode    int
+		wantRecords []v0.ExtraRecord
+	}{
+		{
+			name:     "success-get",
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			dns:      &fakeDNS{records: records},
+			wantCode: http.StatusOK,
+			wantRecords: []v0.ExtraRecord{
+				{Name: "www", Type: "A", Values: []string{"192.0.2.1"}, TTL: 300},
+			},
+		},
+		{
+			name:     "success-post",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=*.lab&type=txt&value=foo&value=bar",
+			dns:      &fakeDNS{},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "success-post-replace-at-quota",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=www&type=A&value=192.0.2.3",
+			dns:      &fakeDNS{records: records},
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{MaxRecords: 1}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "success-delete",
+			method:   http.MethodDelete,
+			params:   "?org=mlab&name=www&type=a",
+			dns:      &fakeDNS{records: records},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-invalid-org",
+			method:   http.MethodGet,
+			params:   "?org=-BAD-",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-settings",
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			orgs:     &fakeOrgSettings{getErr: errors.New("fake get error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-list",
+			method:   http.MethodGet,
+			params:   "?org=mlab",
+			dns:      &fakeDNS{listErr: errors.New("fake list error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-post-suspended",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=www&type=A&value=192.0.2.3",
+			dns:      &fakeDNS{},
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{Status: orgs.StatusSuspended}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-post-invalid-name",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=ndt-lga3356&type=A&value=192.0.2.3",
+			dns:      &fakeDNS{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-post-invalid-value",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=www&type=AAAA&value=192.0.2.3",
+			dns:      &fakeDNS{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-post-quota",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=lab&type=A&value=192.0.2.3",
+			dns:      &fakeDNS{records: records},
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{MaxRecords: 1}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-post-disabled",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=lab&type=A&value=192.0.2.3",
+			dns:      &fakeDNS{},
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{MaxRecords: -1}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-post-conflict",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=www&type=CNAME&value=example.com",
+			dns:      &fakeDNS{records: records},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "error-post-change",
+			method:   http.MethodPost,
+			params:   "?org=mlab&name=lab&type=A&value=192.0.2.3",
+			dns:      &fakeDNS{chgErr: errors.New("fake change error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-delete-not-found",
+			method:   http.MethodDelete,
+			params:   "?org=mlab&name=lab&type=A",
+			dns:      &fakeDNS{getErr: &googleapi.Error{Code: http.StatusNotFound}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-delete-invalid-name",
+			method:   http.MethodDelete,
+			params:   "?org=mlab&name=a.b.c.d.e&type=A",
+			dns:      &fakeDNS{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-delete-change",
+			method:   http.MethodDelete,
+			params:   "?org=mlab&name=www&type=A",
+			dns:      &fakeDNS{records: records, chgErr: errors.New("fake change error")},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-method",
+			method:   http.MethodPut,
+			params:   "?org=mlab",
+			dns:      &fakeDNS{},
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-missing-key",
+			method:   http.MethodGet,
+			dns:      &fakeDNS{records: records},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, tt.dns, nil, nil)
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/org/records"+tt.params, nil)
+			req = withKeyOrg(req)
+
+			s.Records(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Records() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.RecordsResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), &resp), "failed to unmarshal response")
+			if tt.wantRecords != nil && !reflect.DeepEqual(resp.Records, tt.wantRecords) {
+				t.Errorf("Records() returned wrong records; got %+v, want %+v", resp.Records, tt.wantRecords)
+			}
+		})
+	}
+}
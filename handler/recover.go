@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/metrics"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// WithRecovery returns a handler that recovers panics of next, e.g. from
+// rtx.PanicOnError, and responds with a 500 error instead of dropping the
+// connection. The stack trace is logged with the request ID of the response,
+// and panics are counted by path.
+func WithRecovery(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rec := &recoveryWriter{ResponseWriter: rw}
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				// Aborted responses are not errors of the handler.
+				panic(r)
+			}
+			id := requestID(req)
+			log.Printf("panic %s#%s: %v\n%s", req.URL.Path, id, r, debug.Stack())
+			metrics.RequestPanics.WithLabelValues(req.URL.Path).Inc()
+			if rec.wroteHeader {
+				// The response has started and cannot be replaced.
+				return
+			}
+			resp := struct {
+				Error *v2.Error
+			}{
+				Error: &v2.Error{
+					Type:   v0.ErrInternal,
+					Title:  "internal server error",
+					Detail: "request " + id,
+					Status: http.StatusInternalServerError,
+				},
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+		}()
+		next(rec, req)
+	}
+}
+
+// recoveryWriter records whether the response has started.
+type recoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (r *recoveryWriter) WriteHeader(code int) {
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recoveryWriter) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush supports streaming responses.
+func (r *recoveryWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		f.Flush()
+	}
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWithRecovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		next      http.HandlerFunc
+		wantCode  int
+		wantPanic bool
+		wantBody  string
+	}{
+		{
+			name: "success",
+			path: "/recover/success",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Write([]byte("ok"))
+			},
+			wantCode: http.StatusOK,
+			wantBody: "ok",
+		},
+		{
+			name: "error-panic",
+			path: "/recover/panic",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rtx.PanicOnError(errors.New("fake error"), "failed")
+			},
+			wantCode:  http.StatusInternalServerError,
+			wantPanic: true,
+		},
+		{
+			name: "error-panic-after-write",
+			path: "/recover/partial",
+			next: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Write([]byte("partial"))
+				panic("fake panic")
+			},
+			wantCode:  http.StatusOK,
+			wantPanic: true,
+			wantBody:  "partial",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Cloud-Trace-Context", "0123abcd/1;o=1")
+
+			WithRecovery(tt.next)(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("WithRecovery() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			panics := testutil.ToFloat64(metrics.RequestPanics.WithLabelValues(tt.path))
+			if (panics == 1) != tt.wantPanic {
+				t.Errorf("WithRecovery() counted wrong panics; got %v, want panic %t", panics, tt.wantPanic)
+			}
+			if tt.wantBody != "" {
+				if rw.Body.String() != tt.wantBody {
+					t.Errorf("WithRecovery() returned wrong body; got %q, want %q", rw.Body.String(), tt.wantBody)
+				}
+				return
+			}
+			resp := v0.DeleteResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("WithRecovery() returned invalid json: %v", err)
+			}
+			if resp.Error == nil || resp.Error.Type != v0.ErrInternal || !strings.Contains(resp.Error.Detail, "0123abcd") {
+				t.Errorf("WithRecovery() returned wrong error; got %+v", resp.Error)
+			}
+		})
+	}
+}
+
+func TestWithRecovery_abort(t *testing.T) {
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("WithRecovery() recovered wrong panic; got %v, want %v", r, http.ErrAbortHandler)
+		}
+	}()
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/recover/abort", nil)
+	WithRecovery(func(rw http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	})(rw, req)
+}
@@ -0,0 +1,28 @@
+package handler
+
+import "context"
+
+// CloseStreams ends the streaming responses of the Events handler, which
+// would otherwise delay a graceful shutdown until its timeout. New streams
+// end immediately. CloseStreams may be called more than once.
+func (s *Server) CloseStreams() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+}
+
+// Wait waits until the background work of handlers, e.g. the DNS and tracker
+// updates of asynchronous deletes, is complete or ctx is done.
+func (s *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.ops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
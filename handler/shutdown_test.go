@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_CloseStreams(t *testing.T) {
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, &fakeStatusTracker{}, nil)
+	s.NodeEvents = &fakeEventStream{open: true}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rw := httptest.NewRecorder()
+		s.Events(rw, httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/events", nil))
+	}()
+
+	s.CloseStreams()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Events() did not return after CloseStreams()")
+	}
+	// CloseStreams may be called again.
+	s.CloseStreams()
+}
+
+func TestServer_Wait(t *testing.T) {
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, &fakeStatusTracker{}, nil)
+	s.ops.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() with pending operation = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	s.ops.Done()
+	if err := s.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() = %v, want nil", err)
+	}
+}
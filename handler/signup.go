@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/notify"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/signup"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// OrgApplications is an interface used by the Server to record and review
+// applications of new organizations.
+type OrgApplications interface {
+	Submit(ctx context.Context, a *signup.Application) error
+	Get(ctx context.Context, org string) (*signup.Application, error)
+	List(ctx context.Context, status string) ([]*signup.Application, error)
+	Review(ctx context.Context, org, status, reviewer, reason string) (*signup.Application, error)
+}
+
+// OrgCreator is an interface used by the Server to create the Google Cloud
+// resources of approved organizations, e.g. *adminx.Org.
+type OrgCreator interface {
+	Setup(ctx context.Context, org string) (string, error)
+}
+
+// EmailVerifier is an interface used by the Server to verify the contact
+// emails of organizations.
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, org, email string) error
+	Verify(ctx context.Context, org, token string) error
+}
+
+// Apply handler records the application of a new organization with the
+// given "org" name, contact "email", "asn", and expected number of "nodes".
+// Applications are pending until an operator approves or rejects them with
+// the Applications handler.
+func (s *Server) Apply(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.OrgApplicationResponse{}
+	if s.Signups == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "organization applications are not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if req.Method != http.MethodPost {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	a, err := getApplication(req)
+	if err != nil {
+		resp.Error = err
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if err := s.Signups.Submit(req.Context(), a); err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrApplications,
+			Title:  "failed to save organization application",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		if errors.Is(err, signup.ErrExists) {
+			resp.Error.Type = v0.ErrExists
+			resp.Error.Status = http.StatusConflict
+		} else {
+			log.Println("org application submit failure:", err)
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	log.Printf("Organization application: %s by %s from %s", a.Org, a.Email, getSourceIP(req, defaultProxyHops))
+	resp.Applications = []*v0.OrgApplication{toOrgApplication(a)}
+	writeResponse(rw, resp)
+}
+
+// getApplication parses the application parameters of the request.
+func getApplication(req *http.Request) (*signup.Application, *v2.Error) {
+	q := req.URL.Query()
+	a := &signup.Application{Org: q.Get("org"), Email: q.Get("email")}
+	if !isValidName(a.Org) {
+		return nil, &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+	}
+	if err := orgs.ValidateEmail(a.Email); a.Email == "" || err != nil {
+		return nil, &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid email from request",
+			Status: http.StatusBadRequest,
+		}
+	}
+	asn, err := strconv.ParseInt(strings.TrimPrefix(strings.ToUpper(q.Get("asn")), "AS"), 10, 64)
+	if err != nil || asn <= 0 {
+		return nil, &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid asn from request",
+			Status: http.StatusBadRequest,
+		}
+	}
+	a.ASN = asn
+	nodes, err := strconv.ParseInt(q.Get("nodes"), 10, 64)
+	if err != nil || nodes <= 0 {
+		return nil, &v2.Error{
+			Type:   v0.ErrInvalidParam,
+			Title:  "invalid nodes from request",
+			Status: http.StatusBadRequest,
+		}
+	}
+	a.Nodes = nodes
+	return a, nil
+}
+
+// Applications handler is used by operators to review organization
+// applications. A GET lists applications, optionally with the given "status".
+// A POST sets the "status" of the pending application of "org" to "approved"
+// or "rejected", with an optional "reason". Approval creates the organization
+// and returns its API key. If the creation fails, the application remains
+// pending and may be approved again.
+func (s *Server) Applications(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.OrgApplicationResponse{}
+	if s.Signups == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "organization applications are not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	status := req.URL.Query().Get("status")
+
+	switch req.Method {
+	case http.MethodGet:
+		l, err := s.Signups.List(req.Context(), status)
+		if err != nil {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrApplications,
+				Title:  "failed to list organization applications",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+			log.Println("org applications list failure:", err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		for _, a := range l {
+			resp.Applications = append(resp.Applications, toOrgApplication(a))
+		}
+	case http.MethodPost:
+		org := req.URL.Query().Get("org")
+		if !isValidName(org) {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidOrg,
+				Title:  "could not determine organization from request",
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if status != signup.StatusApproved && status != signup.StatusRejected {
+			resp.Error = &v2.Error{
+				Type:   v0.ErrInvalidParam,
+				Title:  "invalid status from request",
+				Detail: "status must be \"" + signup.StatusApproved + "\" or \"" + signup.StatusRejected + "\"",
+				Status: http.StatusBadRequest,
+			}
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		if status == signup.StatusApproved {
+			key, err := s.approve(req.Context(), org)
+			if err != nil {
+				resp.Error = err
+				rw.WriteHeader(resp.Error.Status)
+				writeResponse(rw, resp)
+				return
+			}
+			resp.Key = key
+		}
+		a, err := s.Signups.Review(req.Context(), org, status, caller(req), req.URL.Query().Get("reason"))
+		if err != nil {
+			resp.Error = reviewError(err)
+			rw.WriteHeader(resp.Error.Status)
+			writeResponse(rw, resp)
+			return
+		}
+		audit(req, "organization application "+org, []string{"Status: " + status})
+		resp.Applications = []*v0.OrgApplication{toOrgApplication(a)}
+	default:
+		resp.Error = &v2.Error{
+			Type:   v0.ErrMethodNotAllowed,
+			Title:  "method not allowed",
+			Status: http.StatusMethodNotAllowed,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	writeResponse(rw, resp)
+}
+
+// approve creates the organization of the pending application of org, saves
+// the contact email of the application in the organization settings, and
+// returns the organization API key.
+func (s *Server) approve(ctx context.Context, org string) (string, *v2.Error) {
+	if s.OrgSetup == nil {
+		return "", &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "organization setup is not enabled",
+			Status: http.StatusNotImplemented,
+		}
+	}
+	a, err := s.Signups.Get(ctx, org)
+	if err != nil {
+		return "", reviewError(err)
+	}
+	if a.Status != signup.StatusPending {
+		return "", reviewError(signup.ErrNotPending)
+	}
+	key, err := s.OrgSetup.Setup(ctx, org)
+	if err != nil {
+		log.Println("org setup failure:", org, err)
+		return "", &v2.Error{
+			Type:   v0.ErrOrgSetup,
+			Title:  "failed to create organization",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+	}
+	if s.Orgs != nil {
+		settings, err := s.Orgs.Get(ctx, org)
+		if err == nil {
+			settings.Email = a.Email
+			err = s.Orgs.Set(ctx, org, settings)
+		}
+		if err != nil {
+			log.Println("org settings set failure:", err)
+			return "", &v2.Error{
+				Type:   v0.ErrOrgSettings,
+				Title:  "failed to save organization settings",
+				Detail: err.Error(),
+				Status: http.StatusInternalServerError,
+			}
+		}
+	}
+	// The organization is created already, so the approval does not fail.
+	if s.Emails != nil {
+		if err := s.Emails.SendVerification(ctx, org, a.Email); err != nil {
+			log.Println("org email verification failure:", err)
+		}
+	}
+	return key, nil
+}
+
+// Verify handler verifies the contact email of "org" with the "token" of the
+// link sent to the email. Verified emails receive notifications.
+func (s *Server) Verify(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.VerifyResponse{}
+	if s.Emails == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "email verification is not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org := req.URL.Query().Get("org")
+	if !isValidName(org) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidOrg,
+			Title:  "could not determine organization from request",
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	err := s.Emails.Verify(req.Context(), org, req.URL.Query().Get("token"))
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrEmail,
+			Title:  "failed to verify organization email",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		if errors.Is(err, notify.ErrInvalidToken) {
+			resp.Error.Type = v0.ErrInvalidToken
+			resp.Error.Status = http.StatusUnauthorized
+		} else {
+			log.Println("org email verify failure:", err)
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	log.Printf("Organization email verified: %s", org)
+	resp.Org = org
+	writeResponse(rw, resp)
+}
+
+// reviewError returns the response error of a failed review.
+func reviewError(err error) *v2.Error {
+	e := &v2.Error{
+		Type:   v0.ErrApplications,
+		Title:  "failed to review organization application",
+		Detail: err.Error(),
+		Status: http.StatusInternalServerError,
+	}
+	switch {
+	case errors.Is(err, signup.ErrNotFound):
+		e.Type = v0.ErrNotFound
+		e.Status = http.StatusNotFound
+	case errors.Is(err, signup.ErrNotPending):
+		e.Type = v0.ErrNotPending
+		e.Status = http.StatusConflict
+	default:
+		log.Println("org application review failure:", err)
+	}
+	return e
+}
+
+func toOrgApplication(a *signup.Application) *v0.OrgApplication {
+	return &v0.OrgApplication{
+		Org:      a.Org,
+		Email:    a.Email,
+		ASN:      a.ASN,
+		Nodes:    a.Nodes,
+		Status:   a.Status,
+		Created:  a.Created,
+		Reviewed: a.Reviewed,
+		Reviewer: a.Reviewer,
+		Reason:   a.Reason,
+	}
+}
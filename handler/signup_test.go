@@ -0,0 +1,409 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/notify"
+	"github.com/m-lab/autojoin/internal/signup"
+)
+
+type fakeSignups struct {
+	app       *signup.Application
+	submitted *signup.Application
+	submitErr error
+	getErr    error
+	listErr   error
+	reviewErr error
+	reviewed  string
+}
+
+func (f *fakeSignups) Submit(ctx context.Context, a *signup.Application) error {
+	f.submitted = a
+	return f.submitErr
+}
+
+func (f *fakeSignups) Get(ctx context.Context, org string) (*signup.Application, error) {
+	return f.app, f.getErr
+}
+
+func (f *fakeSignups) List(ctx context.Context, status string) ([]*signup.Application, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	return []*signup.Application{f.app}, nil
+}
+
+func (f *fakeSignups) Review(ctx context.Context, org, status, reviewer, reason string) (*signup.Application, error) {
+	if f.reviewErr != nil {
+		return nil, f.reviewErr
+	}
+	f.reviewed = status
+	a := *f.app
+	a.Status = status
+	a.Reviewer = reviewer
+	a.Reason = reason
+	return &a, nil
+}
+
+type fakeOrgCreator struct {
+	key      string
+	setupErr error
+	org      string
+}
+
+func (f *fakeOrgCreator) Setup(ctx context.Context, org string) (string, error) {
+	f.org = org
+	return f.key, f.setupErr
+}
+
+type fakeEmails struct {
+	sent      string
+	sendErr   error
+	verifyErr error
+}
+
+func (f *fakeEmails) SendVerification(ctx context.Context, org, email string) error {
+	f.sent = email
+	return f.sendErr
+}
+
+func (f *fakeEmails) Verify(ctx context.Context, org, token string) error {
+	return f.verifyErr
+}
+
+func TestServer_Apply(t *testing.T) {
+	params := "?org=foo&email=ops@foo.example&asn=AS64512&nodes=3"
+	tests := []struct {
+		name     string
+		signups  *fakeSignups
+		method   string
+		params   string
+		wantCode int
+	}{
+		{
+			name:     "success",
+			signups:  &fakeSignups{},
+			method:   http.MethodPost,
+			params:   params,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-disabled",
+			method:   http.MethodPost,
+			params:   params,
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-method",
+			signups:  &fakeSignups{},
+			method:   http.MethodGet,
+			params:   params,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-org",
+			signups:  &fakeSignups{},
+			method:   http.MethodPost,
+			params:   "?org=-BAD-&email=ops@foo.example&asn=64512&nodes=3",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-email",
+			signups:  &fakeSignups{},
+			method:   http.MethodPost,
+			params:   "?org=foo&asn=64512&nodes=3",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-asn",
+			signups:  &fakeSignups{},
+			method:   http.MethodPost,
+			params:   "?org=foo&email=ops@foo.example&asn=ASX&nodes=3",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-nodes",
+			signups:  &fakeSignups{},
+			method:   http.MethodPost,
+			params:   "?org=foo&email=ops@foo.example&asn=64512&nodes=0",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-exists",
+			signups:  &fakeSignups{submitErr: signup.ErrExists},
+			method:   http.MethodPost,
+			params:   params,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "error-submit",
+			signups:  &fakeSignups{submitErr: errors.New("fake submit error")},
+			method:   http.MethodPost,
+			params:   params,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.signups != nil {
+				s.Signups = tt.signups
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/org/apply"+tt.params, nil)
+
+			s.Apply(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Apply() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			a := tt.signups.submitted
+			if a.Org != "foo" || a.Email != "ops@foo.example" || a.ASN != 64512 || a.Nodes != 3 {
+				t.Errorf("Apply() submitted wrong application: %+v", a)
+			}
+		})
+	}
+}
+
+func TestServer_Applications(t *testing.T) {
+	pending := &signup.Application{Org: "foo", Email: "ops@foo.example", Status: signup.StatusPending}
+	tests := []struct {
+		name         string
+		signups      *fakeSignups
+		setup        *fakeOrgCreator
+		orgs         *fakeOrgSettings
+		emails       *fakeEmails
+		method       string
+		params       string
+		wantCode     int
+		wantReviewed string
+		wantKey      string
+		wantEmail    string
+	}{
+		{
+			name:     "success-list",
+			signups:  &fakeSignups{app: pending},
+			method:   http.MethodGet,
+			params:   "?status=pending",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:         "success-approve",
+			signups:      &fakeSignups{app: pending},
+			setup:        &fakeOrgCreator{key: "fake-key"},
+			orgs:         &fakeOrgSettings{},
+			emails:       &fakeEmails{},
+			method:       http.MethodPost,
+			params:       "?org=foo&status=approved",
+			wantCode:     http.StatusOK,
+			wantReviewed: signup.StatusApproved,
+			wantKey:      "fake-key",
+			wantEmail:    "ops@foo.example",
+		},
+		{
+			name:         "success-approve-verification-error",
+			signups:      &fakeSignups{app: pending},
+			setup:        &fakeOrgCreator{key: "fake-key"},
+			emails:       &fakeEmails{sendErr: errors.New("fake send error")},
+			method:       http.MethodPost,
+			params:       "?org=foo&status=approved",
+			wantCode:     http.StatusOK,
+			wantReviewed: signup.StatusApproved,
+			wantKey:      "fake-key",
+		},
+		{
+			name:         "success-reject",
+			signups:      &fakeSignups{app: pending},
+			method:       http.MethodPost,
+			params:       "?org=foo&status=rejected&reason=unknown+network",
+			wantCode:     http.StatusOK,
+			wantReviewed: signup.StatusRejected,
+		},
+		{
+			name:     "error-disabled",
+			method:   http.MethodGet,
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-method",
+			signups:  &fakeSignups{app: pending},
+			method:   http.MethodDelete,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "error-list",
+			signups:  &fakeSignups{listErr: errors.New("fake list error")},
+			method:   http.MethodGet,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-org",
+			signups:  &fakeSignups{app: pending},
+			method:   http.MethodPost,
+			params:   "?org=-BAD-&status=rejected",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-status",
+			signups:  &fakeSignups{app: pending},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=pending",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-approve-disabled",
+			signups:  &fakeSignups{app: pending},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=approved",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-approve-not-found",
+			signups:  &fakeSignups{getErr: signup.ErrNotFound},
+			setup:    &fakeOrgCreator{},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=approved",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-approve-not-pending",
+			signups:  &fakeSignups{app: &signup.Application{Org: "foo", Status: signup.StatusRejected}},
+			setup:    &fakeOrgCreator{},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=approved",
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "error-approve-setup",
+			signups:  &fakeSignups{app: pending},
+			setup:    &fakeOrgCreator{setupErr: errors.New("fake setup error")},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=approved",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-approve-settings",
+			signups:  &fakeSignups{app: pending},
+			setup:    &fakeOrgCreator{},
+			orgs:     &fakeOrgSettings{setErr: errors.New("fake set error")},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=approved",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-review",
+			signups:  &fakeSignups{app: pending, reviewErr: errors.New("fake review error")},
+			method:   http.MethodPost,
+			params:   "?org=foo&status=rejected",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.signups != nil {
+				s.Signups = tt.signups
+			}
+			if tt.setup != nil {
+				s.OrgSetup = tt.setup
+			}
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
+			}
+			if tt.emails != nil {
+				s.Emails = tt.emails
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/autojoin/v0/admin/applications"+tt.params, nil)
+
+			s.Applications(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Applications() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			resp := v0.OrgApplicationResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if resp.Key != tt.wantKey {
+				t.Errorf("Applications() returned wrong key; got %q, want %q", resp.Key, tt.wantKey)
+			}
+			if tt.signups != nil && tt.signups.reviewed != tt.wantReviewed {
+				t.Errorf("Applications() reviewed = %q, want %q", tt.signups.reviewed, tt.wantReviewed)
+			}
+			if tt.orgs != nil && tt.orgs.settings.Email != tt.wantEmail {
+				t.Errorf("Applications() saved email = %q, want %q", tt.orgs.settings.Email, tt.wantEmail)
+			}
+			if tt.emails != nil && tt.emails.sent != pending.Email {
+				t.Errorf("Applications() sent verification to %q, want %q", tt.emails.sent, pending.Email)
+			}
+			if tt.wantCode == http.StatusOK && len(resp.Applications) != 1 {
+				t.Errorf("Applications() returned wrong applications: %v", resp.Applications)
+			}
+		})
+	}
+}
+
+func TestServer_Verify(t *testing.T) {
+	tests := []struct {
+		name     string
+		emails   *fakeEmails
+		params   string
+		wantCode int
+	}{
+		{
+			name:     "success",
+			emails:   &fakeEmails{},
+			params:   "?org=foo&token=abc",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-disabled",
+			params:   "?org=foo&token=abc",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-org",
+			emails:   &fakeEmails{},
+			params:   "?org=-BAD-&token=abc",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-invalid-token",
+			emails:   &fakeEmails{verifyErr: notify.ErrInvalidToken},
+			params:   "?org=foo&token=abc",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-verify",
+			emails:   &fakeEmails{verifyErr: errors.New("fake verify error")},
+			params:   "?org=foo&token=abc",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			if tt.emails != nil {
+				s.Emails = tt.emails
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/org/verify"+tt.params, nil)
+
+			s.Verify(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Verify() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+		})
+	}
+}
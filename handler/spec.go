@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/config"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// Spec handler returns the OpenAPI document of the API, with response
+// schemas generated from the api/v0 types.
+func (s *Server) Spec(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if s.OpenAPI == nil {
+		// The OpenAPI document has no response type of its own.
+		resp := struct {
+			Error *v2.Error
+		}{
+			Error: &v2.Error{
+				Type:   v0.ErrNotEnabled,
+				Title:  "openapi document is not available",
+				Status: http.StatusNotImplemented,
+			},
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	rw.Write(s.OpenAPI)
+}
+
+// Version handler returns the build version of the server and the oldest
+// client version it accepts, for the clients of the "service" parameter if
+// given. With a RuntimeConfig, the version and the enabled feature flags are
+// those of the runtime config.
+func (s *Server) Version(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	resp := v0.VersionResponse{
+		Version:          s.BuildVersion,
+		MinClientVersion: s.minClientVersion(req.URL.Query().Get("service")),
+	}
+	if s.RuntimeConfig != nil {
+		resp.Features = s.RuntimeConfig.Get().Features
+	}
+	writeResponse(rw, resp)
+}
+
+// minClientVersion returns the oldest client version accepted for the
+// service, if any.
+func (s *Server) minClientVersion(service string) string {
+	if s.RuntimeConfig != nil {
+		return s.RuntimeConfig.Get().MinVersion(service)
+	}
+	return s.MinClientVersion
+}
+
+// clientWarnings returns the warnings of requests of deprecated Go clients
+// that are still accepted.
+func (s *Server) clientWarnings(req *http.Request) []v0.Warning {
+	if s.RuntimeConfig == nil {
+		return nil
+	}
+	c := s.RuntimeConfig.Get()
+	v := clientVersion(req)
+	if !config.Older(v, c.DeprecatedClientVersion) {
+		return nil
+	}
+	w := v0.Warning{
+		Type:    v0.WarnClientDeprecated,
+		Message: fmt.Sprintf("client version %s is deprecated, upgrade to %s or newer", v, c.DeprecatedClientVersion),
+	}
+	if !c.ClientSunset.IsZero() {
+		w.Sunset = c.ClientSunset.Format(time.DateOnly)
+		w.Message += " before " + w.Sunset
+	}
+	return []v0.Warning{w}
+}
+
+// versionLabel returns the metric label of a client version.
+func versionLabel(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
+
+// clientVersion returns the version of the Go client package that sent the
+// request, from its User-Agent, or "" for other clients and invalid versions.
+func clientVersion(req *http.Request) string {
+	v, ok := strings.CutPrefix(req.UserAgent(), "autojoin-client/")
+	if !ok {
+		return ""
+	}
+	v, _, _ = strings.Cut(v, " ")
+	if !config.ValidVersion(v) {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/config"
+)
+
+func TestServer_Spec(t *testing.T) {
+	tests := []struct {
+		name     string
+		openapi  []byte
+		wantCode int
+	}{
+		{
+			name:     "success",
+			openapi:  []byte(`{"swagger":"2.0"}`),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-not-enabled",
+			wantCode: http.StatusNotImplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+			s.OpenAPI = tt.openapi
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/spec", nil)
+			s.Spec(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Spec() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if tt.openapi != nil && rw.Body.String() != string(tt.openapi) {
+				t.Errorf("Spec() returned wrong document; got %q", rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_Version(t *testing.T) {
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+	s.BuildVersion = "v1.2.3"
+	s.MinClientVersion = "0.1.0"
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/version", nil)
+	s.Version(rw, req)
+
+	resp := v0.VersionResponse{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if rw.Code != http.StatusOK || resp.Version != "v1.2.3" || resp.MinClientVersion != "0.1.0" {
+		t.Errorf("Version() = %d %+v; want v1.2.3 and 0.1.0", rw.Code, resp)
+	}
+}
+
+func TestServer_Version_runtimeConfig(t *testing.T) {
+	s := NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+	s.MinClientVersion = "0.1.0"
+	s.RuntimeConfig = &fakeRuntimeConfig{c: config.Config{
+		MinClientVersion: "0.2.0",
+		ServiceVersions:  []config.ServiceVersion{{Service: "ndt", MinClientVersion: "0.3.0"}},
+		Features:         []string{"a"},
+	}}
+	tests := []struct {
+		params string
+		want   string
+	}{
+		{params: "", want: "0.2.0"},
+		{params: "?service=ndt", want: "0.3.0"},
+		{params: "?service=wehe", want: "0.2.0"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/version"+tt.params, nil)
+		s.Version(rw, req)
+
+		resp := v0.VersionResponse{}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.MinClientVersion != tt.want || len(resp.Features) != 1 || resp.Features[0] != "a" {
+			t.Errorf("Version(%q) = %+v; want %s and features [a]", tt.params, resp, tt.want)
+		}
+	}
+}
+
+func Test_clientVersion(t *testing.T) {
+	tests := []struct {
+		agent string
+		want  string
+	}{
+		{agent: "autojoin-client/0.1.0", want: "0.1.0"},
+		{agent: "autojoin-client/0.1.0 (linux)", want: "0.1.0"},
+		{agent: "autojoin-client/dev"},
+		{agent: "curl/8.0.0"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/version", nil)
+		req.Header.Set("User-Agent", tt.agent)
+		if got := clientVersion(req); got != tt.want {
+			t.Errorf("clientVersion(%q) = %q, want %q", tt.agent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// arrayEncoder writes a JSON array one element at a time so that large
+// results never need to be held in memory as a whole. The opening text is
+// only written with the first element so that an empty array may be rendered
+// differently, e.g. to preserve omitempty semantics of the enclosing object.
+type arrayEncoder struct {
+	w     io.Writer
+	enc   *json.Encoder
+	open  string
+	close string
+	empty string
+	n     int
+	err   error
+}
+
+// newArrayEncoder creates an arrayEncoder that writes open before the first
+// element, close after the last element, or empty if there are no elements.
+func newArrayEncoder(w io.Writer, open, close, empty string) *arrayEncoder {
+	return &arrayEncoder{w: w, enc: json.NewEncoder(w), open: open, close: close, empty: empty}
+}
+
+// Encode writes v as the next array element.
+func (a *arrayEncoder) Encode(v interface{}) {
+	if a.err != nil {
+		return
+	}
+	sep := ","
+	if a.n == 0 {
+		sep = a.open
+	}
+	a.n++
+	if _, a.err = io.WriteString(a.w, sep); a.err != nil {
+		return
+	}
+	a.err = a.enc.Encode(v)
+}
+
+// EncodeField writes key and v as the next member of a JSON object. It allows
+// an arrayEncoder opened with "{" to stream objects keyed by name.
+func (a *arrayEncoder) EncodeField(key string, v interface{}) {
+	if a.err != nil {
+		return
+	}
+	sep := ","
+	if a.n == 0 {
+		sep = a.open
+	}
+	a.n++
+	if _, a.err = io.WriteString(a.w, sep); a.err != nil {
+		return
+	}
+	if a.err = a.enc.Encode(key); a.err != nil {
+		return
+	}
+	if _, a.err = io.WriteString(a.w, ":"); a.err != nil {
+		return
+	}
+	a.err = a.enc.Encode(v)
+}
+
+// Close completes the array and returns the first error encountered.
+func (a *arrayEncoder) Close() error {
+	if a.err != nil {
+		return a.err
+	}
+	end := a.close
+	if a.n == 0 {
+		end = a.empty
+	}
+	_, a.err = io.WriteString(a.w, end+"\n")
+	return a.err
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses.
+func acceptsGzip(req *http.Request) bool {
+	for _, e := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(e), ";")
+		if strings.TrimSpace(coding) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// responseWriter returns a writer for the response body, compressed with gzip
+// if the client accepts it. Callers must close the returned writer.
+func responseWriter(rw http.ResponseWriter, req *http.Request) io.WriteCloser {
+	rw.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req) {
+		return nopCloser{rw}
+	}
+	rw.Header().Set("Content-Encoding", "gzip")
+	return gzip.NewWriter(rw)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/go/testingx"
+)
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("fake write error")
+}
+
+func Test_arrayEncoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		want  string
+	}{
+		{name: "empty", want: "{}\n"},
+		{name: "one", items: []string{"a"}, want: "{\"Servers\":[\"a\"\n]}\n"},
+		{name: "two", items: []string{"a", "b"}, want: "{\"Servers\":[\"a\"\n,\"b\"\n]}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			a := newArrayEncoder(buf, `{"Servers":[`, "]}", "{}")
+			for _, i := range tt.items {
+				a.Encode(i)
+			}
+			testingx.Must(t, a.Close(), "failed to close encoder")
+			if buf.String() != tt.want {
+				t.Errorf("arrayEncoder wrote %q, want %q", buf.String(), tt.want)
+			}
+			resp := v0.ListResponse{}
+			testingx.Must(t, json.Unmarshal(buf.Bytes(), &resp), "failed to unmarshal output")
+			if len(resp.Servers) != len(tt.items) {
+				t.Errorf("arrayEncoder wrote %d items, want %d", len(resp.Servers), len(tt.items))
+			}
+		})
+	}
+
+	// Objects are streamed one member at a time.
+	buf := &bytes.Buffer{}
+	a := newArrayEncoder(buf, "{", "}", "{}")
+	a.EncodeField("a", 1)
+	a.EncodeField("b", 2)
+	testingx.Must(t, a.Close(), "failed to close encoder")
+	got := map[string]int{}
+	testingx.Must(t, json.Unmarshal(buf.Bytes(), &got), "failed to unmarshal output")
+	if got["a"] != 1 || got["b"] != 2 || len(got) != 2 {
+		t.Errorf("arrayEncoder wrote wrong object; got %q", buf.String())
+	}
+
+	a = newArrayEncoder(errWriter{}, "{", "}", "{}")
+	a.EncodeField("a", 1)
+	if err := a.Close(); err == nil {
+		t.Errorf("Close() returned nil, want write error")
+	}
+
+	a = newArrayEncoder(errWriter{}, "[", "]", "[]")
+	a.Encode("a")
+	a.Encode("b")
+	if err := a.Close(); err == nil {
+		t.Errorf("Close() returned nil, want write error")
+	}
+}
+
+func Test_acceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: false},
+		{header: "gzip", want: true},
+		{header: "deflate, gzip;q=0.5", want: true},
+		{header: "gzip;q=0", want: false},
+		{header: "br", want: false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsGzip(req); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestServer_ListStreaming(t *testing.T) {
+	lister := &fakeStatusTracker{
+		nodes: []string{
+			"ndt-lga3356-040e9f4b.mlab.autojoin.measurement-lab.org",
+			"ndt-lga3356-abcdef12.mlab.autojoin.measurement-lab.org"},
+		status: withPorts([]string{"9990"}, []string{"9990"}),
+	}
+	// Results are compressed both when streamed and when served from the cache.
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		s := NewServer("mlab-sandbox", nil, nil, nil, nil, lister, nil)
+		s.ListCacheTTL = ttl
+		rw := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/list?format=servers", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		s.List(rw, req)
+
+		if rw.Code != http.StatusOK || rw.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("List() = %d with encoding %q, want 200 with gzip", rw.Code, rw.Header().Get("Content-Encoding"))
+		}
+		if ttl == 0 && rw.Header().Get("ETag") != "" {
+			t.Errorf("List() returned etag with caching disabled")
+		}
+		r, err := gzip.NewReader(rw.Body)
+		testingx.Must(t, err, "failed to read gzip response")
+		b, err := io.ReadAll(r)
+		testingx.Must(t, err, "failed to decompress response")
+		resp := v0.ListResponse{}
+		testingx.Must(t, json.Unmarshal(b, &resp), "failed to unmarshal response")
+		if len(resp.Servers) != 2 {
+			t.Errorf("List() returned wrong length; got %d, want 2", len(resp.Servers))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/host"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// AccessTokenGenerator is an interface used by the Server to generate
+// short-lived access tokens for organization service accounts.
+type AccessTokenGenerator interface {
+	Generate(ctx context.Context, org string) (string, time.Time, error)
+}
+
+// Token handler is used by autonodes to refresh the access token returned by
+// Register before it expires. Only registered hostnames of organizations that
+// are not suspended receive tokens. Only hostnames of the organization of the
+// API key found by WithAPIKeyValidation are accepted.
+func (s *Server) Token(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	resp := v0.TokenResponse{}
+	if s.AccessTokens == nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotEnabled,
+			Title:  "access tokens are not enabled",
+			Status: http.StatusNotImplemented,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	name, err := host.Parse(req.URL.Query().Get("hostname"))
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrInvalidHostname,
+			Title:  "failed to parse hostname",
+			Detail: err.Error(),
+			Status: http.StatusBadRequest,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	org, ok := orgFromContext(req.Context())
+	if !ok {
+		writeAuthError(rw, http.StatusUnauthorized, v0.ErrMissingAPIKey, "missing api key")
+		return
+	}
+	if org != name.Org {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrWrongOrg,
+			Title:  "hostname does not belong to organization",
+			Status: http.StatusForbidden,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	hostname := name.StringAll()
+	_, err = s.dnsTracker.Get(hostname)
+	if errors.Is(err, tracker.ErrNotFound) {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrNotFound,
+			Title:  "hostname is not registered",
+			Status: http.StatusNotFound,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrTracker,
+			Title:  "failed to read hostname from DNS tracker",
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("dns gc get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	settings, err := s.getOrgSettings(req.Context(), name.Org)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSettings,
+			Title:  "could not load organization settings",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("org settings get failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	if settings.Suspended() {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrOrgSuspended,
+			Title:  "organization is suspended",
+			Status: http.StatusForbidden,
+		}
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+
+	token, expiry, err := s.AccessTokens.Generate(req.Context(), name.Org)
+	if err != nil {
+		resp.Error = &v2.Error{
+			Type:   v0.ErrCredentials,
+			Title:  "could not generate access token for node",
+			Status: http.StatusInternalServerError,
+		}
+		log.Println("access token generate failure:", err)
+		rw.WriteHeader(resp.Error.Status)
+		writeResponse(rw, resp)
+		return
+	}
+	resp.Hostname = hostname
+	resp.Credentials = &v0.Credentials{AccessToken: token, AccessTokenExpiry: &expiry}
+	writeResponse(rw, resp)
+}
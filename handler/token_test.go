@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/testingx"
+)
+
+func TestServer_Token(t *testing.T) {
+	hostname := "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org"
+	expiry := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		tokens   *fakeAccessTokens
+		tracker  *fakeStatusTracker
+		orgs     *fakeOrgSettings
+		org      string
+		params   string
+		wantCode int
+	}{
+		{
+			name:     "success",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "error-not-enabled",
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "error-hostname",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{},
+			org:      "mlab",
+			params:   "?hostname=invalid",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "error-other-org",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			org:      "foo",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-missing-key",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-not-registered",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{getErr: tracker.ErrNotFound},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error-tracker",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{getErr: errors.New("fake get error")},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-settings",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			orgs:     &fakeOrgSettings{getErr: errors.New("fake get error")},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error-suspended",
+			tokens:   &fakeAccessTokens{expiry: expiry},
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			orgs:     &fakeOrgSettings{settings: orgs.Settings{Status: orgs.StatusSuspended}},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-generate",
+			tokens:   &fakeAccessTokens{err: errors.New("fake generate error")},
+			tracker:  &fakeStatusTracker{record: &tracker.DNSRecord{}},
+			org:      "mlab",
+			params:   "?hostname=" + hostname,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("mlab-sandbox", nil, nil, nil, nil, tt.tracker, nil)
+			if tt.tokens != nil {
+				s.AccessTokens = tt.tokens
+			}
+			if tt.orgs != nil {
+				s.Orgs = tt.orgs
+			}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/autojoin/v0/node/token"+tt.params, nil)
+			if tt.org != "" {
+				req = withKey(req, tt.org)
+			}
+
+			s.Token(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("Token() returned wrong code; got %d, want %d", rw.Code, tt.wantCode)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			resp := &v0.TokenResponse{}
+			testingx.Must(t, json.Unmarshal(rw.Body.Bytes(), resp), "failed to parse response")
+			if resp.Hostname != hostname || resp.Credentials == nil || resp.Credentials.AccessToken != "token-mlab" {
+				t.Errorf("Token() returned wrong response; got %+v", resp)
+			}
+			if resp.Credentials.AccessTokenExpiry == nil || !resp.Credentials.AccessTokenExpiry.Equal(expiry) {
+				t.Errorf("Token() returned wrong expiry; got %v, want %v", resp.Credentials.AccessTokenExpiry, expiry)
+			}
+		})
+	}
+}
@@ -5,61 +5,156 @@ import (
 	"context"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/m-lab/autojoin/internal/kdtree"
+	"github.com/m-lab/autojoin/internal/metrics"
 	"github.com/m-lab/go/content"
 	"github.com/m-lab/go/mathx"
 )
 
 // Client manages the IATA data.
 type Client struct {
-	src  content.Provider
-	mu   sync.Mutex
-	rows []Row
+	// FallbackRadius is the distance in km within which airports of any
+	// country are searched when a country has no airports, e.g. for small
+	// territories or new ISO codes. Zero disables the fallback.
+	FallbackRadius float64
+
+	// MaxShrink is the largest fraction of rows that a reload may remove.
+	// Reloads of datasets that shrank more are rejected, and the data in use
+	// is kept. Zero disables the check.
+	MaxShrink float64
+
+	src content.Provider
+	// metros is the curated dataset of approved metros, if any.
+	metros content.Provider
+	mu     sync.Mutex
+	rows   []Row
+	// The last parsed source and curated datasets, reused when a reload
+	// finds one of them unchanged, and whether they are not in use yet.
+	upstream []Row
+	approved map[string]metro
+	pending  bool
+	// Nearest neighbor indexes of the rows of every country, and of all rows.
+	countries map[string]kdtree.Tree
+	all       kdtree.Tree
 }
 
 // Row is a single row in the IATA dataset.
 type Row struct {
 	CountryCode string
 	IATA        string
+	ICAO        string
+	Airport     string
 	Latitude    float64
 	Longitude   float64
 }
 
+// DefaultMaxShrink is the MaxShrink of new Clients.
+const DefaultMaxShrink = 0.5
+
+// ErrShrunk is returned by Load if the reloaded dataset has too few rows.
+var ErrShrunk = errors.New("dataset shrank by more than the maximum")
+
 // New creates a new Client from IATA data contained at the given URL. Any
-// URL supported m-lab/go/content may be provided.
+// URL supported m-lab/go/content may be provided. HTTP(S) URLs are reloaded
+// only if the ETag or Last-Modified time of the data changed.
 func New(ctx context.Context, u *url.URL) (*Client, error) {
-	p, err := content.FromURL(ctx, u)
+	p, err := newSource(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 	c := &Client{
-		src: p,
+		MaxShrink: DefaultMaxShrink,
+		src:       p,
 	}
 	return c, nil
 }
 
-// Load downloads and parses the iata data from the provider source.
+// Load downloads and parses the iata data from the provider source. Load
+// keeps the data in use if neither the source nor the curated dataset
+// changed, or if the reloaded data fails validation.
 func (c *Client) Load(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	// Download raw data.
 	raw, err := c.src.Get(ctx)
-	if err != nil {
+	switch {
+	case err == content.ErrNoChange && c.upstream != nil:
+	case err != nil:
+		metrics.IataLoadFailures.WithLabelValues("fetch").Inc()
 		return err
+	default:
+		c.upstream = parseRows(raw)
+		c.pending = true
+	}
+	if c.metros != nil {
+		raw, err := c.metros.Get(ctx)
+		switch {
+		case err == content.ErrNoChange && c.approved != nil:
+		case err != nil:
+			metrics.IataLoadFailures.WithLabelValues("metros").Inc()
+			return err
+		default:
+			approved, err := parseMetros(raw)
+			if err != nil {
+				metrics.IataLoadFailures.WithLabelValues("metros").Inc()
+				return err
+			}
+			c.approved = approved
+			c.pending = true
+		}
+	}
+	if !c.pending {
+		return nil
+	}
+	rows := c.upstream
+	if c.approved != nil {
+		rows, err = mergeMetros(rows, c.approved)
+		if err != nil {
+			metrics.IataLoadFailures.WithLabelValues("metros").Inc()
+			return err
+		}
+	}
+	// A truncated download or a broken release may remove most airports.
+	if c.MaxShrink > 0 && float64(len(rows)) < float64(len(c.rows))*(1-c.MaxShrink) {
+		metrics.IataLoadFailures.WithLabelValues("shrunk").Inc()
+		return fmt.Errorf("%w: %d rows, was %d", ErrShrunk, len(rows), len(c.rows))
+	}
+	// Index the rows by country, and for the cross border fallback.
+	byCountry := map[string][]kdtree.Point{}
+	all := make([]kdtree.Point, len(rows))
+	for i, r := range rows {
+		all[i] = kdtree.NewPoint(r.Latitude, r.Longitude, i)
+		byCountry[r.CountryCode] = append(byCountry[r.CountryCode], all[i])
 	}
+	countries := make(map[string]kdtree.Tree, len(byCountry))
+	for country, pts := range byCountry {
+		countries[country] = kdtree.New(pts)
+	}
+	c.rows = rows
+	c.countries = countries
+	c.all = kdtree.New(all)
+	c.pending = false
+	metrics.IataRows.Set(float64(len(rows)))
+	metrics.IataLastUpdate.SetToCurrentTime()
+	return nil
+}
+
+// parseRows parses the rows of the IATA dataset. Invalid rows are skipped.
+func parseRows(raw []byte) []Row {
 	// Parse as a CSV. NOTE: the parser preserves values between quotes and removes quotes.
 	b := bytes.NewBuffer(raw)
 	r := csv.NewReader(b)
 	// Header and field positions.
 	// "country_code","region_name","iata","icao","airport","latitude","longitude"
 	// "US","New York","LGA","KLGA","LaGuardia Airport","40.775","-73.875"
-	var rows []Row
+	rows := []Row{}
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -81,18 +176,24 @@ func (c *Client) Load(ctx context.Context) error {
 		row := Row{
 			CountryCode: record[0],
 			IATA:        strings.ToLower(record[2]),
+			ICAO:        strings.ToLower(record[3]),
+			Airport:     record[4],
 			Latitude:    lat,
 			Longitude:   lon,
 		}
 		rows = append(rows, row)
 	}
-	c.rows = rows
-	return nil
+	return rows
 }
 
-type dist struct {
-	iata     string
-	distance float64
+// Airport is a row of the IATA dataset and its distance from a location.
+type Airport struct {
+	Row
+	// Distance is the distance in km.
+	Distance float64
+	// CrossBorder is true for airports outside of the requested country,
+	// found by the fallback search.
+	CrossBorder bool
 }
 
 // ErrNoAirports is returned if Lookup can find no airports.
@@ -100,32 +201,68 @@ var ErrNoAirports = errors.New("no airports in country")
 
 // Lookup searches for the IATA code closest to the given lat/lon within the given country.
 func (c *Client) Lookup(country string, lat, lon float64) (string, error) {
+	airports, err := c.Nearest(country, lat, lon, 1)
+	if err != nil {
+		return "", err
+	}
+	// Return closest.
+	return airports[0].IATA, nil
+}
+
+// Nearest returns up to n airports closest to the given lat/lon within the
+// given country, closest first. If the country has no airports, the airports
+// of any country within FallbackRadius are returned instead.
+func (c *Client) Nearest(country string, lat, lon float64, n int) ([]Airport, error) {
 	c.mu.Lock()
 	// Allow safe Load during Lookup.
+	rows, countries, all := c.rows, c.countries, c.all
+	c.mu.Unlock()
+	p := kdtree.NewPoint(lat, lon, -1)
+	crossBorder := false
+	// Search the airports in country, or within the fallback radius.
+	pts := countries[country].Nearest(p, n, 4)
+	if len(pts) == 0 && c.FallbackRadius > 0 {
+		pts = all.Nearest(p, n, kdtree.Chord2(c.FallbackRadius))
+		crossBorder = true
+	}
+	if len(pts) == 0 {
+		return nil, ErrNoAirports
+	}
+	airports := make([]Airport, len(pts))
+	for i, pt := range pts {
+		r := rows[pt.ID]
+		airports[i] = Airport{Row: r, Distance: r.Distance(lat, lon), CrossBorder: crossBorder}
+	}
+	return airports, nil
+}
+
+// Distance returns the distance in km from the airport of the row to the
+// given lat/lon.
+func (r Row) Distance(lat, lon float64) float64 {
+	return mathx.GetHaversineDistance(lat, lon, r.Latitude, r.Longitude)
+}
+
+// FindByICAO returns the row with metadata about the airport with the given
+// ICAO code.
+func (c *Client) FindByICAO(icao string) (Row, error) {
+	c.mu.Lock()
 	rows := c.rows
 	c.mu.Unlock()
-	// Find all distances to airports in country.
-	airports := []dist{}
+	icao = strings.ToLower(icao)
 	for i := range rows {
-		r := rows[i]
-		if r.CountryCode == country {
-			distance := mathx.GetHaversineDistance(lat, lon, r.Latitude, r.Longitude)
-			d := dist{
-				iata:     r.IATA,
-				distance: distance,
-			}
-			airports = append(airports, d)
+		// Some airports have no ICAO code.
+		if icao != "" && rows[i].ICAO == icao {
+			return rows[i], nil
 		}
 	}
-	if len(airports) == 0 {
-		return "", ErrNoAirports
-	}
-	// Sort distances closest to furthest.
-	sort.Slice(airports, func(i, j int) bool {
-		return airports[i].distance < airports[j].distance
-	})
-	// Return closest.
-	return airports[0].iata, nil
+	return Row{}, ErrNoAirports
+}
+
+// Len returns the number of rows in use.
+func (c *Client) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.rows)
 }
 
 // Find returns the row with metadata about the given iata code.
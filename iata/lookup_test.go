@@ -129,6 +129,8 @@ func TestClient_Find(t *testing.T) {
 			want: Row{
 				CountryCode: "US",
 				IATA:        "jfk",
+				ICAO:        "kjfk",
+				Airport:     "John F. Kennedy International Airport",
 				Latitude:    40.6397,
 				Longitude:   -73.7789,
 			},
@@ -160,3 +162,106 @@ func TestClient_Find(t *testing.T) {
 		})
 	}
 }
+
+func TestRow_Distance(t *testing.T) {
+	r := Row{IATA: "lga", Latitude: 40.775, Longitude: -73.875}
+	if d := r.Distance(40.775, -73.875); d != 0 {
+		t.Errorf("Row.Distance() = %v, want 0", d)
+	}
+	// About 9 km from Manhattan.
+	if d := r.Distance(40.7, -73.9); d < 8 || d > 9 {
+		t.Errorf("Row.Distance() = %v, want between 8 and 9", d)
+	}
+}
+
+func TestClient_Nearest(t *testing.T) {
+	tests := []struct {
+		name    string
+		country string
+		radius  float64
+		n       int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "success-one",
+			country: "US",
+			n:       1,
+			want:    []string{"jfk"},
+		},
+		{
+			name:    "success-all",
+			country: "US",
+			n:       5,
+			want:    []string{"jfk", "lga"},
+		},
+		{
+			name:    "success-cross-border",
+			country: "CA",
+			radius:  500,
+			n:       5,
+			want:    []string{"jfk", "lga"},
+		},
+		{
+			name:    "error",
+			country: "CA",
+			n:       5,
+			wantErr: true,
+		},
+		{
+			name:    "error-outside-radius",
+			country: "CA",
+			radius:  100,
+			n:       5,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse("file:testdata/input.csv")
+			testingx.Must(t, err, "failed to parse file")
+			c, err := New(context.Background(), u)
+			testingx.Must(t, err, "failed to create new client")
+			err = c.Load(context.Background())
+			testingx.Must(t, err, "failed to load dataset")
+			c.FallbackRadius = tt.radius
+
+			got, err := c.Nearest(tt.country, 40, -70, tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.Nearest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			codes := []string{}
+			for i, a := range got {
+				codes = append(codes, a.IATA)
+				if i > 0 && a.Distance < got[i-1].Distance {
+					t.Errorf("Client.Nearest() distances are not sorted: %v", got)
+				}
+				if a.CrossBorder != (a.CountryCode != tt.country) {
+					t.Errorf("Client.Nearest() CrossBorder = %v for %s", a.CrossBorder, a.IATA)
+				}
+			}
+			if !tt.wantErr && !reflect.DeepEqual(codes, tt.want) {
+				t.Errorf("Client.Nearest() = %v, want %v", codes, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_FindByICAO(t *testing.T) {
+	u, err := url.Parse("file:testdata/input.csv")
+	testingx.Must(t, err, "failed to parse file")
+	c, err := New(context.Background(), u)
+	testingx.Must(t, err, "failed to create new client")
+	testingx.Must(t, c.Load(context.Background()), "failed to load dataset")
+
+	got, err := c.FindByICAO("KLGA")
+	if err != nil || got.IATA != "lga" {
+		t.Errorf("Client.FindByICAO() = %v, %v, want lga", got, err)
+	}
+	for _, icao := range []string{"", "klax"} {
+		if _, err := c.FindByICAO(icao); err != ErrNoAirports {
+			t.Errorf("Client.FindByICAO(%q) error = %v, want %v", icao, err, ErrNoAirports)
+		}
+	}
+}
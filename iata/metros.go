@@ -0,0 +1,109 @@
+package iata
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SetMetros restricts the IATA data to the metros approved by M-Lab, read
+// from the curated dataset at the given URL on every Load. Any URL supported
+// by m-lab/go/content may be provided.
+//
+// The curated dataset is a CSV with the fields below. Empty fields keep the
+// values of the IATA dataset, e.g. to only correct the coordinates of an
+// airport. Metros missing from the IATA dataset must have every field.
+//
+//	"iata","country_code","latitude","longitude"
+//	"LGA","US","40.7769","-73.8740"
+func (c *Client) SetMetros(ctx context.Context, u *url.URL) error {
+	p, err := newSource(ctx, u)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metros = p
+	c.approved = nil
+	return nil
+}
+
+// metro is a row of the curated dataset. Empty fields are nil or "".
+type metro struct {
+	country  string
+	lat, lon *float64
+}
+
+// mergeMetros returns the rows of the approved metros, with the values of the
+// curated dataset.
+func mergeMetros(rows []Row, metros map[string]metro) ([]Row, error) {
+	merged := []Row{}
+	found := map[string]bool{}
+	for _, r := range rows {
+		m, ok := metros[r.IATA]
+		if !ok || found[r.IATA] {
+			continue
+		}
+		found[r.IATA] = true
+		if m.country != "" {
+			r.CountryCode = m.country
+		}
+		if m.lat != nil {
+			r.Latitude, r.Longitude = *m.lat, *m.lon
+		}
+		merged = append(merged, r)
+	}
+	// Remaining metros are not in the IATA dataset.
+	for code, m := range metros {
+		if found[code] || m.country == "" || m.lat == nil {
+			continue
+		}
+		merged = append(merged, Row{
+			CountryCode: m.country,
+			IATA:        code,
+			Latitude:    *m.lat,
+			Longitude:   *m.lon,
+		})
+	}
+	if len(merged) == 0 {
+		return nil, fmt.Errorf("no approved metros in dataset")
+	}
+	return merged, nil
+}
+
+// parseMetros parses the curated dataset by lowercase IATA code.
+func parseMetros(raw []byte) (map[string]metro, error) {
+	r := csv.NewReader(bytes.NewReader(raw))
+	r.FieldsPerRecord = 4
+	metros := map[string]metro{}
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(record[0])
+		if line == 1 && code == "iata" {
+			// Header.
+			continue
+		}
+		m := metro{country: record[1]}
+		if record[2] != "" || record[3] != "" {
+			lat, errLat := strconv.ParseFloat(record[2], 64)
+			lon, errLon := strconv.ParseFloat(record[3], 64)
+			if errLat != nil || errLon != nil {
+				return nil, fmt.Errorf("invalid coordinates of metro %q on line %d", code, line)
+			}
+			m.lat, m.lon = &lat, &lon
+		}
+		metros[code] = m
+	}
+	return metros, nil
+}
@@ -0,0 +1,72 @@
+package iata
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/testingx"
+)
+
+func TestClient_SetMetros(t *testing.T) {
+	tests := []struct {
+		name    string
+		metros  string
+		want    []Row
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			metros: "file:testdata/metros.csv",
+			want: []Row{
+				{CountryCode: "US", IATA: "lga", ICAO: "klga", Airport: "LaGuardia Airport", Latitude: 40.7769, Longitude: -73.8740},
+				{CountryCode: "US", IATA: "xyz", Latitude: 41, Longitude: -74},
+			},
+		},
+		{
+			name:    "error-coordinates",
+			metros:  "file:testdata/bad-metros.csv",
+			wantErr: true,
+		},
+		{
+			name:    "error-no-metros",
+			metros:  "file:testdata/bad.csv",
+			wantErr: true,
+		},
+		{
+			name:    "error-file",
+			metros:  "file:testdata/does-not-exist.csv",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse("file:testdata/input.csv")
+			testingx.Must(t, err, "failed to parse file")
+			c, err := New(context.Background(), u)
+			testingx.Must(t, err, "failed to create new client")
+			m, err := url.Parse(tt.metros)
+			testingx.Must(t, err, "failed to parse file %s", tt.metros)
+			testingx.Must(t, c.SetMetros(context.Background(), m), "failed to set metros")
+
+			err = c.Load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(c.rows, tt.want) {
+				t.Errorf("Client.Load() rows = %v, want %v", c.rows, tt.want)
+			}
+			// Airports that are not approved metros are never found.
+			if _, err := c.Find("jfk"); err == nil {
+				t.Errorf("Client.Find(jfk) found an unapproved metro")
+			}
+			if got, err := c.Lookup("US", 40.6, -73.8); err != nil || got != "lga" {
+				t.Errorf("Client.Lookup() = %q, %v, want lga", got, err)
+			}
+		})
+	}
+}
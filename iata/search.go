@@ -0,0 +1,84 @@
+package iata
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Search returns up to n airports whose IATA code, ICAO code, or name match
+// the given query, best matches first. Names match when every word of the
+// query starts a word of the name, ignoring case and punctuation, e.g.
+// "la guardia" matches "LaGuardia Airport" as well as "La Guardia".
+func (c *Client) Search(query string, n int) []Row {
+	c.mu.Lock()
+	rows := c.rows
+	c.mu.Unlock()
+	words := nameWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+	type match struct {
+		row   Row
+		score int
+	}
+	matches := []match{}
+	for _, r := range rows {
+		if score := matchScore(r, query, words); score > 0 {
+			matches = append(matches, match{row: r, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].row.Airport < matches[j].row.Airport
+	})
+	if n < len(matches) {
+		matches = matches[:n]
+	}
+	found := make([]Row, len(matches))
+	for i, m := range matches {
+		found[i] = m.row
+	}
+	return found
+}
+
+// matchScore scores how well the row matches the query: 4 for a code, 3 for
+// the whole name, 2 for the start of the name, 1 for words of the name, and 0
+// for no match.
+func matchScore(r Row, query string, words []string) int {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == r.IATA || (r.ICAO != "" && q == r.ICAO) {
+		return 4
+	}
+	name := nameWords(r.Airport)
+	joined, full := strings.Join(words, ""), strings.Join(name, "")
+	switch {
+	case joined == full:
+		return 3
+	case strings.HasPrefix(full, joined):
+		return 2
+	}
+	for _, w := range words {
+		found := false
+		for _, nw := range name {
+			if strings.HasPrefix(nw, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	return 1
+}
+
+// nameWords returns the lowercase words of s, split at anything other than
+// letters and digits.
+func nameWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
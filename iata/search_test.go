@@ -0,0 +1,44 @@
+package iata
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/testingx"
+)
+
+func TestClient_Search(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		n     int
+		want  []string
+	}{
+		{name: "iata", query: "LGA", n: 5, want: []string{"lga"}},
+		{name: "icao", query: "kjfk", n: 5, want: []string{"jfk"}},
+		{name: "name", query: "La Guardia", n: 5, want: []string{"lga"}},
+		{name: "word", query: "kennedy", n: 5, want: []string{"jfk"}},
+		{name: "several", query: "airport", n: 5, want: []string{"jfk", "lga"}},
+		{name: "limit", query: "airport", n: 1, want: []string{"jfk"}},
+		{name: "none", query: "boston", n: 5, want: []string{}},
+		{name: "empty", query: " - ", n: 5, want: []string{}},
+	}
+	u, err := url.Parse("file:testdata/input.csv")
+	testingx.Must(t, err, "failed to parse file")
+	c, err := New(context.Background(), u)
+	testingx.Must(t, err, "failed to create new client")
+	testingx.Must(t, c.Load(context.Background()), "failed to load dataset")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, r := range c.Search(tt.query, tt.n) {
+				got = append(got, r.IATA)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Client.Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
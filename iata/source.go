@@ -0,0 +1,70 @@
+package iata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/m-lab/go/content"
+)
+
+// newSource returns a provider for the dataset at the given URL. HTTP(S)
+// datasets are fetched conditionally, so that unchanged data is not
+// downloaded again. Other URLs are supported by m-lab/go/content.
+func newSource(ctx context.Context, u *url.URL) (content.Provider, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return &httpSource{
+			u:      u.String(),
+			client: &http.Client{Timeout: time.Minute},
+		}, nil
+	}
+	return content.FromURL(ctx, u)
+}
+
+// httpSource is a content.Provider of public HTTP(S) URLs that sends the
+// ETag and Last-Modified validators of the previous response, and returns
+// content.ErrNoChange when the server reports the data as not modified.
+type httpSource struct {
+	u            string
+	client       *http.Client
+	etag         string
+	lastModified string
+}
+
+// Get returns the data at the URL, or content.ErrNoChange.
+func (h *httpSource) Get(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.etag != "" {
+		req.Header.Set("If-None-Match", h.etag)
+	}
+	if h.lastModified != "" {
+		req.Header.Set("If-Modified-Since", h.lastModified)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, content.ErrNoChange
+	default:
+		return nil, fmt.Errorf("failed to get %s: %s", h.u, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Only remember the validators of complete responses.
+	h.etag = resp.Header.Get("ETag")
+	h.lastModified = resp.Header.Get("Last-Modified")
+	return b, nil
+}
@@ -0,0 +1,72 @@
+package iata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/m-lab/go/testingx"
+)
+
+const (
+	header = `"country_code","region_name","iata","icao","airport","latitude","longitude"` + "\n"
+	lga    = `"US","New York","LGA","KLGA","LaGuardia Airport","40.775","-73.875"` + "\n"
+	jfk    = `"US","New York","JFK","KJFK","John F. Kennedy International Airport","40.6397","-73.7789"` + "\n"
+	ewr    = `"US","New Jersey","EWR","KEWR","Newark Liberty International Airport","40.6925","-74.1687"` + "\n"
+)
+
+func TestClient_Load_refresh(t *testing.T) {
+	data, etag := header+lga+jfk+ewr, `"v1"`
+	status := http.StatusOK
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if status != http.StatusOK {
+			rw.WriteHeader(status)
+			return
+		}
+		if req.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetches++
+		rw.Header().Set("ETag", etag)
+		rw.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL + "/iata.csv")
+	testingx.Must(t, err, "failed to parse url")
+	c, err := New(context.Background(), u)
+	testingx.Must(t, err, "failed to create new client")
+	testingx.Must(t, c.Load(context.Background()), "failed to load dataset")
+
+	// Unchanged data is not downloaded again.
+	testingx.Must(t, c.Load(context.Background()), "failed to reload unchanged dataset")
+	if fetches != 1 || c.Len() != 3 {
+		t.Errorf("Client.Load() fetched %d times with %d rows, want 1 and 3", fetches, c.Len())
+	}
+
+	// Datasets that shrank too much are rejected, and the old rows are kept.
+	data, etag = header+lga, `"v2"`
+	if err := c.Load(context.Background()); !errors.Is(err, ErrShrunk) {
+		t.Errorf("Client.Load() error = %v, want %v", err, ErrShrunk)
+	}
+	if _, err := c.Find("ewr"); err != nil {
+		t.Errorf("Client.Find() error = %v after rejected reload", err)
+	}
+
+	// The same dataset is accepted once the check is disabled.
+	c.MaxShrink = 0
+	testingx.Must(t, c.Load(context.Background()), "failed to load shrunk dataset")
+	if _, err := c.Find("ewr"); err == nil || len(c.rows) != 1 {
+		t.Errorf("Client.Load() kept %d rows, want 1", len(c.rows))
+	}
+
+	status = http.StatusInternalServerError
+	if err := c.Load(context.Background()); err == nil {
+		t.Errorf("Client.Load() error = nil, want error for status %d", status)
+	}
+}
@@ -2,6 +2,11 @@ package adminx
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 
 	"cloud.google.com/go/apikeys/apiv2/apikeyspb"
 	"github.com/googleapis/gax-go"
@@ -11,13 +16,21 @@ import (
 type KeysClient interface {
 	GetKeyString(ctx context.Context, req *apikeyspb.GetKeyStringRequest, opts ...gax.CallOption) (*apikeyspb.GetKeyStringResponse, error)
 	CreateKey(ctx context.Context, req *apikeyspb.CreateKeyRequest, opts ...gax.CallOption) (*apikeyspb.Key, error)
+	LookupKey(ctx context.Context, req *apikeyspb.LookupKeyRequest, opts ...gax.CallOption) (*apikeyspb.LookupKeyResponse, error)
+	DeleteKey(ctx context.Context, req *apikeyspb.DeleteKeyRequest, opts ...gax.CallOption) error
 }
 
+// ErrUnknownKey is returned when an API key was not allocated for an org.
+var ErrUnknownKey = errors.New("api key does not belong to an organization")
+
 // APIKeys maintains state for allcoating API keys.
 type APIKeys struct {
 	locateProject string
 	client        KeysClient
 	namer         *Namer
+	// numberParent is the API key parent of the project by number, e.g.
+	// projects/123456789/locations/global.
+	numberParent string
 }
 
 // NewAPIKeys creates a new APIKeys instance for allocating API keys.
@@ -29,6 +42,18 @@ func NewAPIKeys(locateProj string, c KeysClient, n *Namer) *APIKeys {
 	}
 }
 
+// LoadProjectNumber reads the number of the project from crm. Key names
+// returned by LookupKey use the project number, so FindKey only accepts keys
+// of other projects until the number is loaded.
+func (a *APIKeys) LoadProjectNumber(ctx context.Context, crm CRM) error {
+	p, err := crm.GetProject(ctx)
+	if err != nil {
+		return err
+	}
+	a.numberParent = fmt.Sprintf("projects/%d/locations/global", p.ProjectNumber)
+	return nil
+}
+
 // CreateKey returns an API key restricted to the Locate and Autojoin APIs for use by the named org.
 // CreateKey can be called multiple times safely.
 func (a *APIKeys) CreateKey(ctx context.Context, org string) (string, error) {
@@ -39,26 +64,93 @@ func (a *APIKeys) CreateKey(ctx context.Context, org string) (string, error) {
 	if errIsNotFound(err) {
 		// If the key does not yet exist, create it.
 		// While not documented, it appears to be safe to run this operation multiple times.
-		key, err := a.client.CreateKey(ctx, &apikeyspb.CreateKeyRequest{
-			Parent: a.namer.GetAPIKeyParent(),
-			Key: &apikeyspb.Key{
-				DisplayName: a.namer.GetAPIKeyID(org),
-				Restrictions: &apikeyspb.Restrictions{
-					ApiTargets: []*apikeyspb.ApiTarget{
-						{Service: "autojoin-dot-" + a.namer.Project + ".appspot.com"},
-						{Service: "locate-dot-" + a.locateProject + ".appspot.com"},
-					},
-				},
-			},
-			KeyId: a.namer.GetAPIKeyID(org),
-		})
-		if err != nil {
-			return "", err
-		}
-		return key.KeyString, nil
+		return a.createKey(ctx, a.namer.GetAPIKeyID(org))
+	}
+	if err != nil {
+		return "", err
 	}
+	return get.KeyString, nil
+}
+
+// GetKey returns the API key created by CreateKey for the named org.
+func (a *APIKeys) GetKey(ctx context.Context, org string) (string, error) {
+	get, err := a.client.GetKeyString(ctx, &apikeyspb.GetKeyStringRequest{
+		Name: a.namer.GetAPIKeyName(org),
+	})
 	if err != nil {
 		return "", err
 	}
 	return get.KeyString, nil
 }
+
+// AddKey creates a new API key for the named org, in addition to the key
+// returned by CreateKey, and returns its ID and key string. The ID ends with a
+// random suffix, so it does not identify the org.
+func (a *APIKeys) AddKey(ctx context.Context, org string) (id, key string, err error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id = a.namer.GetAPIKeyID(org) + "-" + hex.EncodeToString(b)
+	key, err = a.createKey(ctx, id)
+	return id, key, err
+}
+
+// DeleteKey deletes the API key with the given ID.
+func (a *APIKeys) DeleteKey(ctx context.Context, id string) error {
+	return a.client.DeleteKey(ctx, &apikeyspb.DeleteKeyRequest{
+		Name: a.namer.GetAPIKeyParent() + "/keys/" + id,
+	})
+}
+
+// createKey creates an API key restricted to the Locate and Autojoin APIs.
+func (a *APIKeys) createKey(ctx context.Context, id string) (string, error) {
+	key, err := a.client.CreateKey(ctx, &apikeyspb.CreateKeyRequest{
+		Parent: a.namer.GetAPIKeyParent(),
+		Key: &apikeyspb.Key{
+			DisplayName: id,
+			Restrictions: &apikeyspb.Restrictions{
+				ApiTargets: []*apikeyspb.ApiTarget{
+					{Service: "autojoin-dot-" + a.namer.Project + ".appspot.com"},
+					{Service: "locate-dot-" + a.locateProject + ".appspot.com"},
+				},
+			},
+		},
+		KeyId: id,
+	})
+	if err != nil {
+		return "", err
+	}
+	return key.KeyString, nil
+}
+
+// GetOrganization returns the name of the org that owns the given API key
+// string. Keys that were not created by CreateKey return ErrUnknownKey.
+func (a *APIKeys) GetOrganization(ctx context.Context, key string) (string, error) {
+	_, org, err := a.FindKey(ctx, key)
+	return org, err
+}
+
+// FindKey returns the ID and the org of the given API key string. Keys that
+// were not created by CreateKey in this project return ErrUnknownKey.
+func (a *APIKeys) FindKey(ctx context.Context, key string) (id, org string, err error) {
+	resp, err := a.client.LookupKey(ctx, &apikeyspb.LookupKeyRequest{KeyString: key})
+	if err != nil {
+		return "", "", err
+	}
+	// LookupKey finds keys of every project, so keys of other projects with
+	// the same ID must be rejected. Key names use the project number, e.g.
+	// projects/123456789/locations/global/keys/autojoin-key-foo.
+	if resp.Parent != a.namer.GetAPIKeyParent() && (a.numberParent == "" || resp.Parent != a.numberParent) {
+		return "", "", ErrUnknownKey
+	}
+	id, ok := strings.CutPrefix(resp.Name, resp.Parent+"/keys/")
+	if !ok {
+		return "", "", ErrUnknownKey
+	}
+	org, ok = strings.CutPrefix(id, a.namer.GetAPIKeyID(""))
+	if !ok || org == "" {
+		return "", "", ErrUnknownKey
+	}
+	return id, org, nil
+}
@@ -2,11 +2,14 @@ package adminx
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"cloud.google.com/go/apikeys/apiv2/apikeyspb"
 	"github.com/googleapis/gax-go"
+	"google.golang.org/api/cloudresourcemanager/v1"
 )
 
 type fakeKeys struct {
@@ -14,15 +17,30 @@ type fakeKeys struct {
 	getKeyErr    error
 	createKey    *apikeyspb.Key
 	createKeyErr error
+	lookupKey    *apikeyspb.LookupKeyResponse
+	lookupKeyErr error
+	deleteKeyErr error
+	created      *apikeyspb.CreateKeyRequest
+	deleted      string
 }
 
 func (f *fakeKeys) GetKeyString(ctx context.Context, req *apikeyspb.GetKeyStringRequest, opts ...gax.CallOption) (*apikeyspb.GetKeyStringResponse, error) {
 	return f.getKey, f.getKeyErr
 }
 func (f *fakeKeys) CreateKey(ctx context.Context, req *apikeyspb.CreateKeyRequest, opts ...gax.CallOption) (*apikeyspb.Key, error) {
+	f.created = req
 	return f.createKey, f.createKeyErr
 }
 
+func (f *fakeKeys) DeleteKey(ctx context.Context, req *apikeyspb.DeleteKeyRequest, opts ...gax.CallOption) error {
+	f.deleted = req.Name
+	return f.deleteKeyErr
+}
+
+func (f *fakeKeys) LookupKey(ctx context.Context, req *apikeyspb.LookupKeyRequest, opts ...gax.CallOption) (*apikeyspb.LookupKeyResponse, error) {
+	return f.lookupKey, f.lookupKeyErr
+}
+
 func TestAPIKeys_CreateKey(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -90,3 +108,172 @@ func TestAPIKeys_CreateKey(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIKeys_GetOrganization(t *testing.T) {
+	tests := []struct {
+		name     string
+		fakeKeys *fakeKeys
+		want     string
+		wantID   string
+		wantErr  error
+	}{
+		{
+			name: "success",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/123456789/locations/global",
+					Name:   "projects/123456789/locations/global/keys/autojoin-key-foo",
+				},
+			},
+			want:   "foo",
+			wantID: "autojoin-key-foo",
+		},
+		{
+			name: "success-project-id",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/mlab-foo/locations/global",
+					Name:   "projects/mlab-foo/locations/global/keys/autojoin-key-foo",
+				},
+			},
+			want:   "foo",
+			wantID: "autojoin-key-foo",
+		},
+		{
+			name: "error-unknown-key",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/123456789/locations/global",
+					Name:   "projects/123456789/locations/global/keys/other-key",
+				},
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "error-empty-org",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/123456789/locations/global",
+					Name:   "projects/123456789/locations/global/keys/autojoin-key-",
+				},
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "error-bad-parent",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/123456789/locations/us-east1",
+					Name:   "projects/123456789/locations/us-east1/keys/autojoin-key-foo",
+				},
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "error-other-project",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/987654321/locations/global",
+					Name:   "projects/987654321/locations/global/keys/autojoin-key-foo",
+				},
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "error-other-project-id",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/mlab-evil/locations/global",
+					Name:   "projects/mlab-evil/locations/global/keys/autojoin-key-foo",
+				},
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "error-name-outside-parent",
+			fakeKeys: &fakeKeys{
+				lookupKey: &apikeyspb.LookupKeyResponse{
+					Parent: "projects/123456789/locations/global",
+					Name:   "projects/987654321/locations/global/keys/autojoin-key-foo",
+				},
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "error-lookup",
+			fakeKeys: &fakeKeys{
+				lookupKeyErr: createNotFoundErr(),
+			},
+			wantErr: createNotFoundErr(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAPIKeys("mlab-ns", tt.fakeKeys, NewNamer("mlab-foo"))
+			crm := &fakeCRM{project: &cloudresourcemanager.Project{ProjectNumber: 123456789}}
+			if err := a.LoadProjectNumber(context.Background(), crm); err != nil {
+				t.Fatalf("LoadProjectNumber() returned error: %v", err)
+			}
+			got, err := a.GetOrganization(context.Background(), "12345")
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("GetOrganization() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetOrganization() = %q, want %q", got, tt.want)
+			}
+			id, _, _ := a.FindKey(context.Background(), "12345")
+			if id != tt.wantID {
+				t.Errorf("FindKey() = %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAPIKeys_LoadProjectNumber(t *testing.T) {
+	f := &fakeKeys{
+		lookupKey: &apikeyspb.LookupKeyResponse{
+			Parent: "projects/123456789/locations/global",
+			Name:   "projects/123456789/locations/global/keys/autojoin-key-foo",
+		},
+	}
+	a := NewAPIKeys("mlab-ns", f, NewNamer("mlab-foo"))
+
+	// Keys named by number are unknown until the project number is loaded.
+	if _, err := a.GetOrganization(context.Background(), "12345"); err != ErrUnknownKey {
+		t.Errorf("GetOrganization() error = %v, want %v", err, ErrUnknownKey)
+	}
+	crm := &fakeCRM{projectErr: errors.New("fake project error")}
+	if err := a.LoadProjectNumber(context.Background(), crm); err != crm.projectErr {
+		t.Errorf("LoadProjectNumber() error = %v, want %v", err, crm.projectErr)
+	}
+}
+
+func TestAPIKeys_AddKey(t *testing.T) {
+	f := &fakeKeys{createKey: &apikeyspb.Key{KeyString: "12345"}}
+	a := NewAPIKeys("mlab-ns", f, NewNamer("mlab-foo"))
+
+	id, key, err := a.AddKey(context.Background(), "foo")
+	if err != nil || key != "12345" {
+		t.Fatalf("AddKey() = %q, %q, %v; want key 12345", id, key, err)
+	}
+	if !strings.HasPrefix(id, "autojoin-key-foo-") || f.created.KeyId != id {
+		t.Errorf("AddKey() created wrong key id; got %q, %q", id, f.created.KeyId)
+	}
+
+	f.createKeyErr = errors.New("fake create error")
+	if _, _, err := a.AddKey(context.Background(), "foo"); err != f.createKeyErr {
+		t.Errorf("AddKey() returned wrong error; got %v, want %v", err, f.createKeyErr)
+	}
+}
+
+func TestAPIKeys_DeleteKey(t *testing.T) {
+	f := &fakeKeys{}
+	a := NewAPIKeys("mlab-ns", f, NewNamer("mlab-foo"))
+
+	if err := a.DeleteKey(context.Background(), "autojoin-key-foo-1234"); err != nil {
+		t.Fatalf("DeleteKey() returned err: %v", err)
+	}
+	if f.deleted != "projects/mlab-foo/locations/global/keys/autojoin-key-foo-1234" {
+		t.Errorf("DeleteKey() deleted wrong key; got %q", f.deleted)
+	}
+}
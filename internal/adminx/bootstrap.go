@@ -0,0 +1,106 @@
+package adminx
+
+import (
+	"context"
+
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/dns/v1"
+)
+
+var (
+	// RequiredServices are the Google Cloud APIs used by the Autojoin API
+	// and orgadm, which must be enabled in the project.
+	RequiredServices = []string{
+		"apikeys.googleapis.com",
+		"cloudresourcemanager.googleapis.com",
+		"datastore.googleapis.com",
+		"dns.googleapis.com",
+		"iam.googleapis.com",
+		"secretmanager.googleapis.com",
+	}
+	// RequiredPermissions are the project permissions needed to create
+	// organizations.
+	RequiredPermissions = []string{
+		"apikeys.keys.create",
+		"datastore.entities.create",
+		"dns.changes.create",
+		"dns.managedZones.create",
+		"iam.serviceAccountKeys.create",
+		"iam.serviceAccounts.create",
+		"resourcemanager.projects.setIamPolicy",
+		"secretmanager.secrets.create",
+		"secretmanager.versions.add",
+	}
+)
+
+// ServiceUsage is a simplified interface to the Google Cloud Service Usage API.
+type ServiceUsage interface {
+	EnabledServices(ctx context.Context) ([]string, error)
+}
+
+// Bootstrap creates the resources shared by all organizations of a project.
+type Bootstrap struct {
+	Project string
+	// Domain is the base domain of the project zone, e.g.
+	// "measurement-lab.org". NewBootstrap sets dnsname.DefaultDomain.
+	Domain string
+	crm    CRM
+	su     ServiceUsage
+	dns    DNS
+}
+
+// NewBootstrap creates a new Bootstrap instance.
+func NewBootstrap(project string, crm CRM, su ServiceUsage, d DNS) *Bootstrap {
+	return &Bootstrap{
+		Project: project,
+		Domain:  dnsname.DefaultDomain,
+		crm:     crm,
+		su:      su,
+		dns:     d,
+	}
+}
+
+// MissingServices returns the RequiredServices that are not enabled.
+func (b *Bootstrap) MissingServices(ctx context.Context) ([]string, error) {
+	enabled, err := b.su.EnabledServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return missing(RequiredServices, enabled), nil
+}
+
+// MissingPermissions returns the RequiredPermissions the caller does not
+// have on the project.
+func (b *Bootstrap) MissingPermissions(ctx context.Context) ([]string, error) {
+	granted, err := b.crm.TestIamPermissions(ctx, RequiredPermissions)
+	if err != nil {
+		return nil, err
+	}
+	return missing(RequiredPermissions, granted), nil
+}
+
+// RegisterProjectZone guarantees that the project zone, the parent of all
+// organization zones, exists. The zone is not delegated from its parent,
+// which is managed outside of the project.
+func (b *Bootstrap) RegisterProjectZone(ctx context.Context) (*dns.ManagedZone, error) {
+	return b.dns.RegisterZone(ctx, &dns.ManagedZone{
+		Description: "Autojoin organization zones of project: " + b.Project,
+		Name:        dnsname.ProjectZone(b.Project, b.Domain),
+		DnsName:     dnsname.ProjectDNS(b.Project, b.Domain),
+		DnssecConfig: &dns.ManagedZoneDnsSecConfig{
+			State: "on",
+		},
+	})
+}
+
+// missing returns the values of want that are not in have.
+func missing(want, have []string) []string {
+	result := []string{}
+	for _, w := range want {
+		if !slices.Contains(have, w) {
+			result = append(result, w)
+		}
+	}
+	return result
+}
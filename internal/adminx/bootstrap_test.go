@@ -0,0 +1,105 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/dns/v1"
+)
+
+type fakeServiceUsage struct {
+	enabled []string
+	err     error
+}
+
+func (f *fakeServiceUsage) EnabledServices(ctx context.Context) ([]string, error) {
+	return f.enabled, f.err
+}
+
+func TestBootstrap_MissingServices(t *testing.T) {
+	tests := []struct {
+		name    string
+		su      *fakeServiceUsage
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			su:   &fakeServiceUsage{enabled: append([]string{"compute.googleapis.com"}, RequiredServices...)},
+			want: []string{},
+		},
+		{
+			name: "success-missing",
+			su:   &fakeServiceUsage{enabled: RequiredServices[1:]},
+			want: RequiredServices[:1],
+		},
+		{
+			name:    "error",
+			su:      &fakeServiceUsage{err: fmt.Errorf("fake error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBootstrap("mlab-foo", &fakeCRM{}, tt.su, &fakeDNS{})
+			got, err := b.MissingServices(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Bootstrap.MissingServices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Bootstrap.MissingServices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootstrap_MissingPermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		crm     *fakeCRM
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			crm:  &fakeCRM{granted: RequiredPermissions},
+			want: []string{},
+		},
+		{
+			name: "success-missing",
+			crm:  &fakeCRM{granted: RequiredPermissions[:1]},
+			want: RequiredPermissions[1:],
+		},
+		{
+			name:    "error",
+			crm:     &fakeCRM{grantedErr: fmt.Errorf("fake error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBootstrap("mlab-foo", tt.crm, &fakeServiceUsage{}, &fakeDNS{})
+			got, err := b.MissingPermissions(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Bootstrap.MissingPermissions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Bootstrap.MissingPermissions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBootstrap_RegisterProjectZone(t *testing.T) {
+	d := &fakeDNS{regZone: &dns.ManagedZone{Name: "autojoin-foo-measurement-lab-org"}}
+	b := NewBootstrap("mlab-foo", &fakeCRM{}, &fakeServiceUsage{}, d)
+	z, err := b.RegisterProjectZone(context.Background())
+	if err != nil {
+		t.Fatalf("Bootstrap.RegisterProjectZone() error = %v", err)
+	}
+	if z.Name != "autojoin-foo-measurement-lab-org" || d.regCalls != 1 {
+		t.Errorf("Bootstrap.RegisterProjectZone() = %v, calls %d", z, d.regCalls)
+	}
+}
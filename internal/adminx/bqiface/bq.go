@@ -0,0 +1,33 @@
+package bqiface
+
+import (
+	"context"
+
+	"google.golang.org/api/bigquery/v2"
+)
+
+type bqImpl struct {
+	bq      *bigquery.Service
+	Project string
+}
+
+// NewBigQuery creates a new BigQuery implementation for wrapping the
+// bigquery.Service of the given project.
+func NewBigQuery(project string, bq *bigquery.Service) *bqImpl {
+	return &bqImpl{
+		Project: project,
+		bq:      bq,
+	}
+}
+
+func (b *bqImpl) GetDataset(ctx context.Context, datasetID string) (*bigquery.Dataset, error) {
+	return b.bq.Datasets.Get(b.Project, datasetID).Context(ctx).Do()
+}
+
+func (b *bqImpl) CreateDataset(ctx context.Context, ds *bigquery.Dataset) (*bigquery.Dataset, error) {
+	return b.bq.Datasets.Insert(b.Project, ds).Context(ctx).Do()
+}
+
+func (b *bqImpl) PatchDataset(ctx context.Context, datasetID string, ds *bigquery.Dataset) (*bigquery.Dataset, error) {
+	return b.bq.Datasets.Patch(b.Project, datasetID, ds).Context(ctx).Do()
+}
@@ -27,3 +27,16 @@ func (c *crmImpl) SetIamPolicy(ctx context.Context, req *cloudresourcemanager.Se
 	_, err := c.crm.Projects.SetIamPolicy(c.Project, req).Context(ctx).Do()
 	return err
 }
+
+func (c *crmImpl) GetProject(ctx context.Context) (*cloudresourcemanager.Project, error) {
+	return c.crm.Projects.Get(c.Project).Context(ctx).Do()
+}
+
+func (c *crmImpl) TestIamPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	req := &cloudresourcemanager.TestIamPermissionsRequest{Permissions: permissions}
+	resp, err := c.crm.Projects.TestIamPermissions(c.Project, req).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Permissions, nil
+}
@@ -0,0 +1,84 @@
+package adminx
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+const (
+	// datasetEditor allows the org service account to write tables of the
+	// org dataset.
+	datasetEditor = "roles/bigquery.dataEditor"
+	// jobUser allows the org service account to run load and query jobs.
+	// Jobs are project resources, so the role is granted on the project.
+	jobUser = "roles/bigquery.jobUser"
+)
+
+// BigQuery is a simplified interface to the BigQuery API.
+type BigQuery interface {
+	GetDataset(ctx context.Context, datasetID string) (*bigquery.Dataset, error)
+	CreateDataset(ctx context.Context, ds *bigquery.Dataset) (*bigquery.Dataset, error)
+	PatchDataset(ctx context.Context, datasetID string, ds *bigquery.Dataset) (*bigquery.Dataset, error)
+}
+
+// WithDataset creates a BigQuery dataset for organizations set up by o, in
+// the given location, e.g. "US".
+func (o *Org) WithDataset(bq BigQuery, location string) *Org {
+	o.bq = bq
+	o.location = location
+	return o
+}
+
+// SetupDataset creates the BigQuery dataset of org, and grants the org
+// service account dataEditor on the dataset and jobUser on the project.
+// SetupDataset may be run again to complete a failed setup.
+func (o *Org) SetupDataset(ctx context.Context, org string, account *iam.ServiceAccount) error {
+	id := o.sam.Namer.GetDatasetID(org)
+	ds, err := o.bq.GetDataset(ctx, id)
+	switch {
+	case errIsNotFound(err):
+		// Create the dataset without access entries, so it receives the
+		// default entries of the project.
+		ds, err = o.bq.CreateDataset(ctx, &bigquery.Dataset{
+			DatasetReference: &bigquery.DatasetReference{
+				ProjectId: o.Project,
+				DatasetId: id,
+			},
+			Description: "Autojoin data from org: " + org,
+			Location:    o.location,
+		})
+		if err != nil {
+			log.Println("failed to create dataset:", id, err)
+			return err
+		}
+	case err != nil:
+		log.Println("get dataset", err)
+		return err
+	}
+
+	found := false
+	for _, a := range ds.Access {
+		if a.Role == datasetEditor && a.UserByEmail == account.Email {
+			found = true
+		}
+	}
+	if !found {
+		access := append(ds.Access, &bigquery.DatasetAccess{
+			Role:        datasetEditor,
+			UserByEmail: account.Email,
+		})
+		_, err = o.bq.PatchDataset(ctx, id, &bigquery.Dataset{Access: access})
+		if err != nil {
+			log.Println("failed to grant dataset access:", id, err)
+			return err
+		}
+	}
+	return o.addBindings(ctx, &cloudresourcemanager.Binding{
+		Members: []string{"serviceAccount:" + account.Email},
+		Role:    jobUser,
+	})
+}
@@ -0,0 +1,135 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+type fakeBigQuery struct {
+	dataset   *bigquery.Dataset
+	getErr    error
+	createErr error
+	patchErr  error
+	created   *bigquery.Dataset
+	patched   *bigquery.Dataset
+}
+
+func (f *fakeBigQuery) GetDataset(ctx context.Context, datasetID string) (*bigquery.Dataset, error) {
+	return f.dataset, f.getErr
+}
+
+func (f *fakeBigQuery) CreateDataset(ctx context.Context, ds *bigquery.Dataset) (*bigquery.Dataset, error) {
+	f.created = ds
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	return ds, nil
+}
+
+func (f *fakeBigQuery) PatchDataset(ctx context.Context, datasetID string, ds *bigquery.Dataset) (*bigquery.Dataset, error) {
+	f.patched = ds
+	return ds, f.patchErr
+}
+
+func TestOrg_SetupDataset(t *testing.T) {
+	account := &iam.ServiceAccount{Email: "autonode-foo@mlab-foo.iam.gserviceaccount.com"}
+	editor := &bigquery.DatasetAccess{Role: datasetEditor, UserByEmail: account.Email}
+	jobUserBinding := &cloudresourcemanager.Binding{
+		Members: []string{"serviceAccount:" + account.Email},
+		Role:    jobUser,
+	}
+	tests := []struct {
+		name        string
+		bq          *fakeBigQuery
+		crm         *fakeCRM
+		wantCreated bool
+		wantPatched bool
+		wantPolicy  bool
+		wantErr     bool
+	}{
+		{
+			name:        "success-create",
+			bq:          &fakeBigQuery{getErr: createNotFoundErr()},
+			crm:         &fakeCRM{getPolicy: &cloudresourcemanager.Policy{}},
+			wantCreated: true,
+			wantPatched: true,
+			wantPolicy:  true,
+		},
+		{
+			name: "success-exists",
+			bq:   &fakeBigQuery{dataset: &bigquery.Dataset{Access: []*bigquery.DatasetAccess{editor}}},
+			crm: &fakeCRM{getPolicy: &cloudresourcemanager.Policy{
+				Bindings: []*cloudresourcemanager.Binding{jobUserBinding},
+			}},
+		},
+		{
+			name:        "success-repair-access",
+			bq:          &fakeBigQuery{dataset: &bigquery.Dataset{}},
+			crm:         &fakeCRM{getPolicy: &cloudresourcemanager.Policy{}},
+			wantPatched: true,
+			wantPolicy:  true,
+		},
+		{
+			name:    "error-get",
+			bq:      &fakeBigQuery{getErr: fmt.Errorf("fake get error")},
+			crm:     &fakeCRM{},
+			wantErr: true,
+		},
+		{
+			name:        "error-create",
+			bq:          &fakeBigQuery{getErr: createNotFoundErr(), createErr: fmt.Errorf("fake create error")},
+			crm:         &fakeCRM{},
+			wantCreated: true,
+			wantErr:     true,
+		},
+		{
+			name:        "error-patch",
+			bq:          &fakeBigQuery{dataset: &bigquery.Dataset{}, patchErr: fmt.Errorf("fake patch error")},
+			crm:         &fakeCRM{},
+			wantPatched: true,
+			wantErr:     true,
+		},
+		{
+			name:        "error-policy",
+			bq:          &fakeBigQuery{dataset: &bigquery.Dataset{}},
+			crm:         &fakeCRM{getPolicyErr: fmt.Errorf("fake policy error")},
+			wantPatched: true,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			sam := NewServiceAccountsManager(&fakeIAMService{}, n)
+			o := NewOrg("mlab-foo", tt.crm, sam, nil, nil, nil, false).WithDataset(tt.bq, "US")
+			err := o.SetupDataset(context.Background(), "foo", account)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.SetupDataset() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (tt.bq.created != nil) != tt.wantCreated {
+				t.Errorf("Org.SetupDataset() created = %v, want %t", tt.bq.created, tt.wantCreated)
+			}
+			if tt.wantCreated && tt.bq.created.DatasetReference.DatasetId != "autojoin_foo" {
+				t.Errorf("Org.SetupDataset() created wrong dataset: %v", tt.bq.created.DatasetReference)
+			}
+			if (tt.bq.patched != nil) != tt.wantPatched {
+				t.Errorf("Org.SetupDataset() patched = %v, want %t", tt.bq.patched, tt.wantPatched)
+			}
+			if tt.wantPatched && !tt.wantErr {
+				l := tt.bq.patched.Access
+				if len(l) == 0 || !reflect.DeepEqual(l[len(l)-1], editor) {
+					t.Errorf("Org.SetupDataset() access = %v, want %v", l, editor)
+				}
+			}
+			if (tt.crm.policy != nil) != tt.wantPolicy {
+				t.Errorf("Org.SetupDataset() set policy = %v, want %t", tt.crm.policy, tt.wantPolicy)
+			}
+		})
+	}
+}
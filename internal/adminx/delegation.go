@@ -0,0 +1,40 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"google.golang.org/api/dns/v1"
+)
+
+// WithNameServers delegates the zones of organizations set up by o to the
+// given name servers, e.g. run by a partner, instead of creating Cloud DNS
+// zones. Only the zone split is created in the project zone.
+func (o *Org) WithNameServers(servers []string) *Org {
+	o.nameServers = servers
+	return o
+}
+
+// checkDelegation reports whether the zone split of the delegated zone exists
+// and delegates to the name servers of o.
+func (o *Org) checkDelegation(ctx context.Context, zone *dns.ManagedZone) (Drift, error) {
+	d := Drift{Resource: ResourceZoneSplit, Name: zone.DnsName}
+	rr, err := o.dns.GetZoneSplit(ctx, zone)
+	switch {
+	case errIsNotFound(err):
+		d.Missing = true
+		return d, nil
+	case err != nil:
+		return d, fmt.Errorf("check %s %s: %w", ResourceZoneSplit, zone.DnsName, err)
+	}
+	ns, err := dnsx.NameServers(o.nameServers, zone.DnsName)
+	if err != nil {
+		return d, err
+	}
+	if !dnsx.MatchesNameServers(rr, ns) {
+		d.Missing, d.Detail = true, "delegated to "+strings.Join(rr.Rrdatas, ", ")
+	}
+	return d, nil
+}
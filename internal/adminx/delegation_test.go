@@ -0,0 +1,119 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+var testNameServers = []string{"ns1.foo.example", "ns2.foo.example"}
+
+func TestOrg_WithNameServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		dns     *fakeDNS
+		wantErr bool
+	}{
+		{
+			name: "success",
+			dns:  &fakeDNS{},
+		},
+		{
+			name:    "error-delegation",
+			dns:     &fakeDNS{delegErr: fmt.Errorf("fake delegation error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOrg("mlab-foo", &fakeCRM{}, nil, nil, tt.dns, &fakeAPIKeys{}, false).WithNameServers(testNameServers)
+			err := o.RegisterDNS(context.Background(), "foo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.RegisterDNS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.dns.regCalls != 0 {
+				t.Errorf("Org.RegisterDNS() registered zone %d times, want 0", tt.dns.regCalls)
+			}
+			if !reflect.DeepEqual(tt.dns.delegated, testNameServers) {
+				t.Errorf("Org.RegisterDNS() delegated to %v, want %v", tt.dns.delegated, testNameServers)
+			}
+		})
+	}
+}
+
+func TestOrg_CheckDelegation(t *testing.T) {
+	account := &iam.ServiceAccount{
+		Name:  "projects/mlab-foo/serviceAccounts/autonode-foo@mlab-foo.iam.gserviceaccount.com",
+		Email: "autonode-foo@mlab-foo.iam.gserviceaccount.com",
+	}
+	o := &Org{Project: "mlab-foo"}
+	complete := &cloudresourcemanager.Policy{Bindings: o.policyBindings("foo", account, false)}
+	tests := []struct {
+		name        string
+		dns         *fakeDNS
+		wantMissing bool
+		wantDetail  string
+		wantErr     bool
+	}{
+		{
+			name: "success-delegated",
+			dns: &fakeDNS{split: &dns.ResourceRecordSet{
+				Rrdatas: []string{"ns2.foo.example.", "NS1.foo.example."},
+			}},
+		},
+		{
+			name: "success-other-name-servers",
+			dns: &fakeDNS{split: &dns.ResourceRecordSet{
+				Rrdatas: []string{"ns-cloud-a1.googledomains.com."},
+			}},
+			wantMissing: true,
+			wantDetail:  "delegated to ns-cloud-a1.googledomains.com.",
+		},
+		{
+			name:        "success-missing",
+			dns:         &fakeDNS{splitErr: createNotFoundErr()},
+			wantMissing: true,
+		},
+		{
+			name:    "error-get-split",
+			dns:     &fakeDNS{splitErr: fmt.Errorf("fake split error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			sam := NewServiceAccountsManager(&fakeIAMService{getAcct: account}, n)
+			sm := NewSecretManager(&fakeSMC{getSec: &secretmanagerpb.Secret{}}, n, sam)
+			o := NewOrg("mlab-foo", &fakeCRM{getPolicy: complete}, sam, sm, tt.dns, &fakeAPIKeys{}, false)
+			o.WithNameServers(testNameServers)
+			got, err := o.Check(context.Background(), "foo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// The zone and its DNSSEC state are not checked.
+			if len(got) != 6 {
+				t.Errorf("Org.Check() returned %d resources, want 6", len(got))
+			}
+			for _, d := range got {
+				switch {
+				case d.Resource == ResourceDNSZone || d.Resource == ResourceDNSSEC:
+					t.Errorf("Org.Check() returned %s for a delegated zone", d.Resource)
+				case d.Resource != ResourceZoneSplit && d.Missing:
+					t.Errorf("Org.Check() returned missing %s", d.Resource)
+				case d.Resource == ResourceZoneSplit && (d.Missing != tt.wantMissing || d.Detail != tt.wantDetail):
+					t.Errorf("Org.Check() split = %+v, want missing %t detail %q", d, tt.wantMissing, tt.wantDetail)
+				}
+			}
+		})
+	}
+}
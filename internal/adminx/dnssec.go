@@ -0,0 +1,67 @@
+package adminx
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"google.golang.org/api/dns/v1"
+)
+
+// CheckDNSSEC returns the DNSSEC problems of the organization zone, e.g. a
+// DS record that is missing from the project zone, and exports their number.
+func (o *Org) CheckDNSSEC(ctx context.Context, org string) ([]string, error) {
+	problems, err := o.dns.CheckDNSSEC(ctx, &dns.ManagedZone{
+		Name:    dnsname.OrgZone(org, o.Project, o.Domain),
+		DnsName: dnsname.OrgDNS(org, o.Project, o.Domain),
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.DNSSECProblems.WithLabelValues(org).Set(float64(len(problems)))
+	return problems, nil
+}
+
+// RunDNSSECChecks checks the DNSSEC state of every organization zone each
+// interval until ctx is canceled.
+func (o *Org) RunDNSSECChecks(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := o.CheckAllDNSSEC(ctx); err != nil {
+				log.Println("failed to check dnssec of organization zones:", err)
+			}
+		}
+	}
+}
+
+// CheckAllDNSSEC checks the DNSSEC state of the zones of all organizations
+// with a service account. Problems and failed checks are logged.
+func (o *Org) CheckAllDNSSEC(ctx context.Context) error {
+	orgs, err := o.sam.ListOrgs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		problems, err := o.CheckDNSSEC(ctx, org)
+		if errIsNotFound(err) {
+			// Zones delegated to the name servers of the org are not
+			// managed by Cloud DNS.
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to check dnssec of %s: %v", org, err)
+			continue
+		}
+		for _, p := range problems {
+			log.Printf("DNSSEC problem of %s: %s", org, p)
+		}
+	}
+	return nil
+}
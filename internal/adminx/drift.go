@@ -0,0 +1,175 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+// Resources checked by Org.Check, in the order they are created by Setup.
+const (
+	ResourceServiceAccount = "service account"
+	ResourceIAMBinding     = "iam binding"
+	ResourceSecret         = "secret"
+	ResourceDNSZone        = "dns zone"
+	ResourceZoneSplit      = "dns zone split"
+	ResourceDNSSEC         = "dnssec"
+	ResourceAPIKey         = "api key"
+)
+
+// Drift reports whether a resource created by Setup exists.
+type Drift struct {
+	Resource string
+	Name     string
+	// Missing is true if the resource does not exist.
+	Missing bool
+	// Repaired is true if the missing resource was created by Repair.
+	Repaired bool
+	// Detail describes why the resource is missing, e.g. the DNSSEC
+	// problems of the organization zone.
+	Detail string
+}
+
+// Check compares the Google Cloud resources of org with those created by
+// Setup, without changing them. Every resource is reported, missing or not.
+func (o *Org) Check(ctx context.Context, org string) ([]Drift, error) {
+	result := []Drift{}
+	add := func(resource, name string, err error) error {
+		switch {
+		case errIsNotFound(err):
+			result = append(result, Drift{Resource: resource, Name: name, Missing: true})
+		case err != nil:
+			return fmt.Errorf("check %s %s: %w", resource, name, err)
+		default:
+			result = append(result, Drift{Resource: resource, Name: name})
+		}
+		return nil
+	}
+
+	n := o.sam.Namer
+	account, err := o.sam.GetServiceAccount(ctx, org)
+	if err := add(ResourceServiceAccount, n.GetServiceAccountName(org), err); err != nil {
+		return nil, err
+	}
+	// Bindings refer to the service account by email, so they are checked
+	// even when the account is missing.
+	curr, err := o.crm.GetIamPolicy(ctx, &cloudresourcemanager.GetIamPolicyRequest{
+		Options: &cloudresourcemanager.GetPolicyOptions{
+			RequestedPolicyVersion: 3,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("check %s: %w", ResourceIAMBinding, err)
+	}
+	if account == nil {
+		account = &iam.ServiceAccount{Email: n.GetServiceAccountEmail(org)}
+	}
+	for _, b := range o.policyBindings(org, account, o.updateTables) {
+		_, missing := appendBindingIfMissing(curr.Bindings, b)
+		result = append(result, Drift{Resource: ResourceIAMBinding, Name: b.Role + " (" + b.Condition.Title + ")", Missing: missing})
+	}
+	_, err = o.sm.GetSecret(ctx, org)
+	if err := add(ResourceSecret, n.GetSecretName(org), err); err != nil {
+		return nil, err
+	}
+	zone := &dns.ManagedZone{
+		Name:    dnsname.OrgZone(org, o.Project, o.Domain),
+		DnsName: dnsname.OrgDNS(org, o.Project, o.Domain),
+	}
+	if len(o.nameServers) > 0 {
+		// Delegated zones only have a zone split.
+		d, err := o.checkDelegation(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	} else {
+		_, err = o.dns.GetZone(ctx, zone.Name)
+		zoneMissing := errIsNotFound(err)
+		if err := add(ResourceDNSZone, zone.Name, err); err != nil {
+			return nil, err
+		}
+		_, err = o.dns.GetZoneSplit(ctx, zone)
+		if err := add(ResourceZoneSplit, zone.DnsName, err); err != nil {
+			return nil, err
+		}
+		d := Drift{Resource: ResourceDNSSEC, Name: zone.Name}
+		if zoneMissing {
+			d.Missing, d.Detail = true, "dns zone is missing"
+		} else {
+			problems, err := o.CheckDNSSEC(ctx, org)
+			if err != nil {
+				return nil, fmt.Errorf("check %s %s: %w", ResourceDNSSEC, zone.Name, err)
+			}
+			d.Missing, d.Detail = len(problems) > 0, strings.Join(problems, "; ")
+		}
+		result = append(result, d)
+	}
+	_, err = o.keys.GetKey(ctx, org)
+	if err := add(ResourceAPIKey, n.GetAPIKeyName(org), err); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Repair creates the missing resources reported by Check, and marks them as
+// repaired. Resources that exist are not changed.
+func (o *Org) Repair(ctx context.Context, org string, drift []Drift) ([]Drift, error) {
+	missing := map[string]bool{}
+	for _, d := range drift {
+		if d.Missing {
+			missing[d.Resource] = true
+		}
+	}
+	if missing[ResourceServiceAccount] || missing[ResourceIAMBinding] {
+		sa, err := o.sam.CreateServiceAccount(ctx, org)
+		if err != nil {
+			return nil, err
+		}
+		if missing[ResourceIAMBinding] {
+			err = o.ApplyPolicy(ctx, org, sa, o.updateTables)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if missing[ResourceSecret] {
+		if err := o.sm.CreateSecret(ctx, org); err != nil {
+			return nil, err
+		}
+	}
+	// RegisterDNS publishes the DS record of the zone as well.
+	var problems []string
+	if missing[ResourceDNSZone] || missing[ResourceZoneSplit] || missing[ResourceDNSSEC] {
+		if err := o.RegisterDNS(ctx, org); err != nil {
+			return nil, err
+		}
+		if missing[ResourceDNSSEC] {
+			var err error
+			problems, err = o.CheckDNSSEC(ctx, org)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if missing[ResourceAPIKey] {
+		if _, err := o.keys.CreateKey(ctx, org); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]Drift, len(drift))
+	for i, d := range drift {
+		d.Repaired = d.Missing
+		if d.Resource == ResourceDNSSEC && len(problems) > 0 {
+			// Problems that remain, e.g. inactive keys, need an operator.
+			d.Repaired, d.Detail = false, strings.Join(problems, "; ")
+		}
+		result[i] = d
+	}
+	return result, nil
+}
@@ -0,0 +1,254 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+func TestOrg_Check(t *testing.T) {
+	account := &iam.ServiceAccount{
+		Name:  "projects/mlab-foo/serviceAccounts/autonode-foo@mlab-foo.iam.gserviceaccount.com",
+		Email: "autonode-foo@mlab-foo.iam.gserviceaccount.com",
+	}
+	o := &Org{Project: "mlab-foo"}
+	complete := &cloudresourcemanager.Policy{Bindings: o.policyBindings("foo", account, false)}
+	tests := []struct {
+		name        string
+		crm         *fakeCRM
+		dns         *fakeDNS
+		keys        *fakeAPIKeys
+		iams        *fakeIAMService
+		smc         *fakeSMC
+		wantMissing []string
+		wantDetail  string
+		wantErr     bool
+	}{
+		{
+			name: "success-no-drift",
+			crm:  &fakeCRM{getPolicy: complete},
+			dns:  &fakeDNS{},
+			keys: &fakeAPIKeys{},
+			iams: &fakeIAMService{getAcct: account},
+			smc:  &fakeSMC{getSec: &secretmanagerpb.Secret{}},
+		},
+		{
+			name: "success-drift",
+			crm: &fakeCRM{getPolicy: &cloudresourcemanager.Policy{
+				Bindings: complete.Bindings[1:],
+			}},
+			dns:         &fakeDNS{splitErr: createNotFoundErr()},
+			keys:        &fakeAPIKeys{},
+			iams:        &fakeIAMService{getAcct: account},
+			smc:         &fakeSMC{getSecErr: createNotFoundErr()},
+			wantMissing: []string{ResourceIAMBinding, ResourceSecret, ResourceZoneSplit},
+		},
+		{
+			name:        "success-missing-everything",
+			crm:         &fakeCRM{getPolicy: &cloudresourcemanager.Policy{}},
+			dns:         &fakeDNS{zoneErr: createNotFoundErr(), splitErr: createNotFoundErr()},
+			keys:        &fakeAPIKeys{getKeyErr: createNotFoundErr()},
+			iams:        &fakeIAMService{getAcctErr: createNotFoundErr()},
+			smc:         &fakeSMC{getSecErr: createNotFoundErr()},
+			wantMissing: []string{ResourceServiceAccount, ResourceIAMBinding, ResourceIAMBinding, ResourceSecret, ResourceDNSZone, ResourceZoneSplit, ResourceDNSSEC, ResourceAPIKey},
+		},
+		{
+			name:        "success-dnssec-problems",
+			crm:         &fakeCRM{getPolicy: complete},
+			dns:         &fakeDNS{problems: []string{"ds record is not published in mlab-foo-measurement-lab-org"}},
+			keys:        &fakeAPIKeys{},
+			iams:        &fakeIAMService{getAcct: account},
+			smc:         &fakeSMC{getSec: &secretmanagerpb.Secret{}},
+			wantMissing: []string{ResourceDNSSEC},
+			wantDetail:  "ds record is not published in mlab-foo-measurement-lab-org",
+		},
+		{
+			name:    "error-get-policy",
+			crm:     &fakeCRM{getPolicyErr: fmt.Errorf("fake policy error")},
+			dns:     &fakeDNS{},
+			keys:    &fakeAPIKeys{},
+			iams:    &fakeIAMService{getAcct: account},
+			smc:     &fakeSMC{},
+			wantErr: true,
+		},
+		{
+			name:    "error-check-dnssec",
+			crm:     &fakeCRM{getPolicy: complete},
+			dns:     &fakeDNS{checkErr: fmt.Errorf("fake dnssec error")},
+			keys:    &fakeAPIKeys{},
+			iams:    &fakeIAMService{getAcct: account},
+			smc:     &fakeSMC{getSec: &secretmanagerpb.Secret{}},
+			wantErr: true,
+		},
+		{
+			name:    "error-get-zone",
+			crm:     &fakeCRM{getPolicy: complete},
+			dns:     &fakeDNS{zoneErr: fmt.Errorf("fake zone error")},
+			keys:    &fakeAPIKeys{},
+			iams:    &fakeIAMService{getAcct: account},
+			smc:     &fakeSMC{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			sam := NewServiceAccountsManager(tt.iams, n)
+			sm := NewSecretManager(tt.smc, n, sam)
+			o := NewOrg("mlab-foo", tt.crm, sam, sm, tt.dns, tt.keys, false)
+			got, err := o.Check(context.Background(), "foo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != 8 {
+				t.Errorf("Org.Check() returned %d resources, want 8", len(got))
+			}
+			missing := []string{}
+			for _, d := range got {
+				if d.Missing {
+					missing = append(missing, d.Resource)
+				}
+				if d.Resource == ResourceDNSSEC && tt.wantDetail != "" && d.Detail != tt.wantDetail {
+					t.Errorf("Org.Check() dnssec detail = %q, want %q", d.Detail, tt.wantDetail)
+				}
+			}
+			if fmt.Sprint(missing) != fmt.Sprint(tt.wantMissing) {
+				t.Errorf("Org.Check() missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestOrg_Repair(t *testing.T) {
+	account := &iam.ServiceAccount{Email: "autonode-foo@mlab-foo.iam.gserviceaccount.com"}
+	tests := []struct {
+		name       string
+		drift      []Drift
+		crm        *fakeCRM
+		dns        *fakeDNS
+		keys       *fakeAPIKeys
+		wantPolicy bool
+		wantDNS    int
+		wantKeys   int
+		wantDetail string
+		wantErr    bool
+	}{
+		{
+			name: "success-no-drift",
+			drift: []Drift{
+				{Resource: ResourceIAMBinding},
+				{Resource: ResourceDNSZone},
+				{Resource: ResourceAPIKey},
+			},
+			crm:  &fakeCRM{getPolicy: &cloudresourcemanager.Policy{}},
+			dns:  &fakeDNS{},
+			keys: &fakeAPIKeys{},
+		},
+		{
+			name: "success-repair-drifted",
+			drift: []Drift{
+				{Resource: ResourceIAMBinding, Missing: true},
+				{Resource: ResourceZoneSplit, Missing: true},
+				{Resource: ResourceAPIKey},
+			},
+			crm:        &fakeCRM{getPolicy: &cloudresourcemanager.Policy{}},
+			dns:        &fakeDNS{},
+			keys:       &fakeAPIKeys{},
+			wantPolicy: true,
+			wantDNS:    1,
+		},
+		{
+			name: "success-repair-key",
+			drift: []Drift{
+				{Resource: ResourceAPIKey, Missing: true},
+			},
+			crm:      &fakeCRM{},
+			dns:      &fakeDNS{},
+			keys:     &fakeAPIKeys{},
+			wantKeys: 1,
+		},
+		{
+			name: "success-repair-dnssec",
+			drift: []Drift{
+				{Resource: ResourceDNSSEC, Missing: true, Detail: "dnssec is off"},
+			},
+			crm:     &fakeCRM{},
+			dns:     &fakeDNS{},
+			keys:    &fakeAPIKeys{},
+			wantDNS: 1,
+		},
+		{
+			name: "success-dnssec-unrepaired",
+			drift: []Drift{
+				{Resource: ResourceDNSSEC, Missing: true, Detail: "dnssec is off"},
+			},
+			crm:        &fakeCRM{},
+			dns:        &fakeDNS{problems: []string{"no active key signing key"}},
+			keys:       &fakeAPIKeys{},
+			wantDNS:    1,
+			wantDetail: "no active key signing key",
+		},
+		{
+			name: "error-register-ds",
+			drift: []Drift{
+				{Resource: ResourceDNSSEC, Missing: true},
+			},
+			crm:     &fakeCRM{},
+			dns:     &fakeDNS{regDSErr: fmt.Errorf("fake ds error")},
+			keys:    &fakeAPIKeys{},
+			wantDNS: 1,
+			wantErr: true,
+		},
+		{
+			name: "error-register-dns",
+			drift: []Drift{
+				{Resource: ResourceDNSZone, Missing: true},
+			},
+			crm:     &fakeCRM{},
+			dns:     &fakeDNS{regZoneErr: fmt.Errorf("fake zone error")},
+			keys:    &fakeAPIKeys{},
+			wantDNS: 1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			sam := NewServiceAccountsManager(&fakeIAMService{getAcct: account}, n)
+			sm := NewSecretManager(&fakeSMC{}, n, sam)
+			o := NewOrg("mlab-foo", tt.crm, sam, sm, tt.dns, tt.keys, false)
+			got, err := o.Repair(context.Background(), "foo", tt.drift)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.Repair() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (tt.crm.policy != nil) != tt.wantPolicy {
+				t.Errorf("Org.Repair() set policy = %v, want %t", tt.crm.policy, tt.wantPolicy)
+			}
+			if tt.dns.regCalls != tt.wantDNS {
+				t.Errorf("Org.Repair() registered zone %d times, want %d", tt.dns.regCalls, tt.wantDNS)
+			}
+			if tt.keys.created != tt.wantKeys {
+				t.Errorf("Org.Repair() created %d keys, want %d", tt.keys.created, tt.wantKeys)
+			}
+			if tt.wantErr {
+				return
+			}
+			for i, d := range got {
+				want := tt.drift[i].Missing && tt.wantDetail == ""
+				if d.Repaired != want {
+					t.Errorf("Org.Repair() = %v, want repaired %t", d, want)
+				}
+				if tt.wantDetail != "" && d.Detail != tt.wantDetail {
+					t.Errorf("Org.Repair() detail = %q, want %q", d.Detail, tt.wantDetail)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+// Package dryrun wraps the clients used to manage organizations so that
+// changes are recorded in a Plan instead of being applied. Reads are passed to
+// the wrapped clients, so a Plan only contains the changes needed to reach the
+// requested state.
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/apikeys/apiv2/apikeyspb"
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go"
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+// Placeholder is returned in place of values only known after a change is
+// applied, e.g. API key strings.
+const Placeholder = "<planned>"
+
+// Change actions.
+const (
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// Change is a single change that would be applied.
+type Change struct {
+	Action   string
+	Resource string
+	Detail   string
+}
+
+// Plan records changes in the order they would be applied.
+type Plan struct {
+	mu      sync.Mutex
+	Changes []Change
+}
+
+func (p *Plan) add(action, resource, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Changes = append(p.Changes, Change{Action: action, Resource: resource, Detail: detail})
+}
+
+// Print writes the plan to w, one change per line prefixed with "+" for
+// creations, "~" for updates and "-" for deletions.
+func (p *Plan) Print(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.Changes) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+	symbols := map[string]string{Create: "+", Update: "~", Delete: "-"}
+	for _, c := range p.Changes {
+		line := symbols[c.Action] + " " + c.Resource
+		if c.Detail != "" {
+			line += " (" + c.Detail + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "%d changes.\n", len(p.Changes))
+}
+
+// IAMClient is implemented by the IAM clients used to manage service accounts
+// and workload identity federation.
+type IAMClient interface {
+	adminx.IAMService
+	adminx.WorkloadIdentityService
+}
+
+// IAM records changes to service accounts and workload identity pools.
+type IAM struct {
+	IAMClient
+	plan    *Plan
+	planned map[string]bool
+}
+
+// NewIAM creates a new IAM recording changes in p.
+func NewIAM(c IAMClient, p *Plan) *IAM {
+	return &IAM{IAMClient: c, plan: p, planned: map[string]bool{}}
+}
+
+func (i *IAM) CreateServiceAccount(ctx context.Context, projName string, req *iam.CreateServiceAccountRequest) (*iam.ServiceAccount, error) {
+	email := req.AccountId + "@" + strings.TrimPrefix(projName, "projects/") + ".iam.gserviceaccount.com"
+	name := projName + "/serviceAccounts/" + email
+	i.planned[name] = true
+	i.plan.add(Create, "service account "+name, req.ServiceAccount.Description)
+	return &iam.ServiceAccount{Name: name, Email: email}, nil
+}
+
+func (i *IAM) CreateKey(ctx context.Context, saName string, req *iam.CreateServiceAccountKeyRequest) (*iam.ServiceAccountKey, error) {
+	i.plan.add(Create, "service account key "+saName+"/keys/"+Placeholder, "")
+	return &iam.ServiceAccountKey{Name: saName + "/keys/" + Placeholder}, nil
+}
+
+func (i *IAM) DeleteKey(ctx context.Context, keyName string) error {
+	i.plan.add(Delete, "service account key "+keyName, "")
+	return nil
+}
+
+func (i *IAM) DeleteServiceAccount(ctx context.Context, saName string) error {
+	if _, err := i.IAMClient.GetServiceAccount(ctx, saName); err != nil {
+		// Missing accounts are ignored by the caller.
+		return err
+	}
+	i.plan.add(Delete, "service account "+saName, "and all of its keys")
+	return nil
+}
+
+func (i *IAM) CreateWorkloadIdentityPool(ctx context.Context, parent, poolID string, pool *iam.WorkloadIdentityPool) error {
+	i.plan.add(Create, "workload identity pool "+parent+"/workloadIdentityPools/"+poolID, pool.Description)
+	return nil
+}
+
+func (i *IAM) CreateWorkloadIdentityPoolProvider(ctx context.Context, parent, providerID string, provider *iam.WorkloadIdentityPoolProvider) error {
+	detail := ""
+	if provider.Oidc != nil {
+		detail = "issuer " + provider.Oidc.IssuerUri
+	}
+	i.plan.add(Create, "workload identity provider "+parent+"/providers/"+providerID, detail)
+	return nil
+}
+
+func (i *IAM) GetServiceAccountIamPolicy(ctx context.Context, saName string) (*iam.Policy, error) {
+	if i.planned[saName] {
+		return &iam.Policy{}, nil
+	}
+	return i.IAMClient.GetServiceAccountIamPolicy(ctx, saName)
+}
+
+func (i *IAM) SetServiceAccountIamPolicy(ctx context.Context, saName string, req *iam.SetIamPolicyRequest) error {
+	curr, err := i.GetServiceAccountIamPolicy(ctx, saName)
+	if err != nil {
+		return err
+	}
+	for _, b := range req.Policy.Bindings {
+		if !containsIAMBinding(curr.Bindings, b) {
+			i.plan.add(Create, "iam binding "+b.Role+" on "+saName, strings.Join(b.Members, ", "))
+		}
+	}
+	return nil
+}
+
+func containsIAMBinding(l []*iam.Binding, b *iam.Binding) bool {
+	for _, a := range l {
+		if a.Role == b.Role && strings.Join(a.Members, ",") == strings.Join(b.Members, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretManager records changes to secrets.
+type SecretManager struct {
+	adminx.SecretManagerClient
+	plan *Plan
+}
+
+// NewSecretManager creates a new SecretManager recording changes in p.
+func NewSecretManager(c adminx.SecretManagerClient, p *Plan) *SecretManager {
+	return &SecretManager{SecretManagerClient: c, plan: p}
+}
+
+func (s *SecretManager) CreateSecret(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+	name := req.Parent + "/secrets/" + req.SecretId
+	s.plan.add(Create, "secret "+name, "")
+	return &secretmanagerpb.Secret{Name: name}, nil
+}
+
+func (s *SecretManager) AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	name := req.Parent + "/versions/" + Placeholder
+	s.plan.add(Create, "secret version "+name, "")
+	return &secretmanagerpb.SecretVersion{Name: name}, nil
+}
+
+func (s *SecretManager) DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	s.plan.add(Update, "secret version "+req.Name, "disable")
+	return &secretmanagerpb.SecretVersion{Name: req.Name}, nil
+}
+
+func (s *SecretManager) DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+	if _, err := s.SecretManagerClient.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: req.Name}); err != nil {
+		// Missing secrets are ignored by the caller.
+		return err
+	}
+	s.plan.add(Delete, "secret "+req.Name, "and all of its versions")
+	return nil
+}
+
+// Keys records changes to API keys.
+type Keys struct {
+	adminx.KeysClient
+	plan    *Plan
+	planned map[string]bool
+}
+
+// NewKeys creates a new Keys recording changes in p.
+func NewKeys(c adminx.KeysClient, p *Plan) *Keys {
+	return &Keys{KeysClient: c, plan: p, planned: map[string]bool{}}
+}
+
+func (k *Keys) CreateKey(ctx context.Context, req *apikeyspb.CreateKeyRequest, opts ...gax.CallOption) (*apikeyspb.Key, error) {
+	name := req.Parent + "/keys/" + req.KeyId
+	k.planned[name] = true
+	targets := []string{}
+	for _, t := range req.Key.GetRestrictions().GetApiTargets() {
+		targets = append(targets, t.Service)
+	}
+	k.plan.add(Create, "api key "+name, "restricted to "+strings.Join(targets, ", "))
+	return &apikeyspb.Key{Name: name, KeyString: Placeholder}, nil
+}
+
+func (k *Keys) GetKeyString(ctx context.Context, req *apikeyspb.GetKeyStringRequest, opts ...gax.CallOption) (*apikeyspb.GetKeyStringResponse, error) {
+	if k.planned[req.Name] {
+		return &apikeyspb.GetKeyStringResponse{KeyString: Placeholder}, nil
+	}
+	return k.KeysClient.GetKeyString(ctx, req, opts...)
+}
+
+func (k *Keys) DeleteKey(ctx context.Context, req *apikeyspb.DeleteKeyRequest, opts ...gax.CallOption) error {
+	if _, err := k.KeysClient.GetKeyString(ctx, &apikeyspb.GetKeyStringRequest{Name: req.Name}); err != nil {
+		// Missing keys are ignored by the caller.
+		return err
+	}
+	k.plan.add(Delete, "api key "+req.Name, "")
+	return nil
+}
+
+// CRM records changes to the project IAM policy.
+type CRM struct {
+	adminx.CRM
+	plan *Plan
+}
+
+// NewCRM creates a new CRM recording changes in p.
+func NewCRM(c adminx.CRM, p *Plan) *CRM {
+	return &CRM{CRM: c, plan: p}
+}
+
+func (c *CRM) SetIamPolicy(ctx context.Context, req *cloudresourcemanager.SetIamPolicyRequest) error {
+	curr, err := c.CRM.GetIamPolicy(ctx, &cloudresourcemanager.GetIamPolicyRequest{
+		Options: &cloudresourcemanager.GetPolicyOptions{RequestedPolicyVersion: 3},
+	})
+	if err != nil {
+		return err
+	}
+	for _, b := range req.Policy.Bindings {
+		if !containsBinding(curr.Bindings, b) {
+			c.plan.add(Create, "project iam binding "+b.Role, bindingDetail(b))
+		}
+	}
+	for _, b := range curr.Bindings {
+		if !containsBinding(req.Policy.Bindings, b) {
+			c.plan.add(Delete, "project iam binding "+b.Role, bindingDetail(b))
+		}
+	}
+	return nil
+}
+
+func containsBinding(l []*cloudresourcemanager.Binding, b *cloudresourcemanager.Binding) bool {
+	for _, a := range l {
+		if adminx.BindingIsEqual(a, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func bindingDetail(b *cloudresourcemanager.Binding) string {
+	d := strings.Join(b.Members, ", ")
+	if b.Condition != nil {
+		d += "; condition " + b.Condition.Title
+	}
+	return d
+}
+
+// DNS records changes to DNS zones and records.
+type DNS struct {
+	dnsiface.Service
+	plan    *Plan
+	planned map[string]*dns.ManagedZone
+}
+
+// NewDNS creates a new DNS recording changes in p.
+func NewDNS(s dnsiface.Service, p *Plan) *DNS {
+	return &DNS{Service: s, plan: p, planned: map[string]*dns.ManagedZone{}}
+}
+
+func (d *DNS) CreateManagedZone(ctx context.Context, project string, zone *dns.ManagedZone) (*dns.ManagedZone, error) {
+	d.planned[zone.Name] = zone
+	d.plan.add(Create, "dns zone "+zone.Name, zone.DnsName)
+	return zone, nil
+}
+
+func (d *DNS) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (*dns.ResourceRecordSet, error) {
+	if _, ok := d.planned[zone]; ok {
+		// Planned zones have only the records created with every zone.
+		return &dns.ResourceRecordSet{Name: name, Type: rtype, Rrdatas: []string{Placeholder}}, nil
+	}
+	return d.Service.ResourceRecordSetsGet(ctx, project, zone, name, rtype)
+}
+
+func (d *DNS) DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error) {
+	if _, ok := d.planned[zone]; ok {
+		// Planned zones have the key signing key created with every zone.
+		return []*dns.DnsKey{{
+			Type:      "keySigning",
+			IsActive:  true,
+			Algorithm: "rsasha256",
+			Digests:   []*dns.DnsKeyDigest{{Type: "sha256", Digest: Placeholder}},
+		}}, nil
+	}
+	return d.Service.DNSKeysList(ctx, project, zone)
+}
+
+func (d *DNS) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
+	for _, rr := range change.Deletions {
+		d.plan.add(Delete, "dns record "+rr.Name+" "+rr.Type+" in "+zone, strings.Join(rr.Rrdatas, ", "))
+	}
+	for _, rr := range change.Additions {
+		d.plan.add(Create, "dns record "+rr.Name+" "+rr.Type+" in "+zone, strings.Join(rr.Rrdatas, ", "))
+	}
+	return change, nil
+}
+
+func (d *DNS) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	if _, err := d.Service.GetManagedZone(ctx, project, zoneName); err != nil {
+		// Missing zones are ignored by the caller.
+		return err
+	}
+	d.plan.add(Delete, "dns zone "+zoneName, "")
+	return nil
+}
+
+// BigQuery records changes to BigQuery datasets.
+type BigQuery struct {
+	adminx.BigQuery
+	plan *Plan
+}
+
+// NewBigQuery creates a new BigQuery recording changes in p.
+func NewBigQuery(b adminx.BigQuery, p *Plan) *BigQuery {
+	return &BigQuery{BigQuery: b, plan: p}
+}
+
+func (b *BigQuery) CreateDataset(ctx context.Context, ds *bigquery.Dataset) (*bigquery.Dataset, error) {
+	b.plan.add(Create, "bigquery dataset "+ds.DatasetReference.DatasetId, ds.Location)
+	return ds, nil
+}
+
+func (b *BigQuery) PatchDataset(ctx context.Context, datasetID string, ds *bigquery.Dataset) (*bigquery.Dataset, error) {
+	emails := []string{}
+	for _, a := range ds.Access {
+		if a.UserByEmail != "" {
+			emails = append(emails, a.Role+" "+a.UserByEmail)
+		}
+	}
+	b.plan.add(Update, "bigquery dataset "+datasetID+" access", strings.Join(emails, ", "))
+	return ds, nil
+}
+
+// Sender records emails instead of sending them.
+type Sender struct {
+	plan *Plan
+}
+
+// NewSender creates a new Sender recording emails in p.
+func NewSender(p *Plan) *Sender {
+	return &Sender{plan: p}
+}
+
+func (s *Sender) Send(ctx context.Context, to, subject, body string) error {
+	s.plan.add(Create, "email to "+to, subject)
+	return nil
+}
+
+// DatastoreClient is implemented by *datastore.Client.
+type DatastoreClient interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+}
+
+// Datastore records changes to Datastore entities.
+type Datastore struct {
+	DatastoreClient
+	plan *Plan
+}
+
+// NewDatastore creates a new Datastore recording changes in p.
+func NewDatastore(c DatastoreClient, p *Plan) *Datastore {
+	return &Datastore{DatastoreClient: c, plan: p}
+}
+
+func (d *Datastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	d.plan.add(Update, "datastore entity "+entityName(key), fmt.Sprintf("%+v", src))
+	return key, nil
+}
+
+func (d *Datastore) Delete(ctx context.Context, key *datastore.Key) error {
+	d.plan.add(Delete, "datastore entity "+entityName(key), "")
+	return nil
+}
+
+func entityName(k *datastore.Key) string {
+	return k.Namespace + "/" + k.Kind + "/" + k.Name
+}
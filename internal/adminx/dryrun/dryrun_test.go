@@ -0,0 +1,343 @@
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/apikeys/apiv2/apikeyspb"
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go"
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+var errNotFound = errors.New("not found")
+
+// The fakes embed their interface so that calls the wrappers must not pass
+// through panic.
+
+type fakeIAM struct {
+	IAMClient
+	policy *iam.Policy
+}
+
+func (f *fakeIAM) GetServiceAccount(ctx context.Context, saName string) (*iam.ServiceAccount, error) {
+	return nil, errNotFound
+}
+
+func (f *fakeIAM) GetServiceAccountIamPolicy(ctx context.Context, saName string) (*iam.Policy, error) {
+	if f.policy == nil {
+		return nil, errNotFound
+	}
+	return f.policy, nil
+}
+
+type fakeSMC struct {
+	adminx.SecretManagerClient
+}
+
+func (f *fakeSMC) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+	return &secretmanagerpb.Secret{Name: req.Name}, nil
+}
+
+type fakeKeys struct {
+	adminx.KeysClient
+}
+
+func (f *fakeKeys) GetKeyString(ctx context.Context, req *apikeyspb.GetKeyStringRequest, opts ...gax.CallOption) (*apikeyspb.GetKeyStringResponse, error) {
+	return nil, errNotFound
+}
+
+type fakeCRM struct {
+	adminx.CRM
+	policy *cloudresourcemanager.Policy
+}
+
+func (f *fakeCRM) GetIamPolicy(ctx context.Context, req *cloudresourcemanager.GetIamPolicyRequest) (*cloudresourcemanager.Policy, error) {
+	return f.policy, nil
+}
+
+type fakeDNS struct {
+	dnsiface.Service
+}
+
+func (f *fakeDNS) GetManagedZone(ctx context.Context, project, zoneName string) (*dns.ManagedZone, error) {
+	return nil, errNotFound
+}
+
+func (f *fakeDNS) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (*dns.ResourceRecordSet, error) {
+	return nil, errNotFound
+}
+
+type fakeDatastore struct {
+	DatastoreClient
+	got int
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.got++
+	return nil
+}
+
+func TestPlan_Print(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []Change
+		want    string
+	}{
+		{
+			name: "success-no-changes",
+			want: "No changes.\n",
+		},
+		{
+			name: "success",
+			changes: []Change{
+				{Action: Create, Resource: "secret foo"},
+				{Action: Update, Resource: "datastore entity bar", Detail: "baz"},
+				{Action: Delete, Resource: "dns zone qux"},
+			},
+			want: "+ secret foo\n~ datastore entity bar (baz)\n- dns zone qux\n3 changes.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plan{Changes: tt.changes}
+			b := &bytes.Buffer{}
+			p.Print(b)
+			if b.String() != tt.want {
+				t.Errorf("Plan.Print() = %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestIAM(t *testing.T) {
+	ctx := context.Background()
+	p := &Plan{}
+	i := NewIAM(&fakeIAM{}, p)
+
+	sa, err := i.CreateServiceAccount(ctx, "projects/mlab-foo", &iam.CreateServiceAccountRequest{
+		AccountId:      "autonode-bar",
+		ServiceAccount: &iam.ServiceAccount{Description: "bar"},
+	})
+	if err != nil {
+		t.Fatalf("IAM.CreateServiceAccount() error = %v", err)
+	}
+	if sa.Email != "autonode-bar@mlab-foo.iam.gserviceaccount.com" {
+		t.Errorf("IAM.CreateServiceAccount() email = %q", sa.Email)
+	}
+	// Planned accounts have an empty policy.
+	pol, err := i.GetServiceAccountIamPolicy(ctx, sa.Name)
+	if err != nil || len(pol.Bindings) != 0 {
+		t.Errorf("IAM.GetServiceAccountIamPolicy() = %v, %v, want empty policy", pol, err)
+	}
+	err = i.SetServiceAccountIamPolicy(ctx, sa.Name, &iam.SetIamPolicyRequest{
+		Policy: &iam.Policy{Bindings: []*iam.Binding{{Role: "roles/iam.workloadIdentityUser", Members: []string{"principalSet://foo"}}}},
+	})
+	if err != nil {
+		t.Errorf("IAM.SetServiceAccountIamPolicy() error = %v", err)
+	}
+	// Missing accounts are not planned for deletion.
+	if err := i.DeleteServiceAccount(ctx, "projects/mlab-foo/serviceAccounts/missing"); err == nil {
+		t.Errorf("IAM.DeleteServiceAccount() error = nil, want error")
+	}
+	if len(p.Changes) != 2 {
+		t.Fatalf("IAM planned %d changes, want 2: %v", len(p.Changes), p.Changes)
+	}
+	if p.Changes[1].Resource != "iam binding roles/iam.workloadIdentityUser on "+sa.Name {
+		t.Errorf("IAM planned wrong change: %v", p.Changes[1])
+	}
+}
+
+func TestSecretManager(t *testing.T) {
+	ctx := context.Background()
+	p := &Plan{}
+	s := NewSecretManager(&fakeSMC{}, p)
+
+	sec, err := s.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{Parent: "projects/mlab-foo", SecretId: "autojoin-sa-key-bar"})
+	if err != nil || sec.Name != "projects/mlab-foo/secrets/autojoin-sa-key-bar" {
+		t.Errorf("SecretManager.CreateSecret() = %v, %v", sec, err)
+	}
+	_, err = s.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{Parent: sec.Name})
+	if err != nil {
+		t.Errorf("SecretManager.AddSecretVersion() error = %v", err)
+	}
+	err = s.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{Name: sec.Name})
+	if err != nil {
+		t.Errorf("SecretManager.DeleteSecret() error = %v", err)
+	}
+	want := []string{Create, Create, Delete}
+	if len(p.Changes) != len(want) {
+		t.Fatalf("SecretManager planned %v, want %d changes", p.Changes, len(want))
+	}
+	for i := range want {
+		if p.Changes[i].Action != want[i] {
+			t.Errorf("SecretManager change %d = %v, want %s", i, p.Changes[i], want[i])
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	p := &Plan{}
+	k := NewKeys(&fakeKeys{}, p)
+
+	key, err := k.CreateKey(ctx, &apikeyspb.CreateKeyRequest{
+		Parent: "projects/mlab-foo/locations/global",
+		KeyId:  "api-key-bar",
+		Key: &apikeyspb.Key{Restrictions: &apikeyspb.Restrictions{
+			ApiTargets: []*apikeyspb.ApiTarget{{Service: "locate.measurementlab.net"}},
+		}},
+	})
+	if err != nil || key.KeyString != Placeholder {
+		t.Errorf("Keys.CreateKey() = %v, %v", key, err)
+	}
+	resp, err := k.GetKeyString(ctx, &apikeyspb.GetKeyStringRequest{Name: key.Name})
+	if err != nil || resp.KeyString != Placeholder {
+		t.Errorf("Keys.GetKeyString() = %v, %v", resp, err)
+	}
+	// Missing keys are not planned for deletion.
+	if err := k.DeleteKey(ctx, &apikeyspb.DeleteKeyRequest{Name: "missing"}); err == nil {
+		t.Errorf("Keys.DeleteKey() error = nil, want error")
+	}
+	if len(p.Changes) != 1 || !strings.Contains(p.Changes[0].Detail, "locate.measurementlab.net") {
+		t.Errorf("Keys planned %v, want 1 change", p.Changes)
+	}
+}
+
+func TestCRM_SetIamPolicy(t *testing.T) {
+	keep := &cloudresourcemanager.Binding{Role: "roles/keep", Members: []string{"user:a"}}
+	drop := &cloudresourcemanager.Binding{Role: "roles/drop", Members: []string{"serviceAccount:b"}}
+	add := &cloudresourcemanager.Binding{
+		Role:      "roles/add",
+		Members:   []string{"serviceAccount:b"},
+		Condition: &cloudresourcemanager.Expr{Title: "bar"},
+	}
+	p := &Plan{}
+	c := NewCRM(&fakeCRM{policy: &cloudresourcemanager.Policy{Bindings: []*cloudresourcemanager.Binding{keep, drop}}}, p)
+	err := c.SetIamPolicy(context.Background(), &cloudresourcemanager.SetIamPolicyRequest{
+		Policy: &cloudresourcemanager.Policy{Bindings: []*cloudresourcemanager.Binding{keep, add}},
+	})
+	if err != nil {
+		t.Fatalf("CRM.SetIamPolicy() error = %v", err)
+	}
+	want := []Change{
+		{Action: Create, Resource: "project iam binding roles/add", Detail: "serviceAccount:b; condition bar"},
+		{Action: Delete, Resource: "project iam binding roles/drop", Detail: "serviceAccount:b"},
+	}
+	if len(p.Changes) != len(want) {
+		t.Fatalf("CRM planned %v, want %v", p.Changes, want)
+	}
+	for i := range want {
+		if p.Changes[i] != want[i] {
+			t.Errorf("CRM change %d = %v, want %v", i, p.Changes[i], want[i])
+		}
+	}
+}
+
+func TestBigQuery(t *testing.T) {
+	ctx := context.Background()
+	p := &Plan{}
+	b := NewBigQuery(nil, p)
+	ds := &bigquery.Dataset{
+		DatasetReference: &bigquery.DatasetReference{DatasetId: "autojoin_bar"},
+		Location:         "US",
+	}
+	if _, err := b.CreateDataset(ctx, ds); err != nil {
+		t.Errorf("BigQuery.CreateDataset() error = %v", err)
+	}
+	ds.Access = []*bigquery.DatasetAccess{{Role: "roles/bigquery.dataEditor", UserByEmail: "b@mlab-foo.iam.gserviceaccount.com"}}
+	if _, err := b.PatchDataset(ctx, "autojoin_bar", ds); err != nil {
+		t.Errorf("BigQuery.PatchDataset() error = %v", err)
+	}
+	want := []Change{
+		{Action: Create, Resource: "bigquery dataset autojoin_bar", Detail: "US"},
+		{Action: Update, Resource: "bigquery dataset autojoin_bar access", Detail: "roles/bigquery.dataEditor b@mlab-foo.iam.gserviceaccount.com"},
+	}
+	if !reflect.DeepEqual(p.Changes, want) {
+		t.Errorf("BigQuery planned %v, want %v", p.Changes, want)
+	}
+}
+
+func TestSender(t *testing.T) {
+	p := &Plan{}
+	s := NewSender(p)
+	if err := s.Send(context.Background(), "ops@foo.example", "hello", "body"); err != nil {
+		t.Errorf("Sender.Send() error = %v", err)
+	}
+	want := []Change{{Action: Create, Resource: "email to ops@foo.example", Detail: "hello"}}
+	if !reflect.DeepEqual(p.Changes, want) {
+		t.Errorf("Sender planned %v, want %v", p.Changes, want)
+	}
+}
+
+func TestDNS(t *testing.T) {
+	ctx := context.Background()
+	p := &Plan{}
+	d := NewDNS(&fakeDNS{}, p)
+
+	zone := &dns.ManagedZone{Name: "autojoin-bar-mlab-foo-measurement-lab-org", DnsName: "bar.mlab-foo.measurement-lab.org."}
+	if _, err := d.CreateManagedZone(ctx, "mlab-foo", zone); err != nil {
+		t.Errorf("DNS.CreateManagedZone() error = %v", err)
+	}
+	// Planned zones have NS records.
+	ns, err := d.ResourceRecordSetsGet(ctx, "mlab-foo", zone.Name, zone.DnsName, "NS")
+	if err != nil || ns.Type != "NS" {
+		t.Errorf("DNS.ResourceRecordSetsGet() = %v, %v, want NS record", ns, err)
+	}
+	// Other records pass through.
+	if _, err := d.ResourceRecordSetsGet(ctx, "mlab-foo", "parent", zone.DnsName, "NS"); err != errNotFound {
+		t.Errorf("DNS.ResourceRecordSetsGet() error = %v, want %v", err, errNotFound)
+	}
+	_, err = d.ChangeCreate(ctx, "mlab-foo", "parent", &dns.Change{Additions: []*dns.ResourceRecordSet{ns}})
+	if err != nil {
+		t.Errorf("DNS.ChangeCreate() error = %v", err)
+	}
+	// Missing zones are not planned for deletion.
+	if err := d.DeleteManagedZone(ctx, "mlab-foo", "missing"); err != errNotFound {
+		t.Errorf("DNS.DeleteManagedZone() error = %v, want %v", err, errNotFound)
+	}
+	if len(p.Changes) != 2 || p.Changes[1].Resource != "dns record bar.mlab-foo.measurement-lab.org. NS in parent" {
+		t.Errorf("DNS planned %v, want 2 changes", p.Changes)
+	}
+}
+
+func TestDatastore(t *testing.T) {
+	ctx := context.Background()
+	p := &Plan{}
+	f := &fakeDatastore{}
+	d := NewDatastore(f, p)
+
+	key := datastore.NameKey("Organization", "bar", nil)
+	key.Namespace = "autojoin"
+	if err := d.Get(ctx, key, nil); err != nil || f.got != 1 {
+		t.Errorf("Datastore.Get() error = %v, calls = %d", err, f.got)
+	}
+	if _, err := d.Put(ctx, key, &struct{ Status string }{"suspended"}); err != nil {
+		t.Errorf("Datastore.Put() error = %v", err)
+	}
+	if err := d.Delete(ctx, key); err != nil {
+		t.Errorf("Datastore.Delete() error = %v", err)
+	}
+	want := []Change{
+		{Action: Update, Resource: "datastore entity autojoin/Organization/bar", Detail: "&{Status:suspended}"},
+		{Action: Delete, Resource: "datastore entity autojoin/Organization/bar"},
+	}
+	if len(p.Changes) != len(want) {
+		t.Fatalf("Datastore planned %v, want %v", p.Changes, want)
+	}
+	for i := range want {
+		if p.Changes[i] != want[i] {
+			t.Errorf("Datastore change %d = %v, want %v", i, p.Changes[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package adminx
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/api/cloudkms/v1"
+)
+
+// ErrNoKMS is returned when loading an encrypted key without a KMS key.
+var ErrNoKMS = errors.New("key is encrypted but no KMS key is configured")
+
+// KMSService is an interface describing the Cloud KMS operations used for
+// envelope encryption.
+type KMSService interface {
+	Encrypt(ctx context.Context, keyName string, req *cloudkms.EncryptRequest) (*cloudkms.EncryptResponse, error)
+	Decrypt(ctx context.Context, keyName string, req *cloudkms.DecryptRequest) (*cloudkms.DecryptResponse, error)
+}
+
+// envelope is the secret payload of an encrypted key. The key is encrypted
+// with a random data key, which is in turn encrypted by the KMS key.
+type envelope struct {
+	KMSKey     string `json:"kms_key"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// seal encrypts plaintext with a new data key, wrapped by the KMS key. The
+// secret name is authenticated so that payloads cannot be swapped between
+// secrets.
+func (s *SecretManager) seal(ctx context.Context, secret string, plaintext []byte) ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	resp, err := s.kms.Encrypt(ctx, s.kmsKey, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(dek),
+	})
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(resp.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&envelope{
+		KMSKey:     s.kmsKey,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(secret)),
+	})
+}
+
+// open decrypts a payload created by seal. Payloads stored without encryption
+// are returned unchanged.
+func (s *SecretManager) open(ctx context.Context, secret string, payload []byte) ([]byte, error) {
+	// Unencrypted keys are base64 encoded and never start with a brace.
+	if !bytes.HasPrefix(payload, []byte("{")) {
+		return payload, nil
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(payload, env); err != nil {
+		return nil, err
+	}
+	if s.kms == nil {
+		return nil, ErrNoKMS
+	}
+	// Decrypt with the key recorded in the envelope, which may differ from
+	// the current key after a change of KMS key.
+	resp, err := s.kms.Decrypt(ctx, env.KMSKey, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(env.WrappedKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	dek, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, env.Nonce, env.Ciphertext, []byte(secret))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
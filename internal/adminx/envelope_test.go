@@ -0,0 +1,153 @@
+package adminx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/cloudkms/v1"
+)
+
+type fakeKMS struct {
+	encErr error
+	decErr error
+	keys   []string
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, keyName string, req *cloudkms.EncryptRequest) (*cloudkms.EncryptResponse, error) {
+	f.keys = append(f.keys, keyName)
+	if f.encErr != nil {
+		return nil, f.encErr
+	}
+	// Reverse the plaintext so that the wrapped key differs from the data key.
+	b, _ := base64.StdEncoding.DecodeString(req.Plaintext)
+	return &cloudkms.EncryptResponse{Ciphertext: base64.StdEncoding.EncodeToString(reverse(b))}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, keyName string, req *cloudkms.DecryptRequest) (*cloudkms.DecryptResponse, error) {
+	f.keys = append(f.keys, keyName)
+	if f.decErr != nil {
+		return nil, f.decErr
+	}
+	b, _ := base64.StdEncoding.DecodeString(req.Ciphertext)
+	return &cloudkms.DecryptResponse{Plaintext: base64.StdEncoding.EncodeToString(reverse(b))}, nil
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func TestSecretManager_Encryption(t *testing.T) {
+	const kmsKey = "projects/mlab-foo/locations/global/keyRings/autojoin/cryptoKeys/secrets"
+	tests := []struct {
+		name       string
+		sealKMS    *fakeKMS
+		openKMS    *fakeKMS
+		openOrg    string
+		plaintext  bool
+		want       string
+		wantErr    error
+		wantAnyErr bool
+	}{
+		{
+			name:    "success",
+			sealKMS: &fakeKMS{},
+			openKMS: &fakeKMS{},
+			openOrg: "testorg",
+			want:    "fake data",
+		},
+		{
+			name:      "success-unencrypted",
+			plaintext: true,
+			openKMS:   &fakeKMS{},
+			openOrg:   "testorg",
+			want:      "fake data",
+		},
+		{
+			name:      "success-unencrypted-without-kms",
+			plaintext: true,
+			openOrg:   "testorg",
+			want:      "fake data",
+		},
+		{
+			name:    "error-no-kms",
+			sealKMS: &fakeKMS{},
+			openOrg: "testorg",
+			wantErr: ErrNoKMS,
+		},
+		{
+			name:       "error-other-secret",
+			sealKMS:    &fakeKMS{},
+			openKMS:    &fakeKMS{},
+			openOrg:    "otherorg",
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-decrypt",
+			sealKMS:    &fakeKMS{},
+			openKMS:    &fakeKMS{decErr: fmt.Errorf("fake decrypt error")},
+			openOrg:    "testorg",
+			wantAnyErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			ctx := context.Background()
+			var payload []byte
+			if tt.plaintext {
+				payload = []byte("fake data")
+			} else {
+				s := NewSecretManager(nil, n, nil).EncryptWith(tt.sealKMS, kmsKey)
+				var err error
+				payload, err = s.payload(ctx, "testorg", "fake data")
+				if err != nil {
+					t.Fatalf("SecretManager.payload() error = %v", err)
+				}
+				if strings.Contains(string(payload), "fake data") {
+					t.Errorf("SecretManager.payload() = %s, contains plaintext", payload)
+				}
+			}
+			smc := &fakeSMC{
+				accessSecVer: &secretmanagerpb.AccessSecretVersionResponse{
+					Payload: &secretmanagerpb.SecretPayload{Data: payload},
+				},
+			}
+			s := NewSecretManager(smc, n, nil)
+			if tt.openKMS != nil {
+				s.EncryptWith(tt.openKMS, "projects/mlab-foo/locations/global/keyRings/autojoin/cryptoKeys/new")
+			}
+			got, err := s.LoadKey(ctx, tt.openOrg)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SecretManager.LoadKey() error = %v, want %v", err, tt.wantErr)
+			}
+			if (err != nil) != (tt.wantErr != nil || tt.wantAnyErr) {
+				t.Fatalf("SecretManager.LoadKey() error = %v, wantErr %t", err, tt.wantAnyErr)
+			}
+			if got != tt.want {
+				t.Errorf("SecretManager.LoadKey() = %q, want %q", got, tt.want)
+			}
+			// Encrypted keys are decrypted with the KMS key used to encrypt them.
+			if tt.openKMS != nil && !tt.plaintext && tt.openKMS.keys[0] != kmsKey {
+				t.Errorf("Decrypt() key = %q, want %q", tt.openKMS.keys[0], kmsKey)
+			}
+		})
+	}
+}
+
+func TestSecretManager_StoreKeyEncryptError(t *testing.T) {
+	n := NewNamer("mlab-foo")
+	smc := &fakeSMC{getSecVerErr: createNotFoundErr()}
+	s := NewSecretManager(smc, n, nil).EncryptWith(&fakeKMS{encErr: fmt.Errorf("fake encrypt error")}, "fake-key")
+	if err := s.StoreKey(context.Background(), "testorg", "fake data"); err == nil {
+		t.Errorf("SecretManager.StoreKey() error = nil, want error")
+	}
+}
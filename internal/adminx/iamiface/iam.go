@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
 )
 
 type iamImpl struct {
@@ -27,3 +28,63 @@ func (i *iamImpl) CreateServiceAccount(ctx context.Context, pName string, req *i
 func (i *iamImpl) CreateKey(ctx context.Context, saName string, req *iam.CreateServiceAccountKeyRequest) (*iam.ServiceAccountKey, error) {
 	return i.iamClient.Projects.ServiceAccounts.Keys.Create(saName, req).Context(ctx).Do()
 }
+
+func (i *iamImpl) DeleteKey(ctx context.Context, keyName string) error {
+	_, err := i.iamClient.Projects.ServiceAccounts.Keys.Delete(keyName).Context(ctx).Do()
+	return err
+}
+
+func (i *iamImpl) ListKeys(ctx context.Context, saName string) ([]*iam.ServiceAccountKey, error) {
+	resp, err := i.iamClient.Projects.ServiceAccounts.Keys.List(saName).KeyTypes("USER_MANAGED").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+func (i *iamImpl) ListServiceAccounts(ctx context.Context, projName string) ([]*iam.ServiceAccount, error) {
+	accounts := []*iam.ServiceAccount{}
+	err := i.iamClient.Projects.ServiceAccounts.List(projName).Pages(ctx, func(resp *iam.ListServiceAccountsResponse) error {
+		accounts = append(accounts, resp.Accounts...)
+		return nil
+	})
+	return accounts, err
+}
+
+func (i *iamImpl) DeleteServiceAccount(ctx context.Context, saName string) error {
+	_, err := i.iamClient.Projects.ServiceAccounts.Delete(saName).Context(ctx).Do()
+	return err
+}
+
+func (i *iamImpl) CreateWorkloadIdentityPool(ctx context.Context, parent, poolID string, pool *iam.WorkloadIdentityPool) error {
+	_, err := i.iamClient.Projects.Locations.WorkloadIdentityPools.Create(parent, pool).WorkloadIdentityPoolId(poolID).Context(ctx).Do()
+	return err
+}
+
+func (i *iamImpl) CreateWorkloadIdentityPoolProvider(ctx context.Context, parent, providerID string, provider *iam.WorkloadIdentityPoolProvider) error {
+	_, err := i.iamClient.Projects.Locations.WorkloadIdentityPools.Providers.Create(parent, provider).WorkloadIdentityPoolProviderId(providerID).Context(ctx).Do()
+	return err
+}
+
+func (i *iamImpl) GetServiceAccountIamPolicy(ctx context.Context, saName string) (*iam.Policy, error) {
+	return i.iamClient.Projects.ServiceAccounts.GetIamPolicy(saName).Context(ctx).Do()
+}
+
+func (i *iamImpl) SetServiceAccountIamPolicy(ctx context.Context, saName string, req *iam.SetIamPolicyRequest) error {
+	_, err := i.iamClient.Projects.ServiceAccounts.SetIamPolicy(saName, req).Context(ctx).Do()
+	return err
+}
+
+type credentialsImpl struct {
+	credsClient *iamcredentials.Service
+}
+
+func NewCredentials(cs *iamcredentials.Service) *credentialsImpl {
+	return &credentialsImpl{
+		credsClient: cs,
+	}
+}
+
+func (c *credentialsImpl) GenerateAccessToken(ctx context.Context, saName string, req *iamcredentials.GenerateAccessTokenRequest) (*iamcredentials.GenerateAccessTokenResponse, error) {
+	return c.credsClient.Projects.ServiceAccounts.GenerateAccessToken(saName, req).Context(ctx).Do()
+}
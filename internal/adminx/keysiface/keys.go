@@ -33,3 +33,19 @@ func (c *keysImpl) CreateKey(ctx context.Context, req *apikeyspb.CreateKeyReques
 	// Wait for the create operation to complete.
 	return key.Wait(ctx)
 }
+
+// LookupKey returns the resource name of the given API key string.
+func (c *keysImpl) LookupKey(ctx context.Context, req *apikeyspb.LookupKeyRequest, opts ...gax.CallOption) (*apikeyspb.LookupKeyResponse, error) {
+	return c.client.LookupKey(ctx, req)
+}
+
+// DeleteKey deletes the given API key. DeleteKey is a blocking request.
+func (c *keysImpl) DeleteKey(ctx context.Context, req *apikeyspb.DeleteKeyRequest, opts ...gax.CallOption) error {
+	op, err := c.client.DeleteKey(ctx, req)
+	if err != nil {
+		return err
+	}
+	// Wait for the delete operation to complete.
+	_, err = op.Wait(ctx)
+	return err
+}
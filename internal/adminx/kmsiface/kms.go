@@ -0,0 +1,25 @@
+package kmsiface
+
+import (
+	"context"
+
+	"google.golang.org/api/cloudkms/v1"
+)
+
+type kmsImpl struct {
+	kmsClient *cloudkms.Service
+}
+
+func NewKMS(kc *cloudkms.Service) *kmsImpl {
+	return &kmsImpl{
+		kmsClient: kc,
+	}
+}
+
+func (k *kmsImpl) Encrypt(ctx context.Context, keyName string, req *cloudkms.EncryptRequest) (*cloudkms.EncryptResponse, error) {
+	return k.kmsClient.Projects.Locations.KeyRings.CryptoKeys.Encrypt(keyName, req).Context(ctx).Do()
+}
+
+func (k *kmsImpl) Decrypt(ctx context.Context, keyName string, req *cloudkms.DecryptRequest) (*cloudkms.DecryptResponse, error) {
+	return k.kmsClient.Projects.Locations.KeyRings.CryptoKeys.Decrypt(keyName, req).Context(ctx).Do()
+}
@@ -1,5 +1,10 @@
 package adminx
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Namer contains metadata needed for resource naming.
 type Namer struct {
 	Project string
@@ -61,3 +66,35 @@ func (n *Namer) GetAPIKeyName(org string) string {
 func (n *Namer) GetAPIKeyID(org string) string {
 	return "autojoin-key-" + org
 }
+
+// GetDatasetID returns the BigQuery dataset ID for the given org, e.g.
+// autojoin_foo. Dataset IDs may not contain hyphens.
+func (n *Namer) GetDatasetID(org string) string {
+	return "autojoin_" + strings.ReplaceAll(org, "-", "_")
+}
+
+// GetWorkloadIdentityPoolID returns the workload identity pool ID for the
+// given org, e.g. autojoin-foo
+func (n *Namer) GetWorkloadIdentityPoolID(org string) string {
+	return "autojoin-" + org
+}
+
+// GetWorkloadIdentityPoolName returns the workload identity pool resource name
+// for the given org and project number, e.g.
+// projects/123/locations/global/workloadIdentityPools/autojoin-foo
+func (n *Namer) GetWorkloadIdentityPoolName(org string, number int64) string {
+	return fmt.Sprintf("projects/%d/locations/global/workloadIdentityPools/%s", number, n.GetWorkloadIdentityPoolID(org))
+}
+
+// GetWorkloadIdentityProviderID returns the workload identity provider ID
+// within the org pool, e.g. oidc
+func (n *Namer) GetWorkloadIdentityProviderID(org string) string {
+	return "oidc"
+}
+
+// GetWorkloadIdentityProviderName returns the workload identity provider
+// resource name for the given org and project number, e.g.
+// projects/123/locations/global/workloadIdentityPools/autojoin-foo/providers/oidc
+func (n *Namer) GetWorkloadIdentityProviderName(org string, number int64) string {
+	return n.GetWorkloadIdentityPoolName(org, number) + "/providers/" + n.GetWorkloadIdentityProviderID(org)
+}
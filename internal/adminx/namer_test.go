@@ -13,6 +13,8 @@ func TestNamer_GetProjectsName(t *testing.T) {
 		wantSAName  string
 		wantSecID   string
 		wantSecName string
+		wantWIFName string
+		wantDataset string
 	}{
 		{
 			name:        "success",
@@ -24,6 +26,21 @@ func TestNamer_GetProjectsName(t *testing.T) {
 			wantSAName:  "projects/mlab-sandbox/serviceAccounts/autonode-foo@mlab-sandbox.iam.gserviceaccount.com",
 			wantSecID:   "autojoin-serviceaccount-key-foo",
 			wantSecName: "projects/mlab-sandbox/secrets/autojoin-serviceaccount-key-foo",
+			wantWIFName: "projects/123/locations/global/workloadIdentityPools/autojoin-foo/providers/oidc",
+			wantDataset: "autojoin_foo",
+		},
+		{
+			name:        "success-hyphen",
+			proj:        "mlab-sandbox",
+			org:         "foo-bar",
+			wantProject: "projects/mlab-sandbox",
+			wantSAID:    "autonode-foo-bar",
+			wantSAEmail: "autonode-foo-bar@mlab-sandbox.iam.gserviceaccount.com",
+			wantSAName:  "projects/mlab-sandbox/serviceAccounts/autonode-foo-bar@mlab-sandbox.iam.gserviceaccount.com",
+			wantSecID:   "autojoin-serviceaccount-key-foo-bar",
+			wantSecName: "projects/mlab-sandbox/secrets/autojoin-serviceaccount-key-foo-bar",
+			wantWIFName: "projects/123/locations/global/workloadIdentityPools/autojoin-foo-bar/providers/oidc",
+			wantDataset: "autojoin_foo_bar",
 		},
 	}
 	for _, tt := range tests {
@@ -47,6 +64,12 @@ func TestNamer_GetProjectsName(t *testing.T) {
 			if got := n.GetSecretName(tt.org); got != tt.wantSecName {
 				t.Errorf("Namer.GetSecretName() = %v, want %v", got, tt.wantSecName)
 			}
+			if got := n.GetWorkloadIdentityProviderName(tt.org, 123); got != tt.wantWIFName {
+				t.Errorf("Namer.GetWorkloadIdentityProviderName() = %v, want %v", got, tt.wantWIFName)
+			}
+			if got := n.GetDatasetID(tt.org); got != tt.wantDataset {
+				t.Errorf("Namer.GetDatasetID() = %v, want %v", got, tt.wantDataset)
+			}
 		})
 	}
 }
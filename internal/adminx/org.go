@@ -2,10 +2,13 @@ package adminx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
 	"golang.org/x/exp/slices"
 
 	"google.golang.org/api/cloudresourcemanager/v1"
@@ -13,6 +16,9 @@ import (
 	"google.golang.org/api/iam/v1"
 )
 
+// ErrOrgHasNodes is returned when tearing down an organization with registered nodes.
+var ErrOrgHasNodes = errors.New("organization has registered nodes")
+
 var (
 	// Restrict uploads to the organization prefix. Needed to share bucket write access.
 	expUploadFmt = (`resource.name.startsWith("projects/_/buckets/archive-%s/objects/autoload/v2/%s") ||` +
@@ -33,34 +39,57 @@ var (
 type DNS interface {
 	RegisterZone(ctx context.Context, zone *dns.ManagedZone) (*dns.ManagedZone, error)
 	RegisterZoneSplit(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error)
+	GetZone(ctx context.Context, zoneName string) (*dns.ManagedZone, error)
+	ListZones(ctx context.Context) ([]*dns.ManagedZone, error)
+	GetZoneSplit(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error)
+	CountRecords(ctx context.Context, zoneName string) (int, error)
+	DeleteZone(ctx context.Context, zone *dns.ManagedZone) error
+	RegisterDS(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error)
+	RegisterDelegation(ctx context.Context, zone *dns.ManagedZone, servers []string) (*dns.ResourceRecordSet, error)
+	CheckDNSSEC(ctx context.Context, zone *dns.ManagedZone) ([]string, error)
 }
 
 // CRM is a simplified interface to the Google Cloud Resource Manager API.
 type CRM interface {
 	GetIamPolicy(ctx context.Context, req *cloudresourcemanager.GetIamPolicyRequest) (*cloudresourcemanager.Policy, error)
 	SetIamPolicy(ctx context.Context, req *cloudresourcemanager.SetIamPolicyRequest) error
+	GetProject(ctx context.Context) (*cloudresourcemanager.Project, error)
+	// TestIamPermissions returns the given permissions that the caller has
+	// on the project.
+	TestIamPermissions(ctx context.Context, permissions []string) ([]string, error)
 }
 
 // Keys is the interface used to manage organization API keys.
 type Keys interface {
 	CreateKey(ctx context.Context, org string) (string, error)
+	GetKey(ctx context.Context, org string) (string, error)
+	DeleteKey(ctx context.Context, id string) error
 }
 
 // Org contains fields needed to setup a new organization for Autojoined nodes.
 type Org struct {
-	Project      string
+	Project string
+	// Domain is the base domain of organization zones, e.g.
+	// "measurement-lab.org". NewOrg sets dnsname.DefaultDomain.
+	Domain       string
 	crm          CRM
 	sam          *ServiceAccountsManager
 	sm           *SecretManager
 	dns          DNS
 	keys         Keys
 	updateTables bool
+	wis          WorkloadIdentityService
+	issuer       string
+	bq           BigQuery
+	location     string
+	nameServers  []string
 }
 
 // NewOrg creates a new Org instance for setting up a new organization.
 func NewOrg(project string, crm CRM, sam *ServiceAccountsManager, sm *SecretManager, dns DNS, k Keys, updateTables bool) *Org {
 	return &Org{
 		Project:      project,
+		Domain:       dnsname.DefaultDomain,
 		crm:          crm,
 		sam:          sam,
 		sm:           sm,
@@ -81,6 +110,20 @@ func (o *Org) Setup(ctx context.Context, org string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// Allow nodes to authenticate as the service account with OIDC tokens.
+	if o.wis != nil {
+		err = o.SetupWorkloadIdentity(ctx, org, sa)
+		if err != nil {
+			return "", err
+		}
+	}
+	// Create a dataset for the data of the org.
+	if o.bq != nil {
+		err = o.SetupDataset(ctx, org, sa)
+		if err != nil {
+			return "", err
+		}
+	}
 	// Create secret with no versions.
 	err = o.sm.CreateSecret(ctx, org)
 	if err != nil {
@@ -94,31 +137,161 @@ func (o *Org) Setup(ctx context.Context, org string) (string, error) {
 	return o.keys.CreateKey(ctx, org)
 }
 
+// Nodes returns the number of nodes registered in the organization zone.
+func (o *Org) Nodes(ctx context.Context, org string) (int, error) {
+	return o.dns.CountRecords(ctx, dnsname.OrgZone(org, o.Project, o.Domain))
+}
+
+// Teardown deletes the Google Cloud resources created by Setup for org. The
+// organization must have no registered nodes. Resources that were already
+// deleted are ignored, so Teardown may be run again after a failure.
+func (o *Org) Teardown(ctx context.Context, org string) error {
+	n, err := o.Nodes(ctx, org)
+	switch {
+	case errIsNotFound(err):
+		// The zone was already deleted.
+	case err != nil:
+		return err
+	case n > 0:
+		return fmt.Errorf("%w: %d nodes in %s", ErrOrgHasNodes, n, dnsname.OrgZone(org, o.Project, o.Domain))
+	}
+	err = o.dns.DeleteZone(ctx, &dns.ManagedZone{
+		Name:    dnsname.OrgZone(org, o.Project, o.Domain),
+		DnsName: dnsname.OrgDNS(org, o.Project, o.Domain),
+	})
+	if err != nil {
+		log.Println("failed to delete zone:", dnsname.OrgZone(org, o.Project, o.Domain), err)
+		return err
+	}
+	err = o.keys.DeleteKey(ctx, o.sam.Namer.GetAPIKeyID(org))
+	if err != nil && !errIsNotFound(err) {
+		log.Println("failed to delete api key:", o.sam.Namer.GetAPIKeyID(org), err)
+		return err
+	}
+	err = o.RemovePolicy(ctx, org)
+	if err != nil {
+		return err
+	}
+	err = o.sm.DeleteSecret(ctx, org)
+	if err != nil {
+		return err
+	}
+	return o.sam.DeleteServiceAccount(ctx, org)
+}
+
+// RemovePolicy removes the org service account from all bindings of the
+// project IAM policy, e.g. those added by ApplyPolicy. Members of a service
+// account that was deleted before its bindings, e.g.
+// "deleted:serviceAccount:<email>?uid=<id>", are removed as well.
+// NOTE: By operating on project IAM policies, this method modifies project wide state.
+func (o *Org) RemovePolicy(ctx context.Context, org string) error {
+	req := &cloudresourcemanager.GetIamPolicyRequest{
+		Options: &cloudresourcemanager.GetPolicyOptions{
+			RequestedPolicyVersion: 3,
+		},
+	}
+	curr, err := o.crm.GetIamPolicy(ctx, req)
+	if err != nil {
+		log.Println("get policy", err)
+		return err
+	}
+	member := "serviceAccount:" + o.sam.Namer.GetServiceAccountEmail(org)
+	isMember := func(m string) bool {
+		return m == member || strings.HasPrefix(m, "deleted:"+member+"?uid=")
+	}
+	bindings := []*cloudresourcemanager.Binding{}
+	found := false
+	for _, b := range curr.Bindings {
+		if !slices.ContainsFunc(b.Members, isMember) {
+			bindings = append(bindings, b)
+			continue
+		}
+		found = true
+		members := slices.DeleteFunc(slices.Clone(b.Members), isMember)
+		if len(members) > 0 {
+			nb := *b
+			nb.Members = members
+			bindings = append(bindings, &nb)
+		}
+	}
+	if !found {
+		return nil
+	}
+	preq := &cloudresourcemanager.SetIamPolicyRequest{
+		Policy: &cloudresourcemanager.Policy{
+			AuditConfigs: curr.AuditConfigs,
+			Bindings:     bindings,
+			Etag:         curr.Etag,
+			Version:      curr.Version,
+		},
+	}
+	err = o.crm.SetIamPolicy(ctx, preq)
+	if err != nil {
+		log.Println("set policy", err)
+		return err
+	}
+	return nil
+}
+
 // RegisterDNS creates the organization zone and the zone split within the project zone.
+// With WithNameServers, only the zone split is created.
 func (o *Org) RegisterDNS(ctx context.Context, org string) error {
+	if len(o.nameServers) > 0 {
+		// Delegated zones are served by the organization, which also signs
+		// them, so there is no zone or DS record to create.
+		_, err := o.dns.RegisterDelegation(ctx, &dns.ManagedZone{
+			Name:    dnsname.OrgZone(org, o.Project, o.Domain),
+			DnsName: dnsname.OrgDNS(org, o.Project, o.Domain),
+		}, o.nameServers)
+		if err != nil {
+			log.Println("failed to register delegation:", dnsname.OrgZone(org, o.Project, o.Domain), err)
+		}
+		return err
+	}
 	zone, err := o.dns.RegisterZone(ctx, &dns.ManagedZone{
 		Description: "Autojoin registered nodes from org: " + org,
-		Name:        dnsname.OrgZone(org, o.Project),
-		DnsName:     dnsname.OrgDNS(org, o.Project),
+		Name:        dnsname.OrgZone(org, o.Project, o.Domain),
+		DnsName:     dnsname.OrgDNS(org, o.Project, o.Domain),
 		DnssecConfig: &dns.ManagedZoneDnsSecConfig{
 			State: "on",
 		},
 	})
 	if err != nil {
-		log.Println("failed to register zone:", dnsname.OrgZone(org, o.Project), err)
+		log.Println("failed to register zone:", dnsname.OrgZone(org, o.Project, o.Domain), err)
 		return err
 	}
 	_, err = o.dns.RegisterZoneSplit(ctx, zone)
 	if err != nil {
-		log.Println("failed to register zone split:", dnsname.OrgZone(org, o.Project), err)
+		log.Println("failed to register zone split:", dnsname.OrgZone(org, o.Project, o.Domain), err)
+		return err
+	}
+	// Keys of a new zone may not be active yet, so a missing key signing key
+	// is reported by the DNSSEC check rather than failing the registration.
+	_, err = o.dns.RegisterDS(ctx, zone)
+	if err != nil && !errors.Is(err, dnsx.ErrNoKeySigningKey) {
+		log.Println("failed to register ds record:", dnsname.OrgZone(org, o.Project, o.Domain), err)
 		return err
 	}
+	problems, err := o.CheckDNSSEC(ctx, org)
+	if err != nil {
+		log.Println("failed to check dnssec:", dnsname.OrgZone(org, o.Project, o.Domain), err)
+		return nil
+	}
+	for _, p := range problems {
+		log.Println("dnssec problem:", dnsname.OrgZone(org, o.Project, o.Domain), p)
+	}
 	return nil
 }
 
 // ApplyPolicy adds write restrictions for shared GCS buckets.
 // NOTE: By operating on project IAM policies, this method modifies project wide state.
 func (o *Org) ApplyPolicy(ctx context.Context, org string, account *iam.ServiceAccount, updateTables bool) error {
+	return o.addBindings(ctx, o.policyBindings(org, account, updateTables)...)
+}
+
+// addBindings adds the given bindings to the project IAM policy, unless they
+// are present already.
+func (o *Org) addBindings(ctx context.Context, bindings ...*cloudresourcemanager.Binding) error {
 	// Get current policy.
 	req := &cloudresourcemanager.GetIamPolicyRequest{
 		Options: &cloudresourcemanager.GetPolicyOptions{
@@ -130,6 +303,32 @@ func (o *Org) ApplyPolicy(ctx context.Context, org string, account *iam.ServiceA
 		log.Println("get policy", err)
 		return err
 	}
+
+	// Append the new bindings if missing from the current set.
+	newBindings, wasMissing := appendBindingIfMissing(curr.Bindings, bindings...)
+
+	// Apply bindings if any were missing.
+	preq := &cloudresourcemanager.SetIamPolicyRequest{
+		Policy: &cloudresourcemanager.Policy{
+			AuditConfigs: curr.AuditConfigs,
+			Bindings:     newBindings,
+			Etag:         curr.Etag,
+			Version:      curr.Version,
+		},
+	}
+
+	if wasMissing {
+		err = o.crm.SetIamPolicy(ctx, preq)
+		if err != nil {
+			log.Println("set policy", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// policyBindings returns the project IAM bindings added by ApplyPolicy.
+func (o *Org) policyBindings(org string, account *iam.ServiceAccount, updateTables bool) []*cloudresourcemanager.Binding {
 	expression := ""
 	role := ""
 	if updateTables {
@@ -141,8 +340,7 @@ func (o *Org) ApplyPolicy(ctx context.Context, org string, account *iam.ServiceA
 		expression = fmt.Sprintf(expUploadFmt, o.Project, org, o.Project, org)
 		role = "roles/storage.objectCreator"
 	}
-	// Setup new bindings.
-	bindings := []*cloudresourcemanager.Binding{
+	return []*cloudresourcemanager.Binding{
 		{
 			Condition: &cloudresourcemanager.Expr{
 				Title:      "Upload restriction for " + org,
@@ -160,28 +358,6 @@ func (o *Org) ApplyPolicy(ctx context.Context, org string, account *iam.ServiceA
 			Role:    "roles/storage.objectViewer",
 		},
 	}
-
-	// Append the new bindings if missing from the current set.
-	newBindings, wasMissing := appendBindingIfMissing(curr.Bindings, bindings...)
-
-	// Apply bindings if any were missing.
-	preq := &cloudresourcemanager.SetIamPolicyRequest{
-		Policy: &cloudresourcemanager.Policy{
-			AuditConfigs: curr.AuditConfigs,
-			Bindings:     newBindings,
-			Etag:         curr.Etag,
-			Version:      curr.Version,
-		},
-	}
-
-	if wasMissing {
-		err = o.crm.SetIamPolicy(ctx, preq)
-		if err != nil {
-			log.Println("set policy", err)
-			return err
-		}
-	}
-	return nil
 }
 
 func appendBindingIfMissing(slice []*cloudresourcemanager.Binding, elems ...*cloudresourcemanager.Binding) ([]*cloudresourcemanager.Binding, bool) {
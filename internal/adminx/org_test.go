@@ -2,14 +2,18 @@ package adminx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"reflect"
 	"strings"
 	"testing"
 
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"golang.org/x/exp/slices"
 	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/dns/v1"
 	"google.golang.org/api/iam/v1"
@@ -26,6 +30,10 @@ type fakeCRM struct {
 	setPolicyErr error
 	bindingCount int
 	policy       *cloudresourcemanager.Policy
+	project      *cloudresourcemanager.Project
+	projectErr   error
+	granted      []string
+	grantedErr   error
 }
 
 func (f *fakeCRM) GetIamPolicy(ctx context.Context, req *cloudresourcemanager.GetIamPolicyRequest) (*cloudresourcemanager.Policy, error) {
@@ -38,30 +46,109 @@ func (f *fakeCRM) SetIamPolicy(ctx context.Context, req *cloudresourcemanager.Se
 	return f.setPolicyErr
 }
 
+func (f *fakeCRM) GetProject(ctx context.Context) (*cloudresourcemanager.Project, error) {
+	return f.project, f.projectErr
+}
+
+func (f *fakeCRM) TestIamPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	return f.granted, f.grantedErr
+}
+
 type fakeDNS struct {
 	regZone     *dns.ManagedZone
 	regZoneErr  error
 	regSplit    *dns.ResourceRecordSet
 	regSplitErr error
+	regCalls    int
+	zoneErr     error
+	splitErr    error
+	count       int
+	countErr    error
+	deleteErr   error
+	deleted     []string
+	regDSErr    error
+	problems    []string
+	checkErr    error
+	zones       []*dns.ManagedZone
+	listErr     error
+	counts      map[string]int
+	split       *dns.ResourceRecordSet
+	delegated   []string
+	delegErr    error
 }
 
 func (f *fakeDNS) RegisterZone(ctx context.Context, zone *dns.ManagedZone) (*dns.ManagedZone, error) {
+	f.regCalls++
 	return f.regZone, f.regZoneErr
 }
 
+func (f *fakeDNS) GetZone(ctx context.Context, zoneName string) (*dns.ManagedZone, error) {
+	return &dns.ManagedZone{Name: zoneName}, f.zoneErr
+}
+
+func (f *fakeDNS) GetZoneSplit(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error) {
+	if f.split != nil {
+		return f.split, f.splitErr
+	}
+	return &dns.ResourceRecordSet{Name: zone.DnsName}, f.splitErr
+}
+
 func (f *fakeDNS) RegisterZoneSplit(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error) {
 	return f.regSplit, f.regSplitErr
 }
 
+func (f *fakeDNS) ListZones(ctx context.Context) ([]*dns.ManagedZone, error) {
+	return f.zones, f.listErr
+}
+
+func (f *fakeDNS) CountRecords(ctx context.Context, zoneName string) (int, error) {
+	if f.counts != nil {
+		return f.counts[zoneName], f.countErr
+	}
+	return f.count, f.countErr
+}
+
+func (f *fakeDNS) DeleteZone(ctx context.Context, zone *dns.ManagedZone) error {
+	f.deleted = append(f.deleted, zone.Name)
+	return f.deleteErr
+}
+
+func (f *fakeDNS) RegisterDS(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error) {
+	return &dns.ResourceRecordSet{Type: "DS"}, f.regDSErr
+}
+
+func (f *fakeDNS) RegisterDelegation(ctx context.Context, zone *dns.ManagedZone, servers []string) (*dns.ResourceRecordSet, error) {
+	f.delegated = servers
+	return &dns.ResourceRecordSet{Type: "NS", Rrdatas: servers}, f.delegErr
+}
+
+func (f *fakeDNS) CheckDNSSEC(ctx context.Context, zone *dns.ManagedZone) ([]string, error) {
+	return f.problems, f.checkErr
+}
+
 type fakeAPIKeys struct {
 	createKey    string
 	createKeyErr error
+	created      int
+	getKeyErr    error
+	deleteErr    error
+	deleted      []string
+}
+
+func (f *fakeAPIKeys) DeleteKey(ctx context.Context, id string) error {
+	f.deleted = append(f.deleted, id)
+	return f.deleteErr
 }
 
 func (f *fakeAPIKeys) CreateKey(ctx context.Context, org string) (string, error) {
+	f.created++
 	return f.createKey, f.createKeyErr
 }
 
+func (f *fakeAPIKeys) GetKey(ctx context.Context, org string) (string, error) {
+	return f.createKey, f.getKeyErr
+}
+
 func TestOrg_Setup(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -98,8 +185,8 @@ func TestOrg_Setup(t *testing.T) {
 			},
 			dns: &fakeDNS{
 				regZone: &dns.ManagedZone{
-					Name:    dnsname.OrgZone("foo", "mlab-foo"),
-					DnsName: dnsname.OrgDNS("foo", "mlab-foo"),
+					Name:    dnsname.OrgZone("foo", "mlab-foo", dnsname.DefaultDomain),
+					DnsName: dnsname.OrgDNS("foo", "mlab-foo", dnsname.DefaultDomain),
 				},
 			},
 			keys: &fakeAPIKeys{
@@ -154,13 +241,76 @@ func TestOrg_Setup(t *testing.T) {
 			},
 			dns: &fakeDNS{
 				regZone: &dns.ManagedZone{
-					Name:    dnsname.OrgZone("foo", "mlab-foo"),
-					DnsName: dnsname.OrgDNS("foo", "mlab-foo"),
+					Name:    dnsname.OrgZone("foo", "mlab-foo", dnsname.DefaultDomain),
+					DnsName: dnsname.OrgDNS("foo", "mlab-foo", dnsname.DefaultDomain),
 				},
 				regSplitErr: fmt.Errorf("fake split register error"),
 			},
 			wantErr: true,
 		},
+		{
+			name: "success-no-key-signing-key",
+			crm: &fakeCRM{
+				getPolicy: &cloudresourcemanager.Policy{
+					Bindings: []*cloudresourcemanager.Binding{
+						{
+							Members: []string{"foo"},
+							Role:    "roles/fooWriter",
+						},
+					},
+				},
+			},
+			sam: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{
+					Name: "foo",
+				},
+			},
+			smc: &fakeSMC{
+				getSec: &secretmanagerpb.Secret{Name: "okay"},
+			},
+			dns: &fakeDNS{
+				regZone: &dns.ManagedZone{
+					Name:    dnsname.OrgZone("foo", "mlab-foo", dnsname.DefaultDomain),
+					DnsName: dnsname.OrgDNS("foo", "mlab-foo", dnsname.DefaultDomain),
+				},
+				regDSErr: dnsx.ErrNoKeySigningKey,
+				problems: []string{"no active key signing key"},
+			},
+			keys: &fakeAPIKeys{
+				createKey: "this-is-a-fake-key",
+			},
+			bindingCount: 3,
+		},
+		{
+			name: "error-register-ds",
+			crm: &fakeCRM{
+				getPolicy: &cloudresourcemanager.Policy{
+					Bindings: []*cloudresourcemanager.Binding{
+						{
+							Members: []string{"foo"},
+							Role:    "roles/fooWriter",
+						},
+					},
+				},
+			},
+			sam: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{
+					Name: "foo",
+				},
+			},
+			smc: &fakeSMC{
+				getSec: &secretmanagerpb.Secret{Name: "okay"},
+			},
+			dns: &fakeDNS{
+				regZone: &dns.ManagedZone{
+					Name:    dnsname.OrgZone("foo", "mlab-foo", dnsname.DefaultDomain),
+					DnsName: dnsname.OrgDNS("foo", "mlab-foo", dnsname.DefaultDomain),
+				},
+				regDSErr: fmt.Errorf("fake ds register error"),
+				problems: []string{"no active key signing key"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "success-equal-bindings",
 			crm: &fakeCRM{
@@ -191,8 +341,8 @@ func TestOrg_Setup(t *testing.T) {
 			},
 			dns: &fakeDNS{
 				regZone: &dns.ManagedZone{
-					Name:    dnsname.OrgZone("foo", "mlab-foo"),
-					DnsName: dnsname.OrgDNS("foo", "mlab-foo"),
+					Name:    dnsname.OrgZone("foo", "mlab-foo", dnsname.DefaultDomain),
+					DnsName: dnsname.OrgDNS("foo", "mlab-foo", dnsname.DefaultDomain),
 				},
 			},
 			keys: &fakeAPIKeys{
@@ -283,8 +433,8 @@ func TestOrg_Setup(t *testing.T) {
 			},
 			dns: &fakeDNS{
 				regZone: &dns.ManagedZone{
-					Name:    dnsname.OrgZone("foo", "mlab-foo"),
-					DnsName: dnsname.OrgDNS("foo", "mlab-foo"),
+					Name:    dnsname.OrgZone("foo", "mlab-foo", dnsname.DefaultDomain),
+					DnsName: dnsname.OrgDNS("foo", "mlab-foo", dnsname.DefaultDomain),
 				},
 			},
 			keys: &fakeAPIKeys{
@@ -385,3 +535,172 @@ func TestBindingIsEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestOrg_Teardown(t *testing.T) {
+	member := "serviceAccount:autonode-foo@mlab-foo.iam.gserviceaccount.com"
+	policy := func() *cloudresourcemanager.Policy {
+		return &cloudresourcemanager.Policy{
+			Bindings: []*cloudresourcemanager.Binding{
+				{Members: []string{member}, Role: "roles/storage.objectCreator"},
+				{Members: []string{"user:other", member}, Role: "roles/storage.objectViewer"},
+				{Members: []string{"user:other"}, Role: "roles/fooWriter"},
+			},
+		}
+	}
+	tests := []struct {
+		name         string
+		crm          *fakeCRM
+		dns          *fakeDNS
+		keys         *fakeAPIKeys
+		iams         *fakeIAMService
+		smc          *fakeSMC
+		wantBindings int
+		wantDeleted  bool
+		wantErr      error
+		wantAnyErr   bool
+	}{
+		{
+			name:         "success",
+			crm:          &fakeCRM{getPolicy: policy()},
+			dns:          &fakeDNS{},
+			keys:         &fakeAPIKeys{},
+			iams:         &fakeIAMService{},
+			smc:          &fakeSMC{},
+			wantBindings: 2,
+			wantDeleted:  true,
+		},
+		{
+			name: "success-deleted-service-account",
+			crm: &fakeCRM{getPolicy: &cloudresourcemanager.Policy{
+				Bindings: []*cloudresourcemanager.Binding{
+					{Members: []string{"deleted:" + member + "?uid=123"}, Role: "roles/storage.objectCreator"},
+					{Members: []string{"user:other", "deleted:" + member + "?uid=123"}, Role: "roles/storage.objectViewer"},
+				},
+			}},
+			dns:          &fakeDNS{},
+			keys:         &fakeAPIKeys{},
+			iams:         &fakeIAMService{delAcctErr: createNotFoundErr()},
+			smc:          &fakeSMC{},
+			wantBindings: 1,
+			wantDeleted:  true,
+		},
+		{
+			name: "success-already-deleted",
+			crm: &fakeCRM{getPolicy: &cloudresourcemanager.Policy{
+				Bindings: []*cloudresourcemanager.Binding{{Members: []string{"user:other"}, Role: "roles/fooWriter"}},
+			}},
+			dns:         &fakeDNS{countErr: createNotFoundErr()},
+			keys:        &fakeAPIKeys{deleteErr: createNotFoundErr()},
+			iams:        &fakeIAMService{delAcctErr: createNotFoundErr()},
+			smc:         &fakeSMC{deleteSecErr: createNotFoundErr()},
+			wantDeleted: true,
+		},
+		{
+			name:    "error-has-nodes",
+			crm:     &fakeCRM{getPolicy: policy()},
+			dns:     &fakeDNS{count: 3},
+			keys:    &fakeAPIKeys{},
+			iams:    &fakeIAMService{},
+			smc:     &fakeSMC{},
+			wantErr: ErrOrgHasNodes,
+		},
+		{
+			name:       "error-count",
+			crm:        &fakeCRM{getPolicy: policy()},
+			dns:        &fakeDNS{countErr: fmt.Errorf("fake count error")},
+			keys:       &fakeAPIKeys{},
+			iams:       &fakeIAMService{},
+			smc:        &fakeSMC{},
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-delete-zone",
+			crm:        &fakeCRM{getPolicy: policy()},
+			dns:        &fakeDNS{deleteErr: fmt.Errorf("fake delete error")},
+			keys:       &fakeAPIKeys{},
+			iams:       &fakeIAMService{},
+			smc:        &fakeSMC{},
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-delete-key",
+			crm:        &fakeCRM{getPolicy: policy()},
+			dns:        &fakeDNS{},
+			keys:       &fakeAPIKeys{deleteErr: fmt.Errorf("fake delete error")},
+			iams:       &fakeIAMService{},
+			smc:        &fakeSMC{},
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-get-policy",
+			crm:        &fakeCRM{getPolicyErr: fmt.Errorf("fake policy error")},
+			dns:        &fakeDNS{},
+			keys:       &fakeAPIKeys{},
+			iams:       &fakeIAMService{},
+			smc:        &fakeSMC{},
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-set-policy",
+			crm:        &fakeCRM{getPolicy: policy(), setPolicyErr: fmt.Errorf("fake policy error")},
+			dns:        &fakeDNS{},
+			keys:       &fakeAPIKeys{},
+			iams:       &fakeIAMService{},
+			smc:        &fakeSMC{},
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-delete-secret",
+			crm:        &fakeCRM{getPolicy: policy()},
+			dns:        &fakeDNS{},
+			keys:       &fakeAPIKeys{},
+			iams:       &fakeIAMService{},
+			smc:        &fakeSMC{deleteSecErr: fmt.Errorf("fake delete error")},
+			wantAnyErr: true,
+		},
+		{
+			name:       "error-delete-service-account",
+			crm:        &fakeCRM{getPolicy: policy()},
+			dns:        &fakeDNS{},
+			keys:       &fakeAPIKeys{},
+			iams:       &fakeIAMService{delAcctErr: fmt.Errorf("fake delete error")},
+			smc:        &fakeSMC{},
+			wantAnyErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			sam := NewServiceAccountsManager(tt.iams, n)
+			sm := NewSecretManager(tt.smc, n, sam)
+			o := NewOrg("mlab-foo", tt.crm, sam, sm, tt.dns, tt.keys, false)
+			err := o.Teardown(context.Background(), "foo")
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Org.Teardown() error = %v, want %v", err, tt.wantErr)
+			}
+			if (err != nil) != (tt.wantErr != nil || tt.wantAnyErr) {
+				t.Fatalf("Org.Teardown() error = %v, wantErr %t", err, tt.wantAnyErr)
+			}
+			if !tt.wantDeleted {
+				return
+			}
+			if tt.crm.policy != nil && len(tt.crm.policy.Bindings) != tt.wantBindings {
+				t.Errorf("Org.Teardown() left %d bindings, want %d", len(tt.crm.policy.Bindings), tt.wantBindings)
+			}
+			if tt.crm.policy != nil {
+				for _, b := range tt.crm.policy.Bindings {
+					if slices.Contains(b.Members, member) || slices.Contains(b.Members, "deleted:"+member+"?uid=123") {
+						t.Errorf("Org.Teardown() left member in binding %v", b)
+					}
+				}
+			}
+			want := []string{"projects/mlab-foo/serviceAccounts/autonode-foo@mlab-foo.iam.gserviceaccount.com"}
+			if !reflect.DeepEqual(tt.iams.deletedAccts, want) {
+				t.Errorf("Org.Teardown() deleted accounts = %v, want %v", tt.iams.deletedAccts, want)
+			}
+			if !reflect.DeepEqual(tt.keys.deleted, []string{"autojoin-key-foo"}) {
+				t.Errorf("Org.Teardown() deleted keys = %v", tt.keys.deleted)
+			}
+		})
+	}
+}
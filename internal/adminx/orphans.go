@@ -0,0 +1,61 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"google.golang.org/api/dns/v1"
+)
+
+// OrphanedZone is an organization zone of the project without a backing
+// organization, e.g. left behind by a deleted or renamed organization.
+type OrphanedZone struct {
+	// Org is the organization named by the zone.
+	Org  string
+	Zone *dns.ManagedZone
+	// Nodes is the number of nodes registered in the zone.
+	Nodes int
+}
+
+// OrphanedZones returns the organization zones of the project and domain
+// whose organization is not one of orgs, sorted by zone name.
+func (o *Org) OrphanedZones(ctx context.Context, orgs []string) ([]OrphanedZone, error) {
+	zones, err := o.dns.ListZones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, org := range orgs {
+		known[org] = true
+	}
+	result := []OrphanedZone{}
+	for _, z := range zones {
+		org, ok := dnsname.ZoneOrg(z.Name, o.Project, o.Domain)
+		if !ok || known[org] {
+			continue
+		}
+		n, err := o.dns.CountRecords(ctx, z.Name)
+		if err != nil {
+			return nil, fmt.Errorf("count records of %s: %w", z.Name, err)
+		}
+		result = append(result, OrphanedZone{Org: org, Zone: z, Nodes: n})
+	}
+	return result, nil
+}
+
+// DeleteOrphanedZone deletes the zone split and the zone of an orphaned zone.
+// Like Teardown, it refuses to delete zones with registered nodes.
+func (o *Org) DeleteOrphanedZone(ctx context.Context, z OrphanedZone) error {
+	// Nodes may have registered since the zone was listed.
+	n, err := o.dns.CountRecords(ctx, z.Zone.Name)
+	switch {
+	case errIsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	case n > 0:
+		return fmt.Errorf("%w: %d nodes in %s", ErrOrgHasNodes, n, z.Zone.Name)
+	}
+	return o.dns.DeleteZone(ctx, z.Zone)
+}
@@ -0,0 +1,115 @@
+package adminx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/dns/v1"
+)
+
+func TestOrg_OrphanedZones(t *testing.T) {
+	zones := []*dns.ManagedZone{
+		{Name: "autojoin-bar-foo-measurement-lab-org"},
+		{Name: "autojoin-baz-foo-measurement-lab-org"},
+		{Name: "autojoin-foo-measurement-lab-org"},
+		{Name: "autojoin-mlab-foo-measurement-lab-org"},
+		{Name: "autojoin-qux-staging-measurement-lab-org"},
+	}
+	tests := []struct {
+		name    string
+		dns     *fakeDNS
+		orgs    []string
+		want    []OrphanedZone
+		wantErr bool
+	}{
+		{
+			name: "success",
+			dns: &fakeDNS{
+				zones:  zones,
+				counts: map[string]int{"autojoin-baz-foo-measurement-lab-org": 2},
+			},
+			orgs: []string{"mlab"},
+			want: []OrphanedZone{
+				{Org: "bar", Zone: zones[0]},
+				{Org: "baz", Zone: zones[1], Nodes: 2},
+			},
+		},
+		{
+			name: "success-no-orphans",
+			dns:  &fakeDNS{zones: zones},
+			orgs: []string{"bar", "baz", "mlab"},
+			want: []OrphanedZone{},
+		},
+		{
+			name:    "error-list",
+			dns:     &fakeDNS{listErr: fmt.Errorf("fake list error")},
+			wantErr: true,
+		},
+		{
+			name:    "error-count",
+			dns:     &fakeDNS{zones: zones, countErr: fmt.Errorf("fake count error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOrg("mlab-foo", &fakeCRM{}, nil, nil, tt.dns, &fakeAPIKeys{}, false)
+			got, err := o.OrphanedZones(context.Background(), tt.orgs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.OrphanedZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Org.OrphanedZones() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrg_DeleteOrphanedZone(t *testing.T) {
+	zone := &dns.ManagedZone{Name: "autojoin-bar-foo-measurement-lab-org"}
+	tests := []struct {
+		name        string
+		dns         *fakeDNS
+		wantDeleted []string
+		wantErr     error
+	}{
+		{
+			name:        "success",
+			dns:         &fakeDNS{},
+			wantDeleted: []string{zone.Name},
+		},
+		{
+			name: "success-already-deleted",
+			dns:  &fakeDNS{countErr: createNotFoundErr()},
+		},
+		{
+			name:    "error-has-nodes",
+			dns:     &fakeDNS{count: 1},
+			wantErr: ErrOrgHasNodes,
+		},
+		{
+			name:        "error-delete",
+			dns:         &fakeDNS{deleteErr: fmt.Errorf("fake delete error")},
+			wantDeleted: []string{zone.Name},
+			wantErr:     errors.New("any"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOrg("mlab-foo", &fakeCRM{}, nil, nil, tt.dns, &fakeAPIKeys{}, false)
+			err := o.DeleteOrphanedZone(context.Background(), OrphanedZone{Org: "bar", Zone: zone})
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Org.DeleteOrphanedZone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrOrgHasNodes && !errors.Is(err, ErrOrgHasNodes) {
+				t.Errorf("Org.DeleteOrphanedZone() error = %v, want %v", err, ErrOrgHasNodes)
+			}
+			if !reflect.DeepEqual(tt.dns.deleted, tt.wantDeleted) {
+				t.Errorf("Org.DeleteOrphanedZone() deleted %v, want %v", tt.dns.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package adminx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"golang.org/x/exp/slices"
+)
+
+// ErrKeyNotFound is returned when an organization has no stored key.
+var ErrKeyNotFound = errors.New("service account key not found")
+
+// Rotator replaces the service account keys stored by a SecretManager.
+type Rotator struct {
+	sm     *SecretManager
+	maxAge time.Duration
+}
+
+// NewRotator creates a new Rotator that deletes keys older than maxAge after
+// each rotation.
+func NewRotator(sm *SecretManager, maxAge time.Duration) *Rotator {
+	return &Rotator{sm: sm, maxAge: maxAge}
+}
+
+// Age returns the time since the current key of org was stored. Orgs without
+// a stored key return ErrKeyNotFound.
+func (r *Rotator) Age(ctx context.Context, org string) (time.Duration, error) {
+	v, err := r.latest(ctx, org)
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(v.CreateTime.AsTime()), nil
+}
+
+// Rotate creates a new service account key for org, stores it as a new secret
+// version, and disables the previous version. Keys older than the maximum age
+// are then deleted, except the new key and the key of the previous version,
+// which nodes may use until they register again.
+func (r *Rotator) Rotate(ctx context.Context, org string) error {
+	prev, err := r.latest(ctx, org)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+	// Without the previous key ID, no keys can be safely deleted.
+	prevID, idErr := "", error(nil)
+	if prev != nil {
+		prevID, idErr = r.keyID(ctx, org)
+	}
+
+	k, err := r.sm.sam.CreateKey(ctx, org)
+	if err != nil {
+		return err
+	}
+	// NOTE: key is already base64 encoded.
+	payload, err := r.sm.payload(ctx, org, k.PrivateKeyData)
+	var v *secretmanagerpb.SecretVersion
+	if err == nil {
+		addReq := &secretmanagerpb.AddSecretVersionRequest{
+			Parent: r.sm.Namer.GetSecretName(org),
+			Payload: &secretmanagerpb.SecretPayload{
+				Data: payload,
+			},
+		}
+		v, err = r.sm.smc.AddSecretVersion(ctx, addReq)
+	}
+	if err != nil {
+		log.Printf("Storing key failed for %q: %v", r.sm.Namer.GetSecretName(org), err)
+		if derr := r.sm.sam.DeleteKey(ctx, k.Name); derr != nil {
+			log.Printf("Failed to delete unstored key %q: %v", k.Name, derr)
+		}
+		return err
+	}
+	log.Println("Rotated key:", v.Name)
+	if prev != nil {
+		_, err = r.sm.smc.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: prev.Name})
+		if err != nil {
+			log.Printf("DisableSecretVersion failed for %q: %v", prev.Name, err)
+			return err
+		}
+	}
+	if idErr != nil {
+		log.Printf("Not deleting old keys of %s; previous key is unknown: %v", org, idErr)
+		return nil
+	}
+	return r.deleteOld(ctx, org, path.Base(k.Name), prevID)
+}
+
+// deleteOld deletes keys of org older than maxAge, except the given keys.
+func (r *Rotator) deleteOld(ctx context.Context, org string, keep ...string) error {
+	keys, err := r.sm.sam.ListKeys(ctx, org)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		id := path.Base(k.Name)
+		if slices.Contains(keep, id) {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, k.ValidAfterTime)
+		if err != nil || time.Since(created) < r.maxAge {
+			continue
+		}
+		if err := r.sm.sam.DeleteKey(ctx, k.Name); err != nil {
+			return err
+		}
+		metrics.KeysDeleted.Inc()
+	}
+	return nil
+}
+
+// latest returns the current secret version of org.
+func (r *Rotator) latest(ctx context.Context, org string) (*secretmanagerpb.SecretVersion, error) {
+	req := &secretmanagerpb.GetSecretVersionRequest{
+		Name: r.sm.Namer.GetSecretName(org) + "/versions/" + r.sm.version,
+	}
+	v, err := r.sm.smc.GetSecretVersion(ctx, req)
+	switch {
+	case errIsNotFound(err):
+		return nil, ErrKeyNotFound
+	case err != nil:
+		return nil, err
+	}
+	return v, nil
+}
+
+// keyID returns the ID of the current key of org, read from the
+// private_key_id of the stored credentials file.
+func (r *Rotator) keyID(ctx context.Context, org string) (string, error) {
+	key, err := r.sm.LoadKey(ctx, org)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	creds := struct {
+		PrivateKeyID string `json:"private_key_id"`
+	}{}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return "", err
+	}
+	if creds.PrivateKeyID == "" {
+		return "", fmt.Errorf("credentials have no private_key_id")
+	}
+	return creds.PrivateKeyID, nil
+}
@@ -0,0 +1,190 @@
+package adminx
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRotator_Rotate(t *testing.T) {
+	saName := "projects/mlab-foo/serviceAccounts/autonode-bar@mlab-foo.iam.gserviceaccount.com"
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	young := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	creds := base64.StdEncoding.EncodeToString([]byte(`{"private_key_id": "prev"}`))
+	keys := []*iam.ServiceAccountKey{
+		{Name: saName + "/keys/new", ValidAfterTime: young},
+		{Name: saName + "/keys/prev", ValidAfterTime: old},
+		{Name: saName + "/keys/old", ValidAfterTime: old},
+		{Name: saName + "/keys/young", ValidAfterTime: young},
+	}
+	prevVersion := &secretmanagerpb.SecretVersion{Name: "projects/mlab-foo/secrets/autojoin-serviceaccount-key-bar/versions/1"}
+	tests := []struct {
+		name         string
+		smc          *fakeSMC
+		iams         *fakeIAMService
+		kms          KMSService
+		wantDisabled []string
+		wantDeleted  []string
+		wantErr      bool
+	}{
+		{
+			name: "success",
+			smc: &fakeSMC{
+				getSecVer:    prevVersion,
+				accessSecVer: &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte(creds)}},
+				addSecVer:    &secretmanagerpb.SecretVersion{Name: "versions/2"},
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+				keys:    keys,
+			},
+			wantDisabled: []string{prevVersion.Name},
+			wantDeleted:  []string{saName + "/keys/old"},
+		},
+		{
+			name: "success-first-key",
+			smc: &fakeSMC{
+				getSecVerErr: createNotFoundErr(),
+				addSecVer:    &secretmanagerpb.SecretVersion{Name: "versions/1"},
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+				keys:    keys,
+			},
+			wantDeleted: []string{saName + "/keys/prev", saName + "/keys/old"},
+		},
+		{
+			name: "success-previous-key-unknown",
+			smc: &fakeSMC{
+				getSecVer:    prevVersion,
+				accessSecVer: &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte("invalid")}},
+				addSecVer:    &secretmanagerpb.SecretVersion{Name: "versions/2"},
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+				keys:    keys,
+			},
+			wantDisabled: []string{prevVersion.Name},
+		},
+		{
+			name: "error-get-version",
+			smc: &fakeSMC{
+				getSecVerErr: fmt.Errorf("fake get error"),
+			},
+			iams:    &fakeIAMService{},
+			wantErr: true,
+		},
+		{
+			name: "error-create-key",
+			smc: &fakeSMC{
+				getSecVerErr: createNotFoundErr(),
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				keyErr:  fmt.Errorf("fake create error"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-add-version-deletes-key",
+			smc: &fakeSMC{
+				getSecVerErr: createNotFoundErr(),
+				addSecVerErr: fmt.Errorf("fake add error"),
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+			},
+			wantDeleted: []string{saName + "/keys/new"},
+			wantErr:     true,
+		},
+		{
+			name: "error-encrypt-deletes-key",
+			smc: &fakeSMC{
+				getSecVerErr: createNotFoundErr(),
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+			},
+			kms:         &fakeKMS{encErr: fmt.Errorf("fake encrypt error")},
+			wantDeleted: []string{saName + "/keys/new"},
+			wantErr:     true,
+		},
+		{
+			name: "error-disable-version",
+			smc: &fakeSMC{
+				getSecVer:    prevVersion,
+				accessSecVer: &secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte(creds)}},
+				addSecVer:    &secretmanagerpb.SecretVersion{Name: "versions/2"},
+				disableErr:   fmt.Errorf("fake disable error"),
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+			},
+			wantDisabled: []string{prevVersion.Name},
+			wantErr:      true,
+		},
+		{
+			name: "error-list-keys",
+			smc: &fakeSMC{
+				getSecVerErr: createNotFoundErr(),
+				addSecVer:    &secretmanagerpb.SecretVersion{Name: "versions/1"},
+			},
+			iams: &fakeIAMService{
+				getAcct: &iam.ServiceAccount{Name: saName},
+				key:     &iam.ServiceAccountKey{Name: saName + "/keys/new", PrivateKeyData: "fake"},
+				listErr: fmt.Errorf("fake list error"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			sm := NewSecretManager(tt.smc, n, NewServiceAccountsManager(tt.iams, n))
+			if tt.kms != nil {
+				sm.EncryptWith(tt.kms, "fake-key")
+			}
+			r := NewRotator(sm, 24*time.Hour)
+			err := r.Rotate(context.Background(), "bar")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Rotator.Rotate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.smc.disabled, tt.wantDisabled) {
+				t.Errorf("Rotator.Rotate() disabled wrong versions; got %v, want %v", tt.smc.disabled, tt.wantDisabled)
+			}
+			if !reflect.DeepEqual(tt.iams.deleted, tt.wantDeleted) {
+				t.Errorf("Rotator.Rotate() deleted wrong keys; got %v, want %v", tt.iams.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestRotator_Age(t *testing.T) {
+	n := NewNamer("mlab-foo")
+	smc := &fakeSMC{
+		getSecVer: &secretmanagerpb.SecretVersion{CreateTime: timestamppb.New(time.Now().Add(-time.Hour))},
+	}
+	r := NewRotator(NewSecretManager(smc, n, nil), 24*time.Hour)
+	age, err := r.Age(context.Background(), "bar")
+	if err != nil || age < time.Hour || age > 2*time.Hour {
+		t.Errorf("Rotator.Age() = %v, %v; want 1h, nil", age, err)
+	}
+	smc.getSecVerErr = createNotFoundErr()
+	if _, err := r.Age(context.Background(), "bar"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Rotator.Age() returned wrong error; got %v, want %v", err, ErrKeyNotFound)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/googleapis/gax-go/v2/apierror"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/iam/v1"
 	"google.golang.org/grpc/codes"
 )
@@ -17,6 +20,10 @@ type IAMService interface {
 	GetServiceAccount(ctx context.Context, saName string) (*iam.ServiceAccount, error)
 	CreateServiceAccount(ctx context.Context, projName string, req *iam.CreateServiceAccountRequest) (*iam.ServiceAccount, error)
 	CreateKey(ctx context.Context, saName string, req *iam.CreateServiceAccountKeyRequest) (*iam.ServiceAccountKey, error)
+	DeleteKey(ctx context.Context, keyName string) error
+	ListKeys(ctx context.Context, saName string) ([]*iam.ServiceAccountKey, error)
+	ListServiceAccounts(ctx context.Context, projName string) ([]*iam.ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, saName string) error
 }
 
 // ServiceAccountsManager contains resources needed for managing service accounts.
@@ -67,8 +74,15 @@ func (s *ServiceAccountsManager) CreateServiceAccount(ctx context.Context, org s
 	return account, nil
 }
 
+// GetServiceAccount returns the service account associated with org.
+func (s *ServiceAccountsManager) GetServiceAccount(ctx context.Context, org string) (*iam.ServiceAccount, error) {
+	return s.iams.GetServiceAccount(ctx, s.Namer.GetServiceAccountName(org))
+}
+
 // CreateKey creates and returns a key for the service account associated with org.
-func (s *ServiceAccountsManager) CreateKey(ctx context.Context, org string) (*iam.ServiceAccountKey, error) {
+func (s *ServiceAccountsManager) CreateKey(ctx context.Context, org string) (_ *iam.ServiceAccountKey, err error) {
+	ctx, span := tracing.Start(ctx, "adminx.CreateKey", attribute.String("org", org))
+	defer func() { tracing.End(span, err) }()
 	// Get Service Account, which should have been setup during Org registration.
 	account, err := s.iams.GetServiceAccount(ctx, s.Namer.GetServiceAccountName(org))
 	switch {
@@ -94,6 +108,60 @@ func (s *ServiceAccountsManager) CreateKey(ctx context.Context, org string) (*ia
 	return key, nil
 }
 
+// DeleteKey deletes the service account key with the given resource name, e.g.
+// a key returned by CreateKey. Keys that do not exist are ignored.
+func (s *ServiceAccountsManager) DeleteKey(ctx context.Context, keyName string) error {
+	log.Printf("Deleting service account key: %q", keyName)
+	err := s.iams.DeleteKey(ctx, keyName)
+	if err != nil && !errIsNotFound(err) {
+		log.Printf("DeleteKey failed for %q: %v", keyName, err)
+		return fmt.Errorf("DeleteKey(%s): %w", keyName, err)
+	}
+	return nil
+}
+
+// ListKeys returns the user managed keys of the service account associated
+// with org.
+func (s *ServiceAccountsManager) ListKeys(ctx context.Context, org string) ([]*iam.ServiceAccountKey, error) {
+	keys, err := s.iams.ListKeys(ctx, s.Namer.GetServiceAccountName(org))
+	if err != nil {
+		log.Printf("ListKeys failed for %q: %v", s.Namer.GetServiceAccountName(org), err)
+		return nil, fmt.Errorf("ListKeys(%s): %w", s.Namer.GetServiceAccountName(org), err)
+	}
+	return keys, nil
+}
+
+// ListOrgs returns the names of organizations with a service account in the
+// project.
+func (s *ServiceAccountsManager) ListOrgs(ctx context.Context) ([]string, error) {
+	accounts, err := s.iams.ListServiceAccounts(ctx, s.Namer.GetProjectsName())
+	if err != nil {
+		log.Printf("ListServiceAccounts failed for %q: %v", s.Namer.GetProjectsName(), err)
+		return nil, fmt.Errorf("ListServiceAccounts(%s): %w", s.Namer.GetProjectsName(), err)
+	}
+	prefix := s.Namer.GetServiceAccountID("")
+	suffix := s.Namer.GetServiceAccountEmail("")[len(prefix):]
+	orgs := []string{}
+	for _, a := range accounts {
+		if strings.HasPrefix(a.Email, prefix) && strings.HasSuffix(a.Email, suffix) {
+			orgs = append(orgs, strings.TrimSuffix(strings.TrimPrefix(a.Email, prefix), suffix))
+		}
+	}
+	return orgs, nil
+}
+
+// DeleteServiceAccount deletes the service account associated with org, and
+// with it all of its keys. Service accounts that do not exist are ignored.
+func (s *ServiceAccountsManager) DeleteServiceAccount(ctx context.Context, org string) error {
+	log.Printf("Deleting service account: %q", s.Namer.GetServiceAccountName(org))
+	err := s.iams.DeleteServiceAccount(ctx, s.Namer.GetServiceAccountName(org))
+	if err != nil && !errIsNotFound(err) {
+		log.Printf("DeleteServiceAccount failed for %q: %v", s.Namer.GetServiceAccountName(org), err)
+		return fmt.Errorf("DeleteServiceAccount(%s): %w", s.Namer.GetServiceAccountName(org), err)
+	}
+	return nil
+}
+
 func errIsNotFound(err error) bool {
 	var gerr *apierror.APIError
 	if errors.As(err, &gerr) {
@@ -102,3 +170,12 @@ func errIsNotFound(err error) bool {
 	}
 	return false
 }
+
+func errIsAlreadyExists(err error) bool {
+	var gerr *apierror.APIError
+	if errors.As(err, &gerr) {
+		s := gerr.GRPCStatus()
+		return (s != nil && s.Code() == codes.AlreadyExists) || gerr.HTTPCode() == http.StatusConflict
+	}
+	return false
+}
@@ -21,6 +21,17 @@ type fakeIAMService struct {
 
 	key    *iam.ServiceAccountKey
 	keyErr error
+
+	deleteErr error
+	deleted   []string
+
+	keys    []*iam.ServiceAccountKey
+	listErr error
+
+	accounts     []*iam.ServiceAccount
+	listAcctErr  error
+	delAcctErr   error
+	deletedAccts []string
 }
 
 func (f *fakeIAMService) GetServiceAccount(ctx context.Context, saName string) (*iam.ServiceAccount, error) {
@@ -33,6 +44,22 @@ func (f *fakeIAMService) CreateKey(ctx context.Context, saName string, req *iam.
 	return f.key, f.keyErr
 }
 
+func (f *fakeIAMService) DeleteKey(ctx context.Context, keyName string) error {
+	f.deleted = append(f.deleted, keyName)
+	return f.deleteErr
+}
+func (f *fakeIAMService) ListKeys(ctx context.Context, saName string) ([]*iam.ServiceAccountKey, error) {
+	return f.keys, f.listErr
+}
+
+func (f *fakeIAMService) ListServiceAccounts(ctx context.Context, projName string) ([]*iam.ServiceAccount, error) {
+	return f.accounts, f.listAcctErr
+}
+func (f *fakeIAMService) DeleteServiceAccount(ctx context.Context, saName string) error {
+	f.deletedAccts = append(f.deletedAccts, saName)
+	return f.delAcctErr
+}
+
 func createNotFoundErr() error {
 	err, _ := apierror.FromError(status.Error(codes.NotFound, "fake not found"))
 	return err
@@ -174,3 +201,77 @@ func TestServiceAccountsManager_CreateKey(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceAccountsManager_DeleteKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		iams    IAMService
+		wantErr bool
+	}{
+		{
+			name: "success",
+			iams: &fakeIAMService{},
+		},
+		{
+			name: "success-not-found",
+			iams: &fakeIAMService{
+				deleteErr: createNotFoundErr(),
+			},
+		},
+		{
+			name: "error-other-failure",
+			iams: &fakeIAMService{
+				deleteErr: fmt.Errorf("fake delete error"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServiceAccountsManager(tt.iams, NewNamer("mlab-foo"))
+			err := s.DeleteKey(context.Background(), "projects/mlab-foo/serviceAccounts/autonode-foo@mlab-foo.iam.gserviceaccount.com/keys/1234")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ServiceAccountsManager.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServiceAccountsManager_ListOrgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		iams    *fakeIAMService
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			iams: &fakeIAMService{
+				accounts: []*iam.ServiceAccount{
+					{Email: "autonode-foo@mlab-foo.iam.gserviceaccount.com"},
+					{Email: "autojoin@mlab-foo.iam.gserviceaccount.com"},
+					{Email: "autonode-bar@mlab-foo.iam.gserviceaccount.com"},
+					{Email: "autonode-baz@other.iam.gserviceaccount.com"},
+				},
+			},
+			want: []string{"foo", "bar"},
+		},
+		{
+			name:    "error",
+			iams:    &fakeIAMService{listAcctErr: fmt.Errorf("fake list error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServiceAccountsManager(tt.iams, NewNamer("mlab-foo"))
+			got, err := s.ListOrgs(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ServiceAccountsManager.ListOrgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServiceAccountsManager.ListOrgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
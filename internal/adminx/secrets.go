@@ -3,9 +3,13 @@ package adminx
 import (
 	"context"
 	"log"
+	"time"
 
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/googleapis/gax-go"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // SecretManagerClient is an interface describing operations on the Google Cloud Secret Manager API.
@@ -15,6 +19,8 @@ type SecretManagerClient interface {
 	GetSecretVersion(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
 	AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
 	AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error
 }
 
 // SecretManager manages operations on secrets.
@@ -23,6 +29,8 @@ type SecretManager struct {
 	smc     SecretManagerClient
 	sam     *ServiceAccountsManager
 	version string
+	kms     KMSService
+	kmsKey  string
 }
 
 // NewSecretManager creates a new secret manager instance.
@@ -35,6 +43,15 @@ func NewSecretManager(smc SecretManagerClient, n *Namer, sam *ServiceAccountsMan
 	}
 }
 
+// EncryptWith enables envelope encryption of stored keys using the named KMS
+// key, e.g. "projects/<project>/locations/global/keyRings/<ring>/cryptoKeys/<key>".
+// Keys stored before encryption was enabled remain readable.
+func (s *SecretManager) EncryptWith(kms KMSService, keyName string) *SecretManager {
+	s.kms = kms
+	s.kmsKey = keyName
+	return s
+}
+
 // CreateSecret creates a new secret for the given org using the naming
 // convention of the instance Namer.
 func (s *SecretManager) CreateSecret(ctx context.Context, org string) error {
@@ -72,9 +89,32 @@ func (s *SecretManager) CreateSecret(ctx context.Context, org string) error {
 	return nil
 }
 
+// GetSecret returns the secret of the given org.
+func (s *SecretManager) GetSecret(ctx context.Context, org string) (*secretmanagerpb.Secret, error) {
+	return s.smc.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{
+		Name: s.Namer.GetSecretName(org),
+	})
+}
+
+// DeleteSecret deletes the secret of the given org with all of its versions.
+// Secrets that do not exist are ignored.
+func (s *SecretManager) DeleteSecret(ctx context.Context, org string) error {
+	log.Printf("Deleting secret: %q", s.Namer.GetSecretName(org))
+	err := s.smc.DeleteSecret(ctx, &secretmanagerpb.DeleteSecretRequest{
+		Name: s.Namer.GetSecretName(org),
+	})
+	if err != nil && !errIsNotFound(err) {
+		log.Printf("Delete secret failed for %q: %v", s.Namer.GetSecretName(org), err)
+		return err
+	}
+	return nil
+}
+
 // LoadOrCreateKey is a single method to either create and store a key or
 // read an existing key from SecretManager.
-func (s *SecretManager) LoadOrCreateKey(ctx context.Context, org string) (string, error) {
+func (s *SecretManager) LoadOrCreateKey(ctx context.Context, org string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "adminx.LoadOrCreateKey", attribute.String("org", org))
+	defer func() { tracing.End(span, err) }()
 	key, err := s.LoadKey(ctx, org)
 	switch {
 	case errIsNotFound(err):
@@ -100,8 +140,6 @@ func (s *SecretManager) LoadOrCreateKey(ctx context.Context, org string) (string
 
 // StoreKey saves the given key in the org's secret.
 func (s *SecretManager) StoreKey(ctx context.Context, org string, key string) error {
-	// Declare the payload to store.
-	payload := []byte(key)
 	req := &secretmanagerpb.GetSecretVersionRequest{
 		Name: s.Namer.GetSecretName(org) + "/versions/" + s.version,
 	}
@@ -109,6 +147,11 @@ func (s *SecretManager) StoreKey(ctx context.Context, org string, key string) er
 	version, err := s.smc.GetSecretVersion(ctx, req)
 	switch {
 	case errIsNotFound(err):
+		// Declare the payload to store.
+		payload, err := s.payload(ctx, org, key)
+		if err != nil {
+			return err
+		}
 		// Add secret.
 		addReq := &secretmanagerpb.AddSecretVersionRequest{
 			Parent: s.Namer.GetSecretName(org),
@@ -135,9 +178,23 @@ func (s *SecretManager) LoadKey(ctx context.Context, org string) (string, error)
 		Name: s.Namer.GetSecretName(org) + "/versions/" + s.version,
 	}
 	// Call the API.
+	start := time.Now()
 	result, err := s.smc.AccessSecretVersion(ctx, req)
+	metrics.SecretAccessDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return "", err
+	}
+	data, err := s.open(ctx, s.Namer.GetSecretName(org), result.Payload.Data)
 	if err != nil {
 		return "", err
 	}
-	return string(result.Payload.Data), nil
+	return string(data), nil
+}
+
+// payload returns the secret payload for key, encrypted if a KMS key is set.
+func (s *SecretManager) payload(ctx context.Context, org string, key string) ([]byte, error) {
+	if s.kms == nil {
+		return []byte(key), nil
+	}
+	return s.seal(ctx, s.Namer.GetSecretName(org), []byte(key))
 }
@@ -21,6 +21,10 @@ type fakeSMC struct {
 	addSecVerErr    error
 	accessSecVer    *secretmanagerpb.AccessSecretVersionResponse
 	accessSecVerErr error
+	disabled        []string
+	disableErr      error
+	deleteSecErr    error
+	deletedSecs     []string
 }
 
 func (f *fakeSMC) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
@@ -39,6 +43,16 @@ func (f *fakeSMC) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.
 	return f.accessSecVer, f.accessSecVerErr
 }
 
+func (f *fakeSMC) DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	f.disabled = append(f.disabled, req.Name)
+	return &secretmanagerpb.SecretVersion{Name: req.Name}, f.disableErr
+}
+
+func (f *fakeSMC) DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+	f.deletedSecs = append(f.deletedSecs, req.Name)
+	return f.deleteSecErr
+}
+
 func TestSecretManager_CreateSecret(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -0,0 +1,37 @@
+package serviceusageiface
+
+import (
+	"context"
+
+	"google.golang.org/api/serviceusage/v1"
+)
+
+type serviceUsageImpl struct {
+	su      *serviceusage.Service
+	Project string
+}
+
+// NewServiceUsage creates a new service usage implementation for wrapping the
+// serviceusage.Service of the given project.
+func NewServiceUsage(project string, su *serviceusage.Service) *serviceUsageImpl {
+	return &serviceUsageImpl{
+		Project: project,
+		su:      su,
+	}
+}
+
+// EnabledServices returns the names of the enabled services of the project,
+// e.g. "dns.googleapis.com".
+func (s *serviceUsageImpl) EnabledServices(ctx context.Context) ([]string, error) {
+	names := []string{}
+	call := s.su.Services.List("projects/" + s.Project).Filter("state:ENABLED").PageSize(200)
+	err := call.Pages(ctx, func(resp *serviceusage.ListServicesResponse) error {
+		for _, svc := range resp.Services {
+			if svc.Config != nil {
+				names = append(names, svc.Config.Name)
+			}
+		}
+		return nil
+	})
+	return names, err
+}
@@ -0,0 +1,67 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+// storageScope limits access tokens to Cloud Storage. The service account
+// policy further limits which objects may be read or written.
+const storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// CredentialsService defines the interface used to access the Google Cloud IAM
+// Credentials Service.
+type CredentialsService interface {
+	GenerateAccessToken(ctx context.Context, saName string, req *iamcredentials.GenerateAccessTokenRequest) (*iamcredentials.GenerateAccessTokenResponse, error)
+}
+
+// AccessTokens generates short-lived OAuth access tokens for organization
+// service accounts, so that nodes do not need exported service account keys.
+//
+// NOTE: the caller must be allowed to create tokens for the service accounts,
+// e.g. with roles/iam.serviceAccountTokenCreator.
+type AccessTokens struct {
+	cs       CredentialsService
+	Namer    *Namer
+	lifetime time.Duration
+}
+
+// NewAccessTokens creates a new AccessTokens instance that generates tokens
+// valid for lifetime. Lifetimes longer than one hour require an organization
+// policy exception.
+func NewAccessTokens(cs CredentialsService, n *Namer, lifetime time.Duration) *AccessTokens {
+	return &AccessTokens{
+		cs:       cs,
+		Namer:    n,
+		lifetime: lifetime,
+	}
+}
+
+// Generate returns a new access token for the service account of the given
+// org, and the time it expires.
+func (a *AccessTokens) Generate(ctx context.Context, org string) (_ string, _ time.Time, err error) {
+	ctx, span := tracing.Start(ctx, "adminx.GenerateAccessToken", attribute.String("org", org))
+	defer func() { tracing.End(span, err) }()
+	req := &iamcredentials.GenerateAccessTokenRequest{
+		Lifetime: fmt.Sprintf("%ds", int64(a.lifetime.Seconds())),
+		Scope:    []string{storageScope},
+	}
+	// The project is inferred from the service account email.
+	name := "projects/-/serviceAccounts/" + a.Namer.GetServiceAccountEmail(org)
+	resp, err := a.cs.GenerateAccessToken(ctx, name, req)
+	if err != nil {
+		log.Printf("GenerateAccessToken failed for %q: %v", name, err)
+		return "", time.Time{}, fmt.Errorf("GenerateAccessToken(%s): %w", name, err)
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("GenerateAccessToken(%s): invalid expire time: %w", name, err)
+	}
+	return resp.AccessToken, expiry, nil
+}
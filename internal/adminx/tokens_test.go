@@ -0,0 +1,81 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+type fakeCredentialsService struct {
+	name string
+	req  *iamcredentials.GenerateAccessTokenRequest
+	resp *iamcredentials.GenerateAccessTokenResponse
+	err  error
+}
+
+func (f *fakeCredentialsService) GenerateAccessToken(ctx context.Context, saName string, req *iamcredentials.GenerateAccessTokenRequest) (*iamcredentials.GenerateAccessTokenResponse, error) {
+	f.name = saName
+	f.req = req
+	return f.resp, f.err
+}
+
+func TestAccessTokens_Generate(t *testing.T) {
+	tests := []struct {
+		name       string
+		cs         *fakeCredentialsService
+		wantToken  string
+		wantExpiry time.Time
+		wantErr    bool
+	}{
+		{
+			name: "success",
+			cs: &fakeCredentialsService{
+				resp: &iamcredentials.GenerateAccessTokenResponse{
+					AccessToken: "fake-token",
+					ExpireTime:  "2024-01-02T03:04:05Z",
+				},
+			},
+			wantToken:  "fake-token",
+			wantExpiry: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			name: "error-generate",
+			cs: &fakeCredentialsService{
+				err: fmt.Errorf("fake generate error"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-expire-time",
+			cs: &fakeCredentialsService{
+				resp: &iamcredentials.GenerateAccessTokenResponse{
+					AccessToken: "fake-token",
+					ExpireTime:  "invalid",
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAccessTokens(tt.cs, NewNamer("mlab-foo"), time.Hour)
+			token, expiry, err := a.Generate(context.Background(), "bar")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AccessTokens.Generate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if token != tt.wantToken || !expiry.Equal(tt.wantExpiry) {
+				t.Errorf("AccessTokens.Generate() = %q, %v; want %q, %v", token, expiry, tt.wantToken, tt.wantExpiry)
+			}
+			if tt.cs.name != "projects/-/serviceAccounts/autonode-bar@mlab-foo.iam.gserviceaccount.com" {
+				t.Errorf("AccessTokens.Generate() used wrong service account; got %q", tt.cs.name)
+			}
+			if tt.cs.req.Lifetime != "3600s" {
+				t.Errorf("AccessTokens.Generate() used wrong lifetime; got %q", tt.cs.req.Lifetime)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package adminx
+
+import (
+	"context"
+	"log"
+
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/iam/v1"
+)
+
+// workloadIdentityUser allows federated identities to impersonate a service account.
+const workloadIdentityUser = "roles/iam.workloadIdentityUser"
+
+// WorkloadIdentityService is an interface describing the IAM operations used
+// to set up workload identity federation.
+type WorkloadIdentityService interface {
+	CreateWorkloadIdentityPool(ctx context.Context, parent, poolID string, pool *iam.WorkloadIdentityPool) error
+	CreateWorkloadIdentityPoolProvider(ctx context.Context, parent, providerID string, provider *iam.WorkloadIdentityPoolProvider) error
+	GetServiceAccountIamPolicy(ctx context.Context, saName string) (*iam.Policy, error)
+	SetServiceAccountIamPolicy(ctx context.Context, saName string, req *iam.SetIamPolicyRequest) error
+}
+
+// WithWorkloadIdentity enables workload identity federation for nodes of
+// organizations set up by o. Nodes authenticate with OIDC tokens from the
+// given issuer, e.g. https://sts.windows.net/<tenant>/, instead of service
+// account keys.
+func (o *Org) WithWorkloadIdentity(wis WorkloadIdentityService, issuer string) *Org {
+	o.wis = wis
+	o.issuer = issuer
+	return o
+}
+
+// WorkloadIdentityProvider returns the resource name of the workload identity
+// provider of org, e.g.
+// projects/123/locations/global/workloadIdentityPools/autojoin-foo/providers/oidc
+func (o *Org) WorkloadIdentityProvider(ctx context.Context, org string) (string, error) {
+	p, err := o.crm.GetProject(ctx)
+	if err != nil {
+		return "", err
+	}
+	return o.sam.Namer.GetWorkloadIdentityProviderName(org, p.ProjectNumber), nil
+}
+
+// SetupWorkloadIdentity creates the workload identity pool and OIDC provider
+// of org, and allows identities of the pool to impersonate the org service
+// account. SetupWorkloadIdentity may be run again to complete a failed setup.
+func (o *Org) SetupWorkloadIdentity(ctx context.Context, org string, account *iam.ServiceAccount) error {
+	p, err := o.crm.GetProject(ctx)
+	if err != nil {
+		log.Println("get project", err)
+		return err
+	}
+	n := o.sam.Namer
+	poolID := n.GetWorkloadIdentityPoolID(org)
+	err = o.wis.CreateWorkloadIdentityPool(ctx, n.GetProjectsName()+"/locations/global", poolID, &iam.WorkloadIdentityPool{
+		Description: "Autojoin nodes from org: " + org,
+		DisplayName: poolID,
+	})
+	if err != nil && !errIsAlreadyExists(err) {
+		log.Println("failed to create workload identity pool:", poolID, err)
+		return err
+	}
+	err = o.wis.CreateWorkloadIdentityPoolProvider(ctx, n.GetProjectsName()+"/locations/global/workloadIdentityPools/"+poolID,
+		n.GetWorkloadIdentityProviderID(org), &iam.WorkloadIdentityPoolProvider{
+			AttributeMapping: map[string]string{"google.subject": "assertion.sub"},
+			Oidc:             &iam.Oidc{IssuerUri: o.issuer},
+		})
+	if err != nil && !errIsAlreadyExists(err) {
+		log.Println("failed to create workload identity provider:", poolID, err)
+		return err
+	}
+
+	// Allow all identities of the pool to impersonate the service account.
+	member := "principalSet://iam.googleapis.com/" + n.GetWorkloadIdentityPoolName(org, p.ProjectNumber) + "/*"
+	policy, err := o.wis.GetServiceAccountIamPolicy(ctx, account.Name)
+	if err != nil {
+		log.Println("get service account policy", err)
+		return err
+	}
+	for _, b := range policy.Bindings {
+		if b.Role == workloadIdentityUser && b.Condition == nil && slices.Contains(b.Members, member) {
+			return nil
+		}
+	}
+	policy.Bindings = append(policy.Bindings, &iam.Binding{
+		Members: []string{member},
+		Role:    workloadIdentityUser,
+	})
+	err = o.wis.SetServiceAccountIamPolicy(ctx, account.Name, &iam.SetIamPolicyRequest{Policy: policy})
+	if err != nil {
+		log.Println("set service account policy", err)
+		return err
+	}
+	return nil
+}
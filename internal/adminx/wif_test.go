@@ -0,0 +1,161 @@
+package adminx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+)
+
+type fakeWIS struct {
+	poolErr     error
+	providerErr error
+	getPolicy   *iam.Policy
+	getErr      error
+	setErr      error
+	pool        string
+	provider    *iam.WorkloadIdentityPoolProvider
+	setPolicy   *iam.Policy
+}
+
+func (f *fakeWIS) CreateWorkloadIdentityPool(ctx context.Context, parent, poolID string, pool *iam.WorkloadIdentityPool) error {
+	f.pool = parent + "/workloadIdentityPools/" + poolID
+	return f.poolErr
+}
+
+func (f *fakeWIS) CreateWorkloadIdentityPoolProvider(ctx context.Context, parent, providerID string, provider *iam.WorkloadIdentityPoolProvider) error {
+	f.provider = provider
+	return f.providerErr
+}
+
+func (f *fakeWIS) GetServiceAccountIamPolicy(ctx context.Context, saName string) (*iam.Policy, error) {
+	return f.getPolicy, f.getErr
+}
+
+func (f *fakeWIS) SetServiceAccountIamPolicy(ctx context.Context, saName string, req *iam.SetIamPolicyRequest) error {
+	f.setPolicy = req.Policy
+	return f.setErr
+}
+
+func createAlreadyExistsErr() error {
+	err, _ := apierror.FromError(&googleapi.Error{Code: http.StatusConflict})
+	return err
+}
+
+func TestOrg_SetupWorkloadIdentity(t *testing.T) {
+	member := "principalSet://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/autojoin-foo/*"
+	project := &cloudresourcemanager.Project{ProjectNumber: 123}
+	tests := []struct {
+		name        string
+		crm         *fakeCRM
+		wis         *fakeWIS
+		wantMembers []string
+		wantErr     bool
+	}{
+		{
+			name:        "success",
+			crm:         &fakeCRM{project: project},
+			wis:         &fakeWIS{getPolicy: &iam.Policy{}},
+			wantMembers: []string{member},
+		},
+		{
+			name: "success-already-exists",
+			crm:  &fakeCRM{project: project},
+			wis: &fakeWIS{
+				poolErr:     createAlreadyExistsErr(),
+				providerErr: createAlreadyExistsErr(),
+				getPolicy: &iam.Policy{Bindings: []*iam.Binding{
+					{Members: []string{member}, Role: workloadIdentityUser},
+				}},
+			},
+		},
+		{
+			name:    "error-get-project",
+			crm:     &fakeCRM{projectErr: fmt.Errorf("fake project error")},
+			wis:     &fakeWIS{},
+			wantErr: true,
+		},
+		{
+			name:    "error-create-pool",
+			crm:     &fakeCRM{project: project},
+			wis:     &fakeWIS{poolErr: fmt.Errorf("fake pool error")},
+			wantErr: true,
+		},
+		{
+			name:    "error-create-provider",
+			crm:     &fakeCRM{project: project},
+			wis:     &fakeWIS{providerErr: fmt.Errorf("fake provider error")},
+			wantErr: true,
+		},
+		{
+			name:    "error-get-policy",
+			crm:     &fakeCRM{project: project},
+			wis:     &fakeWIS{getErr: fmt.Errorf("fake get error")},
+			wantErr: true,
+		},
+		{
+			name:        "error-set-policy",
+			crm:         &fakeCRM{project: project},
+			wis:         &fakeWIS{getPolicy: &iam.Policy{}, setErr: fmt.Errorf("fake set error")},
+			wantMembers: []string{member},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNamer("mlab-foo")
+			o := NewOrg("mlab-foo", tt.crm, NewServiceAccountsManager(nil, n), nil, nil, nil, false)
+			o.WithWorkloadIdentity(tt.wis, "https://issuer.example.com")
+			acct := &iam.ServiceAccount{Name: n.GetServiceAccountName("foo")}
+			err := o.SetupWorkloadIdentity(context.Background(), "foo", acct)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Org.SetupWorkloadIdentity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var members []string
+			if tt.wis.setPolicy != nil {
+				for _, b := range tt.wis.setPolicy.Bindings {
+					members = append(members, b.Members...)
+				}
+			}
+			if !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("Org.SetupWorkloadIdentity() members = %v, want %v", members, tt.wantMembers)
+			}
+			if tt.wis.provider != nil && tt.wis.provider.Oidc.IssuerUri != "https://issuer.example.com" {
+				t.Errorf("Org.SetupWorkloadIdentity() issuer = %q", tt.wis.provider.Oidc.IssuerUri)
+			}
+		})
+	}
+}
+
+func TestOrg_SetupWithWorkloadIdentity(t *testing.T) {
+	n := NewNamer("mlab-foo")
+	sam := NewServiceAccountsManager(&fakeIAMService{getAcct: &iam.ServiceAccount{Name: "foo"}}, n)
+	sm := NewSecretManager(&fakeSMC{getSec: &secretmanagerpb.Secret{Name: "okay"}}, n, sam)
+	crm := &fakeCRM{getPolicy: &cloudresourcemanager.Policy{}, projectErr: fmt.Errorf("fake project error")}
+	o := NewOrg("mlab-foo", crm, sam, sm, nil, nil, false).WithWorkloadIdentity(&fakeWIS{}, "https://issuer.example.com")
+	if _, err := o.Setup(context.Background(), "foo"); err == nil {
+		t.Errorf("Org.Setup() error = nil, want workload identity error")
+	}
+}
+
+func TestOrg_WorkloadIdentityProvider(t *testing.T) {
+	n := NewNamer("mlab-foo")
+	crm := &fakeCRM{project: &cloudresourcemanager.Project{ProjectNumber: 123}}
+	o := NewOrg("mlab-foo", crm, NewServiceAccountsManager(nil, n), nil, nil, nil, false)
+	got, err := o.WorkloadIdentityProvider(context.Background(), "foo")
+	want := "projects/123/locations/global/workloadIdentityPools/autojoin-foo/providers/oidc"
+	if err != nil || got != want {
+		t.Errorf("Org.WorkloadIdentityProvider() = %q, %v, want %q", got, err, want)
+	}
+	crm.projectErr = fmt.Errorf("fake project error")
+	if _, err := o.WorkloadIdentityProvider(context.Background(), "foo"); err == nil {
+		t.Errorf("Org.WorkloadIdentityProvider() error = nil, want error")
+	}
+}
@@ -0,0 +1,129 @@
+// Package cache caches values loaded from slow backends, e.g. organization
+// settings in Datastore, for a TTL. An optional shared layer in Redis lets all
+// instances of the API reuse each other's lookups.
+package cache
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+)
+
+// Shared is a cache layer shared by all instances.
+type Shared interface {
+	// Get returns nil without error if the key is not found.
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Del(key string) error
+}
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Cache is an in-process cache of values that expire after a TTL. Values are
+// JSON encoded in the optional Shared layer.
+type Cache[V any] struct {
+	name   string
+	ttl    time.Duration
+	shared Shared
+
+	mu      sync.Mutex
+	entries map[string]entry[V]
+}
+
+// New creates a new Cache. The name identifies the cache in metrics and in the
+// Shared layer, which may be nil.
+func New[V any](name string, ttl time.Duration, shared Shared) *Cache[V] {
+	return &Cache[V]{
+		name:    name,
+		ttl:     ttl,
+		shared:  shared,
+		entries: map[string]entry[V]{},
+	}
+}
+
+// Get returns the cached value of key, or the value returned by load. Errors
+// from load are returned and not cached.
+func (c *Cache[V]) Get(key string, load func() (V, error)) (V, error) {
+	if v, ok := c.get(key); ok {
+		metrics.CacheRequests.WithLabelValues(c.name, "hit").Inc()
+		return v, nil
+	}
+	if c.shared != nil {
+		b, err := c.shared.Get(c.sharedKey(key))
+		if err != nil {
+			log.Printf("Failed to read %s from shared cache: %v", c.name, err)
+		}
+		var v V
+		if b != nil && json.Unmarshal(b, &v) == nil {
+			metrics.CacheRequests.WithLabelValues(c.name, "shared_hit").Inc()
+			c.put(key, v)
+			return v, nil
+		}
+	}
+	metrics.CacheRequests.WithLabelValues(c.name, "miss").Inc()
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+	c.put(key, v)
+	if c.shared != nil && c.ttl > 0 {
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = c.shared.Set(c.sharedKey(key), b, c.ttl)
+		}
+		if err != nil {
+			log.Printf("Failed to save %s to shared cache: %v", c.name, err)
+		}
+	}
+	return v, nil
+}
+
+// Invalidate removes key from the cache and the Shared layer. Other instances
+// may return their own cached value until it expires.
+func (c *Cache[V]) Invalidate(key string) error {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	if c.shared == nil {
+		return nil
+	}
+	return c.shared.Del(c.sharedKey(key))
+}
+
+func (c *Cache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+// put saves the value of key. Expired entries are removed on every put so the
+// cache size is bounded by the number of distinct keys within one TTL.
+func (c *Cache[V]) put(key string, v V) {
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry[V]{value: v, expires: now.Add(c.ttl)}
+}
+
+func (c *Cache[V]) sharedKey(key string) string {
+	return keyPrefix + c.name + ":" + key
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeConn implements the subset of Redis commands used by Redis.
+type fakeConn struct {
+	m   map[string][]byte
+	err error
+}
+
+func (c *fakeConn) Close() error                               { return nil }
+func (c *fakeConn) Err() error                                 { return nil }
+func (c *fakeConn) Send(cmd string, args ...interface{}) error { return nil }
+func (c *fakeConn) Flush() error                               { return nil }
+func (c *fakeConn) Receive() (interface{}, error)              { return nil, nil }
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		// The pool flushes connections with an empty command on close.
+		return nil, nil
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	key := args[0].(string)
+	switch cmd {
+	case "SET":
+		c.m[key] = args[1].([]byte)
+		return "OK", nil
+	case "GET":
+		v, ok := c.m[key]
+		if !ok {
+			return nil, nil
+		}
+		return v, nil
+	case "DEL":
+		delete(c.m, key)
+		return int64(1), nil
+	}
+	return nil, errors.New("unsupported command")
+}
+
+// loader counts calls and returns the configured value.
+type loader struct {
+	value string
+	err   error
+	calls int
+}
+
+func (l *loader) load() (string, error) {
+	l.calls++
+	return l.value, l.err
+}
+
+func TestCache(t *testing.T) {
+	c := New[string]("test", time.Minute, nil)
+	l := &loader{value: "mlab"}
+
+	for i := 0; i < 2; i++ {
+		v, err := c.Get("abc", l.load)
+		if err != nil || v != "mlab" {
+			t.Errorf("Get() = %q, %v; want mlab, nil", v, err)
+		}
+	}
+	if l.calls != 1 {
+		t.Errorf("Get() loaded %d times; want 1", l.calls)
+	}
+
+	// Invalidated entries are loaded again.
+	if err := c.Invalidate("abc"); err != nil {
+		t.Fatalf("Invalidate() returned err: %v", err)
+	}
+	l.value = "foo"
+	if v, _ := c.Get("abc", l.load); v != "foo" || l.calls != 2 {
+		t.Errorf("Get() = %q after %d loads; want foo after 2", v, l.calls)
+	}
+
+	// Errors are not cached.
+	l.err = errors.New("fake load error")
+	if _, err := c.Get("def", l.load); err != l.err {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, l.err)
+	}
+	if _, ok := c.entries["def"]; ok {
+		t.Errorf("Get() cached failed load")
+	}
+
+	// Expired entries are loaded again, and are removed by the next put.
+	c.entries["abc"] = entry[string]{value: "old", expires: time.Now().Add(-time.Second)}
+	l.err = nil
+	if v, _ := c.Get("abc", l.load); v != "foo" || l.calls != 4 {
+		t.Errorf("Get() = %q after %d loads; want foo after 4", v, l.calls)
+	}
+
+	// A zero ttl disables caching.
+	c = New[string]("test", 0, nil)
+	c.Get("abc", l.load)
+	c.Get("abc", l.load)
+	if l.calls != 6 {
+		t.Errorf("Get() loaded %d times; want 6", l.calls)
+	}
+}
+
+func TestCache_Shared(t *testing.T) {
+	conn := &fakeConn{m: map[string][]byte{}}
+	shared := NewRedis(&redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }})
+	c1 := New[string]("test", time.Minute, shared)
+	c2 := New[string]("test", time.Minute, shared)
+	l := &loader{value: "mlab"}
+
+	// Values loaded by one instance are reused by others.
+	c1.Get("abc", l.load)
+	if string(conn.m["cache:test:abc"]) != `"mlab"` {
+		t.Errorf("Get() saved wrong shared value; got %q", conn.m["cache:test:abc"])
+	}
+	if v, err := c2.Get("abc", l.load); err != nil || v != "mlab" || l.calls != 1 {
+		t.Errorf("Get() = %q, %v after %d loads; want mlab after 1", v, err, l.calls)
+	}
+
+	// Invalidation removes shared values.
+	if err := c1.Invalidate("abc"); err != nil {
+		t.Fatalf("Invalidate() returned err: %v", err)
+	}
+	if _, ok := conn.m["cache:test:abc"]; ok {
+		t.Errorf("Invalidate() did not remove shared value")
+	}
+
+	// Shared layer failures fall back to load.
+	conn.err = errors.New("fake redis error")
+	if v, err := c1.Get("abc", l.load); err != nil || v != "mlab" || l.calls != 2 {
+		t.Errorf("Get() = %q, %v after %d loads; want mlab after 2", v, err, l.calls)
+	}
+	if err := c1.Invalidate("abc"); err != conn.err {
+		t.Errorf("Invalidate() returned wrong error; got %v, want %v", err, conn.err)
+	}
+}
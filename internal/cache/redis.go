@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// keyPrefix separates cache entries from other keys in Redis.
+const keyPrefix = "cache:"
+
+// Redis is a Shared cache layer in Redis.
+//
+// NOTE: the tracker reads every key of its Redis database as a hash, so Redis
+// must use a different database.
+type Redis struct {
+	pool *redis.Pool
+}
+
+// NewRedis creates a new Redis cache layer.
+func NewRedis(pool *redis.Pool) *Redis {
+	return &Redis{pool: pool}
+}
+
+// Get returns the value of key, or nil if it is not found.
+func (r *Redis) Get(key string) ([]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("GET", key))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// Set saves the value of key for the given ttl.
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", key, value, "PX", ttl.Milliseconds())
+	return err
+}
+
+// Del removes key.
+func (r *Redis) Del(key string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", key)
+	return err
+}
@@ -0,0 +1,321 @@
+// Package config manages operational settings of the Autojoin API that may be
+// inspected and changed at runtime. Settings are persisted to Datastore so
+// that all instances converge on the same values and changes survive restarts.
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+const (
+	// Kind is the Datastore kind of the config entity.
+	Kind = "Config"
+	// Name is the Datastore key name of the singleton config entity.
+	Name = "runtime"
+
+	// MinGCTTL is the smallest accepted GC TTL. Nodes re-register hourly, so
+	// anything shorter would expire healthy nodes.
+	MinGCTTL = time.Hour
+	// MinGCInterval is the smallest accepted interval between GC runs.
+	MinGCInterval = time.Minute
+)
+
+var (
+	// ErrInvalidGCTTL is returned when the GC TTL is below MinGCTTL.
+	ErrInvalidGCTTL = fmt.Errorf("gc ttl must be at least %s", MinGCTTL)
+	// ErrInvalidGCInterval is returned when the GC interval is below MinGCInterval.
+	ErrInvalidGCInterval = fmt.Errorf("gc interval must be at least %s", MinGCInterval)
+	// ErrInvalidVersion is returned when a client version is not of the form
+	// MAJOR.MINOR.PATCH.
+	ErrInvalidVersion = errors.New("client version must be of the form 1.2.3")
+	// ErrInvalidService is returned when a service name is not lowercase
+	// letters and digits, or appears more than once.
+	ErrInvalidService = errors.New("service must be unique lowercase letters and digits")
+	// ErrInvalidFeature is returned when a feature name is not lowercase
+	// letters, digits and underscores, or appears more than once.
+	ErrInvalidFeature = errors.New("feature must be unique lowercase letters, digits and underscores")
+
+	versionRe = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+$`)
+	serviceRe = regexp.MustCompile(`^[a-z0-9]{1,10}$`)
+	featureRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+)
+
+// Config contains the runtime adjustable settings.
+type Config struct {
+	// GCTTL is how long a registration remains active without being renewed.
+	GCTTL time.Duration
+	// GCInterval is the time between garbage collection runs.
+	GCInterval time.Duration
+	// MinClientVersion is the oldest version of the client package accepted
+	// by the server, if any.
+	MinClientVersion string
+	// ServiceVersions overrides MinClientVersion for the clients of some
+	// services.
+	ServiceVersions []ServiceVersion
+	// Features are the names of the enabled feature flags.
+	Features []string
+	// DeprecatedClientVersion is the oldest client version that is not
+	// deprecated, if any. Older clients that are still accepted are warned
+	// that they stop being supported at ClientSunset.
+	DeprecatedClientVersion string
+	ClientSunset            time.Time
+}
+
+// ServiceVersion is the oldest client version accepted for a service.
+type ServiceVersion struct {
+	Service          string
+	MinClientVersion string
+}
+
+// Validate returns an error if the config contains out of range values.
+func (c Config) Validate() error {
+	switch {
+	case c.GCTTL < MinGCTTL:
+		return ErrInvalidGCTTL
+	case c.GCInterval < MinGCInterval:
+		return ErrInvalidGCInterval
+	case c.MinClientVersion != "" && !versionRe.MatchString(c.MinClientVersion):
+		return ErrInvalidVersion
+	case c.DeprecatedClientVersion != "" && !versionRe.MatchString(c.DeprecatedClientVersion):
+		return ErrInvalidVersion
+	}
+	services := map[string]bool{}
+	for _, v := range c.ServiceVersions {
+		if !serviceRe.MatchString(v.Service) || services[v.Service] {
+			return ErrInvalidService
+		}
+		if !versionRe.MatchString(v.MinClientVersion) {
+			return ErrInvalidVersion
+		}
+		services[v.Service] = true
+	}
+	features := map[string]bool{}
+	for _, f := range c.Features {
+		if !featureRe.MatchString(f) || features[f] {
+			return ErrInvalidFeature
+		}
+		features[f] = true
+	}
+	return nil
+}
+
+// MinVersion returns the oldest client version accepted for the service.
+func (c Config) MinVersion(service string) string {
+	for _, v := range c.ServiceVersions {
+		if v.Service == service {
+			return v.MinClientVersion
+		}
+	}
+	return c.MinClientVersion
+}
+
+// ValidVersion reports whether v is a client version of the form
+// MAJOR.MINOR.PATCH, optionally prefixed by "v".
+func ValidVersion(v string) bool {
+	return versionRe.MatchString(v)
+}
+
+// Older reports whether client version v is older than min. Versions are of
+// the form MAJOR.MINOR.PATCH, optionally prefixed by "v". Invalid versions
+// are never older.
+func Older(v, min string) bool {
+	a, okA := parseVersion(v)
+	b, okB := parseVersion(min)
+	if !okA || !okB {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var p [3]int
+	if !versionRe.MatchString(v) {
+		return p, false
+	}
+	for i, f := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return p, false
+		}
+		p[i] = n
+	}
+	return p, true
+}
+
+// Enabled reports whether the named feature flag is enabled.
+func (c Config) Enabled(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether both configs have the same settings. Nil and empty
+// lists are equal, since Datastore may load either.
+func (c Config) Equal(o Config) bool {
+	if c.GCTTL != o.GCTTL || c.GCInterval != o.GCInterval ||
+		c.MinClientVersion != o.MinClientVersion ||
+		c.DeprecatedClientVersion != o.DeprecatedClientVersion || !c.ClientSunset.Equal(o.ClientSunset) ||
+		len(c.ServiceVersions) != len(o.ServiceVersions) || len(c.Features) != len(o.Features) {
+		return false
+	}
+	for i := range c.ServiceVersions {
+		if c.ServiceVersions[i] != o.ServiceVersions[i] {
+			return false
+		}
+	}
+	for i := range c.Features {
+		if c.Features[i] != o.Features[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Datastore is the subset of the Datastore client used to persist the config.
+// It is implemented by *datastore.Client.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+}
+
+// Target applies config changes to a running component.
+type Target interface {
+	Reconfigure(ttl, interval time.Duration)
+}
+
+// Manager loads, persists, and applies the runtime config.
+type Manager struct {
+	ds        Datastore
+	namespace string
+	target    Target
+
+	mu      sync.Mutex
+	current Config
+}
+
+// NewManager creates a new Manager that starts with the given defaults and
+// applies config changes to target. Entities are stored in the given Datastore
+// namespace.
+func NewManager(ds Datastore, namespace string, defaults Config, target Target) *Manager {
+	return &Manager{
+		ds:        ds,
+		namespace: namespace,
+		target:    target,
+		current:   defaults,
+	}
+}
+
+// Get returns the current config.
+func (m *Manager) Get() Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Set validates, persists, and applies the given config.
+func (m *Manager) Set(ctx context.Context, c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.ds.Put(ctx, m.key(), &c)
+	if err != nil {
+		return err
+	}
+	m.apply(c)
+	return nil
+}
+
+// Initialize persists the current config if no config was persisted, e.g.
+// when setting up a new namespace. It reports whether the config was saved.
+func (m *Manager) Initialize(ctx context.Context) (bool, error) {
+	if err := m.current.Validate(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.ds.Get(ctx, m.key(), &Config{})
+	switch {
+	case errors.Is(err, datastore.ErrNoSuchEntity):
+	case err != nil:
+		return false, err
+	default:
+		return false, nil
+	}
+	c := m.current
+	if _, err := m.ds.Put(ctx, m.key(), &c); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Load reads the persisted config and applies it if it differs from the
+// current config. If no config was persisted, the current config is kept.
+func (m *Manager) Load(ctx context.Context) error {
+	c := Config{}
+	err := m.ds.Get(ctx, m.key(), &c)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("persisted config is invalid: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !c.Equal(m.current) {
+		log.Printf("Applying runtime config: %+v", c)
+		m.apply(c)
+	}
+	return nil
+}
+
+// Run periodically reloads the persisted config until the context is
+// canceled, so changes made through one instance reach all others.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.Load(ctx); err != nil {
+				log.Println("failed to reload runtime config:", err)
+			}
+		}
+	}
+}
+
+// apply must be called with mu held.
+func (m *Manager) apply(c Config) {
+	m.current = c
+	m.target.Reconfigure(c.GCTTL, c.GCInterval)
+}
+
+func (m *Manager) key() *datastore.Key {
+	k := datastore.NameKey(Kind, Name, nil)
+	k.Namespace = m.namespace
+	return k
+}
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+type fakeDatastore struct {
+	c      *Config
+	getErr error
+	putErr error
+	key    *datastore.Key
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	if f.c == nil {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Config) = *f.c
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	c := *src.(*Config)
+	f.c = &c
+	return key, nil
+}
+
+type fakeTarget struct {
+	ttl      time.Duration
+	interval time.Duration
+	calls    int
+}
+
+func (f *fakeTarget) Reconfigure(ttl, interval time.Duration) {
+	f.ttl = ttl
+	f.interval = interval
+	f.calls++
+}
+
+var defaults = Config{GCTTL: 3 * time.Hour, GCInterval: 30 * time.Minute}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Config
+		wantErr error
+	}{
+		{name: "success", c: defaults},
+		{name: "error-ttl", c: Config{GCTTL: time.Minute, GCInterval: time.Hour}, wantErr: ErrInvalidGCTTL},
+		{name: "error-interval", c: Config{GCTTL: time.Hour, GCInterval: time.Second}, wantErr: ErrInvalidGCInterval},
+		{
+			name: "success-versions-features",
+			c: Config{
+				GCTTL: time.Hour, GCInterval: time.Hour, MinClientVersion: "v0.1.0",
+				ServiceVersions: []ServiceVersion{{Service: "ndt", MinClientVersion: "0.2.0"}},
+				Features:        []string{"org_setup", "v2"},
+			},
+		},
+		{name: "error-version", c: Config{GCTTL: time.Hour, GCInterval: time.Hour, MinClientVersion: "latest"}, wantErr: ErrInvalidVersion},
+		{name: "error-deprecated-version", c: Config{GCTTL: time.Hour, GCInterval: time.Hour, DeprecatedClientVersion: "soon"}, wantErr: ErrInvalidVersion},
+		{
+			name: "error-service-version",
+			c: Config{
+				GCTTL: time.Hour, GCInterval: time.Hour,
+				ServiceVersions: []ServiceVersion{{Service: "ndt"}},
+			},
+			wantErr: ErrInvalidVersion,
+		},
+		{
+			name: "error-service-duplicate",
+			c: Config{
+				GCTTL: time.Hour, GCInterval: time.Hour,
+				ServiceVersions: []ServiceVersion{{Service: "ndt", MinClientVersion: "0.1.0"}, {Service: "ndt", MinClientVersion: "0.2.0"}},
+			},
+			wantErr: ErrInvalidService,
+		},
+		{
+			name: "error-service-name",
+			c: Config{
+				GCTTL: time.Hour, GCInterval: time.Hour,
+				ServiceVersions: []ServiceVersion{{Service: "NDT", MinClientVersion: "0.1.0"}},
+			},
+			wantErr: ErrInvalidService,
+		},
+		{name: "error-feature", c: Config{GCTTL: time.Hour, GCInterval: time.Hour, Features: []string{"Bad-Name"}}, wantErr: ErrInvalidFeature},
+		{name: "error-feature-duplicate", c: Config{GCTTL: time.Hour, GCInterval: time.Hour, Features: []string{"a", "a"}}, wantErr: ErrInvalidFeature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); err != tt.wantErr {
+				t.Errorf("Validate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_MinVersion(t *testing.T) {
+	c := Config{
+		MinClientVersion: "0.1.0",
+		ServiceVersions:  []ServiceVersion{{Service: "ndt", MinClientVersion: "0.2.0"}},
+	}
+	if got := c.MinVersion("ndt"); got != "0.2.0" {
+		t.Errorf("MinVersion(ndt) = %q, want 0.2.0", got)
+	}
+	if got := c.MinVersion("other"); got != "0.1.0" {
+		t.Errorf("MinVersion(other) = %q, want 0.1.0", got)
+	}
+	if got := c.MinVersion(""); got != "0.1.0" {
+		t.Errorf("MinVersion() = %q, want 0.1.0", got)
+	}
+}
+
+func TestOlder(t *testing.T) {
+	tests := []struct {
+		v, min string
+		want   bool
+	}{
+		{v: "0.1.0", min: "0.2.0", want: true},
+		{v: "v0.9.9", min: "0.10.0", want: true},
+		{v: "1.0.0", min: "v0.10.0"},
+		{v: "0.2.0", min: "0.2.0"},
+		{v: "0.2.1", min: "0.2.0"},
+		{v: "dev", min: "0.2.0"},
+		{v: "0.1.0", min: ""},
+	}
+	for _, tt := range tests {
+		if got := Older(tt.v, tt.min); got != tt.want {
+			t.Errorf("Older(%q, %q) = %t, want %t", tt.v, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_Enabled(t *testing.T) {
+	c := Config{Features: []string{"a", "b"}}
+	if !c.Enabled("b") || c.Enabled("c") {
+		t.Errorf("Enabled() = %t, %t; want true, false", c.Enabled("b"), c.Enabled("c"))
+	}
+}
+
+func TestConfig_Equal(t *testing.T) {
+	c := Config{GCTTL: time.Hour, Features: []string{"a"}}
+	tests := []struct {
+		name string
+		o    Config
+		want bool
+	}{
+		{name: "equal", o: Config{GCTTL: time.Hour, Features: []string{"a"}}, want: true},
+		{name: "empty-lists", o: Config{GCTTL: time.Hour, Features: []string{"a"}, ServiceVersions: []ServiceVersion{}}, want: true},
+		{name: "ttl", o: Config{GCTTL: time.Minute, Features: []string{"a"}}},
+		{name: "features", o: Config{GCTTL: time.Hour, Features: []string{"b"}}},
+		{name: "versions", o: Config{GCTTL: time.Hour, Features: []string{"a"}, ServiceVersions: []ServiceVersion{{Service: "ndt"}}}},
+		{name: "version", o: Config{GCTTL: time.Hour, Features: []string{"a"}, MinClientVersion: "0.1.0"}},
+		{name: "deprecated", o: Config{GCTTL: time.Hour, Features: []string{"a"}, DeprecatedClientVersion: "0.1.0"}},
+		{name: "sunset", o: Config{GCTTL: time.Hour, Features: []string{"a"}, ClientSunset: time.Unix(0, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Equal(tt.o); got != tt.want {
+				t.Errorf("Equal() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_Set(t *testing.T) {
+	ds := &fakeDatastore{}
+	target := &fakeTarget{}
+	m := NewManager(ds, "autojoin", defaults, target)
+
+	if got := m.Get(); !got.Equal(defaults) {
+		t.Errorf("Get() = %v, want %v", got, defaults)
+	}
+
+	c := Config{GCTTL: 336 * time.Hour, GCInterval: time.Hour}
+	if err := m.Set(context.Background(), c); err != nil {
+		t.Fatalf("Set() returned err: %v", err)
+	}
+	if !m.Get().Equal(c) || !ds.c.Equal(c) || target.ttl != c.GCTTL || target.interval != c.GCInterval {
+		t.Errorf("Set() did not persist and apply config; got %v, ds %v, target %v", m.Get(), ds.c, target)
+	}
+	if ds.key.Namespace != "autojoin" || ds.key.Kind != Kind || ds.key.Name != Name {
+		t.Errorf("Set() used wrong key: %v", ds.key)
+	}
+
+	// Invalid configs are neither persisted nor applied.
+	if err := m.Set(context.Background(), Config{}); err == nil {
+		t.Errorf("Set() returned nil, want error")
+	}
+	// Datastore errors leave the current config unchanged.
+	ds.putErr = errors.New("fake put error")
+	if err := m.Set(context.Background(), defaults); err == nil || !m.Get().Equal(c) {
+		t.Errorf("Set() = %v with config %v, want error and %v", err, m.Get(), c)
+	}
+}
+
+func TestManager_Load(t *testing.T) {
+	persisted := Config{GCTTL: 336 * time.Hour, GCInterval: time.Hour}
+	tests := []struct {
+		name      string
+		ds        *fakeDatastore
+		want      Config
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			ds:        &fakeDatastore{c: &persisted},
+			want:      persisted,
+			wantCalls: 1,
+		},
+		{
+			name: "success-unchanged",
+			ds:   &fakeDatastore{c: &defaults},
+			want: defaults,
+		},
+		{
+			name: "success-missing",
+			ds:   &fakeDatastore{},
+			want: defaults,
+		},
+		{
+			name:    "error-get",
+			ds:      &fakeDatastore{getErr: errors.New("fake get error")},
+			want:    defaults,
+			wantErr: true,
+		},
+		{
+			name:    "error-invalid",
+			ds:      &fakeDatastore{c: &Config{}},
+			want:    defaults,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &fakeTarget{}
+			m := NewManager(tt.ds, "autojoin", defaults, target)
+			err := m.Load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !m.Get().Equal(tt.want) || target.calls != tt.wantCalls {
+				t.Errorf("Load() = %v after %d calls, want %v after %d", m.Get(), target.calls, tt.want, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestManager_Initialize(t *testing.T) {
+	persisted := Config{GCTTL: 336 * time.Hour, GCInterval: time.Hour}
+	tests := []struct {
+		name      string
+		ds        *fakeDatastore
+		defaults  Config
+		want      Config
+		wantSaved bool
+		wantErr   bool
+	}{
+		{
+			name:      "success-missing",
+			ds:        &fakeDatastore{},
+			want:      defaults,
+			wantSaved: true,
+		},
+		{
+			name: "success-exists",
+			ds:   &fakeDatastore{c: &persisted},
+			want: persisted,
+		},
+		{
+			name:    "error-get",
+			ds:      &fakeDatastore{getErr: errors.New("fake get error")},
+			wantErr: true,
+		},
+		{
+			name:    "error-put",
+			ds:      &fakeDatastore{putErr: errors.New("fake put error")},
+			wantErr: true,
+		},
+		{
+			name:     "error-invalid",
+			ds:       &fakeDatastore{},
+			defaults: Config{GCTTL: time.Minute, GCInterval: time.Hour},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.defaults.Equal(Config{}) {
+				tt.defaults = defaults
+			}
+			m := NewManager(tt.ds, "test", tt.defaults, nil)
+			saved, err := m.Initialize(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Initialize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if saved != tt.wantSaved {
+				t.Errorf("Initialize() = %t, want %t", saved, tt.wantSaved)
+			}
+			if !tt.wantErr && !tt.ds.c.Equal(tt.want) {
+				t.Errorf("Initialize() persisted %v, want %v", *tt.ds.c, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_Run(t *testing.T) {
+	persisted := Config{GCTTL: 336 * time.Hour, GCInterval: time.Hour}
+	target := &fakeTarget{}
+	m := NewManager(&fakeDatastore{c: &persisted}, "autojoin", defaults, target)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Run(ctx, 10*time.Millisecond); err != nil {
+		t.Errorf("Run() returned err: %v", err)
+	}
+	if !m.Get().Equal(persisted) {
+		t.Errorf("Run() did not load config; got %v, want %v", m.Get(), persisted)
+	}
+}
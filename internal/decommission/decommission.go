@@ -0,0 +1,109 @@
+// Package decommission records nodes that are removed from the Autojoin API,
+// so that the data pipeline can bound the time ranges it attributes to each
+// machine.
+package decommission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/host"
+)
+
+// Record describes the active period and final state of a removed node.
+// Records are written as newline delimited JSON, which may be loaded directly
+// into BigQuery.
+type Record struct {
+	Hostname string
+	Org      string
+	// Reason is why the node was removed, e.g. "deleted" or "expired".
+	Reason string
+	// Registered is the approximate time of the first registration. It is
+	// zero if the node was removed before it was first seen by the GC.
+	Registered time.Time
+	// LastUpdate is the time of the last registration.
+	LastUpdate time.Time
+	// Decommissioned is the time the node was removed.
+	Decommissioned time.Time
+	// Annotation is the final annotation returned to the node.
+	Annotation *v0.ServerAnnotation `json:",omitempty"`
+}
+
+// Uploader saves report data to the named object.
+type Uploader interface {
+	Upload(ctx context.Context, object string, data []byte) error
+}
+
+// Reporter creates and uploads Records.
+type Reporter struct {
+	uploader Uploader
+	prefix   string
+}
+
+// NewReporter creates a new Reporter that saves records under the given
+// object prefix.
+func NewReporter(u Uploader, prefix string) *Reporter {
+	return &Reporter{uploader: u, prefix: prefix}
+}
+
+// Report uploads a Record for the given hostname and final status. Each record
+// is saved to a separate object named by date, hostname, and removal time.
+func (r *Reporter) Report(ctx context.Context, hostname string, s tracker.Status, reason string) error {
+	now := time.Now().UTC()
+	rec := NewRecord(hostname, s, reason, now)
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	object := fmt.Sprintf("%s%s/%s-%d.json", r.prefix, now.Format("2006/01/02"), hostname, now.Unix())
+	return r.uploader.Upload(ctx, object, append(b, '\n'))
+}
+
+// NewRecord creates a Record for the given hostname and final status.
+func NewRecord(hostname string, s tracker.Status, reason string, now time.Time) *Record {
+	rec := &Record{
+		Hostname:       hostname,
+		Reason:         reason,
+		Decommissioned: now,
+	}
+	if name, err := host.Parse(hostname); err == nil {
+		rec.Org = name.Org
+	}
+	if s.Registered != nil {
+		rec.Registered = time.Unix(s.Registered.Unix, 0).UTC()
+	}
+	if s.DNS != nil {
+		rec.LastUpdate = time.Unix(s.DNS.LastUpdate, 0).UTC()
+		if s.DNS.Registration != nil {
+			rec.Annotation = s.DNS.Registration.Annotation
+		}
+	}
+	return rec
+}
+
+// GCSUploader uploads objects to a GCS bucket.
+type GCSUploader struct {
+	client *storage.Client
+	bucket string
+}
+
+// NewGCSUploader creates a new GCSUploader for the given bucket.
+func NewGCSUploader(client *storage.Client, bucket string) *GCSUploader {
+	return &GCSUploader{client: client, bucket: bucket}
+}
+
+// Upload writes data to the named object.
+func (g *GCSUploader) Upload(ctx context.Context, object string, data []byte) error {
+	w := g.client.Bucket(g.bucket).Object(object).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
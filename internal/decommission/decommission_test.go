@@ -0,0 +1,106 @@
+package decommission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/uuid-annotator/annotator"
+)
+
+type fakeUploader struct {
+	object string
+	data   []byte
+	err    error
+}
+
+func (f *fakeUploader) Upload(ctx context.Context, object string, data []byte) error {
+	f.object = object
+	f.data = data
+	return f.err
+}
+
+func TestNewRecord(t *testing.T) {
+	const hostname = "ndt-lga3356-040e9f4b.mlab.sandbox.measurement-lab.org"
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		host   string
+		status tracker.Status
+		want   Record
+	}{
+		{
+			name: "success",
+			host: hostname,
+			status: tracker.Status{
+				DNS: &tracker.DNSRecord{
+					LastUpdate: 200,
+					Registration: &v0.Registration{
+						Annotation: &v0.ServerAnnotation{
+							Annotation: annotator.ServerAnnotations{Site: "lga3356"},
+						},
+					},
+				},
+				Registered: &tracker.Timestamp{Unix: 100},
+			},
+			want: Record{
+				Hostname:       hostname,
+				Org:            "mlab",
+				Reason:         "expired",
+				Registered:     time.Unix(100, 0).UTC(),
+				LastUpdate:     time.Unix(200, 0).UTC(),
+				Decommissioned: now,
+			},
+		},
+		{
+			name: "success-empty-status",
+			host: "invalid",
+			want: Record{
+				Hostname:       "invalid",
+				Reason:         "expired",
+				Decommissioned: now,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewRecord(tt.host, tt.status, "expired", now)
+			ann := got.Annotation
+			got.Annotation = nil
+			if *got != tt.want {
+				t.Errorf("NewRecord() = %+v, want %+v", got, tt.want)
+			}
+			if tt.status.DNS != nil && (ann == nil || ann.Annotation.Site != "lga3356") {
+				t.Errorf("NewRecord() returned wrong annotation; got %v", ann)
+			}
+		})
+	}
+}
+
+func TestReporter_Report(t *testing.T) {
+	const hostname = "ndt-lga3356-040e9f4b.mlab.sandbox.measurement-lab.org"
+	u := &fakeUploader{}
+	r := NewReporter(u, "decommission/")
+	s := tracker.Status{DNS: &tracker.DNSRecord{LastUpdate: 200}}
+
+	if err := r.Report(context.Background(), hostname, s, "deleted"); err != nil {
+		t.Fatalf("Report() returned err: %v", err)
+	}
+	if !strings.HasPrefix(u.object, "decommission/") || !strings.Contains(u.object, hostname) {
+		t.Errorf("Report() used wrong object name; got %q", u.object)
+	}
+	rec := Record{}
+	if err := json.Unmarshal(u.data, &rec); err != nil || rec.Reason != "deleted" || rec.Org != "mlab" {
+		t.Errorf("Report() uploaded wrong record; got %+v, %v", rec, err)
+	}
+
+	u.err = errors.New("fake upload error")
+	if err := r.Report(context.Background(), hostname, s, "deleted"); err != u.err {
+		t.Errorf("Report() returned wrong error; got %v, want %v", err, u.err)
+	}
+}
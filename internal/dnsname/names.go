@@ -1,20 +1,77 @@
 package dnsname
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/m-lab/go/host"
+)
+
+// DefaultDomain is the base domain of production deployments.
+const DefaultDomain = "measurement-lab.org"
+
+// validDomain matches domains with two labels, e.g. "measurement-lab.org".
+// Hostnames have exactly five labels, and the two below the domain are the
+// org and project, so deeper domains cannot be parsed from hostnames.
+var validDomain = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?\.[a-z]([a-z0-9-]*[a-z0-9])?$`)
+
+// ValidateDomain returns an error if domain is not a valid base domain, e.g.
+// "measurement-lab.org".
+func ValidateDomain(domain string) error {
+	if !validDomain.MatchString(domain) {
+		return fmt.Errorf("invalid domain %q: must have two lowercase labels, e.g. %q", domain, DefaultDomain)
+	}
+	return nil
+}
 
 // ProjectZone returns the project zone name, e.g. "autojoin-sandbox-measurement-lab-org".
-func ProjectZone(project string) string {
-	return "autojoin-" + strings.TrimPrefix(project, "mlab-") + "-measurement-lab-org"
+func ProjectZone(project, domain string) string {
+	return "autojoin-" + strings.TrimPrefix(project, "mlab-") + "-" + zoneSuffix(domain)
+}
+
+// ProjectDNS returns the DNS name of the project zone, e.g. "sandbox.measurement-lab.org.".
+func ProjectDNS(project, domain string) string {
+	return strings.TrimPrefix(project, "mlab-") + "." + domain + "."
 }
 
-// OrgZone returns the organization zone name based on the given organization and
-// project, e.g. "autojoin-foo-sandbox-measurement-lab-org".
-func OrgZone(org, project string) string {
+// OrgZone returns the organization zone name based on the given organization,
+// project, and domain, e.g. "autojoin-foo-sandbox-measurement-lab-org".
+func OrgZone(org, project, domain string) string {
 	// NOTE: prefix prevents name collision with existing zones when the org is "mlab".
-	return "autojoin-" + org + "-" + strings.TrimPrefix(project, "mlab-") + "-measurement-lab-org"
+	return "autojoin-" + org + "-" + strings.TrimPrefix(project, "mlab-") + "-" + zoneSuffix(domain)
+}
+
+// ZoneOrg returns the organization of the given organization zone name, the
+// inverse of OrgZone. It returns false if the zone is not an organization zone
+// of the project and domain, e.g. the project zone.
+func ZoneOrg(zone, project, domain string) (string, bool) {
+	rest, ok := strings.CutPrefix(zone, "autojoin-")
+	if !ok {
+		return "", false
+	}
+	org, ok := strings.CutSuffix(rest, "-"+strings.TrimPrefix(project, "mlab-")+"-"+zoneSuffix(domain))
+	if !ok || org == "" {
+		return "", false
+	}
+	return org, true
+}
+
+// OrgDNS returns the DNS name for the given org, project, and domain, e.g.
+// "foo.autojoin.measurement-lab.org."
+func OrgDNS(org, project, domain string) string {
+	return org + "." + strings.TrimPrefix(project, "mlab-") + "." + domain + "."
+}
+
+// SiteDNS returns the DNS name of the round-robin record of all nodes of the
+// service and site of the given hostname, e.g.
+// "ndt-lga12345.foo.sandbox.measurement-lab.org.".
+func SiteDNS(name host.Name) string {
+	return fmt.Sprintf("%s-%s.%s.%s.%s.", name.Service, name.Site, name.Org, name.Project, name.Domain)
 }
 
-// OrgDNS returns the DNS name for the given org and project, e.g. "foo.autojoin.measurement-lab.org."
-func OrgDNS(org, project string) string {
-	return org + "." + strings.TrimPrefix(project, "mlab-") + ".measurement-lab.org."
+// zoneSuffix returns the domain as used in zone names, which may not contain
+// dots, e.g. "measurement-lab-org".
+func zoneSuffix(domain string) string {
+	return strings.ReplaceAll(domain, ".", "-")
 }
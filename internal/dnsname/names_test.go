@@ -1,56 +1,163 @@
 package dnsname
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/m-lab/go/host"
+)
+
+func TestValidateDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		domain  string
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			domain: "measurement-lab.org",
+		},
+		{
+			name:   "success-other",
+			domain: "example.org",
+		},
+		{
+			name:    "error-empty",
+			domain:  "",
+			wantErr: true,
+		},
+		{
+			name:    "error-one-label",
+			domain:  "org",
+			wantErr: true,
+		},
+		{
+			name:    "error-three-labels",
+			domain:  "test.example.org",
+			wantErr: true,
+		},
+		{
+			name:    "error-trailing-dot",
+			domain:  "example.org.",
+			wantErr: true,
+		},
+		{
+			name:    "error-uppercase",
+			domain:  "Example.org",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateDomain(tt.domain); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDomain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
 
 func TestProjectZone(t *testing.T) {
 	tests := []struct {
 		name    string
 		project string
+		domain  string
 		want    string
 	}{
 		{
 			name:    "success",
 			project: "mlab-sandbox",
+			domain:  DefaultDomain,
 			want:    "autojoin-sandbox-measurement-lab-org",
 		},
 		{
 			name:    "success",
 			project: "mlab-autojoin",
+			domain:  DefaultDomain,
 			want:    "autojoin-autojoin-measurement-lab-org",
 		},
+		{
+			name:    "success-domain",
+			project: "mlab-sandbox",
+			domain:  "example.org",
+			want:    "autojoin-sandbox-example-org",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ProjectZone(tt.project); got != tt.want {
+			if got := ProjectZone(tt.project, tt.domain); got != tt.want {
 				t.Errorf("ProjectZone() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestProjectDNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		domain  string
+		want    string
+	}{
+		{
+			name:    "success",
+			project: "mlab-sandbox",
+			domain:  DefaultDomain,
+			want:    "sandbox.measurement-lab.org.",
+		},
+		{
+			name:    "success-autojoin",
+			project: "mlab-autojoin",
+			domain:  DefaultDomain,
+			want:    "autojoin.measurement-lab.org.",
+		},
+		{
+			name:    "success-domain",
+			project: "mlab-sandbox",
+			domain:  "example.org",
+			want:    "sandbox.example.org.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ProjectDNS(tt.project, tt.domain); got != tt.want {
+				t.Errorf("ProjectDNS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestOrgZone(t *testing.T) {
 	tests := []struct {
 		name    string
 		org     string
 		project string
+		domain  string
 		want    string
 	}{
 		{
 			name:    "success",
 			org:     "mlab",
 			project: "mlab-sandbox",
+			domain:  DefaultDomain,
 			want:    "autojoin-mlab-sandbox-measurement-lab-org",
 		},
 		{
 			name:    "success",
 			org:     "rnp",
 			project: "mlab-autojoin",
+			domain:  DefaultDomain,
 			want:    "autojoin-rnp-autojoin-measurement-lab-org",
 		},
+		{
+			name:    "success-domain",
+			org:     "foo",
+			project: "mlab-sandbox",
+			domain:  "example.org",
+			want:    "autojoin-foo-sandbox-example-org",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := OrgZone(tt.org, tt.project); got != tt.want {
+			if got := OrgZone(tt.org, tt.project, tt.domain); got != tt.want {
 				t.Errorf("OrgZone() = %v, want %v", got, tt.want)
 			}
 		})
@@ -62,26 +169,103 @@ func TestOrgDNS(t *testing.T) {
 		name    string
 		org     string
 		project string
+		domain  string
 		want    string
 	}{
 		{
 			name:    "success",
 			org:     "foo",
 			project: "mlab-sandbox",
+			domain:  DefaultDomain,
 			want:    "foo.sandbox.measurement-lab.org.",
 		},
 		{
 			name:    "success",
 			org:     "mlab",
 			project: "mlab-autojoin",
+			domain:  DefaultDomain,
 			want:    "mlab.autojoin.measurement-lab.org.",
 		},
+		{
+			name:    "success-domain",
+			org:     "foo",
+			project: "mlab-sandbox",
+			domain:  "example.org",
+			want:    "foo.sandbox.example.org.",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := OrgDNS(tt.org, tt.project); got != tt.want {
+			if got := OrgDNS(tt.org, tt.project, tt.domain); got != tt.want {
 				t.Errorf("OrgDNS() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestZoneOrg(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		project string
+		domain  string
+		want    string
+		wantOK  bool
+	}{
+		{
+			name:    "success",
+			zone:    "autojoin-foo-sandbox-measurement-lab-org",
+			project: "mlab-sandbox",
+			domain:  DefaultDomain,
+			want:    "foo",
+			wantOK:  true,
+		},
+		{
+			name:    "success-org-with-dashes",
+			zone:    "autojoin-foo-bar-sandbox-example-org",
+			project: "mlab-sandbox",
+			domain:  "example.org",
+			want:    "foo-bar",
+			wantOK:  true,
+		},
+		{
+			name:    "project-zone",
+			zone:    "autojoin-sandbox-measurement-lab-org",
+			project: "mlab-sandbox",
+			domain:  DefaultDomain,
+		},
+		{
+			name:    "other-project",
+			zone:    "autojoin-foo-staging-measurement-lab-org",
+			project: "mlab-sandbox",
+			domain:  DefaultDomain,
+		},
+		{
+			name:    "other-zone",
+			zone:    "foo-sandbox-measurement-lab-org",
+			project: "mlab-sandbox",
+			domain:  DefaultDomain,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ZoneOrg(tt.zone, tt.project, tt.domain)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ZoneOrg() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+			if ok && OrgZone(got, tt.project, tt.domain) != tt.zone {
+				t.Errorf("OrgZone(ZoneOrg()) = %q, want %q", OrgZone(got, tt.project, tt.domain), tt.zone)
+			}
+		})
+	}
+}
+
+func TestSiteDNS(t *testing.T) {
+	name, err := host.Parse("ndt-lga12345-01020304.foo.sandbox.measurement-lab.org")
+	if err != nil {
+		t.Fatalf("host.Parse() failed: %v", err)
+	}
+	if got, want := SiteDNS(name), "ndt-lga12345.foo.sandbox.measurement-lab.org."; got != want {
+		t.Errorf("SiteDNS() = %q, want %q", got, want)
+	}
+}
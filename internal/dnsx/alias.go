@@ -0,0 +1,98 @@
+package dnsx
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/m-lab/go/host"
+	"google.golang.org/api/dns/v1"
+)
+
+// TXTAliases is the key of the TXT record value with the other services of a
+// node, e.g. "aliases=msak,wehe". Each service has a CNAME alias of the
+// hostname, e.g. msak-lga12345-01020304.foo.sandbox.measurement-lab.org.
+const TXTAliases = "aliases"
+
+var recordTypeCNAME = "CNAME"
+
+// txtValue returns the value of key in the given TXT rrdata, e.g. as created
+// by TXTData, or "" if the key is missing.
+func txtValue(txt, key string) string {
+	for _, f := range strings.Fields(txt) {
+		kv, err := strconv.Unquote(f)
+		if err != nil {
+			continue
+		}
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// aliasNames returns the alias of hostname for every service listed in the
+// given TXT rrdata.
+func aliasNames(hostname, txt string) []string {
+	services := txtValue(txt, TXTAliases)
+	if services == "" {
+		return nil
+	}
+	name, err := host.Parse(strings.TrimSuffix(hostname, "."))
+	if err != nil || name.Version != "v3" || name.Service == "" {
+		return nil
+	}
+	aliases := []string{}
+	for _, service := range strings.Split(services, ",") {
+		name.Service = service
+		aliases = append(aliases, name.StringAll()+".")
+	}
+	return aliases
+}
+
+func appendAlias(rrs []*dns.ResourceRecordSet, alias, hostname string) []*dns.ResourceRecordSet {
+	return append(rrs, &dns.ResourceRecordSet{
+		Name:    alias,
+		Type:    recordTypeCNAME,
+		Ttl:     DefaultTTL,
+		Rrdatas: []string{hostname},
+	})
+}
+
+// appendAliasChanges adds the changes that replace the aliases listed in the
+// current TXT record, if any, with the aliases listed in txt.
+func appendAliasChanges(chg *dns.Change, rrTXT *dns.ResourceRecordSet, hostname, txt string) {
+	prev := map[string]bool{}
+	if rrTXT != nil && len(rrTXT.Rrdatas) == 1 {
+		for _, alias := range aliasNames(hostname, rrTXT.Rrdatas[0]) {
+			prev[alias] = true
+		}
+	}
+	for _, alias := range aliasNames(hostname, txt) {
+		if prev[alias] {
+			// The alias already exists.
+			delete(prev, alias)
+			continue
+		}
+		chg.Additions = appendAlias(chg.Additions, alias, hostname)
+	}
+	stale := []string{}
+	for alias := range prev {
+		stale = append(stale, alias)
+	}
+	sort.Strings(stale)
+	for _, alias := range stale {
+		chg.Deletions = appendAlias(chg.Deletions, alias, hostname)
+	}
+}
+
+// appendAliasDeletions adds the deletions of the aliases listed in the TXT
+// record of hostname.
+func appendAliasDeletions(chg *dns.Change, rrTXT *dns.ResourceRecordSet, hostname string) {
+	if len(rrTXT.Rrdatas) != 1 {
+		return
+	}
+	for _, alias := range aliasNames(hostname, rrTXT.Rrdatas[0]) {
+		chg.Deletions = appendAlias(chg.Deletions, alias, hostname)
+	}
+}
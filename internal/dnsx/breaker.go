@@ -0,0 +1,120 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrCircuitOpen is returned by the calls of a Breaker while it is open.
+var ErrCircuitOpen = errors.New("cloud dns circuit breaker is open")
+
+// Breaker is a circuit breaker around the record reads and changes of a DNS
+// Service. After Threshold consecutive failures, the breaker opens and calls
+// fail with ErrCircuitOpen for the Cooldown, so registrations do not block on
+// an unavailable Cloud DNS. Once the Cooldown ends, calls are tried again and
+// the first success closes the breaker, while a failure opens it again.
+//
+// Only errors that suggest an outage, i.e. 429 and 5xx responses and network
+// errors, count as failures. Other errors, e.g. of missing records, do not.
+// A Threshold of zero disables the breaker.
+type Breaker struct {
+	dnsiface.Service
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewBreaker creates a new Breaker around the calls of s.
+func NewBreaker(s dnsiface.Service, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Service: s, Threshold: threshold, Cooldown: cooldown}
+}
+
+// Open reports whether calls currently fail with ErrCircuitOpen.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.openUntil)
+}
+
+// ResourceRecordSetsGet gets an existing resource record set, if present.
+func (b *Breaker) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (*dns.ResourceRecordSet, error) {
+	if b.Open() {
+		return nil, ErrCircuitOpen
+	}
+	rr, err := b.Service.ResourceRecordSetsGet(ctx, project, zone, name, rtype)
+	b.record(err)
+	return rr, err
+}
+
+// ResourceRecordSetsByName lists the resource record sets with the given name.
+func (b *Breaker) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	if b.Open() {
+		return nil, ErrCircuitOpen
+	}
+	rrs, err := b.Service.ResourceRecordSetsByName(ctx, project, zone, name)
+	b.record(err)
+	return rrs, err
+}
+
+// ChangeCreate applies the given change set.
+func (b *Breaker) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
+	if b.Open() {
+		return nil, ErrCircuitOpen
+	}
+	chg, err := b.Service.ChangeCreate(ctx, project, zone, change)
+	b.record(err)
+	return chg, err
+}
+
+// ChangeGet gets the change with the given ID.
+func (b *Breaker) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	if b.Open() {
+		return nil, ErrCircuitOpen
+	}
+	chg, err := b.Service.ChangeGet(ctx, project, zone, id)
+	b.record(err)
+	return chg, err
+}
+
+func (b *Breaker) record(err error) {
+	if b.Threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !outage(err) {
+		if b.failures >= b.Threshold {
+			metrics.DNSCircuitOpen.Set(0)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openUntil = time.Now().Add(b.Cooldown)
+		metrics.DNSCircuitOpen.Set(1)
+	}
+}
+
+// outage reports whether err suggests that Cloud DNS is unavailable.
+func outage(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestBreaker(t *testing.T) {
+	f := &fakeDNS{getErr: &googleapi.Error{Code: 503}}
+	b := NewBreaker(f, 2, 50*time.Millisecond)
+	ctx := context.Background()
+
+	// Missing records are not failures.
+	f.getErr = &googleapi.Error{Code: 404}
+	for i := 0; i < 3; i++ {
+		b.ResourceRecordSetsGet(ctx, "mlab-sandbox", "zone", "name", "A")
+	}
+	if b.Open() {
+		t.Fatalf("Open() = true after not found errors, want false")
+	}
+
+	// Consecutive outages open the breaker.
+	f.getErr = &googleapi.Error{Code: 503}
+	b.ResourceRecordSetsGet(ctx, "mlab-sandbox", "zone", "name", "A")
+	if b.Open() {
+		t.Fatalf("Open() = true after one failure, want false")
+	}
+	b.ResourceRecordSetsGet(ctx, "mlab-sandbox", "zone", "name", "A")
+	if !b.Open() {
+		t.Fatalf("Open() = false after two failures, want true")
+	}
+	f.getErr = nil
+	if _, err := b.ResourceRecordSetsGet(ctx, "mlab-sandbox", "zone", "name", "A"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("ResourceRecordSetsGet() = %v, want %v", err, ErrCircuitOpen)
+	}
+	if _, err := b.ChangeCreate(ctx, "mlab-sandbox", "zone", &dns.Change{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("ChangeCreate() = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// After the cooldown, a failure opens the breaker again.
+	time.Sleep(60 * time.Millisecond)
+	f.chgErr = errors.New("fake network error")
+	chg := &dns.Change{Additions: []*dns.ResourceRecordSet{{}}}
+	if _, err := b.ChangeCreate(ctx, "mlab-sandbox", "zone", chg); errors.Is(err, ErrCircuitOpen) || err == nil {
+		t.Errorf("ChangeCreate() = %v, want fake error", err)
+	}
+	if !b.Open() {
+		t.Fatalf("Open() = false after failed trial, want true")
+	}
+
+	// After the cooldown, a success closes the breaker.
+	time.Sleep(60 * time.Millisecond)
+	f.chgErr = nil
+	if _, err := b.ChangeCreate(ctx, "mlab-sandbox", "zone", chg); err != nil {
+		t.Errorf("ChangeCreate() = %v, want nil", err)
+	}
+	f.getErr = &googleapi.Error{Code: 503}
+	b.ResourceRecordSetsGet(ctx, "mlab-sandbox", "zone", "name", "A")
+	if b.Open() {
+		t.Errorf("Open() = true after success and one failure, want false")
+	}
+}
+
+func TestBreaker_disabled(t *testing.T) {
+	f := &fakeDNS{getErr: &googleapi.Error{Code: 503}}
+	b := NewBreaker(f, 0, time.Minute)
+	for i := 0; i < 3; i++ {
+		b.ResourceRecordSetsGet(context.Background(), "mlab-sandbox", "zone", "name", "A")
+	}
+	if b.Open() {
+		t.Errorf("Open() = true, want false")
+	}
+}
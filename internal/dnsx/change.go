@@ -0,0 +1,57 @@
+package dnsx
+
+import (
+	"context"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/dns/v1"
+)
+
+// changeDone is the status of a change whose records are served by all
+// authoritative name servers of the zone.
+const changeDone = "done"
+
+// changePollInterval is the time between reads of the status of a change.
+var changePollInterval = time.Second
+
+// WaitChange waits until Cloud DNS reports that the given change is done,
+// polling its status until timeout. It returns whether the change is done; a
+// change that is still pending after timeout is not an error.
+func (d *Manager) WaitChange(ctx context.Context, chg *dns.Change, timeout time.Duration) (_ bool, err error) {
+	ctx, span := tracing.Start(ctx, "dnsx.WaitChange", attribute.String("change", chg.Id), attribute.String("zone", d.Zone))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	t := time.NewTicker(changePollInterval)
+	defer t.Stop()
+	for chg.Status != changeDone {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-t.C:
+		}
+		chg, err = d.Service.ChangeGet(ctx, d.Project, d.Zone, chg.Id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	metrics.DNSPropagationDuration.WithLabelValues(d.Zone).Observe(time.Since(start).Seconds())
+	return true, nil
+}
+
+// ChangeDone reports whether the change with the given ID is done.
+func (d *Manager) ChangeDone(ctx context.Context, id string) (bool, error) {
+	chg, err := d.Service.ChangeGet(ctx, d.Project, d.Zone, id)
+	if err != nil {
+		return false, err
+	}
+	return chg.Status == changeDone, nil
+}
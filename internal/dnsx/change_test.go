@@ -0,0 +1,81 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/api/dns/v1"
+)
+
+func TestManager_WaitChange(t *testing.T) {
+	const zone = "autojoin-foo-sandbox-measurement-lab-org"
+	changePollInterval = time.Millisecond
+	tests := []struct {
+		name     string
+		chg      *dns.Change
+		results  map[string]result
+		wantDone bool
+		wantErr  bool
+	}{
+		{
+			name:     "success-created-done",
+			chg:      &dns.Change{Id: "1", Status: "done"},
+			wantDone: true,
+		},
+		{
+			name: "success-done",
+			chg:  &dns.Change{Id: "1", Status: "pending"},
+			results: map[string]result{
+				"getchg-" + zone + "-1": {chg: &dns.Change{Id: "1", Status: "done"}},
+			},
+			wantDone: true,
+		},
+		{
+			name: "success-timeout",
+			chg:  &dns.Change{Id: "1", Status: "pending"},
+			results: map[string]result{
+				"getchg-" + zone + "-1": {chg: &dns.Change{Id: "1", Status: "pending"}},
+			},
+		},
+		{
+			name: "error-get",
+			chg:  &dns.Change{Id: "1", Status: "pending"},
+			results: map[string]result{
+				"getchg-" + zone + "-1": {err: errors.New("fake get error")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", zone)
+			done, err := d.WaitChange(context.Background(), tt.chg, 20*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Manager.WaitChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if done != tt.wantDone {
+				t.Errorf("Manager.WaitChange() = %t, want %t", done, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestManager_ChangeDone(t *testing.T) {
+	const zone = "autojoin-foo-sandbox-measurement-lab-org"
+	d := NewManager(&fakeDNS2{results: map[string]result{
+		"getchg-" + zone + "-1": {chg: &dns.Change{Id: "1", Status: "done"}},
+		"getchg-" + zone + "-2": {chg: &dns.Change{Id: "2", Status: "pending"}},
+		"getchg-" + zone + "-3": {err: errors.New("fake get error")},
+	}}, "mlab-sandbox", zone)
+	if done, err := d.ChangeDone(context.Background(), "1"); err != nil || !done {
+		t.Errorf("ChangeDone(done) = %t, %v, want true, nil", done, err)
+	}
+	if done, err := d.ChangeDone(context.Background(), "2"); err != nil || done {
+		t.Errorf("ChangeDone(pending) = %t, %v, want false, nil", done, err)
+	}
+	if _, err := d.ChangeDone(context.Background(), "3"); err == nil {
+		t.Errorf("ChangeDone() returned nil error")
+	}
+}
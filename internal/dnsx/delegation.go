@@ -0,0 +1,93 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/dns/v1"
+)
+
+// Limits of the name servers of a delegated zone.
+const (
+	MinNameServers = 2
+	MaxNameServers = 13
+)
+
+// ErrInvalidNameServers is returned for name servers that cannot serve a
+// delegated zone.
+var ErrInvalidNameServers = errors.New("invalid name servers")
+
+// NameServers returns the fully qualified, sorted name servers of a zone with
+// DNS name zoneDNS that is delegated to servers outside Cloud DNS. There must
+// be MinNameServers to MaxNameServers distinct hostnames. Name servers within
+// the delegated zone are rejected, since they would need glue records.
+func NameServers(servers []string, zoneDNS string) ([]string, error) {
+	if len(servers) < MinNameServers || len(servers) > MaxNameServers {
+		return nil, fmt.Errorf("%w: want %d to %d name servers, got %d", ErrInvalidNameServers, MinNameServers, MaxNameServers, len(servers))
+	}
+	seen := map[string]bool{}
+	result := []string{}
+	for _, s := range servers {
+		host := strings.TrimSuffix(strings.ToLower(s), ".")
+		switch {
+		case !isTarget(host) || net.ParseIP(host) != nil:
+			return nil, fmt.Errorf("%w: invalid hostname %q", ErrInvalidNameServers, s)
+		case host+"." == zoneDNS || strings.HasSuffix(host+".", "."+zoneDNS):
+			return nil, fmt.Errorf("%w: %q is within the delegated zone", ErrInvalidNameServers, s)
+		case seen[host]:
+			return nil, fmt.Errorf("%w: duplicate %q", ErrInvalidNameServers, s)
+		}
+		seen[host] = true
+		result = append(result, host+".")
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// MatchesNameServers reports whether the zone split rr delegates to the given
+// name servers, e.g. as returned by NameServers.
+func MatchesNameServers(rr *dns.ResourceRecordSet, servers []string) bool {
+	curr := []string{}
+	for _, r := range rr.Rrdatas {
+		curr = append(curr, strings.ToLower(r))
+	}
+	sort.Strings(curr)
+	return strings.Join(curr, ",") == strings.Join(servers, ",")
+}
+
+// RegisterDelegation guarantees that the zone split of the given zone
+// delegates to the given name servers instead of a Cloud DNS zone. A previous
+// split is replaced, and the DS record of a previous Cloud DNS zone is
+// removed, since its keys do not sign the delegated zone.
+func (d *Manager) RegisterDelegation(ctx context.Context, zone *dns.ManagedZone, servers []string) (*dns.ResourceRecordSet, error) {
+	ns, err := NameServers(servers, zone.DnsName)
+	if err != nil {
+		return nil, err
+	}
+	add := &dns.ResourceRecordSet{Name: zone.DnsName, Type: recordTypeNS, Ttl: DefaultTTL, Rrdatas: ns}
+	chg := &dns.Change{Additions: []*dns.ResourceRecordSet{add}}
+	for _, rtype := range []string{recordTypeNS, recordTypeDS} {
+		rr, err := d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, zone.DnsName, rtype)
+		switch {
+		case isNotFound(err):
+		case err != nil:
+			return nil, err
+		case rr == nil:
+		case rtype == recordTypeNS && MatchesNameServers(rr, ns):
+			chg.Additions = nil
+		default:
+			appendDeletions(chg, rr, zone.DnsName)
+		}
+	}
+	if chg.Additions == nil && chg.Deletions == nil {
+		return add, nil
+	}
+	if _, err := d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg); err != nil {
+		return nil, err
+	}
+	return add, nil
+}
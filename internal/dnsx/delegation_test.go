@@ -0,0 +1,149 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestNameServers(t *testing.T) {
+	const zoneDNS = "foo.sandbox.measurement-lab.org."
+	tests := []struct {
+		name    string
+		servers []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "success",
+			servers: []string{"NS2.foo.example.", "ns1.foo.example"},
+			want:    []string{"ns1.foo.example.", "ns2.foo.example."},
+		},
+		{
+			name:    "error-too-few",
+			servers: []string{"ns1.foo.example"},
+			wantErr: true,
+		},
+		{
+			name:    "error-too-many",
+			servers: make([]string, MaxNameServers+1),
+			wantErr: true,
+		},
+		{
+			name:    "error-hostname",
+			servers: []string{"ns1.foo.example", "ns2"},
+			wantErr: true,
+		},
+		{
+			name:    "error-address",
+			servers: []string{"ns1.foo.example", "192.0.2.1"},
+			wantErr: true,
+		},
+		{
+			name:    "error-within-zone",
+			servers: []string{"ns1.foo.example", "ns." + zoneDNS},
+			wantErr: true,
+		},
+		{
+			name:    "error-duplicate",
+			servers: []string{"ns1.foo.example", "NS1.foo.example."},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NameServers(tt.servers, zoneDNS)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NameServers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidNameServers) {
+				t.Errorf("NameServers() error = %v, want %v", err, ErrInvalidNameServers)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NameServers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_RegisterDelegation(t *testing.T) {
+	const parent = "autojoin-sandbox-measurement-lab-org"
+	zone := &dns.ManagedZone{Name: "autojoin-foo-sandbox-measurement-lab-org", DnsName: "foo.sandbox.measurement-lab.org."}
+	getNS := "get-" + parent + "-" + zone.DnsName + "-NS"
+	getDS := "get-" + parent + "-" + zone.DnsName + "-DS"
+	notFound := result{err: &googleapi.Error{Code: 404}}
+	servers := []string{"ns1.foo.example", "ns2.foo.example"}
+	tests := []struct {
+		name    string
+		servers []string
+		results map[string]result
+		wantErr error
+	}{
+		{
+			name:    "success-create",
+			servers: servers,
+			results: map[string]result{getNS: notFound, getDS: notFound},
+		},
+		{
+			name:    "success-exists",
+			servers: servers,
+			results: map[string]result{
+				getNS:           {get: &dns.ResourceRecordSet{Rrdatas: []string{"ns2.foo.example.", "ns1.foo.example."}}},
+				getDS:           notFound,
+				"chg-" + parent: {err: fmt.Errorf("unexpected change")},
+			},
+		},
+		{
+			name:    "success-replace-cloud-dns",
+			servers: servers,
+			results: map[string]result{
+				getNS: {get: &dns.ResourceRecordSet{Rrdatas: []string{"ns-cloud-a1.googledomains.com."}}},
+				getDS: {get: &dns.ResourceRecordSet{Rrdatas: []string{"12345 8 2 ABCDEF"}}},
+			},
+		},
+		{
+			name:    "error-invalid",
+			servers: servers[:1],
+			wantErr: ErrInvalidNameServers,
+		},
+		{
+			name:    "error-get",
+			servers: servers,
+			results: map[string]result{getNS: {err: fmt.Errorf("fake get error")}},
+			wantErr: errors.New("any"),
+		},
+		{
+			name:    "error-change",
+			servers: servers,
+			results: map[string]result{
+				getNS:           notFound,
+				getDS:           notFound,
+				"chg-" + parent: {err: fmt.Errorf("fake change error")},
+			},
+			wantErr: errors.New("any"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", parent)
+			got, err := d.RegisterDelegation(context.Background(), zone, tt.servers)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Manager.RegisterDelegation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrInvalidNameServers && !errors.Is(err, ErrInvalidNameServers) {
+				t.Errorf("Manager.RegisterDelegation() error = %v, want %v", err, ErrInvalidNameServers)
+			}
+			if err != nil {
+				return
+			}
+			if want := []string{"ns1.foo.example.", "ns2.foo.example."}; got.Type != "NS" || !reflect.DeepEqual(got.Rrdatas, want) {
+				t.Errorf("Manager.RegisterDelegation() = %+v, want NS %v", got, want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package dnsiface
 
 import (
 	"context"
+	"time"
 
+	"github.com/m-lab/autojoin/internal/metrics"
 	"google.golang.org/api/dns/v1"
 )
 
@@ -10,8 +12,14 @@ import (
 type Service interface {
 	ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, type_ string) (*dns.ResourceRecordSet, error)
 	ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error)
+	ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error)
 	GetManagedZone(ctx context.Context, project, zoneName string) (*dns.ManagedZone, error)
 	CreateManagedZone(ctx context.Context, project string, z *dns.ManagedZone) (*dns.ManagedZone, error)
+	DeleteManagedZone(ctx context.Context, project, zoneName string) error
+	ResourceRecordSetsList(ctx context.Context, project string, zone string) ([]*dns.ResourceRecordSet, error)
+	ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error)
+	DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error)
+	ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error)
 }
 
 // CloudDNSService implements the DNS Service interface.
@@ -31,9 +39,19 @@ func (c *CloudDNSService) ResourceRecordSetsGet(ctx context.Context, project str
 
 // ChangeCreate applies the given change set.
 func (c *CloudDNSService) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
+	start := time.Now()
+	defer func() {
+		metrics.DNSChangeDuration.WithLabelValues(zone).Observe(time.Since(start).Seconds())
+	}()
 	return c.Service.Changes.Create(project, zone, change).Context(ctx).Do()
 }
 
+// ChangeGet gets the change with the given ID, e.g. to check whether its
+// status is "done".
+func (c *CloudDNSService) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	return c.Service.Changes.Get(project, zone, id).Context(ctx).Do()
+}
+
 // GetManagedZone gets the named zone.
 func (c *CloudDNSService) GetManagedZone(ctx context.Context, project, zoneName string) (*dns.ManagedZone, error) {
 	return c.Service.ManagedZones.Get(project, zoneName).Context(ctx).Do()
@@ -43,3 +61,50 @@ func (c *CloudDNSService) GetManagedZone(ctx context.Context, project, zoneName
 func (c *CloudDNSService) CreateManagedZone(ctx context.Context, project string, zone *dns.ManagedZone) (*dns.ManagedZone, error) {
 	return c.Service.ManagedZones.Create(project, zone).Context(ctx).Do()
 }
+
+// DeleteManagedZone deletes the named zone. Zones must be empty of records
+// other than the NS and SOA records of the zone.
+func (c *CloudDNSService) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	return c.Service.ManagedZones.Delete(project, zoneName).Context(ctx).Do()
+}
+
+// ResourceRecordSetsList lists all resource record sets of the named zone.
+func (c *CloudDNSService) ResourceRecordSetsList(ctx context.Context, project string, zone string) ([]*dns.ResourceRecordSet, error) {
+	rrs := []*dns.ResourceRecordSet{}
+	err := c.Service.ResourceRecordSets.List(project, zone).Pages(ctx, func(resp *dns.ResourceRecordSetsListResponse) error {
+		rrs = append(rrs, resp.Rrsets...)
+		return nil
+	})
+	return rrs, err
+}
+
+// ResourceRecordSetsByName lists the resource record sets of every type with
+// the given name in the named zone.
+func (c *CloudDNSService) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	rrs := []*dns.ResourceRecordSet{}
+	err := c.Service.ResourceRecordSets.List(project, zone).Name(name).Pages(ctx, func(resp *dns.ResourceRecordSetsListResponse) error {
+		rrs = append(rrs, resp.Rrsets...)
+		return nil
+	})
+	return rrs, err
+}
+
+// DNSKeysList lists the DNSSEC keys of the named zone.
+func (c *CloudDNSService) DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error) {
+	keys := []*dns.DnsKey{}
+	err := c.Service.DnsKeys.List(project, zone).Pages(ctx, func(resp *dns.DnsKeysListResponse) error {
+		keys = append(keys, resp.DnsKeys...)
+		return nil
+	})
+	return keys, err
+}
+
+// ManagedZonesList lists all managed zones of the project.
+func (c *CloudDNSService) ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error) {
+	zones := []*dns.ManagedZone{}
+	err := c.Service.ManagedZones.List(project).Pages(ctx, func(resp *dns.ManagedZonesListResponse) error {
+		zones = append(zones, resp.ManagedZones...)
+		return nil
+	})
+	return zones, err
+}
@@ -0,0 +1,190 @@
+package dnsiface
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryService retries the record reads and changes of the Service it wraps
+// after transient errors, i.e. 429 and 5xx responses, with jittered
+// exponential backoff. After a 429 response with a Retry-After header, all
+// calls wait until the quota is expected to be available again.
+//
+// A change that failed with a 5xx response may have been applied. Its retry
+// then fails with the conflict, like a change made by another instance.
+type RetryService struct {
+	Service
+	// Retries is the number of times a failed call is retried.
+	Retries int
+	// MinBackoff is the delay before the first retry. The delay doubles for
+	// each retry, up to MaxBackoff. Calls are neither retried nor throttled
+	// if Retry-After exceeds MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// NewRetryService creates a new RetryService that retries the calls of s.
+func NewRetryService(s Service, retries int) *RetryService {
+	return &RetryService{
+		Service:    s,
+		Retries:    retries,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+// ResourceRecordSetsGet gets an existing resource record set, if present.
+func (r *RetryService) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (rr *dns.ResourceRecordSet, err error) {
+	err = r.retry(ctx, "ResourceRecordSetsGet", func() error {
+		rr, err = r.Service.ResourceRecordSetsGet(ctx, project, zone, name, rtype)
+		return err
+	})
+	return rr, err
+}
+
+// ResourceRecordSetsByName lists the resource record sets with the given name.
+func (r *RetryService) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) (rrs []*dns.ResourceRecordSet, err error) {
+	err = r.retry(ctx, "ResourceRecordSetsByName", func() error {
+		rrs, err = r.Service.ResourceRecordSetsByName(ctx, project, zone, name)
+		return err
+	})
+	return rrs, err
+}
+
+// DNSKeysList lists the DNSSEC keys of the named zone.
+func (r *RetryService) DNSKeysList(ctx context.Context, project string, zone string) (keys []*dns.DnsKey, err error) {
+	err = r.retry(ctx, "DNSKeysList", func() error {
+		keys, err = r.Service.DNSKeysList(ctx, project, zone)
+		return err
+	})
+	return keys, err
+}
+
+// ManagedZonesList lists all managed zones of the project.
+func (r *RetryService) ManagedZonesList(ctx context.Context, project string) (zones []*dns.ManagedZone, err error) {
+	err = r.retry(ctx, "ManagedZonesList", func() error {
+		zones, err = r.Service.ManagedZonesList(ctx, project)
+		return err
+	})
+	return zones, err
+}
+
+// ChangeGet gets the change with the given ID.
+func (r *RetryService) ChangeGet(ctx context.Context, project string, zone string, id string) (chg *dns.Change, err error) {
+	err = r.retry(ctx, "ChangeGet", func() error {
+		chg, err = r.Service.ChangeGet(ctx, project, zone, id)
+		return err
+	})
+	return chg, err
+}
+
+// ChangeCreate applies the given change set.
+func (r *RetryService) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (chg *dns.Change, err error) {
+	err = r.retry(ctx, "ChangeCreate", func() error {
+		chg, err = r.Service.ChangeCreate(ctx, project, zone, change)
+		return err
+	})
+	return chg, err
+}
+
+func (r *RetryService) retry(ctx context.Context, method string, f func() error) error {
+	backoff := r.MinBackoff
+	for i := 0; ; i++ {
+		if err := r.throttle(ctx); err != nil {
+			return err
+		}
+		err := f()
+		code, ok := transient(err)
+		if !ok {
+			return err
+		}
+		after, ok := retryAfter(err)
+		if ok && after > r.MaxBackoff {
+			// Waiting would hold the call longer than any backoff.
+			return err
+		}
+		if ok && code == http.StatusTooManyRequests {
+			r.mu.Lock()
+			if t := time.Now().Add(after); t.After(r.until) {
+				r.until = t
+			}
+			r.mu.Unlock()
+		}
+		if i >= r.Retries {
+			return err
+		}
+		// Jitter spreads the retries of concurrent calls.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if after > wait {
+			wait = after
+		}
+		metrics.DNSRetries.WithLabelValues(method, strconv.Itoa(code)).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+		if backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// throttle waits until the time given by the last Retry-After of a 429
+// response, if any.
+func (r *RetryService) throttle(ctx context.Context) error {
+	r.mu.Lock()
+	wait := time.Until(r.until)
+	r.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// transient returns the status code of errors that may succeed if retried.
+func transient(err error) (int, bool) {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return 0, false
+	}
+	return gerr.Code, gerr.Code == http.StatusTooManyRequests || gerr.Code >= 500
+}
+
+// retryAfter returns the delay of the Retry-After header of the error
+// response, in seconds or as an HTTP date.
+func retryAfter(err error) (time.Duration, bool) {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Header == nil {
+		return 0, false
+	}
+	v := gerr.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
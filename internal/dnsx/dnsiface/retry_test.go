@@ -0,0 +1,163 @@
+package dnsiface
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+type fakeService struct {
+	Service
+	errs  []error
+	calls int
+}
+
+func (f *fakeService) next() error {
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *fakeService) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (*dns.ResourceRecordSet, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	return &dns.ResourceRecordSet{Name: name}, nil
+}
+
+func (f *fakeService) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
+	if err := f.next(); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func apiError(code int, retryAfter string) error {
+	h := http.Header{}
+	if retryAfter != "" {
+		h.Set("Retry-After", retryAfter)
+	}
+	return &googleapi.Error{Code: code, Header: h}
+}
+
+func TestRetryService(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			wantCalls: 1,
+		},
+		{
+			name:      "success-after-5xx",
+			errs:      []error{apiError(http.StatusServiceUnavailable, ""), apiError(http.StatusInternalServerError, "")},
+			wantCalls: 3,
+		},
+		{
+			name:      "success-after-429",
+			errs:      []error{apiError(http.StatusTooManyRequests, "0")},
+			wantCalls: 2,
+		},
+		{
+			name:      "error-not-transient",
+			errs:      []error{apiError(http.StatusNotFound, "")},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "error-other",
+			errs:      []error{errors.New("fake error")},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name: "error-retries-exhausted",
+			errs: []error{
+				apiError(http.StatusBadGateway, ""), apiError(http.StatusBadGateway, ""),
+				apiError(http.StatusBadGateway, ""), apiError(http.StatusBadGateway, ""),
+			},
+			wantCalls: 4,
+			wantErr:   true,
+		},
+		{
+			name:      "error-retry-after-too-long",
+			errs:      []error{apiError(http.StatusTooManyRequests, "3600")},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeService{errs: tt.errs}
+			r := NewRetryService(f, 3)
+			r.MinBackoff = time.Millisecond
+			r.MaxBackoff = 10 * time.Millisecond
+
+			_, err := r.ResourceRecordSetsGet(context.Background(), "mlab-sandbox", "zone", "name", "A")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResourceRecordSetsGet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if f.calls != tt.wantCalls {
+				t.Errorf("ResourceRecordSetsGet() made %d calls, want %d", f.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryService_ChangeCreate(t *testing.T) {
+	f := &fakeService{errs: []error{apiError(http.StatusServiceUnavailable, "")}}
+	r := NewRetryService(f, 3)
+	r.MinBackoff = time.Millisecond
+
+	chg, err := r.ChangeCreate(context.Background(), "mlab-sandbox", "zone", &dns.Change{})
+	if err != nil || chg == nil || f.calls != 2 {
+		t.Errorf("ChangeCreate() = %v, %v after %d calls, want change after 2", chg, err, f.calls)
+	}
+}
+
+func TestRetryService_throttle(t *testing.T) {
+	f := &fakeService{errs: []error{apiError(http.StatusTooManyRequests, "1")}}
+	r := NewRetryService(f, 0)
+	r.MaxBackoff = 2 * time.Second
+
+	if _, err := r.ChangeCreate(context.Background(), "mlab-sandbox", "zone", &dns.Change{}); err == nil {
+		t.Fatalf("ChangeCreate() returned nil, want error")
+	}
+	// Later calls wait for the Retry-After of the 429 response.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.ChangeCreate(ctx, "mlab-sandbox", "zone", &dns.Change{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ChangeCreate() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if f.calls != 1 {
+		t.Errorf("ChangeCreate() made %d calls, want 1", f.calls)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	if d, ok := retryAfter(apiError(http.StatusTooManyRequests, "5")); !ok || d != 5*time.Second {
+		t.Errorf("retryAfter(5) = %s, %t; want 5s", d, ok)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d, ok := retryAfter(apiError(http.StatusTooManyRequests, date)); !ok || d <= 0 || d > time.Minute {
+		t.Errorf("retryAfter(%s) = %s, %t; want up to 1m", date, d, ok)
+	}
+	if _, ok := retryAfter(apiError(http.StatusTooManyRequests, "")); ok {
+		t.Errorf("retryAfter() = true, want false")
+	}
+	if _, ok := retryAfter(errors.New("fake error")); ok {
+		t.Errorf("retryAfter(error) = true, want false")
+	}
+}
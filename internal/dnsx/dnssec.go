@@ -0,0 +1,128 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/dns/v1"
+)
+
+// ErrNoKeySigningKey is returned when a zone has no active key signing key,
+// e.g. because DNSSEC is off.
+var ErrNoKeySigningKey = errors.New("no active key signing key")
+
+var recordTypeDS = "DS"
+
+// Numbers of DNSSEC algorithms and digest types, as used in DS records.
+var (
+	dnssecAlgorithms = map[string]int{
+		"rsasha1":         5,
+		"rsasha256":       8,
+		"rsasha512":       10,
+		"ecdsap256sha256": 13,
+		"ecdsap384sha384": 14,
+	}
+	dnssecDigests = map[string]int{
+		"sha1":   1,
+		"sha256": 2,
+		"sha384": 4,
+	}
+)
+
+// dsRecords returns the sorted rrdatas of the DS records of the active key
+// signing keys, e.g. "12345 8 2 <digest>".
+func dsRecords(keys []*dns.DnsKey) []string {
+	ds := []string{}
+	for _, k := range keys {
+		if k.Type != "keySigning" || !k.IsActive {
+			continue
+		}
+		for _, d := range k.Digests {
+			alg, ok1 := dnssecAlgorithms[k.Algorithm]
+			typ, ok2 := dnssecDigests[d.Type]
+			if !ok1 || !ok2 {
+				continue
+			}
+			ds = append(ds, fmt.Sprintf("%d %d %d %s", k.KeyTag, alg, typ, strings.ToUpper(d.Digest)))
+		}
+	}
+	sort.Strings(ds)
+	return ds
+}
+
+// matchesDS reports whether the DS record has exactly the given rrdatas.
+func matchesDS(rr *dns.ResourceRecordSet, ds []string) bool {
+	curr := []string{}
+	for _, r := range rr.Rrdatas {
+		curr = append(curr, strings.ToUpper(r))
+	}
+	sort.Strings(curr)
+	return strings.Join(curr, ",") == strings.Join(ds, ",")
+}
+
+// RegisterDS guarantees that the parent zone has the DS record of the key
+// signing keys of the given zone, which completes the DNSSEC chain of trust
+// of the zone split created by RegisterZoneSplit.
+func (d *Manager) RegisterDS(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error) {
+	keys, err := d.Service.DNSKeysList(ctx, d.Project, zone.Name)
+	if err != nil {
+		return nil, err
+	}
+	ds := dsRecords(keys)
+	if len(ds) == 0 {
+		return nil, ErrNoKeySigningKey
+	}
+	rr, err := d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, zone.DnsName, recordTypeDS)
+	switch {
+	case isNotFound(err):
+		rr = nil
+	case err != nil:
+		return nil, err
+	case matchesDS(rr, ds):
+		return rr, nil
+	}
+	add := &dns.ResourceRecordSet{Name: zone.DnsName, Type: recordTypeDS, Ttl: DefaultTTL, Rrdatas: ds}
+	chg := &dns.Change{Additions: []*dns.ResourceRecordSet{add}}
+	if rr != nil {
+		// The key signing keys changed since the record was published.
+		appendDeletions(chg, rr, zone.DnsName)
+	}
+	if _, err := d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg); err != nil {
+		return nil, err
+	}
+	return add, nil
+}
+
+// CheckDNSSEC returns the DNSSEC problems of the given zone, e.g. that
+// DNSSEC is off or that the parent zone does not have its DS record. A
+// healthy zone returns no problems.
+func (d *Manager) CheckDNSSEC(ctx context.Context, zone *dns.ManagedZone) ([]string, error) {
+	z, err := d.Service.GetManagedZone(ctx, d.Project, zone.Name)
+	if err != nil {
+		return nil, err
+	}
+	if z.DnssecConfig == nil || z.DnssecConfig.State != "on" {
+		return []string{"dnssec is off"}, nil
+	}
+	keys, err := d.Service.DNSKeysList(ctx, d.Project, zone.Name)
+	if err != nil {
+		return nil, err
+	}
+	ds := dsRecords(keys)
+	if len(ds) == 0 {
+		return []string{ErrNoKeySigningKey.Error()}, nil
+	}
+	rr, err := d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, zone.DnsName, recordTypeDS)
+	switch {
+	case isNotFound(err):
+		return []string{"ds record is not published in " + d.Zone}, nil
+	case err != nil:
+		return nil, err
+	case !matchesDS(rr, ds):
+		return []string{"ds record does not match the key signing keys"}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,197 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var testKeys = []*dns.DnsKey{
+	{
+		Type:      "keySigning",
+		IsActive:  true,
+		Algorithm: "rsasha256",
+		KeyTag:    12345,
+		Digests:   []*dns.DnsKeyDigest{{Type: "sha256", Digest: "abcdef"}},
+	},
+	{
+		Type:      "zoneSigning",
+		IsActive:  true,
+		Algorithm: "rsasha256",
+		KeyTag:    23456,
+	},
+	{
+		Type:      "keySigning",
+		IsActive:  false,
+		Algorithm: "rsasha256",
+		KeyTag:    34567,
+		Digests:   []*dns.DnsKeyDigest{{Type: "sha256", Digest: "fedcba"}},
+	},
+}
+
+func TestManager_RegisterDS(t *testing.T) {
+	const parent = "autojoin-sandbox-measurement-lab-org"
+	zone := &dns.ManagedZone{Name: "autojoin-foo-sandbox-measurement-lab-org", DnsName: "foo.sandbox.measurement-lab.org."}
+	get := "get-" + parent + "-" + zone.DnsName + "-DS"
+	tests := []struct {
+		name    string
+		results map[string]result
+		want    string
+		wantErr error
+	}{
+		{
+			name: "success-create",
+			results: map[string]result{
+				"keys-" + zone.Name: {keys: testKeys},
+				get:                 {err: &googleapi.Error{Code: 404}},
+			},
+			want: "12345 8 2 ABCDEF",
+		},
+		{
+			name: "success-exists",
+			results: map[string]result{
+				"keys-" + zone.Name: {keys: testKeys},
+				get:                 {get: &dns.ResourceRecordSet{Rrdatas: []string{"12345 8 2 abcdef"}}},
+				"chg-" + parent:     {err: fmt.Errorf("unexpected change")},
+			},
+			want: "12345 8 2 abcdef",
+		},
+		{
+			name: "success-replace",
+			results: map[string]result{
+				"keys-" + zone.Name: {keys: testKeys},
+				get:                 {get: &dns.ResourceRecordSet{Rrdatas: []string{"34567 8 2 FEDCBA"}}},
+			},
+			want: "12345 8 2 ABCDEF",
+		},
+		{
+			name: "error-no-key-signing-key",
+			results: map[string]result{
+				"keys-" + zone.Name: {keys: testKeys[1:]},
+			},
+			wantErr: ErrNoKeySigningKey,
+		},
+		{
+			name: "error-get",
+			results: map[string]result{
+				"keys-" + zone.Name: {keys: testKeys},
+				get:                 {err: fmt.Errorf("fake get error")},
+			},
+			wantErr: errors.New("any"),
+		},
+		{
+			name: "error-change",
+			results: map[string]result{
+				"keys-" + zone.Name: {keys: testKeys},
+				get:                 {err: &googleapi.Error{Code: 404}},
+				"chg-" + parent:     {err: fmt.Errorf("fake change error")},
+			},
+			wantErr: errors.New("any"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", parent)
+			got, err := d.RegisterDS(context.Background(), zone)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Manager.RegisterDS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrNoKeySigningKey && !errors.Is(err, ErrNoKeySigningKey) {
+				t.Errorf("Manager.RegisterDS() error = %v, want %v", err, ErrNoKeySigningKey)
+			}
+			if err != nil {
+				return
+			}
+			if len(got.Rrdatas) != 1 || got.Rrdatas[0] != tt.want {
+				t.Errorf("Manager.RegisterDS() = %v, want %q", got.Rrdatas, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_CheckDNSSEC(t *testing.T) {
+	const parent = "autojoin-sandbox-measurement-lab-org"
+	zone := &dns.ManagedZone{Name: "autojoin-foo-sandbox-measurement-lab-org", DnsName: "foo.sandbox.measurement-lab.org."}
+	get := "get-" + parent + "-" + zone.DnsName + "-DS"
+	on := result{zone: &dns.ManagedZone{Name: zone.Name, DnssecConfig: &dns.ManagedZoneDnsSecConfig{State: "on"}}}
+	tests := []struct {
+		name    string
+		results map[string]result
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "success-healthy",
+			results: map[string]result{
+				"getzone-" + zone.Name: on,
+				"keys-" + zone.Name:    {keys: testKeys},
+				get:                    {get: &dns.ResourceRecordSet{Rrdatas: []string{"12345 8 2 ABCDEF"}}},
+			},
+		},
+		{
+			name: "success-dnssec-off",
+			results: map[string]result{
+				"getzone-" + zone.Name: {zone: &dns.ManagedZone{Name: zone.Name}},
+			},
+			want: "dnssec is off",
+		},
+		{
+			name: "success-no-key-signing-key",
+			results: map[string]result{
+				"getzone-" + zone.Name: on,
+				"keys-" + zone.Name:    {keys: testKeys[1:]},
+			},
+			want: "no active key signing key",
+		},
+		{
+			name: "success-ds-missing",
+			results: map[string]result{
+				"getzone-" + zone.Name: on,
+				"keys-" + zone.Name:    {keys: testKeys},
+				get:                    {err: &googleapi.Error{Code: 404}},
+			},
+			want: "ds record is not published in " + parent,
+		},
+		{
+			name: "success-ds-mismatch",
+			results: map[string]result{
+				"getzone-" + zone.Name: on,
+				"keys-" + zone.Name:    {keys: testKeys},
+				get:                    {get: &dns.ResourceRecordSet{Rrdatas: []string{"34567 8 2 FEDCBA"}}},
+			},
+			want: "ds record does not match the key signing keys",
+		},
+		{
+			name: "error-get-zone",
+			results: map[string]result{
+				"getzone-" + zone.Name: {err: fmt.Errorf("fake zone error")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-keys",
+			results: map[string]result{
+				"getzone-" + zone.Name: on,
+				"keys-" + zone.Name:    {err: fmt.Errorf("fake keys error")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", parent)
+			got, err := d.CheckDNSSEC(context.Background(), zone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Manager.CheckDNSSEC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, "; ") != tt.want {
+				t.Errorf("Manager.CheckDNSSEC() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/dns/v1"
+)
+
+// MaxExtraValues is the maximum number of values of an extra record.
+const MaxExtraValues = 8
+
+// ExtraTypes are the record types of extra records.
+var ExtraTypes = []string{recordTypeA, recordTypeAAAA, recordTypeCNAME, recordTypeTXT}
+
+var (
+	// ErrInvalidExtra is returned for extra records with an invalid name,
+	// type or value.
+	ErrInvalidExtra = errors.New("invalid extra record")
+	// ErrExtraConflict is returned when a CNAME record would share its name
+	// with other records.
+	ErrExtraConflict = errors.New("cname records cannot share their name with other records")
+	// ErrExtraNotFound is returned when deleting an extra record that does
+	// not exist.
+	ErrExtraNotFound = errors.New("extra record not found")
+)
+
+var (
+	extraLabel  = regexp.MustCompile(`^[a-z0-9]{1,63}$`)
+	targetLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// ExtraName returns the DNS name of the extra record with the given name
+// relative to the zone with DNS name zoneDNS, e.g. "*.lab" in
+// "foo.sandbox.measurement-lab.org." is
+// "*.lab.foo.sandbox.measurement-lab.org.". Labels may not contain dashes, so
+// extra records never collide with node and site records. A wildcard is only
+// allowed as the first of at least two labels.
+func ExtraName(relative, zoneDNS string) (string, error) {
+	labels := strings.Split(relative, ".")
+	if len(labels) > 4 {
+		return "", fmt.Errorf("%w: name %q has more than 4 labels", ErrInvalidExtra, relative)
+	}
+	for i, l := range labels {
+		if l == "*" && i == 0 && len(labels) > 1 {
+			continue
+		}
+		if !extraLabel.MatchString(l) {
+			return "", fmt.Errorf("%w: invalid name %q", ErrInvalidExtra, relative)
+		}
+	}
+	return relative + "." + zoneDNS, nil
+}
+
+// ExtraRecord returns the record set of the named extra record after
+// validating its type and values. TXT values are quoted, and CNAME targets
+// are made fully qualified.
+func ExtraRecord(name, rtype string, values []string) (*dns.ResourceRecordSet, error) {
+	if len(values) == 0 || len(values) > MaxExtraValues {
+		return nil, fmt.Errorf("%w: want 1 to %d values, got %d", ErrInvalidExtra, MaxExtraValues, len(values))
+	}
+	rrdatas := []string{}
+	for _, v := range values {
+		switch rtype {
+		case recordTypeA:
+			ip := net.ParseIP(v)
+			if ip == nil || ip.To4() == nil || strings.Contains(v, ":") {
+				return nil, fmt.Errorf("%w: invalid A value %q", ErrInvalidExtra, v)
+			}
+			rrdatas = append(rrdatas, ip.String())
+		case recordTypeAAAA:
+			ip := net.ParseIP(v)
+			if ip == nil || !strings.Contains(v, ":") {
+				return nil, fmt.Errorf("%w: invalid AAAA value %q", ErrInvalidExtra, v)
+			}
+			rrdatas = append(rrdatas, ip.String())
+		case recordTypeCNAME:
+			target := strings.TrimSuffix(v, ".")
+			if len(values) != 1 || !isTarget(target) {
+				return nil, fmt.Errorf("%w: invalid CNAME value %q", ErrInvalidExtra, v)
+			}
+			rrdatas = append(rrdatas, target+".")
+		case recordTypeTXT:
+			if len(v) > 255 || strings.IndexFunc(v, func(r rune) bool { return r < ' ' || r > '~' }) >= 0 {
+				return nil, fmt.Errorf("%w: invalid TXT value %q", ErrInvalidExtra, v)
+			}
+			rrdatas = append(rrdatas, strconv.Quote(v))
+		default:
+			return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidExtra, rtype)
+		}
+	}
+	return &dns.ResourceRecordSet{Name: name, Type: rtype, Ttl: DefaultTTL, Rrdatas: rrdatas}, nil
+}
+
+// isTarget reports whether s is a valid hostname with at least two labels.
+func isTarget(s string) bool {
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 || len(s) > 253 {
+		return false
+	}
+	for _, l := range labels {
+		if !targetLabel.MatchString(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExtraRecord reports whether name is the name of an extra record, i.e. the
+// first label has no dash, unlike node and site records.
+func isExtraRecord(name string) bool {
+	return !strings.Contains(strings.SplitN(name, ".", 2)[0], "-")
+}
+
+// ListExtra returns the extra records of the zone with DNS name zoneDNS,
+// sorted by name and type.
+func (d *Manager) ListExtra(ctx context.Context, zoneDNS string) ([]*dns.ResourceRecordSet, error) {
+	rrs, err := d.Service.ResourceRecordSetsList(ctx, d.Project, d.Zone)
+	if err != nil {
+		return nil, err
+	}
+	extra := []*dns.ResourceRecordSet{}
+	for _, rr := range rrs {
+		if !strings.HasSuffix(rr.Name, "."+zoneDNS) || !isExtraRecord(rr.Name) {
+			continue
+		}
+		for _, t := range ExtraTypes {
+			if rr.Type == t {
+				extra = append(extra, rr)
+			}
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool {
+		if extra[i].Name != extra[j].Name {
+			return extra[i].Name < extra[j].Name
+		}
+		return extra[i].Type < extra[j].Type
+	})
+	return extra, nil
+}
+
+// SetExtra adds the extra record, e.g. as returned by ExtraRecord, replacing
+// the record with the same name and type.
+func (d *Manager) SetExtra(ctx context.Context, rr *dns.ResourceRecordSet) error {
+	rrs, err := d.Service.ResourceRecordSetsByName(ctx, d.Project, d.Zone, rr.Name)
+	if err != nil {
+		return err
+	}
+	chg := &dns.Change{Additions: []*dns.ResourceRecordSet{rr}}
+	for _, r := range rrs {
+		switch {
+		case r.Type == rr.Type:
+			appendDeletions(chg, r, rr.Name)
+		case r.Type == recordTypeCNAME || rr.Type == recordTypeCNAME:
+			return ErrExtraConflict
+		}
+	}
+	_, err = d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	return err
+}
+
+// DeleteExtra deletes the named extra record of the given type.
+func (d *Manager) DeleteExtra(ctx context.Context, name, rtype string) error {
+	rr, err := d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, name, rtype)
+	switch {
+	case isNotFound(err):
+		return ErrExtraNotFound
+	case err != nil:
+		return err
+	}
+	chg := &dns.Change{}
+	appendDeletions(chg, rr, name)
+	_, err = d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	return err
+}
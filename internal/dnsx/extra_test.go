@@ -0,0 +1,252 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestExtraName(t *testing.T) {
+	const zoneDNS = "foo.sandbox.measurement-lab.org."
+	tests := []struct {
+		relative string
+		want     string
+		wantErr  bool
+	}{
+		{relative: "www", want: "www." + zoneDNS},
+		{relative: "*.lab", want: "*.lab." + zoneDNS},
+		{relative: "a.b.c.d", want: "a.b.c.d." + zoneDNS},
+		{relative: "", wantErr: true},
+		{relative: "*", wantErr: true},
+		{relative: "lab.*", wantErr: true},
+		{relative: "ndt-lga12345", wantErr: true},
+		{relative: "WWW", wantErr: true},
+		{relative: "a.b.c.d.e", wantErr: true},
+		{relative: "lab.", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.relative, func(t *testing.T) {
+			got, err := ExtraName(tt.relative, zoneDNS)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtraName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidExtra) {
+				t.Errorf("ExtraName() error = %v, want %v", err, ErrInvalidExtra)
+			}
+			if got != tt.want {
+				t.Errorf("ExtraName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtraRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		rtype   string
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "success-a", rtype: "A", values: []string{"192.0.2.1", "192.0.2.2"}, want: []string{"192.0.2.1", "192.0.2.2"}},
+		{name: "success-aaaa", rtype: "AAAA", values: []string{"2001:DB8::1"}, want: []string{"2001:db8::1"}},
+		{name: "success-cname", rtype: "CNAME", values: []string{"example.com"}, want: []string{"example.com."}},
+		{name: "success-txt", rtype: "TXT", values: []string{`v=1 "x"`}, want: []string{`"v=1 \"x\""`}},
+		{name: "error-no-values", rtype: "A", wantErr: true},
+		{name: "error-too-many-values", rtype: "TXT", values: make([]string, MaxExtraValues+1), wantErr: true},
+		{name: "error-a", rtype: "A", values: []string{"2001:db8::1"}, wantErr: true},
+		{name: "error-aaaa", rtype: "AAAA", values: []string{"192.0.2.1"}, wantErr: true},
+		{name: "error-cname-multiple", rtype: "CNAME", values: []string{"example.com", "example.org"}, wantErr: true},
+		{name: "error-cname-target", rtype: "CNAME", values: []string{"localhost"}, wantErr: true},
+		{name: "error-txt", rtype: "TXT", values: []string{"bad\n"}, wantErr: true},
+		{name: "error-type", rtype: "MX", values: []string{"example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtraRecord("www.foo.sandbox.measurement-lab.org.", tt.rtype, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtraRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Type != tt.rtype || got.Ttl != 300 || !reflect.DeepEqual(got.Rrdatas, tt.want) {
+				t.Errorf("ExtraRecord() = %+v, want %s %v", got, tt.rtype, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_ListExtra(t *testing.T) {
+	const zoneDNS = "foo.sandbox.measurement-lab.org."
+	tests := []struct {
+		name    string
+		results map[string]result
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			results: map[string]result{
+				"list-fake-zone": {list: []*dns.ResourceRecordSet{
+					{Name: zoneDNS, Type: "NS"},
+					{Name: zoneDNS, Type: "SOA"},
+					{Name: "ndt-lga12345-c0a80001." + zoneDNS, Type: "A"},
+					{Name: "www." + zoneDNS, Type: "TXT"},
+					{Name: "*.lab." + zoneDNS, Type: "A"},
+					{Name: "www." + zoneDNS, Type: "A"},
+				}},
+			},
+			want: []string{"*.lab." + zoneDNS + " A", "www." + zoneDNS + " A", "www." + zoneDNS + " TXT"},
+		},
+		{
+			name: "error-list",
+			results: map[string]result{
+				"list-fake-zone": {err: fmt.Errorf("fake list error")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", "fake-zone")
+			got, err := d.ListExtra(context.Background(), zoneDNS)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Manager.ListExtra() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			names := []string{}
+			for _, rr := range got {
+				names = append(names, rr.Name+" "+rr.Type)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Manager.ListExtra() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_SetExtra(t *testing.T) {
+	const name = "www.foo.sandbox.measurement-lab.org."
+	a := &dns.ResourceRecordSet{Name: name, Type: "A", Ttl: 300, Rrdatas: []string{"192.0.2.1"}}
+	cname := &dns.ResourceRecordSet{Name: name, Type: "CNAME", Ttl: 300, Rrdatas: []string{"example.com."}}
+	txt := &dns.ResourceRecordSet{Name: name, Type: "TXT", Ttl: 300, Rrdatas: []string{`"foo"`}}
+	tests := []struct {
+		name    string
+		rr      *dns.ResourceRecordSet
+		results map[string]result
+		wantErr error
+	}{
+		{
+			name: "success-create",
+			rr:   a,
+		},
+		{
+			name: "success-replace",
+			rr:   a,
+			results: map[string]result{
+				"list-fake-zone-" + name: {list: []*dns.ResourceRecordSet{a, txt}},
+			},
+		},
+		{
+			name: "error-conflict-cname",
+			rr:   cname,
+			results: map[string]result{
+				"list-fake-zone-" + name: {list: []*dns.ResourceRecordSet{txt}},
+			},
+			wantErr: ErrExtraConflict,
+		},
+		{
+			name: "error-conflict-existing-cname",
+			rr:   a,
+			results: map[string]result{
+				"list-fake-zone-" + name: {list: []*dns.ResourceRecordSet{cname}},
+			},
+			wantErr: ErrExtraConflict,
+		},
+		{
+			name: "error-list",
+			rr:   a,
+			results: map[string]result{
+				"list-fake-zone-" + name: {err: fmt.Errorf("fake list error")},
+			},
+			wantErr: errors.New("any"),
+		},
+		{
+			name: "error-change",
+			rr:   a,
+			results: map[string]result{
+				"chg-fake-zone": {err: fmt.Errorf("fake change error")},
+			},
+			wantErr: errors.New("any"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", "fake-zone")
+			err := d.SetExtra(context.Background(), tt.rr)
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Manager.SetExtra() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrExtraConflict && !errors.Is(err, ErrExtraConflict) {
+				t.Errorf("Manager.SetExtra() error = %v, want %v", err, ErrExtraConflict)
+			}
+		})
+	}
+}
+
+func TestManager_DeleteExtra(t *testing.T) {
+	const name = "www.foo.sandbox.measurement-lab.org."
+	get := "get-fake-zone-" + name + "-A"
+	a := &dns.ResourceRecordSet{Name: name, Type: "A", Ttl: 300, Rrdatas: []string{"192.0.2.1"}}
+	tests := []struct {
+		name    string
+		results map[string]result
+		wantErr error
+	}{
+		{
+			name: "success",
+			results: map[string]result{
+				get: {get: a},
+			},
+		},
+		{
+			name: "error-not-found",
+			results: map[string]result{
+				get: {err: &googleapi.Error{Code: 404}},
+			},
+			wantErr: ErrExtraNotFound,
+		},
+		{
+			name: "error-get",
+			results: map[string]result{
+				get: {err: fmt.Errorf("fake get error")},
+			},
+			wantErr: errors.New("any"),
+		},
+		{
+			name: "error-change",
+			results: map[string]result{
+				get:             {get: a},
+				"chg-fake-zone": {err: fmt.Errorf("fake change error")},
+			},
+			wantErr: errors.New("any"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", "fake-zone")
+			err := d.DeleteExtra(context.Background(), name, "A")
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Manager.DeleteExtra() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrExtraNotFound && !errors.Is(err, ErrExtraNotFound) {
+				t.Errorf("Manager.DeleteExtra() error = %v, want %v", err, ErrExtraNotFound)
+			}
+		})
+	}
+}
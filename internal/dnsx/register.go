@@ -4,8 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/api/dns/v1"
 	"google.golang.org/api/googleapi"
 )
@@ -13,17 +18,51 @@ import (
 var (
 	// ErrBadIPFormat is returned when registering a hostname with a malformed IP.
 	ErrBadIPFormat = errors.New("bad ip format")
+	// ErrInvalidTTL is returned for a TTL outside of MinTTL and MaxTTL.
+	ErrInvalidTTL = errors.New("invalid ttl")
 
 	recordTypeA    = "A"
 	recordTypeAAAA = "AAAA"
 	recordTypeNS   = "NS"
+	recordTypeTXT  = "TXT"
 )
 
+// Limits of the TTL in seconds of the records of a node. Short TTLs suit
+// mobile or ephemeral deployments whose addresses change often.
+const (
+	DefaultTTL = 300
+	MinTTL     = 30
+	MaxTTL     = 3600
+)
+
+// ValidateTTL returns ErrInvalidTTL if ttl is outside of MinTTL and MaxTTL.
+func ValidateTTL(ttl int64) error {
+	if ttl < MinTTL || ttl > MaxTTL {
+		return fmt.Errorf("%w: want %d to %d seconds, got %d", ErrInvalidTTL, MinTTL, MaxTTL, ttl)
+	}
+	return nil
+}
+
 // Manager contains state needed for managing DNS recors.
 type Manager struct {
 	Project string
 	Zone    string
 	Service dnsiface.Service
+	// Sites enables the round-robin site records, e.g.
+	// ndt-lga12345.foo.sandbox.measurement-lab.org, with the addresses of
+	// every node of the service at the site.
+	Sites bool
+	// TTL is the TTL in seconds of the A, AAAA and TXT records created by
+	// Register. Zero uses DefaultTTL.
+	TTL int64
+}
+
+// ttl returns the TTL of the records created by Register.
+func (d *Manager) ttl() int64 {
+	if d.TTL == 0 {
+		return DefaultTTL
+	}
+	return d.TTL
 }
 
 // NewManager creates a new Manager instance.
@@ -46,66 +85,118 @@ func appendDeletions(chg *dns.Change, rr *dns.ResourceRecordSet, hostname string
 	)
 }
 
-func appendAdditions(chg *dns.Change, hostname, ip, rtype string) {
+func appendAdditions(chg *dns.Change, hostname, ip, rtype string, ttl int64) {
 	chg.Additions = append(chg.Additions,
 		&dns.ResourceRecordSet{
 			Name:    hostname,
 			Type:    rtype,
-			Ttl:     300,
+			Ttl:     ttl,
 			Rrdatas: []string{ip},
 		},
 	)
 }
 
 // Register creates a new resource record for hostname with the given ipv4 and ipv6 adresses.
-func (d *Manager) Register(ctx context.Context, hostname, ipv4, ipv6 string) (*dns.Change, error) {
-	chg := &dns.Change{}
-	var err error
-	var rr *dns.ResourceRecordSet
+// A non-empty txt also replaces the TXT record of the hostname, e.g. with the
+// registration metadata of the node, and the CNAME aliases of the services
+// listed with the TXTAliases key.
+func (d *Manager) Register(ctx context.Context, hostname, ipv4, ipv6, txt string) (_ *dns.Change, err error) {
+	ctx, span := tracing.Start(ctx, "dnsx.Register", attribute.String("hostname", hostname), attribute.String("zone", d.Zone))
+	defer func() { tracing.End(span, err) }()
 
-	// IPv4 is required. An empty ipv4 value will generate an error.
-	rr, err = d.get(ctx, hostname, recordTypeA)
-	if isNotFound(err) {
-		appendAdditions(chg, hostname, ipv4, recordTypeA)
-	}
-	if rr != nil {
-		// Record matches given parameters, so we do not need to add or delete it.
-		matches := (len(rr.Rrdatas) == 1 && rr.Rrdatas[0] == ipv4)
-		if !matches {
-			// We found an existing resource record that doesn't match the given address.
-			// Remove the old one and add a new one.
-			appendDeletions(chg, rr, hostname)
-			appendAdditions(chg, hostname, ipv4, recordTypeA)
+	// The A and AAAA records are read with a single listing of the hostname.
+	rrs, err := d.Service.ResourceRecordSetsByName(ctx, d.Project, d.Zone, hostname)
+	if err != nil {
+		return nil, err
+	}
+	var rrA, rrAAAA, rrTXT *dns.ResourceRecordSet
+	for _, rr := range rrs {
+		switch rr.Type {
+		case recordTypeA:
+			rrA = rr
+		case recordTypeAAAA:
+			rrAAAA = rr
+		case recordTypeTXT:
+			rrTXT = rr
 		}
 	}
 
+	chg := &dns.Change{}
+	// IPv4 is required. An empty ipv4 value will generate an error.
+	appendChanges(chg, rrA, hostname, ipv4, recordTypeA, d.ttl())
 	// IPv6 remains optional for now.
 	if ipv6 != "" {
-		rr, err = d.get(ctx, hostname, recordTypeAAAA)
-		if isNotFound(err) {
-			appendAdditions(chg, hostname, ipv6, recordTypeAAAA)
-		}
-		if rr != nil {
-			matches := (len(rr.Rrdatas) == 1 && rr.Rrdatas[0] == ipv6)
-			if !matches {
-				appendDeletions(chg, rr, hostname)
-				appendAdditions(chg, hostname, ipv6, recordTypeAAAA)
-			}
-		}
+		appendChanges(chg, rrAAAA, hostname, ipv6, recordTypeAAAA, d.ttl())
+	}
+	if txt != "" {
+		appendChanges(chg, rrTXT, hostname, txt, recordTypeTXT, d.ttl())
+		// The TXT record lists the aliases of the other services of the node.
+		appendAliasChanges(chg, rrTXT, hostname, txt)
 	}
 
 	if chg.Additions == nil && chg.Deletions == nil {
 		// Without any actions, the ChangeCreate will fail.
+		d.registerSite(ctx, hostname, rrA, rrAAAA, ipv4, ipv6)
+		return nil, nil
+	}
+
+	result, err := d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	if err != nil {
 		return nil, err
 	}
+	d.registerSite(ctx, hostname, rrA, rrAAAA, ipv4, ipv6)
+	return result, nil
+}
+
+// registerSite replaces the previous addresses of hostname in its site record
+// with the given ones.
+func (d *Manager) registerSite(ctx context.Context, hostname string, rrA, rrAAAA *dns.ResourceRecordSet, ipv4, ipv6 string) {
+	if !d.Sites {
+		return
+	}
+	del, add := siteAddrs{}, siteAddrs{recordTypeA: {ipv4}}
+	del.add(rrA)
+	if ipv6 != "" {
+		del.add(rrAAAA)
+		add[recordTypeAAAA] = []string{ipv6}
+	}
+	d.updateSite(ctx, siteName(hostname), del, add)
+}
+
+// appendChanges adds the changes that make the rtype record of hostname match
+// data, e.g. an address, given the current record, if any.
+func appendChanges(chg *dns.Change, rr *dns.ResourceRecordSet, hostname, data, rtype string, ttl int64) {
+	if rr == nil {
+		appendAdditions(chg, hostname, data, rtype, ttl)
+		return
+	}
+	// Record matches given parameters, so we do not need to add or delete it.
+	matches := (len(rr.Rrdatas) == 1 && rr.Rrdatas[0] == data && rr.Ttl == ttl)
+	if !matches {
+		// We found an existing resource record that doesn't match the given data.
+		// Remove the old one and add a new one.
+		appendDeletions(chg, rr, hostname)
+		appendAdditions(chg, hostname, data, rtype, ttl)
+	}
+}
 
-	return d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+// TXTData returns the rrdata of a TXT record with one string for each of the
+// given key=value pairs, e.g. TXTData("org", "mlab") returns "\"org=mlab\"".
+func TXTData(kv ...string) string {
+	parts := []string{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, strconv.Quote(kv[i]+"="+kv[i+1]))
+	}
+	return strings.Join(parts, " ")
 }
 
 // Delete removes all resource records associated with the given hostname.
-func (d *Manager) Delete(ctx context.Context, hostname string) (*dns.Change, error) {
+func (d *Manager) Delete(ctx context.Context, hostname string) (_ *dns.Change, err error) {
+	ctx, span := tracing.Start(ctx, "dnsx.Delete", attribute.String("hostname", hostname), attribute.String("zone", d.Zone))
+	defer func() { tracing.End(span, err) }()
 	chg := &dns.Change{}
-	for _, rtype := range []string{recordTypeA, recordTypeAAAA} {
+	del := siteAddrs{}
+	for _, rtype := range []string{recordTypeA, recordTypeAAAA, recordTypeTXT} {
 		rr, err := d.get(ctx, hostname, rtype)
 		if err != nil && !isNotFound(err) {
 			// A different error occured. The host record may or may not exist.
@@ -114,9 +205,87 @@ func (d *Manager) Delete(ctx context.Context, hostname string) (*dns.Change, err
 		if rr != nil {
 			// Remove the record we found.
 			appendDeletions(chg, rr, hostname)
+			del.add(rr)
+			if rr.Type == recordTypeTXT {
+				appendAliasDeletions(chg, rr, hostname)
+			}
 		}
 	}
-	return d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	result, err := d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	if err != nil {
+		return nil, err
+	}
+	if d.Sites {
+		d.updateSite(ctx, siteName(hostname), del, nil)
+	}
+	return result, nil
+}
+
+// DeleteAll removes all resource records associated with the given hostnames
+// using a single change. The returned map contains an entry for every
+// hostname, with a nil error if its records were removed or did not exist.
+func (d *Manager) DeleteAll(ctx context.Context, hostnames []string) map[string]error {
+	ctx, span := tracing.Start(ctx, "dnsx.DeleteAll", attribute.Int("hostnames", len(hostnames)), attribute.String("zone", d.Zone))
+	defer span.End()
+	results := map[string]error{}
+	chg := &dns.Change{}
+	pending := []string{}
+	for _, hostname := range hostnames {
+		results[hostname] = nil
+		before := len(chg.Deletions)
+		for _, rtype := range []string{recordTypeA, recordTypeAAAA, recordTypeTXT} {
+			rr, err := d.get(ctx, hostname, rtype)
+			if err != nil && !isNotFound(err) {
+				// Leave this host unchanged, but continue with the others.
+				results[hostname] = err
+				chg.Deletions = chg.Deletions[:before]
+				break
+			}
+			if rr != nil {
+				appendDeletions(chg, rr, hostname)
+				if rr.Type == recordTypeTXT {
+					appendAliasDeletions(chg, rr, hostname)
+				}
+			}
+		}
+		if results[hostname] == nil && len(chg.Deletions) > before {
+			pending = append(pending, hostname)
+		}
+	}
+	if len(chg.Deletions) == 0 {
+		// Without any actions, the ChangeCreate will fail.
+		return results
+	}
+	_, err := d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	if err != nil {
+		for _, hostname := range pending {
+			results[hostname] = err
+		}
+		return results
+	}
+	if d.Sites {
+		d.deleteSites(ctx, chg, pending)
+	}
+	return results
+}
+
+// deleteSites removes the addresses of the deleted hostnames from their site
+// records, with one update for each site.
+func (d *Manager) deleteSites(ctx context.Context, chg *dns.Change, hostnames []string) {
+	sites := map[string]siteAddrs{}
+	for _, hostname := range hostnames {
+		if site := siteName(hostname); site != "" && sites[site] == nil {
+			sites[site] = siteAddrs{}
+		}
+	}
+	for _, rr := range chg.Deletions {
+		if del, ok := sites[siteName(rr.Name)]; ok {
+			del.add(rr)
+		}
+	}
+	for site, del := range sites {
+		d.updateSite(ctx, site, del, nil)
+	}
 }
 
 // RegisterZone guarantees that the provided zone already exists or is created,
@@ -174,6 +343,94 @@ func (d *Manager) RegisterZoneSplit(ctx context.Context, zone *dns.ManagedZone)
 	return result.Additions[0], nil
 }
 
+// GetZone returns the named zone.
+func (d *Manager) GetZone(ctx context.Context, zoneName string) (*dns.ManagedZone, error) {
+	return d.Service.GetManagedZone(ctx, d.Project, zoneName)
+}
+
+// ListZones returns the autojoin managed zones of the project, i.e. the
+// project zone and the organization zones, sorted by name.
+func (d *Manager) ListZones(ctx context.Context) ([]*dns.ManagedZone, error) {
+	zones, err := d.Service.ManagedZonesList(ctx, d.Project)
+	if err != nil {
+		return nil, err
+	}
+	result := []*dns.ManagedZone{}
+	for _, z := range zones {
+		if strings.HasPrefix(z.Name, "autojoin-") {
+			result = append(result, z)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// CheckZone returns an error if the named zone does not exist or does not
+// serve dnsName, e.g. when the zone was created for a different domain.
+func (d *Manager) CheckZone(ctx context.Context, zoneName, dnsName string) error {
+	zone, err := d.GetZone(ctx, zoneName)
+	if err != nil {
+		return fmt.Errorf("failed to get zone %s: %w", zoneName, err)
+	}
+	if zone.DnsName != dnsName {
+		return fmt.Errorf("zone %s serves %s, want %s", zoneName, zone.DnsName, dnsName)
+	}
+	return nil
+}
+
+// GetZoneSplit returns the NS record of the given zone in the parent zone,
+// e.g. as created by RegisterZoneSplit.
+func (d *Manager) GetZoneSplit(ctx context.Context, zone *dns.ManagedZone) (*dns.ResourceRecordSet, error) {
+	return d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, zone.DnsName, recordTypeNS)
+}
+
+// CountRecords returns the number of hostnames with an A record in the named
+// zone, e.g. the nodes registered in an organization zone.
+func (d *Manager) CountRecords(ctx context.Context, zoneName string) (int, error) {
+	rrs, err := d.Service.ResourceRecordSetsList(ctx, d.Project, zoneName)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rr := range rrs {
+		// Site records aggregate the nodes counted here, and extra records
+		// are not nodes.
+		if rr.Type == recordTypeA && !isSiteRecord(rr.Name) && !isExtraRecord(rr.Name) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteZone removes the zone split of the given zone from the parent zone
+// and deletes the zone. Zones or splits that do not exist are ignored.
+func (d *Manager) DeleteZone(ctx context.Context, zone *dns.ManagedZone) error {
+	chg := &dns.Change{}
+	// The DS record published by RegisterDS is removed with the zone split.
+	for _, rtype := range []string{recordTypeNS, recordTypeDS} {
+		rr, err := d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, zone.DnsName, rtype)
+		switch {
+		case isNotFound(err):
+		case err != nil:
+			return err
+		case rr != nil:
+			appendDeletions(chg, rr, zone.DnsName)
+		}
+	}
+	if chg.Deletions != nil {
+		if _, err := d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg); err != nil {
+			return err
+		}
+	}
+	err := d.Service.DeleteManagedZone(ctx, d.Project, zone.Name)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
 // get retrieves a resource record for the given hostname and rtype.
 func (d *Manager) get(ctx context.Context, hostname, rtype string) (*dns.ResourceRecordSet, error) {
 	return d.Service.ResourceRecordSetsGet(ctx, d.Project, d.Zone, hostname, rtype)
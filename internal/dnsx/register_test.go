@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -16,10 +17,13 @@ import (
 )
 
 type result struct {
-	get  *dns.ResourceRecordSet
-	chg  *dns.Change
-	zone *dns.ManagedZone
-	err  error
+	get   *dns.ResourceRecordSet
+	chg   *dns.Change
+	zone  *dns.ManagedZone
+	list  []*dns.ResourceRecordSet
+	err   error
+	keys  []*dns.DnsKey
+	zones []*dns.ManagedZone
 }
 type fakeDNS2 struct {
 	results map[string]result
@@ -42,20 +46,47 @@ func (f *fakeDNS2) CreateManagedZone(ctx context.Context, project string, zone *
 	return r.zone, r.err
 }
 
+func (f *fakeDNS2) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	return f.results["delzone-"+zoneName].err
+}
+func (f *fakeDNS2) ResourceRecordSetsList(ctx context.Context, project string, zone string) ([]*dns.ResourceRecordSet, error) {
+	r := f.results["list-"+zone]
+	return r.list, r.err
+}
+func (f *fakeDNS2) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	r := f.results["list-"+zone+"-"+name]
+	return r.list, r.err
+}
+func (f *fakeDNS2) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	r := f.results["getchg-"+zone+"-"+id]
+	return r.chg, r.err
+}
+
+func (f *fakeDNS2) ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error) {
+	r := f.results["listzones"]
+	return r.zones, r.err
+}
+
+func (f *fakeDNS2) DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error) {
+	r := f.results["keys-"+zone]
+	return r.keys, r.err
+}
+
 type fakeDNS struct {
 	record []*dns.ResourceRecordSet
-	i      int
 	getErr error
 	chgErr error
+	lists  int
 }
 
+// ResourceRecordSetsGet returns the record of the given type, if any.
 func (f *fakeDNS) ResourceRecordSetsGet(ctx context.Context, project string, zone string, name string, rtype string) (*dns.ResourceRecordSet, error) {
-	var x *dns.ResourceRecordSet
-	if f.i < len(f.record) {
-		x = f.record[f.i]
-		f.i++
+	for _, r := range f.record {
+		if r.Type == rtype {
+			return r, f.getErr
+		}
 	}
-	return x, f.getErr
+	return nil, f.getErr
 }
 
 func (f *fakeDNS) ChangeCreate(ctx context.Context, project string, zone string, change *dns.Change) (*dns.Change, error) {
@@ -76,6 +107,31 @@ func (f *fakeDNS) GetManagedZone(ctx context.Context, project, zoneName string)
 	return nil, nil
 }
 
+func (f *fakeDNS) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	return nil
+}
+
+func (f *fakeDNS) ResourceRecordSetsList(ctx context.Context, project string, zone string) ([]*dns.ResourceRecordSet, error) {
+	return f.record, f.getErr
+}
+
+func (f *fakeDNS) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	f.lists++
+	return f.record, f.getErr
+}
+
+func (f *fakeDNS) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	return nil, f.getErr
+}
+
+func (f *fakeDNS) ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error) {
+	return nil, f.getErr
+}
+
+func (f *fakeDNS) DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error) {
+	return nil, f.getErr
+}
+
 func TestManager_Register(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -84,13 +140,15 @@ func TestManager_Register(t *testing.T) {
 		hostname string
 		ipv4     string
 		ipv6     string
+		txt      string
+		ttl      int64
 		want     *dns.Change
 		wantErr  bool
 	}{
 		{
 			name:     "success",
 			zone:     "sandbox-measurement-lab-org",
-			service:  &fakeDNS{getErr: &googleapi.Error{Code: 404}},
+			service:  &fakeDNS{},
 			hostname: "foo.sandbox.measurement-lab.org",
 			ipv4:     "192.168.0.1",
 			ipv6:     "",
@@ -183,10 +241,133 @@ func TestManager_Register(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "success-txt-replace",
+			zone: "sandbox-measurement-lab-org",
+			service: &fakeDNS{record: []*dns.ResourceRecordSet{
+				{
+					Name:    "foo.sandbox.measurement-lab.org",
+					Type:    "A",
+					Ttl:     300,
+					Rrdatas: []string{"192.168.0.1"}, // will be kept.
+				},
+				{
+					Name:    "foo.sandbox.measurement-lab.org",
+					Type:    "TXT",
+					Ttl:     300,
+					Rrdatas: []string{`"org=mlab" "type=virtual"`}, // will be removed.
+				},
+			}},
+			hostname: "foo.sandbox.measurement-lab.org",
+			ipv4:     "192.168.0.1",
+			txt:      `"org=mlab" "type=physical"`,
+			want: &dns.Change{
+				Additions: []*dns.ResourceRecordSet{
+					{
+						Name:    "foo.sandbox.measurement-lab.org",
+						Type:    "TXT",
+						Ttl:     300,
+						Rrdatas: []string{`"org=mlab" "type=physical"`},
+					},
+				},
+				Deletions: []*dns.ResourceRecordSet{
+					{
+						Name:    "foo.sandbox.measurement-lab.org",
+						Type:    "TXT",
+						Ttl:     300,
+						Rrdatas: []string{`"org=mlab" "type=virtual"`},
+					},
+				},
+			},
+		},
+		{
+			name: "success-aliases-replace",
+			zone: "autojoin-foo-sandbox-measurement-lab-org",
+			service: &fakeDNS{record: []*dns.ResourceRecordSet{
+				{
+					Name:    "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+					Type:    "A",
+					Ttl:     300,
+					Rrdatas: []string{"192.168.0.1"},
+				},
+				{
+					Name:    "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+					Type:    "TXT",
+					Ttl:     300,
+					Rrdatas: []string{`"org=foo" "aliases=msak,wehe"`},
+				},
+			}},
+			hostname: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+			ipv4:     "192.168.0.1",
+			txt:      `"org=foo" "aliases=msak,revtr"`,
+			want: &dns.Change{
+				Additions: []*dns.ResourceRecordSet{
+					{
+						Name:    "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+						Type:    "TXT",
+						Ttl:     300,
+						Rrdatas: []string{`"org=foo" "aliases=msak,revtr"`},
+					},
+					{
+						Name:    "revtr-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+						Type:    "CNAME",
+						Ttl:     300,
+						Rrdatas: []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org."},
+					},
+				},
+				Deletions: []*dns.ResourceRecordSet{
+					{
+						Name:    "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+						Type:    "TXT",
+						Ttl:     300,
+						Rrdatas: []string{`"org=foo" "aliases=msak,wehe"`},
+					},
+					{
+						Name:    "wehe-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+						Type:    "CNAME",
+						Ttl:     300,
+						Rrdatas: []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org."},
+					},
+				},
+			},
+		},
+		{
+			name: "success-ttl-replace",
+			zone: "sandbox-measurement-lab-org",
+			service: &fakeDNS{record: []*dns.ResourceRecordSet{
+				{
+					Name:    "foo.sandbox.measurement-lab.org",
+					Type:    "A",
+					Ttl:     300,
+					Rrdatas: []string{"192.168.0.1"}, // will be replaced.
+				},
+			}},
+			hostname: "foo.sandbox.measurement-lab.org",
+			ipv4:     "192.168.0.1",
+			ttl:      60,
+			want: &dns.Change{
+				Additions: []*dns.ResourceRecordSet{
+					{
+						Name:    "foo.sandbox.measurement-lab.org",
+						Type:    "A",
+						Ttl:     60,
+						Rrdatas: []string{"192.168.0.1"},
+					},
+				},
+				Deletions: []*dns.ResourceRecordSet{
+					{
+						Name:    "foo.sandbox.measurement-lab.org",
+						Type:    "A",
+						Ttl:     300,
+						Rrdatas: []string{"192.168.0.1"},
+					},
+				},
+			},
+		},
 		{
 			name:     "error-change",
 			zone:     "sandbox-measurement-lab-org",
-			service:  &fakeDNS{getErr: &googleapi.Error{Code: 404}, chgErr: errors.New("err")},
+			service:  &fakeDNS{chgErr: errors.New("err")},
 			hostname: "foo.sandbox.measurement-lab.org",
 			ipv4:     "192.168.0.1",
 			ipv6:     "fe80::1002:161f:ae39:a2c9",
@@ -205,7 +386,8 @@ func TestManager_Register(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			d := NewManager(tt.service, "mlab-sandbox", tt.zone)
-			got, err := d.Register(context.Background(), tt.hostname, tt.ipv4, tt.ipv6)
+			d.TTL = tt.ttl
+			got, err := d.Register(context.Background(), tt.hostname, tt.ipv4, tt.ipv6, tt.txt)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Manager.Register() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -213,6 +395,9 @@ func TestManager_Register(t *testing.T) {
 			if diff := deep.Equal(got, tt.want); diff != nil {
 				t.Errorf("Manager.Register() change returned != change expected: %s", strings.Join(diff, "\n"))
 			}
+			if f, ok := tt.service.(*fakeDNS); ok && f.lists != 1 {
+				t.Errorf("Manager.Register() listed records %d times, want 1", f.lists)
+			}
 		})
 	}
 }
@@ -261,6 +446,35 @@ func TestManager_Delete(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "success-aliases",
+			zone: "autojoin-foo-sandbox-measurement-lab-org",
+			service: &fakeDNS{record: []*dns.ResourceRecordSet{
+				{
+					Name:    "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+					Type:    "TXT",
+					Ttl:     300,
+					Rrdatas: []string{`"org=foo" "aliases=msak"`},
+				},
+			}},
+			hostname: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+			want: &dns.Change{
+				Deletions: []*dns.ResourceRecordSet{
+					{
+						Name:    "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+						Type:    "TXT",
+						Ttl:     300,
+						Rrdatas: []string{`"org=foo" "aliases=msak"`},
+					},
+					{
+						Name:    "msak-lga12345-c0a80001.foo.sandbox.measurement-lab.org.",
+						Type:    "CNAME",
+						Ttl:     300,
+						Rrdatas: []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org."},
+					},
+				},
+			},
+		},
 		{
 			name:     "error-change",
 			zone:     "sandbox-measurement-lab-org",
@@ -292,6 +506,66 @@ func TestManager_Delete(t *testing.T) {
 	}
 }
 
+func TestManager_DeleteAll(t *testing.T) {
+	const zone = "autojoin-foo-sandbox-measurement-lab-org"
+	a := &dns.ResourceRecordSet{Type: "A", Ttl: 300, Rrdatas: []string{"192.168.0.1"}}
+	notFound := &googleapi.Error{Code: 404}
+	tests := []struct {
+		name    string
+		results map[string]result
+		want    map[string]bool
+	}{
+		{
+			name: "success",
+			results: map[string]result{
+				"get-" + zone + "-a.foo.-A":    {get: a},
+				"get-" + zone + "-a.foo.-AAAA": {err: notFound},
+				"get-" + zone + "-b.foo.-A":    {err: notFound},
+				"get-" + zone + "-b.foo.-AAAA": {err: notFound},
+				"chg-" + zone:                  {chg: &dns.Change{}},
+			},
+			want: map[string]bool{"a.foo.": true, "b.foo.": true},
+		},
+		{
+			name: "success-partial-get-error",
+			results: map[string]result{
+				"get-" + zone + "-a.foo.-A":    {get: a},
+				"get-" + zone + "-a.foo.-AAAA": {err: notFound},
+				"get-" + zone + "-b.foo.-A":    {get: a},
+				"get-" + zone + "-b.foo.-AAAA": {err: errors.New("fake get error")},
+				"chg-" + zone:                  {chg: &dns.Change{}},
+			},
+			want: map[string]bool{"a.foo.": true, "b.foo.": false},
+		},
+		{
+			name: "error-change",
+			results: map[string]result{
+				"get-" + zone + "-a.foo.-A":    {get: a},
+				"get-" + zone + "-a.foo.-AAAA": {err: notFound},
+				"get-" + zone + "-b.foo.-A":    {err: notFound},
+				"get-" + zone + "-b.foo.-AAAA": {err: notFound},
+				"chg-" + zone:                  {err: errors.New("fake change error")},
+			},
+			// Hosts without records are unaffected by the failed change.
+			want: map[string]bool{"a.foo.": false, "b.foo.": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", zone)
+			got := d.DeleteAll(context.Background(), []string{"a.foo.", "b.foo."})
+			if len(got) != len(tt.want) {
+				t.Fatalf("Manager.DeleteAll() returned %d results, want %d", len(got), len(tt.want))
+			}
+			for h, ok := range tt.want {
+				if (got[h] == nil) != ok {
+					t.Errorf("Manager.DeleteAll() result for %s = %v, want success %t", h, got[h], ok)
+				}
+			}
+		})
+	}
+}
+
 func TestManager_RegisterZone(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -350,7 +624,7 @@ func TestManager_RegisterZone(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := NewManager(tt.service, tt.project, dnsname.ProjectZone(tt.project))
+			d := NewManager(tt.service, tt.project, dnsname.ProjectZone(tt.project, dnsname.DefaultDomain))
 			got, err := d.RegisterZone(context.Background(), tt.zone)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Manager.RegisterZone() error = %v, wantErr %v", err, tt.wantErr)
@@ -486,7 +760,7 @@ func TestManager_RegisterZoneSplit(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := NewManager(tt.service, tt.project, dnsname.ProjectZone(tt.project))
+			d := NewManager(tt.service, tt.project, dnsname.ProjectZone(tt.project, dnsname.DefaultDomain))
 			got, err := d.RegisterZoneSplit(context.Background(), tt.zone)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Manager.RegisterZoneSplit() error = %v, wantErr %v", err, tt.wantErr)
@@ -498,3 +772,271 @@ func TestManager_RegisterZoneSplit(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_CheckZone(t *testing.T) {
+	zone := dnsname.ProjectZone("mlab-sandbox", dnsname.DefaultDomain)
+	tests := []struct {
+		name    string
+		results map[string]result
+		wantErr bool
+	}{
+		{
+			name: "success",
+			results: map[string]result{
+				"getzone-" + zone: {zone: &dns.ManagedZone{Name: zone, DnsName: "sandbox.measurement-lab.org."}},
+			},
+		},
+		{
+			name: "error-other-domain",
+			results: map[string]result{
+				"getzone-" + zone: {zone: &dns.ManagedZone{Name: zone, DnsName: "sandbox.example.org."}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-get",
+			results: map[string]result{
+				"getzone-" + zone: {err: &googleapi.Error{Code: 404}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", zone)
+			err := d.CheckZone(context.Background(), zone, dnsname.ProjectDNS("mlab-sandbox", dnsname.DefaultDomain))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Manager.CheckZone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_ListZones(t *testing.T) {
+	zone := dnsname.ProjectZone("mlab-sandbox", dnsname.DefaultDomain)
+	tests := []struct {
+		name    string
+		results map[string]result
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			results: map[string]result{
+				"listzones": {zones: []*dns.ManagedZone{
+					{Name: "autojoin-foo-sandbox-measurement-lab-org"},
+					{Name: "other-zone"},
+					{Name: zone},
+				}},
+			},
+			want: []string{"autojoin-foo-sandbox-measurement-lab-org", zone},
+		},
+		{
+			name: "error-list",
+			results: map[string]result{
+				"listzones": {err: fmt.Errorf("fake list error")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(&fakeDNS2{results: tt.results}, "mlab-sandbox", zone)
+			got, err := d.ListZones(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Manager.ListZones() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			names := []string{}
+			for _, z := range got {
+				names = append(names, z.Name)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Manager.ListZones() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_CountRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		service dnsiface.Service
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "success",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"list-fake-zone": {list: []*dns.ResourceRecordSet{
+						{Name: "fake.zone.", Type: "NS"},
+						{Name: "fake.zone.", Type: "SOA"},
+						{Name: "ndt-lga12345-c0a80001.fake.zone.", Type: "A"},
+						{Name: "ndt-lga12345-c0a80001.fake.zone.", Type: "AAAA"},
+						{Name: "ndt-lga12345-c0a80002.fake.zone.", Type: "A"},
+						{Name: "ndt-lga12345.fake.zone.", Type: "A"},
+						{Name: "www.fake.zone.", Type: "A"},
+						{Name: "*.lab.fake.zone.", Type: "TXT"},
+					}},
+				},
+			},
+			want: 2,
+		},
+		{
+			name: "error-list",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"list-fake-zone": {err: fmt.Errorf("fake list error")},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(tt.service, "mlab-sandbox", dnsname.ProjectZone("mlab-sandbox", dnsname.DefaultDomain))
+			got, err := d.CountRecords(context.Background(), "fake-zone")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Manager.CountRecords() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Manager.CountRecords() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_DeleteZone(t *testing.T) {
+	fakeRR := &dns.ResourceRecordSet{
+		Name:    "fake.zone.",
+		Type:    "NS",
+		Rrdatas: []string{"ns1.fake."},
+	}
+	zone := &dns.ManagedZone{
+		Name:    "fake-zone",
+		DnsName: "fake.zone.",
+	}
+	tests := []struct {
+		name    string
+		service dnsiface.Service
+		wantErr bool
+	}{
+		{
+			name: "success",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"get-autojoin-sandbox-measurement-lab-org-fake.zone.-NS": {get: fakeRR},
+					"chg-autojoin-sandbox-measurement-lab-org":               {chg: &dns.Change{}},
+				},
+			},
+		},
+		{
+			name: "success-not-found",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"get-autojoin-sandbox-measurement-lab-org-fake.zone.-NS": {err: &googleapi.Error{Code: 404}},
+					"delzone-fake-zone": {err: &googleapi.Error{Code: 404}},
+				},
+			},
+		},
+		{
+			name: "error-get-split",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"get-autojoin-sandbox-measurement-lab-org-fake.zone.-NS": {err: fmt.Errorf("fake get error")},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-delete-split",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"get-autojoin-sandbox-measurement-lab-org-fake.zone.-NS": {get: fakeRR},
+					"chg-autojoin-sandbox-measurement-lab-org":               {err: fmt.Errorf("fake change error")},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error-delete-zone",
+			service: &fakeDNS2{
+				results: map[string]result{
+					"get-autojoin-sandbox-measurement-lab-org-fake.zone.-NS": {err: &googleapi.Error{Code: 404}},
+					"delzone-fake-zone": {err: &googleapi.Error{Code: 400}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewManager(tt.service, "mlab-sandbox", dnsname.ProjectZone("mlab-sandbox", dnsname.DefaultDomain))
+			if err := d.DeleteZone(context.Background(), zone); (err != nil) != tt.wantErr {
+				t.Errorf("Manager.DeleteZone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTTL(t *testing.T) {
+	tests := []struct {
+		ttl     int64
+		wantErr bool
+	}{
+		{ttl: MinTTL},
+		{ttl: DefaultTTL},
+		{ttl: MaxTTL},
+		{ttl: 0, wantErr: true},
+		{ttl: MinTTL - 1, wantErr: true},
+		{ttl: MaxTTL + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.FormatInt(tt.ttl, 10), func(t *testing.T) {
+			err := ValidateTTL(tt.ttl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidTTL) {
+				t.Errorf("ValidateTTL() error = %v, want %v", err, ErrInvalidTTL)
+			}
+		})
+	}
+}
+
+func TestTXTData(t *testing.T) {
+	got := TXTData("org", "mlab", "type", "physical")
+	if want := `"org=mlab" "type=physical"`; got != want {
+		t.Errorf("TXTData() = %s, want %s", got, want)
+	}
+}
+
+func TestIsSiteRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "ndt-lga12345.foo.sandbox.measurement-lab.org.", want: true},
+		{name: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org.", want: false},
+		{name: "lga12345-c0a80001.foo.sandbox.measurement-lab.org.", want: false},
+		{name: "lga12345-abc12345.foo.sandbox.measurement-lab.org.", want: false},
+		{name: "foo.sandbox.measurement-lab.org.", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSiteRecord(tt.name); got != tt.want {
+				t.Errorf("isSiteRecord() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxtValue(t *testing.T) {
+	txt := TXTData("org", "foo", TXTAliases, "msak,wehe")
+	if got := txtValue(txt, TXTAliases); got != "msak,wehe" {
+		t.Errorf("txtValue(aliases) = %q, want %q", got, "msak,wehe")
+	}
+	if got := txtValue(txt, "missing"); got != "" {
+		t.Errorf("txtValue(missing) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,140 @@
+package dnsx
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/go/host"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// siteRetries is the number of attempts to update a site record when a
+// concurrent update of the same site changed it first.
+const siteRetries = 3
+
+// siteAddrs contains addresses keyed by record type, e.g. "A" or "AAAA".
+type siteAddrs map[string][]string
+
+func (a siteAddrs) add(rr *dns.ResourceRecordSet) {
+	if rr != nil && (rr.Type == recordTypeA || rr.Type == recordTypeAAAA) {
+		a[rr.Type] = append(a[rr.Type], rr.Rrdatas...)
+	}
+}
+
+// siteName returns the name of the site record of the given node hostname,
+// or "" if hostname is not a v3 node name.
+func siteName(hostname string) string {
+	name, err := host.Parse(strings.TrimSuffix(hostname, "."))
+	if err != nil || name.Version != "v3" || name.Service == "" {
+		return ""
+	}
+	return dnsname.SiteDNS(name)
+}
+
+// isSiteRecord reports whether name is the name of a site record, i.e. the
+// first label is <service>-<site> rather than a node name.
+func isSiteRecord(name string) bool {
+	parts := strings.Split(strings.SplitN(name, ".", 2)[0], "-")
+	return len(parts) == 2 && !isSite(parts[0]) && isSite(parts[1])
+}
+
+// isSite reports whether s has the form of a v3 site, e.g. "lga12345".
+func isSite(s string) bool {
+	if len(s) < 4 || len(s) > 13 {
+		return false
+	}
+	for i, c := range s {
+		if (i < 3 && !unicode.IsLetter(c)) || (i >= 3 && !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// updateSite removes the del addresses from and adds the add addresses to
+// the round-robin records of the named site. Site errors do not fail the
+// registration of the node, so they are logged.
+func (d *Manager) updateSite(ctx context.Context, site string, del, add siteAddrs) {
+	if site == "" {
+		return
+	}
+	var err error
+	for i := 0; i < siteRetries; i++ {
+		err = d.tryUpdateSite(ctx, site, del, add)
+		if !isConflict(err) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("failed to update site record %s: %v", site, err)
+	}
+}
+
+func (d *Manager) tryUpdateSite(ctx context.Context, site string, del, add siteAddrs) error {
+	rrs, err := d.Service.ResourceRecordSetsByName(ctx, d.Project, d.Zone, site)
+	if err != nil {
+		return err
+	}
+	chg := &dns.Change{}
+	for _, rtype := range []string{recordTypeA, recordTypeAAAA} {
+		var rr *dns.ResourceRecordSet
+		addrs := map[string]bool{}
+		for _, r := range rrs {
+			if r.Type == rtype {
+				rr = r
+				for _, a := range r.Rrdatas {
+					addrs[a] = true
+				}
+			}
+		}
+		for _, a := range del[rtype] {
+			delete(addrs, a)
+		}
+		for _, a := range add[rtype] {
+			addrs[a] = true
+		}
+		rrdatas := []string{}
+		for a := range addrs {
+			rrdatas = append(rrdatas, a)
+		}
+		sort.Strings(rrdatas)
+		if rr != nil {
+			curr := append([]string{}, rr.Rrdatas...)
+			sort.Strings(curr)
+			if strings.Join(curr, ",") == strings.Join(rrdatas, ",") {
+				// The site record already has these addresses.
+				continue
+			}
+			appendDeletions(chg, rr, site)
+		}
+		if len(rrdatas) > 0 {
+			chg.Additions = append(chg.Additions, &dns.ResourceRecordSet{
+				Name:    site,
+				Type:    rtype,
+				Ttl:     DefaultTTL,
+				Rrdatas: rrdatas,
+			})
+		}
+	}
+	if chg.Additions == nil && chg.Deletions == nil {
+		return nil
+	}
+	_, err = d.Service.ChangeCreate(ctx, d.Project, d.Zone, chg)
+	return err
+}
+
+// isConflict checks whether this is a googleapi.Error for a change that
+// conflicts with the current records, e.g. after a concurrent update.
+func isConflict(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == 409 || gerr.Code == 412
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Package events distributes node lifecycle events, e.g. registrations and
+// expirations, to subscribers of all instances of the Autojoin API.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/host"
+)
+
+// Channel is the Redis Pub/Sub channel of node events. Pub/Sub channels are
+// not scoped to a Redis database.
+const Channel = "autojoin:node-events"
+
+// Broker delivers published events to the subscribers of this instance.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[chan v0.NodeEvent]bool
+}
+
+// NewBroker creates a new Broker without subscribers.
+func NewBroker() *Broker {
+	return &Broker{subs: map[chan v0.NodeEvent]bool{}}
+}
+
+// Subscribe returns a channel that receives all events published after the
+// call. A subscriber that falls more than buffer events behind is
+// unsubscribed and its channel closed, so that slow subscribers never block
+// publishers; they should subscribe again and resynchronize. Callers must call
+// cancel when done.
+func (b *Broker) Subscribe(buffer int) (<-chan v0.NodeEvent, func()) {
+	c := make(chan v0.NodeEvent, buffer)
+	b.mu.Lock()
+	b.subs[c] = true
+	b.mu.Unlock()
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subs[c] {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
+
+// Publish delivers the event to every subscriber.
+func (b *Broker) Publish(ctx context.Context, e v0.NodeEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.subs {
+		select {
+		case c <- e:
+		default:
+			log.Printf("Dropping slow node event subscriber")
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+	return nil
+}
+
+// pingInterval is the interval between pings of the Redis subscription.
+const pingInterval = time.Minute
+
+// Redis publishes events to a Redis Pub/Sub channel, and delivers the events
+// published by all instances to the subscribers of its Broker.
+type Redis struct {
+	*Broker
+	// Channel is the Pub/Sub channel of the events, the package Channel by
+	// default. Instances serving several projects use a channel per project.
+	Channel string
+	pool    *redis.Pool
+}
+
+// NewRedis creates a new Redis that delivers events to the given Broker. Run
+// must be called to receive events.
+func NewRedis(pool *redis.Pool, b *Broker) *Redis {
+	return &Redis{Broker: b, Channel: Channel, pool: pool}
+}
+
+// Publish sends the event to all instances, including this one.
+func (r *Redis) Publish(ctx context.Context, e v0.NodeEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("PUBLISH", r.Channel, b)
+	return err
+}
+
+// Run delivers the events of the Redis channel to the Broker until the
+// subscription fails or ctx is canceled. The subscription fails if no reply,
+// including pongs, is received within two ping intervals.
+func (r *Redis) Run(ctx context.Context) error {
+	psc := redis.PubSubConn{Conn: r.pool.Get()}
+	defer psc.Close()
+	if err := psc.Subscribe(r.Channel); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Pings detect broken connections while no events are published.
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				// Receive blocks, so unsubscribe to return on cancellation.
+				psc.Unsubscribe()
+				return
+			case <-ticker.C:
+				psc.Ping("")
+			}
+		}
+	}()
+	for {
+		// The read timeout of the pool would end idle subscriptions.
+		switch m := psc.ReceiveWithTimeout(2 * pingInterval).(type) {
+		case redis.Message:
+			e := v0.NodeEvent{}
+			if err := json.Unmarshal(m.Data, &e); err != nil {
+				log.Printf("Failed to parse node event: %v", err)
+				continue
+			}
+			r.Broker.Publish(ctx, e)
+		case redis.Subscription:
+			if m.Count == 0 {
+				return ctx.Err()
+			}
+		case error:
+			return m
+		}
+	}
+}
+
+// Publisher publishes node events.
+type Publisher interface {
+	Publish(ctx context.Context, e v0.NodeEvent) error
+}
+
+// Reporter publishes the hostnames removed from the tracker, by the garbage
+// collector or the delete handlers, as delete or expire events.
+type Reporter struct {
+	pub Publisher
+}
+
+// NewReporter creates a new Reporter that publishes to p.
+func NewReporter(p Publisher) *Reporter {
+	return &Reporter{pub: p}
+}
+
+// Report implements tracker.Reporter.
+func (r *Reporter) Report(ctx context.Context, hostname string, s tracker.Status, reason string) error {
+	return r.pub.Publish(ctx, NewEvent(EventType(reason), hostname, reason))
+}
+
+// EventType returns the event type of a tracker removal reason.
+func EventType(reason string) string {
+	if reason == "expired" {
+		return v0.EventExpire
+	}
+	return v0.EventDelete
+}
+
+// NewEvent creates a new event of the given type for hostname that happened
+// now.
+func NewEvent(eventType, hostname, reason string) v0.NodeEvent {
+	e := v0.NodeEvent{
+		Type:     eventType,
+		Hostname: hostname,
+		Reason:   reason,
+		Time:     time.Now().UTC(),
+	}
+	if name, err := host.Parse(hostname); err == nil {
+		e.Org = name.Org
+	}
+	return e
+}
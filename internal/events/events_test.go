@@ -0,0 +1,153 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"github.com/m-lab/autojoin/internal/tracker"
+)
+
+const hostname = "ndt-lga3269-4f20bd89.foo.sandbox.measurement-lab.org"
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+	fast, cancelFast := b.Subscribe(2)
+	defer cancelFast()
+	slow, cancelSlow := b.Subscribe(1)
+
+	e := NewEvent(v0.EventRegister, hostname, "")
+	b.Publish(context.Background(), e)
+	b.Publish(context.Background(), e)
+
+	for i := 0; i < 2; i++ {
+		if got := <-fast; got.Hostname != hostname || got.Org != "foo" {
+			t.Errorf("Subscribe() received wrong event; got %+v", got)
+		}
+	}
+	// The slow subscriber fell behind on the second event and was closed.
+	if _, ok := <-slow; !ok {
+		t.Errorf("Subscribe() did not receive the first event")
+	}
+	if _, ok := <-slow; ok {
+		t.Errorf("Subscribe() did not close the slow subscriber")
+	}
+	// Canceling a closed subscriber is safe.
+	cancelSlow()
+	if len(b.subs) != 1 {
+		t.Errorf("Broker has wrong number of subscribers; got %d, want 1", len(b.subs))
+	}
+}
+
+func TestReporter_Report(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{reason: "expired", want: v0.EventExpire},
+		{reason: "deleted", want: v0.EventDelete},
+		{reason: "suspended", want: v0.EventDelete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			b := NewBroker()
+			c, cancel := b.Subscribe(1)
+			defer cancel()
+			err := NewReporter(b).Report(context.Background(), hostname, tracker.Status{}, tt.reason)
+			if err != nil {
+				t.Fatalf("Report() returned error: %v", err)
+			}
+			got := <-c
+			if got.Type != tt.want || got.Reason != tt.reason || got.Hostname != hostname || got.Time.IsZero() {
+				t.Errorf("Report() published wrong event; got %+v, want type %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeConn replies to Receive with the given replies in order, and records
+// the arguments of Do.
+type fakeConn struct {
+	replies []interface{}
+	done    []interface{}
+	err     error
+}
+
+func (c *fakeConn) Close() error                               { return nil }
+func (c *fakeConn) Err() error                                 { return nil }
+func (c *fakeConn) Send(cmd string, args ...interface{}) error { return nil }
+func (c *fakeConn) Flush() error                               { return nil }
+func (c *fakeConn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		return nil, errors.New("connection closed")
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	return r, nil
+}
+func (c *fakeConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+func (c *fakeConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		// The pool flushes connections with an empty command on close.
+		return nil, nil
+	}
+	c.done = append(c.done, args...)
+	return int64(1), c.err
+}
+
+func newPool(c *fakeConn) *redis.Pool {
+	return &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}
+}
+
+func TestRedis_Publish(t *testing.T) {
+	c := &fakeConn{}
+	r := NewRedis(newPool(c), NewBroker())
+	if err := r.Publish(context.Background(), NewEvent(v0.EventRenew, hostname, "")); err != nil {
+		t.Fatalf("Publish() returned error: %v", err)
+	}
+	if len(c.done) != 2 || c.done[0] != Channel {
+		t.Fatalf("Publish() sent wrong arguments; got %v", c.done)
+	}
+	e := v0.NodeEvent{}
+	if err := json.Unmarshal(c.done[1].([]byte), &e); err != nil || e.Type != v0.EventRenew {
+		t.Errorf("Publish() sent wrong event; got %s", c.done[1])
+	}
+
+	c.err = errors.New("fake error")
+	if err := r.Publish(context.Background(), e); err == nil {
+		t.Errorf("Publish() expected error")
+	}
+}
+
+func TestRedis_Run(t *testing.T) {
+	b, _ := json.Marshal(NewEvent(v0.EventExpire, hostname, "expired"))
+	c := &fakeConn{replies: []interface{}{
+		[]interface{}{[]byte("subscribe"), []byte(Channel), int64(1)},
+		[]interface{}{[]byte("message"), []byte(Channel), b},
+		[]interface{}{[]byte("message"), []byte(Channel), []byte("not json")},
+	}}
+	broker := NewBroker()
+	events, cancel := broker.Subscribe(2)
+	defer cancel()
+	r := NewRedis(newPool(c), broker)
+
+	// Run returns the error of the closed connection.
+	if err := r.Run(context.Background()); err == nil {
+		t.Errorf("Run() expected error")
+	}
+	if len(events) != 1 {
+		t.Fatalf("Run() delivered wrong number of events; got %d, want 1", len(events))
+	}
+	if e := <-events; e.Type != v0.EventExpire || e.Hostname != hostname {
+		t.Errorf("Run() delivered wrong event; got %+v", e)
+	}
+}
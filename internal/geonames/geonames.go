@@ -0,0 +1,188 @@
+// Package geonames finds the nearest city and administrative region of a
+// location in a GeoNames cities dataset, e.g. cities15000.txt from
+// https://download.geonames.org/export/dump/.
+package geonames
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/m-lab/autojoin/internal/kdtree"
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/mathx"
+)
+
+// DefaultMaxDistance is the MaxDistance of new Clients.
+const DefaultMaxDistance = 100
+
+// ErrNotFound is returned if Nearest can find no city.
+var ErrNotFound = errors.New("no city near location")
+
+// Place is a city of the GeoNames dataset.
+type Place struct {
+	City        string
+	CountryCode string
+	// Admin1Code is the GeoNames code of the first-level administrative
+	// region of the city, e.g. "NY", and Admin1 its name, if known.
+	Admin1Code string
+	Admin1     string
+	Latitude   float64
+	Longitude  float64
+	// Distance is the distance in km from the searched location.
+	Distance float64
+}
+
+// Client manages the GeoNames data.
+type Client struct {
+	// MaxDistance is the distance in km beyond which cities are not near a
+	// location.
+	MaxDistance float64
+
+	src content.Provider
+	// admin1 is the dataset of administrative region names, if any.
+	admin1    content.Provider
+	mu        sync.Mutex
+	places    []Place
+	countries map[string]kdtree.Tree
+	// names are the names of administrative regions by country and admin1
+	// code, e.g. "US.NY".
+	names map[string]string
+}
+
+// New creates a new Client from the GeoNames cities dataset at the given URL.
+// Any URL supported by m-lab/go/content may be provided.
+func New(ctx context.Context, u *url.URL) (*Client, error) {
+	p, err := content.FromURL(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{MaxDistance: DefaultMaxDistance, src: p}, nil
+}
+
+// SetAdmin1 names the administrative regions of cities from the GeoNames
+// admin1CodesASCII.txt dataset at the given URL, read on every Load.
+func (c *Client) SetAdmin1(ctx context.Context, u *url.URL) error {
+	p, err := content.FromURL(ctx, u)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.admin1 = p
+	return nil
+}
+
+// Load downloads and parses the GeoNames data from the provider sources.
+// Unchanged sources are not parsed again.
+func (c *Client) Load(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	raw, err := c.src.Get(ctx)
+	switch {
+	case err == content.ErrNoChange && c.places != nil:
+	case err != nil:
+		return err
+	default:
+		places := parseCities(raw)
+		byCountry := map[string][]kdtree.Point{}
+		for i, p := range places {
+			byCountry[p.CountryCode] = append(byCountry[p.CountryCode], kdtree.NewPoint(p.Latitude, p.Longitude, i))
+		}
+		countries := make(map[string]kdtree.Tree, len(byCountry))
+		for country, pts := range byCountry {
+			countries[country] = kdtree.New(pts)
+		}
+		c.places = places
+		c.countries = countries
+	}
+	if c.admin1 == nil {
+		return nil
+	}
+	raw, err = c.admin1.Get(ctx)
+	switch {
+	case err == content.ErrNoChange && c.names != nil:
+	case err != nil:
+		return err
+	default:
+		c.names = parseAdmin1(raw)
+	}
+	return nil
+}
+
+// Len returns the number of cities in use.
+func (c *Client) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.places)
+}
+
+// Nearest returns the city closest to the given lat/lon within the given
+// country, if closer than MaxDistance.
+func (c *Client) Nearest(country string, lat, lon float64) (Place, error) {
+	c.mu.Lock()
+	// Allow safe Load during Nearest.
+	places, countries, names := c.places, c.countries, c.names
+	c.mu.Unlock()
+	pts := countries[country].Nearest(kdtree.NewPoint(lat, lon, -1), 1, kdtree.Chord2(c.MaxDistance))
+	if len(pts) == 0 {
+		return Place{}, ErrNotFound
+	}
+	p := places[pts[0].ID]
+	p.Admin1 = names[p.CountryCode+"."+p.Admin1Code]
+	p.Distance = mathx.GetHaversineDistance(lat, lon, p.Latitude, p.Longitude)
+	return p, nil
+}
+
+// parseCities parses the tab separated cities dataset. Invalid rows are
+// skipped. The fields used are:
+//
+//	geonameid, name, asciiname, alternatenames, latitude, longitude,
+//	feature class, feature code, country code, cc2, admin1 code, ...
+func parseCities(raw []byte) []Place {
+	places := []Place{}
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 11 {
+			continue
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			continue
+		}
+		lon, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			continue
+		}
+		p := Place{
+			City:        fields[1],
+			CountryCode: fields[8],
+			Admin1Code:  fields[10],
+			Latitude:    lat,
+			Longitude:   lon,
+		}
+		places = append(places, p)
+	}
+	return places
+}
+
+// parseAdmin1 parses the tab separated admin1 codes dataset, by country and
+// admin1 code, e.g. "US.NY".
+func parseAdmin1(raw []byte) map[string]string {
+	names := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		names[fields[0]] = fields[1]
+	}
+	return names
+}
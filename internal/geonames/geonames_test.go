@@ -0,0 +1,195 @@
+package geonames
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/m-lab/go/testingx"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			file: "file:testdata/cities.txt",
+		},
+		{
+			name:    "error",
+			file:    "fake-scheme:file-does-not-exist.txt",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.file)
+			testingx.Must(t, err, "failed to parse file %s", tt.file)
+			_, err = New(context.Background(), u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := (&Client{}).SetAdmin1(context.Background(), u); (err != nil) != tt.wantErr {
+				t.Errorf("Client.SetAdmin1() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_Load(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		admin1  string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			file: "file:testdata/cities.txt",
+		},
+		{
+			name:   "success-admin1",
+			file:   "file:testdata/cities.txt",
+			admin1: "file:testdata/admin1.txt",
+		},
+		{
+			name:    "error-file",
+			file:    "file:testdata/does-not-exist.txt",
+			wantErr: true,
+		},
+		{
+			name:    "error-admin1",
+			file:    "file:testdata/cities.txt",
+			admin1:  "file:testdata/does-not-exist.txt",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.file)
+			testingx.Must(t, err, "failed to parse file %s", tt.file)
+			c, err := New(context.Background(), u)
+			testingx.Must(t, err, "failed to create new client")
+			if tt.admin1 != "" {
+				a, err := url.Parse(tt.admin1)
+				testingx.Must(t, err, "failed to parse file %s", tt.admin1)
+				testingx.Must(t, c.SetAdmin1(context.Background(), a), "failed to set admin1")
+			}
+
+			if err := c.Load(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("Client.Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// Unchanged data is kept.
+			testingx.Must(t, c.Load(context.Background()), "failed to reload unchanged dataset")
+			if c.Len() != 3 {
+				t.Errorf("Client.Len() = %d, want 3", c.Len())
+			}
+		})
+	}
+}
+
+func TestClient_Nearest(t *testing.T) {
+	tests := []struct {
+		name    string
+		country string
+		lat     float64
+		lon     float64
+		max     float64
+		want    Place
+		wantErr bool
+	}{
+		{
+			name:    "success",
+			country: "US",
+			lat:     40.7769, // LGA.
+			lon:     -73.8740,
+			want: Place{
+				City:        "New York City",
+				CountryCode: "US",
+				Admin1Code:  "NY",
+				Admin1:      "New York",
+				Latitude:    40.71427,
+				Longitude:   -74.00597,
+			},
+		},
+		{
+			name:    "success-nearest-in-country",
+			country: "US",
+			lat:     40.6925, // EWR.
+			lon:     -74.1687,
+			want: Place{
+				City:        "Newark",
+				CountryCode: "US",
+				Admin1Code:  "NJ",
+				Admin1:      "New Jersey",
+				Latitude:    40.73566,
+				Longitude:   -74.17237,
+			},
+		},
+		{
+			name:    "success-unknown-admin1",
+			country: "GB",
+			lat:     51.4700, // LHR.
+			lon:     -0.4543,
+			want: Place{
+				City:        "London",
+				CountryCode: "GB",
+				Admin1Code:  "ENG",
+				Latitude:    51.50853,
+				Longitude:   -0.12574,
+			},
+		},
+		{
+			name:    "error-too-far",
+			country: "US",
+			lat:     40.7769,
+			lon:     -73.8740,
+			max:     5,
+			wantErr: true,
+		},
+		{
+			name:    "error-country",
+			country: "CA",
+			lat:     40.7769,
+			lon:     -73.8740,
+			wantErr: true,
+		},
+	}
+	u, err := url.Parse("file:testdata/cities.txt")
+	testingx.Must(t, err, "failed to parse url")
+	a, err := url.Parse("file:testdata/admin1.txt")
+	testingx.Must(t, err, "failed to parse url")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(context.Background(), u)
+			testingx.Must(t, err, "failed to create new client")
+			testingx.Must(t, c.SetAdmin1(context.Background(), a), "failed to set admin1")
+			testingx.Must(t, c.Load(context.Background()), "failed to load dataset")
+			if tt.max != 0 {
+				c.MaxDistance = tt.max
+			}
+
+			got, err := c.Nearest(tt.country, tt.lat, tt.lon)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.Nearest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Distance <= 0 || got.Distance > c.MaxDistance {
+				t.Errorf("Client.Nearest() distance = %v, want within %v", got.Distance, c.MaxDistance)
+			}
+			got.Distance = 0
+			if got != tt.want {
+				t.Errorf("Client.Nearest() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
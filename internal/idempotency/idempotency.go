@@ -0,0 +1,99 @@
+// Package idempotency saves responses by client provided idempotency keys, so
+// that retried requests are answered from the saved response rather than
+// repeating DNS and tracker changes.
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// keyPrefix separates idempotency entries from other keys in Redis.
+const keyPrefix = "idempotency:"
+
+// ErrInProgress is returned when a request with the same key has started but
+// not finished.
+var ErrInProgress = errors.New("request with idempotency key is in progress")
+
+// Entry is the saved response of a request.
+type Entry struct {
+	// Fingerprint identifies the request that first used the key.
+	Fingerprint string
+	// Done is false while the first request is in progress.
+	Done        bool
+	Status      int    `json:",omitempty"`
+	ContentType string `json:",omitempty"`
+	Body        []byte `json:",omitempty"`
+}
+
+// Store saves entries in Redis. Entries expire after the configured window.
+//
+// NOTE: the tracker reads every key of its Redis database as a hash, so the
+// Store must use a different database.
+type Store struct {
+	pool   *redis.Pool
+	window time.Duration
+}
+
+// NewStore creates a new Store that keeps entries for the given window.
+func NewStore(pool *redis.Pool, window time.Duration) *Store {
+	return &Store{pool: pool, window: window}
+}
+
+// Start reserves the key for a new request with the given fingerprint. If the
+// key is already reserved, Start returns the saved entry instead, or
+// ErrInProgress if the first request has not finished. A nil entry means the
+// caller should handle the request and then call Finish or Cancel.
+func (s *Store) Start(key, fingerprint string) (*Entry, error) {
+	b, err := json.Marshal(&Entry{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	reply, err := conn.Do("SET", keyPrefix+key, b, "NX", "PX", s.window.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	if reply != nil {
+		// The key was reserved.
+		return nil, nil
+	}
+	raw, err := redis.Bytes(conn.Do("GET", keyPrefix+key))
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if err := json.Unmarshal(raw, e); err != nil {
+		return nil, err
+	}
+	if !e.Done && e.Fingerprint == fingerprint {
+		return nil, ErrInProgress
+	}
+	return e, nil
+}
+
+// Finish saves the response of the request that reserved the key.
+func (s *Store) Finish(key string, e *Entry) error {
+	e.Done = true
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", keyPrefix+key, b, "PX", s.window.Milliseconds())
+	return err
+}
+
+// Cancel releases the key so that the request may be retried, e.g. after a
+// transient server error.
+func (s *Store) Cancel(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", keyPrefix+key)
+	return err
+}
@@ -0,0 +1,103 @@
+package idempotency
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeConn implements the subset of Redis commands used by Store.
+type fakeConn struct {
+	m   map[string][]byte
+	err error
+}
+
+func (c *fakeConn) Close() error                               { return nil }
+func (c *fakeConn) Err() error                                 { return nil }
+func (c *fakeConn) Send(cmd string, args ...interface{}) error { return nil }
+func (c *fakeConn) Flush() error                               { return nil }
+func (c *fakeConn) Receive() (interface{}, error)              { return nil, nil }
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		// The pool flushes connections with an empty command on close.
+		return nil, nil
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	key := args[0].(string)
+	switch cmd {
+	case "SET":
+		if _, ok := c.m[key]; ok && args[2] == "NX" {
+			return nil, nil
+		}
+		c.m[key] = args[1].([]byte)
+		return "OK", nil
+	case "GET":
+		v, ok := c.m[key]
+		if !ok {
+			return nil, nil
+		}
+		return v, nil
+	case "DEL":
+		delete(c.m, key)
+		return int64(1), nil
+	}
+	return nil, errors.New("unsupported command")
+}
+
+func TestStore(t *testing.T) {
+	conn := &fakeConn{m: map[string][]byte{}}
+	s := NewStore(&redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}, time.Minute)
+
+	// The first request reserves the key.
+	e, err := s.Start("abc", "fp1")
+	if e != nil || err != nil {
+		t.Fatalf("Start() = %v, %v; want nil, nil", e, err)
+	}
+	// Duplicates wait for the first request to finish.
+	if _, err := s.Start("abc", "fp1"); err != ErrInProgress {
+		t.Errorf("Start() returned wrong error; got %v, want %v", err, ErrInProgress)
+	}
+	err = s.Finish("abc", &Entry{Fingerprint: "fp1", Status: 200, ContentType: "application/json", Body: []byte("{}")})
+	if err != nil {
+		t.Fatalf("Finish() returned err: %v", err)
+	}
+	// Duplicates receive the saved response.
+	e, err = s.Start("abc", "fp1")
+	if err != nil || e == nil || !e.Done || e.Status != 200 || string(e.Body) != "{}" {
+		t.Errorf("Start() = %v, %v; want saved entry", e, err)
+	}
+	// Reuse of the key by a different request returns the original fingerprint.
+	e, err = s.Start("abc", "fp2")
+	if err != nil || e == nil || e.Fingerprint != "fp1" {
+		t.Errorf("Start() = %v, %v; want entry for fp1", e, err)
+	}
+
+	// Canceled keys may be reused.
+	if err := s.Cancel("abc"); err != nil {
+		t.Fatalf("Cancel() returned err: %v", err)
+	}
+	if e, err := s.Start("abc", "fp2"); e != nil || err != nil {
+		t.Errorf("Start() = %v, %v; want nil, nil", e, err)
+	}
+
+	// Corrupt entries are errors.
+	conn.m[keyPrefix+"bad"] = []byte("not json")
+	if _, err := s.Start("bad", "fp1"); err == nil {
+		t.Errorf("Start() returned nil error for corrupt entry")
+	}
+
+	conn.err = errors.New("fake redis error")
+	if _, err := s.Start("abc", "fp1"); err != conn.err {
+		t.Errorf("Start() returned wrong error; got %v, want %v", err, conn.err)
+	}
+	if err := s.Finish("abc", &Entry{}); err != conn.err {
+		t.Errorf("Finish() returned wrong error; got %v, want %v", err, conn.err)
+	}
+	if err := s.Cancel("abc"); err != conn.err {
+		t.Errorf("Cancel() returned wrong error; got %v, want %v", err, conn.err)
+	}
+}
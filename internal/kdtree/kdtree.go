@@ -0,0 +1,126 @@
+// Package kdtree finds the places nearest to a location, e.g. airports or
+// cities, in a 3-d tree of their positions on the unit sphere.
+package kdtree
+
+import (
+	"math"
+	"sort"
+)
+
+// earthRadiusKm is the radius used by mathx.GetHaversineDistance.
+const earthRadiusKm = 6371
+
+// Point is the position of a place on the unit sphere. Euclidean distances
+// between points grow with great-circle distances, without special cases for
+// the poles or the antimeridian.
+type Point struct {
+	v [3]float64
+	// ID identifies the place, e.g. its index in a dataset.
+	ID int
+}
+
+// NewPoint returns the point of the place with the given id at lat/lon.
+func NewPoint(lat, lon float64, id int) Point {
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	return Point{
+		v:  [3]float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)},
+		ID: id,
+	}
+}
+
+// Chord2 returns the squared distance between the points on the unit sphere
+// that are km apart. Every point is within Chord2 of 4 of every other.
+func Chord2(km float64) float64 {
+	if km >= math.Pi*earthRadiusKm {
+		return 4
+	}
+	c := 2 * math.Sin(km/(2*earthRadiusKm))
+	return c * c
+}
+
+func dist2(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// Tree is a 3-d tree of points stored in place: the median of every range
+// is its root, with the lower half of the range on the left and the upper half
+// on the right, split by the coordinates x, y, and z in turn.
+type Tree []Point
+
+// New builds a tree of the given points, which are reordered in place.
+func New(pts []Point) Tree {
+	t := Tree(pts)
+	t.build(0, len(t), 0)
+	return t
+}
+
+func (t Tree) build(lo, hi, axis int) {
+	if hi-lo <= 1 {
+		return
+	}
+	s := t[lo:hi]
+	sort.Slice(s, func(i, j int) bool { return s[i].v[axis] < s[j].v[axis] })
+	mid := (lo + hi) / 2
+	t.build(lo, mid, (axis+1)%3)
+	t.build(mid+1, hi, (axis+1)%3)
+}
+
+// Nearest returns up to n points closest to p within the squared distance
+// max, closest first.
+func (t Tree) Nearest(p Point, n int, max float64) []Point {
+	nb := &neighbors{n: n, max: max}
+	t.search(0, len(t), 0, p.v, nb)
+	return nb.pts
+}
+
+func (t Tree) search(lo, hi, axis int, v [3]float64, nb *neighbors) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	p := t[mid]
+	if d := dist2(p.v, v); d <= nb.max {
+		nb.add(p, d)
+	}
+	next := (axis + 1) % 3
+	diff := v[axis] - p.v[axis]
+	if diff < 0 {
+		t.search(lo, mid, next, v, nb)
+		if diff*diff <= nb.max {
+			t.search(mid+1, hi, next, v, nb)
+		}
+		return
+	}
+	t.search(mid+1, hi, next, v, nb)
+	if diff*diff <= nb.max {
+		t.search(lo, mid, next, v, nb)
+	}
+}
+
+// neighbors collects the n closest points found so far. Once n points are
+// found, max shrinks to the distance of the farthest one.
+type neighbors struct {
+	n   int
+	max float64
+	pts []Point
+	d   []float64
+}
+
+func (nb *neighbors) add(p Point, d float64) {
+	i := sort.SearchFloat64s(nb.d, d)
+	if i >= nb.n {
+		return
+	}
+	nb.pts = append(nb.pts, Point{})
+	nb.d = append(nb.d, 0)
+	copy(nb.pts[i+1:], nb.pts[i:])
+	copy(nb.d[i+1:], nb.d[i:])
+	nb.pts[i], nb.d[i] = p, d
+	if len(nb.pts) > nb.n {
+		nb.pts, nb.d = nb.pts[:nb.n], nb.d[:nb.n]
+	}
+	if len(nb.pts) == nb.n {
+		nb.max = nb.d[nb.n-1]
+	}
+}
@@ -0,0 +1,66 @@
+package kdtree
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestTree_Nearest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	pts := make([]Point, 500)
+	for i := range pts {
+		pts[i] = NewPoint(r.Float64()*180-90, r.Float64()*360-180, i)
+	}
+	// The tree reorders its points, so the brute force search uses a copy.
+	want := append([]Point{}, pts...)
+	tree := New(pts)
+	queries := []Point{
+		NewPoint(40.775, -73.875, -1),
+		NewPoint(90, 0, -1),
+		NewPoint(-45, 180, -1),
+		NewPoint(0, -179.9, -1),
+	}
+	for _, q := range queries {
+		for _, tt := range []struct {
+			n   int
+			max float64
+		}{
+			{n: 1, max: 4},
+			{n: 10, max: 4},
+			{n: 500, max: Chord2(1000)},
+		} {
+			sort.Slice(want, func(i, j int) bool {
+				return dist2(want[i].v, q.v) < dist2(want[j].v, q.v)
+			})
+			expected := []int{}
+			for _, p := range want {
+				if len(expected) < tt.n && dist2(p.v, q.v) <= tt.max {
+					expected = append(expected, p.ID)
+				}
+			}
+			got := tree.Nearest(q, tt.n, tt.max)
+			if len(got) != len(expected) {
+				t.Fatalf("Nearest(%v, %d) returned %d points, want %d", q.v, tt.n, len(got), len(expected))
+			}
+			for i := range got {
+				if got[i].ID != expected[i] {
+					t.Errorf("Nearest(%v, %d)[%d] = %d, want %d", q.v, tt.n, i, got[i].ID, expected[i])
+				}
+			}
+		}
+	}
+}
+
+func TestChord2(t *testing.T) {
+	a, b := NewPoint(40.775, -73.875, 0), NewPoint(40.6397, -73.7789, 1)
+	// Haversine distance between LGA and JFK.
+	km := 17.0868
+	if d := dist2(a.v, b.v); math.Abs(d-Chord2(km)) > 1e-10 {
+		t.Errorf("Chord2(%v) = %v, want %v", km, Chord2(km), d)
+	}
+	if got := Chord2(30000); got != 4 {
+		t.Errorf("Chord2(30000) = %v, want 4", got)
+	}
+}
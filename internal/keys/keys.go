@@ -0,0 +1,309 @@
+// Package keys persists the scopes of API keys and validates API keys used
+// with the Autojoin API.
+package keys
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/metrics"
+)
+
+// Kind is the Datastore kind of API key entities.
+const Kind = "APIKey"
+
+// Scopes grant access to groups of endpoints.
+const (
+	// ScopeRegister allows nodes to register, update, and declare maintenance.
+	ScopeRegister = "register"
+	// ScopeDelete allows deleting nodes and sites, and reading the status of
+	// asynchronous deletes.
+	ScopeDelete = "delete"
+	// ScopeRecords allows managing the extra records of the organization
+	// zone.
+	ScopeRecords = "records"
+	// ScopeAdmin allows the admin endpoints, which manage every organization.
+	// It is only granted to keys that name it.
+	ScopeAdmin = "admin"
+)
+
+// AllScopes contains every scope.
+var AllScopes = []string{ScopeRegister, ScopeDelete, ScopeRecords, ScopeAdmin}
+
+// DefaultScopes are granted to keys without scopes.
+var DefaultScopes = []string{ScopeRegister, ScopeDelete, ScopeRecords}
+
+// ErrNotFound is returned when an API key has no saved entity.
+var ErrNotFound = errors.New("api key not found")
+
+// ErrExpired is returned when validating an API key after its expiration.
+var ErrExpired = errors.New("api key expired")
+
+// ErrRevoked is returned when validating a revoked API key.
+var ErrRevoked = errors.New("api key revoked")
+
+// Key is the entity saved for an API key. The Datastore name of the entity is
+// the API key ID.
+type Key struct {
+	// ID is the API key ID, taken from the Datastore key.
+	ID string `datastore:"-"`
+	// Org is the organization that owns the key.
+	Org string
+	// Scopes granted to the key. Keys without scopes are granted
+	// DefaultScopes.
+	Scopes []string
+	// Created is the time the key was created by the Manager.
+	Created time.Time `datastore:",noindex"`
+	// ExpiresAt is the time after which the key is rejected. Zero never
+	// expires.
+	ExpiresAt time.Time `datastore:",noindex"`
+	// Revoked is the time the key was revoked. Zero is not revoked.
+	Revoked time.Time `datastore:",noindex"`
+}
+
+// Info describes a validated API key.
+type Info struct {
+	ID     string
+	Org    string
+	Scopes []string
+	// ExpiresAt is the time after which the key is rejected. Zero never
+	// expires.
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the key is expired at t.
+func (i *Info) Expired(t time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !t.Before(i.ExpiresAt)
+}
+
+// Allows reports whether the key is granted the scope.
+func (i *Info) Allows(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScope reports whether scope is a known scope.
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Datastore is the subset of the Datastore client used to persist keys.
+// It is implemented by *datastore.Client.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+}
+
+// Store reads and writes API key entities.
+type Store struct {
+	ds        Datastore
+	namespace string
+}
+
+// NewStore creates a new Store that saves keys in the given Datastore
+// namespace.
+func NewStore(ds Datastore, namespace string) *Store {
+	return &Store{ds: ds, namespace: namespace}
+}
+
+// Get returns the entity of the API key ID.
+func (s *Store) Get(ctx context.Context, id string) (*Key, error) {
+	k := &Key{}
+	start := time.Now()
+	err := s.ds.Get(ctx, s.key(id), k)
+	metrics.DatastoreLookupDuration.WithLabelValues(Kind).Observe(time.Since(start).Seconds())
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	k.ID = id
+	return k, nil
+}
+
+// List returns the entities of all API keys of the organization.
+func (s *Store) List(ctx context.Context, org string) ([]*Key, error) {
+	q := datastore.NewQuery(Kind).Namespace(s.namespace).FilterField("Org", "=", org)
+	l := []*Key{}
+	names, err := s.ds.GetAll(ctx, q, &l)
+	if err != nil {
+		return nil, err
+	}
+	for i := range l {
+		l[i].ID = names[i].Name
+	}
+	return l, nil
+}
+
+// Put saves the entity of the API key ID.
+func (s *Store) Put(ctx context.Context, id string, k *Key) error {
+	_, err := s.ds.Put(ctx, s.key(id), k)
+	return err
+}
+
+func (s *Store) key(id string) *datastore.Key {
+	k := datastore.NameKey(Kind, id, nil)
+	k.Namespace = s.namespace
+	return k
+}
+
+// Finder finds the ID and organization of an API key string. It is
+// implemented by *adminx.APIKeys.
+type Finder interface {
+	FindKey(ctx context.Context, key string) (id, org string, err error)
+}
+
+// Validator validates API keys and finds their scopes.
+type Validator struct {
+	finder Finder
+	store  *Store
+}
+
+// NewValidator creates a new Validator.
+func NewValidator(f Finder, s *Store) *Validator {
+	return &Validator{finder: f, store: s}
+}
+
+// ValidateKey returns the Info of the API key string. Keys without a saved
+// entity are granted DefaultScopes. Expired and revoked keys return ErrExpired
+// and ErrRevoked.
+func (v *Validator) ValidateKey(ctx context.Context, key string) (*Info, error) {
+	id, org, err := v.finder.FindKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	k, err := v.store.Get(ctx, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return &Info{ID: id, Org: org, Scopes: DefaultScopes}, nil
+	case err != nil:
+		return nil, err
+	case !k.Revoked.IsZero():
+		return nil, ErrRevoked
+	}
+	scopes := k.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	if k.Org != "" {
+		// Keys created by the Manager have IDs that do not name the org.
+		org = k.Org
+	}
+	info := &Info{ID: id, Org: org, Scopes: scopes, ExpiresAt: k.ExpiresAt}
+	if info.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return info, nil
+}
+
+// Creator creates and deletes API keys. It is implemented by *adminx.APIKeys.
+type Creator interface {
+	AddKey(ctx context.Context, org string) (id, key string, err error)
+	DeleteKey(ctx context.Context, id string) error
+}
+
+// Manager creates, lists, and revokes the API keys of organizations.
+type Manager struct {
+	creator Creator
+	store   *Store
+}
+
+// NewManager creates a new Manager.
+func NewManager(c Creator, s *Store) *Manager {
+	return &Manager{creator: c, store: s}
+}
+
+// Create creates a new API key for the organization with the given scopes and
+// expiration, which may be zero. The key string is only returned by Create.
+func (m *Manager) Create(ctx context.Context, org string, scopes []string, expires time.Time) (*Key, string, error) {
+	id, key, err := m.creator.AddKey(ctx, org)
+	if err != nil {
+		return nil, "", err
+	}
+	k := &Key{
+		ID:        id,
+		Org:       org,
+		Scopes:    scopes,
+		Created:   time.Now().UTC(),
+		ExpiresAt: expires,
+	}
+	if err := m.store.Put(ctx, id, k); err != nil {
+		// Without an entity, the key would not be attributed to the org.
+		if derr := m.creator.DeleteKey(ctx, id); derr != nil {
+			log.Printf("Failed to delete API key %s after save failure: %v", id, derr)
+		}
+		return nil, "", err
+	}
+	return k, key, nil
+}
+
+// List returns the API keys of the organization created by the Manager.
+func (m *Manager) List(ctx context.Context, org string) ([]*Key, error) {
+	return m.store.List(ctx, org)
+}
+
+// Revoke marks the API key of the organization as revoked and deletes it.
+// Keys not created by the Manager, or owned by other organizations, return
+// ErrNotFound.
+func (m *Manager) Revoke(ctx context.Context, org, id string) error {
+	k, err := m.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if k.Org != org || k.Created.IsZero() {
+		return ErrNotFound
+	}
+	if k.Revoked.IsZero() {
+		// Save the revocation first, so the key is rejected even if the
+		// deletion below fails.
+		k.Revoked = time.Now().UTC()
+		if err := m.store.Put(ctx, id, k); err != nil {
+			return err
+		}
+	}
+	return m.creator.DeleteKey(ctx, id)
+}
+
+// Rotate replaces the API key ID of the organization with a new key granted
+// the same scopes. The old key expires after grace, so that nodes may switch
+// to the new key first. The old key may be a key not created by the Manager,
+// e.g. the key created at organization setup, which must belong to org. If the
+// old key cannot be updated, the new key is returned with the error.
+func (m *Manager) Rotate(ctx context.Context, org, id string, grace time.Duration) (*Key, string, error) {
+	old, err := m.store.Get(ctx, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// Keys without entities are granted DefaultScopes.
+		old = &Key{Org: org}
+	case err != nil:
+		return nil, "", err
+	case old.Org != org || !old.Revoked.IsZero():
+		return nil, "", ErrNotFound
+	}
+	k, key, err := m.Create(ctx, org, old.Scopes, time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+	expires := time.Now().Add(grace).UTC()
+	if old.ExpiresAt.IsZero() || expires.Before(old.ExpiresAt) {
+		old.ExpiresAt = expires
+		if err := m.store.Put(ctx, id, old); err != nil {
+			return k, key, err
+		}
+	}
+	return k, key, nil
+}
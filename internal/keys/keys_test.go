@@ -0,0 +1,321 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+type fakeDatastore struct {
+	m      map[string]Key
+	getErr error
+	putErr error
+	key    *datastore.Key
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	k, ok := f.m[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Key) = k
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.m[key.Name] = *src.(*Key)
+	return key, nil
+}
+
+// GetAll returns every entity, ignoring the query filter.
+func (f *fakeDatastore) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	names := []string{}
+	for name := range f.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	keys := []*datastore.Key{}
+	l := dst.(*[]*Key)
+	for _, name := range names {
+		k := f.m[name]
+		keys = append(keys, datastore.NameKey(Kind, name, nil))
+		*l = append(*l, &k)
+	}
+	return keys, nil
+}
+
+type fakeFinder struct {
+	id  string
+	org string
+	err error
+}
+
+func (f *fakeFinder) FindKey(ctx context.Context, key string) (string, string, error) {
+	return f.id, f.org, f.err
+}
+
+func TestStore(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Key{}}
+	s := NewStore(ds, "test")
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "autojoin-key-mlab"); err != ErrNotFound {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+	if ds.key.Kind != Kind || ds.key.Name != "autojoin-key-mlab" || ds.key.Namespace != "test" {
+		t.Errorf("Get() used wrong key; got %v", ds.key)
+	}
+	want := &Key{ID: "autojoin-key-mlab", Org: "mlab", Scopes: []string{ScopeRegister}}
+	if err := s.Put(ctx, "autojoin-key-mlab", want); err != nil {
+		t.Fatalf("Put() returned err: %v", err)
+	}
+	got, err := s.Get(ctx, "autojoin-key-mlab")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %v, %v; want %v", got, err, want)
+	}
+	l, err := s.List(ctx, "mlab")
+	if err != nil || len(l) != 1 || !reflect.DeepEqual(l[0], want) {
+		t.Errorf("List() = %v, %v; want [%v]", l, err, want)
+	}
+	ds.getErr = errors.New("fake get error")
+	if _, err := s.Get(ctx, "autojoin-key-mlab"); err != ds.getErr {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+	if _, err := s.List(ctx, "mlab"); err != ds.getErr {
+		t.Errorf("List() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+}
+
+func TestValidator_ValidateKey(t *testing.T) {
+	errFind := errors.New("fake unknown key")
+	errGet := errors.New("fake get error")
+	tests := []struct {
+		name    string
+		finder  *fakeFinder
+		keys    map[string]Key
+		getErr  error
+		want    *Info
+		wantErr error
+	}{
+		{
+			name:   "success-no-entity",
+			finder: &fakeFinder{id: "autojoin-key-mlab", org: "mlab"},
+			want:   &Info{ID: "autojoin-key-mlab", Org: "mlab", Scopes: DefaultScopes},
+		},
+		{
+			name:   "success-scopes",
+			finder: &fakeFinder{id: "autojoin-key-mlab", org: "mlab"},
+			keys:   map[string]Key{"autojoin-key-mlab": {Org: "mlab", Scopes: []string{ScopeRegister}}},
+			want:   &Info{ID: "autojoin-key-mlab", Org: "mlab", Scopes: []string{ScopeRegister}},
+		},
+		{
+			name:   "success-empty-scopes",
+			finder: &fakeFinder{id: "autojoin-key-mlab", org: "mlab"},
+			keys:   map[string]Key{"autojoin-key-mlab": {Org: "mlab"}},
+			want:   &Info{ID: "autojoin-key-mlab", Org: "mlab", Scopes: DefaultScopes},
+		},
+		{
+			name:   "success-manager-key",
+			finder: &fakeFinder{id: "autojoin-key-mlab-1234", org: "mlab-1234"},
+			keys:   map[string]Key{"autojoin-key-mlab-1234": {Org: "mlab", ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Second)}},
+			want:   &Info{ID: "autojoin-key-mlab-1234", Org: "mlab", Scopes: DefaultScopes, ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Second)},
+		},
+		{
+			name:    "error-expired",
+			finder:  &fakeFinder{id: "autojoin-key-mlab-1234", org: "mlab-1234"},
+			keys:    map[string]Key{"autojoin-key-mlab-1234": {Org: "mlab", ExpiresAt: time.Now().Add(-time.Hour)}},
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "error-revoked",
+			finder:  &fakeFinder{id: "autojoin-key-mlab-1234", org: "mlab-1234"},
+			keys:    map[string]Key{"autojoin-key-mlab-1234": {Org: "mlab", Revoked: time.Now()}},
+			wantErr: ErrRevoked,
+		},
+		{
+			name:    "error-finder",
+			finder:  &fakeFinder{err: errFind},
+			wantErr: errFind,
+		},
+		{
+			name:    "error-get",
+			finder:  &fakeFinder{id: "autojoin-key-mlab", org: "mlab"},
+			getErr:  errGet,
+			wantErr: errGet,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &fakeDatastore{m: tt.keys, getErr: tt.getErr}
+			v := NewValidator(tt.finder, NewStore(ds, "test"))
+			got, err := v.ValidateKey(context.Background(), "12345")
+			if err != tt.wantErr {
+				t.Fatalf("ValidateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfo_Allows(t *testing.T) {
+	i := &Info{Scopes: []string{ScopeRegister}}
+	if !i.Allows(ScopeRegister) || i.Allows(ScopeDelete) {
+		t.Errorf("Allows() returned wrong result for %v", i.Scopes)
+	}
+	if !ValidScope(ScopeDelete) || !ValidScope(ScopeAdmin) || ValidScope("owner") {
+		t.Errorf("ValidScope() returned wrong result")
+	}
+}
+
+type fakeCreator struct {
+	createErr error
+	deleteErr error
+	deleted   []string
+}
+
+func (f *fakeCreator) AddKey(ctx context.Context, org string) (string, string, error) {
+	return "autojoin-key-" + org + "-1234", "secret", f.createErr
+}
+
+func (f *fakeCreator) DeleteKey(ctx context.Context, id string) error {
+	f.deleted = append(f.deleted, id)
+	return f.deleteErr
+}
+
+func TestManager(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Key{}}
+	c := &fakeCreator{}
+	m := NewManager(c, NewStore(ds, "test"))
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	k, key, err := m.Create(ctx, "mlab", []string{ScopeRegister}, expires)
+	if err != nil || key != "secret" || k.ID != "autojoin-key-mlab-1234" || k.Created.IsZero() {
+		t.Fatalf("Create() = %v, %q, %v; want new key", k, key, err)
+	}
+	l, err := m.List(ctx, "mlab")
+	if err != nil || len(l) != 1 || !l[0].ExpiresAt.Equal(expires) {
+		t.Errorf("List() = %v, %v; want created key", l, err)
+	}
+
+	// Keys of other organizations cannot be revoked.
+	if err := m.Revoke(ctx, "other", k.ID); err != ErrNotFound {
+		t.Errorf("Revoke() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+	if err := m.Revoke(ctx, "mlab", k.ID); err != nil {
+		t.Fatalf("Revoke() returned err: %v", err)
+	}
+	if ds.m[k.ID].Revoked.IsZero() || !reflect.DeepEqual(c.deleted, []string{k.ID}) {
+		t.Errorf("Revoke() did not revoke and delete key; got %v, %v", ds.m[k.ID], c.deleted)
+	}
+	// Keys that were not created by the Manager cannot be revoked.
+	ds.m["autojoin-key-mlab"] = Key{Org: "mlab", Scopes: []string{ScopeRegister}}
+	if err := m.Revoke(ctx, "mlab", "autojoin-key-mlab"); err != ErrNotFound {
+		t.Errorf("Revoke() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+
+	// Keys are deleted if their entity cannot be saved.
+	ds.putErr = errors.New("fake put error")
+	if _, _, err := m.Create(ctx, "foo", nil, time.Time{}); err != ds.putErr {
+		t.Errorf("Create() returned wrong error; got %v, want %v", err, ds.putErr)
+	}
+	if len(c.deleted) != 2 || c.deleted[1] != "autojoin-key-foo-1234" {
+		t.Errorf("Create() did not delete key after failure; got %v", c.deleted)
+	}
+	c.createErr = errors.New("fake create error")
+	if _, _, err := m.Create(ctx, "foo", nil, time.Time{}); err != c.createErr {
+		t.Errorf("Create() returned wrong error; got %v, want %v", err, c.createErr)
+	}
+}
+
+func TestManager_Rotate(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		keys       map[string]Key
+		id         string
+		createErr  error
+		wantScopes []string
+		wantErr    error
+		wantAnyErr bool
+	}{
+		{
+			name:       "success-org-key",
+			keys:       map[string]Key{},
+			id:         "autojoin-key-mlab",
+			wantScopes: nil,
+		},
+		{
+			name: "success-manager-key",
+			keys: map[string]Key{
+				"autojoin-key-mlab-abcd": {Org: "mlab", Scopes: []string{ScopeRegister}, Created: time.Now()},
+			},
+			id:         "autojoin-key-mlab-abcd",
+			wantScopes: []string{ScopeRegister},
+		},
+		{
+			name: "error-other-org",
+			keys: map[string]Key{
+				"autojoin-key-other-abcd": {Org: "other", Created: time.Now()},
+			},
+			id:      "autojoin-key-other-abcd",
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "error-revoked",
+			keys: map[string]Key{
+				"autojoin-key-mlab-abcd": {Org: "mlab", Created: time.Now(), Revoked: time.Now()},
+			},
+			id:      "autojoin-key-mlab-abcd",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:       "error-create",
+			keys:       map[string]Key{},
+			id:         "autojoin-key-mlab",
+			createErr:  errors.New("fake create error"),
+			wantAnyErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &fakeDatastore{m: tt.keys}
+			m := NewManager(&fakeCreator{createErr: tt.createErr}, NewStore(ds, "test"))
+			k, key, err := m.Rotate(ctx, "mlab", tt.id, time.Hour)
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Fatalf("Rotate() returned wrong error; got %v, want %v", err, tt.wantErr)
+			}
+			if (err != nil) != (tt.wantErr != nil || tt.wantAnyErr) {
+				t.Fatalf("Rotate() returned err: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if key != "secret" || !reflect.DeepEqual(k.Scopes, tt.wantScopes) || !k.ExpiresAt.IsZero() {
+				t.Errorf("Rotate() = %v, %q; want new key with scopes %v", k, key, tt.wantScopes)
+			}
+			old := ds.m[tt.id]
+			if old.Org != "mlab" || old.ExpiresAt.IsZero() || old.ExpiresAt.After(time.Now().Add(time.Hour)) {
+				t.Errorf("Rotate() did not expire old key; got %v", old)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package maxmind
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/uuid-annotator/annotator"
+	"github.com/m-lab/uuid-annotator/tarreader"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ASN annotates IPv4 and IPv6 addresses with their autonomous system from the
+// GeoLite2 ASN database. It is an alternative to the routeview datasets of the
+// uuid-annotator.
+type ASN struct {
+	mu  sync.RWMutex
+	src content.Provider
+	db  *maxminddb.Reader
+}
+
+// NewASN creates a new ASN instance which loads data from the given
+// content.Provider. Callers should call Load() on the returned ASN instance
+// before calling AnnotateIP(); until then, every address is missing.
+func NewASN(src content.Provider) *ASN {
+	return &ASN{src: src}
+}
+
+// AnnotateIP returns the network of the given IP. The network is Missing if
+// the IP is invalid or not in the dataset.
+func (a *ASN) AnnotateIP(src string) *annotator.Network {
+	ann := &annotator.Network{}
+	ip := net.ParseIP(src)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.db == nil || ip == nil {
+		ann.Missing = true
+		return ann
+	}
+	record := geoip2.ASN{}
+	ipnet, ok, err := a.db.LookupNetwork(ip, &record)
+	if err != nil || !ok || record.AutonomousSystemNumber == 0 {
+		ann.Missing = true
+		return ann
+	}
+	ann.CIDR = ipnet.String()
+	ann.ASNumber = uint32(record.AutonomousSystemNumber)
+	ann.ASName = record.AutonomousSystemOrganization
+	ann.Systems = []annotator.System{{ASNs: []uint32{ann.ASNumber}}}
+	return ann
+}
+
+// Len returns the number of nodes of the search tree of the database in use,
+// or zero until it is loaded.
+func (a *ASN) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.db == nil {
+		return 0
+	}
+	return int(a.db.Metadata.NodeCount)
+}
+
+// Load reads the GeoLite2 ASN database from the provider, unless unchanged.
+func (a *ASN) Load(ctx context.Context) error {
+	tgz, err := a.src.Get(ctx)
+	if err == content.ErrNoChange {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := tarreader.FromTarGZ(tgz, "GeoLite2-ASN.mmdb")
+	if err != nil {
+		return err
+	}
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(db.Metadata.DatabaseType, "ASN") {
+		return fmt.Errorf("wrong database type %q, want GeoLite2-ASN", db.Metadata.DatabaseType)
+	}
+	// Don't acquire the lock until after the data is in RAM.
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.db = db
+	return nil
+}
+
+// Reload is intended to be called regularly to update the local dataset with
+// newer information from the provider. Errors are logged, and the previous
+// data is kept.
+func (a *ASN) Reload(ctx context.Context) {
+	if err := a.Load(ctx); err != nil {
+		log.Printf("Failed to reload GeoLite2 ASN dataset: %v", err)
+	}
+}
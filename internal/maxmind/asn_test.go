@@ -0,0 +1,112 @@
+package maxmind
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/testingx"
+	"github.com/m-lab/uuid-annotator/annotator"
+)
+
+func TestASN_Load(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			src:  "file:testdata/fake-geolite2-asn.tar.gz",
+		},
+		{
+			name:    "error-url",
+			src:     "file:testdata/file-does-not-exist.tar.gz",
+			wantErr: true,
+		},
+		{
+			name:    "error-missing-database",
+			src:     "file:testdata/fake-geolite2.tar.gz",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := url.Parse(tt.src)
+			testingx.Must(t, err, "failed to parse url")
+			src, err := content.FromURL(context.Background(), p)
+			testingx.Must(t, err, "failed to get url")
+			a := NewASN(src)
+			err = a.Load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ASN.Load() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			testingx.Must(t, a.Load(context.Background()), "failed to reload unchanged data")
+			if a.Len() == 0 {
+				t.Errorf("ASN.Len() = 0 after Load()")
+			}
+		})
+	}
+}
+
+func TestASN_AnnotateIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want *annotator.Network
+	}{
+		{
+			name: "success-ipv4",
+			ip:   "192.0.2.10",
+			want: &annotator.Network{
+				CIDR:     "192.0.2.0/24",
+				ASNumber: 64496,
+				ASName:   "Example IPv4 Net",
+				Systems:  []annotator.System{{ASNs: []uint32{64496}}},
+			},
+		},
+		{
+			name: "success-ipv6",
+			ip:   "2001:db8::1",
+			want: &annotator.Network{
+				CIDR:     "2001:db8::/32",
+				ASNumber: 64497,
+				ASName:   "Example IPv6 Net",
+				Systems:  []annotator.System{{ASNs: []uint32{64497}}},
+			},
+		},
+		{
+			name: "missing",
+			ip:   "198.51.100.1",
+			want: &annotator.Network{Missing: true},
+		},
+		{
+			name: "missing-invalid-ip",
+			ip:   "not-an-ip",
+			want: &annotator.Network{Missing: true},
+		},
+	}
+	p, err := url.Parse("file:testdata/fake-geolite2-asn.tar.gz")
+	testingx.Must(t, err, "failed to parse url")
+	src, err := content.FromURL(context.Background(), p)
+	testingx.Must(t, err, "failed to get url")
+	a := NewASN(src)
+	if got := a.AnnotateIP("192.0.2.10"); !got.Missing {
+		t.Errorf("ASN.AnnotateIP() = %#v before Load, want missing", got)
+	}
+	a.Reload(context.Background())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := a.AnnotateIP(tt.ip)
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("ASN.AnnotateIP() = %v", diff)
+			}
+		})
+	}
+}
@@ -22,6 +22,11 @@ type Maxmind struct {
 	mu      sync.RWMutex
 	src     content.Provider
 	Maxmind *geoip2.Reader
+
+	// anonSrc is the optional GeoIP2 Anonymous IP dataset, which flags
+	// hosting providers and proxies.
+	anonSrc content.Provider
+	anon    *geoip2.Reader
 }
 
 // NewMaxmind creates a new Maxmind instance which loads data from the given
@@ -45,6 +50,37 @@ func (mm *Maxmind) City(ip net.IP) (*geoip2.City, error) {
 	return record, nil
 }
 
+// SetAnonymousIP loads the GeoIP2 Anonymous IP dataset from the given
+// content.Provider on every Reload.
+func (mm *Maxmind) SetAnonymousIP(src content.Provider) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.anonSrc = src
+}
+
+// AnonymousIP searches for the hosting provider and proxy flags of the given
+// IP. Without an Anonymous IP dataset, no IPs are flagged.
+func (mm *Maxmind) AnonymousIP(ip net.IP) (*geoip2.AnonymousIP, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	if mm.anon == nil {
+		return &geoip2.AnonymousIP{}, nil
+	}
+	return mm.anon.AnonymousIP(ip)
+}
+
+// Len returns the number of nodes of the search tree of the city database in
+// use, which grows with the number of networks it covers. It is zero until
+// the database is loaded.
+func (mm *Maxmind) Len() int {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	if mm.Maxmind == nil {
+		return 0
+	}
+	return int(mm.Maxmind.Metadata().NodeCount)
+}
+
 func isEmpty(r *geoip2.City) bool {
 	// The record has no associated city, country, or continent.
 	return r.City.GeoNameID == 0 && r.Country.GeoNameID == 0 && r.Continent.GeoNameID == 0
@@ -53,25 +89,46 @@ func isEmpty(r *geoip2.City) bool {
 // Reload is intended to be called regularly to update the local dataset with
 // newer information from the provider.
 func (mm *Maxmind) Reload(ctx context.Context) error {
-	tgz, err := mm.src.Get(ctx)
-	if err == content.ErrNoChange {
-		return nil
-	}
+	city, err := load(ctx, mm.src, "GeoLite2-City.mmdb")
 	if err != nil {
 		return err
 	}
-	data, err := tarreader.FromTarGZ(tgz, "GeoLite2-City.mmdb")
-	if err != nil {
-		return err
+	// Don't acquire the lock until after the data is in RAM.
+	mm.mu.Lock()
+	if city != nil {
+		mm.Maxmind = city
 	}
-	// Parse the raw data.
-	mmtmp, err := geoip2.FromBytes(data)
+	anonSrc := mm.anonSrc
+	mm.mu.Unlock()
+	if anonSrc == nil {
+		return nil
+	}
+	anon, err := load(ctx, anonSrc, "GeoIP2-Anonymous-IP.mmdb")
 	if err != nil {
 		return err
 	}
-	// Don't acquire the lock until after the data is in RAM.
 	mm.mu.Lock()
 	defer mm.mu.Unlock()
-	mm.Maxmind = mmtmp
+	if anon != nil {
+		mm.anon = anon
+	}
 	return nil
 }
+
+// load reads the named database from the tar.gz archive of the provider. It
+// returns nil if the archive is unchanged.
+func load(ctx context.Context, src content.Provider, name string) (*geoip2.Reader, error) {
+	tgz, err := src.Get(ctx)
+	if err == content.ErrNoChange {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := tarreader.FromTarGZ(tgz, name)
+	if err != nil {
+		return nil, err
+	}
+	// Parse the raw data.
+	return geoip2.FromBytes(data)
+}
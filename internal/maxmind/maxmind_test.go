@@ -53,7 +53,9 @@ func TestMaxmind_Reload(t *testing.T) {
 				return
 			}
 			mm.Reload(context.Background()) // no change.
-
+			if mm.Len() == 0 {
+				t.Errorf("Maxmind.Len() = 0 after Reload()")
+			}
 		})
 	}
 }
@@ -115,3 +117,68 @@ func TestMaxmind_City(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxmind_AnonymousIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		anon        string
+		ip          net.IP
+		wantHosting bool
+		wantLoadErr bool
+	}{
+		{
+			name:        "success-hosting",
+			anon:        "file:testdata/fake-anonymous-ip.tar.gz",
+			ip:          net.ParseIP("192.0.2.10"),
+			wantHosting: true,
+		},
+		{
+			name: "success-not-flagged",
+			anon: "file:testdata/fake-anonymous-ip.tar.gz",
+			ip:   net.ParseIP("198.51.100.1"),
+		},
+		{
+			name: "success-no-dataset",
+			ip:   net.ParseIP("192.0.2.10"),
+		},
+		{
+			name:        "error-missing-database",
+			anon:        "file:testdata/fake-geolite2.tar.gz",
+			wantLoadErr: true,
+		},
+		{
+			name:        "error-url",
+			anon:        "file:testdata/file-does-not-exist.tar.gz",
+			wantLoadErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := url.Parse("file:testdata/fake-geolite2.tar.gz")
+			testingx.Must(t, err, "failed to parse url")
+			src, err := content.FromURL(context.Background(), p)
+			testingx.Must(t, err, "failed to get url")
+			mm := NewMaxmind(src)
+			if tt.anon != "" {
+				p, err := url.Parse(tt.anon)
+				testingx.Must(t, err, "failed to parse url")
+				anon, err := content.FromURL(context.Background(), p)
+				testingx.Must(t, err, "failed to get url")
+				mm.SetAnonymousIP(anon)
+			}
+			err = mm.Reload(context.Background())
+			if (err != nil) != tt.wantLoadErr {
+				t.Fatalf("Maxmind.Reload() error = %v, wantErr %v", err, tt.wantLoadErr)
+			}
+			if tt.wantLoadErr {
+				return
+			}
+
+			got, err := mm.AnonymousIP(tt.ip)
+			testingx.Must(t, err, "failed to look up ip")
+			if got.IsHostingProvider != tt.wantHosting || got.IsAnonymous != tt.wantHosting {
+				t.Errorf("Maxmind.AnonymousIP() = %#v, want hosting %v", got, tt.wantHosting)
+			}
+		})
+	}
+}
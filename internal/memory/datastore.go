@@ -0,0 +1,360 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/provision"
+	"github.com/m-lab/autojoin/internal/signup"
+	"github.com/m-lab/autojoin/internal/tracker"
+)
+
+// Datastore is an in-memory Datastore client. Entities are saved as properties,
+// like the Datastore client, so that entities are loaded with the same rules.
+type Datastore struct {
+	mu       sync.Mutex
+	entities map[string]entity
+	nextID   int64
+}
+
+type entity struct {
+	key   *datastore.Key
+	props []datastore.Property
+}
+
+// NewDatastore creates a new empty Datastore.
+func NewDatastore() *Datastore {
+	return &Datastore{entities: map[string]entity{}}
+}
+
+func save(src interface{}) ([]datastore.Property, error) {
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		return pls.Save()
+	}
+	return datastore.SaveStruct(src)
+}
+
+func load(dst interface{}, props []datastore.Property) error {
+	if pls, ok := dst.(datastore.PropertyLoadSaver); ok {
+		return pls.Load(props)
+	}
+	return datastore.LoadStruct(dst, props)
+}
+
+func (d *Datastore) get(key *datastore.Key, dst interface{}) error {
+	e, ok := d.entities[key.Encode()]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	return load(dst, e.props)
+}
+
+func (d *Datastore) put(key *datastore.Key, props []datastore.Property) *datastore.Key {
+	if key.Incomplete() {
+		d.nextID++
+		k := *key
+		k.ID = d.nextID
+		key = &k
+	}
+	d.entities[key.Encode()] = entity{key: key, props: props}
+	return key
+}
+
+func (d *Datastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.get(key, dst)
+}
+
+// Put saves the entity. Incomplete keys are assigned a new ID.
+func (d *Datastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	props, err := save(src)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.put(key, props), nil
+}
+
+// Delete deletes the entity. Like the Datastore client, deleting a missing
+// entity is not an error.
+func (d *Datastore) Delete(ctx context.Context, key *datastore.Key) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entities, key.Encode())
+	return nil
+}
+
+// GetAll appends the entities matching the kind, namespace, ancestor and
+// property filters of q to dst, which must be a pointer to a slice of structs,
+// struct pointers or PropertyLists. Orders and limits of q are ignored.
+func (d *Datastore) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	m, err := newMatcher(q)
+	if err != nil {
+		return nil, err
+	}
+	sv := reflect.ValueOf(dst)
+	if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("memory: GetAll dst must be a pointer to a slice, got %T", dst)
+	}
+	sv = sv.Elem()
+	et := sv.Type().Elem()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := []string{}
+	for name, e := range d.entities {
+		if m.matches(e) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return d.entities[names[i]].key.String() < d.entities[names[j]].key.String()
+	})
+	keys := []*datastore.Key{}
+	for _, name := range names {
+		e := d.entities[name]
+		var ev reflect.Value
+		if et.Kind() == reflect.Ptr {
+			ev = reflect.New(et.Elem())
+			err = load(ev.Interface(), e.props)
+		} else {
+			ev = reflect.New(et)
+			err = load(ev.Interface(), e.props)
+			ev = ev.Elem()
+		}
+		if err != nil {
+			return nil, err
+		}
+		sv.Set(reflect.Append(sv, ev))
+		keys = append(keys, e.key)
+	}
+	return keys, nil
+}
+
+// RunInTransaction runs f in a transaction. Transactions are serialized, and
+// the entities put by f are only saved if f returns nil.
+func (d *Datastore) RunInTransaction(ctx context.Context, f func(tx *Transaction) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tx := &Transaction{d: d, pending: map[string]entity{}}
+	if err := f(tx); err != nil {
+		return err
+	}
+	for _, e := range tx.pending {
+		d.put(e.key, e.props)
+	}
+	return nil
+}
+
+// Transaction is a transaction of a Datastore.
+type Transaction struct {
+	d       *Datastore
+	pending map[string]entity
+}
+
+// Get loads the entity, including the entities put in the transaction.
+func (tx *Transaction) Get(key *datastore.Key, dst interface{}) error {
+	if e, ok := tx.pending[key.Encode()]; ok {
+		return load(dst, e.props)
+	}
+	return tx.d.get(key, dst)
+}
+
+// Put saves the entity when the transaction commits. Keys must be complete.
+func (tx *Transaction) Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error) {
+	if key.Incomplete() {
+		return nil, fmt.Errorf("memory: transactions do not support incomplete keys")
+	}
+	props, err := save(src)
+	if err != nil {
+		return nil, err
+	}
+	tx.pending[key.Encode()] = entity{key: key, props: props}
+	return &datastore.PendingKey{}, nil
+}
+
+// Signups returns d as the Datastore of organization applications.
+func (d *Datastore) Signups() signup.Datastore {
+	return &signups{d}
+}
+
+type signups struct {
+	*Datastore
+}
+
+func (s *signups) RunInTransaction(ctx context.Context, f func(tx signup.Transaction) error) error {
+	return s.Datastore.RunInTransaction(ctx, func(tx *Transaction) error {
+		return f(tx)
+	})
+}
+
+// Tokens returns d as the Datastore of provisioning tokens.
+func (d *Datastore) Tokens() provision.Datastore {
+	return &tokens{d}
+}
+
+type tokens struct {
+	*Datastore
+}
+
+func (t *tokens) RunInTransaction(ctx context.Context, f func(tx provision.Transaction) error) error {
+	return t.Datastore.RunInTransaction(ctx, func(tx *Transaction) error {
+		return f(tx)
+	})
+}
+
+// Tracker returns d as the Datastore of tracker entries.
+func (d *Datastore) Tracker() tracker.Datastore {
+	return &trackerEntries{d}
+}
+
+type trackerEntries struct {
+	*Datastore
+}
+
+func (t *trackerEntries) RunInTransaction(ctx context.Context, f func(tx tracker.Transaction) error) error {
+	return t.Datastore.RunInTransaction(ctx, func(tx *Transaction) error {
+		return f(tx)
+	})
+}
+
+// matcher matches entities with a query. The fields of datastore.Query are
+// not exported, so they are read with reflection.
+type matcher struct {
+	kind      string
+	namespace string
+	ancestor  *datastore.Key
+	filters   []filter
+}
+
+type filter struct {
+	name  string
+	op    string
+	value reflect.Value
+}
+
+// operators report whether the result of comparing a property with the value
+// of a filter matches the filter.
+var operators = map[string]func(c int) bool{
+	"=":  func(c int) bool { return c == 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+}
+
+func newMatcher(q *datastore.Query) (*matcher, error) {
+	v := reflect.ValueOf(q).Elem()
+	m := &matcher{
+		kind:      v.FieldByName("kind").String(),
+		namespace: v.FieldByName("namespace").String(),
+		ancestor:  readKey(v.FieldByName("ancestor")),
+	}
+	fv := v.FieldByName("filter")
+	for i := 0; i < fv.Len(); i++ {
+		f := fv.Index(i).Elem()
+		if f.Type() != reflect.TypeOf(datastore.PropertyFilter{}) {
+			return nil, fmt.Errorf("memory: unsupported filter %s", f.Type())
+		}
+		op := f.FieldByName("Operator").String()
+		if _, ok := operators[op]; !ok {
+			return nil, fmt.Errorf("memory: unsupported filter operator %q", op)
+		}
+		m.filters = append(m.filters, filter{
+			name:  f.FieldByName("FieldName").String(),
+			op:    op,
+			value: f.FieldByName("Value").Elem(),
+		})
+	}
+	return m, nil
+}
+
+// readKey copies the key of the pointer value v, which may not be exported.
+func readKey(v reflect.Value) *datastore.Key {
+	if v.IsNil() {
+		return nil
+	}
+	v = v.Elem()
+	return &datastore.Key{
+		Kind:      v.FieldByName("Kind").String(),
+		ID:        v.FieldByName("ID").Int(),
+		Name:      v.FieldByName("Name").String(),
+		Parent:    readKey(v.FieldByName("Parent")),
+		Namespace: v.FieldByName("Namespace").String(),
+	}
+}
+
+func (m *matcher) matches(e entity) bool {
+	if e.key.Kind != m.kind || e.key.Namespace != m.namespace {
+		return false
+	}
+	if m.ancestor != nil {
+		found := false
+		for k := e.key; k != nil && !found; k = k.Parent {
+			found = k.Equal(m.ancestor)
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, f := range m.filters {
+		if !hasProperty(e.props, f) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasProperty(props []datastore.Property, f filter) bool {
+	for _, p := range props {
+		if p.Name != f.name {
+			continue
+		}
+		c, ok := compare(reflect.ValueOf(p.Value), f.value)
+		return ok && operators[f.op](c)
+	}
+	return false
+}
+
+// compare compares the property value pv with the filter value fv. Values of
+// different types are not comparable, except nil values, which are equal.
+func compare(pv, fv reflect.Value) (int, bool) {
+	switch {
+	case !pv.IsValid() || !fv.IsValid():
+		return 0, pv.IsValid() == fv.IsValid()
+	case pv.Kind() == reflect.String && fv.Kind() == reflect.String:
+		return strings.Compare(pv.String(), fv.String()), true
+	case pv.CanInt() && fv.CanInt():
+		return order(pv.Int(), fv.Int()), true
+	case pv.Kind() == reflect.Bool && fv.Kind() == reflect.Bool:
+		return order(boolInt(pv.Bool()), boolInt(fv.Bool())), true
+	case pv.CanFloat() && fv.CanFloat():
+		return order(pv.Float(), fv.Float()), true
+	}
+	return 0, false
+}
+
+func order[T int | int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// boolInt orders false before true, like Datastore.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/provision"
+	"github.com/m-lab/autojoin/internal/signup"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/locate/memorystore"
+)
+
+func TestDatastore(t *testing.T) {
+	ctx := context.Background()
+	d := NewDatastore()
+	k := datastore.NameKey("Test", "a", nil)
+	k.Namespace = "test"
+
+	err := d.Get(ctx, k, &datastore.PropertyList{})
+	if !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Fatalf("Get() missing entity error = %v, want ErrNoSuchEntity", err)
+	}
+	type entity struct {
+		Value string
+		Count int64
+	}
+	if _, err := d.Put(ctx, k, &entity{Value: "x", Count: 2}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	got := &entity{}
+	if err := d.Get(ctx, k, got); err != nil || got.Value != "x" || got.Count != 2 {
+		t.Errorf("Get() = %+v, %v, want {x 2}", got, err)
+	}
+
+	// Incomplete keys are assigned IDs.
+	k1, err := d.Put(ctx, datastore.IncompleteKey("Test", nil), &entity{})
+	if err != nil || k1.Incomplete() {
+		t.Errorf("Put(incomplete) = %v, %v, want complete key", k1, err)
+	}
+
+	if err := d.Delete(ctx, k); err != nil {
+		t.Errorf("Delete() failed: %v", err)
+	}
+	if err := d.Get(ctx, k, got); !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Errorf("Get() deleted entity error = %v, want ErrNoSuchEntity", err)
+	}
+
+	// Inequality filters compare values of the same type.
+	for i, v := range []string{"a", "b", "c"} {
+		if _, err := d.Put(ctx, datastore.IDKey("Test", int64(i+10), nil), &entity{Value: v, Count: int64(i)}); err != nil {
+			t.Fatalf("Put() failed: %v", err)
+		}
+	}
+	l := []entity{}
+	q := datastore.NewQuery("Test").FilterField("Count", ">", 0).FilterField("Value", "<=", "b")
+	if _, err := d.GetAll(ctx, q, &l); err != nil || len(l) != 1 || l[0].Value != "b" {
+		t.Errorf("GetAll() with inequality filters = %+v, %v, want b", l, err)
+	}
+	q = datastore.NewQuery("Test").FilterField("Count", "!=", 1)
+	if _, err := d.GetAll(ctx, q, &[]entity{}); err == nil {
+		t.Errorf("GetAll() with unsupported filter returned nil error")
+	}
+	if _, err := d.GetAll(ctx, datastore.NewQuery("Test"), []entity{}); err == nil {
+		t.Errorf("GetAll() with slice dst returned nil error")
+	}
+}
+
+func TestDatastore_Stores(t *testing.T) {
+	ctx := context.Background()
+	d := NewDatastore()
+
+	// Keys are filtered by org.
+	ks := keys.NewStore(d, "test")
+	for id, org := range map[string]string{"k1": "foo", "k2": "bar", "k3": "foo"} {
+		if err := ks.Put(ctx, id, &keys.Key{Org: org}); err != nil {
+			t.Fatalf("keys Put() failed: %v", err)
+		}
+	}
+	l, err := ks.List(ctx, "foo")
+	if err != nil || len(l) != 2 || l[0].ID != "k1" || l[1].ID != "k3" {
+		t.Errorf("keys List(foo) = %+v, %v, want k1 and k3", l, err)
+	}
+	// Other namespaces are not visible.
+	if l, err := keys.NewStore(d, "other").List(ctx, "foo"); err != nil || len(l) != 0 {
+		t.Errorf("keys List(foo) in other namespace = %+v, %v, want none", l, err)
+	}
+
+	// History is filtered by ancestor.
+	h := orgs.NewHistory(d, "test")
+	now := time.Now().UTC()
+	h.Add(ctx, "foo", orgs.MultiplierChange{Old: 1, New: 2, Time: now})
+	h.Add(ctx, "foo", orgs.MultiplierChange{Old: 2, New: 3, Time: now.Add(time.Second)})
+	h.Add(ctx, "bar", orgs.MultiplierChange{Old: 1, New: 0, Time: now})
+	changes, err := h.List(ctx, "foo")
+	if err != nil || len(changes) != 2 || changes[0].New != 3 {
+		t.Errorf("history List(foo) = %+v, %v, want 2 changes", changes, err)
+	}
+
+	// Applications are changed in transactions and filtered by status.
+	ss := signup.NewStore(d.Signups(), "test")
+	for _, org := range []string{"foo", "bar"} {
+		if err := ss.Submit(ctx, &signup.Application{Org: org}); err != nil {
+			t.Fatalf("signup Submit(%s) failed: %v", org, err)
+		}
+	}
+	if err := ss.Submit(ctx, &signup.Application{Org: "foo"}); !errors.Is(err, signup.ErrExists) {
+		t.Errorf("signup Submit(foo) again error = %v, want ErrExists", err)
+	}
+	if _, err := ss.Review(ctx, "bar", signup.StatusApproved, "admin", ""); err != nil {
+		t.Fatalf("signup Review() failed: %v", err)
+	}
+	apps, err := ss.List(ctx, signup.StatusPending)
+	if err != nil || len(apps) != 1 || apps[0].Org != "foo" {
+		t.Errorf("signup List(pending) = %+v, %v, want foo", apps, err)
+	}
+
+	// Tokens are redeemed once.
+	ps := provision.NewStore(d.Tokens(), "test")
+	token, err := ps.Mint(ctx, "foo", time.Hour)
+	if err != nil {
+		t.Fatalf("provision Mint() failed: %v", err)
+	}
+	if org, err := ps.Redeem(ctx, token); err != nil || org != "foo" {
+		t.Errorf("provision Redeem() = %q, %v, want foo", org, err)
+	}
+	if _, err := ps.Redeem(ctx, token); !errors.Is(err, provision.ErrInvalidToken) {
+		t.Errorf("provision Redeem() again error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestDatastore_RunInTransaction(t *testing.T) {
+	ctx := context.Background()
+	d := NewDatastore()
+	k := datastore.NameKey("Test", "a", nil)
+	props := &datastore.PropertyList{{Name: "Value", Value: "x"}}
+	errFake := errors.New("fake error")
+	err := d.RunInTransaction(ctx, func(tx *Transaction) error {
+		if _, err := tx.Put(k, props); err != nil {
+			return err
+		}
+		if err := tx.Get(k, &datastore.PropertyList{}); err != nil {
+			return err
+		}
+		return errFake
+	})
+	if err != errFake {
+		t.Errorf("RunInTransaction() error = %v, want %v", err, errFake)
+	}
+	if err := d.Get(ctx, k, &datastore.PropertyList{}); !errors.Is(err, datastore.ErrNoSuchEntity) {
+		t.Errorf("Get() after failed transaction error = %v, want ErrNoSuchEntity", err)
+	}
+	err = d.RunInTransaction(ctx, func(tx *Transaction) error {
+		_, err := tx.Put(datastore.IncompleteKey("Test", nil), props)
+		return err
+	})
+	if err == nil {
+		t.Errorf("RunInTransaction() with incomplete key returned nil error")
+	}
+}
+
+func TestDatastore_Tracker(t *testing.T) {
+	ctx := context.Background()
+	d := NewDatastore()
+	c := tracker.NewDatastoreClient(d.Tracker(), "test", time.Hour)
+
+	w := &tracker.Window{Start: 1, End: 2}
+	err := c.Put("foo", "Maintenance", w, &memorystore.PutOptions{FieldMustExist: "DNS"})
+	if !errors.Is(err, tracker.ErrNotFound) {
+		t.Errorf("Put() without required field error = %v, want ErrNotFound", err)
+	}
+	r := &tracker.DNSRecord{LastUpdate: 10, Ports: []string{"9990"}}
+	if err := c.Put("foo", "DNS", r, &memorystore.PutOptions{}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := c.Put("foo", "Maintenance", w, &memorystore.PutOptions{FieldMustExist: "DNS"}); err != nil {
+		t.Fatalf("Put() with required field failed: %v", err)
+	}
+	all, err := c.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll() failed: %v", err)
+	}
+	got := all["foo"]
+	if len(all) != 1 || got.DNS == nil || got.DNS.Ports[0] != "9990" || got.Maintenance == nil || got.Maintenance.End != 2 {
+		t.Errorf("GetAll() = %+v, want foo with DNS and Maintenance", all)
+	}
+	if got, err := c.Get("foo"); err != nil || got.DNS == nil || got.Maintenance == nil {
+		t.Errorf("Get() = %+v, %v, want DNS and Maintenance", got, err)
+	}
+	if got, err := c.Get("unknown"); err != nil || got.DNS != nil {
+		t.Errorf("Get() of unknown entry = %+v, %v, want zero value", got, err)
+	}
+
+	// Expired entries are hidden and deleted.
+	expired := tracker.NewDatastoreClient(d.Tracker(), "test", -time.Minute)
+	if err := expired.Put("bar", "DNS", r, &memorystore.PutOptions{}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if all, err := c.GetAll(); err != nil || len(all) != 1 {
+		t.Errorf("GetAll() with expired entry = %+v, %v, want foo", all, err)
+	}
+	if got, err := c.Get("bar"); err != nil || got.DNS != nil {
+		t.Errorf("Get() of expired entry = %+v, %v, want zero value", got, err)
+	}
+	if n, err := c.Expire(ctx); err != nil || n != 1 {
+		t.Errorf("Expire() = %d, %v, want 1", n, err)
+	}
+	if n, err := c.Expire(ctx); err != nil || n != 0 {
+		t.Errorf("Expire() again = %d, %v, want 0", n, err)
+	}
+
+	if err := c.Del("foo"); err != nil {
+		t.Fatalf("Del() failed: %v", err)
+	}
+	if all, err := c.GetAll(); err != nil || len(all) != 0 {
+		t.Errorf("GetAll() after Del = %+v, %v, want none", all, err)
+	}
+}
@@ -0,0 +1,689 @@
+// Package memory implements in-memory fakes of the Google Cloud clients used by
+// the Autojoin API, so that the server can be run and tested locally without a
+// project, e.g. with the -dev flag.
+//
+// The fakes keep all state in memory and return the errors of the real APIs
+// for missing and existing resources, so that callers take the same paths as
+// in production. Credentials, keys and tokens returned by the fakes are random
+// and cannot be used to authenticate with Google Cloud.
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/apikeys/apiv2/apikeyspb"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// httpError returns the error of a REST API, e.g. Cloud DNS or IAM.
+func httpError(code int, format string, args ...interface{}) error {
+	err, _ := apierror.FromError(&googleapi.Error{Code: code, Message: fmt.Sprintf(format, args...)})
+	return err
+}
+
+// grpcError returns the error of a gRPC API, e.g. Secret Manager or API Keys.
+func grpcError(code codes.Code, format string, args ...interface{}) error {
+	err, _ := apierror.FromError(status.Errorf(code, format, args...))
+	return err
+}
+
+// randomID returns a random hex string of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// DNS is an in-memory Cloud DNS service.
+type DNS struct {
+	mu    sync.Mutex
+	zones map[string]*zone
+}
+
+type zone struct {
+	zone    *dns.ManagedZone
+	records map[string]*dns.ResourceRecordSet
+	changes map[string]*dns.Change
+	keys    []*dns.DnsKey
+}
+
+func recordKey(name, rtype string) string {
+	return name + "/" + rtype
+}
+
+// NewDNS creates a new DNS without zones.
+func NewDNS() *DNS {
+	return &DNS{zones: map[string]*zone{}}
+}
+
+func (d *DNS) ResourceRecordSetsGet(ctx context.Context, project string, zoneName string, name string, rtype string) (*dns.ResourceRecordSet, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	rr, ok := z.records[recordKey(name, rtype)]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "record %s %s not found", name, rtype)
+	}
+	return rr, nil
+}
+
+// ChangeCreate applies the change atomically. Like Cloud DNS, deletions must
+// match existing records exactly, and additions must not replace records.
+func (d *DNS) ChangeCreate(ctx context.Context, project string, zoneName string, change *dns.Change) (*dns.Change, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		return nil, httpError(http.StatusBadRequest, "change has no additions or deletions")
+	}
+	deleted := map[string]bool{}
+	for _, rr := range change.Deletions {
+		k := recordKey(rr.Name, rr.Type)
+		curr, ok := z.records[k]
+		if !ok {
+			return nil, httpError(http.StatusNotFound, "record %s %s not found", rr.Name, rr.Type)
+		}
+		if strings.Join(curr.Rrdatas, ",") != strings.Join(rr.Rrdatas, ",") {
+			return nil, httpError(http.StatusPreconditionFailed, "record %s %s does not match", rr.Name, rr.Type)
+		}
+		deleted[k] = true
+	}
+	for _, rr := range change.Additions {
+		k := recordKey(rr.Name, rr.Type)
+		if _, ok := z.records[k]; ok && !deleted[k] {
+			return nil, httpError(http.StatusConflict, "record %s %s already exists", rr.Name, rr.Type)
+		}
+	}
+	for k := range deleted {
+		delete(z.records, k)
+	}
+	for _, rr := range change.Additions {
+		z.records[recordKey(rr.Name, rr.Type)] = rr
+	}
+	// Changes are applied immediately, so they are always done.
+	chg := &dns.Change{
+		Additions: change.Additions,
+		Deletions: change.Deletions,
+		Id:        randomID(8),
+		Status:    "done",
+		StartTime: time.Now().UTC().Format(time.RFC3339),
+	}
+	z.changes[chg.Id] = chg
+	return chg, nil
+}
+
+// ChangeGet returns a change created by ChangeCreate.
+func (d *DNS) ChangeGet(ctx context.Context, project string, zoneName string, id string) (*dns.Change, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	chg, ok := z.changes[id]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "change %s not found", id)
+	}
+	return chg, nil
+}
+
+func (d *DNS) GetManagedZone(ctx context.Context, project, zoneName string) (*dns.ManagedZone, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	return z.zone, nil
+}
+
+// CreateManagedZone creates the zone with its NS and SOA records.
+func (d *DNS) CreateManagedZone(ctx context.Context, project string, mz *dns.ManagedZone) (*dns.ManagedZone, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.zones[mz.Name]; ok {
+		return nil, httpError(http.StatusConflict, "zone %s already exists", mz.Name)
+	}
+	created := *mz
+	created.NameServers = []string{"ns1.localhost.", "ns2.localhost."}
+	created.CreationTime = time.Now().UTC().Format(time.RFC3339)
+	z := &zone{zone: &created, records: map[string]*dns.ResourceRecordSet{}, changes: map[string]*dns.Change{}}
+	z.records[recordKey(mz.DnsName, "NS")] = &dns.ResourceRecordSet{
+		Name: mz.DnsName, Type: "NS", Ttl: 21600, Rrdatas: created.NameServers,
+	}
+	z.records[recordKey(mz.DnsName, "SOA")] = &dns.ResourceRecordSet{
+		Name: mz.DnsName, Type: "SOA", Ttl: 21600, Rrdatas: []string{"ns1.localhost. hostmaster.localhost. 1 21600 3600 259200 300"},
+	}
+	if mz.DnssecConfig != nil && mz.DnssecConfig.State == "on" {
+		// Like Cloud DNS, zones with DNSSEC have a key signing key.
+		z.keys = []*dns.DnsKey{{
+			Id:        randomID(4),
+			Type:      "keySigning",
+			IsActive:  true,
+			Algorithm: "rsasha256",
+			KeyTag:    int64(len(d.zones) + 1),
+			Digests:   []*dns.DnsKeyDigest{{Type: "sha256", Digest: randomID(32)}},
+		}}
+	}
+	d.zones[mz.Name] = z
+	return &created, nil
+}
+
+// DNSKeysList returns the DNSSEC keys of the zone.
+func (d *DNS) DNSKeysList(ctx context.Context, project string, zoneName string) ([]*dns.DnsKey, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	return z.keys, nil
+}
+
+// ManagedZonesList returns all zones sorted by name.
+func (d *DNS) ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	zones := []*dns.ManagedZone{}
+	for _, z := range d.zones {
+		zones = append(zones, z.zone)
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].Name < zones[j].Name
+	})
+	return zones, nil
+}
+
+// DeleteManagedZone deletes the zone. Like Cloud DNS, zones with records
+// other than their NS and SOA records cannot be deleted.
+func (d *DNS) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	for _, rr := range z.records {
+		if rr.Name != z.zone.DnsName || (rr.Type != "NS" && rr.Type != "SOA") {
+			return httpError(http.StatusBadRequest, "zone %s is not empty", zoneName)
+		}
+	}
+	delete(d.zones, zoneName)
+	return nil
+}
+
+// ResourceRecordSetsList returns the records of the zone sorted by name and
+// type.
+func (d *DNS) ResourceRecordSetsList(ctx context.Context, project string, zoneName string) ([]*dns.ResourceRecordSet, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	z, ok := d.zones[zoneName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "zone %s not found", zoneName)
+	}
+	keys := make([]string, 0, len(z.records))
+	for k := range z.records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rrs := make([]*dns.ResourceRecordSet, 0, len(keys))
+	for _, k := range keys {
+		rrs = append(rrs, z.records[k])
+	}
+	return rrs, nil
+}
+
+// ResourceRecordSetsByName returns the records of the zone with the given
+// name sorted by type.
+func (d *DNS) ResourceRecordSetsByName(ctx context.Context, project string, zoneName string, name string) ([]*dns.ResourceRecordSet, error) {
+	rrs, err := d.ResourceRecordSetsList(ctx, project, zoneName)
+	if err != nil {
+		return nil, err
+	}
+	named := []*dns.ResourceRecordSet{}
+	for _, rr := range rrs {
+		if rr.Name == name {
+			named = append(named, rr)
+		}
+	}
+	return named, nil
+}
+
+// IAM is an in-memory IAM service for service accounts and their keys.
+type IAM struct {
+	mu       sync.Mutex
+	accounts map[string]*iam.ServiceAccount
+	keys     map[string][]*iam.ServiceAccountKey
+}
+
+// NewIAM creates a new IAM without service accounts.
+func NewIAM() *IAM {
+	return &IAM{
+		accounts: map[string]*iam.ServiceAccount{},
+		keys:     map[string][]*iam.ServiceAccountKey{},
+	}
+}
+
+func (i *IAM) GetServiceAccount(ctx context.Context, saName string) (*iam.ServiceAccount, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	sa, ok := i.accounts[saName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "service account %s not found", saName)
+	}
+	return sa, nil
+}
+
+func (i *IAM) CreateServiceAccount(ctx context.Context, projName string, req *iam.CreateServiceAccountRequest) (*iam.ServiceAccount, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	email := req.AccountId + "@" + strings.TrimPrefix(projName, "projects/") + ".iam.gserviceaccount.com"
+	name := projName + "/serviceAccounts/" + email
+	if _, ok := i.accounts[name]; ok {
+		return nil, httpError(http.StatusConflict, "service account %s already exists", name)
+	}
+	sa := &iam.ServiceAccount{
+		Name:     name,
+		Email:    email,
+		UniqueId: strconv.Itoa(len(i.accounts) + 1),
+	}
+	if req.ServiceAccount != nil {
+		sa.Description = req.ServiceAccount.Description
+		sa.DisplayName = req.ServiceAccount.DisplayName
+	}
+	i.accounts[name] = sa
+	return sa, nil
+}
+
+// CreateKey returns a new key with credentials of the service account that
+// contain a random private key ID, but no private key.
+func (i *IAM) CreateKey(ctx context.Context, saName string, req *iam.CreateServiceAccountKeyRequest) (*iam.ServiceAccountKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	sa, ok := i.accounts[saName]
+	if !ok {
+		return nil, httpError(http.StatusNotFound, "service account %s not found", saName)
+	}
+	id := randomID(20)
+	creds, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   sa.Email,
+		"client_id":      sa.UniqueId,
+		"private_key_id": id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	k := &iam.ServiceAccountKey{
+		Name:           saName + "/keys/" + id,
+		KeyAlgorithm:   req.KeyAlgorithm,
+		KeyType:        "USER_MANAGED",
+		PrivateKeyType: req.PrivateKeyType,
+		PrivateKeyData: base64.StdEncoding.EncodeToString(creds),
+		ValidAfterTime: time.Now().UTC().Format(time.RFC3339),
+	}
+	i.keys[saName] = append(i.keys[saName], k)
+	return k, nil
+}
+
+func (i *IAM) DeleteKey(ctx context.Context, keyName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	saName := keyName[:strings.LastIndex(keyName, "/keys/")+1]
+	saName = strings.TrimSuffix(saName, "/")
+	for n, k := range i.keys[saName] {
+		if k.Name == keyName {
+			i.keys[saName] = append(i.keys[saName][:n], i.keys[saName][n+1:]...)
+			return nil
+		}
+	}
+	return httpError(http.StatusNotFound, "key %s not found", keyName)
+}
+
+// ListKeys returns the keys of the service account without their private key
+// data.
+func (i *IAM) ListKeys(ctx context.Context, saName string) ([]*iam.ServiceAccountKey, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.accounts[saName]; !ok {
+		return nil, httpError(http.StatusNotFound, "service account %s not found", saName)
+	}
+	keys := []*iam.ServiceAccountKey{}
+	for _, k := range i.keys[saName] {
+		c := *k
+		c.PrivateKeyData = ""
+		keys = append(keys, &c)
+	}
+	return keys, nil
+}
+
+// ListServiceAccounts returns the service accounts of the project sorted by
+// name.
+func (i *IAM) ListServiceAccounts(ctx context.Context, projName string) ([]*iam.ServiceAccount, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	accounts := []*iam.ServiceAccount{}
+	for name, sa := range i.accounts {
+		if strings.HasPrefix(name, projName+"/serviceAccounts/") {
+			accounts = append(accounts, sa)
+		}
+	}
+	sort.Slice(accounts, func(a, b int) bool {
+		return accounts[a].Name < accounts[b].Name
+	})
+	return accounts, nil
+}
+
+func (i *IAM) DeleteServiceAccount(ctx context.Context, saName string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.accounts[saName]; !ok {
+		return httpError(http.StatusNotFound, "service account %s not found", saName)
+	}
+	delete(i.accounts, saName)
+	delete(i.keys, saName)
+	return nil
+}
+
+// Credentials is an in-memory IAM credentials service.
+type Credentials struct {
+	iam *IAM
+}
+
+// NewCredentials creates a new Credentials issuing access tokens for the
+// service accounts of i.
+func NewCredentials(i *IAM) *Credentials {
+	return &Credentials{iam: i}
+}
+
+// GenerateAccessToken returns a random access token for the service account.
+// The project of the name is ignored, e.g. "projects/-/serviceAccounts/<email>".
+func (c *Credentials) GenerateAccessToken(ctx context.Context, saName string, req *iamcredentials.GenerateAccessTokenRequest) (*iamcredentials.GenerateAccessTokenResponse, error) {
+	email := saName[strings.LastIndex(saName, "/")+1:]
+	c.iam.mu.Lock()
+	found := false
+	for _, sa := range c.iam.accounts {
+		found = found || sa.Email == email
+	}
+	c.iam.mu.Unlock()
+	if !found {
+		return nil, httpError(http.StatusNotFound, "service account %s not found", email)
+	}
+	lifetime, err := time.ParseDuration(req.Lifetime)
+	if err != nil || req.Lifetime == "" {
+		lifetime = time.Hour
+	}
+	return &iamcredentials.GenerateAccessTokenResponse{
+		AccessToken: "dev-" + randomID(16),
+		ExpireTime:  time.Now().Add(lifetime).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// SecretManager is an in-memory Secret Manager client.
+type SecretManager struct {
+	mu      sync.Mutex
+	secrets map[string]*secret
+}
+
+type secret struct {
+	secret   *secretmanagerpb.Secret
+	versions []*secretmanagerpb.SecretVersion
+	data     [][]byte
+}
+
+// NewSecretManager creates a new SecretManager without secrets.
+func NewSecretManager() *SecretManager {
+	return &SecretManager{secrets: map[string]*secret{}}
+}
+
+// version returns the index of the named version, e.g.
+// "projects/p/secrets/s/versions/1". The "latest" version is the most recent
+// enabled version.
+func (s *SecretManager) version(name string) (*secret, int, error) {
+	secretName, v, _ := strings.Cut(name, "/versions/")
+	sec, ok := s.secrets[secretName]
+	if !ok {
+		return nil, 0, grpcError(codes.NotFound, "secret %s not found", secretName)
+	}
+	if v == "latest" {
+		for i := len(sec.versions) - 1; i >= 0; i-- {
+			if sec.versions[i].State == secretmanagerpb.SecretVersion_ENABLED {
+				return sec, i, nil
+			}
+		}
+		return nil, 0, grpcError(codes.NotFound, "secret %s has no enabled versions", secretName)
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 1 || i > len(sec.versions) {
+		return nil, 0, grpcError(codes.NotFound, "secret version %s not found", name)
+	}
+	return sec, i - 1, nil
+}
+
+func (s *SecretManager) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, ok := s.secrets[req.Name]
+	if !ok {
+		return nil, grpcError(codes.NotFound, "secret %s not found", req.Name)
+	}
+	return sec.secret, nil
+}
+
+func (s *SecretManager) CreateSecret(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := req.Parent + "/secrets/" + req.SecretId
+	if _, ok := s.secrets[name]; ok {
+		return nil, grpcError(codes.AlreadyExists, "secret %s already exists", name)
+	}
+	sec := &secretmanagerpb.Secret{
+		Name:        name,
+		Replication: req.GetSecret().GetReplication(),
+		CreateTime:  timestamppb.Now(),
+	}
+	s.secrets[name] = &secret{secret: sec}
+	return sec, nil
+}
+
+func (s *SecretManager) GetSecretVersion(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, i, err := s.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return sec.versions[i], nil
+}
+
+func (s *SecretManager) AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, ok := s.secrets[req.Parent]
+	if !ok {
+		return nil, grpcError(codes.NotFound, "secret %s not found", req.Parent)
+	}
+	v := &secretmanagerpb.SecretVersion{
+		Name:       fmt.Sprintf("%s/versions/%d", req.Parent, len(sec.versions)+1),
+		CreateTime: timestamppb.Now(),
+		State:      secretmanagerpb.SecretVersion_ENABLED,
+	}
+	sec.versions = append(sec.versions, v)
+	sec.data = append(sec.data, req.GetPayload().GetData())
+	return v, nil
+}
+
+// AccessSecretVersion returns the payload of an enabled version.
+func (s *SecretManager) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, i, err := s.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if sec.versions[i].State != secretmanagerpb.SecretVersion_ENABLED {
+		return nil, grpcError(codes.FailedPrecondition, "secret version %s is disabled", sec.versions[i].Name)
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    sec.versions[i].Name,
+		Payload: &secretmanagerpb.SecretPayload{Data: sec.data[i]},
+	}, nil
+}
+
+func (s *SecretManager) DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, i, err := s.version(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	sec.versions[i].State = secretmanagerpb.SecretVersion_DISABLED
+	return sec.versions[i], nil
+}
+
+func (s *SecretManager) DeleteSecret(ctx context.Context, req *secretmanagerpb.DeleteSecretRequest, opts ...gax.CallOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[req.Name]; !ok {
+		return grpcError(codes.NotFound, "secret %s not found", req.Name)
+	}
+	delete(s.secrets, req.Name)
+	return nil
+}
+
+// Keys is an in-memory API Keys client.
+type Keys struct {
+	mu      sync.Mutex
+	keys    map[string]*apikeyspb.Key
+	strings map[string]string
+}
+
+// NewKeys creates a new Keys without API keys.
+func NewKeys() *Keys {
+	return &Keys{keys: map[string]*apikeyspb.Key{}, strings: map[string]string{}}
+}
+
+func (k *Keys) GetKeyString(ctx context.Context, req *apikeyspb.GetKeyStringRequest, opts ...gax.CallOption) (*apikeyspb.GetKeyStringResponse, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, ok := k.keys[req.Name]
+	if !ok {
+		return nil, grpcError(codes.NotFound, "key %s not found", req.Name)
+	}
+	return &apikeyspb.GetKeyStringResponse{KeyString: key.KeyString}, nil
+}
+
+// CreateKey creates a key with a random key string.
+func (k *Keys) CreateKey(ctx context.Context, req *apikeyspb.CreateKeyRequest, opts ...gax.CallOption) (*apikeyspb.Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	name := req.Parent + "/keys/" + req.KeyId
+	if _, ok := k.keys[name]; ok {
+		return nil, grpcError(codes.AlreadyExists, "key %s already exists", name)
+	}
+	key := &apikeyspb.Key{
+		Name:         name,
+		DisplayName:  req.GetKey().GetDisplayName(),
+		KeyString:    "dev-" + randomID(16),
+		Restrictions: req.GetKey().GetRestrictions(),
+		CreateTime:   timestamppb.Now(),
+	}
+	k.keys[name] = key
+	k.strings[key.KeyString] = name
+	return key, nil
+}
+
+func (k *Keys) LookupKey(ctx context.Context, req *apikeyspb.LookupKeyRequest, opts ...gax.CallOption) (*apikeyspb.LookupKeyResponse, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	name, ok := k.strings[req.KeyString]
+	if !ok {
+		return nil, grpcError(codes.NotFound, "key not found")
+	}
+	return &apikeyspb.LookupKeyResponse{Name: name, Parent: name[:strings.LastIndex(name, "/keys/")]}, nil
+}
+
+func (k *Keys) DeleteKey(ctx context.Context, req *apikeyspb.DeleteKeyRequest, opts ...gax.CallOption) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, ok := k.keys[req.Name]
+	if !ok {
+		return grpcError(codes.NotFound, "key %s not found", req.Name)
+	}
+	delete(k.strings, key.KeyString)
+	delete(k.keys, req.Name)
+	return nil
+}
+
+// CRM is an in-memory Cloud Resource Manager client of a single project. The
+// caller has every permission on the project.
+type CRM struct {
+	mu      sync.Mutex
+	project string
+	policy  *cloudresourcemanager.Policy
+}
+
+// NewCRM creates a new CRM of the named project with an empty IAM policy.
+func NewCRM(project string) *CRM {
+	return &CRM{project: project, policy: &cloudresourcemanager.Policy{Version: 3}}
+}
+
+func (c *CRM) GetIamPolicy(ctx context.Context, req *cloudresourcemanager.GetIamPolicyRequest) (*cloudresourcemanager.Policy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := *c.policy
+	p.Bindings = append([]*cloudresourcemanager.Binding{}, c.policy.Bindings...)
+	return &p, nil
+}
+
+func (c *CRM) SetIamPolicy(ctx context.Context, req *cloudresourcemanager.SetIamPolicyRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.Policy.Etag != c.policy.Etag {
+		return httpError(http.StatusConflict, "policy of %s was concurrently modified", c.project)
+	}
+	p := *req.Policy
+	p.Etag = randomID(8)
+	c.policy = &p
+	return nil
+}
+
+func (c *CRM) GetProject(ctx context.Context) (*cloudresourcemanager.Project, error) {
+	return &cloudresourcemanager.Project{
+		ProjectId:      c.project,
+		Name:           c.project,
+		ProjectNumber:  1,
+		LifecycleState: "ACTIVE",
+	}, nil
+}
+
+func (c *CRM) TestIamPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	return permissions, nil
+}
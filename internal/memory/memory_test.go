@@ -0,0 +1,473 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/go-test/deep"
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"google.golang.org/api/dns/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// setupOrg creates the project zone and the organization foo in the fakes.
+func setupOrg(t *testing.T, project string) (*DNS, *IAM, *adminx.SecretManager, *adminx.APIKeys, string) {
+	ctx := context.Background()
+	d := NewDNS()
+	domain := dnsname.DefaultDomain
+	pz := dnsx.NewManager(d, project, dnsname.ProjectZone(project, domain))
+	_, err := pz.RegisterZone(ctx, &dns.ManagedZone{
+		Name:    pz.Zone,
+		DnsName: dnsname.ProjectDNS(project, domain),
+	})
+	if err != nil {
+		t.Fatalf("RegisterZone() failed: %v", err)
+	}
+	i := NewIAM()
+	n := adminx.NewNamer(project)
+	sa := adminx.NewServiceAccountsManager(i, n)
+	sm := adminx.NewSecretManager(NewSecretManager(), n, sa)
+	ak := adminx.NewAPIKeys(project, NewKeys(), n)
+	o := adminx.NewOrg(project, NewCRM(project), sa, sm, pz, ak, false)
+	key, err := o.Setup(ctx, "foo")
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	return d, i, sm, ak, key
+}
+
+func TestOrg(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d, i, sm, ak, key := setupOrg(t, project)
+
+	// Setup may be run again.
+	o := adminx.NewOrg(project, NewCRM(project), adminx.NewServiceAccountsManager(i, sm.Namer), sm,
+		dnsx.NewManager(d, project, dnsname.ProjectZone(project, dnsname.DefaultDomain)), ak, false)
+	if _, err := o.Setup(ctx, "foo"); err != nil {
+		t.Errorf("Setup() again failed: %v", err)
+	}
+
+	// Setup publishes the DS record of the organization zone.
+	problems, err := o.CheckDNSSEC(ctx, "foo")
+	if err != nil || len(problems) != 0 {
+		t.Errorf("CheckDNSSEC() = %v, %v, want no problems", problems, err)
+	}
+
+	id, org, err := ak.FindKey(ctx, key)
+	if err != nil || org != "foo" || id != "autojoin-key-foo" {
+		t.Errorf("FindKey() = %q, %q, %v, want autojoin-key-foo, foo", id, org, err)
+	}
+	if _, _, err := ak.FindKey(ctx, "unknown"); err == nil {
+		t.Errorf("FindKey(unknown) returned nil error")
+	}
+
+	// Keys are created once, then loaded.
+	k1, err := sm.LoadOrCreateKey(ctx, "foo")
+	if err != nil {
+		t.Fatalf("LoadOrCreateKey() failed: %v", err)
+	}
+	k2, err := sm.LoadOrCreateKey(ctx, "foo")
+	if err != nil || k1 != k2 {
+		t.Errorf("LoadOrCreateKey() again = %q, %v, want %q", k2, err, k1)
+	}
+
+	// Rotation disables the previous version, and keeps the previous key.
+	r := adminx.NewRotator(sm, 0)
+	if err := r.Rotate(ctx, "foo"); err != nil {
+		t.Fatalf("Rotate() failed: %v", err)
+	}
+	k3, err := sm.LoadKey(ctx, "foo")
+	if err != nil || k3 == k1 {
+		t.Errorf("LoadKey() after Rotate = %q, %v, want new key", k3, err)
+	}
+	keys, err := adminx.NewServiceAccountsManager(i, sm.Namer).ListKeys(ctx, "foo")
+	if err != nil || len(keys) != 2 {
+		t.Errorf("ListKeys() = %d keys, %v, want 2", len(keys), err)
+	}
+
+	// Nodes register in the organization zone.
+	m := dnsx.NewManager(d, project, dnsname.OrgZone("foo", project, dnsname.DefaultDomain))
+	hostname := "ndt-lga12345-01020304.foo.sandbox.measurement-lab.org."
+	if _, err := m.Register(ctx, hostname, "192.0.2.1", "", ""); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if _, err := m.Register(ctx, hostname, "192.0.2.2", "2001:db8::1", `"org=foo"`); err != nil {
+		t.Fatalf("Register() with new addresses failed: %v", err)
+	}
+	if n, err := m.CountRecords(ctx, m.Zone); err != nil || n != 1 {
+		t.Errorf("CountRecords() = %d, %v, want 1", n, err)
+	}
+	if err := o.Teardown(ctx, "foo"); err == nil {
+		t.Errorf("Teardown() with nodes returned nil error")
+	}
+	if _, err := m.Delete(ctx, hostname); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if err := o.Teardown(ctx, "foo"); err != nil {
+		t.Errorf("Teardown() failed: %v", err)
+	}
+	var gerr *googleapi.Error
+	_, err = d.ResourceRecordSetsGet(ctx, project, dnsname.ProjectZone(project, dnsname.DefaultDomain), dnsname.OrgDNS("foo", project, dnsname.DefaultDomain), "DS")
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusNotFound {
+		t.Errorf("DS record after Teardown error = %v, want not found", err)
+	}
+	if _, err := sm.GetSecret(ctx, "foo"); status.Code(err) != codes.NotFound {
+		t.Errorf("GetSecret() after Teardown error = %v, want NotFound", err)
+	}
+}
+
+func TestOrphanedZones(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d, i, sm, ak, _ := setupOrg(t, project)
+	pz := dnsx.NewManager(d, project, dnsname.ProjectZone(project, dnsname.DefaultDomain))
+	o := adminx.NewOrg(project, NewCRM(project), adminx.NewServiceAccountsManager(i, sm.Namer), sm, pz, ak, false)
+
+	// The zone of a deleted org remains without its other resources.
+	if err := o.RegisterDNS(ctx, "bar"); err != nil {
+		t.Fatalf("RegisterDNS() failed: %v", err)
+	}
+	zones, err := o.OrphanedZones(ctx, []string{"foo"})
+	if err != nil || len(zones) != 1 || zones[0].Org != "bar" {
+		t.Fatalf("OrphanedZones() = %+v, %v, want zone of bar", zones, err)
+	}
+	if err := o.DeleteOrphanedZone(ctx, zones[0]); err != nil {
+		t.Fatalf("DeleteOrphanedZone() failed: %v", err)
+	}
+	if zones, err := o.OrphanedZones(ctx, []string{"foo"}); err != nil || len(zones) != 0 {
+		t.Errorf("OrphanedZones() after delete = %+v, %v, want none", zones, err)
+	}
+	split, err := pz.GetZoneSplit(ctx, zones[0].Zone)
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusNotFound {
+		t.Errorf("GetZoneSplit() after delete = %v, %v, want not found", split, err)
+	}
+}
+
+func TestDelegation(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d, i, sm, ak, _ := setupOrg(t, project)
+	pz := dnsx.NewManager(d, project, dnsname.ProjectZone(project, dnsname.DefaultDomain))
+	o := adminx.NewOrg(project, NewCRM(project), adminx.NewServiceAccountsManager(i, sm.Namer), sm, pz, ak, false)
+	o.WithNameServers([]string{"ns2.foo.example", "ns1.foo.example"})
+	zoneDNS := dnsname.OrgDNS("foo", project, dnsname.DefaultDomain)
+
+	// The split of the Cloud DNS zone and its DS record are replaced.
+	if err := o.RegisterDNS(ctx, "foo"); err != nil {
+		t.Fatalf("RegisterDNS() failed: %v", err)
+	}
+	rr, err := d.ResourceRecordSetsGet(ctx, project, pz.Zone, zoneDNS, "NS")
+	if err != nil {
+		t.Fatalf("zone split failed: %v", err)
+	}
+	if diff := deep.Equal(rr.Rrdatas, []string{"ns1.foo.example.", "ns2.foo.example."}); diff != nil {
+		t.Errorf("zone split differs: %v", diff)
+	}
+	var gerr *googleapi.Error
+	_, err = d.ResourceRecordSetsGet(ctx, project, pz.Zone, zoneDNS, "DS")
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusNotFound {
+		t.Errorf("DS record after delegation error = %v, want not found", err)
+	}
+	drift, err := o.Check(ctx, "foo")
+	if err != nil {
+		t.Fatalf("Check() failed: %v", err)
+	}
+	// Bindings are missing since every fake CRM has its own policy.
+	for _, dr := range drift {
+		if dr.Resource == adminx.ResourceZoneSplit && dr.Missing {
+			t.Errorf("Check() returned missing %s %s: %s", dr.Resource, dr.Name, dr.Detail)
+		}
+	}
+	if err := o.Teardown(ctx, "foo"); err != nil {
+		t.Errorf("Teardown() failed: %v", err)
+	}
+	_, err = d.ResourceRecordSetsGet(ctx, project, pz.Zone, zoneDNS, "NS")
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusNotFound {
+		t.Errorf("zone split after Teardown error = %v, want not found", err)
+	}
+}
+
+func TestSiteRecords(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d, _, _, _, _ := setupOrg(t, project)
+	m := dnsx.NewManager(d, project, dnsname.OrgZone("foo", project, dnsname.DefaultDomain))
+	m.Sites = true
+	site := "ndt-lga12345.foo.sandbox.measurement-lab.org."
+	h1 := "ndt-lga12345-01020304.foo.sandbox.measurement-lab.org."
+	h2 := "ndt-lga12345-05060708.foo.sandbox.measurement-lab.org."
+	h3 := "ndt-lga12345-090a0b0c.foo.sandbox.measurement-lab.org."
+
+	wantSite := func(rtype string, want ...string) {
+		t.Helper()
+		rr, err := d.ResourceRecordSetsGet(ctx, project, m.Zone, site, rtype)
+		if len(want) == 0 {
+			var gerr *googleapi.Error
+			if !errors.As(err, &gerr) || gerr.Code != http.StatusNotFound {
+				t.Errorf("site %s record = %v, %v, want not found", rtype, rr, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("site %s record failed: %v", rtype, err)
+		}
+		if diff := deep.Equal(rr.Rrdatas, want); diff != nil {
+			t.Errorf("site %s record differs: %v", rtype, diff)
+		}
+	}
+
+	// Every registered node is added to the site record.
+	if _, err := m.Register(ctx, h1, "192.0.2.1", "2001:db8::1", ""); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if _, err := m.Register(ctx, h2, "192.0.2.2", "", ""); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if _, err := m.Register(ctx, h3, "192.0.2.3", "", ""); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	wantSite("A", "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	wantSite("AAAA", "2001:db8::1")
+
+	// New addresses replace the previous ones.
+	if _, err := m.Register(ctx, h1, "192.0.2.4", "2001:db8::4", ""); err != nil {
+		t.Fatalf("Register() with new addresses failed: %v", err)
+	}
+	wantSite("A", "192.0.2.2", "192.0.2.3", "192.0.2.4")
+	wantSite("AAAA", "2001:db8::4")
+
+	// Site records are not counted as nodes.
+	if n, err := m.CountRecords(ctx, m.Zone); err != nil || n != 3 {
+		t.Errorf("CountRecords() = %d, %v, want 3", n, err)
+	}
+
+	// Deleted nodes are removed, and empty site records are deleted.
+	if _, err := m.Delete(ctx, h1); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	wantSite("A", "192.0.2.2", "192.0.2.3")
+	wantSite("AAAA")
+	results := m.DeleteAll(ctx, []string{h2, h3})
+	for h, err := range results {
+		if err != nil {
+			t.Errorf("DeleteAll() %s failed: %v", h, err)
+		}
+	}
+	wantSite("A")
+}
+
+func TestAliases(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d, _, _, _, _ := setupOrg(t, project)
+	m := dnsx.NewManager(d, project, dnsname.OrgZone("foo", project, dnsname.DefaultDomain))
+	hostname := "ndt-lga12345-01020304.foo.sandbox.measurement-lab.org."
+	alias := func(service string) string {
+		return service + "-lga12345-01020304.foo.sandbox.measurement-lab.org."
+	}
+	wantAlias := func(service string, exists bool) {
+		t.Helper()
+		rr, err := d.ResourceRecordSetsGet(ctx, project, m.Zone, alias(service), "CNAME")
+		switch {
+		case exists && (err != nil || rr.Rrdatas[0] != hostname):
+			t.Errorf("alias %s = %v, %v, want CNAME of %s", service, rr, err, hostname)
+		case !exists && err == nil:
+			t.Errorf("alias %s = %v, want not found", service, rr)
+		}
+	}
+
+	txt := dnsx.TXTData("org", "foo", dnsx.TXTAliases, "msak,wehe")
+	if _, err := m.Register(ctx, hostname, "192.0.2.1", "", txt); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	wantAlias("msak", true)
+	wantAlias("wehe", true)
+
+	// Services that are no longer registered lose their aliases.
+	txt = dnsx.TXTData("org", "foo", dnsx.TXTAliases, "msak,revtr")
+	if _, err := m.Register(ctx, hostname, "192.0.2.1", "", txt); err != nil {
+		t.Fatalf("Register() with new services failed: %v", err)
+	}
+	wantAlias("msak", true)
+	wantAlias("revtr", true)
+	wantAlias("wehe", false)
+
+	if _, err := m.Delete(ctx, hostname); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	wantAlias("msak", false)
+	wantAlias("revtr", false)
+}
+
+func TestExtraRecords(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d, _, _, _, _ := setupOrg(t, project)
+	zoneDNS := dnsname.OrgDNS("foo", project, dnsname.DefaultDomain)
+	m := dnsx.NewManager(d, project, dnsname.OrgZone("foo", project, dnsname.DefaultDomain))
+	set := func(relative, rtype string, values ...string) error {
+		t.Helper()
+		name, err := dnsx.ExtraName(relative, zoneDNS)
+		if err != nil {
+			t.Fatalf("ExtraName(%q) failed: %v", relative, err)
+		}
+		rr, err := dnsx.ExtraRecord(name, rtype, values)
+		if err != nil {
+			t.Fatalf("ExtraRecord(%q) failed: %v", relative, err)
+		}
+		return m.SetExtra(ctx, rr)
+	}
+
+	if _, err := m.Register(ctx, "ndt-lga12345-01020304."+zoneDNS, "192.0.2.1", "", ""); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := set("*.lab", "A", "192.0.2.2"); err != nil {
+		t.Fatalf("SetExtra(*.lab) failed: %v", err)
+	}
+	if err := set("www", "TXT", "foo"); err != nil {
+		t.Fatalf("SetExtra(www) failed: %v", err)
+	}
+	// Setting a record again replaces its values.
+	if err := set("*.lab", "A", "192.0.2.3", "192.0.2.4"); err != nil {
+		t.Fatalf("SetExtra(*.lab) again failed: %v", err)
+	}
+	if err := set("www", "CNAME", "example.com"); !errors.Is(err, dnsx.ErrExtraConflict) {
+		t.Errorf("SetExtra(www CNAME) = %v, want %v", err, dnsx.ErrExtraConflict)
+	}
+	extra, err := m.ListExtra(ctx, zoneDNS)
+	if err != nil || len(extra) != 2 || extra[0].Name != "*.lab."+zoneDNS || len(extra[0].Rrdatas) != 2 {
+		t.Fatalf("ListExtra() = %+v, %v, want *.lab and www", extra, err)
+	}
+	// Extra records are not nodes.
+	if n, err := m.CountRecords(ctx, m.Zone); err != nil || n != 1 {
+		t.Errorf("CountRecords() = %d, %v, want 1", n, err)
+	}
+
+	if err := m.DeleteExtra(ctx, "www."+zoneDNS, "TXT"); err != nil {
+		t.Fatalf("DeleteExtra() failed: %v", err)
+	}
+	if err := m.DeleteExtra(ctx, "www."+zoneDNS, "TXT"); !errors.Is(err, dnsx.ErrExtraNotFound) {
+		t.Errorf("DeleteExtra() again = %v, want %v", err, dnsx.ErrExtraNotFound)
+	}
+	if extra, err := m.ListExtra(ctx, zoneDNS); err != nil || len(extra) != 1 {
+		t.Errorf("ListExtra() after delete = %+v, %v, want *.lab", extra, err)
+	}
+}
+
+func TestDNS_ChangeCreate(t *testing.T) {
+	ctx := context.Background()
+	d := NewDNS()
+	rr := &dns.ResourceRecordSet{Name: "a.example.", Type: "A", Rrdatas: []string{"192.0.2.1"}}
+	tests := []struct {
+		name   string
+		zone   string
+		change *dns.Change
+		code   int
+	}{
+		{name: "error-missing-zone", zone: "missing", change: &dns.Change{Additions: []*dns.ResourceRecordSet{rr}}, code: http.StatusNotFound},
+		{name: "error-empty", zone: "example", change: &dns.Change{}, code: http.StatusBadRequest},
+		{name: "add", zone: "example", change: &dns.Change{Additions: []*dns.ResourceRecordSet{rr}}},
+		{name: "error-exists", zone: "example", change: &dns.Change{Additions: []*dns.ResourceRecordSet{rr}}, code: http.StatusConflict},
+		{
+			name: "error-mismatch",
+			zone: "example",
+			change: &dns.Change{Deletions: []*dns.ResourceRecordSet{
+				{Name: "a.example.", Type: "A", Rrdatas: []string{"192.0.2.2"}},
+			}},
+			code: http.StatusPreconditionFailed,
+		},
+		{name: "delete", zone: "example", change: &dns.Change{Deletions: []*dns.ResourceRecordSet{rr}}},
+		{name: "error-missing-record", zone: "example", change: &dns.Change{Deletions: []*dns.ResourceRecordSet{rr}}, code: http.StatusNotFound},
+	}
+	if _, err := d.CreateManagedZone(ctx, "p", &dns.ManagedZone{Name: "example", DnsName: "example."}); err != nil {
+		t.Fatalf("CreateManagedZone() failed: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := d.ChangeCreate(ctx, "p", tt.zone, tt.change)
+			code := 0
+			if gerr, ok := err.(interface{ Unwrap() error }); ok {
+				if g, ok := gerr.Unwrap().(*googleapi.Error); ok {
+					code = g.Code
+				}
+			}
+			if code != tt.code {
+				t.Errorf("ChangeCreate() error = %v, want code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestDNS_ResourceRecordSetsByName(t *testing.T) {
+	ctx := context.Background()
+	d := NewDNS()
+	if _, err := d.CreateManagedZone(ctx, "p", &dns.ManagedZone{Name: "example", DnsName: "example."}); err != nil {
+		t.Fatalf("CreateManagedZone() failed: %v", err)
+	}
+	_, err := d.ChangeCreate(ctx, "p", "example", &dns.Change{Additions: []*dns.ResourceRecordSet{
+		{Name: "a.example.", Type: "AAAA", Rrdatas: []string{"2001:db8::1"}},
+		{Name: "a.example.", Type: "A", Rrdatas: []string{"192.0.2.1"}},
+		{Name: "b.example.", Type: "A", Rrdatas: []string{"192.0.2.2"}},
+	}})
+	if err != nil {
+		t.Fatalf("ChangeCreate() failed: %v", err)
+	}
+	rrs, err := d.ResourceRecordSetsByName(ctx, "p", "example", "a.example.")
+	if err != nil || len(rrs) != 2 || rrs[0].Type != "A" || rrs[1].Type != "AAAA" {
+		t.Errorf("ResourceRecordSetsByName() = %v, %v; want A and AAAA of a.example.", rrs, err)
+	}
+	if _, err := d.ResourceRecordSetsByName(ctx, "p", "missing", "a.example."); err == nil {
+		t.Errorf("ResourceRecordSetsByName() of missing zone returned nil error")
+	}
+}
+
+func TestSecretManager(t *testing.T) {
+	ctx := context.Background()
+	s := NewSecretManager()
+	name := "projects/p/secrets/s"
+	_, err := s.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{Parent: name})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("AddSecretVersion() without secret error = %v, want NotFound", err)
+	}
+	if _, err := s.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{Parent: "projects/p", SecretId: "s"}); err != nil {
+		t.Fatalf("CreateSecret() failed: %v", err)
+	}
+	if _, err := s.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{Parent: "projects/p", SecretId: "s"}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateSecret() again error = %v, want AlreadyExists", err)
+	}
+	for _, data := range []string{"a", "b"} {
+		_, err := s.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+			Parent:  name,
+			Payload: &secretmanagerpb.SecretPayload{Data: []byte(data)},
+		})
+		if err != nil {
+			t.Fatalf("AddSecretVersion() failed: %v", err)
+		}
+	}
+	if _, err := s.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: name + "/versions/2"}); err != nil {
+		t.Fatalf("DisableSecretVersion() failed: %v", err)
+	}
+	// The latest version is the most recent enabled version.
+	resp, err := s.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: name + "/versions/latest"})
+	if err != nil || string(resp.Payload.Data) != "a" {
+		t.Errorf("AccessSecretVersion(latest) = %v, %v, want a", resp, err)
+	}
+	_, err = s.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: name + "/versions/2"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("AccessSecretVersion(disabled) error = %v, want FailedPrecondition", err)
+	}
+	v, err := s.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: name + "/versions/latest"})
+	if err != nil || v.Name != name+"/versions/1" || time.Since(v.CreateTime.AsTime()) > time.Minute {
+		t.Errorf("GetSecretVersion(latest) = %v, %v, want version 1", v, err)
+	}
+}
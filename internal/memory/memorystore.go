@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/locate/memorystore"
+)
+
+// Memorystore is an in-memory Memorystore client. Values are saved as JSON
+// fields of hashes and scanned into V, like the Memorystore client. Expiration
+// options are ignored; stale tracker entries are removed by the garbage
+// collector.
+type Memorystore[V any] struct {
+	mu     sync.Mutex
+	hashes map[string]map[string][]byte
+}
+
+// NewMemorystore creates a new empty Memorystore.
+func NewMemorystore[V any]() *Memorystore[V] {
+	return &Memorystore[V]{hashes: map[string]map[string][]byte{}}
+}
+
+// Put sets the field of the hash key to the JSON value.
+func (m *Memorystore[V]) Put(key string, field string, value redis.Scanner, opts *memorystore.PutOptions) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.hashes[key]
+	if opts != nil && opts.FieldMustExist != "" {
+		if _, found := h[opts.FieldMustExist]; !found {
+			return errors.New("key not found")
+		}
+	}
+	if !ok {
+		h = map[string][]byte{}
+		m.hashes[key] = h
+	}
+	h[field] = b
+	return nil
+}
+
+// Get returns the value of the hash key, or the zero value if the key does not
+// exist.
+func (m *Memorystore[V]) Get(key string) (V, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var v V
+	src := []interface{}{}
+	for field, b := range m.hashes[key] {
+		src = append(src, []byte(field), b)
+	}
+	err := redis.ScanStruct(src, &v)
+	return v, err
+}
+
+// GetAll returns the values of all hashes.
+func (m *Memorystore[V]) GetAll() (map[string]V, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := map[string]V{}
+	for key, h := range m.hashes {
+		src := []interface{}{}
+		for field, b := range h {
+			src = append(src, []byte(field), b)
+		}
+		var v V
+		if err := redis.ScanStruct(src, &v); err != nil {
+			return nil, err
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+func (m *Memorystore[V]) Del(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.hashes, key)
+	return nil
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/locate/memorystore"
+	"google.golang.org/api/dns/v1"
+)
+
+func TestMemorystore(t *testing.T) {
+	m := NewMemorystore[tracker.Status]()
+	w := &tracker.Window{Start: 1, End: 2}
+	err := m.Put("foo", "Maintenance", w, &memorystore.PutOptions{FieldMustExist: "DNS"})
+	if err == nil {
+		t.Errorf("Put() without required field returned nil error")
+	}
+	r := &tracker.DNSRecord{LastUpdate: 10, Ports: []string{"9990"}}
+	if err := m.Put("foo", "DNS", r, &memorystore.PutOptions{}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := m.Put("foo", "Maintenance", w, &memorystore.PutOptions{FieldMustExist: "DNS"}); err != nil {
+		t.Fatalf("Put() with required field failed: %v", err)
+	}
+	all, err := m.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll() failed: %v", err)
+	}
+	got := all["foo"]
+	if len(all) != 1 || got.DNS == nil || got.DNS.LastUpdate != 10 || got.Maintenance == nil || got.Maintenance.End != 2 {
+		t.Errorf("GetAll() = %+v, want foo with DNS and Maintenance", all)
+	}
+	if got, err := m.Get("foo"); err != nil || got.DNS == nil || got.Maintenance == nil {
+		t.Errorf("Get() = %+v, %v, want DNS and Maintenance", got, err)
+	}
+	if err := m.Del("foo"); err != nil {
+		t.Fatalf("Del() failed: %v", err)
+	}
+	if all, err := m.GetAll(); err != nil || len(all) != 0 {
+		t.Errorf("GetAll() after Del = %+v, %v, want none", all, err)
+	}
+	if got, err := m.Get("foo"); err != nil || got.DNS != nil {
+		t.Errorf("Get() after Del = %+v, %v, want zero value", got, err)
+	}
+}
+
+func TestMemorystore_GarbageCollector(t *testing.T) {
+	ctx := context.Background()
+	project := "mlab-sandbox"
+	d := NewDNS()
+	m := dnsx.NewManager(d, project, dnsname.OrgZone("foo", project, dnsname.DefaultDomain))
+	_, err := d.CreateManagedZone(ctx, project, &dns.ManagedZone{
+		Name:    m.Zone,
+		DnsName: dnsname.OrgDNS("foo", project, dnsname.DefaultDomain),
+	})
+	if err != nil {
+		t.Fatalf("CreateManagedZone() failed: %v", err)
+	}
+	hostname := "ndt-lga12345-01020304.foo.sandbox.measurement-lab.org"
+	if _, err := m.Register(ctx, hostname+".", "192.0.2.1", "", ""); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	// Stale entries are removed from DNS and the tracker.
+	ms := NewMemorystore[tracker.Status]()
+	r := &tracker.DNSRecord{LastUpdate: time.Now().Add(-time.Hour).Unix()}
+	if err := ms.Put(hostname, "DNS", r, &memorystore.PutOptions{}); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	gc := tracker.NewGarbageCollector(d, project, ms, time.Minute, 10*time.Millisecond)
+	go gc.Run(ctx)
+	defer gc.Stop()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if all, _ := ms.GetAll(); len(all) == 0 {
+			break
+		}
+	}
+	if all, err := ms.GetAll(); err != nil || len(all) != 0 {
+		t.Errorf("GetAll() after expiration = %+v, %v, want none", all, err)
+	}
+	if n, err := m.CountRecords(ctx, m.Zone); err != nil || n != 0 {
+		t.Errorf("CountRecords() after expiration = %d, %v, want 0", n, err)
+	}
+}
@@ -18,6 +18,16 @@ var (
 		},
 	)
 
+	// DNSExpirationEarliest is the earliest time, as a Unix timestamp, that the
+	// DNS record of a host of each org and site will be removed.
+	DNSExpirationEarliest = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dns_expiration_earliest",
+			Help: "The earliest DNS record expiration of the hosts of each org and site",
+		},
+		[]string{"org", "site"},
+	)
+
 	// RequestHandlerDuration is a histogram that tracks the latency of each request handler.
 	RequestHandlerDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -26,4 +36,356 @@ var (
 		},
 		[]string{"path", "code"},
 	)
+
+	// SLOObjective is the target fraction of good events for each SLO.
+	SLOObjective = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_slo_objective",
+			Help: "The target fraction of good events for each SLO.",
+		},
+		[]string{"slo"},
+	)
+
+	// SLOErrorRatio is the fraction of bad events for each SLO over a window.
+	SLOErrorRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_slo_error_ratio",
+			Help: "The fraction of bad events for each SLO over a window.",
+		},
+		[]string{"slo", "window"},
+	)
+
+	// SLOBurnRate is the rate at which the error budget of each SLO is
+	// consumed over a window. A burn rate of 1 exhausts the budget exactly at
+	// the end of the SLO period.
+	SLOBurnRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_slo_burn_rate",
+			Help: "The error budget burn rate for each SLO over a window.",
+		},
+		[]string{"slo", "window"},
+	)
+
+	// SLOErrorBudgetRemaining is the fraction of error budget remaining for
+	// each SLO over the longest window.
+	SLOErrorBudgetRemaining = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_slo_error_budget_remaining",
+			Help: "The fraction of error budget remaining for each SLO.",
+		},
+		[]string{"slo"},
+	)
+
+	// TrackerReplicaStaleness is the number of seconds since the tracker read
+	// replica last communicated with its primary.
+	TrackerReplicaStaleness = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autojoin_tracker_replica_staleness_seconds",
+			Help: "Seconds since the tracker read replica last heard from its primary.",
+		},
+	)
+
+	// TrackerReplicaUp reports whether the tracker read replica is connected
+	// to its primary.
+	TrackerReplicaUp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autojoin_tracker_replica_up",
+			Help: "Whether the tracker read replica is connected to its primary.",
+		},
+	)
+
+	// JobUp reports whether each supervised background job is running.
+	JobUp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_job_up",
+			Help: "Whether each supervised background job is running.",
+		},
+		[]string{"job"},
+	)
+
+	// JobRestarts counts restarts of supervised background jobs after failure.
+	JobRestarts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_job_restarts_total",
+			Help: "Number of restarts of supervised background jobs after failure.",
+		},
+		[]string{"job"},
+	)
+
+	// JobPanics counts panics recovered from supervised background jobs.
+	JobPanics = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_job_panics_total",
+			Help: "Number of panics recovered from supervised background jobs.",
+		},
+		[]string{"job"},
+	)
+
+	// RequestPanics counts panics recovered from request handlers.
+	RequestPanics = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_request_panics_total",
+			Help: "Number of panics recovered from request handlers.",
+		},
+		[]string{"path"},
+	)
+
+	// CacheRequests counts cache lookups by cache and result, one of "hit",
+	// "shared_hit" or "miss".
+	CacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_cache_requests_total",
+			Help: "Number of cache lookups by cache and result.",
+		},
+		[]string{"cache", "result"},
+	)
+
+	// KeyRotations counts service account key rotations by result, either
+	// "success" or "error".
+	KeyRotations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_key_rotations_total",
+			Help: "Number of service account key rotations by result.",
+		},
+		[]string{"result"},
+	)
+
+	// DNSSECProblems is the number of DNSSEC problems of each organization
+	// zone, e.g. a missing DS record, as of the last check.
+	DNSSECProblems = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dnssec_problems",
+			Help: "Number of DNSSEC problems of each organization zone.",
+		},
+		[]string{"org"},
+	)
+
+	// ActiveNodes is the number of active nodes of each org and site, as of
+	// the last garbage collection run.
+	ActiveNodes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_active_nodes",
+			Help: "Number of active nodes by org and site.",
+		},
+		[]string{"org", "site"},
+	)
+
+	// NodeRegistrations counts successful registrations of each org.
+	NodeRegistrations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_node_registrations_total",
+			Help: "Number of successful node registrations by org.",
+		},
+		[]string{"org"},
+	)
+
+	// ClientVersions counts successful registrations of each org by the
+	// version of the Go client package of the node, or "unknown".
+	ClientVersions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_client_versions_total",
+			Help: "Number of successful node registrations by org and client version.",
+		},
+		[]string{"org", "version"},
+	)
+
+	// RegistrationsRejected counts failed registrations by org and reason, the
+	// error type of the response. The org is empty if unknown.
+	RegistrationsRejected = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_registrations_rejected_total",
+			Help: "Number of rejected node registrations by org and reason.",
+		},
+		[]string{"org", "reason"},
+	)
+
+	// NodeDeletions counts nodes removed from DNS by org and reason, e.g.
+	// "deleted", "expired" or "suspended".
+	NodeDeletions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_node_deletions_total",
+			Help: "Number of nodes removed from DNS by org and reason.",
+		},
+		[]string{"org", "reason"},
+	)
+
+	// DNSChangeDuration is a histogram of Cloud DNS change latencies by zone.
+	DNSChangeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "autojoin_dns_change_duration_seconds",
+			Help: "A histogram of Cloud DNS change latencies by zone.",
+		},
+		[]string{"zone"},
+	)
+
+	// DNSPropagationDuration is a histogram of the time until Cloud DNS
+	// reports verified changes as done, by zone.
+	DNSPropagationDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "autojoin_dns_propagation_duration_seconds",
+			Help:    "A histogram of the time until Cloud DNS changes are done by zone.",
+			Buckets: []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+		},
+		[]string{"zone"},
+	)
+
+	// DNSRetries counts the retries of Cloud DNS calls by method and the
+	// status code of the failed call.
+	DNSRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_dns_retries_total",
+			Help: "Number of retried Cloud DNS calls by method and status code.",
+		},
+		[]string{"method", "code"},
+	)
+
+	// DNSCachedRegistrations counts registrations that skipped Cloud DNS
+	// because their addresses matched the records last applied.
+	DNSCachedRegistrations = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "autojoin_dns_cached_registrations_total",
+			Help: "Number of registrations that did not read or change Cloud DNS records.",
+		},
+	)
+
+	// DNSCircuitOpen is 1 while the Cloud DNS circuit breaker is open.
+	DNSCircuitOpen = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dns_circuit_open",
+			Help: "Whether the Cloud DNS circuit breaker is open.",
+		},
+	)
+
+	// DNSPendingNodes is the number of registrations of each org whose DNS
+	// records are waiting for Cloud DNS.
+	DNSPendingNodes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dns_pending_nodes",
+			Help: "Number of nodes by org whose DNS records are pending.",
+		},
+		[]string{"org"},
+	)
+
+	// SecretAccessDuration is a histogram of Secret Manager secret access
+	// latencies.
+	SecretAccessDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "autojoin_secret_access_duration_seconds",
+			Help: "A histogram of Secret Manager secret access latencies.",
+		},
+	)
+
+	// DatastoreLookupDuration is a histogram of Datastore lookup latencies by
+	// entity kind.
+	DatastoreLookupDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "autojoin_datastore_lookup_duration_seconds",
+			Help: "A histogram of Datastore lookup latencies by kind.",
+		},
+		[]string{"kind"},
+	)
+
+	// KeysDeleted counts service account keys deleted after rotation because
+	// they were older than the maximum key age.
+	KeysDeleted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "autojoin_keys_deleted_total",
+			Help: "Number of old service account keys deleted after rotation.",
+		},
+	)
+
+	// IataRows is the number of rows of the IATA dataset in use.
+	IataRows = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autojoin_iata_rows",
+			Help: "Number of rows of the IATA dataset in use.",
+		},
+	)
+
+	// IataLastUpdate is the time, as a Unix timestamp, that the IATA dataset
+	// in use was loaded. Reloads of unchanged data do not update it.
+	IataLastUpdate = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autojoin_iata_last_update_timestamp_seconds",
+			Help: "The time that the IATA dataset in use was loaded.",
+		},
+	)
+
+	// IataLoadFailures counts failed reloads of the IATA dataset by reason,
+	// one of "fetch", "metros" or "shrunk".
+	IataLoadFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_iata_load_failures_total",
+			Help: "Number of failed IATA dataset reloads by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	// DatasetLastLoad is the time, as a Unix timestamp, of the last
+	// successful load of each dataset, e.g. "iata" or "maxmind".
+	DatasetLastLoad = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dataset_last_load_timestamp_seconds",
+			Help: "The time of the last successful load of each dataset.",
+		},
+		[]string{"dataset"},
+	)
+
+	// DatasetAge is the time in seconds since the last successful load of
+	// each dataset, updated periodically.
+	DatasetAge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dataset_age_seconds",
+			Help: "Seconds since the last successful load of each dataset.",
+		},
+		[]string{"dataset"},
+	)
+
+	// DatasetEntries is the size of each dataset in use, if known.
+	DatasetEntries = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_dataset_entries",
+			Help: "Number of entries of each dataset in use.",
+		},
+		[]string{"dataset"},
+	)
+
+	// RedisPoolConnections is the number of connections of each Redis pool by
+	// state, either "in_use" or "idle".
+	RedisPoolConnections = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autojoin_redis_pool_connections",
+			Help: "Number of connections of each Redis pool by state.",
+		},
+		[]string{"pool", "state"},
+	)
+
+	// RedisPoolWaits counts connections that callers waited for because a
+	// Redis pool had reached its maximum number of active connections.
+	RedisPoolWaits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_redis_pool_waits_total",
+			Help: "Number of connections waited for by Redis pool.",
+		},
+		[]string{"pool"},
+	)
+
+	// RedisDialErrors counts failed connection attempts by Redis pool.
+	RedisDialErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_redis_dial_errors_total",
+			Help: "Number of failed Redis connection attempts by pool.",
+		},
+		[]string{"pool"},
+	)
+
+	// RedisCommandErrors counts failed Redis commands by pool and command.
+	// Receives of replies, e.g. Pub/Sub messages, are counted as RECEIVE.
+	RedisCommandErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autojoin_redis_command_errors_total",
+			Help: "Number of failed Redis commands by pool and command.",
+		},
+		[]string{"pool", "command"},
+	)
 )
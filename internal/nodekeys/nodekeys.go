@@ -0,0 +1,118 @@
+// Package nodekeys issues a separate service account key to each node and
+// revokes it when the node is removed, so that one compromised node does not
+// expose the credentials of every node in its organization.
+package nodekeys
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/tracing"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/iam/v1"
+)
+
+// Kind is the Datastore kind of node key entities.
+const Kind = "NodeKey"
+
+// Key records the service account key issued to a node. The Datastore name
+// of the entity is the node hostname. Private key data is never saved.
+type Key struct {
+	Org string
+	// Name is the IAM resource name of the service account key.
+	Name    string    `datastore:",noindex"`
+	Created time.Time `datastore:",noindex"`
+}
+
+// Issuer creates and deletes service account keys.
+type Issuer interface {
+	CreateKey(ctx context.Context, org string) (*iam.ServiceAccountKey, error)
+	DeleteKey(ctx context.Context, keyName string) error
+}
+
+// Datastore is the subset of the Datastore client used to track node keys.
+// It is implemented by *datastore.Client.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+}
+
+// Manager issues and revokes node keys.
+type Manager struct {
+	issuer    Issuer
+	ds        Datastore
+	namespace string
+}
+
+// NewManager creates a new Manager that tracks node keys in the given
+// Datastore namespace.
+func NewManager(i Issuer, ds Datastore, namespace string) *Manager {
+	return &Manager{issuer: i, ds: ds, namespace: namespace}
+}
+
+// IssueKey creates a new service account key for the node and returns the
+// base64 encoded private key data. The key previously issued to the node, if
+// any, is deleted, so each node holds at most one key.
+func (m *Manager) IssueKey(ctx context.Context, org, hostname string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "nodekeys.IssueKey", attribute.String("hostname", hostname))
+	defer func() { tracing.End(span, err) }()
+	prev := &Key{}
+	err = m.ds.Get(ctx, m.key(hostname), prev)
+	if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+		return "", err
+	}
+	k, err := m.issuer.CreateKey(ctx, org)
+	if err != nil {
+		return "", err
+	}
+	_, err = m.ds.Put(ctx, m.key(hostname), &Key{Org: org, Name: k.Name, Created: time.Now().UTC()})
+	if err != nil {
+		// Do not leave a key that cannot be revoked later.
+		if derr := m.issuer.DeleteKey(ctx, k.Name); derr != nil {
+			log.Printf("Failed to delete untracked key of %s: %v", hostname, derr)
+		}
+		return "", err
+	}
+	if prev.Name != "" && prev.Name != k.Name {
+		// The node receives the new key either way, so a failure only
+		// leaves the previous key active until it is deleted manually.
+		if err := m.issuer.DeleteKey(ctx, prev.Name); err != nil {
+			log.Printf("Failed to delete previous key of %s: %v", hostname, err)
+		}
+	}
+	return k.PrivateKeyData, nil
+}
+
+// Revoke deletes the service account key issued to the node. Nodes without
+// an issued key are ignored.
+func (m *Manager) Revoke(ctx context.Context, hostname string) error {
+	k := &Key{}
+	err := m.ds.Get(ctx, m.key(hostname), k)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.issuer.DeleteKey(ctx, k.Name); err != nil {
+		return err
+	}
+	return m.ds.Delete(ctx, m.key(hostname))
+}
+
+// Report revokes the key of a node removed from the tracker. Report
+// implements tracker.Reporter.
+func (m *Manager) Report(ctx context.Context, hostname string, s tracker.Status, reason string) error {
+	return m.Revoke(ctx, hostname)
+}
+
+func (m *Manager) key(hostname string) *datastore.Key {
+	k := datastore.NameKey(Kind, hostname, nil)
+	k.Namespace = m.namespace
+	return k
+}
@@ -0,0 +1,171 @@
+package nodekeys
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"google.golang.org/api/iam/v1"
+)
+
+type fakeDatastore struct {
+	m         map[string]Key
+	getErr    error
+	putErr    error
+	deleteErr error
+	key       *datastore.Key
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	k, ok := f.m[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Key) = k
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.m[key.Name] = *src.(*Key)
+	return key, nil
+}
+
+func (f *fakeDatastore) Delete(ctx context.Context, key *datastore.Key) error {
+	f.key = key
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	delete(f.m, key.Name)
+	return nil
+}
+
+type fakeIssuer struct {
+	created   int
+	deleted   []string
+	createErr error
+	deleteErr error
+}
+
+func (f *fakeIssuer) CreateKey(ctx context.Context, org string) (*iam.ServiceAccountKey, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	f.created++
+	return &iam.ServiceAccountKey{
+		Name:           "keys/" + strconv.Itoa(f.created),
+		PrivateKeyData: "data-" + strconv.Itoa(f.created),
+	}, nil
+}
+
+func (f *fakeIssuer) DeleteKey(ctx context.Context, keyName string) error {
+	f.deleted = append(f.deleted, keyName)
+	return f.deleteErr
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	ds := &fakeDatastore{m: map[string]Key{}}
+	i := &fakeIssuer{}
+	m := NewManager(i, ds, "test")
+	hostname := "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org"
+
+	key, err := m.IssueKey(ctx, "mlab", hostname)
+	if err != nil || key != "data-1" {
+		t.Fatalf("IssueKey() = %q, %v; want %q, nil", key, err, "data-1")
+	}
+	if ds.key.Namespace != "test" || ds.key.Kind != Kind || ds.key.Name != hostname {
+		t.Errorf("IssueKey() used wrong key; got %v", ds.key)
+	}
+	if ds.m[hostname].Org != "mlab" || ds.m[hostname].Name != "keys/1" {
+		t.Errorf("IssueKey() saved wrong key; got %+v", ds.m[hostname])
+	}
+	// Issuing a new key deletes the previous one.
+	key, err = m.IssueKey(ctx, "mlab", hostname)
+	if err != nil || key != "data-2" {
+		t.Fatalf("IssueKey() = %q, %v; want %q, nil", key, err, "data-2")
+	}
+	if !reflect.DeepEqual(i.deleted, []string{"keys/1"}) {
+		t.Errorf("IssueKey() deleted wrong keys; got %v", i.deleted)
+	}
+	// Removed nodes are revoked.
+	if err := m.Report(ctx, hostname, tracker.Status{}, "expired"); err != nil {
+		t.Errorf("Report() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(i.deleted, []string{"keys/1", "keys/2"}) {
+		t.Errorf("Report() deleted wrong keys; got %v", i.deleted)
+	}
+	if _, ok := ds.m[hostname]; ok {
+		t.Errorf("Report() did not delete node key entity")
+	}
+	// Nodes without keys are ignored.
+	if err := m.Revoke(ctx, hostname); err != nil {
+		t.Errorf("Revoke() returned error: %v", err)
+	}
+}
+
+func TestManager_Errors(t *testing.T) {
+	ctx := context.Background()
+	hostname := "ndt-lga12345-c0a80001.mlab.sandbox.measurement-lab.org"
+	tests := []struct {
+		name        string
+		ds          *fakeDatastore
+		issuer      *fakeIssuer
+		wantIssue   bool
+		wantRevoke  bool
+		wantDeleted []string
+	}{
+		{
+			name:       "error-get",
+			ds:         &fakeDatastore{m: map[string]Key{}, getErr: errors.New("fake get error")},
+			issuer:     &fakeIssuer{},
+			wantIssue:  true,
+			wantRevoke: true,
+		},
+		{
+			name:      "error-create",
+			ds:        &fakeDatastore{m: map[string]Key{}},
+			issuer:    &fakeIssuer{createErr: errors.New("fake create error")},
+			wantIssue: true,
+		},
+		{
+			name:        "error-put-deletes-new-key",
+			ds:          &fakeDatastore{m: map[string]Key{}, putErr: errors.New("fake put error")},
+			issuer:      &fakeIssuer{},
+			wantIssue:   true,
+			wantDeleted: []string{"keys/1"},
+		},
+		{
+			name:        "error-delete",
+			ds:          &fakeDatastore{m: map[string]Key{hostname: {Org: "mlab", Name: "keys/0"}}},
+			issuer:      &fakeIssuer{deleteErr: errors.New("fake delete error")},
+			wantRevoke:  true,
+			wantDeleted: []string{"keys/0", "keys/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(tt.issuer, tt.ds, "test")
+			if _, err := m.IssueKey(ctx, "mlab", hostname); (err != nil) != tt.wantIssue {
+				t.Errorf("IssueKey() error = %v, wantErr %t", err, tt.wantIssue)
+			}
+			if err := m.Revoke(ctx, hostname); (err != nil) != tt.wantRevoke {
+				t.Errorf("Revoke() error = %v, wantErr %t", err, tt.wantRevoke)
+			}
+			if !reflect.DeepEqual(tt.issuer.deleted, tt.wantDeleted) {
+				t.Errorf("deleted wrong keys; got %v, want %v", tt.issuer.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Package notify verifies the contact emails of organizations and sends them
+// notifications about their keys and nodes.
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/host"
+)
+
+// Kind is the Datastore kind of email verification entities.
+const Kind = "EmailVerification"
+
+// ErrInvalidToken is returned when verifying an email with an unknown or
+// expired token.
+var ErrInvalidToken = errors.New("verification token is unknown or expired")
+
+// Verification is the entity saved for the email of an organization. The
+// Datastore name of the entity is the organization name.
+type Verification struct {
+	Email string `datastore:",noindex"`
+	// Token is the SHA-256 hash of the token sent to Email, so tokens are
+	// never saved.
+	Token     string    `datastore:",noindex"`
+	Created   time.Time `datastore:",noindex"`
+	ExpiresAt time.Time `datastore:",noindex"`
+	// Verified is the time Email was verified. Zero is unverified.
+	Verified time.Time `datastore:",noindex"`
+}
+
+// Sender sends a plain text email.
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Datastore is the subset of the Datastore client used to persist
+// verifications.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+}
+
+// OrgSettings reads the settings of organizations.
+type OrgSettings interface {
+	Get(ctx context.Context, org string) (orgs.Settings, error)
+}
+
+// Notifier verifies organization emails and sends notifications to verified
+// emails. Organizations without a verified email are not notified.
+type Notifier struct {
+	sender    Sender
+	ds        Datastore
+	namespace string
+	orgs      OrgSettings
+	verifyURL string
+	ttl       time.Duration
+}
+
+// NewNotifier creates a new Notifier that saves verifications in the given
+// Datastore namespace. Verification links point to verifyURL, e.g.
+// https://autojoin-dot-mlab-sandbox.appspot.com/autojoin/v0/org/verify, and
+// expire after ttl.
+func NewNotifier(s Sender, ds Datastore, namespace string, o OrgSettings, verifyURL string, ttl time.Duration) *Notifier {
+	return &Notifier{sender: s, ds: ds, namespace: namespace, orgs: o, verifyURL: verifyURL, ttl: ttl}
+}
+
+// SendVerification sends a verification link to the email of org. Links sent
+// earlier are no longer valid.
+func (n *Notifier) SendVerification(ctx context.Context, org, email string) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now().UTC()
+	v := &Verification{Email: email, Token: hash(token), Created: now, ExpiresAt: now.Add(n.ttl)}
+	if _, err := n.ds.Put(ctx, n.key(org), v); err != nil {
+		return err
+	}
+	link := n.verifyURL + "?" + url.Values{"org": {org}, "token": {token}}.Encode()
+	body := fmt.Sprintf("Please verify the contact email of the M-Lab Autojoin organization %q\n"+
+		"by opening this link before %s:\n\n%s\n\n"+
+		"Verified contacts are notified about key rotations and expired nodes.\n",
+		org, v.ExpiresAt.Format(time.RFC1123), link)
+	return n.sender.Send(ctx, email, "Verify your M-Lab Autojoin contact email", body)
+}
+
+// Verify marks the email of org as verified. Unknown, expired, or replaced
+// tokens return ErrInvalidToken.
+func (n *Notifier) Verify(ctx context.Context, org, token string) error {
+	v := &Verification{}
+	err := n.ds.Get(ctx, n.key(org), v)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(v.Token), []byte(hash(token))) != 1 {
+		return ErrInvalidToken
+	}
+	if !v.Verified.IsZero() {
+		return nil
+	}
+	if !time.Now().Before(v.ExpiresAt) {
+		return ErrInvalidToken
+	}
+	v.Verified = time.Now().UTC()
+	_, err = n.ds.Put(ctx, n.key(org), v)
+	return err
+}
+
+// Notify sends a message to the email of org if it is verified. Emails
+// changed since verification are not notified.
+func (n *Notifier) Notify(ctx context.Context, org, subject, body string) error {
+	s, err := n.orgs.Get(ctx, org)
+	if err != nil {
+		return err
+	}
+	if s.Email == "" {
+		return nil
+	}
+	v := &Verification{}
+	err = n.ds.Get(ctx, n.key(org), v)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if v.Verified.IsZero() || v.Email != s.Email {
+		return nil
+	}
+	return n.sender.Send(ctx, s.Email, subject, body)
+}
+
+// KeyRotated notifies org that its service account key was replaced.
+func (n *Notifier) KeyRotated(ctx context.Context, org string) error {
+	body := fmt.Sprintf("The service account key of the M-Lab Autojoin organization %q was replaced.\n"+
+		"Nodes receive the new key on their next registration.\n", org)
+	return n.Notify(ctx, org, "M-Lab Autojoin key rotated", body)
+}
+
+// Report notifies the organization of a hostname removed by the garbage
+// collector because it stopped registering. Other removals are ignored.
+// Report implements tracker.Reporter.
+func (n *Notifier) Report(ctx context.Context, hostname string, s tracker.Status, reason string) error {
+	if reason != "expired" {
+		return nil
+	}
+	name, err := host.Parse(hostname)
+	if err != nil || name.Org == "" {
+		return nil
+	}
+	body := fmt.Sprintf("The node %s of the M-Lab Autojoin organization %q stopped registering\n"+
+		"and was removed. It is added again on its next registration.\n", hostname, name.Org)
+	return n.Notify(ctx, name.Org, "M-Lab Autojoin node expired: "+hostname, body)
+}
+
+func (n *Notifier) key(org string) *datastore.Key {
+	k := datastore.NameKey(Kind, org, nil)
+	k.Namespace = n.namespace
+	return k
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
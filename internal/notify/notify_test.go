@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/tracker"
+)
+
+type fakeDatastore struct {
+	m      map[string]Verification
+	getErr error
+	putErr error
+	key    *datastore.Key
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	v, ok := f.m[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Verification) = v
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.m[key.Name] = *src.(*Verification)
+	return key, nil
+}
+
+type fakeSender struct {
+	to      []string
+	subject string
+	body    string
+	err     error
+}
+
+func (f *fakeSender) Send(ctx context.Context, to, subject, body string) error {
+	f.to = append(f.to, to)
+	f.subject = subject
+	f.body = body
+	return f.err
+}
+
+type fakeOrgs struct {
+	settings orgs.Settings
+	err      error
+}
+
+func (f *fakeOrgs) Get(ctx context.Context, org string) (orgs.Settings, error) {
+	return f.settings, f.err
+}
+
+var link = regexp.MustCompile(`https://\S+`)
+
+// tokenOf returns the token of the verification link in body.
+func tokenOf(t *testing.T, body string) string {
+	u, err := url.Parse(link.FindString(body))
+	if err != nil {
+		t.Fatalf("failed to parse link of %q: %v", body, err)
+	}
+	if u.Query().Get("org") != "foo" {
+		t.Errorf("link has wrong org: %s", u)
+	}
+	return u.Query().Get("token")
+}
+
+func TestNotifier_Verify(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Verification{}}
+	s := &fakeSender{}
+	o := &fakeOrgs{settings: orgs.Settings{Email: "ops@foo.example"}}
+	n := NewNotifier(s, ds, "test", o, "https://autojoin.example/autojoin/v0/org/verify", time.Hour)
+	ctx := context.Background()
+
+	// Unverified emails are not notified.
+	if err := n.KeyRotated(ctx, "foo"); err != nil || len(s.to) != 0 {
+		t.Errorf("KeyRotated() = %v, sent to %v; want no email", err, s.to)
+	}
+	if err := n.SendVerification(ctx, "foo", "ops@foo.example"); err != nil {
+		t.Fatalf("SendVerification() returned error: %v", err)
+	}
+	if ds.key.Namespace != "test" || ds.key.Kind != Kind || ds.key.Name != "foo" {
+		t.Errorf("SendVerification() used wrong key; got %v", ds.key)
+	}
+	token := tokenOf(t, s.body)
+	if ds.m["foo"].Token == token {
+		t.Errorf("SendVerification() saved the token; want hash")
+	}
+	if err := n.KeyRotated(ctx, "foo"); err != nil || len(s.to) != 1 {
+		t.Errorf("KeyRotated() = %v, sent to %v; want no email", err, s.to)
+	}
+	if err := n.Verify(ctx, "foo", "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() returned wrong error; got %v, want %v", err, ErrInvalidToken)
+	}
+	if err := n.Verify(ctx, "bar", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() returned wrong error; got %v, want %v", err, ErrInvalidToken)
+	}
+	if err := n.Verify(ctx, "foo", token); err != nil {
+		t.Fatalf("Verify() returned error: %v", err)
+	}
+	// Verifying again is harmless.
+	if err := n.Verify(ctx, "foo", token); err != nil {
+		t.Errorf("Verify() returned error: %v", err)
+	}
+	if err := n.KeyRotated(ctx, "foo"); err != nil || len(s.to) != 2 || s.to[1] != "ops@foo.example" {
+		t.Errorf("KeyRotated() = %v, sent to %v; want ops@foo.example", err, s.to)
+	}
+
+	// Changed emails are not notified until verified.
+	o.settings.Email = "new@foo.example"
+	if err := n.KeyRotated(ctx, "foo"); err != nil || len(s.to) != 2 {
+		t.Errorf("KeyRotated() = %v, sent to %v; want no email", err, s.to)
+	}
+
+	// Expired tokens are rejected.
+	if err := n.SendVerification(ctx, "foo", "new@foo.example"); err != nil {
+		t.Fatalf("SendVerification() returned error: %v", err)
+	}
+	token = tokenOf(t, s.body)
+	v := ds.m["foo"]
+	v.ExpiresAt = time.Now().Add(-time.Minute)
+	ds.m["foo"] = v
+	if err := n.Verify(ctx, "foo", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() returned wrong error; got %v, want %v", err, ErrInvalidToken)
+	}
+
+	ds.getErr = errors.New("fake get error")
+	if err := n.Verify(ctx, "foo", token); err == nil {
+		t.Errorf("Verify() expected error")
+	}
+	if err := n.KeyRotated(ctx, "foo"); err == nil {
+		t.Errorf("KeyRotated() expected error")
+	}
+	ds.putErr = errors.New("fake put error")
+	if err := n.SendVerification(ctx, "foo", "ops@foo.example"); err == nil {
+		t.Errorf("SendVerification() expected error")
+	}
+	o.err = errors.New("fake orgs error")
+	if err := n.KeyRotated(ctx, "foo"); err == nil {
+		t.Errorf("KeyRotated() expected error")
+	}
+}
+
+func TestNotifier_Report(t *testing.T) {
+	verified := map[string]Verification{
+		"foo": {Email: "ops@foo.example", Verified: time.Now()},
+	}
+	tests := []struct {
+		name     string
+		hostname string
+		reason   string
+		sendErr  error
+		wantSent bool
+		wantErr  bool
+	}{
+		{
+			name:     "success-expired",
+			hostname: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org",
+			reason:   "expired",
+			wantSent: true,
+		},
+		{
+			name:     "success-deleted",
+			hostname: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org",
+			reason:   "deleted",
+		},
+		{
+			name:     "success-invalid-hostname",
+			hostname: "invalid",
+			reason:   "expired",
+		},
+		{
+			name:     "error-send",
+			hostname: "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org",
+			reason:   "expired",
+			sendErr:  errors.New("fake send error"),
+			wantSent: true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeSender{err: tt.sendErr}
+			o := &fakeOrgs{settings: orgs.Settings{Email: "ops@foo.example"}}
+			n := NewNotifier(s, &fakeDatastore{m: verified}, "test", o, "https://autojoin.example", time.Hour)
+			err := n.Report(context.Background(), tt.hostname, tracker.Status{}, tt.reason)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Report() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(s.to) != 0) != tt.wantSent {
+				t.Errorf("Report() sent to %v, want sent %t", s.to, tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP is a Sender that sends emails through an SMTP server, e.g. the
+// SendGrid relay smtp.sendgrid.net:587 with the username "apikey".
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTP creates a new SMTP sender using the server at addr, e.g.
+// "smtp.example.com:587", that sends emails from the given address.
+// Authentication is disabled when username is empty.
+func NewSMTP(addr, from, username, password string) *SMTP {
+	s := &SMTP{addr: addr, from: from, send: smtp.SendMail}
+	if username != "" {
+		h, _, _ := net.SplitHostPort(addr)
+		s.auth = smtp.PlainAuth("", username, password, h)
+	}
+	return s
+}
+
+// Send sends a plain text email to the given address.
+func (s *SMTP) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header: %q", to+subject)
+	}
+	msg := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+	return s.send(s.addr, s.auth, s.from, []string{to}, []byte(msg))
+}
@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestSMTP_Send(t *testing.T) {
+	s := NewSMTP("smtp.example.com:587", "autojoin@example.com", "apikey", "secret")
+	var gotAddr, gotFrom, gotMsg string
+	var gotTo []string
+	var gotAuth smtp.Auth
+	s.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotAuth, gotFrom, gotTo, gotMsg = addr, a, from, to, string(msg)
+		return nil
+	}
+	err := s.Send(context.Background(), "ops@foo.example", "hello", "line 1\nline 2\n")
+	if err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+	if gotAddr != "smtp.example.com:587" || gotFrom != "autojoin@example.com" || gotAuth == nil {
+		t.Errorf("Send() used wrong server; got %q, %q, %v", gotAddr, gotFrom, gotAuth)
+	}
+	if len(gotTo) != 1 || gotTo[0] != "ops@foo.example" {
+		t.Errorf("Send() used wrong recipients; got %v", gotTo)
+	}
+	for _, want := range []string{"To: ops@foo.example\r\n", "Subject: hello\r\n", "\r\n\r\nline 1\r\nline 2\r\n"} {
+		if !strings.Contains(gotMsg, want) {
+			t.Errorf("Send() message %q does not contain %q", gotMsg, want)
+		}
+	}
+
+	if err := s.Send(context.Background(), "ops@foo.example", "hello\r\nBcc: x@y.example", ""); err == nil {
+		t.Errorf("Send() expected error for header injection")
+	}
+	s.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		return errors.New("fake send error")
+	}
+	if err := s.Send(context.Background(), "ops@foo.example", "hello", ""); err == nil {
+		t.Errorf("Send() expected error")
+	}
+
+	if s := NewSMTP("localhost:25", "autojoin@example.com", "", ""); s.auth != nil {
+		t.Errorf("NewSMTP() without username has auth %v", s.auth)
+	}
+}
@@ -0,0 +1,95 @@
+// Package operation persists the status of long running requests, so that
+// clients may poll for completion from any instance of the Autojoin API.
+package operation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	v0 "github.com/m-lab/autojoin/api/v0"
+)
+
+// Kind is the Datastore kind of operation entities.
+const Kind = "Operation"
+
+// ErrNotFound is returned when an operation does not exist.
+var ErrNotFound = errors.New("operation not found")
+
+// Datastore is the subset of the Datastore client used to persist operations.
+// It is implemented by *datastore.Client.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+}
+
+// Store creates, reads, and updates operations.
+type Store struct {
+	ds        Datastore
+	namespace string
+}
+
+// NewStore creates a new Store that saves operations in the given Datastore
+// namespace.
+func NewStore(ds Datastore, namespace string) *Store {
+	return &Store{ds: ds, namespace: namespace}
+}
+
+// Create saves a new pending operation of the given type for a hostname. The
+// new operation is assigned a random ID.
+func (s *Store) Create(ctx context.Context, opType, hostname, org string) (*v0.Operation, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	op := &v0.Operation{
+		ID:       hex.EncodeToString(b),
+		Type:     opType,
+		Hostname: hostname,
+		Org:      org,
+		State:    v0.OperationPending,
+		Created:  now,
+		Updated:  now,
+	}
+	if _, err := s.ds.Put(ctx, s.key(op.ID), op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// Get returns the operation with the given ID, or ErrNotFound.
+func (s *Store) Get(ctx context.Context, id string) (*v0.Operation, error) {
+	op := &v0.Operation{}
+	err := s.ds.Get(ctx, s.key(id), op)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// Finish saves the final state of the operation. A nil error marks the
+// operation done, otherwise failed.
+func (s *Store) Finish(ctx context.Context, op *v0.Operation, opErr error) error {
+	op.State = v0.OperationDone
+	op.Error = ""
+	if opErr != nil {
+		op.State = v0.OperationFailed
+		op.Error = opErr.Error()
+	}
+	op.Updated = time.Now().UTC()
+	_, err := s.ds.Put(ctx, s.key(op.ID), op)
+	return err
+}
+
+func (s *Store) key(id string) *datastore.Key {
+	k := datastore.NameKey(Kind, id, nil)
+	k.Namespace = s.namespace
+	return k
+}
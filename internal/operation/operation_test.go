@@ -0,0 +1,88 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	v0 "github.com/m-lab/autojoin/api/v0"
+)
+
+type fakeDatastore struct {
+	ops    map[string]v0.Operation
+	getErr error
+	putErr error
+	key    *datastore.Key
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	op, ok := f.ops[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*v0.Operation) = op
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.ops[key.Name] = *src.(*v0.Operation)
+	return key, nil
+}
+
+func TestStore(t *testing.T) {
+	ds := &fakeDatastore{ops: map[string]v0.Operation{}}
+	s := NewStore(ds, "test")
+	ctx := context.Background()
+
+	op, err := s.Create(ctx, "delete", "foo", "mlab")
+	if err != nil {
+		t.Fatalf("Create() returned err: %v", err)
+	}
+	if op.ID == "" || op.State != v0.OperationPending || op.Hostname != "foo" || op.Org != "mlab" {
+		t.Errorf("Create() returned wrong operation; got %+v", op)
+	}
+	if ds.key.Kind != Kind || ds.key.Name != op.ID || ds.key.Namespace != "test" {
+		t.Errorf("Create() used wrong key; got %v", ds.key)
+	}
+
+	err = s.Finish(ctx, op, errors.New("fake delete error"))
+	if err != nil {
+		t.Fatalf("Finish() returned err: %v", err)
+	}
+	got, err := s.Get(ctx, op.ID)
+	if err != nil {
+		t.Fatalf("Get() returned err: %v", err)
+	}
+	if got.State != v0.OperationFailed || got.Error != "fake delete error" {
+		t.Errorf("Get() returned wrong operation; got %+v", got)
+	}
+
+	err = s.Finish(ctx, got, nil)
+	if err != nil {
+		t.Fatalf("Finish() returned err: %v", err)
+	}
+	if got.State != v0.OperationDone || got.Error != "" {
+		t.Errorf("Finish() saved wrong state; got %+v", got)
+	}
+
+	if _, err := s.Get(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+	ds.getErr = errors.New("fake get error")
+	if _, err := s.Get(ctx, op.ID); err != ds.getErr {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+	ds.putErr = errors.New("fake put error")
+	if _, err := s.Create(ctx, "delete", "foo", "mlab"); err != ds.putErr {
+		t.Errorf("Create() returned wrong error; got %v, want %v", err, ds.putErr)
+	}
+}
@@ -0,0 +1,237 @@
+package orgs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is the configuration of an organization as stored in version
+// control, e.g. foo.yaml:
+//
+//	name: foo
+//	email: ops@foo.example
+//	update_tables: false
+//	probability_multiplier: 0.5
+//	allowed_services: [ndt]
+//	allowed_asns: [64512]
+//
+// Settings managed by the Autojoin API, like the workload identity provider,
+// are not part of the definition.
+type Definition struct {
+	Name                  string   `yaml:"name"`
+	Email                 string   `yaml:"email,omitempty"`
+	Status                string   `yaml:"status,omitempty"`
+	UpdateTables          bool     `yaml:"update_tables"`
+	ProbabilityMultiplier float64  `yaml:"probability_multiplier,omitempty"`
+	VerifySourceIP        bool     `yaml:"verify_source_ip,omitempty"`
+	AllowSharedIP         bool     `yaml:"allow_shared_ip,omitempty"`
+	AllowedServices       []string `yaml:"allowed_services,omitempty"`
+	AllowedASNs           []int64  `yaml:"allowed_asns,omitempty"`
+	AllowedPrefixes       []string `yaml:"allowed_prefixes,omitempty"`
+	NodeKeys              bool     `yaml:"node_keys,omitempty"`
+	AccessTokens          bool     `yaml:"access_tokens,omitempty"`
+	MaxRecords            int      `yaml:"max_records,omitempty"`
+	DNSTTL                int64    `yaml:"dns_ttl,omitempty"`
+	MaxMetroDistance      int      `yaml:"max_metro_distance,omitempty"`
+	RejectHosting         bool     `yaml:"reject_hosting,omitempty"`
+}
+
+// NewDefinition returns the definition of the named organization with the
+// given settings.
+func NewDefinition(name string, st Settings) *Definition {
+	return &Definition{
+		Name:                  name,
+		Email:                 st.Email,
+		Status:                st.Status,
+		UpdateTables:          st.UpdateTables,
+		ProbabilityMultiplier: st.ProbabilityMultiplier,
+		VerifySourceIP:        st.VerifySourceIP,
+		AllowSharedIP:         st.AllowSharedIP,
+		AllowedServices:       st.AllowedServices,
+		AllowedASNs:           st.AllowedASNs,
+		AllowedPrefixes:       st.AllowedPrefixes,
+		NodeKeys:              st.NodeKeys,
+		AccessTokens:          st.AccessTokens,
+		MaxRecords:            st.MaxRecords,
+		DNSTTL:                st.DNSTTL,
+		MaxMetroDistance:      st.MaxMetroDistance,
+		RejectHosting:         st.RejectHosting,
+	}
+}
+
+// ReadDefinition reads and validates a YAML definition from r.
+func ReadDefinition(r io.Reader) (*Definition, error) {
+	d := &Definition{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("invalid definition: %w", err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ReadDefinitions reads and validates a YAML manifest from r, i.e. a list of
+// definitions with unique names.
+func ReadDefinitions(r io.Reader) ([]*Definition, error) {
+	defs := []*Definition{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&defs); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := validateAll(defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// ReadDefinitionsCSV reads and validates a CSV manifest of definitions from r.
+// The header row names the columns with the YAML field names of Definition,
+// and list values are separated by ";", e.g.
+//
+//	name,email,allowed_asns
+//	foo,ops@foo.example,64512;64513
+func ReadDefinitionsCSV(r io.Reader) ([]*Definition, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("invalid manifest: missing header")
+	}
+	fields := definitionFields()
+	header := rows[0]
+	for _, h := range header {
+		if _, ok := fields[h]; !ok {
+			return nil, fmt.Errorf("invalid manifest: unknown column %q", h)
+		}
+	}
+	defs := []*Definition{}
+	for i, row := range rows[1:] {
+		// Build a YAML mapping of plain scalars, so values are decoded with
+		// the same types as YAML manifests.
+		m := &yaml.Node{Kind: yaml.MappingNode}
+		for j, v := range row {
+			if v == "" {
+				continue
+			}
+			value := &yaml.Node{Kind: yaml.ScalarNode, Value: v}
+			if fields[header[j]] == reflect.Slice {
+				value = &yaml.Node{Kind: yaml.SequenceNode}
+				for _, e := range strings.Split(v, ";") {
+					value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: e})
+				}
+			}
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: header[j]}, value)
+		}
+		d := &Definition{}
+		if err := m.Decode(d); err != nil {
+			return nil, fmt.Errorf("invalid manifest: row %d: %w", i+2, err)
+		}
+		defs = append(defs, d)
+	}
+	if err := validateAll(defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// definitionFields returns the kinds of Definition fields by YAML name.
+func definitionFields() map[string]reflect.Kind {
+	fields := map[string]reflect.Kind{}
+	t := reflect.TypeOf(Definition{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		fields[name] = t.Field(i).Type.Kind()
+	}
+	return fields
+}
+
+// validateAll validates every definition, and rejects duplicate names.
+func validateAll(defs []*Definition) error {
+	names := map[string]bool{}
+	for _, d := range defs {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		if names[d.Name] {
+			return fmt.Errorf("invalid manifest: duplicate name %q", d.Name)
+		}
+		names[d.Name] = true
+	}
+	return nil
+}
+
+// Write writes the definition as YAML to w.
+func (d *Definition) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(d); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Validate returns an error if the definition cannot be applied.
+func (d *Definition) Validate() error {
+	switch {
+	case d.Name == "":
+		return fmt.Errorf("invalid definition: name is required")
+	case d.Status != "" && d.Status != StatusActive && d.Status != StatusSuspended:
+		return fmt.Errorf("invalid definition: status must be %q or %q", StatusActive, StatusSuspended)
+	}
+	if err := ValidateEmail(d.Email); err != nil {
+		return fmt.Errorf("invalid definition: %w", err)
+	}
+	if d.ProbabilityMultiplier != 0 {
+		if err := ValidateProbabilityMultiplier(d.ProbabilityMultiplier); err != nil {
+			return fmt.Errorf("invalid definition: %w", err)
+		}
+	}
+	for _, p := range d.AllowedPrefixes {
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			return fmt.Errorf("invalid definition: allowed_prefixes: %w", err)
+		}
+	}
+	if d.DNSTTL != 0 {
+		if err := dnsx.ValidateTTL(d.DNSTTL); err != nil {
+			return fmt.Errorf("invalid definition: dns_ttl: %w", err)
+		}
+	}
+	if d.MaxMetroDistance < 0 {
+		return fmt.Errorf("invalid definition: max_metro_distance must not be negative")
+	}
+	return nil
+}
+
+// Settings returns the current settings updated with the definition.
+// Settings that are not part of the definition are unchanged.
+func (d *Definition) Settings(current Settings) Settings {
+	st := current
+	st.Email = d.Email
+	st.Status = d.Status
+	st.UpdateTables = d.UpdateTables
+	st.ProbabilityMultiplier = d.ProbabilityMultiplier
+	st.VerifySourceIP = d.VerifySourceIP
+	st.AllowSharedIP = d.AllowSharedIP
+	st.AllowedServices = d.AllowedServices
+	st.AllowedASNs = d.AllowedASNs
+	st.AllowedPrefixes = d.AllowedPrefixes
+	st.NodeKeys = d.NodeKeys
+	st.AccessTokens = d.AccessTokens
+	st.MaxRecords = d.MaxRecords
+	st.DNSTTL = d.DNSTTL
+	st.MaxMetroDistance = d.MaxMetroDistance
+	st.RejectHosting = d.RejectHosting
+	return st
+}
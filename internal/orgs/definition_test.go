@@ -0,0 +1,205 @@
+package orgs
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefinition_RoundTrip(t *testing.T) {
+	st := Settings{
+		Status:                   StatusSuspended,
+		Email:                    "ops@foo.example",
+		UpdateTables:             true,
+		ProbabilityMultiplier:    0.5,
+		AllowedServices:          []string{"ndt"},
+		AllowedASNs:              []int64{64512},
+		AllowedPrefixes:          []string{"192.168.0.0/24"},
+		NodeKeys:                 true,
+		DNSTTL:                   60,
+		MaxMetroDistance:         500,
+		RejectHosting:            true,
+		WorkloadIdentityProvider: "projects/123/locations/global/workloadIdentityPools/autojoin-foo/providers/oidc",
+	}
+	b := &bytes.Buffer{}
+	if err := NewDefinition("foo", st).Write(b); err != nil {
+		t.Fatalf("Definition.Write() error = %v", err)
+	}
+	d, err := ReadDefinition(b)
+	if err != nil {
+		t.Fatalf("ReadDefinition() error = %v", err)
+	}
+	if d.Name != "foo" {
+		t.Errorf("ReadDefinition() name = %q, want foo", d.Name)
+	}
+	// Settings outside of the definition are kept.
+	got := d.Settings(Settings{WorkloadIdentityProvider: st.WorkloadIdentityProvider})
+	if !reflect.DeepEqual(got, st) {
+		t.Errorf("Definition.Settings() = %+v, want %+v", got, st)
+	}
+}
+
+func TestReadDefinition(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			yaml: "name: foo\nemail: ops@foo.example\nallowed_asns: [1, 2]\n",
+		},
+		{
+			name:    "error-unknown-field",
+			yaml:    "name: foo\nallowed_asn: [1]\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-missing-name",
+			yaml:    "email: ops@foo.example\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-status",
+			yaml:    "name: foo\nstatus: deleted\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-multiplier",
+			yaml:    "name: foo\nprobability_multiplier: -1\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-multiplier-too-large",
+			yaml:    "name: foo\nprobability_multiplier: 100\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-email",
+			yaml:    "name: foo\nemail: not an address\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-prefix",
+			yaml:    "name: foo\nallowed_prefixes: [invalid]\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-dns-ttl",
+			yaml:    "name: foo\ndns_ttl: 5\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-max-metro-distance",
+			yaml:    "name: foo\nmax_metro_distance: -1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadDefinition(strings.NewReader(tt.yaml))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadDefinition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadDefinitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			yaml: "- name: foo\n  email: ops@foo.example\n- name: bar\n  update_tables: true\n",
+			want: []string{"foo", "bar"},
+		},
+		{
+			name:    "error-duplicate",
+			yaml:    "- name: foo\n- name: foo\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-not-a-list",
+			yaml:    "name: foo\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs, err := ReadDefinitions(strings.NewReader(tt.yaml))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadDefinitions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			names := []string{}
+			for _, d := range defs {
+				names = append(names, d.Name)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("ReadDefinitions() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDefinitionsCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []*Definition
+		wantErr bool
+	}{
+		{
+			name: "success",
+			csv: "name,email,update_tables,probability_multiplier,allowed_asns,allowed_services\n" +
+				"foo,ops@foo.example,true,0.5,64512;64513,ndt\n" +
+				"bar,,,,,\n",
+			want: []*Definition{
+				{
+					Name:                  "foo",
+					Email:                 "ops@foo.example",
+					UpdateTables:          true,
+					ProbabilityMultiplier: 0.5,
+					AllowedASNs:           []int64{64512, 64513},
+					AllowedServices:       []string{"ndt"},
+				},
+				{Name: "bar"},
+			},
+		},
+		{
+			name:    "error-unknown-column",
+			csv:     "name,mail\nfoo,ops@foo.example\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-invalid-value",
+			csv:     "name,allowed_asns\nfoo,AS64512\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-missing-name",
+			csv:     "name,email\n,ops@foo.example\n",
+			wantErr: true,
+		},
+		{
+			name:    "error-empty",
+			csv:     "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadDefinitionsCSV(strings.NewReader(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadDefinitionsCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadDefinitionsCSV() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package orgs
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// HistoryKind is the Datastore kind of multiplier change entities. Changes
+// are children of the Organization entity of their organization.
+const HistoryKind = "MultiplierChange"
+
+// MultiplierChange records one change of the ProbabilityMultiplier of an
+// organization.
+type MultiplierChange struct {
+	Old float64
+	New float64
+	// Actor is the API key or user that made the change.
+	Actor string
+	Time  time.Time
+}
+
+// HistoryDatastore is the subset of the Datastore client used to persist
+// multiplier changes. It is implemented by *datastore.Client.
+type HistoryDatastore interface {
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+}
+
+// History reads and writes the multiplier changes of organizations.
+type History struct {
+	ds        HistoryDatastore
+	namespace string
+}
+
+// NewHistory creates a new History that saves changes in the given Datastore
+// namespace.
+func NewHistory(ds HistoryDatastore, namespace string) *History {
+	return &History{ds: ds, namespace: namespace}
+}
+
+// Add saves a multiplier change of the organization.
+func (h *History) Add(ctx context.Context, org string, c MultiplierChange) error {
+	k := datastore.IncompleteKey(HistoryKind, h.parent(org))
+	k.Namespace = h.namespace
+	_, err := h.ds.Put(ctx, k, &c)
+	return err
+}
+
+// List returns the multiplier changes of the organization, most recent first.
+func (h *History) List(ctx context.Context, org string) ([]MultiplierChange, error) {
+	q := datastore.NewQuery(HistoryKind).Namespace(h.namespace).Ancestor(h.parent(org))
+	l := []MultiplierChange{}
+	if _, err := h.ds.GetAll(ctx, q, &l); err != nil {
+		return nil, err
+	}
+	// Sort here, since ordered ancestor queries require a composite index.
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Time.After(l[j].Time)
+	})
+	return l, nil
+}
+
+func (h *History) parent(org string) *datastore.Key {
+	k := datastore.NameKey(Kind, org, nil)
+	k.Namespace = h.namespace
+	return k
+}
@@ -0,0 +1,75 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+type fakeHistoryDatastore struct {
+	l      []MultiplierChange
+	err    error
+	key    *datastore.Key
+	getErr error
+}
+
+func (f *fakeHistoryDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.l = append(f.l, *src.(*MultiplierChange))
+	return key, nil
+}
+
+// GetAll returns every change in the order they were added, ignoring the query.
+func (f *fakeHistoryDatastore) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	l := dst.(*[]MultiplierChange)
+	*l = append(*l, f.l...)
+	return make([]*datastore.Key, len(f.l)), nil
+}
+
+func TestHistory(t *testing.T) {
+	ds := &fakeHistoryDatastore{}
+	h := NewHistory(ds, "test")
+	ctx := context.Background()
+	first := MultiplierChange{Old: 0, New: 0.5, Actor: "key foo", Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	second := MultiplierChange{Old: 0.5, New: 2, Actor: "user bar", Time: first.Time.Add(time.Hour)}
+
+	for _, c := range []MultiplierChange{first, second} {
+		if err := h.Add(ctx, "mlab", c); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if ds.key.Kind != HistoryKind || !ds.key.Incomplete() || ds.key.Namespace != "test" {
+		t.Errorf("Add() used wrong key; got %v", ds.key)
+	}
+	if p := ds.key.Parent; p == nil || p.Kind != Kind || p.Name != "mlab" || p.Namespace != "test" {
+		t.Errorf("Add() used wrong parent key; got %v", p)
+	}
+
+	got, err := h.List(ctx, "mlab")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []MultiplierChange{second, first}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	ds.err = errors.New("fake put error")
+	if err := h.Add(ctx, "mlab", first); err == nil {
+		t.Errorf("Add() expected error")
+	}
+	ds.getErr = errors.New("fake get error")
+	if _, err := h.List(ctx, "mlab"); err == nil {
+		t.Errorf("List() expected error")
+	}
+}
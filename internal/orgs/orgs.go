@@ -0,0 +1,313 @@
+// Package orgs persists per-organization settings of the Autojoin API.
+package orgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"reflect"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/cache"
+	"github.com/m-lab/autojoin/internal/metrics"
+)
+
+// Kind is the Datastore kind of organization settings entities.
+const Kind = "Organization"
+
+// Organization status values. Organizations without a saved status are active.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+)
+
+// MaxProbabilityMultiplier is the largest valid ProbabilityMultiplier.
+const MaxProbabilityMultiplier = 10
+
+// DefaultMaxRecords is the number of extra records organizations may add to
+// their zone when MaxRecords is zero.
+const DefaultMaxRecords = 10
+
+// Settings contains the options of one organization. The zero value contains
+// the defaults for organizations without saved settings.
+type Settings struct {
+	// Status is StatusActive or StatusSuspended. Suspended organizations
+	// cannot register nodes.
+	Status string
+	// Email is the operator contact of the organization.
+	Email string
+	// UpdateTables records whether the organization service account may
+	// update table schemas. It is applied when the organization is created.
+	UpdateTables bool
+	// ProbabilityMultiplier scales the probability requested by nodes of the
+	// organization. Zero leaves the probability unchanged.
+	ProbabilityMultiplier float64
+	// VerifySourceIP requires the ipv4 given at registration to match the
+	// source address of the request.
+	VerifySourceIP bool
+	// AllowSharedIP allows registration of addresses that are already
+	// registered by another organization.
+	AllowSharedIP bool
+	// AllowedASNs limits registrations to nodes in the given ASNs. All ASNs
+	// are allowed when empty.
+	AllowedASNs []int64
+	// AllowedPrefixes limits registrations to addresses within the given
+	// CIDR prefixes. All addresses are allowed when empty.
+	AllowedPrefixes []string
+	// AllowedServices limits registrations to nodes of the given services.
+	// All services are allowed when empty.
+	AllowedServices []string
+	// NodeKeys issues a separate service account key to each node, instead
+	// of the key shared by the organization. Node keys are revoked when the
+	// node is deleted or expires.
+	NodeKeys bool
+	// AccessTokens returns short-lived access tokens to nodes instead of
+	// service account keys. Nodes refresh tokens before they expire.
+	AccessTokens bool
+	// WorkloadIdentityProvider is the resource name of the workload identity
+	// federation provider of the organization. When set, nodes receive a
+	// federation config to impersonate WorkloadIdentityServiceAccount with
+	// their OIDC identity, instead of service account keys.
+	WorkloadIdentityProvider       string
+	WorkloadIdentityServiceAccount string
+	// MaxRecords limits the number of extra records in the organization
+	// zone. Zero means DefaultMaxRecords, and a negative value disables
+	// extra records.
+	MaxRecords int
+	// NameServers are the name servers the organization zone is delegated
+	// to, instead of a Cloud DNS zone. They are set when the organization is
+	// created.
+	NameServers []string
+	// DNSTTL is the TTL in seconds of the DNS records of registered nodes,
+	// unless a node requests another one. Zero means dnsx.DefaultTTL.
+	DNSTTL int64
+	// MaxMetroDistance rejects registrations whose IATA airport is farther
+	// than the given km from the geolocation of the node. Zero disables the
+	// check.
+	MaxMetroDistance int
+	// RejectHosting rejects registrations of addresses that Maxmind flags as
+	// hosting providers or proxies. Otherwise, they are only flagged in the
+	// annotation of the node.
+	RejectHosting bool
+}
+
+// ValidateEmail returns an error if email is not empty and not a valid
+// address, e.g. "ops@foo.example".
+func ValidateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	a, err := mail.ParseAddress(email)
+	if err != nil || a.Address != email {
+		return fmt.Errorf("invalid email address: %q", email)
+	}
+	return nil
+}
+
+// ValidateProbabilityMultiplier returns an error if m is not within
+// (0, MaxProbabilityMultiplier].
+func ValidateProbabilityMultiplier(m float64) error {
+	if m <= 0 || m > MaxProbabilityMultiplier {
+		return fmt.Errorf("probability multiplier must be within (0, %d]: %v", MaxProbabilityMultiplier, m)
+	}
+	return nil
+}
+
+// Diff returns the changes from settings a to b as "Field: a -> b", e.g. for
+// audit logs.
+func Diff(a, b Settings) []string {
+	changes := []string{}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		fa, fb := va.Field(i).Interface(), vb.Field(i).Interface()
+		if !reflect.DeepEqual(fa, fb) {
+			changes = append(changes, fmt.Sprintf("%s: %#v -> %#v", va.Type().Field(i).Name, fa, fb))
+		}
+	}
+	return changes
+}
+
+// Suspended reports whether the organization is suspended.
+func (st Settings) Suspended() bool {
+	return st.Status == StatusSuspended
+}
+
+// AllowsASN reports whether the settings allow registrations from asn.
+func (st Settings) AllowsASN(asn int64) bool {
+	if len(st.AllowedASNs) == 0 {
+		return true
+	}
+	for _, a := range st.AllowedASNs {
+		if a == asn {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsService reports whether the settings allow registrations of nodes
+// running service.
+func (st Settings) AllowsService(service string) bool {
+	if len(st.AllowedServices) == 0 {
+		return true
+	}
+	for _, s := range st.AllowedServices {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Probability returns the node probability p scaled by the
+// ProbabilityMultiplier of the settings, at most 1.
+func (st Settings) Probability(p float64) float64 {
+	if st.ProbabilityMultiplier == 0 {
+		return p
+	}
+	return math.Min(p*st.ProbabilityMultiplier, 1)
+}
+
+// RecordQuota returns the number of extra records the organization may add to
+// its zone.
+func (st Settings) RecordQuota() int {
+	switch {
+	case st.MaxRecords == 0:
+		return DefaultMaxRecords
+	case st.MaxRecords < 0:
+		return 0
+	}
+	return st.MaxRecords
+}
+
+// AllowsIP reports whether the settings allow registrations of ip.
+// Unparseable prefixes never match.
+func (st Settings) AllowsIP(ip net.IP) bool {
+	if len(st.AllowedPrefixes) == 0 {
+		return true
+	}
+	for _, p := range st.AllowedPrefixes {
+		_, n, err := net.ParseCIDR(p)
+		if err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Datastore is the subset of the Datastore client used to persist settings.
+// It is implemented by *datastore.Client.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+}
+
+// Store reads and writes organization settings.
+type Store struct {
+	ds        Datastore
+	namespace string
+}
+
+// NewStore creates a new Store that saves settings in the given Datastore
+// namespace.
+func NewStore(ds Datastore, namespace string) *Store {
+	return &Store{ds: ds, namespace: namespace}
+}
+
+// Get returns the settings of the organization, or the defaults if none were
+// saved.
+func (s *Store) Get(ctx context.Context, org string) (Settings, error) {
+	st := Settings{}
+	start := time.Now()
+	err := s.ds.Get(ctx, s.key(org), &st)
+	metrics.DatastoreLookupDuration.WithLabelValues(Kind).Observe(time.Since(start).Seconds())
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Settings{}, nil
+	}
+	return st, err
+}
+
+// Suspended reports whether the organization is suspended.
+func (s *Store) Suspended(ctx context.Context, org string) (bool, error) {
+	st, err := s.Get(ctx, org)
+	if err != nil {
+		return false, err
+	}
+	return st.Suspended(), nil
+}
+
+// Set saves the settings of the organization.
+func (s *Store) Set(ctx context.Context, org string, st Settings) error {
+	_, err := s.ds.Put(ctx, s.key(org), &st)
+	return err
+}
+
+// Delete deletes the settings of the organization, restoring the defaults.
+func (s *Store) Delete(ctx context.Context, org string) error {
+	return s.ds.Delete(ctx, s.key(org))
+}
+
+// List returns the sorted names of the organizations with saved settings.
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	q := datastore.NewQuery(Kind).Namespace(s.namespace)
+	l := []Settings{}
+	keys, err := s.ds.GetAll(ctx, q, &l)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, k := range keys {
+		names = append(names, k.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) key(org string) *datastore.Key {
+	k := datastore.NameKey(Kind, org, nil)
+	k.Namespace = s.namespace
+	return k
+}
+
+// CachedStore is a Store that caches settings to avoid Datastore reads on
+// every registration. Set invalidates the cached settings of the organization.
+type CachedStore struct {
+	store *Store
+	cache *cache.Cache[Settings]
+}
+
+// NewCachedStore creates a new CachedStore.
+func NewCachedStore(s *Store, c *cache.Cache[Settings]) *CachedStore {
+	return &CachedStore{store: s, cache: c}
+}
+
+// Get returns the cached settings of the organization.
+func (s *CachedStore) Get(ctx context.Context, org string) (Settings, error) {
+	return s.cache.Get(org, func() (Settings, error) {
+		return s.store.Get(ctx, org)
+	})
+}
+
+// Suspended reports whether the organization is suspended.
+func (s *CachedStore) Suspended(ctx context.Context, org string) (bool, error) {
+	st, err := s.Get(ctx, org)
+	if err != nil {
+		return false, err
+	}
+	return st.Suspended(), nil
+}
+
+// Set saves the settings of the organization and removes them from the cache.
+func (s *CachedStore) Set(ctx context.Context, org string, st Settings) error {
+	if err := s.store.Set(ctx, org, st); err != nil {
+		return err
+	}
+	return s.cache.Invalidate(org)
+}
@@ -0,0 +1,289 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/m-lab/autojoin/internal/cache"
+)
+
+type fakeDatastore struct {
+	m      map[string]Settings
+	getErr error
+	putErr error
+	key    *datastore.Key
+	gets   int
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	f.gets++
+	if f.getErr != nil {
+		return f.getErr
+	}
+	st, ok := f.m[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Settings) = st
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.m[key.Name] = *src.(*Settings)
+	return key, nil
+}
+
+func (f *fakeDatastore) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	keys := []*datastore.Key{}
+	for name, st := range f.m {
+		*dst.(*[]Settings) = append(*dst.(*[]Settings), st)
+		keys = append(keys, datastore.NameKey(Kind, name, nil))
+	}
+	return keys, nil
+}
+
+func (f *fakeDatastore) Delete(ctx context.Context, key *datastore.Key) error {
+	f.key = key
+	delete(f.m, key.Name)
+	return f.putErr
+}
+
+func TestStore(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Settings{}}
+	s := NewStore(ds, "test")
+	ctx := context.Background()
+
+	// Organizations without saved settings use the defaults.
+	st, err := s.Get(ctx, "mlab")
+	if err != nil || !reflect.DeepEqual(st, Settings{}) {
+		t.Errorf("Get() = %v, %v; want defaults", st, err)
+	}
+	if ds.key.Kind != Kind || ds.key.Name != "mlab" || ds.key.Namespace != "test" {
+		t.Errorf("Get() used wrong key; got %v", ds.key)
+	}
+
+	err = s.Set(ctx, "mlab", Settings{VerifySourceIP: true})
+	if err != nil {
+		t.Fatalf("Set() returned err: %v", err)
+	}
+	st, err = s.Get(ctx, "mlab")
+	if err != nil || !st.VerifySourceIP {
+		t.Errorf("Get() = %v, %v; want VerifySourceIP", st, err)
+	}
+
+	if suspended, err := s.Suspended(ctx, "mlab"); err != nil || suspended {
+		t.Errorf("Suspended() = %t, %v; want false", suspended, err)
+	}
+	err = s.Set(ctx, "mlab", Settings{Status: StatusSuspended})
+	if err != nil {
+		t.Fatalf("Set() returned err: %v", err)
+	}
+	if suspended, err := s.Suspended(ctx, "mlab"); err != nil || !suspended {
+		t.Errorf("Suspended() = %t, %v; want true", suspended, err)
+	}
+
+	if err := s.Set(ctx, "foo", Settings{}); err != nil {
+		t.Fatalf("Set() returned err: %v", err)
+	}
+	if l, err := s.List(ctx); err != nil || !reflect.DeepEqual(l, []string{"foo", "mlab"}) {
+		t.Errorf("List() = %v, %v; want [foo mlab]", l, err)
+	}
+
+	if err := s.Delete(ctx, "mlab"); err != nil {
+		t.Fatalf("Delete() returned err: %v", err)
+	}
+	if st, err := s.Get(ctx, "mlab"); err != nil || !reflect.DeepEqual(st, Settings{}) {
+		t.Errorf("Get() = %v, %v; want defaults after Delete()", st, err)
+	}
+
+	ds.getErr = errors.New("fake get error")
+	if _, err := s.Get(ctx, "mlab"); err != ds.getErr {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+	if _, err := s.List(ctx); err != ds.getErr {
+		t.Errorf("List() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+	if _, err := s.Suspended(ctx, "mlab"); err != ds.getErr {
+		t.Errorf("Suspended() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+	ds.putErr = errors.New("fake put error")
+	if err := s.Set(ctx, "mlab", Settings{}); err != ds.putErr {
+		t.Errorf("Set() returned wrong error; got %v, want %v", err, ds.putErr)
+	}
+}
+
+func TestSettings_Allows(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		asn      int64
+		ip       string
+		wantASN  bool
+		wantIP   bool
+	}{
+		{
+			name:    "success-no-restrictions",
+			asn:     12345,
+			ip:      "192.168.0.1",
+			wantASN: true,
+			wantIP:  true,
+		},
+		{
+			name: "success-allowed",
+			settings: Settings{
+				AllowedASNs:     []int64{1, 12345},
+				AllowedPrefixes: []string{"10.0.0.0/8", "192.168.0.0/24"},
+			},
+			asn:     12345,
+			ip:      "192.168.0.1",
+			wantASN: true,
+			wantIP:  true,
+		},
+		{
+			name: "success-allowed-ipv6",
+			settings: Settings{
+				AllowedPrefixes: []string{"2001:db8::/32"},
+			},
+			ip:      "2001:db8::1",
+			wantASN: true,
+			wantIP:  true,
+		},
+		{
+			name: "denied",
+			settings: Settings{
+				AllowedASNs:     []int64{1},
+				AllowedPrefixes: []string{"10.0.0.0/8", "invalid"},
+			},
+			asn: 12345,
+			ip:  "192.168.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.settings.AllowsASN(tt.asn); got != tt.wantASN {
+				t.Errorf("AllowsASN() = %t, want %t", got, tt.wantASN)
+			}
+			if got := tt.settings.AllowsIP(net.ParseIP(tt.ip)); got != tt.wantIP {
+				t.Errorf("AllowsIP() = %t, want %t", got, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestSettings_AllowsService(t *testing.T) {
+	st := Settings{}
+	if !st.AllowsService("ndt") {
+		t.Errorf("AllowsService() = false, want true without restrictions")
+	}
+	st.AllowedServices = []string{"ndt"}
+	if !st.AllowsService("ndt") || st.AllowsService("wehe") {
+		t.Errorf("AllowsService() did not restrict services to %v", st.AllowedServices)
+	}
+}
+
+func TestSettings_Probability(t *testing.T) {
+	tests := []struct {
+		name       string
+		multiplier float64
+		p          float64
+		want       float64
+	}{
+		{name: "success-unset", p: 0.5, want: 0.5},
+		{name: "success-scaled", multiplier: 0.5, p: 0.5, want: 0.25},
+		{name: "success-at-most-one", multiplier: 4, p: 0.5, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := Settings{ProbabilityMultiplier: tt.multiplier}
+			if got := st.Probability(tt.p); got != tt.want {
+				t.Errorf("Probability() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	for _, email := range []string{"", "ops@foo.example"} {
+		if err := ValidateEmail(email); err != nil {
+			t.Errorf("ValidateEmail(%q) error = %v, want nil", email, err)
+		}
+	}
+	for _, email := range []string{"ops", "Ops <ops@foo.example>", "ops@foo.example, x@y.z"} {
+		if err := ValidateEmail(email); err == nil {
+			t.Errorf("ValidateEmail(%q) error = nil, want error", email)
+		}
+	}
+}
+
+func TestValidateProbabilityMultiplier(t *testing.T) {
+	for _, m := range []float64{0.01, 1, MaxProbabilityMultiplier} {
+		if err := ValidateProbabilityMultiplier(m); err != nil {
+			t.Errorf("ValidateProbabilityMultiplier(%v) error = %v, want nil", m, err)
+		}
+	}
+	for _, m := range []float64{-1, 0, MaxProbabilityMultiplier + 0.1} {
+		if err := ValidateProbabilityMultiplier(m); err == nil {
+			t.Errorf("ValidateProbabilityMultiplier(%v) error = nil, want error", m)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := Settings{Email: "a@foo.example", AllowedASNs: []int64{1}}
+	b := Settings{Email: "b@foo.example", AllowedASNs: []int64{1}, ProbabilityMultiplier: 0.5}
+	want := []string{
+		`Email: "a@foo.example" -> "b@foo.example"`,
+		`ProbabilityMultiplier: 0 -> 0.5`,
+	}
+	if got := Diff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+	if got := Diff(a, a); len(got) != 0 {
+		t.Errorf("Diff() = %q, want no changes", got)
+	}
+}
+
+func TestCachedStore(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Settings{}}
+	s := NewCachedStore(NewStore(ds, "test"), cache.New[Settings]("orgs", time.Minute, nil))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if suspended, err := s.Suspended(ctx, "mlab"); err != nil || suspended {
+			t.Errorf("Suspended() = %t, %v; want false", suspended, err)
+		}
+	}
+	if ds.gets != 1 {
+		t.Errorf("Suspended() read Datastore %d times; want 1", ds.gets)
+	}
+
+	// Set invalidates the cached settings.
+	if err := s.Set(ctx, "mlab", Settings{Status: StatusSuspended}); err != nil {
+		t.Fatalf("Set() returned err: %v", err)
+	}
+	if suspended, err := s.Suspended(ctx, "mlab"); err != nil || !suspended {
+		t.Errorf("Suspended() = %t, %v; want true", suspended, err)
+	}
+
+	ds.putErr = errors.New("fake put error")
+	if err := s.Set(ctx, "mlab", Settings{}); err != ds.putErr {
+		t.Errorf("Set() returned wrong error; got %v, want %v", err, ds.putErr)
+	}
+	ds.getErr = errors.New("fake get error")
+	if _, err := s.Suspended(ctx, "other"); err != ds.getErr {
+		t.Errorf("Suspended() returned wrong error; got %v, want %v", err, ds.getErr)
+	}
+}
@@ -0,0 +1,143 @@
+// Package provision issues single-use provisioning tokens, which let new nodes
+// register without a long-lived organization API key in their image.
+package provision
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Kind is the Datastore kind of provisioning token entities.
+const Kind = "ProvisioningToken"
+
+// ErrInvalidToken is returned when redeeming an unknown, expired, or used
+// token.
+var ErrInvalidToken = errors.New("provisioning token is unknown, expired, or used")
+
+// Token is the entity saved for a provisioning token. The Datastore name of
+// the entity is the SHA-256 hash of the token, so tokens are never saved.
+type Token struct {
+	// Org is the organization that nodes using the token register under.
+	Org       string
+	Created   time.Time `datastore:",noindex"`
+	ExpiresAt time.Time `datastore:",noindex"`
+	// Used is the time the token was redeemed. Zero is unused.
+	Used time.Time `datastore:",noindex"`
+}
+
+// Transaction is the subset of a Datastore transaction used to redeem tokens.
+// It is implemented by *datastore.Transaction.
+type Transaction interface {
+	Get(key *datastore.Key, dst interface{}) error
+	Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error)
+}
+
+// Datastore is the subset of the Datastore client used to persist tokens.
+type Datastore interface {
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	RunInTransaction(ctx context.Context, f func(tx Transaction) error) error
+}
+
+// client adapts a *datastore.Client to the Datastore interface.
+type client struct {
+	c *datastore.Client
+}
+
+// NewDatastore returns a Datastore using the given client.
+func NewDatastore(c *datastore.Client) Datastore {
+	return &client{c: c}
+}
+
+func (c *client) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	return c.c.Put(ctx, key, src)
+}
+
+func (c *client) RunInTransaction(ctx context.Context, f func(tx Transaction) error) error {
+	_, err := c.c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(tx)
+	})
+	return err
+}
+
+// Store mints and redeems provisioning tokens.
+type Store struct {
+	ds        Datastore
+	namespace string
+}
+
+// NewStore creates a new Store that saves tokens in the given Datastore
+// namespace.
+func NewStore(ds Datastore, namespace string) *Store {
+	return &Store{ds: ds, namespace: namespace}
+}
+
+// Mint creates a new token for the organization that may be redeemed once
+// within ttl.
+func (s *Store) Mint(ctx context.Context, org string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now().UTC()
+	t := &Token{Org: org, Created: now, ExpiresAt: now.Add(ttl)}
+	if _, err := s.ds.Put(ctx, s.key(token), t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Redeem marks the token as used and returns its organization. Tokens that
+// are unknown, expired, or already used return ErrInvalidToken.
+func (s *Store) Redeem(ctx context.Context, token string) (string, error) {
+	org := ""
+	err := s.ds.RunInTransaction(ctx, func(tx Transaction) error {
+		t := &Token{}
+		err := tx.Get(s.key(token), t)
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if !t.Used.IsZero() || !now.Before(t.ExpiresAt) {
+			return ErrInvalidToken
+		}
+		t.Used = now.UTC()
+		org = t.Org
+		_, err = tx.Put(s.key(token), t)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return org, nil
+}
+
+// Release marks a redeemed token as unused, e.g. after the registration it
+// was redeemed for failed. Expired tokens remain invalid.
+func (s *Store) Release(ctx context.Context, token string) error {
+	return s.ds.RunInTransaction(ctx, func(tx Transaction) error {
+		t := &Token{}
+		if err := tx.Get(s.key(token), t); err != nil {
+			return err
+		}
+		t.Used = time.Time{}
+		_, err := tx.Put(s.key(token), t)
+		return err
+	})
+}
+
+func (s *Store) key(token string) *datastore.Key {
+	sum := sha256.Sum256([]byte(token))
+	k := datastore.NameKey(Kind, hex.EncodeToString(sum[:]), nil)
+	k.Namespace = s.namespace
+	return k
+}
@@ -0,0 +1,115 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+type fakeDatastore struct {
+	m      map[string]Token
+	getErr error
+	putErr error
+	key    *datastore.Key
+}
+
+func (f *fakeDatastore) Get(key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	t, ok := f.m[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Token) = t
+	return nil
+}
+
+func (f *fakeDatastore) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.m[key.Name] = *src.(*Token)
+	return key, nil
+}
+
+// fakeTx writes directly to the fakeDatastore.
+type fakeTx struct {
+	f *fakeDatastore
+}
+
+func (tx *fakeTx) Get(key *datastore.Key, dst interface{}) error {
+	return tx.f.Get(key, dst)
+}
+
+func (tx *fakeTx) Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error) {
+	_, err := tx.f.Put(context.Background(), key, src)
+	return nil, err
+}
+
+func (f *fakeDatastore) RunInTransaction(ctx context.Context, fn func(tx Transaction) error) error {
+	return fn(&fakeTx{f: f})
+}
+
+func TestStore(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Token{}}
+	s := NewStore(ds, "test")
+	ctx := context.Background()
+
+	token, err := s.Mint(ctx, "mlab", time.Hour)
+	if err != nil {
+		t.Fatalf("Mint() returned error: %v", err)
+	}
+	if ds.key.Namespace != "test" || ds.key.Kind != Kind {
+		t.Errorf("Mint() used wrong key; got %v", ds.key)
+	}
+	if ds.key.Name == token {
+		t.Errorf("Mint() saved the token; want hash")
+	}
+	if _, err := s.Redeem(ctx, "unknown"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem() returned wrong error; got %v, want %v", err, ErrInvalidToken)
+	}
+	org, err := s.Redeem(ctx, token)
+	if err != nil || org != "mlab" {
+		t.Errorf("Redeem() = %q, %v; want %q, nil", org, err, "mlab")
+	}
+	if _, err := s.Redeem(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem() used token returned wrong error; got %v, want %v", err, ErrInvalidToken)
+	}
+	if err := s.Release(ctx, token); err != nil {
+		t.Errorf("Release() returned error: %v", err)
+	}
+	if org, err := s.Redeem(ctx, token); err != nil || org != "mlab" {
+		t.Errorf("Redeem() released token = %q, %v; want %q, nil", org, err, "mlab")
+	}
+
+	expired, err := s.Mint(ctx, "mlab", -time.Second)
+	if err != nil {
+		t.Fatalf("Mint() returned error: %v", err)
+	}
+	if _, err := s.Redeem(ctx, expired); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem() expired token returned wrong error; got %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestStore_Errors(t *testing.T) {
+	ctx := context.Background()
+	ds := &fakeDatastore{m: map[string]Token{}, putErr: errors.New("fake put error")}
+	s := NewStore(ds, "test")
+	if _, err := s.Mint(ctx, "mlab", time.Hour); err == nil {
+		t.Errorf("Mint() returned nil error; want put error")
+	}
+	ds = &fakeDatastore{m: map[string]Token{}, getErr: errors.New("fake get error")}
+	s = NewStore(ds, "test")
+	if _, err := s.Redeem(ctx, "abc"); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem() returned wrong error; got %v, want get error", err)
+	}
+	if err := s.Release(ctx, "abc"); err == nil {
+		t.Errorf("Release() returned nil error; want get error")
+	}
+}
@@ -0,0 +1,191 @@
+// Package redisx creates Redis connection pools with authentication, TLS,
+// timeouts and connection limits, and exports metrics of their usage and
+// errors.
+package redisx
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/autojoin/internal/metrics"
+)
+
+// testIdle is how long a connection may be idle before it is tested with a
+// PING when borrowed from a pool. Broken connections are replaced by new ones.
+const testIdle = time.Minute
+
+// Config configures the connections of Redis pools.
+type Config struct {
+	// Password is the AUTH string of the instance. AUTH is disabled if empty.
+	Password string
+	// TLS enables in-transit encryption.
+	TLS bool
+	// CACert is the path of a PEM file with the certificate authorities of
+	// the instance, e.g. the Memorystore server CA. The system roots are used
+	// if empty.
+	CACert string
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MaxIdle is the maximum number of idle connections of each pool.
+	MaxIdle int
+	// MaxActive is the maximum number of connections of each pool. Callers
+	// wait for a connection once the limit is reached. Zero is unlimited.
+	MaxActive int
+	// IdleTimeout is how long idle connections are kept. Zero keeps them
+	// until the pool is closed.
+	IdleTimeout time.Duration
+}
+
+// NewPool returns a pool of connections to database db of the Redis instance
+// at addr. The name labels the metrics of the pool.
+func (c Config) NewPool(name, addr string, db int) (*redis.Pool, error) {
+	opts := []redis.DialOption{
+		redis.DialDatabase(db),
+		redis.DialConnectTimeout(c.DialTimeout),
+		redis.DialReadTimeout(c.ReadTimeout),
+		redis.DialWriteTimeout(c.WriteTimeout),
+	}
+	if c.Password != "" {
+		opts = append(opts, redis.DialPassword(c.Password))
+	}
+	if c.TLS {
+		tc, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, redis.DialUseTLS(true), redis.DialTLSConfig(tc))
+	}
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			rc, err := redis.Dial("tcp", addr, opts...)
+			if err != nil {
+				metrics.RedisDialErrors.WithLabelValues(name).Inc()
+				return nil, err
+			}
+			return &conn{Conn: rc, pool: name}, nil
+		},
+		TestOnBorrow: func(rc redis.Conn, t time.Time) error {
+			if time.Since(t) < testIdle {
+				return nil
+			}
+			_, err := rc.Do("PING")
+			return err
+		},
+		MaxIdle:     c.MaxIdle,
+		MaxActive:   c.MaxActive,
+		IdleTimeout: c.IdleTimeout,
+		Wait:        true,
+	}, nil
+}
+
+// tlsConfig returns the TLS configuration of connections. The server name is
+// set from the address by redis.Dial.
+func (c Config) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.CACert == "" {
+		return tc, nil
+	}
+	b, err := os.ReadFile(c.CACert)
+	if err != nil {
+		return nil, err
+	}
+	tc.RootCAs = x509.NewCertPool()
+	if !tc.RootCAs.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates found in " + c.CACert)
+	}
+	return tc, nil
+}
+
+// Monitor periodically exports the connection counts and waits of the named
+// pools as metrics. It returns when the context is canceled.
+func Monitor(ctx context.Context, pools map[string]*redis.Pool, interval time.Duration) {
+	waits := map[string]int64{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for name, p := range pools {
+				waits[name] = export(name, p.Stats(), waits[name])
+			}
+		}
+	}
+}
+
+// export sets the metrics of the named pool from its stats, given the wait
+// count of the last export, and returns the current wait count.
+func export(name string, s redis.PoolStats, waits int64) int64 {
+	metrics.RedisPoolConnections.WithLabelValues(name, "in_use").Set(float64(s.ActiveCount - s.IdleCount))
+	metrics.RedisPoolConnections.WithLabelValues(name, "idle").Set(float64(s.IdleCount))
+	if s.WaitCount > waits {
+		metrics.RedisPoolWaits.WithLabelValues(name).Add(float64(s.WaitCount - waits))
+	}
+	return s.WaitCount
+}
+
+// conn counts the errors of the commands of a connection. It supports the
+// timeouts and contexts of the connection it wraps.
+type conn struct {
+	redis.Conn
+	pool string
+}
+
+func (c *conn) count(cmd string, err error) {
+	// The pool sends an empty command to flush connections it takes back.
+	if err != nil && cmd != "" {
+		metrics.RedisCommandErrors.WithLabelValues(c.pool, strings.ToUpper(cmd)).Inc()
+	}
+}
+
+func (c *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	c.count(cmd, err)
+	return reply, err
+}
+
+func (c *conn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := redis.DoContext(c.Conn, ctx, cmd, args...)
+	c.count(cmd, err)
+	return reply, err
+}
+
+func (c *conn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	c.count(cmd, err)
+	return reply, err
+}
+
+func (c *conn) Send(cmd string, args ...interface{}) error {
+	err := c.Conn.Send(cmd, args...)
+	c.count(cmd, err)
+	return err
+}
+
+func (c *conn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	c.count("RECEIVE", err)
+	return reply, err
+}
+
+func (c *conn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	reply, err := redis.ReceiveContext(c.Conn, ctx)
+	c.count("RECEIVE", err)
+	return reply, err
+}
+
+func (c *conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	reply, err := redis.ReceiveWithTimeout(c.Conn, timeout)
+	c.count("RECEIVE", err)
+	return reply, err
+}
@@ -0,0 +1,116 @@
+package redisx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeConn fails every command with err.
+type fakeConn struct {
+	err error
+}
+
+func (c *fakeConn) Close() error                                            { return nil }
+func (c *fakeConn) Err() error                                              { return nil }
+func (c *fakeConn) Send(cmd string, args ...interface{}) error              { return c.err }
+func (c *fakeConn) Flush() error                                            { return nil }
+func (c *fakeConn) Receive() (interface{}, error)                           { return nil, c.err }
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) { return nil, c.err }
+
+func TestConfig_NewPool(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "success", cfg: Config{Password: "secret", DialTimeout: time.Second}},
+		{name: "success-tls", cfg: Config{TLS: true}},
+		{name: "error-missing-ca", cfg: Config{TLS: true, CACert: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "error-invalid-ca", cfg: Config{TLS: true, CACert: invalid}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.cfg.NewPool("test", "127.0.0.1:6379", 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPool() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && !p.Wait {
+				t.Errorf("NewPool() returned pool that does not wait for connections")
+			}
+		})
+	}
+}
+
+func TestConfig_NewPool_dialError(t *testing.T) {
+	// Nothing listens on port 1.
+	p, err := Config{DialTimeout: time.Second}.NewPool("dial-test", "127.0.0.1:1", 0)
+	if err != nil {
+		t.Fatalf("NewPool() failed: %v", err)
+	}
+	conn := p.Get()
+	defer conn.Close()
+	if conn.Err() == nil {
+		t.Fatalf("Get() returned connection without error")
+	}
+	if got := testutil.ToFloat64(metrics.RedisDialErrors.WithLabelValues("dial-test")); got != 1 {
+		t.Errorf("RedisDialErrors = %f, want 1", got)
+	}
+}
+
+func Test_conn(t *testing.T) {
+	c := &conn{Conn: &fakeConn{err: errors.New("fake error")}, pool: "conn-test"}
+	c.Do("get", "foo")
+	c.Send("SET", "foo", "bar")
+	c.Receive()
+	// Connections without timeouts or contexts return errors.
+	redis.DoContext(c, context.Background(), "GET", "foo")
+	redis.ReceiveWithTimeout(c, time.Second)
+	// Flushes by the pool are not counted.
+	c.Do("")
+
+	for cmd, want := range map[string]float64{"GET": 2, "SET": 1, "RECEIVE": 2} {
+		if got := testutil.ToFloat64(metrics.RedisCommandErrors.WithLabelValues("conn-test", cmd)); got != want {
+			t.Errorf("RedisCommandErrors(%s) = %f, want %f", cmd, got, want)
+		}
+	}
+	if n := testutil.CollectAndCount(metrics.RedisCommandErrors); n != 3 {
+		t.Errorf("RedisCommandErrors has %d series, want 3", n)
+	}
+
+	// Successful commands are not counted.
+	ok := &conn{Conn: &fakeConn{}, pool: "conn-test"}
+	ok.Do("GET", "foo")
+	if got := testutil.ToFloat64(metrics.RedisCommandErrors.WithLabelValues("conn-test", "GET")); got != 2 {
+		t.Errorf("RedisCommandErrors(GET) after success = %f, want 2", got)
+	}
+}
+
+func Test_export(t *testing.T) {
+	waits := export("export-test", redis.PoolStats{ActiveCount: 5, IdleCount: 2, WaitCount: 3}, 0)
+	waits = export("export-test", redis.PoolStats{ActiveCount: 4, IdleCount: 4, WaitCount: 7}, waits)
+	if waits != 7 {
+		t.Errorf("export() = %d, want 7", waits)
+	}
+	if got := testutil.ToFloat64(metrics.RedisPoolWaits.WithLabelValues("export-test")); got != 7 {
+		t.Errorf("RedisPoolWaits = %f, want 7", got)
+	}
+	if got := testutil.ToFloat64(metrics.RedisPoolConnections.WithLabelValues("export-test", "in_use")); got != 0 {
+		t.Errorf("RedisPoolConnections(in_use) = %f, want 0", got)
+	}
+	if got := testutil.ToFloat64(metrics.RedisPoolConnections.WithLabelValues("export-test", "idle")); got != 4 {
+		t.Errorf("RedisPoolConnections(idle) = %f, want 4", got)
+	}
+}
@@ -4,32 +4,56 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"reflect"
 	"strings"
 
 	v0 "github.com/m-lab/autojoin/api/v0"
 	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/geonames"
 	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/uuid-annotator/annotator"
 	"github.com/oschwald/geoip2-golang"
 )
 
-var (
-	mlabDomain = "measurement-lab.org"
-)
-
-// Params is used internally to collect multiple parameters.
+// Params is used internally to collect multiple parameters. The handler
+// parses and validates them from register requests before calling
+// CreateRegisterResponse, which is the only place hostnames and annotations
+// of registrations are generated.
 type Params struct {
 	Project     string
+	Domain      string
 	Service     string
 	Org         string
 	IPv4        string
 	IPv6        string
 	Geo         *geoip2.City
 	Metro       iata.Row
+	Place       *geonames.Place
+	Location    *Location
 	Network     *annotator.Network
+	Traits      v0.IPTraits
 	Probability float64
 	Type        string
 	Uplink      string
+	// Cloud is the optional hosting of nodes of type "cloud".
+	Cloud v0.Cloud
+
+	// Services are the other services of the node, each with an alias of
+	// the hostname.
+	Services []string
+
+	// DNSTTL is the TTL in seconds of the DNS records of the node. Zero uses
+	// the TTL of the organization.
+	DNSTTL int64
+}
+
+// Location is the location of a node given by its operator. The handler
+// checks that it is near the metro of the node.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	// City is optional.
+	City string
 }
 
 // CreateRegisterResponse generates a RegisterResponse from the given
@@ -39,7 +63,11 @@ func CreateRegisterResponse(p *Params) v0.RegisterResponse {
 	// Calculate machine, site, and hostname.
 	machine := hex.EncodeToString(net.ParseIP(p.IPv4).To4())
 	site := fmt.Sprintf("%s%d", p.Metro.IATA, p.Network.ASNumber)
-	hostname := fmt.Sprintf("%s-%s-%s.%s.%s.%s", p.Service, site, machine, p.Org, strings.TrimPrefix(p.Project, "mlab-"), mlabDomain)
+	hostname := fmt.Sprintf("%s-%s-%s.%s.%s.%s", p.Service, site, machine, p.Org, strings.TrimPrefix(p.Project, "mlab-"), p.Domain)
+	var aliases []string
+	for _, service := range p.Services {
+		aliases = append(aliases, fmt.Sprintf("%s-%s-%s.%s.%s.%s", service, site, machine, p.Org, strings.TrimPrefix(p.Project, "mlab-"), p.Domain))
+	}
 
 	// Using these, create geo annotation.
 	geo := &annotator.Geolocation{
@@ -61,6 +89,23 @@ func CreateRegisterResponse(p *Params) v0.RegisterResponse {
 			geo.Subdivision2Name = p.Geo.Subdivisions[1].Names["en"]
 		}
 	}
+	// Fill in the names Maxmind does not know from the city nearest the metro.
+	if p.Place != nil {
+		if geo.City == "" {
+			geo.City = p.Place.City
+		}
+		if geo.Subdivision1Name == "" {
+			geo.Subdivision1Name = p.Place.Admin1
+		}
+	}
+	// Operators know the location of their nodes better than Maxmind.
+	if p.Location != nil {
+		geo.Latitude = p.Location.Latitude
+		geo.Longitude = p.Location.Longitude
+		if p.Location.City != "" {
+			geo.City = p.Location.City
+		}
+	}
 
 	// A v0.Network must contain a valid CIDR, so we convert the v4/v6
 	// addresses to a /32 or a /128 here.
@@ -74,6 +119,7 @@ func CreateRegisterResponse(p *Params) v0.RegisterResponse {
 	r := v0.RegisterResponse{
 		Registration: &v0.Registration{
 			Hostname: hostname,
+			Aliases:  aliases,
 			Annotation: &v0.ServerAnnotation{
 				Annotation: annotator.ServerAnnotations{
 					Site:    site,
@@ -85,7 +131,8 @@ func CreateRegisterResponse(p *Params) v0.RegisterResponse {
 					IPv4: ipv4CIDR,
 					IPv6: ipv6CIDR,
 				},
-				Type: "unknown", // should be overridden by node.
+				Type:             "unknown", // should be overridden by node.
+				OperatorLocation: p.Location != nil,
 			},
 			Heartbeat: &v2.Registration{
 				City:          geo.City,
@@ -105,5 +152,38 @@ func CreateRegisterResponse(p *Params) v0.RegisterResponse {
 			},
 		},
 	}
+	if p.Traits != (v0.IPTraits{}) {
+		traits := p.Traits
+		r.Registration.Annotation.Traits = &traits
+	}
+	if p.Type == "cloud" {
+		// Unlike physical and virtual machines, cloud hosting is known
+		// from the registration, so nodes need not override the type.
+		r.Registration.Annotation.Type = p.Type
+		if p.Cloud != (v0.Cloud{}) {
+			cloud := p.Cloud
+			r.Registration.Annotation.Cloud = &cloud
+		}
+	}
 	return r
 }
+
+// Diff compares a node's local heartbeat registration with the registration
+// the server would generate now, and returns every field that differs.
+func Diff(local, server *v2.Registration) []v0.FieldDiff {
+	diffs := []v0.FieldDiff{}
+	lv := reflect.ValueOf(*local)
+	sv := reflect.ValueOf(*server)
+	for i := 0; i < lv.NumField(); i++ {
+		l, s := lv.Field(i).Interface(), sv.Field(i).Interface()
+		if reflect.DeepEqual(l, s) {
+			continue
+		}
+		diffs = append(diffs, v0.FieldDiff{
+			Field:  lv.Type().Field(i).Name,
+			Local:  fmt.Sprint(l),
+			Server: fmt.Sprint(s),
+		})
+	}
+	return diffs
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/go-test/deep"
 	v0 "github.com/m-lab/autojoin/api/v0"
 	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/geonames"
 	v2 "github.com/m-lab/locate/api/v2"
 	"github.com/m-lab/uuid-annotator/annotator"
 	"github.com/oschwald/geoip2-golang"
@@ -22,6 +23,7 @@ func TestCreateRegisterResponse(t *testing.T) {
 			name: "success",
 			p: &Params{
 				Project: "mlab-sandbox",
+				Domain:  "measurement-lab.org",
 				Service: "ndt",
 				Org:     "bar",
 				IPv4:    "192.168.0.1",
@@ -62,6 +64,7 @@ func TestCreateRegisterResponse(t *testing.T) {
 				Network: &annotator.Network{
 					ASNumber: 12345,
 				},
+				Traits:      v0.IPTraits{Hosting: true},
 				Probability: 1.0,
 				Type:        "physical",
 				Uplink:      "10g",
@@ -90,7 +93,8 @@ func TestCreateRegisterResponse(t *testing.T) {
 							IPv4: "192.168.0.1/32",
 							IPv6: "::1/128",
 						},
-						Type: "unknown",
+						Type:   "unknown",
+						Traits: &v0.IPTraits{Hosting: true},
 					},
 					Heartbeat: &v2.Registration{
 						CountryCode: "US",
@@ -109,6 +113,194 @@ func TestCreateRegisterResponse(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "success-place",
+			p: &Params{
+				Project: "mlab-sandbox",
+				Domain:  "measurement-lab.org",
+				Service: "ndt",
+				Org:     "bar",
+				IPv4:    "192.168.0.1",
+				Geo:     &geoip2.City{},
+				Metro: iata.Row{
+					IATA:      "lga",
+					Latitude:  40.7769,
+					Longitude: -73.8740,
+				},
+				Place: &geonames.Place{
+					City:        "New York City",
+					CountryCode: "US",
+					Admin1:      "New York",
+				},
+				Network: &annotator.Network{
+					ASNumber: 12345,
+				},
+				Probability: 1.0,
+				Type:        "physical",
+			},
+			want: v0.RegisterResponse{
+				Registration: &v0.Registration{
+					Hostname: "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+					Annotation: &v0.ServerAnnotation{
+						Annotation: annotator.ServerAnnotations{
+							Site:    "lga12345",
+							Machine: "c0a80001",
+							Geo: &annotator.Geolocation{
+								City:             "New York City",
+								Subdivision1Name: "New York",
+								Latitude:         40.7769,
+								Longitude:        -73.8740,
+							},
+							Network: &annotator.Network{
+								ASNumber: 12345,
+							},
+						},
+						Network: v0.Network{
+							IPv4: "192.168.0.1/32",
+						},
+						Type: "unknown",
+					},
+					Heartbeat: &v2.Registration{
+						City:        "New York City",
+						Experiment:  "ndt",
+						Hostname:    "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+						Latitude:    40.7769,
+						Longitude:   -73.8740,
+						Machine:     "c0a80001",
+						Metro:       "lga",
+						Project:     "mlab-sandbox",
+						Probability: 1,
+						Site:        "lga12345",
+						Type:        "physical",
+					},
+				},
+			},
+		},
+		{
+			name: "success-location",
+			p: &Params{
+				Project: "mlab-sandbox",
+				Domain:  "measurement-lab.org",
+				Service: "ndt",
+				Org:     "bar",
+				IPv4:    "192.168.0.1",
+				Geo:     &geoip2.City{},
+				Metro: iata.Row{
+					IATA:      "lga",
+					Latitude:  40.7769,
+					Longitude: -73.8740,
+				},
+				Place: &geonames.Place{
+					City:   "New York City",
+					Admin1: "New York",
+				},
+				Location: &Location{
+					Latitude:  40.7433,
+					Longitude: -73.9196,
+					City:      "Queens",
+				},
+				Network: &annotator.Network{
+					ASNumber: 12345,
+				},
+				Probability: 1.0,
+				Type:        "physical",
+			},
+			want: v0.RegisterResponse{
+				Registration: &v0.Registration{
+					Hostname: "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+					Annotation: &v0.ServerAnnotation{
+						Annotation: annotator.ServerAnnotations{
+							Site:    "lga12345",
+							Machine: "c0a80001",
+							Geo: &annotator.Geolocation{
+								City:             "Queens",
+								Subdivision1Name: "New York",
+								Latitude:         40.7433,
+								Longitude:        -73.9196,
+							},
+							Network: &annotator.Network{
+								ASNumber: 12345,
+							},
+						},
+						Network: v0.Network{
+							IPv4: "192.168.0.1/32",
+						},
+						Type:             "unknown",
+						OperatorLocation: true,
+					},
+					Heartbeat: &v2.Registration{
+						City:        "Queens",
+						Experiment:  "ndt",
+						Hostname:    "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+						Latitude:    40.7433,
+						Longitude:   -73.9196,
+						Machine:     "c0a80001",
+						Metro:       "lga",
+						Project:     "mlab-sandbox",
+						Probability: 1,
+						Site:        "lga12345",
+						Type:        "physical",
+					},
+				},
+			},
+		},
+		{
+			name: "success-cloud",
+			p: &Params{
+				Project: "mlab-sandbox",
+				Domain:  "measurement-lab.org",
+				Service: "ndt",
+				Org:     "bar",
+				IPv4:    "192.168.0.1",
+				Geo:     &geoip2.City{},
+				Metro: iata.Row{
+					IATA:      "lga",
+					Latitude:  40.7769,
+					Longitude: -73.8740,
+				},
+				Network: &annotator.Network{
+					ASNumber: 12345,
+				},
+				Probability: 1.0,
+				Type:        "cloud",
+				Cloud:       v0.Cloud{Provider: "gcp", Region: "us-east1"},
+			},
+			want: v0.RegisterResponse{
+				Registration: &v0.Registration{
+					Hostname: "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+					Annotation: &v0.ServerAnnotation{
+						Annotation: annotator.ServerAnnotations{
+							Site:    "lga12345",
+							Machine: "c0a80001",
+							Geo: &annotator.Geolocation{
+								Latitude:  40.7769,
+								Longitude: -73.8740,
+							},
+							Network: &annotator.Network{
+								ASNumber: 12345,
+							},
+						},
+						Network: v0.Network{
+							IPv4: "192.168.0.1/32",
+						},
+						Type:  "cloud",
+						Cloud: &v0.Cloud{Provider: "gcp", Region: "us-east1"},
+					},
+					Heartbeat: &v2.Registration{
+						Experiment:  "ndt",
+						Hostname:    "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+						Latitude:    40.7769,
+						Longitude:   -73.8740,
+						Machine:     "c0a80001",
+						Metro:       "lga",
+						Project:     "mlab-sandbox",
+						Probability: 1,
+						Site:        "lga12345",
+						Type:        "cloud",
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -119,3 +311,59 @@ func TestCreateRegisterResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestDiff(t *testing.T) {
+	server := &v2.Registration{
+		City:        "New York",
+		Hostname:    "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+		Latitude:    -10,
+		Probability: 1,
+		Services:    map[string][]string{"ndt/ndt7": {"ws:///ndt/v7/download"}},
+	}
+	tests := []struct {
+		name  string
+		local v2.Registration
+		want  []v0.FieldDiff
+	}{
+		{
+			name:  "success-equal",
+			local: *server,
+			want:  []v0.FieldDiff{},
+		},
+		{
+			name: "success-changed",
+			local: v2.Registration{
+				City:        "Newark",
+				Hostname:    "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+				Latitude:    -11,
+				Probability: 1,
+				Services:    map[string][]string{"ndt/ndt7": {"ws:///ndt/v7/download"}},
+			},
+			want: []v0.FieldDiff{
+				{Field: "City", Local: "Newark", Server: "New York"},
+				{Field: "Latitude", Local: "-11", Server: "-10"},
+			},
+		},
+		{
+			name: "success-services",
+			local: v2.Registration{
+				City:        "New York",
+				Hostname:    "ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+				Latitude:    -10,
+				Probability: 0.5,
+			},
+			want: []v0.FieldDiff{
+				{Field: "Probability", Local: "0.5", Server: "1"},
+				{Field: "Services", Local: "map[]", Server: "map[ndt/ndt7:[ws:///ndt/v7/download]]"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(&tt.local, server)
+			if diff := deep.Equal(got, tt.want); diff != nil {
+				t.Errorf("Diff() returned != expected: \n%s", strings.Join(diff, "\n"))
+			}
+		})
+	}
+}
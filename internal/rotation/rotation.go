@@ -0,0 +1,133 @@
+// Package rotation periodically replaces the service account keys of
+// organizations with registered nodes.
+package rotation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/host"
+)
+
+// Rotator replaces the stored service account key of an organization.
+type Rotator interface {
+	Age(ctx context.Context, org string) (time.Duration, error)
+	Rotate(ctx context.Context, org string) error
+}
+
+// Lister lists the registered hostnames.
+type Lister interface {
+	List() ([]string, []tracker.Status, error)
+}
+
+// Notifier notifies organizations that their key was rotated.
+type Notifier interface {
+	KeyRotated(ctx context.Context, org string) error
+}
+
+// Manager rotates the keys of organizations with registered nodes once they
+// are older than the rotation interval.
+//
+// NOTE: every instance of the Autojoin API may run a Manager. Keys are only
+// rotated when older than the interval, so concurrent instances rarely
+// rotate the same key twice, and a duplicate rotation is harmless.
+type Manager struct {
+	r        Rotator
+	l        Lister
+	interval time.Duration
+	notifier Notifier
+}
+
+// NewManager creates a new Manager that rotates keys older than interval.
+func NewManager(r Rotator, l Lister, interval time.Duration) *Manager {
+	return &Manager{r: r, l: l, interval: interval}
+}
+
+// NotifyWith notifies organizations after their key is rotated. Failed
+// notifications are logged and do not fail the rotation.
+func (m *Manager) NotifyWith(n Notifier) {
+	m.notifier = n
+}
+
+// Run checks the age of every organization key each check interval until ctx
+// is canceled.
+func (m *Manager) Run(ctx context.Context, check time.Duration) error {
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := m.RotateExpired(ctx); err != nil {
+				log.Println("failed to check service account keys:", err)
+			}
+		}
+	}
+}
+
+// RotateExpired rotates the keys of organizations with registered nodes that
+// are older than the rotation interval. Organizations without a stored key
+// are skipped.
+func (m *Manager) RotateExpired(ctx context.Context) error {
+	hosts, _, err := m.l.List()
+	if err != nil {
+		return err
+	}
+	for _, org := range orgs(hosts) {
+		age, err := m.r.Age(ctx, org)
+		if errors.Is(err, adminx.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to read key age of %s: %v", org, err)
+			continue
+		}
+		if age < m.interval {
+			continue
+		}
+		if err := m.Rotate(ctx, org); err != nil {
+			log.Printf("Failed to rotate key of %s: %v", org, err)
+		}
+	}
+	return nil
+}
+
+// Rotate rotates the key of org now.
+func (m *Manager) Rotate(ctx context.Context, org string) error {
+	err := m.r.Rotate(ctx, org)
+	if err != nil {
+		metrics.KeyRotations.WithLabelValues("error").Inc()
+		return err
+	}
+	metrics.KeyRotations.WithLabelValues("success").Inc()
+	log.Printf("Rotated service account key of %s", org)
+	if m.notifier != nil {
+		if err := m.notifier.KeyRotated(ctx, org); err != nil {
+			log.Printf("Failed to notify %s of key rotation: %v", org, err)
+		}
+	}
+	return nil
+}
+
+// orgs returns the sorted, unique organizations of the given hostnames.
+func orgs(hosts []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, h := range hosts {
+		name, err := host.Parse(h)
+		if err != nil || name.Org == "" || seen[name.Org] {
+			continue
+		}
+		seen[name.Org] = true
+		result = append(result, name.Org)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,165 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeRotator struct {
+	ages      map[string]time.Duration
+	ageErr    error
+	rotateErr error
+	rotated   []string
+}
+
+func (f *fakeRotator) Age(ctx context.Context, org string) (time.Duration, error) {
+	if f.ageErr != nil {
+		return 0, f.ageErr
+	}
+	age, ok := f.ages[org]
+	if !ok {
+		return 0, adminx.ErrKeyNotFound
+	}
+	return age, nil
+}
+
+func (f *fakeRotator) Rotate(ctx context.Context, org string) error {
+	f.rotated = append(f.rotated, org)
+	return f.rotateErr
+}
+
+type fakeLister struct {
+	hosts []string
+	err   error
+}
+
+func (f *fakeLister) List() ([]string, []tracker.Status, error) {
+	return f.hosts, nil, f.err
+}
+
+func TestManager_RotateExpired(t *testing.T) {
+	hosts := []string{
+		"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org",
+		"ndt-lga12345-c0a80002.foo.sandbox.measurement-lab.org",
+		"ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org",
+		"ndt-lga12345-c0a80001.baz.sandbox.measurement-lab.org",
+		"invalid",
+	}
+	tests := []struct {
+		name        string
+		r           *fakeRotator
+		l           *fakeLister
+		wantRotated []string
+		wantErr     bool
+	}{
+		{
+			name: "success",
+			r: &fakeRotator{ages: map[string]time.Duration{
+				"foo": 48 * time.Hour,
+				"bar": time.Hour,
+			}},
+			l:           &fakeLister{hosts: hosts},
+			wantRotated: []string{"foo"},
+		},
+		{
+			name: "success-rotate-error",
+			r: &fakeRotator{
+				ages:      map[string]time.Duration{"foo": 48 * time.Hour, "bar": 48 * time.Hour},
+				rotateErr: errors.New("fake rotate error"),
+			},
+			l:           &fakeLister{hosts: hosts},
+			wantRotated: []string{"bar", "foo"},
+		},
+		{
+			name: "success-age-error",
+			r:    &fakeRotator{ageErr: errors.New("fake age error")},
+			l:    &fakeLister{hosts: hosts},
+		},
+		{
+			name:    "error-list",
+			r:       &fakeRotator{},
+			l:       &fakeLister{err: errors.New("fake list error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(tt.r, tt.l, 24*time.Hour)
+			err := m.RotateExpired(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RotateExpired() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.r.rotated, tt.wantRotated) {
+				t.Errorf("RotateExpired() rotated wrong orgs; got %v, want %v", tt.r.rotated, tt.wantRotated)
+			}
+		})
+	}
+}
+
+func TestManager_Rotate(t *testing.T) {
+	success := testutil.ToFloat64(metrics.KeyRotations.WithLabelValues("success"))
+	failure := testutil.ToFloat64(metrics.KeyRotations.WithLabelValues("error"))
+	r := &fakeRotator{}
+	m := NewManager(r, &fakeLister{}, time.Hour)
+	if err := m.Rotate(context.Background(), "foo"); err != nil {
+		t.Errorf("Rotate() returned error: %v", err)
+	}
+	r.rotateErr = errors.New("fake rotate error")
+	if err := m.Rotate(context.Background(), "foo"); err == nil {
+		t.Errorf("Rotate() returned nil error; want error")
+	}
+	if got := testutil.ToFloat64(metrics.KeyRotations.WithLabelValues("success")) - success; got != 1 {
+		t.Errorf("Rotate() counted wrong successes; got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.KeyRotations.WithLabelValues("error")) - failure; got != 1 {
+		t.Errorf("Rotate() counted wrong errors; got %v, want 1", got)
+	}
+}
+
+type fakeNotifier struct {
+	orgs []string
+	err  error
+}
+
+func (f *fakeNotifier) KeyRotated(ctx context.Context, org string) error {
+	f.orgs = append(f.orgs, org)
+	return f.err
+}
+
+func TestManager_NotifyWith(t *testing.T) {
+	r := &fakeRotator{}
+	n := &fakeNotifier{err: errors.New("fake notify error")}
+	m := NewManager(r, &fakeLister{}, time.Hour)
+	m.NotifyWith(n)
+	if err := m.Rotate(context.Background(), "foo"); err != nil {
+		t.Errorf("Rotate() returned error: %v", err)
+	}
+	r.rotateErr = errors.New("fake rotate error")
+	if err := m.Rotate(context.Background(), "bar"); err == nil {
+		t.Errorf("Rotate() returned nil error; want error")
+	}
+	if !reflect.DeepEqual(n.orgs, []string{"foo"}) {
+		t.Errorf("Rotate() notified wrong orgs; got %v, want [foo]", n.orgs)
+	}
+}
+
+func TestManager_Run(t *testing.T) {
+	r := &fakeRotator{ages: map[string]time.Duration{"foo": 48 * time.Hour}}
+	m := NewManager(r, &fakeLister{hosts: []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org"}}, 24*time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Run(ctx, 10*time.Millisecond); err != nil {
+		t.Errorf("Run() returned error: %v", err)
+	}
+	if len(r.rotated) == 0 {
+		t.Errorf("Run() did not rotate keys")
+	}
+}
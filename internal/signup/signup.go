@@ -0,0 +1,194 @@
+// Package signup records applications of new organizations, which operators
+// approve or reject before the organization is created.
+package signup
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Kind is the Datastore kind of organization application entities.
+const Kind = "OrgApplication"
+
+// Application status values.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+var (
+	// ErrExists is returned when applying for an organization with a pending
+	// or approved application.
+	ErrExists = errors.New("organization application already exists")
+	// ErrNotFound is returned when reviewing an unknown application.
+	ErrNotFound = errors.New("organization application not found")
+	// ErrNotPending is returned when reviewing an application that was
+	// approved or rejected already.
+	ErrNotPending = errors.New("organization application is not pending")
+)
+
+// Application is the entity saved for an organization application. The
+// Datastore name of the entity is the organization name.
+type Application struct {
+	Org   string `datastore:"-"`
+	Email string `datastore:",noindex"`
+	ASN   int64  `datastore:",noindex"`
+	// Nodes is the number of nodes the organization expects to register.
+	Nodes   int64 `datastore:",noindex"`
+	Status  string
+	Created time.Time `datastore:",noindex"`
+	// Reviewed is the time the application was approved or rejected, by
+	// Reviewer. Reason explains a rejection.
+	Reviewed time.Time `datastore:",noindex"`
+	Reviewer string    `datastore:",noindex"`
+	Reason   string    `datastore:",noindex"`
+}
+
+// Transaction is the subset of a Datastore transaction used to change
+// applications. It is implemented by *datastore.Transaction.
+type Transaction interface {
+	Get(key *datastore.Key, dst interface{}) error
+	Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error)
+}
+
+// Datastore is the subset of the Datastore client used to persist
+// applications.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+	RunInTransaction(ctx context.Context, f func(tx Transaction) error) error
+}
+
+// client adapts a *datastore.Client to the Datastore interface.
+type client struct {
+	c *datastore.Client
+}
+
+// NewDatastore returns a Datastore using the given client.
+func NewDatastore(c *datastore.Client) Datastore {
+	return &client{c: c}
+}
+
+func (c *client) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	return c.c.Get(ctx, key, dst)
+}
+
+func (c *client) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return c.c.GetAll(ctx, q, dst)
+}
+
+func (c *client) RunInTransaction(ctx context.Context, f func(tx Transaction) error) error {
+	_, err := c.c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(tx)
+	})
+	return err
+}
+
+// Store saves and reviews organization applications.
+type Store struct {
+	ds        Datastore
+	namespace string
+}
+
+// NewStore creates a new Store that saves applications in the given
+// Datastore namespace.
+func NewStore(ds Datastore, namespace string) *Store {
+	return &Store{ds: ds, namespace: namespace}
+}
+
+// Submit saves a pending application. Organizations with a pending or
+// approved application return ErrExists. Rejected organizations may apply
+// again.
+func (s *Store) Submit(ctx context.Context, a *Application) error {
+	return s.ds.RunInTransaction(ctx, func(tx Transaction) error {
+		curr := &Application{}
+		err := tx.Get(s.key(a.Org), curr)
+		switch {
+		case errors.Is(err, datastore.ErrNoSuchEntity):
+		case err != nil:
+			return err
+		case curr.Status != StatusRejected:
+			return ErrExists
+		}
+		a.Status = StatusPending
+		a.Created = time.Now().UTC()
+		a.Reviewed, a.Reviewer, a.Reason = time.Time{}, "", ""
+		_, err = tx.Put(s.key(a.Org), a)
+		return err
+	})
+}
+
+// Get returns the application of org, or ErrNotFound.
+func (s *Store) Get(ctx context.Context, org string) (*Application, error) {
+	a := &Application{}
+	err := s.ds.Get(ctx, s.key(org), a)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.Org = org
+	return a, nil
+}
+
+// List returns the applications with the given status, or all applications
+// if status is empty, oldest first.
+func (s *Store) List(ctx context.Context, status string) ([]*Application, error) {
+	q := datastore.NewQuery(Kind).Namespace(s.namespace)
+	if status != "" {
+		q = q.FilterField("Status", "=", status)
+	}
+	l := []*Application{}
+	keys, err := s.ds.GetAll(ctx, q, &l)
+	if err != nil {
+		return nil, err
+	}
+	for i := range l {
+		l[i].Org = keys[i].Name
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Created.Before(l[j].Created)
+	})
+	return l, nil
+}
+
+// Review approves or rejects the pending application of org, and returns the
+// reviewed application. Status must be StatusApproved or StatusRejected.
+func (s *Store) Review(ctx context.Context, org, status, reviewer, reason string) (*Application, error) {
+	a := &Application{}
+	err := s.ds.RunInTransaction(ctx, func(tx Transaction) error {
+		err := tx.Get(s.key(org), a)
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if a.Status != StatusPending {
+			return ErrNotPending
+		}
+		a.Status = status
+		a.Reviewed = time.Now().UTC()
+		a.Reviewer = reviewer
+		a.Reason = reason
+		_, err = tx.Put(s.key(org), a)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.Org = org
+	return a, nil
+}
+
+func (s *Store) key(org string) *datastore.Key {
+	k := datastore.NameKey(Kind, org, nil)
+	k.Namespace = s.namespace
+	return k
+}
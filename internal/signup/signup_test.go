@@ -0,0 +1,146 @@
+package signup
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+type fakeDatastore struct {
+	m      map[string]Application
+	getErr error
+	putErr error
+	key    *datastore.Key
+}
+
+func (f *fakeDatastore) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	f.key = key
+	if f.getErr != nil {
+		return f.getErr
+	}
+	a, ok := f.m[key.Name]
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	*dst.(*Application) = a
+	return nil
+}
+
+func (f *fakeDatastore) Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error) {
+	f.key = key
+	if f.putErr != nil {
+		return nil, f.putErr
+	}
+	f.m[key.Name] = *src.(*Application)
+	return nil, nil
+}
+
+func (f *fakeDatastore) RunInTransaction(ctx context.Context, fn func(tx Transaction) error) error {
+	return fn(&fakeTx{f: f})
+}
+
+// fakeTx writes directly to the fakeDatastore.
+type fakeTx struct {
+	f *fakeDatastore
+}
+
+func (tx *fakeTx) Get(key *datastore.Key, dst interface{}) error {
+	return tx.f.Get(context.Background(), key, dst)
+}
+
+func (tx *fakeTx) Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error) {
+	return tx.f.Put(key, src)
+}
+
+// GetAll returns every application, ignoring the query filter.
+func (f *fakeDatastore) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	names := []string{}
+	for name := range f.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	keys := []*datastore.Key{}
+	l := dst.(*[]*Application)
+	for _, name := range names {
+		a := f.m[name]
+		keys = append(keys, datastore.NameKey(Kind, name, nil))
+		*l = append(*l, &a)
+	}
+	return keys, nil
+}
+
+func TestStore(t *testing.T) {
+	ds := &fakeDatastore{m: map[string]Application{}}
+	s := NewStore(ds, "test")
+	ctx := context.Background()
+
+	err := s.Submit(ctx, &Application{Org: "foo", Email: "ops@foo.example", ASN: 64512, Nodes: 3})
+	if err != nil {
+		t.Fatalf("Submit() returned error: %v", err)
+	}
+	if ds.key.Namespace != "test" || ds.key.Kind != Kind || ds.key.Name != "foo" {
+		t.Errorf("Submit() used wrong key; got %v", ds.key)
+	}
+	if a := ds.m["foo"]; a.Status != StatusPending || a.Created.IsZero() {
+		t.Errorf("Submit() saved %+v, want pending", a)
+	}
+	if err := s.Submit(ctx, &Application{Org: "foo"}); !errors.Is(err, ErrExists) {
+		t.Errorf("Submit() returned wrong error; got %v, want %v", err, ErrExists)
+	}
+
+	if a, err := s.Get(ctx, "foo"); err != nil || a.Org != "foo" || a.ASN != 64512 {
+		t.Errorf("Get() = %+v, %v; want foo", a, err)
+	}
+	if _, err := s.Get(ctx, "bar"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+
+	l, err := s.List(ctx, StatusPending)
+	if err != nil || len(l) != 1 || l[0].Org != "foo" {
+		t.Errorf("List() = %v, %v; want foo", l, err)
+	}
+
+	if _, err := s.Review(ctx, "bar", StatusApproved, "key a", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Review() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+	a, err := s.Review(ctx, "foo", StatusRejected, "key a", "unknown network")
+	if err != nil {
+		t.Fatalf("Review() returned error: %v", err)
+	}
+	if a.Org != "foo" || a.Status != StatusRejected || a.Reviewer != "key a" || a.Reason != "unknown network" || a.Reviewed.IsZero() {
+		t.Errorf("Review() = %+v, want rejected", a)
+	}
+	if _, err := s.Review(ctx, "foo", StatusApproved, "key a", ""); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Review() returned wrong error; got %v, want %v", err, ErrNotPending)
+	}
+
+	// Rejected organizations may apply again.
+	if err := s.Submit(ctx, &Application{Org: "foo"}); err != nil {
+		t.Errorf("Submit() returned error: %v", err)
+	}
+	if a := ds.m["foo"]; a.Status != StatusPending || a.Reason != "" {
+		t.Errorf("Submit() saved %+v, want pending", a)
+	}
+
+	ds.getErr = errors.New("fake get error")
+	if err := s.Submit(ctx, &Application{Org: "bar"}); err == nil {
+		t.Errorf("Submit() expected error")
+	}
+	if _, err := s.List(ctx, ""); err == nil {
+		t.Errorf("List() expected error")
+	}
+	if _, err := s.Review(ctx, "foo", StatusApproved, "key a", ""); err == nil {
+		t.Errorf("Review() expected error")
+	}
+	ds.getErr = nil
+	ds.putErr = errors.New("fake put error")
+	if _, err := s.Review(ctx, "foo", StatusApproved, "key a", ""); err == nil {
+		t.Errorf("Review() expected error")
+	}
+}
@@ -0,0 +1,247 @@
+// Package slo defines the service level objectives of the Autojoin API and
+// computes error ratios and burn rates for them from the request handler
+// latency histogram.
+package slo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Kind is the type of SLO.
+type Kind int
+
+const (
+	// Availability SLOs count 5xx responses as bad events.
+	Availability Kind = iota
+	// Latency SLOs count responses slower than the objective threshold as bad events.
+	Latency
+)
+
+// HistogramName is the name of the metric family used to compute SLOs.
+const HistogramName = "autojoin_request_handler_duration"
+
+// ErrNoHistogram is returned when the request handler histogram is not found.
+var ErrNoHistogram = errors.New("request handler histogram not found")
+
+// Objective describes a single SLO for a request handler path.
+type Objective struct {
+	// Name is used as the "slo" label value for all SLO metrics.
+	Name string
+	// Path is the value of the "path" label in the request handler histogram.
+	Path string
+	// Kind determines which events are counted as bad.
+	Kind Kind
+	// Target is the fraction of good events, e.g. 0.995.
+	Target float64
+	// Threshold is the latency bound in seconds for Latency SLOs. It must
+	// equal one of the histogram bucket upper bounds.
+	Threshold float64
+}
+
+// Objectives are the default SLOs for partner facing APIs.
+var Objectives = []Objective{
+	{Name: "register-availability", Path: "/autojoin/v0/node/register", Kind: Availability, Target: 0.995},
+	{Name: "register-latency", Path: "/autojoin/v0/node/register", Kind: Latency, Target: 0.99, Threshold: 2.5},
+	{Name: "list-availability", Path: "/autojoin/v0/node/list", Kind: Availability, Target: 0.999},
+	{Name: "list-latency", Path: "/autojoin/v0/node/list", Kind: Latency, Target: 0.99, Threshold: 1},
+}
+
+// Windows are the default burn rate windows, suitable for multi-window,
+// multi-burn-rate alerts. The longest window is used for the remaining error budget.
+var Windows = []time.Duration{5 * time.Minute, time.Hour, 6 * time.Hour, 3 * 24 * time.Hour}
+
+type counts struct {
+	total float64
+	bad   float64
+}
+
+type sample struct {
+	time   time.Time
+	counts map[string]counts
+}
+
+// Evaluator periodically gathers the request handler histogram and exports
+// error ratio, burn rate, and remaining error budget for each objective.
+type Evaluator struct {
+	gatherer   prometheus.Gatherer
+	objectives []Objective
+	windows    []time.Duration
+
+	mu      sync.Mutex
+	samples []sample
+}
+
+// NewEvaluator creates a new Evaluator for the given objectives and windows.
+func NewEvaluator(g prometheus.Gatherer, objectives []Objective, windows []time.Duration) *Evaluator {
+	for _, o := range objectives {
+		metrics.SLOObjective.WithLabelValues(o.Name).Set(o.Target)
+	}
+	return &Evaluator{
+		gatherer:   g,
+		objectives: objectives,
+		windows:    windows,
+	}
+}
+
+// Run evaluates all objectives every interval until the context is canceled.
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// The histogram is missing until the first request is handled.
+			err := e.Evaluate(now)
+			if err != nil && err != ErrNoHistogram {
+				log.Println("slo evaluation failure:", err)
+			}
+		}
+	}
+}
+
+// Evaluate takes a new snapshot of the request handler histogram and updates
+// the SLO metrics for every objective and window.
+func (e *Evaluator) Evaluate(now time.Time) error {
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	var family *dto.MetricFamily
+	for _, mf := range mfs {
+		if mf.GetName() == HistogramName {
+			family = mf
+			break
+		}
+	}
+	if family == nil {
+		return ErrNoHistogram
+	}
+	cur := sample{time: now, counts: map[string]counts{}}
+	for _, o := range e.objectives {
+		cur.counts[o.Name] = count(family, o)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.samples = append(e.samples, cur)
+	e.prune(now)
+
+	for _, o := range e.objectives {
+		for i, w := range e.windows {
+			ratio := e.errorRatio(o, now.Add(-w), cur)
+			burn := ratio / (1 - o.Target)
+			metrics.SLOErrorRatio.WithLabelValues(o.Name, formatWindow(w)).Set(ratio)
+			metrics.SLOBurnRate.WithLabelValues(o.Name, formatWindow(w)).Set(burn)
+			if i == len(e.windows)-1 {
+				metrics.SLOErrorBudgetRemaining.WithLabelValues(o.Name).Set(1 - burn)
+			}
+		}
+	}
+	return nil
+}
+
+// prune removes samples that are older than the longest window, while always
+// keeping at least one sample at or beyond that boundary.
+func (e *Evaluator) prune(now time.Time) {
+	longest := time.Duration(0)
+	for _, w := range e.windows {
+		if w > longest {
+			longest = w
+		}
+	}
+	start := now.Add(-longest)
+	i := 0
+	for i < len(e.samples)-1 && !e.samples[i+1].time.After(start) {
+		i++
+	}
+	e.samples = e.samples[i:]
+}
+
+// errorRatio returns the fraction of bad events between the oldest sample
+// taken at or after start and the current sample.
+func (e *Evaluator) errorRatio(o Objective, start time.Time, cur sample) float64 {
+	base := cur
+	for _, s := range e.samples {
+		if !s.time.Before(start) {
+			base = s
+			break
+		}
+	}
+	now := cur.counts[o.Name]
+	then := base.counts[o.Name]
+	if now.total < then.total || now.bad < then.bad {
+		// The counters were reset, e.g. after a restart.
+		then = counts{}
+	}
+	total := now.total - then.total
+	if total <= 0 {
+		return 0
+	}
+	return (now.bad - then.bad) / total
+}
+
+// count returns the total and bad events for the given objective.
+func count(family *dto.MetricFamily, o Objective) counts {
+	c := counts{}
+	for _, m := range family.GetMetric() {
+		if label(m, "path") != o.Path {
+			continue
+		}
+		h := m.GetHistogram()
+		total := float64(h.GetSampleCount())
+		c.total += total
+		switch o.Kind {
+		case Availability:
+			if strings.HasPrefix(label(m, "code"), "5") {
+				c.bad += total
+			}
+		case Latency:
+			good := 0.0
+			for _, b := range h.GetBucket() {
+				if b.GetUpperBound() == o.Threshold {
+					good = float64(b.GetCumulativeCount())
+					break
+				}
+			}
+			c.bad += total - good
+		}
+	}
+	return c
+}
+
+func label(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// formatWindow returns a short, Prometheus-style duration string, e.g. "5m" or "3d".
+func formatWindow(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return strconv.Itoa(int(d/(24*time.Hour))) + "d"
+	case d >= time.Hour && d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	case d >= time.Minute && d%time.Minute == 0:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	default:
+		return fmt.Sprintf("%ds", int64(math.Round(d.Seconds())))
+	}
+}
@@ -0,0 +1,127 @@
+package slo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newHistogram() (*prometheus.Registry, *prometheus.HistogramVec) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: HistogramName,
+			Help: "fake histogram",
+		},
+		[]string{"path", "code"},
+	)
+	reg.MustRegister(h)
+	return reg, h
+}
+
+func TestEvaluator_Evaluate(t *testing.T) {
+	objectives := []Objective{
+		{Name: "test-availability", Path: "/test", Kind: Availability, Target: 0.9},
+		{Name: "test-latency", Path: "/test", Kind: Latency, Target: 0.5, Threshold: 1},
+	}
+	windows := []time.Duration{5 * time.Minute, time.Hour}
+	reg, h := newHistogram()
+	e := NewEvaluator(reg, objectives, windows)
+
+	if got := testutil.ToFloat64(metrics.SLOObjective.WithLabelValues("test-availability")); got != 0.9 {
+		t.Errorf("NewEvaluator() objective = %f, want 0.9", got)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := e.Evaluate(start); err != ErrNoHistogram {
+		t.Errorf("Evaluate() with no observations = %v, want %v", err, ErrNoHistogram)
+	}
+
+	// Baseline: one fast success.
+	h.WithLabelValues("/test", "200").Observe(0.1)
+	if err := e.Evaluate(start); err != nil {
+		t.Fatalf("Evaluate() returned error: %v", err)
+	}
+
+	// Ten minutes later: 8 more fast successes, one slow success, one slow error.
+	for i := 0; i < 8; i++ {
+		h.WithLabelValues("/test", "200").Observe(0.1)
+	}
+	h.WithLabelValues("/test", "200").Observe(5)
+	h.WithLabelValues("/test", "500").Observe(5)
+	h.WithLabelValues("/other", "500").Observe(5)
+	if err := e.Evaluate(start.Add(10 * time.Minute)); err != nil {
+		t.Fatalf("Evaluate() returned error: %v", err)
+	}
+
+	tests := []struct {
+		slo    string
+		window string
+		ratio  float64
+		burn   float64
+	}{
+		// The 5m window has no older sample, so it only compares the current one.
+		{slo: "test-availability", window: "5m", ratio: 0, burn: 0},
+		{slo: "test-availability", window: "1h", ratio: 0.1, burn: 1},
+		{slo: "test-latency", window: "1h", ratio: 0.2, burn: 0.4},
+	}
+	for _, tt := range tests {
+		ratio := testutil.ToFloat64(metrics.SLOErrorRatio.WithLabelValues(tt.slo, tt.window))
+		burn := testutil.ToFloat64(metrics.SLOBurnRate.WithLabelValues(tt.slo, tt.window))
+		if math.Abs(ratio-tt.ratio) > 1e-9 {
+			t.Errorf("Evaluate() %s/%s ratio = %f, want %f", tt.slo, tt.window, ratio, tt.ratio)
+		}
+		if math.Abs(burn-tt.burn) > 1e-9 {
+			t.Errorf("Evaluate() %s/%s burn = %f, want %f", tt.slo, tt.window, burn, tt.burn)
+		}
+	}
+	budget := testutil.ToFloat64(metrics.SLOErrorBudgetRemaining.WithLabelValues("test-latency"))
+	if math.Abs(budget-0.6) > 1e-9 {
+		t.Errorf("Evaluate() remaining budget = %f, want 0.6", budget)
+	}
+
+	// Two hours later, old samples are pruned and only the most recent one
+	// beyond the longest window remains.
+	if err := e.Evaluate(start.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Evaluate() returned error: %v", err)
+	}
+	if len(e.samples) != 2 {
+		t.Errorf("Evaluate() kept %d samples, want 2", len(e.samples))
+	}
+}
+
+func TestEvaluator_errorRatioReset(t *testing.T) {
+	o := Objective{Name: "test", Path: "/test", Kind: Availability, Target: 0.9}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &Evaluator{
+		samples: []sample{
+			{time: start, counts: map[string]counts{"test": {total: 100, bad: 50}}},
+		},
+	}
+	cur := sample{time: start.Add(time.Minute), counts: map[string]counts{"test": {total: 10, bad: 1}}}
+	if got := e.errorRatio(o, start, cur); math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("errorRatio() after reset = %f, want 0.1", got)
+	}
+}
+
+func Test_formatWindow(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 30 * time.Second, want: "30s"},
+		{d: 5 * time.Minute, want: "5m"},
+		{d: 6 * time.Hour, want: "6h"},
+		{d: 72 * time.Hour, want: "3d"},
+		{d: 90 * time.Minute, want: "90m"},
+	}
+	for _, tt := range tests {
+		if got := formatWindow(tt.d); got != tt.want {
+			t.Errorf("formatWindow(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
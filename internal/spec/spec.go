@@ -0,0 +1,218 @@
+// Package spec generates the OpenAPI document served by the Autojoin API. The
+// paths and parameters come from openapi.yaml, which is also deployed to Cloud
+// Endpoints, and the response schemas are generated from the api/v0 types so
+// that they always match the responses of the server.
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+	"gopkg.in/yaml.v3"
+)
+
+// Responses maps the operationId of each operation in openapi.yaml to the
+// type of its JSON response. Operations that do not return JSON are nil.
+var Responses = map[string]interface{}{
+	"autojoin-v0-lookup":                    v0.LookupResponse{},
+	"autojoin-v0-lookup-batch":              v0.BatchLookupResponse{},
+	"autojoin-v0-lookup-validate":           v0.MetroValidationResponse{},
+	"autojoin-v0-lookup-search":             v0.LookupResponse{},
+	"autojoin-v0-node-provision":            v0.RegisterResponse{},
+	"autojoin-v0-org-apply":                 v0.OrgApplicationResponse{},
+	"autojoin-v0-org-verify":                v0.VerifyResponse{},
+	"autojoin-v0-node-register":             v0.RegisterResponse{},
+	"autojoin-v0-node-diff":                 v0.DiffResponse{},
+	"autojoin-v0-node-get":                  v0.GetResponse{},
+	"autojoin-v0-node-update":               v0.UpdateResponse{},
+	"autojoin-v0-node-token":                v0.TokenResponse{},
+	"autojoin-v0-node-maintenance":          v0.MaintenanceResponse{},
+	"autojoin-v0-node-delete":               v0.DeleteResponse{},
+	"autojoin-v0-node-delete-site":          v0.DeleteSiteResponse{},
+	"autojoin-v0-org-records-list":          v0.RecordsResponse{},
+	"autojoin-v0-org-records-set":           v0.RecordsResponse{},
+	"autojoin-v0-org-records-delete":        v0.RecordsResponse{},
+	"autojoin-v0-operation":                 v0.OperationResponse{},
+	"autojoin-v0-node-list":                 v0.ListResponse{},
+	"autojoin-v0-admin-config-get":          v0.ConfigResponse{},
+	"autojoin-v0-admin-config-set":          v0.ConfigResponse{},
+	"autojoin-v0-admin-keys-list":           v0.KeysResponse{},
+	"autojoin-v0-admin-keys-create":         v0.KeysResponse{},
+	"autojoin-v0-admin-keys-revoke":         v0.KeysResponse{},
+	"autojoin-v0-admin-org-get":             v0.OrgResponse{},
+	"autojoin-v0-admin-org-set":             v0.OrgResponse{},
+	"autojoin-v0-admin-org-history":         v0.OrgHistoryResponse{},
+	"autojoin-v0-admin-applications-list":   v0.OrgApplicationResponse{},
+	"autojoin-v0-admin-applications-review": v0.OrgApplicationResponse{},
+	"autojoin-v0-admin-rotate":              v0.RotateResponse{},
+	"autojoin-v0-admin-override-set":        v0.OverrideResponse{},
+	"autojoin-v0-admin-override-clear":      v0.OverrideResponse{},
+	"autojoin-v0-admin-expire":              v0.ExpireResponse{},
+	"autojoin-v0-admin-client-versions":     v0.ClientVersionsResponse{},
+	"autojoin-v0-admin-datasets":            v0.DatasetsResponse{},
+	"autojoin-v0-version":                   v0.VersionResponse{},
+	// The spec is the OpenAPI document itself.
+	"autojoin-v0-spec": nil,
+	// Events are streamed as text/event-stream.
+	"autojoin-v0-node-events": nil,
+}
+
+// Generate returns the OpenAPI document of the given openapi.yaml as JSON,
+// with the deployment placeholders replaced by project and a generated schema
+// for the response of every operation in Responses.
+func Generate(base []byte, project string) ([]byte, error) {
+	base = []byte(strings.NewReplacer("{{PROJECT}}", project, "{{DEPLOYMENT}}", project).Replace(string(base)))
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(base, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse openapi document: %w", err)
+	}
+	paths, ok := doc["paths"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("openapi document has no paths")
+	}
+	g := &generator{defs: map[string]interface{}{}}
+	for p, item := range paths {
+		methods, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for m, op := range methods {
+			op, ok := op.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := op["operationId"].(string)
+			resp, ok := Responses[id]
+			if !ok {
+				return nil, fmt.Errorf("%s %s: no response type for operationId %q", m, p, id)
+			}
+			if resp == nil {
+				continue
+			}
+			responses, _ := op["responses"].(map[string]interface{})
+			if responses == nil {
+				responses = map[string]interface{}{}
+				op["responses"] = responses
+			}
+			schema := g.schema(reflect.TypeOf(resp))
+			// Errors are returned in the same response type.
+			for code, r := range responses {
+				r, ok := r.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%s %s: invalid response %s", m, p, code)
+				}
+				r["schema"] = schema
+			}
+		}
+	}
+	doc["definitions"] = g.defs
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// generator generates JSON schemas of Go types. Named struct types are added
+// to defs and referenced by name.
+type generator struct {
+	defs map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema returns the JSON schema of values of type t encoded by encoding/json.
+func (g *generator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name := defName(t)
+		if _, ok := g.defs[name]; !ok {
+			// Reserve the name first to support recursive types.
+			g.defs[name] = nil
+			g.defs[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.object(t)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings.
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	default:
+		// Interfaces may contain any value.
+		return map[string]interface{}{}
+	}
+}
+
+// object returns the schema of a struct. Fields of embedded structs are
+// promoted, as by encoding/json.
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	g.fields(t, props, &required)
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (g *generator) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.fields(ft, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		// Fields that are always present are required.
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// defName returns the definition name of a named type. Types of api/v0 use
+// their own name, and others are qualified by their package, e.g. "v2.Error".
+func defName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(v0.Lookup{}).PkgPath() {
+		return t.Name()
+	}
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
@@ -0,0 +1,133 @@
+package spec
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	base, err := os.ReadFile("../../openapi.yaml")
+	if err != nil {
+		t.Fatalf("failed to read openapi.yaml: %v", err)
+	}
+	b, err := Generate(base, "mlab-sandbox")
+	if err != nil {
+		t.Fatalf("Generate() returned error: %v", err)
+	}
+	if strings.Contains(string(b), "{{") {
+		t.Errorf("Generate() did not replace placeholders")
+	}
+	doc := struct {
+		Host  string
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Responses   map[string]struct {
+				Schema map[string]interface{}
+			}
+		}
+		Definitions map[string]struct {
+			Properties map[string]interface{}
+		}
+	}{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("Generate() returned invalid json: %v", err)
+	}
+	if doc.Host != "autojoin-dot-mlab-sandbox.appspot.com" {
+		t.Errorf("Generate() returned wrong host; got %q", doc.Host)
+	}
+	// Every operation has a response type, and every response type is used.
+	used := map[string]bool{}
+	for p, methods := range doc.Paths {
+		for m, op := range methods {
+			used[op.OperationID] = true
+			if Responses[op.OperationID] == nil {
+				continue
+			}
+			for code, r := range op.Responses {
+				ref, _ := r.Schema["$ref"].(string)
+				name := strings.TrimPrefix(ref, "#/definitions/")
+				if _, ok := doc.Definitions[name]; !ok {
+					t.Errorf("%s %s %s: schema %q is not defined", m, p, code, ref)
+				}
+			}
+		}
+	}
+	for id := range Responses {
+		if !used[id] {
+			t.Errorf("Responses[%q] is not an operation of openapi.yaml", id)
+		}
+	}
+	reg := doc.Definitions["RegisterResponse"].Properties
+	for _, f := range []string{"Error", "Registration", "Invalid"} {
+		if _, ok := reg[f]; !ok {
+			t.Errorf("RegisterResponse has no property %q; got %v", f, reg)
+		}
+	}
+	if _, ok := doc.Definitions["v2.Error"].Properties["type"]; !ok {
+		t.Errorf("v2.Error does not use json names; got %v", doc.Definitions["v2.Error"])
+	}
+}
+
+func TestGenerate_error(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+	}{
+		{
+			name: "invalid-yaml",
+			base: "paths: [",
+		},
+		{
+			name: "no-paths",
+			base: "swagger: \"2.0\"",
+		},
+		{
+			name: "unknown-operation",
+			base: "paths:\n  /foo:\n    get:\n      operationId: foo\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Generate([]byte(tt.base), "mlab-sandbox"); err == nil {
+				t.Errorf("Generate() expected error")
+			}
+		})
+	}
+}
+
+type inner struct {
+	Promoted string
+}
+
+type outer struct {
+	inner
+	Renamed  int               `json:"renamed"`
+	Optional *bool             `json:",omitempty"`
+	Skipped  string            `json:"-"`
+	Labels   map[string]string `json:",omitempty"`
+	Self     *outer            `json:",omitempty"`
+}
+
+func TestGenerator_schema(t *testing.T) {
+	g := &generator{defs: map[string]interface{}{}}
+	s := g.schema(reflect.TypeOf(&outer{}))
+	if s["$ref"] != "#/definitions/spec.outer" {
+		t.Fatalf("schema() returned wrong ref; got %v", s)
+	}
+	def := g.defs["spec.outer"].(map[string]interface{})
+	props := def["properties"].(map[string]interface{})
+	for _, p := range []string{"Promoted", "renamed", "Optional", "Labels", "Self"} {
+		if _, ok := props[p]; !ok {
+			t.Errorf("schema() has no property %q; got %v", p, props)
+		}
+	}
+	if _, ok := props["Skipped"]; ok {
+		t.Errorf("schema() included skipped field")
+	}
+	if !reflect.DeepEqual(def["required"], []string{"Promoted", "renamed"}) {
+		t.Errorf("schema() returned wrong required fields; got %v", def["required"])
+	}
+}
@@ -0,0 +1,97 @@
+// Package supervisor runs long-lived background jobs, isolating panics and
+// restarting failed jobs with exponential backoff.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"golang.org/x/sync/errgroup"
+)
+
+// Job is a long-lived background function. A Job should run until the given
+// context is canceled. A Job that returns a nil error before then is
+// considered complete and is not restarted.
+type Job func(ctx context.Context) error
+
+// Supervisor runs named jobs within an errgroup.
+type Supervisor struct {
+	// MinBackoff is the delay before the first restart of a failed job.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between restarts. A job that runs for
+	// longer than MaxBackoff before failing is restarted after MinBackoff.
+	MaxBackoff time.Duration
+
+	ctx   context.Context
+	group *errgroup.Group
+}
+
+// New creates a new Supervisor. All jobs stop when the given context is canceled.
+func New(ctx context.Context) *Supervisor {
+	g, gctx := errgroup.WithContext(ctx)
+	return &Supervisor{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+		ctx:        gctx,
+		group:      g,
+	}
+}
+
+// Go starts the named job in a new goroutine. If the job returns an error or
+// panics, it is restarted after a backoff until the Supervisor context is
+// canceled.
+func (s *Supervisor) Go(name string, job Job) {
+	s.group.Go(func() error {
+		defer metrics.JobUp.WithLabelValues(name).Set(0)
+		backoff := s.MinBackoff
+		for {
+			metrics.JobUp.WithLabelValues(name).Set(1)
+			start := time.Now()
+			err := run(s.ctx, name, job)
+			metrics.JobUp.WithLabelValues(name).Set(0)
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			if err == nil {
+				log.Printf("Job %q completed", name)
+				return nil
+			}
+			if time.Since(start) > s.MaxBackoff {
+				// The job was healthy for a while, so restart quickly.
+				backoff = s.MinBackoff
+			}
+			log.Printf("Job %q failed, restarting in %s: %v", name, backoff, err)
+			metrics.JobRestarts.WithLabelValues(name).Inc()
+			select {
+			case <-s.ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > s.MaxBackoff {
+				backoff = s.MaxBackoff
+			}
+		}
+	})
+}
+
+// Wait blocks until all jobs have returned.
+func (s *Supervisor) Wait() error {
+	return s.group.Wait()
+}
+
+// run calls the job and converts panics into errors.
+func run(ctx context.Context, name string, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %q panic: %v\n%s", name, r, debug.Stack())
+			metrics.JobPanics.WithLabelValues(name).Inc()
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job(ctx)
+}
@@ -0,0 +1,95 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSupervisor_Go(t *testing.T) {
+	tests := []struct {
+		name         string
+		job          func(calls int32) error
+		wantCalls    int32
+		wantRestarts float64
+		wantPanics   float64
+	}{
+		{
+			name:      "success-completes",
+			job:       func(calls int32) error { return nil },
+			wantCalls: 1,
+		},
+		{
+			name: "error-restarts",
+			job: func(calls int32) error {
+				if calls < 3 {
+					return errors.New("fake error")
+				}
+				return nil
+			},
+			wantCalls:    3,
+			wantRestarts: 2,
+		},
+		{
+			name: "panic-restarts",
+			job: func(calls int32) error {
+				if calls < 2 {
+					panic("fake panic")
+				}
+				return nil
+			},
+			wantCalls:    2,
+			wantRestarts: 1,
+			wantPanics:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(context.Background())
+			s.MinBackoff = time.Millisecond
+			s.MaxBackoff = 10 * time.Millisecond
+			var calls int32
+			s.Go(tt.name, func(ctx context.Context) error {
+				return tt.job(atomic.AddInt32(&calls, 1))
+			})
+			if err := s.Wait(); err != nil {
+				t.Errorf("Wait() returned err: %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Go() calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if got := testutil.ToFloat64(metrics.JobRestarts.WithLabelValues(tt.name)); got != tt.wantRestarts {
+				t.Errorf("Go() restarts = %f, want %f", got, tt.wantRestarts)
+			}
+			if got := testutil.ToFloat64(metrics.JobPanics.WithLabelValues(tt.name)); got != tt.wantPanics {
+				t.Errorf("Go() panics = %f, want %f", got, tt.wantPanics)
+			}
+			if got := testutil.ToFloat64(metrics.JobUp.WithLabelValues(tt.name)); got != 0 {
+				t.Errorf("Go() up = %f, want 0", got)
+			}
+		})
+	}
+}
+
+func TestSupervisor_GoCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(ctx)
+	s.MinBackoff = time.Hour
+	s.MaxBackoff = time.Hour
+	started := make(chan bool)
+	s.Go("cancel", func(ctx context.Context) error {
+		close(started)
+		return errors.New("fake error")
+	})
+	<-started
+	// Cancel while the job waits to restart.
+	cancel()
+	if err := s.Wait(); err != nil {
+		t.Errorf("Wait() returned err: %v", err)
+	}
+}
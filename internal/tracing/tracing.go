@@ -0,0 +1,109 @@
+// Package tracing instruments the Autojoin API and the Google Cloud backends
+// it calls with OpenTelemetry, so that slow requests can be broken down by
+// backend call.
+//
+// Spans are created through the OpenTelemetry API and recorded by the global
+// TracerProvider. Until a TracerProvider is registered, spans are not recorded
+// but trace context is still propagated from incoming requests to backends.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/grpc"
+)
+
+// Name is the instrumentation name of the spans of this module.
+const Name = "github.com/m-lab/autojoin"
+
+// cloudPlatformScope is the OAuth scope of the REST clients created by
+// HTTPOptions. It includes the scopes of every API used by the server.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Setup registers the global propagators named by the standard
+// OTEL_PROPAGATORS environment variable. The W3C tracecontext and baggage
+// propagators are used by default; "none" disables propagation.
+func Setup() error {
+	p, err := propagators(os.Getenv("OTEL_PROPAGATORS"))
+	if err != nil {
+		return err
+	}
+	otel.SetTextMapPropagator(p)
+	return nil
+}
+
+func propagators(names string) (propagation.TextMapPropagator, error) {
+	if names == "" {
+		names = "tracecontext,baggage"
+	}
+	var ps []propagation.TextMapPropagator
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "tracecontext":
+			ps = append(ps, propagation.TraceContext{})
+		case "baggage":
+			ps = append(ps, propagation.Baggage{})
+		case "none":
+			return propagation.NewCompositeTextMapPropagator(), nil
+		default:
+			return nil, fmt.Errorf("unsupported OTEL_PROPAGATORS value %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(ps...), nil
+}
+
+// Start starts a span named name as a child of the span of ctx. Callers must
+// call End when done.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(Name).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span, recording err as its status if not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Handler returns a handler that starts a span for every request, continuing
+// the trace of the request headers. Spans are named by method and path.
+func Handler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "autojoin",
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return req.Method + " " + req.URL.Path
+		}))
+}
+
+// HTTPOptions returns the client options of Google Cloud REST clients, e.g.
+// Cloud DNS and IAM, that create a span for every backend call.
+func HTTPOptions(ctx context.Context) ([]option.ClientOption, error) {
+	base, err := htransport.NewTransport(ctx, http.DefaultTransport, option.WithScopes(cloudPlatformScope))
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{Transport: otelhttp.NewTransport(base)}
+	return []option.ClientOption{option.WithHTTPClient(c)}, nil
+}
+
+// GRPCOptions returns the client options of Google Cloud gRPC clients, e.g.
+// Secret Manager and Datastore, that create a span for every backend call.
+func GRPCOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithStatsHandler(otelgrpc.NewClientHandler())),
+	}
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		fields  []string
+		wantErr bool
+	}{
+		{name: "default", fields: []string{"traceparent", "tracestate", "baggage"}},
+		{name: "tracecontext", env: "tracecontext", fields: []string{"traceparent", "tracestate"}},
+		{name: "baggage", env: " baggage ", fields: []string{"baggage"}},
+		{name: "none", env: "none", fields: nil},
+		{name: "error-unsupported", env: "tracecontext,b3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_PROPAGATORS", tt.env)
+			err := Setup()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Setup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// The order of fields of a composite propagator is not defined.
+			got := otel.GetTextMapPropagator().Fields()
+			sort.Strings(got)
+			want := append([]string(nil), tt.fields...)
+			sort.Strings(want)
+			if len(got) != len(want) {
+				t.Fatalf("Setup() wrong fields; got %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("Setup() wrong fields; got %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+type fakeSpan struct {
+	noop.Span
+	code  codes.Code
+	err   error
+	ended bool
+}
+
+func (s *fakeSpan) RecordError(err error, _ ...trace.EventOption) { s.err = err }
+func (s *fakeSpan) SetStatus(code codes.Code, _ string)           { s.code = code }
+func (s *fakeSpan) End(...trace.SpanEndOption)                    { s.ended = true }
+
+func TestEnd(t *testing.T) {
+	s := &fakeSpan{}
+	End(s, nil)
+	if !s.ended || s.err != nil || s.code != codes.Unset {
+		t.Errorf("End(nil) = %+v, want ended without error", s)
+	}
+	err := errors.New("fake error")
+	s = &fakeSpan{}
+	End(s, err)
+	if !s.ended || s.err != err || s.code != codes.Error {
+		t.Errorf("End(err) = %+v, want ended with error", s)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Setenv("OTEL_PROPAGATORS", "")
+	if err := Setup(); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	var got trace.SpanContext
+	h := Handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = trace.SpanContextFromContext(req.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/node/register", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Handler() did not continue the trace; got %q", got.TraceID())
+	}
+	_, span := Start(context.Background(), "test")
+	defer span.End()
+	if span.SpanContext().IsValid() {
+		t.Errorf("Start() without a parent created a valid span context")
+	}
+}
+
+func TestGRPCOptions(t *testing.T) {
+	if got := GRPCOptions(); len(got) != 1 {
+		t.Errorf("GRPCOptions() returned %d options, want 1", len(got))
+	}
+}
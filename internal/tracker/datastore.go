@@ -0,0 +1,229 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/locate/memorystore"
+)
+
+// EntryKind is the Datastore kind of tracker entries. The Datastore name of an
+// entry is its hostname.
+const EntryKind = "TrackerEntry"
+
+// expiresField is the property of the expiration time of an entry, as a Unix
+// timestamp.
+const expiresField = "Expires"
+
+// Transaction is the subset of a Datastore transaction used to update
+// tracker entries. It is implemented by *datastore.Transaction.
+type Transaction interface {
+	Get(key *datastore.Key, dst interface{}) error
+	Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error)
+}
+
+// Datastore is the subset of the Datastore client used to persist tracker
+// entries.
+type Datastore interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+	RunInTransaction(ctx context.Context, f func(tx Transaction) error) error
+}
+
+// client adapts a *datastore.Client to the Datastore interface.
+type client struct {
+	c *datastore.Client
+}
+
+// NewDatastore returns the Datastore of the given client.
+func NewDatastore(c *datastore.Client) Datastore {
+	return &client{c: c}
+}
+
+func (c *client) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	return c.c.Get(ctx, key, dst)
+}
+
+func (c *client) GetAll(ctx context.Context, q *datastore.Query, dst interface{}) ([]*datastore.Key, error) {
+	return c.c.GetAll(ctx, q, dst)
+}
+
+func (c *client) Delete(ctx context.Context, key *datastore.Key) error {
+	return c.c.Delete(ctx, key)
+}
+
+func (c *client) RunInTransaction(ctx context.Context, f func(tx Transaction) error) error {
+	_, err := c.c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(tx)
+	})
+	return err
+}
+
+// DatastoreClient is a MemorystoreClient that saves entries in Datastore, for
+// deployments without Redis. Every field of an entry is saved as a JSON
+// property, like the fields of Memorystore hashes.
+//
+// Every Put extends the expiration of the entry by the TTL. Expired entries
+// are not returned by GetAll, and are deleted by Run. The TTL should be much
+// longer than the TTL of the GarbageCollector, which removes the DNS records
+// of stale entries; it only removes entries the GarbageCollector missed, e.g.
+// entries updated while they were deleted.
+type DatastoreClient struct {
+	ds        Datastore
+	namespace string
+	ttl       time.Duration
+}
+
+// NewDatastoreClient creates a new DatastoreClient that saves entries in the
+// given Datastore namespace.
+func NewDatastoreClient(ds Datastore, namespace string, ttl time.Duration) *DatastoreClient {
+	return &DatastoreClient{ds: ds, namespace: namespace, ttl: ttl}
+}
+
+func (c *DatastoreClient) key(hostname string) *datastore.Key {
+	k := datastore.NameKey(EntryKind, hostname, nil)
+	k.Namespace = c.namespace
+	return k
+}
+
+// Put sets the field of the entry of the hostname key to the JSON value. With
+// FieldMustExist, entries without the field return ErrNotFound.
+func (c *DatastoreClient) Put(key string, field string, value redis.Scanner, opts *memorystore.PutOptions) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return c.ds.RunInTransaction(ctx, func(tx Transaction) error {
+		props := datastore.PropertyList{}
+		err := tx.Get(c.key(key), &props)
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+		if opts != nil && opts.FieldMustExist != "" && !hasField(props, opts.FieldMustExist) {
+			return ErrNotFound
+		}
+		updated := datastore.PropertyList{
+			{Name: field, Value: string(b), NoIndex: true},
+			{Name: expiresField, Value: time.Now().Add(c.ttl).Unix()},
+		}
+		for _, p := range props {
+			if p.Name != field && p.Name != expiresField {
+				updated = append(updated, p)
+			}
+		}
+		_, err = tx.Put(c.key(key), &updated)
+		return err
+	})
+}
+
+func hasField(props datastore.PropertyList, field string) bool {
+	for _, p := range props {
+		if p.Name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of the entry of the hostname key, or the zero value if
+// the entry does not exist or has expired.
+func (c *DatastoreClient) Get(key string) (Status, error) {
+	props := datastore.PropertyList{}
+	err := c.ds.Get(context.Background(), c.key(key), &props)
+	switch {
+	case errors.Is(err, datastore.ErrNoSuchEntity):
+		return Status{}, nil
+	case err != nil:
+		return Status{}, err
+	}
+	for _, p := range props {
+		if exp, ok := p.Value.(int64); ok && p.Name == expiresField && exp <= time.Now().Unix() {
+			return Status{}, nil
+		}
+	}
+	return scanStatus(props)
+}
+
+// GetAll returns the values of all entries that have not expired.
+func (c *DatastoreClient) GetAll() (map[string]Status, error) {
+	q := datastore.NewQuery(EntryKind).Namespace(c.namespace).
+		FilterField(expiresField, ">", time.Now().Unix())
+	entries := []datastore.PropertyList{}
+	keys, err := c.ds.GetAll(context.Background(), q, &entries)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]Status{}
+	for i, props := range entries {
+		v, err := scanStatus(props)
+		if err != nil {
+			return nil, err
+		}
+		values[keys[i].Name] = v
+	}
+	return values, nil
+}
+
+// scanStatus returns the Status of the JSON properties of an entry.
+func scanStatus(props datastore.PropertyList) (Status, error) {
+	src := []interface{}{}
+	for _, p := range props {
+		if s, ok := p.Value.(string); ok {
+			src = append(src, []byte(p.Name), []byte(s))
+		}
+	}
+	v := Status{}
+	err := redis.ScanStruct(src, &v)
+	return v, err
+}
+
+// Del deletes the entry of the hostname key.
+func (c *DatastoreClient) Del(key string) error {
+	return c.ds.Delete(context.Background(), c.key(key))
+}
+
+// Expire deletes the expired entries, and returns the number of deleted
+// entries.
+func (c *DatastoreClient) Expire(ctx context.Context) (int, error) {
+	q := datastore.NewQuery(EntryKind).Namespace(c.namespace).
+		FilterField(expiresField, "<=", time.Now().Unix())
+	entries := []datastore.PropertyList{}
+	keys, err := c.ds.GetAll(ctx, q, &entries)
+	if err != nil {
+		return 0, err
+	}
+	for i, k := range keys {
+		if err := c.ds.Delete(ctx, k); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// Run deletes expired entries every interval until the context is canceled.
+func (c *DatastoreClient) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			n, err := c.Expire(ctx)
+			if err != nil {
+				log.Printf("Failed to delete expired tracker entries: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Deleted %d expired tracker entries", n)
+			}
+		}
+	}
+}
@@ -2,10 +2,15 @@ package tracker
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"log"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	v0 "github.com/m-lab/autojoin/api/v0"
 	"github.com/m-lab/autojoin/internal/dnsname"
 	"github.com/m-lab/autojoin/internal/dnsx"
 	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
@@ -19,6 +24,71 @@ import (
 type Status struct {
 	// DNS represents a DNS record
 	DNS *DNSRecord
+	// Registered is the approximate time the hostname was first registered.
+	// It is saved separately from DNS so that re-registration preserves it.
+	Registered *Timestamp
+	// Maintenance is the most recent maintenance window declared for the
+	// hostname. It is saved separately from DNS so that re-registration
+	// preserves it.
+	Maintenance *Window
+	// Override is the probability override set by operators. It is saved
+	// separately from DNS so that re-registration preserves it.
+	Override *Override
+}
+
+// Override is a probability set by operators that replaces the probability
+// requested by a node, e.g. zero to drain it during an incident.
+type Override struct {
+	// Enabled is false once the override is cleared.
+	Enabled     bool
+	Probability float64
+	// Reason describes why the override was set.
+	Reason string `json:",omitempty"`
+}
+
+// Window is a period during which a node is under planned maintenance. An
+// expired node is not removed during its maintenance window.
+type Window struct {
+	// Start and End are Unix timestamps.
+	Start int64
+	End   int64
+}
+
+// Active reports whether the window includes the given time.
+func (w *Window) Active(t time.Time) bool {
+	return w != nil && t.Unix() >= w.Start && t.Unix() < w.End
+}
+
+// Timestamp is a time saved in memorystore.
+type Timestamp struct {
+	// Unix is the time as a Unix timestamp.
+	Unix int64
+}
+
+// Reporter records hostnames that are removed, e.g. for data pipeline
+// coordination.
+type Reporter interface {
+	Report(ctx context.Context, hostname string, s Status, reason string) error
+}
+
+// Reporters is a Reporter that reports to every Reporter in order. Errors are
+// joined, so one failure does not prevent later reports.
+type Reporters []Reporter
+
+// Report calls Report on every Reporter.
+func (r Reporters) Report(ctx context.Context, hostname string, s Status, reason string) error {
+	errs := []error{}
+	for _, rep := range r {
+		if err := rep.Report(ctx, hostname, s, reason); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SuspensionChecker reports whether an organization is suspended.
+type SuspensionChecker interface {
+	Suspended(ctx context.Context, org string) (bool, error)
 }
 
 // DNSRecord represents a DNS record with a last update time to verify if the
@@ -28,72 +98,301 @@ type DNSRecord struct {
 	LastUpdate int64
 	// Ports contains a list of service ports to monitor
 	Ports []string
+	// Type is the machine type reported at registration: "physical",
+	// "virtual", or "cloud".
+	Type string
+	// CloudProvider and CloudRegion are the optional hosting of nodes of
+	// type "cloud".
+	CloudProvider string `json:",omitempty"`
+	CloudRegion   string `json:",omitempty"`
+	// Country is the ISO country code of the registered IPv4 address.
+	Country string
+	// Uplink is the uplink speed reported at registration, e.g. "10g".
+	Uplink string `json:",omitempty"`
+	// UplinkMbps is the capacity of the uplink in Mbps, for capacity reports.
+	UplinkMbps int64 `json:",omitempty"`
+	// Labels contains arbitrary key=value metadata provided by the node.
+	Labels map[string]string `json:",omitempty"`
+	// ClientVersion is the version of the Go client package of the node, if
+	// reported.
+	ClientVersion string `json:",omitempty"`
+	// PendingDNS is true if the DNS records of the registration were not
+	// changed because Cloud DNS was unavailable. ApplyPending changes them.
+	PendingDNS bool `json:",omitempty"`
+	// Rrdata is the data of the DNS records of the registration, which are
+	// applied unless PendingDNS is set. Registrations with the same data do
+	// not read or change Cloud DNS.
+	Rrdata *Rrdata `json:",omitempty"`
+	// DNSChange is the ID of the last Cloud DNS change of the registration
+	// if it was not verified as done, e.g. when verification timed out.
+	DNSChange string `json:",omitempty"`
+	// Registration is the most recent registration returned to the node,
+	// without credentials.
+	Registration *v0.Registration `json:",omitempty"`
+}
+
+// Rrdata is the data of the A, AAAA, and TXT records of a hostname.
+type Rrdata struct {
+	A    string
+	AAAA string `json:",omitempty"`
+	// TXT describes the registration, including the Registered time.
+	TXT string `json:",omitempty"`
+	// TTL is the TTL in seconds of the records. Zero means dnsx.DefaultTTL.
+	TTL int64 `json:",omitempty"`
+	// Registered is the time of the first registration as a Unix timestamp.
+	Registered int64 `json:",omitempty"`
+}
+
+// Matches reports whether the records have the same data as o. A nil Rrdata
+// matches nothing.
+func (r *Rrdata) Matches(o *Rrdata) bool {
+	return r != nil && o != nil && r.A == o.A && r.AAAA == o.AAAA && r.TXT == o.TXT && r.TTL == o.TTL
 }
 
+// ErrNotFound is returned when a hostname is not tracked.
+var ErrNotFound = errors.New("hostname not found")
+
+// indexMaxAge is the age of the address index after which Hostnames reads the
+// tracker again for addresses that are not indexed.
+var indexMaxAge = time.Minute
+
 // MemorystoreClient is a client for reading and writing data in Memorystore.
 // The interface takes in a type argument which specifies the types of values
 // that are stored and can be retrieved.
 type MemorystoreClient[V any] interface {
 	Put(key string, field string, value redis.Scanner, opts *memorystore.PutOptions) error
+	// Get returns the value of the key, or the zero value if the key does
+	// not exist.
+	Get(key string) (V, error)
 	GetAll() (map[string]V, error)
 	Del(key string) error
 }
 
+// MemorystoreReader is a client for reading data from Memorystore, e.g. from a
+// read replica.
+type MemorystoreReader[V any] interface {
+	GetAll() (map[string]V, error)
+}
+
 // GarbageCollector is a tracker that implements automatic garbage collection
 // of stale entities - i.e. entities whose registration has not been updated
 // for longer than the configured TTL.
 //
-// When the GarbageCollector is created, it spawns a goroutine that
-// periodically reads all entities in Memorystore and checks if their
-// registration has expired. If an entity has expired, it is deleted from both
-// Cloud DNS and Memorystore.
+// Once Run is called, the GarbageCollector periodically reads all entities in
+// Memorystore and checks if their registration has expired. If an entity has
+// expired, it is deleted from both Cloud DNS and Memorystore.
 type GarbageCollector struct {
 	MemorystoreClient[Status]
-	stop    chan bool
-	project string
-	ttl     time.Duration
-	dns     dnsiface.Service
+	stop     chan bool
+	stopOnce sync.Once
+	project  string
+	dns      dnsiface.Service
+	reader   MemorystoreReader[Status]
+	reporter Reporter
+	suspend  SuspensionChecker
+	// hostMetrics enables the per-host DNSExpiration series.
+	hostMetrics bool
+	// siteRecords enables the round-robin site records of the DNS changes.
+	siteRecords bool
+
+	// mu protects ttl and interval, which may be changed at runtime, and
+	// addrs, which is refreshed by every List, and the time it was read.
+	mu       sync.Mutex
+	ttl      time.Duration
+	interval time.Duration
+	reconfig chan struct{}
+	addrs    map[string][]string
+	indexed  time.Time
+
+	// queue holds the hostnames of registrations whose DNS records are
+	// changed by the workers of RunQueue.
+	queue chan string
 }
 
-// NewGarbageCollector returns a new garbage-collected tracker for DNS entries
-// and spawns a goroutine to periodically check and delete expired entities.
+// NewGarbageCollector returns a new garbage-collected tracker for DNS entries.
+// Callers must call Run to periodically check and delete expired entities.
 func NewGarbageCollector(dns dnsiface.Service, project string, msClient MemorystoreClient[Status],
 	ttl, interval time.Duration) *GarbageCollector {
-	st := &GarbageCollector{
+	return &GarbageCollector{
 		MemorystoreClient: msClient,
 		stop:              make(chan bool),
 		project:           project,
 		ttl:               ttl,
+		interval:          interval,
+		reconfig:          make(chan struct{}, 1),
+		addrs:             map[string][]string{},
 		dns:               dns,
+		hostMetrics:       true,
+		queue:             make(chan string, pendingQueueSize),
+	}
+}
+
+// Config returns the current TTL and garbage collection interval.
+func (gc *GarbageCollector) Config() (ttl, interval time.Duration) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.ttl, gc.interval
+}
+
+// Reconfigure changes the TTL and garbage collection interval. A running Run
+// loop uses the new interval immediately.
+func (gc *GarbageCollector) Reconfigure(ttl, interval time.Duration) {
+	gc.mu.Lock()
+	gc.ttl = ttl
+	gc.interval = interval
+	gc.mu.Unlock()
+	select {
+	case gc.reconfig <- struct{}{}:
+	default:
+		// A reconfiguration is already pending.
 	}
+}
 
-	// Start a goroutine to periodically check and remove expired entities.
-	go func(t *GarbageCollector) {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+// Run periodically checks and removes expired entities until the context is
+// canceled or Stop is called.
+func (gc *GarbageCollector) Run(ctx context.Context) error {
+	_, interval := gc.Config()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		for {
-			select {
-			case <-t.stop:
-				return
-			case <-ticker.C:
-				log.Printf("Checking for expired memorystore entities...")
-				t.checkAndRemoveExpired()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-gc.stop:
+			return nil
+		case <-gc.reconfig:
+			_, interval = gc.Config()
+			ticker.Reset(interval)
+		case <-ticker.C:
+			log.Printf("Checking for expired memorystore entities...")
+			_, _, err := gc.checkAndRemoveExpired()
+			if err != nil {
+				log.Printf("Failed to check for expired entities: %v", err)
 			}
 		}
-	}(st)
-
-	return st
+	}
 }
 
 // Update creates a new entry in memorystore for the given hostname or updates
-// the existing one with a new LastUpdate time.
-func (gc *GarbageCollector) Update(hostname string, ports []string) error {
-	entry := &DNSRecord{
-		LastUpdate: time.Now().UTC().Unix(),
-		Ports:      ports,
+// the existing one with a new LastUpdate time. The LastUpdate field of the
+// given record is always overwritten.
+func (gc *GarbageCollector) Update(hostname string, entry *DNSRecord) error {
+	entry.LastUpdate = time.Now().UTC().Unix()
+	err := gc.Put(hostname, "DNS", entry, &memorystore.PutOptions{})
+	if err != nil {
+		return err
+	}
+	if ip := hostnameIPv4(hostname); ip != "" {
+		gc.mu.Lock()
+		defer gc.mu.Unlock()
+		for _, h := range gc.addrs[ip] {
+			if h == hostname {
+				return nil
+			}
+		}
+		gc.addrs[ip] = append(gc.addrs[ip], hostname)
+	}
+	return nil
+}
+
+// SetMaintenance saves the maintenance window of a tracked hostname. A zero
+// Window clears any previous window. Untracked hostnames return an error.
+func (gc *GarbageCollector) SetMaintenance(hostname string, w *Window) error {
+	return gc.Put(hostname, "Maintenance", w, &memorystore.PutOptions{FieldMustExist: "DNS"})
+}
+
+// SetOverride saves the probability override of a tracked hostname. An
+// override that is not Enabled clears any previous override. Untracked
+// hostnames return an error. Overrides are returned by Get as part of the
+// Status, so they apply to all instances at once.
+func (gc *GarbageCollector) SetOverride(hostname string, o *Override) error {
+	return gc.Put(hostname, "Override", o, &memorystore.PutOptions{FieldMustExist: "DNS"})
+}
+
+// Hostnames returns the active hostnames registered with the given IPv4
+// address. To avoid reading memorystore on every registration, hostnames are
+// indexed by List and LoadIndexes. Addresses missing from an index older than
+// indexMaxAge are looked up again, so registrations to other instances are
+// found within indexMaxAge.
+func (gc *GarbageCollector) Hostnames(ipv4 string) []string {
+	gc.mu.Lock()
+	hosts := gc.addrs[ipv4]
+	stale := len(hosts) == 0 && time.Since(gc.indexed) > indexMaxAge
+	if stale {
+		// Concurrent registrations wait for the next refresh instead.
+		gc.indexed = time.Now()
+	}
+	gc.mu.Unlock()
+	if stale {
+		if err := gc.LoadIndexes(); err != nil {
+			log.Printf("Failed to load hostnames of %s: %v", ipv4, err)
+		}
+		gc.mu.Lock()
+		hosts = gc.addrs[ipv4]
+		gc.mu.Unlock()
+	}
+	return append([]string(nil), hosts...)
+}
+
+// LoadIndexes reads the active hostnames of the tracker, from the reader if
+// configured, and indexes them by address without removing expired entries.
+// Servers call it once at startup so that Hostnames does not depend on the
+// first GC run.
+func (gc *GarbageCollector) LoadIndexes() error {
+	var r MemorystoreReader[Status] = gc.MemorystoreClient
+	if gc.reader != nil {
+		r = gc.reader
+	}
+	values, err := r.GetAll()
+	if err != nil {
+		return err
+	}
+	nodes, _ := gc.active(values)
+	gc.updateIndexes(nodes)
+	return nil
+}
+
+// updateIndexes replaces all known addresses with those of the given active
+// hostnames.
+func (gc *GarbageCollector) updateIndexes(nodes []string) {
+	addrs := map[string][]string{}
+	for _, k := range nodes {
+		if ip := hostnameIPv4(k); ip != "" {
+			addrs[ip] = append(addrs[ip], k)
+		}
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.addrs = addrs
+	gc.indexed = time.Now()
+}
+
+// hostnameIPv4 returns the IPv4 address encoded in the machine name of an
+// autojoin hostname, or "" if the hostname is not valid.
+func hostnameIPv4(hostname string) string {
+	name, err := host.Parse(hostname)
+	if err != nil {
+		return ""
+	}
+	b, err := hex.DecodeString(name.Machine)
+	if err != nil || len(b) != net.IPv4len {
+		return ""
+	}
+	return net.IP(b).String()
+}
+
+// Get returns the status of the given hostname, or ErrNotFound if the
+// hostname is not tracked.
+func (gc *GarbageCollector) Get(hostname string) (*Status, error) {
+	v, err := gc.MemorystoreClient.Get(hostname)
+	if err != nil {
+		return nil, err
 	}
-	return gc.Put(hostname, "DNS", entry, &memorystore.PutOptions{})
+	if v.DNS == nil {
+		return nil, ErrNotFound
+	}
+	return &v, nil
 }
 
 func (gc *GarbageCollector) Delete(hostname string) error {
@@ -103,16 +402,120 @@ func (gc *GarbageCollector) Delete(hostname string) error {
 		log.Printf("Failed to delete %s from memorystore: %v", hostname, err)
 		return err
 	}
+	metrics.DNSExpiration.DeleteLabelValues(hostname)
+	if ip := hostnameIPv4(hostname); ip != "" {
+		gc.mu.Lock()
+		defer gc.mu.Unlock()
+		hosts := []string{}
+		for _, h := range gc.addrs[ip] {
+			if h != hostname {
+				hosts = append(hosts, h)
+			}
+		}
+		gc.addrs[ip] = hosts
+	}
 	return nil
 }
 
-func (gc *GarbageCollector) List() ([]string, [][]string, error) {
-	return gc.checkAndRemoveExpired()
+// ReadFrom configures the GarbageCollector to serve List from the given
+// reader, e.g. a read replica, so that heavy read traffic does not load the
+// primary instance. When a reader is configured, List omits expired entries
+// without deleting them; they are removed by the periodic garbage collection.
+func (gc *GarbageCollector) ReadFrom(r MemorystoreReader[Status]) {
+	gc.reader = r
+}
+
+// ReportTo configures the GarbageCollector to report every expired hostname
+// to the given Reporter before it is removed.
+func (gc *GarbageCollector) ReportTo(r Reporter) {
+	gc.reporter = r
+}
+
+// ExpireSuspended configures the GarbageCollector to remove the hostnames of
+// suspended organizations without waiting for their TTL.
+func (gc *GarbageCollector) ExpireSuspended(s SuspensionChecker) {
+	gc.suspend = s
+}
+
+// ExportHostMetrics configures whether the GarbageCollector exports the DNS
+// expiration of every host. The per-host series are enabled by default; large
+// deployments may disable them and rely on the per-org and site aggregates.
+func (gc *GarbageCollector) ExportHostMetrics(enabled bool) {
+	gc.hostMetrics = enabled
+}
+
+// SiteRecords configures whether the GarbageCollector maintains the
+// round-robin site records when it changes the DNS records of hosts.
+func (gc *GarbageCollector) SiteRecords(enabled bool) {
+	gc.siteRecords = enabled
+}
+
+// dnsManager returns a Manager of the organization zone of the given host.
+func (gc *GarbageCollector) dnsManager(name host.Name) *dnsx.Manager {
+	m := dnsx.NewManager(gc.dns, gc.project, dnsname.OrgZone(name.Org, gc.project, name.Domain))
+	m.Sites = gc.siteRecords
+	return m
+}
+
+// isSuspended reports whether the organization of hostname is suspended.
+// Results are cached per organization in the given map.
+func (gc *GarbageCollector) isSuspended(hostname string, cache map[string]bool) bool {
+	if gc.suspend == nil {
+		return false
+	}
+	name, err := host.Parse(hostname)
+	if err != nil {
+		return false
+	}
+	suspended, ok := cache[name.Org]
+	if !ok {
+		suspended, err = gc.suspend.Suspended(context.Background(), name.Org)
+		if err != nil {
+			log.Printf("Failed to check suspension of %s: %v", name.Org, err)
+		}
+		cache[name.Org] = suspended
+	}
+	return suspended
+}
+
+// List returns the hostnames and status of all active entries. Without a
+// separate reader, List also removes expired entries.
+func (gc *GarbageCollector) List() ([]string, []Status, error) {
+	if gc.reader == nil {
+		return gc.checkAndRemoveExpired()
+	}
+	values, err := gc.reader.GetAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes, status := gc.active(values)
+	gc.updateIndexes(nodes)
+	return nodes, status, nil
+}
+
+// active returns the hostnames and status of the given entries that have a
+// DNS record and are not expired.
+func (gc *GarbageCollector) active(values map[string]Status) ([]string, []Status) {
+	ttl, _ := gc.Config()
+	now := time.Now()
+	nodes := []string{}
+	status := []Status{}
+	for k, v := range values {
+		if v.DNS == nil {
+			continue
+		}
+		if now.Sub(time.Unix(v.DNS.LastUpdate, 0)) > ttl && !v.Maintenance.Active(now) {
+			continue
+		}
+		nodes = append(nodes, k)
+		status = append(status, v)
+	}
+	return nodes, status
 }
 
-func (gc *GarbageCollector) checkAndRemoveExpired() ([]string, [][]string, error) {
+func (gc *GarbageCollector) checkAndRemoveExpired() ([]string, []Status, error) {
 	nodes := []string{}
-	ports := [][]string{}
+	status := []Status{}
 	values, err := gc.GetAll()
 
 	if err != nil {
@@ -121,11 +524,28 @@ func (gc *GarbageCollector) checkAndRemoveExpired() ([]string, [][]string, error
 	}
 
 	// Iterate over values and check if they are expired.
+	ttl, _ := gc.Config()
+	suspended := map[string]bool{}
+	expires := map[string]time.Time{}
 	for k, v := range values {
+		if v.DNS == nil {
+			// Without a DNS record the entry can never expire normally.
+			log.Printf("Removing %s without DNS record from memorystore", k)
+			gc.Delete(k)
+			continue
+		}
 		lastUpdate := time.Unix(v.DNS.LastUpdate, 0)
-		metrics.DNSExpiration.WithLabelValues(k).Set(float64(lastUpdate.Add(gc.ttl).Unix()))
-		if time.Since(lastUpdate) > gc.ttl {
-			log.Printf("%s expired on %s, deleting from Cloud DNS and memorystore", k, lastUpdate.Add(gc.ttl))
+		expires[k] = lastUpdate.Add(ttl)
+		reason := ""
+		switch {
+		case time.Since(lastUpdate) > ttl && !v.Maintenance.Active(time.Now()):
+			reason = "expired"
+			log.Printf("%s expired on %s, deleting from Cloud DNS and memorystore", k, lastUpdate.Add(ttl))
+		case gc.isSuspended(k, suspended):
+			reason = "suspended"
+			log.Printf("%s organization is suspended, deleting from Cloud DNS and memorystore", k)
+		}
+		if reason != "" {
 
 			// Parse hostname.
 			name, err := host.Parse(k)
@@ -135,7 +555,7 @@ func (gc *GarbageCollector) checkAndRemoveExpired() ([]string, [][]string, error
 				// TODO(rd): count errors with a Prometheus metric
 			}
 
-			m := dnsx.NewManager(gc.dns, gc.project, dnsname.OrgZone(name.Org, gc.project))
+			m := gc.dnsManager(name)
 			_, err = m.Delete(context.Background(), name.StringAll()+".")
 			if err != nil {
 				log.Printf("Failed to delete DNS entry for %s: %v", name, err)
@@ -145,21 +565,83 @@ func (gc *GarbageCollector) checkAndRemoveExpired() ([]string, [][]string, error
 				// TODO(rd): count errors with a Prometheus metric
 			}
 
+			metrics.NodeDeletions.WithLabelValues(name.Org, reason).Inc()
+
+			if gc.reporter != nil {
+				err = gc.reporter.Report(context.Background(), k, v, reason)
+				if err != nil {
+					log.Printf("Failed to report %s hostname %s: %v", reason, k, err)
+				}
+			}
+
 			// Remove expired hostname from memorystore.
 			err = gc.Delete(k)
 			if err != nil {
 				log.Printf("Failed to delete %s: %v", k, err)
 				// TODO(rd): count errors with a Prometheus metric
+				continue
 			}
+			delete(expires, k)
 		} else {
+			if v.Registered == nil {
+				// The first GC run after registration records the start of
+				// the active period, accurate to within one GC interval.
+				v.Registered = &Timestamp{Unix: v.DNS.LastUpdate}
+				if err := gc.Put(k, "Registered", v.Registered, &memorystore.PutOptions{}); err != nil {
+					log.Printf("Failed to save registration time of %s: %v", k, err)
+				}
+			}
 			nodes = append(nodes, k)
-			ports = append(ports, v.DNS.Ports)
+			status = append(status, v)
+		}
+	}
+	gc.updateIndexes(nodes)
+	gc.updateNodeMetrics(nodes, expires)
+	return nodes, status, nil
+}
+
+// updateNodeMetrics exports the number of active nodes of each org and site,
+// the earliest DNS expiration of the hosts of each org and site, and, unless
+// disabled, the DNS expiration of every host. The series of removed orgs,
+// sites and hosts are deleted.
+func (gc *GarbageCollector) updateNodeMetrics(nodes []string, expires map[string]time.Time) {
+	counts := map[[2]string]int{}
+	for _, k := range nodes {
+		if name, err := host.Parse(k); err == nil {
+			counts[[2]string{name.Org, name.Site}]++
 		}
 	}
-	return nodes, ports, nil
+	earliest := map[[2]string]time.Time{}
+	for k, t := range expires {
+		name, err := host.Parse(k)
+		if err != nil {
+			continue
+		}
+		site := [2]string{name.Org, name.Site}
+		if e, ok := earliest[site]; !ok || t.Before(e) {
+			earliest[site] = t
+		}
+	}
+	metrics.ActiveNodes.Reset()
+	for k, n := range counts {
+		metrics.ActiveNodes.WithLabelValues(k[0], k[1]).Set(float64(n))
+	}
+	metrics.DNSExpirationEarliest.Reset()
+	for k, t := range earliest {
+		metrics.DNSExpirationEarliest.WithLabelValues(k[0], k[1]).Set(float64(t.Unix()))
+	}
+	metrics.DNSExpiration.Reset()
+	if !gc.hostMetrics {
+		return
+	}
+	for k, t := range expires {
+		metrics.DNSExpiration.WithLabelValues(k).Set(float64(t.Unix()))
+	}
 }
 
+// Stop causes Run to return. Stop may be called more than once.
 func (gc *GarbageCollector) Stop() {
-	gc.stop <- true
-	close(gc.stop)
+	gc.stopOnce.Do(func() {
+		close(gc.stop)
+	})
 }
@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/autojoin/internal/metrics"
 	"github.com/m-lab/locate/memorystore"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"google.golang.org/api/dns/v1"
 )
 
@@ -30,19 +32,44 @@ func (f *fakeDNS) CreateManagedZone(ctx context.Context, project string, zone *d
 func (f *fakeDNS) GetManagedZone(ctx context.Context, project, zoneName string) (*dns.ManagedZone, error) {
 	return nil, nil
 }
+func (f *fakeDNS) DeleteManagedZone(ctx context.Context, project, zoneName string) error {
+	return nil
+}
+func (f *fakeDNS) ResourceRecordSetsList(ctx context.Context, project string, zone string) ([]*dns.ResourceRecordSet, error) {
+	return nil, nil
+}
+func (f *fakeDNS) ResourceRecordSetsByName(ctx context.Context, project string, zone string, name string) ([]*dns.ResourceRecordSet, error) {
+	return nil, f.getErr
+}
+func (f *fakeDNS) ChangeGet(ctx context.Context, project string, zone string, id string) (*dns.Change, error) {
+	return nil, f.getErr
+}
+func (f *fakeDNS) ManagedZonesList(ctx context.Context, project string) ([]*dns.ManagedZone, error) {
+	return nil, f.getErr
+}
+func (f *fakeDNS) DNSKeysList(ctx context.Context, project string, zone string) ([]*dns.DnsKey, error) {
+	return nil, f.getErr
+}
 
 type fakeMemorystoreClient[V any] struct {
 	putErr error
 	delErr error
 	getErr error
 	m      map[string]V
+	fields []string
 }
 
-// Put returns nil.
+// Put records the field name and returns putErr.
 func (c *fakeMemorystoreClient[V]) Put(key string, field string, value redis.Scanner, opts *memorystore.PutOptions) error {
+	c.fields = append(c.fields, field)
 	return c.putErr
 }
 
+// Get returns the value of the key and getErr.
+func (c *fakeMemorystoreClient[V]) Get(key string) (V, error) {
+	return c.m[key], c.getErr
+}
+
 // GetAll returns an empty map and a nil error.
 func (c *fakeMemorystoreClient[V]) GetAll() (map[string]V, error) {
 	return c.m, c.getErr
@@ -70,17 +97,34 @@ func TestNewGarbageCollector(t *testing.T) {
 		t.Errorf("NewGarbageCollector() = %v, want %v", gc, reflect.Value{})
 	}
 
+	done := make(chan error)
+	go func() {
+		done <- gc.Run(context.Background())
+	}()
 	if runtime.NumGoroutine() != before+1 {
-		t.Errorf("NewGarbageCollector() did not spawn a new goroutine.")
+		t.Errorf("Run() did not spawn a new goroutine.")
 	}
 
 	// Let the GC one or more times.
 	time.Sleep(500 * time.Millisecond)
 
 	gc.Stop()
-	time.Sleep(500 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Errorf("Run() returned err: %v", err)
+	}
+	// Stop may be called more than once.
+	gc.Stop()
+	time.Sleep(100 * time.Millisecond)
 	if runtime.NumGoroutine() != before {
-		t.Errorf("NewGarbageCollector() did not stop the goroutine (got %d, exp: %d).", runtime.NumGoroutine(), before)
+		t.Errorf("Run() did not stop the goroutine (got %d, exp: %d).", runtime.NumGoroutine(), before)
+	}
+
+	// Run also returns when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	gc = NewGarbageCollector(dns, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+	cancel()
+	if err := gc.Run(ctx); err != nil {
+		t.Errorf("Run() returned err: %v", err)
 	}
 }
 
@@ -89,7 +133,7 @@ func TestGarbageCollector_Update(t *testing.T) {
 	fakeMSClient := &fakeMemorystoreClient[Status]{}
 	gc := NewGarbageCollector(dns, "test-project", fakeMSClient, 3*time.Hour, 1*time.Hour)
 
-	err := gc.Update("foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org", nil)
+	err := gc.Update("foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org", &DNSRecord{})
 	if err != nil {
 		t.Errorf("Update() returned err, expected nil: %v", err)
 	}
@@ -130,6 +174,12 @@ func TestGarbageCollector_List(t *testing.T) {
 	if _, ok := fakeMSClient.m["foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org"]; !ok {
 		t.Errorf("List() removed a non-expired record.")
 	}
+	if n := testutil.ToFloat64(metrics.ActiveNodes.WithLabelValues("bar", "lga12345")); n != 1 {
+		t.Errorf("List() exported wrong active nodes; got %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.NodeDeletions.WithLabelValues("bar", "expired")); n == 0 {
+		t.Errorf("List() did not count the expired node")
+	}
 
 	// Add un-parseable hostname
 	fakeMSClient.m["invalid"] = Status{
@@ -151,6 +201,46 @@ func TestGarbageCollector_List(t *testing.T) {
 	}
 }
 
+func TestGarbageCollector_HostMetrics(t *testing.T) {
+	expired := "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org"
+	active := "foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org"
+	later := "foo-lga12345-c0a80003.bar.sandbox.measurement-lab.org"
+	now := time.Now()
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			expired: {DNS: &DNSRecord{LastUpdate: 0}},
+			active:  {DNS: &DNSRecord{LastUpdate: now.Unix()}},
+			later:   {DNS: &DNSRecord{LastUpdate: now.Add(time.Minute).Unix()}},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+	metrics.DNSExpiration.WithLabelValues(expired).Set(1)
+
+	gc.List()
+	// The series of the expired host was removed.
+	if n := testutil.CollectAndCount(metrics.DNSExpiration); n != 2 {
+		t.Errorf("List() exported wrong number of host series; got %d, want 2", n)
+	}
+	want := float64(now.Add(3 * time.Hour).Unix())
+	if got := testutil.ToFloat64(metrics.DNSExpirationEarliest.WithLabelValues("bar", "lga12345")); got != want {
+		t.Errorf("List() exported wrong earliest expiration; got %v, want %v", got, want)
+	}
+
+	gc.Delete(later)
+	if n := testutil.CollectAndCount(metrics.DNSExpiration); n != 1 {
+		t.Errorf("Delete() did not remove the host series; got %d series, want 1", n)
+	}
+
+	gc.ExportHostMetrics(false)
+	gc.List()
+	if n := testutil.CollectAndCount(metrics.DNSExpiration); n != 0 {
+		t.Errorf("List() exported disabled host series; got %d, want 0", n)
+	}
+	if got := testutil.ToFloat64(metrics.DNSExpirationEarliest.WithLabelValues("bar", "lga12345")); got != want {
+		t.Errorf("List() exported wrong earliest expiration; got %v, want %v", got, want)
+	}
+}
+
 func TestGarbageCollector_Delete(t *testing.T) {
 	dns := &fakeDNS{}
 	fakeMSClient := &fakeMemorystoreClient[Status]{
@@ -179,3 +269,418 @@ func TestGarbageCollector_Delete(t *testing.T) {
 		t.Errorf("Delete() did not propagate errors.")
 	}
 }
+
+func TestGarbageCollector_ListFromReader(t *testing.T) {
+	primary := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{},
+	}
+	replica := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			"foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: 0},
+			},
+			"foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: time.Now().Unix()},
+			},
+			"missing-dns-record": {},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", primary, 3*time.Hour, 1*time.Hour)
+	gc.ReadFrom(replica)
+
+	nodes, status, err := gc.List()
+	if err != nil {
+		t.Fatalf("List() returned err: %v", err)
+	}
+	if len(nodes) != 1 || len(status) != 1 || nodes[0] != "foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org" {
+		t.Errorf("List() returned wrong nodes; got %v", nodes)
+	}
+	// Expired entries are not deleted from the replica.
+	if len(replica.m) != 3 {
+		t.Errorf("List() modified the replica; got %d entries, want 3", len(replica.m))
+	}
+
+	replica.getErr = errors.New("fake replica error")
+	if _, _, err := gc.List(); err != replica.getErr {
+		t.Errorf("List() returned wrong error; got %v, want %v", err, replica.getErr)
+	}
+}
+
+func TestGarbageCollector_Reconfigure(t *testing.T) {
+	fakeMSClient := &fakeMemorystoreClient[Status]{m: map[string]Status{}}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+
+	done := make(chan error)
+	go func() {
+		done <- gc.Run(context.Background())
+	}()
+
+	gc.Reconfigure(6*time.Hour, time.Minute)
+	// A second pending reconfiguration must not block.
+	gc.Reconfigure(12*time.Hour, 2*time.Minute)
+	ttl, interval := gc.Config()
+	if ttl != 12*time.Hour || interval != 2*time.Minute {
+		t.Errorf("Config() = (%s, %s), want (12h, 2m)", ttl, interval)
+	}
+
+	gc.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Run() returned err: %v", err)
+	}
+}
+
+func TestGarbageCollector_Get(t *testing.T) {
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			"foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{Ports: []string{"9990"}},
+			},
+			"missing-dns-record": {},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+
+	r, err := gc.Get("foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org")
+	if err != nil || !reflect.DeepEqual(r.DNS.Ports, []string{"9990"}) {
+		t.Errorf("Get() = %v, %v; want ports [9990]", r, err)
+	}
+	if _, err := gc.Get("unknown"); err != ErrNotFound {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := gc.Get("missing-dns-record"); err != ErrNotFound {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, ErrNotFound)
+	}
+	fakeMSClient.getErr = errors.New("fake getall error")
+	if _, err := gc.Get("unknown"); err != fakeMSClient.getErr {
+		t.Errorf("Get() returned wrong error; got %v, want %v", err, fakeMSClient.getErr)
+	}
+}
+
+type fakeReporter struct {
+	hostnames []string
+	err       error
+}
+
+func (f *fakeReporter) Report(ctx context.Context, hostname string, s Status, reason string) error {
+	f.hostnames = append(f.hostnames, hostname)
+	return f.err
+}
+
+func TestReporters(t *testing.T) {
+	a := &fakeReporter{err: errors.New("fake report error")}
+	b := &fakeReporter{}
+	err := Reporters{a, b}.Report(context.Background(), "foo", Status{}, "deleted")
+	if err == nil {
+		t.Errorf("Reporters.Report() returned nil error; want error")
+	}
+	if !reflect.DeepEqual(a.hostnames, []string{"foo"}) || !reflect.DeepEqual(b.hostnames, []string{"foo"}) {
+		t.Errorf("Reporters.Report() did not report to all; got %v and %v", a.hostnames, b.hostnames)
+	}
+	if err := (Reporters{b}).Report(context.Background(), "bar", Status{}, "deleted"); err != nil {
+		t.Errorf("Reporters.Report() returned error: %v", err)
+	}
+}
+
+func TestGarbageCollector_ReportTo(t *testing.T) {
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			"foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: 0},
+			},
+			"foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: time.Now().Unix()},
+			},
+			"missing-dns-record": {
+				Registered: &Timestamp{Unix: 0},
+			},
+		},
+	}
+	r := &fakeReporter{err: errors.New("fake report error")}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+	gc.ReportTo(r)
+
+	nodes, status, err := gc.List()
+	if err != nil {
+		t.Fatalf("List() returned err: %v", err)
+	}
+	// Expired hostnames are reported and removed even if reporting fails.
+	if len(r.hostnames) != 1 || r.hostnames[0] != "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org" {
+		t.Errorf("List() reported wrong hostnames; got %v", r.hostnames)
+	}
+	if len(fakeMSClient.m) != 1 {
+		t.Errorf("List() did not remove expired and invalid entries; got %v", fakeMSClient.m)
+	}
+	// Active hostnames are given a registration time.
+	if len(nodes) != 1 || status[0].Registered == nil || status[0].Registered.Unix != status[0].DNS.LastUpdate {
+		t.Errorf("List() did not set registration time; got %v", status)
+	}
+	if !reflect.DeepEqual(fakeMSClient.fields, []string{"Registered"}) {
+		t.Errorf("List() saved wrong fields; got %v", fakeMSClient.fields)
+	}
+}
+
+type fakeSuspensionChecker struct {
+	suspended map[string]bool
+	err       error
+	calls     int
+}
+
+func (f *fakeSuspensionChecker) Suspended(ctx context.Context, org string) (bool, error) {
+	f.calls++
+	return f.suspended[org], f.err
+}
+
+func TestGarbageCollector_ExpireSuspended(t *testing.T) {
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			"foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: time.Now().Unix()},
+			},
+			"foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: time.Now().Unix()},
+			},
+			"foo-lga12345-c0a80003.baz.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: time.Now().Unix()},
+			},
+		},
+	}
+	r := &fakeReporter{}
+	sc := &fakeSuspensionChecker{suspended: map[string]bool{"bar": true}}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+	gc.ReportTo(r)
+	gc.ExpireSuspended(sc)
+
+	nodes, _, err := gc.List()
+	if err != nil {
+		t.Fatalf("List() returned err: %v", err)
+	}
+	if !reflect.DeepEqual(nodes, []string{"foo-lga12345-c0a80003.baz.sandbox.measurement-lab.org"}) {
+		t.Errorf("List() returned wrong nodes; got %v", nodes)
+	}
+	if len(r.hostnames) != 2 || len(fakeMSClient.m) != 1 {
+		t.Errorf("List() did not remove suspended nodes; got %v", fakeMSClient.m)
+	}
+	// Each organization is checked once per run.
+	if sc.calls != 2 {
+		t.Errorf("List() checked suspension %d times; want 2", sc.calls)
+	}
+
+	// Nodes are kept if suspension cannot be checked.
+	sc.err = errors.New("fake suspension error")
+	sc.suspended = map[string]bool{}
+	nodes, _, err = gc.List()
+	if err != nil || len(nodes) != 1 {
+		t.Errorf("List() = %v, %v; want 1 node", nodes, err)
+	}
+}
+
+func TestGarbageCollector_Maintenance(t *testing.T) {
+	const hostname = "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org"
+	now := time.Now()
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			hostname: {
+				DNS:         &DNSRecord{LastUpdate: 0},
+				Maintenance: &Window{Start: now.Add(-time.Hour).Unix(), End: now.Add(time.Hour).Unix()},
+			},
+			"foo-lga12345-c0a80002.bar.sandbox.measurement-lab.org": {
+				DNS:         &DNSRecord{LastUpdate: 0},
+				Maintenance: &Window{Start: now.Add(-2 * time.Hour).Unix(), End: now.Add(-time.Hour).Unix()},
+			},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+
+	if err := gc.SetMaintenance(hostname, &Window{}); err != nil {
+		t.Errorf("SetMaintenance() returned err: %v", err)
+	}
+	if !reflect.DeepEqual(fakeMSClient.fields, []string{"Maintenance"}) {
+		t.Errorf("SetMaintenance() saved wrong fields; got %v", fakeMSClient.fields)
+	}
+
+	// Expired hostnames are kept only during an active maintenance window.
+	nodes, _, err := gc.List()
+	if err != nil {
+		t.Fatalf("List() returned err: %v", err)
+	}
+	if !reflect.DeepEqual(nodes, []string{hostname}) {
+		t.Errorf("List() returned wrong nodes; got %v, want %v", nodes, []string{hostname})
+	}
+	gc.ReadFrom(fakeMSClient)
+	nodes, _, err = gc.List()
+	if err != nil {
+		t.Fatalf("List() from reader returned err: %v", err)
+	}
+	if !reflect.DeepEqual(nodes, []string{hostname}) {
+		t.Errorf("List() from reader returned wrong nodes; got %v, want %v", nodes, []string{hostname})
+	}
+}
+
+func TestWindow_Active(t *testing.T) {
+	now := time.Unix(100, 0)
+	tests := []struct {
+		name string
+		w    *Window
+		want bool
+	}{
+		{name: "nil", w: nil, want: false},
+		{name: "zero", w: &Window{}, want: false},
+		{name: "active", w: &Window{Start: 50, End: 150}, want: true},
+		{name: "starts-now", w: &Window{Start: 100, End: 150}, want: true},
+		{name: "ends-now", w: &Window{Start: 50, End: 100}, want: false},
+		{name: "future", w: &Window{Start: 150, End: 200}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.Active(now); got != tt.want {
+				t.Errorf("Window.Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGarbageCollector_SetOverride(t *testing.T) {
+	const hostname = "foo-lga12345-c0a80001.bar.sandbox.measurement-lab.org"
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			hostname: {
+				DNS:      &DNSRecord{LastUpdate: time.Now().Unix()},
+				Override: &Override{Enabled: true, Probability: 0.5},
+			},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+
+	err := gc.SetOverride(hostname, &Override{Enabled: true, Probability: 0})
+	if err != nil {
+		t.Fatalf("SetOverride() returned err: %v", err)
+	}
+	if !reflect.DeepEqual(fakeMSClient.fields, []string{"Override"}) {
+		t.Errorf("SetOverride() saved wrong fields; got %v, want [Override]", fakeMSClient.fields)
+	}
+
+	// Overrides are read with the status of the hostname.
+	status, err := gc.Get(hostname)
+	if err != nil || status.Override == nil || status.Override.Probability != 0.5 {
+		t.Errorf("Get() = %v, %v; want override 0.5", status, err)
+	}
+
+	fakeMSClient.putErr = errors.New("fake put error")
+	if err := gc.SetOverride(hostname, &Override{Enabled: true}); err != fakeMSClient.putErr {
+		t.Errorf("SetOverride() returned wrong error; got %v, want %v", err, fakeMSClient.putErr)
+	}
+}
+
+func TestGarbageCollector_Hostnames(t *testing.T) {
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: time.Now().Unix()},
+			},
+			// Expired hostnames are not included.
+			"ndt-lga12345-c0a80001.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: 0},
+			},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+	_, _, err := gc.List()
+	if err != nil {
+		t.Fatalf("List() returned err: %v", err)
+	}
+	want := []string{"ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org"}
+	if got := gc.Hostnames("192.168.0.1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hostnames() = %v, want %v", got, want)
+	}
+
+	// Registrations and deletes on this instance apply immediately.
+	for i := 0; i < 2; i++ {
+		err = gc.Update("msak-lga12345-c0a80001.baz.sandbox.measurement-lab.org", &DNSRecord{})
+		if err != nil {
+			t.Fatalf("Update() returned err: %v", err)
+		}
+	}
+	want = append(want, "msak-lga12345-c0a80001.baz.sandbox.measurement-lab.org")
+	if got := gc.Hostnames("192.168.0.1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hostnames() = %v, want %v", got, want)
+	}
+	if err := gc.Delete(want[0]); err != nil {
+		t.Fatalf("Delete() returned err: %v", err)
+	}
+	if got := gc.Hostnames("192.168.0.1"); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("Hostnames() = %v, want %v", got, want[1:])
+	}
+	if got := gc.Hostnames("192.168.0.2"); len(got) != 0 {
+		t.Errorf("Hostnames() = %v, want none", got)
+	}
+	if got := hostnameIPv4("invalid"); got != "" {
+		t.Errorf("hostnameIPv4() = %q, want empty", got)
+	}
+}
+
+func TestGarbageCollector_LoadIndexes(t *testing.T) {
+	defer func(d time.Duration) { indexMaxAge = d }(indexMaxAge)
+	const hostname = "ndt-lga12345-c0a80001.foo.sandbox.measurement-lab.org"
+	fakeMSClient := &fakeMemorystoreClient[Status]{
+		m: map[string]Status{
+			hostname: {DNS: &DNSRecord{LastUpdate: time.Now().Unix()}},
+			// Expired hostnames are neither included nor deleted.
+			"ndt-lga12345-c0a80002.bar.sandbox.measurement-lab.org": {
+				DNS: &DNSRecord{LastUpdate: 0},
+			},
+		},
+	}
+	gc := NewGarbageCollector(&fakeDNS{}, "test-project", fakeMSClient, 3*time.Hour, time.Hour)
+	if err := gc.LoadIndexes(); err != nil {
+		t.Fatalf("LoadIndexes() returned err: %v", err)
+	}
+	if got := gc.Hostnames("192.168.0.1"); !reflect.DeepEqual(got, []string{hostname}) {
+		t.Errorf("Hostnames() = %v, want %v", got, []string{hostname})
+	}
+	if got := gc.Hostnames("192.168.0.2"); len(got) != 0 {
+		t.Errorf("Hostnames() = %v, want none", got)
+	}
+	if len(fakeMSClient.m) != 2 {
+		t.Errorf("LoadIndexes() deleted entries; got %d, want 2", len(fakeMSClient.m))
+	}
+
+	// Registrations to other instances are found once the index is stale.
+	const other = "ndt-lga12345-c0a80003.baz.sandbox.measurement-lab.org"
+	fakeMSClient.FakeAdd(other, Status{DNS: &DNSRecord{LastUpdate: time.Now().Unix()}})
+	if got := gc.Hostnames("192.168.0.3"); len(got) != 0 {
+		t.Errorf("Hostnames() = %v, want none before the index is stale", got)
+	}
+	indexMaxAge = 0
+	if got := gc.Hostnames("192.168.0.3"); !reflect.DeepEqual(got, []string{other}) {
+		t.Errorf("Hostnames() = %v, want %v", got, []string{other})
+	}
+
+	// Errors leave the index unchanged.
+	fakeMSClient.getErr = errors.New("fake get error")
+	if err := gc.LoadIndexes(); err != fakeMSClient.getErr {
+		t.Errorf("LoadIndexes() returned wrong error; got %v, want %v", err, fakeMSClient.getErr)
+	}
+	if got := gc.Hostnames("192.168.0.1"); !reflect.DeepEqual(got, []string{hostname}) {
+		t.Errorf("Hostnames() = %v, want %v", got, []string{hostname})
+	}
+}
+
+func TestRrdata_Matches(t *testing.T) {
+	r := &Rrdata{A: "192.0.2.1", AAAA: "2001:db8::1", TXT: `"org=mlab"`, Registered: 1}
+	if !r.Matches(&Rrdata{A: "192.0.2.1", AAAA: "2001:db8::1", TXT: `"org=mlab"`}) {
+		t.Errorf("Matches() = false, want true")
+	}
+	if r.Matches(&Rrdata{A: "192.0.2.1", TXT: `"org=mlab"`}) {
+		t.Errorf("Matches() without IPv6 = true, want false")
+	}
+	if r.Matches(&Rrdata{A: "192.0.2.1", AAAA: "2001:db8::1", TXT: `"org=foo"`}) {
+		t.Errorf("Matches() with other TXT = true, want false")
+	}
+	if r.Matches(&Rrdata{A: "192.0.2.1", AAAA: "2001:db8::1", TXT: `"org=mlab"`, TTL: 60}) {
+		t.Errorf("Matches() with other TTL = true, want false")
+	}
+	var empty *Rrdata
+	if empty.Matches(r) || r.Matches(nil) {
+		t.Errorf("Matches() of nil = true, want false")
+	}
+}
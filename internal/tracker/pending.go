@@ -0,0 +1,145 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/locate/memorystore"
+)
+
+// ApplyPending changes the DNS records of the active registrations made while
+// Cloud DNS was unavailable, and returns the number of registrations changed.
+// It stops at the first failure, leaving the remaining registrations pending
+// for the next call.
+func (gc *GarbageCollector) ApplyPending(ctx context.Context) (int, error) {
+	nodes, status, err := gc.List()
+	if err != nil {
+		return 0, err
+	}
+	pending := map[string]int{}
+	applied := 0
+	var failed error
+	for i, k := range nodes {
+		rec := status[i].DNS
+		if !rec.PendingDNS {
+			continue
+		}
+		name, err := host.Parse(k)
+		if err != nil {
+			log.Printf("Failed to parse hostname %s: %v", k, err)
+			continue
+		}
+		if failed == nil {
+			failed = gc.applyPending(ctx, name, rec)
+			if failed == nil {
+				applied++
+				continue
+			}
+		}
+		pending[name.Org]++
+	}
+	metrics.DNSPendingNodes.Reset()
+	for org, n := range pending {
+		metrics.DNSPendingNodes.WithLabelValues(org).Set(float64(n))
+	}
+	return applied, failed
+}
+
+func (gc *GarbageCollector) applyPending(ctx context.Context, name host.Name, rec *DNSRecord) error {
+	if rec.Rrdata == nil {
+		// Records saved by earlier versions only include the addresses.
+		rec.Rrdata = &Rrdata{}
+		if r := rec.Registration; r != nil && r.Annotation != nil {
+			rec.Rrdata.A, rec.Rrdata.AAAA = r.Annotation.Network.IPv4, r.Annotation.Network.IPv6
+		}
+	}
+	m := gc.dnsManager(name)
+	m.TTL = rec.Rrdata.TTL
+	if _, err := m.Register(ctx, name.StringAll()+".", rec.Rrdata.A, rec.Rrdata.AAAA, rec.Rrdata.TXT); err != nil {
+		return err
+	}
+	// Entries deleted meanwhile are not created again.
+	rec.PendingDNS = false
+	err := gc.Put(name.StringAll(), "DNS", rec, &memorystore.PutOptions{FieldMustExist: "DNS"})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// pendingQueueSize is the number of hostnames Enqueue holds for the workers.
+const pendingQueueSize = 1024
+
+// Enqueue adds the hostname of a pending registration to the queue of
+// RunQueue. When the queue is full, the registration is left to RunPending.
+func (gc *GarbageCollector) Enqueue(hostname string) {
+	select {
+	case gc.queue <- hostname:
+	default:
+		log.Printf("DNS queue is full; %s waits for the next pending run", hostname)
+	}
+}
+
+// RunQueue changes the DNS records of queued hostnames with the given number
+// of workers until the context is canceled. Hostnames that are no longer
+// pending, e.g. because they were deleted, are skipped.
+func (gc *GarbageCollector) RunQueue(ctx context.Context, workers int) error {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case hostname := <-gc.queue:
+					gc.applyQueued(ctx, hostname)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (gc *GarbageCollector) applyQueued(ctx context.Context, hostname string) {
+	name, err := host.Parse(hostname)
+	if err != nil {
+		log.Printf("Failed to parse hostname %s: %v", hostname, err)
+		return
+	}
+	s, err := gc.Get(hostname)
+	if err != nil || s.DNS == nil || !s.DNS.PendingDNS {
+		return
+	}
+	if err := gc.applyPending(ctx, name, s.DNS); err != nil {
+		// RunPending tries again later.
+		log.Printf("Failed to apply DNS registration of %s: %v", hostname, err)
+	}
+}
+
+// RunPending calls ApplyPending every interval until the context is canceled.
+func (gc *GarbageCollector) RunPending(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			n, err := gc.ApplyPending(ctx)
+			if n > 0 {
+				log.Printf("Applied %d pending DNS registrations", n)
+			}
+			if err != nil {
+				log.Printf("Failed to apply pending DNS registrations: %v", err)
+			}
+		}
+	}
+}
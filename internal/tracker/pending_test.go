@@ -0,0 +1,103 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	v0 "github.com/m-lab/autojoin/api/v0"
+)
+
+func TestGarbageCollector_ApplyPending(t *testing.T) {
+	tests := []struct {
+		name       string
+		dns        *fakeDNS
+		wantN      int
+		wantErr    bool
+		wantFields int
+	}{
+		{
+			name:       "success",
+			dns:        &fakeDNS{},
+			wantN:      1,
+			wantFields: 1,
+		},
+		{
+			name:    "error-change",
+			dns:     &fakeDNS{chgErr: errors.New("fake change error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().Unix()
+			ms := &fakeMemorystoreClient[Status]{m: map[string]Status{
+				"ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org": {
+					DNS: &DNSRecord{
+						LastUpdate: now,
+						PendingDNS: true,
+						Registration: &v0.Registration{
+							Annotation: &v0.ServerAnnotation{Network: v0.Network{IPv4: "192.0.2.1"}},
+						},
+					},
+					Registered: &Timestamp{Unix: now},
+				},
+				"ndt-lga3270-4f20bd8a.mlab.sandbox.measurement-lab.org": {
+					DNS:        &DNSRecord{LastUpdate: now},
+					Registered: &Timestamp{Unix: now},
+				},
+			}}
+			gc := NewGarbageCollector(tt.dns, "mlab-sandbox", ms, 3*time.Hour, time.Hour)
+
+			n, err := gc.ApplyPending(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ApplyPending() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n != tt.wantN {
+				t.Errorf("ApplyPending() = %d, want %d", n, tt.wantN)
+			}
+			if len(ms.fields) != tt.wantFields {
+				t.Errorf("ApplyPending() saved fields %v, want %d", ms.fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestGarbageCollector_RunPending(t *testing.T) {
+	gc := NewGarbageCollector(&fakeDNS{}, "mlab-sandbox", &fakeMemorystoreClient[Status]{}, 3*time.Hour, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := gc.RunPending(ctx, 10*time.Millisecond); err != nil {
+		t.Errorf("RunPending() returned err: %v", err)
+	}
+}
+
+func TestGarbageCollector_RunQueue(t *testing.T) {
+	now := time.Now().Unix()
+	hostname := "ndt-lga3269-4f20bd89.mlab.sandbox.measurement-lab.org"
+	ms := &fakeMemorystoreClient[Status]{m: map[string]Status{
+		hostname: {
+			DNS: &DNSRecord{
+				LastUpdate: now,
+				PendingDNS: true,
+				Registration: &v0.Registration{
+					Annotation: &v0.ServerAnnotation{Network: v0.Network{IPv4: "192.0.2.1"}},
+				},
+			},
+			Registered: &Timestamp{Unix: now},
+		},
+	}}
+	gc := NewGarbageCollector(&fakeDNS{}, "mlab-sandbox", ms, 3*time.Hour, time.Hour)
+	gc.Enqueue("invalid-hostname")
+	gc.Enqueue(hostname)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := gc.RunQueue(ctx, 2); err != nil {
+		t.Errorf("RunQueue() returned err: %v", err)
+	}
+	if len(ms.fields) != 1 {
+		t.Errorf("RunQueue() saved fields %v, want 1", ms.fields)
+	}
+}
@@ -0,0 +1,41 @@
+package tracker
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/locate/memorystore"
+)
+
+// hashClient is the subset of MemorystoreClient implemented by the
+// Memorystore client of the locate package.
+type hashClient[V any] interface {
+	Put(key string, field string, value redis.Scanner, opts *memorystore.PutOptions) error
+	GetAll() (map[string]V, error)
+	Del(key string) error
+}
+
+// RedisClient is a MemorystoreClient of a Redis instance. It extends the
+// Memorystore client of the locate package, which only reads all entries, with
+// Get.
+type RedisClient[V any] struct {
+	hashClient[V]
+	pool *redis.Pool
+}
+
+// NewRedisClient creates a new RedisClient of the Redis instance of the pool.
+func NewRedisClient[V any](pool *redis.Pool) *RedisClient[V] {
+	return &RedisClient[V]{hashClient: memorystore.NewClient[V](pool), pool: pool}
+}
+
+// Get returns the value of the hash key with a single HGETALL, or the zero
+// value if the key does not exist.
+func (c *RedisClient[V]) Get(key string) (V, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	var v V
+	values, err := redis.Values(conn.Do("HGETALL", key))
+	if err != nil {
+		return v, err
+	}
+	err = redis.ScanStruct(values, &v)
+	return v, err
+}
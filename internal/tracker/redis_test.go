@@ -0,0 +1,30 @@
+package tracker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestRedisClient_Get(t *testing.T) {
+	conn := &fakeConn{
+		reply: []interface{}{[]byte("DNS"), []byte(`{"LastUpdate":10}`)},
+	}
+	c := NewRedisClient[Status](&redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }})
+
+	got, err := c.Get("foo")
+	if err != nil || got.DNS == nil || got.DNS.LastUpdate != 10 {
+		t.Errorf("Get() = %+v, %v, want DNS", got, err)
+	}
+
+	conn.reply = []interface{}{}
+	if got, err := c.Get("unknown"); err != nil || got.DNS != nil {
+		t.Errorf("Get() of unknown key = %+v, %v, want zero value", got, err)
+	}
+
+	conn.err = errors.New("fake hgetall error")
+	if _, err := c.Get("foo"); err == nil {
+		t.Errorf("Get() returned nil error, want %v", conn.err)
+	}
+}
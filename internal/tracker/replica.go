@@ -0,0 +1,72 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/autojoin/internal/metrics"
+)
+
+// MonitorReplica periodically reads the replication state of the Redis
+// instance behind the given pool and exports its staleness as metrics. It
+// returns when the context is canceled.
+func MonitorReplica(ctx context.Context, pool *redis.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkReplica(pool)
+		}
+	}
+}
+
+func checkReplica(pool *redis.Pool) {
+	conn := pool.Get()
+	defer conn.Close()
+	info, err := redis.String(conn.Do("INFO", "replication"))
+	if err != nil {
+		log.Println("failed to read replica status:", err)
+		metrics.TrackerReplicaUp.Set(0)
+		return
+	}
+	lag, up := parseReplication(info)
+	metrics.TrackerReplicaStaleness.Set(lag)
+	if up {
+		metrics.TrackerReplicaUp.Set(1)
+	} else {
+		metrics.TrackerReplicaUp.Set(0)
+	}
+}
+
+// parseReplication returns the number of seconds since the replica last
+// heard from its primary and whether the replication link is up, given the
+// output of the Redis "INFO replication" command. A primary instance is never
+// stale.
+func parseReplication(info string) (float64, bool) {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		k, v, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found {
+			fields[k] = v
+		}
+	}
+	if fields["role"] == "master" {
+		return 0, true
+	}
+	up := fields["master_link_status"] == "up"
+	lag, err := strconv.ParseFloat(fields["master_last_io_seconds_ago"], 64)
+	if err != nil || lag < 0 {
+		// The replica has never synced with the primary.
+		return 0, false
+	}
+	return lag, up
+}
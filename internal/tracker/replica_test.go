@@ -0,0 +1,85 @@
+package tracker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeConn struct {
+	reply interface{}
+	err   error
+}
+
+func (c *fakeConn) Close() error                                            { return nil }
+func (c *fakeConn) Err() error                                              { return nil }
+func (c *fakeConn) Send(cmd string, args ...interface{}) error              { return nil }
+func (c *fakeConn) Flush() error                                            { return nil }
+func (c *fakeConn) Receive() (interface{}, error)                           { return c.reply, c.err }
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) { return c.reply, c.err }
+
+func Test_parseReplication(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    string
+		wantLag float64
+		wantUp  bool
+	}{
+		{
+			name:    "primary",
+			info:    "# Replication\r\nrole:master\r\nconnected_slaves:1\r\n",
+			wantLag: 0,
+			wantUp:  true,
+		},
+		{
+			name:    "replica-up",
+			info:    "# Replication\r\nrole:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:3\r\n",
+			wantLag: 3,
+			wantUp:  true,
+		},
+		{
+			name:    "replica-down",
+			info:    "# Replication\r\nrole:slave\r\nmaster_link_status:down\r\nmaster_last_io_seconds_ago:120\r\n",
+			wantLag: 120,
+			wantUp:  false,
+		},
+		{
+			name:    "replica-never-synced",
+			info:    "# Replication\r\nrole:slave\r\nmaster_link_status:down\r\nmaster_last_io_seconds_ago:-1\r\n",
+			wantLag: 0,
+			wantUp:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lag, up := parseReplication(tt.info)
+			if lag != tt.wantLag || up != tt.wantUp {
+				t.Errorf("parseReplication() = (%f, %t), want (%f, %t)", lag, up, tt.wantLag, tt.wantUp)
+			}
+		})
+	}
+}
+
+func Test_checkReplica(t *testing.T) {
+	conn := &fakeConn{
+		reply: []byte("role:slave\r\nmaster_link_status:up\r\nmaster_last_io_seconds_ago:7\r\n"),
+	}
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+
+	checkReplica(pool)
+	if got := testutil.ToFloat64(metrics.TrackerReplicaStaleness); got != 7 {
+		t.Errorf("checkReplica() staleness = %f, want 7", got)
+	}
+	if got := testutil.ToFloat64(metrics.TrackerReplicaUp); got != 1 {
+		t.Errorf("checkReplica() up = %f, want 1", got)
+	}
+
+	conn.err = errors.New("fake info error")
+	checkReplica(pool)
+	if got := testutil.ToFloat64(metrics.TrackerReplicaUp); got != 0 {
+		t.Errorf("checkReplica() up after error = %f, want 0", got)
+	}
+}
@@ -14,3 +14,33 @@ func (t *DNSRecord) RedisScan(x interface{}) error {
 	}
 	return json.Unmarshal(v, t)
 }
+
+// RedisScan determines how Timestamp objects will be interpreted when read
+// from Redis.
+func (t *Timestamp) RedisScan(x interface{}) error {
+	v, ok := x.([]byte)
+	if !ok {
+		return fmt.Errorf("failed to convert %T to []byte]", x)
+	}
+	return json.Unmarshal(v, t)
+}
+
+// RedisScan determines how Window objects will be interpreted when read from
+// Redis.
+func (w *Window) RedisScan(x interface{}) error {
+	v, ok := x.([]byte)
+	if !ok {
+		return fmt.Errorf("failed to convert %T to []byte]", x)
+	}
+	return json.Unmarshal(v, w)
+}
+
+// RedisScan determines how Override objects will be interpreted when read from
+// Redis.
+func (o *Override) RedisScan(x interface{}) error {
+	v, ok := x.([]byte)
+	if !ok {
+		return fmt.Errorf("failed to convert %T to []byte]", x)
+	}
+	return json.Unmarshal(v, o)
+}
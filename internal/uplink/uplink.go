@@ -0,0 +1,81 @@
+// Package uplink defines the canonical uplink speeds of nodes.
+package uplink
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknown is returned by Parse for speeds that are not canonical.
+var ErrUnknown = errors.New("unknown uplink speed")
+
+// Speed is a canonical uplink speed.
+type Speed struct {
+	// Name is the canonical name, e.g. "10g".
+	Name string
+	// Mbps is the capacity in megabits per second.
+	Mbps int64
+}
+
+// speeds are the canonical speeds, slowest first.
+var speeds = []Speed{
+	{"100m", 100},
+	{"1g", 1000},
+	{"2.5g", 2500},
+	{"10g", 10000},
+	{"25g", 25000},
+	{"40g", 40000},
+	{"100g", 100000},
+}
+
+// Names returns the canonical names, slowest first.
+func Names() []string {
+	names := make([]string, len(speeds))
+	for i, s := range speeds {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Parse returns the canonical speed of s. Units are case insensitive and may
+// end in "bps" or "b", so that "10G", "10gbps", and "10000m" are all "10g".
+func Parse(s string) (Speed, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "bps")
+	v = strings.TrimSuffix(v, "b")
+	var unit float64
+	switch {
+	case strings.HasSuffix(v, "m"):
+		unit = 1
+	case strings.HasSuffix(v, "g"):
+		unit = 1000
+	default:
+		return Speed{}, unknown(s)
+	}
+	n, err := strconv.ParseFloat(v[:len(v)-1], 64)
+	if err != nil {
+		return Speed{}, unknown(s)
+	}
+	for _, sp := range speeds {
+		if n*unit == float64(sp.Mbps) {
+			return sp, nil
+		}
+	}
+	return Speed{}, unknown(s)
+}
+
+// Mbps returns the capacity of the canonical speed name, or zero.
+func Mbps(name string) int64 {
+	for _, sp := range speeds {
+		if sp.Name == name {
+			return sp.Mbps
+		}
+	}
+	return 0
+}
+
+func unknown(s string) error {
+	return fmt.Errorf("%w %q, want one of %s", ErrUnknown, s, strings.Join(Names(), ", "))
+}
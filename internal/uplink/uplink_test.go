@@ -0,0 +1,92 @@
+package uplink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Speed
+		wantErr bool
+	}{
+		{
+			name: "success-canonical",
+			s:    "10g",
+			want: Speed{Name: "10g", Mbps: 10000},
+		},
+		{
+			name: "success-fraction",
+			s:    "2.5g",
+			want: Speed{Name: "2.5g", Mbps: 2500},
+		},
+		{
+			name: "success-megabits",
+			s:    "100m",
+			want: Speed{Name: "100m", Mbps: 100},
+		},
+		{
+			name: "success-normalized-case-suffix",
+			s:    " 40Gbps",
+			want: Speed{Name: "40g", Mbps: 40000},
+		},
+		{
+			name: "success-normalized-units",
+			s:    "1000Mb",
+			want: Speed{Name: "1g", Mbps: 1000},
+		},
+		{
+			name: "success-normalized-fraction",
+			s:    "0.1g",
+			want: Speed{Name: "100m", Mbps: 100},
+		},
+		{
+			name:    "error-unknown-speed",
+			s:       "20g",
+			wantErr: true,
+		},
+		{
+			name:    "error-no-unit",
+			s:       "10",
+			wantErr: true,
+		},
+		{
+			name:    "error-not-a-number",
+			s:       "fastg",
+			wantErr: true,
+		},
+		{
+			name:    "error-empty",
+			s:       "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrUnknown) {
+				t.Errorf("Parse() error = %v, want %v", err, ErrUnknown)
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMbps(t *testing.T) {
+	for _, name := range Names() {
+		sp, err := Parse(name)
+		if err != nil || sp.Name != name || Mbps(name) != sp.Mbps {
+			t.Errorf("Parse(%q) = %v, %v; Mbps() = %d", name, sp, err, Mbps(name))
+		}
+	}
+	if got := Mbps("20g"); got != 0 {
+		t.Errorf("Mbps() = %d for unknown speed, want 0", got)
+	}
+}
@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	_ "embed"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
 	"time"
 
-	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/storage"
 	"github.com/gomodule/redigo/redis"
 	"github.com/m-lab/autojoin/handler"
 	"github.com/m-lab/autojoin/iata"
-	"github.com/m-lab/autojoin/internal/adminx"
-	"github.com/m-lab/autojoin/internal/adminx/iamiface"
-	"github.com/m-lab/autojoin/internal/dnsx/dnsiface"
+	"github.com/m-lab/autojoin/internal/cache"
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/geonames"
+	"github.com/m-lab/autojoin/internal/idempotency"
 	"github.com/m-lab/autojoin/internal/maxmind"
-	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/redisx"
+	"github.com/m-lab/autojoin/internal/slo"
+	"github.com/m-lab/autojoin/internal/supervisor"
+	"github.com/m-lab/autojoin/internal/tracing"
 	"github.com/m-lab/autojoin/internal/tracker"
 	"github.com/m-lab/go/content"
 	"github.com/m-lab/go/flagx"
@@ -26,33 +36,155 @@ import (
 	"github.com/m-lab/locate/memorystore"
 	"github.com/m-lab/uuid-annotator/asnannotator"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"google.golang.org/api/dns/v1"
-	"google.golang.org/api/iam/v1"
 )
 
+// openapiYAML is the OpenAPI document deployed to Cloud Endpoints, served with
+// generated response schemas by the spec handler.
+//
+//go:embed openapi.yaml
+var openapiYAML []byte
+
+// version is the build version of the server, e.g. set with
+// -ldflags "-X main.version=v1.2.3". When empty, the VCS revision of the build
+// or the App Engine version is used.
+var version string
+
 var (
 	listenPort   string
 	project      string
+	domain       string
 	redisAddr    string
+	redisRead    string
+	redisCfg     redisx.Config
+	trackerStore string
+	trackerTTL   time.Duration
+	projectsFile string
 	iataSrc      = flagx.MustNewURL("https://raw.githubusercontent.com/ip2location/ip2location-iata-icao/1.0.21/iata-icao.csv")
+	iataRadius   float64
+	iataShrink   float64
+	metrosSrc    = flagx.URL{}
 	maxmindSrc   = flagx.URL{}
+	anonSrc      = flagx.URL{}
 	routeviewSrc = flagx.URL{}
+	asnSource    string
+	asnSrc       = flagx.URL{}
+	geonamesSrc  = flagx.URL{}
+	admin1Src    = flagx.URL{}
+	geonamesDist float64
 	gcTTL        time.Duration
 	gcInterval   time.Duration
+	dnsRetries   int
+	dnsThreshold int
+	dnsCooldown  time.Duration
+	dnsAsync     bool
+	dnsWorkers   int
+	regTimeout   time.Duration
+	proxyHops    int
+	dnsVerify    time.Duration
+	gcSuspended  bool
+	gcHostStats  bool
+	siteRecords  bool
+	dnssecEvery  time.Duration
+	sloInterval  time.Duration
+	listTTL      time.Duration
+	configReload time.Duration
+	dsNamespace  string
+	reportBucket string
+	idemWindow   time.Duration
+	idemDB       int
+	cacheTTL     time.Duration
+	cacheDB      int
+	locateProj   string
+	tokenLife    time.Duration
+	rotateEvery  time.Duration
+	keyMaxAge    time.Duration
+	kmsKey       string
+	orgSetup     bool
+	smtpAddr     string
+	smtpFrom     string
+	smtpUser     string
+	smtpPass     string
+	verifyURL    string
+	verifyTTL    time.Duration
+	minClient    string
+	datasetAge   time.Duration
+	stopTimeout  time.Duration
+	devMode      bool
+	devOrgs      string
 )
 
 func init() {
 	// PORT and GOOGLE_CLOUD_PROJECT are part of the default App Engine environment.
 	flag.StringVar(&listenPort, "port", "8080", "AppEngine port environment variable")
 	flag.StringVar(&project, "google-cloud-project", "", "AppEngine project environment variable")
+	flag.StringVar(&domain, "domain", dnsname.DefaultDomain, "Base domain of node hostnames and DNS zones. The project zone must exist for this domain")
 	flag.Var(&iataSrc, "iata-url", "URL to IATA dataset")
+	flag.Var(&metrosSrc, "iata-metros-url", "URL of a curated dataset of approved metros, e.g. gs://bucket/file. If given, lookups and registrations are restricted to these metros")
+	flag.Float64Var(&iataRadius, "iata-fallback-radius", 500, "Radius in km to search airports of other countries when a country has no airports. Zero disables the fallback")
+	flag.Float64Var(&iataShrink, "iata-max-shrink", iata.DefaultMaxShrink, "Largest fraction of airports that an IATA dataset reload may remove. Zero disables the check")
 	flag.Var(&maxmindSrc, "maxmind-url", "URL of a Maxmind GeoIP dataset, e.g. gs://bucket/file or file:./relativepath/file")
+	flag.Var(&anonSrc, "maxmind-anonymous-url", "URL of a Maxmind GeoIP2 Anonymous IP dataset, e.g. gs://bucket/file. If given, addresses of hosting providers and proxies are flagged at registration")
+	flag.StringVar(&asnSource, "asn-source", "routeview", "Source of ASN annotations: routeview, or maxmind for the IPv4 and IPv6 GeoLite2 ASN dataset of -maxmind-asn-url")
 	flag.Var(&routeviewSrc, "routeview-v4.url", "URL of an ip2prefix routeview IPv4 dataset, e.g. gs://bucket/file and file:./relativepath/file")
-	flag.StringVar(&redisAddr, "redis-address", "", "Primary endpoint for Redis instance")
+	flag.Var(&asnSrc, "maxmind-asn-url", "URL of a Maxmind GeoLite2 ASN dataset, e.g. gs://bucket/file, used with -asn-source=maxmind")
+	flag.Var(&geonamesSrc, "geonames-url", "URL of a GeoNames cities dataset, e.g. gs://bucket/cities15000.txt. If given, the city of nodes that Maxmind does not name is the city nearest their metro")
+	flag.Var(&admin1Src, "geonames-admin1-url", "URL of the GeoNames admin1CodesASCII.txt dataset, used with -geonames-url to name the region of nodes")
+	flag.Float64Var(&geonamesDist, "geonames-max-distance", geonames.DefaultMaxDistance, "Distance in km from a metro beyond which GeoNames cities are not used")
+	flag.StringVar(&redisAddr, "redis-address", "", "Primary endpoint for Redis instance. If empty, DNS entries are tracked in memory, which only suits single-instance deployments")
+	flag.StringVar(&redisRead, "redis-read-address", "", "Read endpoint for Redis read replicas, used by List")
+	flag.StringVar(&redisCfg.Password, "redis-password", "", "AUTH string of the Redis instance. AUTH is disabled if empty. Prefer setting REDIS_PASSWORD in the environment")
+	flag.BoolVar(&redisCfg.TLS, "redis-tls", false, "Connect to Redis with TLS, for instances with in-transit encryption")
+	flag.StringVar(&redisCfg.CACert, "redis-ca-cert", "", "PEM file of the Redis server certificate authorities, e.g. the Memorystore server CA. Defaults to the system roots")
+	flag.DurationVar(&redisCfg.DialTimeout, "redis-dial-timeout", 5*time.Second, "Timeout for connecting to Redis")
+	flag.DurationVar(&redisCfg.ReadTimeout, "redis-read-timeout", 5*time.Second, "Timeout for reading Redis replies")
+	flag.DurationVar(&redisCfg.WriteTimeout, "redis-write-timeout", 5*time.Second, "Timeout for writing Redis commands")
+	flag.IntVar(&redisCfg.MaxIdle, "redis-max-idle", 10, "Maximum number of idle connections of each Redis pool")
+	flag.IntVar(&redisCfg.MaxActive, "redis-max-active", 100, "Maximum number of connections of each Redis pool. Requests wait for a connection at the limit. Zero is unlimited")
+	flag.DurationVar(&redisCfg.IdleTimeout, "redis-idle-timeout", 5*time.Minute, "How long idle Redis connections are kept open")
 
+	flag.StringVar(&trackerStore, "tracker", "memorystore", "Store of tracked DNS entries: memorystore, or datastore for deployments without Redis. Without -redis-address, memorystore entries are kept in memory")
+	flag.DurationVar(&trackerTTL, "tracker-ttl", 7*24*time.Hour, "Time after which Datastore tracker entries that were not updated are deleted. Must exceed -gc-ttl, since the DNS records of deleted entries are not removed")
 	flag.DurationVar(&gcTTL, "gc-ttl", 3*time.Hour, "Time to live for DNS entries")
 	flag.DurationVar(&gcInterval, "gc-interval", 30*time.Minute, "Interval between garbage collection runs")
+	flag.IntVar(&dnsRetries, "dns-retries", 3, "Number of retries of Cloud DNS record reads and changes after 429 and 5xx errors")
+	flag.IntVar(&dnsThreshold, "dns-breaker-threshold", 5, "Consecutive Cloud DNS failures after which registrations skip DNS changes until they are applied in the background. Zero disables the breaker")
+	flag.DurationVar(&dnsCooldown, "dns-breaker-cooldown", 30*time.Second, "Time Cloud DNS calls are skipped after the breaker opens, and the interval between retries of pending DNS changes")
+	flag.BoolVar(&dnsAsync, "dns-async", false, "Respond to registrations before changing their DNS records, which are changed by a queue of workers")
+	flag.IntVar(&dnsWorkers, "dns-workers", 4, "Number of workers changing the DNS records of registrations with -dns-async")
+	flag.DurationVar(&dnsVerify, "dns-verify", 0, "Time a registration waits for Cloud DNS to report its DNS change as done. Zero disables verification")
+	flag.IntVar(&proxyHops, "proxy-hops", 1, "Number of trusted proxy addresses after the client address in X-Forwarded-For, used to verify the source address of registrations")
+	flag.DurationVar(&regTimeout, "register-timeout", 20*time.Second, "Time a registration waits for its backend calls, e.g. Cloud DNS and Secret Manager. Zero disables the limit")
+	flag.BoolVar(&siteRecords, "site-records", false, "Maintain round-robin DNS records with the addresses of every node of a service at a site, e.g. ndt-lga12345.<org>.<project>.measurement-lab.org")
+	flag.DurationVar(&dnssecEvery, "dnssec-check-interval", 24*time.Hour, "Interval between checks of the DNSSEC state of organization zones. Zero disables the checks")
+	flag.BoolVar(&gcHostStats, "gc-host-metrics", true, "Export the DNS expiration of every host. Disable for large deployments; per-org and site aggregates are always exported")
+	flag.BoolVar(&gcSuspended, "gc-expire-suspended", true, "Remove nodes of suspended organizations on the next garbage collection run")
+	flag.DurationVar(&listTTL, "list-cache-ttl", 10*time.Second, "How long to reuse rendered node list results")
+	flag.DurationVar(&configReload, "config-reload-interval", time.Minute, "Interval between reloads of the runtime config from Datastore")
+	flag.StringVar(&dsNamespace, "datastore-namespace", "autojoin", "Datastore namespace for the runtime config, operations, and organization settings")
+	flag.StringVar(&reportBucket, "decommission-bucket", "", "GCS bucket for node decommission reports. Reports are disabled if empty")
+	flag.DurationVar(&idemWindow, "idempotency-window", 10*time.Minute, "How long responses are replayed for repeated Idempotency-Key headers. Zero disables idempotency keys")
+	flag.IntVar(&idemDB, "idempotency-redis-db", 1, "Redis database for idempotency keys. Must differ from the tracker database")
+	flag.StringVar(&locateProj, "locate-project", "", "GCP project of the Locate API, used to restrict API keys created by the admin API. Defaults to mlab-ns for mlab-autojoin, or the -google-cloud-project")
+	flag.DurationVar(&cacheTTL, "org-cache-ttl", time.Minute, "How long organization settings and API key owners and scopes are cached. Zero disables caching")
+	flag.IntVar(&cacheDB, "org-cache-redis-db", -1, "Redis database for a cache shared by all instances. Must differ from the tracker database. Negative disables the shared cache")
+	flag.DurationVar(&tokenLife, "access-token-lifetime", time.Hour, "Lifetime of access tokens returned to nodes of organizations with access tokens enabled. At most 1h without an organization policy exception")
+	flag.DurationVar(&rotateEvery, "key-rotation-interval", 30*24*time.Hour, "Age after which organization service account keys are rotated. Zero disables periodic rotation")
+	flag.DurationVar(&keyMaxAge, "key-max-age", 60*24*time.Hour, "Age after which replaced service account keys are deleted during rotation. Should exceed -key-rotation-interval")
+	flag.StringVar(&kmsKey, "kms-key", "", "Cloud KMS key used to encrypt service account keys before storing them in Secret Manager, e.g. projects/<project>/locations/global/keyRings/<ring>/cryptoKeys/<key>. Empty stores keys unencrypted")
+	flag.DurationVar(&sloInterval, "slo-interval", time.Minute, "Interval between SLO burn rate evaluations")
+	flag.StringVar(&smtpAddr, "smtp-address", "", "SMTP server used to verify and notify organization emails, e.g. smtp.sendgrid.net:587. Emails are disabled if empty")
+	flag.StringVar(&smtpFrom, "smtp-from", "", "Sender address of organization emails")
+	flag.StringVar(&smtpUser, "smtp-username", "", "SMTP username, e.g. apikey for SendGrid. Authentication is disabled if empty")
+	flag.StringVar(&smtpPass, "smtp-password", "", "SMTP password, e.g. a SendGrid API key. Prefer setting SMTP_PASSWORD in the environment")
+	flag.StringVar(&verifyURL, "verify-url", "", "URL of the org/verify endpoint sent in verification emails. Defaults to the App Engine URL of the project")
+	flag.DurationVar(&verifyTTL, "verify-ttl", 72*time.Hour, "How long email verification links are valid")
+	flag.DurationVar(&stopTimeout, "shutdown-timeout", 25*time.Second, "How long to drain requests and finish background deletes after SIGTERM. App Engine stops instances 30s after SIGTERM")
+	flag.DurationVar(&datasetAge, "dataset-max-age", 7*24*time.Hour, "Age after which IATA, Maxmind and ASN datasets that could not be reloaded fail readiness checks. Zero disables the check")
+	flag.StringVar(&minClient, "min-client-version", "", "Default oldest version of the Go client package accepted by the server, until set in the runtime config")
+	flag.BoolVar(&orgSetup, "org-setup", false, "Create organizations when their applications are approved. Requires permission to set the project IAM policy")
+	flag.StringVar(&projectsFile, "projects", "", "JSON registry of the projects served besides -google-cloud-project, routed by request host or the project parameter of admin requests. See README")
+	flag.BoolVar(&devMode, "dev", false, "Use in-memory fakes of Cloud DNS, Datastore, Secret Manager, IAM, API Keys and Memorystore for local development. State is lost on exit")
+	flag.StringVar(&devOrgs, "dev-orgs", "foo", "Comma-separated organizations created on startup in -dev mode. Their API keys are logged")
 
 	// Enable logging with line numbers to trace error locations.
 	log.SetFlags(log.LUTC | log.Llongfile)
@@ -64,60 +196,193 @@ func main() {
 	flag.Parse()
 	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Could not parse env args")
 	defer mainCancel()
+	// App Engine sends SIGTERM before stopping an instance.
+	sigCtx, sigCancel := signal.NotifyContext(mainCtx, syscall.SIGTERM, os.Interrupt)
+	defer sigCancel()
 
 	prom := prometheusx.MustServeMetrics()
 	defer prom.Close()
 
-	// Setup DNS service.
-	ds, err := dns.NewService(mainCtx)
-	rtx.Must(err, "failed to create new dns service")
-	d := dnsiface.NewCloudDNSService(ds)
+	// Background jobs are restarted after failures or panics.
+	sup := supervisor.New(mainCtx)
+	defer sup.Wait()
+
+	// Compute SLO burn rates from the request handler histogram.
+	e := slo.NewEvaluator(prometheus.DefaultGatherer, slo.Objectives, slo.Windows)
+	sup.Go("slo", func(ctx context.Context) error {
+		e.Run(ctx, sloInterval)
+		return nil
+	})
+
+	// Trace requests and the calls of the Google Cloud clients.
+	rtx.Must(tracing.Setup(), "failed to setup tracing")
+	rtx.Must(dnsname.ValidateDomain(domain), "invalid -domain")
+	// In -dev mode, Google Cloud services and Memorystore are replaced by
+	// in-memory fakes.
+	if trackerStore != "memorystore" && trackerStore != "datastore" {
+		log.Fatalf("invalid -tracker %q; must be memorystore or datastore", trackerStore)
+	}
+	if asnSource != "routeview" && asnSource != "maxmind" {
+		log.Fatalf("invalid -asn-source %q; must be routeview or maxmind", asnSource)
+	}
+	if asnSource == "maxmind" && asnSrc.URL == nil {
+		log.Fatal("-asn-source=maxmind requires -maxmind-asn-url")
+	}
+	if devMode && project == "" {
+		project = "mlab-sandbox"
+	}
+	var registry []projectConfig
+	if projectsFile != "" {
+		var err error
+		registry, err = loadProjects(projectsFile)
+		rtx.Must(err, "failed to load -projects")
+	}
+	var c *clients
+	if devMode {
+		log.Printf("Running in -dev mode with in-memory Google Cloud services for project %s", project)
+		c = newDevClients(mainCtx, strings.Split(devOrgs, ","), registry)
+	} else {
+		c = newCloudClients(mainCtx)
+	}
+	defer c.Close()
 
 	// Setup IATA, maxmind, and asn sources.
 	i, err := iata.New(mainCtx, iataSrc.URL)
 	rtx.Must(err, "failed to load iata dataset")
+	i.FallbackRadius = iataRadius
+	i.MaxShrink = iataShrink
+	if metrosSrc.URL != nil {
+		rtx.Must(i.SetMetros(mainCtx, metrosSrc.URL), "failed to load metros dataset")
+	}
 	mmsrc, err := content.FromURL(mainCtx, maxmindSrc.URL)
 	rtx.Must(err, "failed to load maxmindurl: %s", maxmindSrc.URL)
 	mm := maxmind.NewMaxmind(mmsrc)
-	rvsrc, err := content.FromURL(mainCtx, routeviewSrc.URL)
-	rtx.Must(err, "Could not load routeview v4 URL")
-	asn := asnannotator.NewIPv4(mainCtx, rvsrc)
-
-	// Secret Manager & Service Accounts
-	sc, err := secretmanager.NewClient(mainCtx)
-	rtx.Must(err, "failed to create secretmanager client")
-	defer sc.Close()
-	ic, err := iam.NewService(mainCtx)
-	rtx.Must(err, "failed to create iam service client")
-	n := adminx.NewNamer(project)
-	sa := adminx.NewServiceAccountsManager(iamiface.NewIAM(ic), n)
-	sm := adminx.NewSecretManager(sc, n, sa)
-
-	// Connect to memorystore.
-	pool := &redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			return redis.Dial("tcp", redisAddr)
-		},
-	}
-	msClient := memorystore.NewClient[tracker.Status](pool)
-
-	// Test connection by calling GetAll
-	entries, err := msClient.GetAll()
-	rtx.Must(err, "Could not connect to memorystore")
-	log.Printf("Connected to memorystore at %s", redisAddr)
-	log.Printf("Number of tracked DNS entries: %d", len(entries))
-
-	gc := tracker.NewGarbageCollector(d, project, msClient, gcTTL, gcInterval)
-	log.Print("DNS garbage collector started")
-	defer gc.Stop()
-
-	// Create server.
-	s := handler.NewServer(project, i, mm, asn, d, gc, sm)
-	go func() {
+	if anonSrc.URL != nil {
+		src, err := content.FromURL(mainCtx, anonSrc.URL)
+		rtx.Must(err, "failed to load maxmind anonymous ip url: %s", anonSrc.URL)
+		mm.SetAnonymousIP(src)
+	}
+	// The sources of the datasets are reported by the datasets admin handler.
+	sources := map[string]string{
+		"iata":    iataSrc.URL.Redacted(),
+		"maxmind": maxmindSrc.URL.Redacted(),
+	}
+	var asn handler.ASNFinder
+	switch {
+	case asnSource == "maxmind":
+		src, err := content.FromURL(mainCtx, asnSrc.URL)
+		rtx.Must(err, "failed to load maxmind asn url: %s", asnSrc.URL)
+		asn = maxmind.NewASN(src)
+		sources["asn"] = asnSrc.URL.Redacted()
+	case devMode && routeviewSrc.URL == nil:
+		// Without a routeview dataset, every IP is annotated with a fake ASN.
+		asn = asnannotator.NewFake()
+	default:
+		rvsrc, err := content.FromURL(mainCtx, routeviewSrc.URL)
+		rtx.Must(err, "Could not load routeview v4 URL")
+		asn = asnannotator.NewIPv4(mainCtx, rvsrc)
+		sources["asn"] = routeviewSrc.URL.Redacted()
+	}
+	var gn *geonames.Client
+	if geonamesSrc.URL != nil {
+		gn, err = geonames.New(mainCtx, geonamesSrc.URL)
+		rtx.Must(err, "failed to load geonames url: %s", geonamesSrc.URL)
+		gn.MaxDistance = geonamesDist
+		sources["geonames"] = geonamesSrc.URL.Redacted()
+		if admin1Src.URL != nil {
+			rtx.Must(gn.SetAdmin1(mainCtx, admin1Src.URL), "failed to load geonames admin1 url: %s", admin1Src.URL)
+		}
+	}
+
+	// Pools are monitored while the server runs.
+	pools := map[string]*redis.Pool{}
+	if c.pool != nil {
+		pools["tracker"] = c.pool
+	}
+	var replica tracker.MemorystoreReader[tracker.Status]
+	if c.pool != nil && redisRead != "" {
+		// Serve List from read replicas to protect registration writes.
+		readPool, err := redisCfg.NewPool("replica", redisRead, 0)
+		rtx.Must(err, "failed to create redis read pool")
+		pools["replica"] = readPool
+		replica = memorystore.NewClient[tracker.Status](readPool)
+		sup.Go("replica", func(ctx context.Context) error {
+			tracker.MonitorReplica(ctx, readPool, time.Minute)
+			return nil
+		})
+		log.Printf("Reading tracker entries from memorystore replicas at %s", redisRead)
+	}
+
+	// Idempotency keys are kept in a separate database, since the tracker
+	// reads every key in its own. They need Redis.
+	var idem handler.IdempotencyStore
+	if c.pool != nil && idemWindow > 0 {
+		idemPool, err := redisCfg.NewPool("idempotency", redisAddr, idemDB)
+		rtx.Must(err, "failed to create redis idempotency pool")
+		pools["idempotency"] = idemPool
+		idem = idempotency.NewStore(idemPool, idemWindow)
+	}
+
+	// Organization settings and API key owners are read on every request.
+	// Admin updates invalidate cached settings, but other instances without
+	// the shared cache may use old settings for up to one TTL.
+	var shared cache.Shared
+	if c.pool != nil && cacheDB >= 0 {
+		cachePool, err := redisCfg.NewPool("cache", redisAddr, cacheDB)
+		rtx.Must(err, "failed to create redis cache pool")
+		pools["cache"] = cachePool
+		shared = cache.NewRedis(cachePool)
+	}
+	if len(pools) > 0 {
+		sup.Go("redis-pools", func(ctx context.Context) error {
+			redisx.Monitor(ctx, pools, 10*time.Second)
+			return nil
+		})
+	}
+	if verifyURL == "" {
+		verifyURL = "https://autojoin-dot-" + project + ".appspot.com/autojoin/v0/org/verify"
+	}
+	if smtpAddr != "" {
+		log.Printf("Sending organization emails through %s", smtpAddr)
+	}
+	deps := &env{c: c, sup: sup, iata: i, mm: mm, asn: asn, geonames: gn, sources: sources, idem: idem, shared: shared}
+	if reportBucket != "" {
+		gcs, err := storage.NewClient(mainCtx, c.httpOpts...)
+		rtx.Must(err, "failed to create storage client")
+		defer gcs.Close()
+		deps.gcs = gcs
+		log.Printf("Reporting decommissioned nodes to gs://%s", reportBucket)
+	}
+
+	// Create the servers of the primary project and of the other projects
+	// of the registry. Requests are routed to their project by host, or by
+	// the project parameter of admin requests.
+	if locateProj == "" {
+		locateProj = locateProject(project)
+	}
+	primary := newProject(mainCtx, deps, projectConfig{
+		Project:       project,
+		Domain:        domain,
+		Namespace:     dsNamespace,
+		LocateProject: locateProj,
+		primary:       true,
+	}, c.tracker)
+	s := primary.s
+	if replica != nil {
+		primary.gc.ReadFrom(replica)
+	}
+	router := handler.NewProjectRouter(project, primary.mux, primary.validator)
+	servers := []*projectServer{primary}
+	for _, pc := range registry {
+		p := newProject(mainCtx, deps, pc, c.newTracker(pc))
+		router.Add(pc.Project, pc.Hosts, p.mux)
+		servers = append(servers, p)
+		log.Printf("Serving project %s for hosts %v", pc.Project, pc.Hosts)
+	}
+	// Datasets are shared by all projects, and reloaded by the primary.
+	sup.Go("reload", func(ctx context.Context) error {
 		// Load once.
-		s.Iata.Load(mainCtx)
-		s.Maxmind.Reload(mainCtx)
-		s.ASN.Reload(mainCtx)
+		s.Reload(ctx)
 
 		// Check and reload db at least once a day.
 		reloadConfig := memoryless.Config{
@@ -125,45 +390,74 @@ func main() {
 			Max:      3 * 24 * time.Hour,
 			Expected: 24 * time.Hour,
 		}
-		tick, err := memoryless.NewTicker(mainCtx, reloadConfig)
-		rtx.Must(err, "Could not create ticker for reloading")
+		tick, err := memoryless.NewTicker(ctx, reloadConfig)
+		if err != nil {
+			return err
+		}
+		defer tick.Stop()
 		for range tick.C {
-			s.Iata.Load(mainCtx)
-			s.Maxmind.Reload(mainCtx)
-			s.ASN.Reload(mainCtx)
+			s.Reload(ctx)
 		}
-	}()
-
-	mux := http.NewServeMux()
-	// USER APIs
-	mux.HandleFunc("/autojoin/v0/lookup", promhttp.InstrumentHandlerDuration(
-		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/lookup"}),
-		http.HandlerFunc(s.Lookup)))
-
-	// AUTOJOIN APIs
-	// Nodes register on start up.
-	mux.HandleFunc("/autojoin/v0/node/register", promhttp.InstrumentHandlerDuration(
-		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/register"}),
-		http.HandlerFunc(s.Register)))
-
-	mux.HandleFunc("/autojoin/v0/node/delete", promhttp.InstrumentHandlerDuration(
-		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/delete"}),
-		http.HandlerFunc(s.Delete)))
-
-	mux.HandleFunc("/autojoin/v0/node/list", promhttp.InstrumentHandlerDuration(
-		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/list"}),
-		http.HandlerFunc(s.List)))
-
-	// Liveness and Readiness checks to support deployments.
-	mux.HandleFunc("/v0/live", s.Live)
-	mux.HandleFunc("/v0/ready", s.Ready)
+		return nil
+	})
+	sup.Go("datasets", func(ctx context.Context) error {
+		s.MonitorDatasets(ctx, time.Minute)
+		return nil
+	})
 
 	srv := &http.Server{
-		Addr:    ":" + listenPort,
-		Handler: mux,
+		Addr: ":" + listenPort,
+		// Clients that accept problem+json receive RFC 7807 errors, including
+		// the errors of recovered panics. Every request is logged with its ID,
+		// and traced.
+		Handler: tracing.Handler(handler.WithRequestLogging(project,
+			handler.WithProblemDetails(handler.WithRecovery(router.ServeHTTP)))),
+	}
+	for _, p := range servers {
+		srv.RegisterOnShutdown(p.s.CloseStreams)
 	}
 	log.Println("Listening for INSECURE access requests on " + listenPort)
 	rtx.Must(httpx.ListenAndServeAsync(srv), "Could not start server")
-	defer srv.Close()
-	<-mainCtx.Done()
+	<-sigCtx.Done()
+
+	// Stop accepting requests and drain in-flight handlers, and let
+	// asynchronous deletes finish their DNS and tracker updates, before
+	// stopping the garbage collector and other background jobs.
+	log.Println("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Failed to drain requests: %v", err)
+		srv.Close()
+	}
+	for _, p := range servers {
+		if err := p.s.Wait(ctx); err != nil {
+			log.Printf("Failed to finish background deletes: %v", err)
+		}
+	}
+	// A garbage collection in progress completes before Run returns.
+	for _, p := range servers {
+		p.gc.Stop()
+	}
+	mainCancel()
+	sup.Wait()
+	log.Println("Shutdown complete")
+}
+
+// buildVersion returns the version of the server build.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, kv := range info.Settings {
+			if kv.Key == "vcs.revision" {
+				return kv.Value
+			}
+		}
+	}
+	if v := os.Getenv("GAE_VERSION"); v != "" {
+		return v
+	}
+	return "dev"
 }
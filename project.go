@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/autojoin/handler"
+	"github.com/m-lab/autojoin/iata"
+	"github.com/m-lab/autojoin/internal/adminx"
+	"github.com/m-lab/autojoin/internal/cache"
+	"github.com/m-lab/autojoin/internal/config"
+	"github.com/m-lab/autojoin/internal/decommission"
+	"github.com/m-lab/autojoin/internal/dnsname"
+	"github.com/m-lab/autojoin/internal/dnsx"
+	"github.com/m-lab/autojoin/internal/events"
+	"github.com/m-lab/autojoin/internal/geonames"
+	"github.com/m-lab/autojoin/internal/keys"
+	"github.com/m-lab/autojoin/internal/maxmind"
+	"github.com/m-lab/autojoin/internal/metrics"
+	"github.com/m-lab/autojoin/internal/nodekeys"
+	"github.com/m-lab/autojoin/internal/notify"
+	"github.com/m-lab/autojoin/internal/operation"
+	"github.com/m-lab/autojoin/internal/orgs"
+	"github.com/m-lab/autojoin/internal/provision"
+	"github.com/m-lab/autojoin/internal/rotation"
+	"github.com/m-lab/autojoin/internal/signup"
+	"github.com/m-lab/autojoin/internal/spec"
+	"github.com/m-lab/autojoin/internal/supervisor"
+	"github.com/m-lab/autojoin/internal/tracker"
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// projectConfig is a target project served by the instance. The primary
+// project is configured by flags, and other projects by the -projects
+// registry.
+type projectConfig struct {
+	// Project is the Google Cloud project of the DNS zones, service accounts,
+	// secrets and API keys of the project.
+	Project string
+	// Domain is the base domain of node hostnames. Defaults to -domain.
+	Domain string
+	// Namespace is the Datastore namespace of the project, in the Datastore of
+	// the primary project. Defaults to -datastore-namespace and the project,
+	// e.g. autojoin-mlab-staging.
+	Namespace string
+	// LocateProject is the project of the Locate API that API keys are
+	// restricted to. Defaults like -locate-project.
+	LocateProject string
+	// Hosts are the request hosts routed to the project, e.g.
+	// autojoin-dot-mlab-staging.appspot.com.
+	Hosts []string
+	// RedisDB is the Redis database of the tracker of the project. It must
+	// differ from the databases of other projects, idempotency keys and the
+	// shared cache.
+	RedisDB int
+
+	primary bool
+}
+
+// job returns the name of a background job of the project. Jobs of the
+// primary project keep their names.
+func (p *projectConfig) job(name string) string {
+	if p.primary {
+		return name
+	}
+	return name + "/" + p.Project
+}
+
+// locateProject returns the default Locate API project of the project.
+func locateProject(project string) string {
+	if project == "mlab-autojoin" {
+		return "mlab-ns"
+	}
+	return project
+}
+
+// loadProjects reads the registry of the projects served besides the primary
+// project from the JSON file at path, and applies defaults.
+func loadProjects(path string) ([]projectConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	projects := []projectConfig{}
+	if err := json.Unmarshal(b, &projects); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	seen := map[string]bool{project: true}
+	dbs := map[int]bool{0: true, idemDB: true, cacheDB: true}
+	for i := range projects {
+		p := &projects[i]
+		if p.Project == "" || seen[p.Project] {
+			return nil, fmt.Errorf("missing or duplicate project %q", p.Project)
+		}
+		seen[p.Project] = true
+		if p.Domain == "" {
+			p.Domain = domain
+		}
+		if err := dnsname.ValidateDomain(p.Domain); err != nil {
+			return nil, fmt.Errorf("invalid domain of %s: %w", p.Project, err)
+		}
+		if p.Namespace == "" {
+			p.Namespace = dsNamespace + "-" + p.Project
+		}
+		if p.LocateProject == "" {
+			p.LocateProject = locateProject(p.Project)
+		}
+		if redisAddr != "" && trackerStore == "memorystore" {
+			if dbs[p.RedisDB] {
+				return nil, fmt.Errorf("redis database %d of %s is already used", p.RedisDB, p.Project)
+			}
+			dbs[p.RedisDB] = true
+		}
+	}
+	return projects, nil
+}
+
+// env holds the clients and datasets shared by the servers of all projects.
+type env struct {
+	c    *clients
+	sup  *supervisor.Supervisor
+	iata *iata.Client
+	mm   *maxmind.Maxmind
+	asn  handler.ASNFinder
+	// geonames is nil unless -geonames-url is set.
+	geonames *geonames.Client
+	// sources are the URLs of the datasets by name.
+	sources map[string]string
+	idem    handler.IdempotencyStore
+	shared  cache.Shared
+	// gcs uploads decommission reports if -decommission-bucket is set.
+	gcs *storage.Client
+}
+
+// projectServer is the server of a target project.
+type projectServer struct {
+	s         *handler.Server
+	gc        *tracker.GarbageCollector
+	mux       *http.ServeMux
+	validator handler.APIKeyValidator
+}
+
+// newProject creates the server of the project, whose DNS entries are tracked
+// by ms, and starts its background jobs.
+func newProject(ctx context.Context, e *env, p projectConfig, ms tracker.MemorystoreClient[tracker.Status]) *projectServer {
+	c := e.c
+	pz := dnsx.NewManager(c.dns, p.Project, dnsname.ProjectZone(p.Project, p.Domain))
+	err := pz.CheckZone(ctx, pz.Zone, dnsname.ProjectDNS(p.Project, p.Domain))
+	rtx.Must(err, "project zone of %s is missing for domain %s; run orgadm bootstrap", p.Project, p.Domain)
+
+	// Secret Manager & Service Accounts
+	n := adminx.NewNamer(p.Project)
+	sa := adminx.NewServiceAccountsManager(c.iam, n)
+	sm := adminx.NewSecretManager(c.secrets, n, sa)
+	if c.kms != nil {
+		sm.EncryptWith(c.kms, kmsKey)
+	}
+	ak := adminx.NewAPIKeys(p.LocateProject, c.keys, n)
+	rtx.Must(ak.LoadProjectNumber(ctx, c.crm(p.Project)), "failed to read project number of %s", p.Project)
+
+	gc := tracker.NewGarbageCollector(c.dns, p.Project, ms, gcTTL, gcInterval)
+	gc.ExportHostMetrics(gcHostStats)
+	gc.SiteRecords(siteRecords)
+	// Addresses registered before the start are known without waiting for
+	// the first GC run.
+	if err := gc.LoadIndexes(); err != nil {
+		log.Printf("Failed to load the hostname index of %s: %v", p.Project, err)
+	}
+	e.sup.Go(p.job("gc"), gc.Run)
+	// Registrations made while the Cloud DNS breaker was open are applied
+	// once it closes.
+	e.sup.Go(p.job("dns-pending"), func(ctx context.Context) error {
+		return gc.RunPending(ctx, dnsCooldown)
+	})
+	if dnsAsync {
+		e.sup.Go(p.job("dns-queue"), func(ctx context.Context) error {
+			return gc.RunQueue(ctx, dnsWorkers)
+		})
+	}
+	if dt, ok := ms.(*tracker.DatastoreClient); ok {
+		e.sup.Go(p.job("tracker-expiry"), func(ctx context.Context) error {
+			return dt.Run(ctx, time.Hour)
+		})
+	}
+	log.Printf("DNS garbage collector of %s started", p.Project)
+
+	// Runtime config overrides the GC and client version flags once set through
+	// the admin API.
+	dc := c.ds
+	rc := config.NewManager(dc, p.Namespace, config.Config{GCTTL: gcTTL, GCInterval: gcInterval, MinClientVersion: minClient}, gc)
+	rtx.Must(rc.Load(ctx), "failed to load runtime config of %s", p.Project)
+	e.sup.Go(p.job("config"), func(ctx context.Context) error {
+		return rc.Run(ctx, configReload)
+	})
+
+	keyStore := keys.NewStore(dc, p.Namespace)
+	validator := handler.NewCachedAPIKeyValidator(
+		keys.NewValidator(ak, keyStore),
+		cache.New[keys.Info](p.job("keys"), cacheTTL, e.shared),
+		cache.New[string](p.job("key-ids"), cacheTTL, e.shared))
+
+	// Create server.
+	s := handler.NewServer(p.Project, e.iata, e.mm, e.asn, c.dns, gc, sm)
+	s.ListCacheTTL = listTTL
+	s.RegisterTimeout = regTimeout
+	s.ProxyHops = proxyHops
+	s.VerifyDNS = dnsVerify
+	s.SiteRecords = siteRecords
+	s.Domain = p.Domain
+	if e.geonames != nil {
+		s.Geonames = e.geonames
+	}
+	if dnsAsync {
+		s.DNSQueue = gc
+	}
+	// Node keys are issued to nodes of organizations that enable them, and
+	// are revoked when the node is deleted or expires.
+	nk := nodekeys.NewManager(sa, dc, p.Namespace)
+	s.NodeKeys = nk
+	s.AccessTokens = adminx.NewAccessTokens(c.creds, n, tokenLife)
+	if dnssecEvery > 0 {
+		// Organization zones are created by orgadm, which publishes their DS
+		// records; problems that appear later are exported as metrics.
+		o := adminx.NewOrg(p.Project, c.crm(p.Project), sa, sm, pz, ak, false)
+		o.Domain = p.Domain
+		e.sup.Go(p.job("dnssec"), func(ctx context.Context) error {
+			return o.RunDNSSECChecks(ctx, dnssecEvery)
+		})
+	}
+	rm := rotation.NewManager(adminx.NewRotator(sm, keyMaxAge), gc, rotateEvery)
+	s.KeyRotation = rm
+	if rotateEvery > 0 {
+		e.sup.Go(p.job("rotation"), func(ctx context.Context) error {
+			return rm.Run(ctx, time.Hour)
+		})
+	}
+	// Node events of all instances are relayed through Redis Pub/Sub. Removed
+	// hostnames are published by the events reporter. Without Redis, events are
+	// delivered within the instance.
+	b := events.NewBroker()
+	var ev handler.EventStream = b
+	if c.pool != nil {
+		re := events.NewRedis(c.pool, b)
+		if !p.primary {
+			re.Channel = events.Channel + ":" + p.Project
+		}
+		e.sup.Go(p.job("events"), re.Run)
+		ev = re
+	}
+	s.NodeEvents = ev
+	reporters := tracker.Reporters{nk, events.NewReporter(ev)}
+	orgStore := orgs.NewCachedStore(orgs.NewStore(dc, p.Namespace), cache.New[orgs.Settings](p.job("orgs"), cacheTTL, e.shared))
+	if smtpAddr != "" {
+		// Verified organization emails are notified of key rotations and
+		// expired nodes.
+		u := verifyURL
+		if u == "" || !p.primary {
+			u = "https://autojoin-dot-" + p.Project + ".appspot.com/autojoin/v0/org/verify"
+		}
+		nt := notify.NewNotifier(notify.NewSMTP(smtpAddr, smtpFrom, smtpUser, smtpPass), dc, p.Namespace, orgStore, u, verifyTTL)
+		s.Emails = nt
+		rm.NotifyWith(nt)
+		reporters = append(reporters, nt)
+	}
+	if e.gcs != nil {
+		// Record removed nodes for the data pipeline.
+		prefix := "decommission/"
+		if !p.primary {
+			prefix += p.Project + "/"
+		}
+		reporters = append(reporters, decommission.NewReporter(decommission.NewGCSUploader(e.gcs, reportBucket), prefix))
+	}
+	gc.ReportTo(reporters)
+	s.Decommission = reporters
+	s.RuntimeConfig = rc
+	s.Operations = operation.NewStore(dc, p.Namespace)
+	s.APIKeys = keys.NewManager(ak, keyStore)
+	s.KeyCache = validator
+	s.Tokens = provision.NewStore(c.tokens, p.Namespace)
+	s.Orgs = orgStore
+	s.History = orgs.NewHistory(dc, p.Namespace)
+	s.Signups = signup.NewStore(c.signups, p.Namespace)
+	if orgSetup {
+		// Approved applications create the organization like orgadm create.
+		o := adminx.NewOrg(p.Project, c.crm(p.Project), sa, sm, pz, ak, false)
+		o.Domain = p.Domain
+		s.OrgSetup = o
+	}
+	if gcSuspended {
+		gc.ExpireSuspended(orgStore)
+	}
+	s.OpenAPI, err = spec.Generate(openapiYAML, p.Project)
+	rtx.Must(err, "failed to generate openapi document")
+	s.BuildVersion = buildVersion()
+	s.MinClientVersion = minClient
+	// Ready fails until the datasets are loaded, or while a dependency is
+	// unavailable.
+	s.DatasetMaxAge = datasetAge
+	s.DatasetSources = e.sources
+	s.Checks = c.checks
+	return &projectServer{s: s, gc: gc, mux: routes(s, validator, e.idem), validator: validator}
+}
+
+// routes returns the handlers of the endpoints of the server.
+func routes(s *handler.Server, validator handler.APIKeyValidator, idem handler.IdempotencyStore) *http.ServeMux {
+	mux := http.NewServeMux()
+	// USER APIs
+	mux.HandleFunc("/autojoin/v0/lookup", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/lookup"}),
+		http.HandlerFunc(s.Lookup)))
+	mux.HandleFunc("/autojoin/v0/lookup/batch", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/lookup/batch"}),
+		http.HandlerFunc(s.LookupBatch)))
+	mux.HandleFunc("/autojoin/v0/lookup/validate", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/lookup/validate"}),
+		http.HandlerFunc(s.ValidateMetro)))
+	mux.HandleFunc("/autojoin/v0/lookup/search", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/lookup/search"}),
+		http.HandlerFunc(s.SearchAirports)))
+
+	// AUTOJOIN APIs
+	// Nodes register on start up.
+	mux.HandleFunc("/autojoin/v0/node/register", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/register"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRegister, handler.WithRedactedIdempotency(idem, s.RegisterRedactor(), s.Register)))))
+
+	// New nodes register once with a provisioning token instead of an
+	// organization API key.
+	mux.HandleFunc("/autojoin/v0/node/provision", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/provision"}),
+		http.HandlerFunc(s.Provision)))
+
+	// Nodes check their local registration for drift.
+	mux.HandleFunc("/autojoin/v0/node/diff", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/diff"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRegister, s.Diff))))
+
+	// Nodes update ports or probability without re-registering.
+	mux.HandleFunc("/autojoin/v0/node/update", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/update"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRegister, s.Update))))
+
+	// Nodes refresh short-lived access tokens before they expire.
+	mux.HandleFunc("/autojoin/v0/node/token", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/token"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRegister, s.Token))))
+
+	// Nodes check their registration and whether their DNS records changed.
+	mux.HandleFunc("/autojoin/v0/node/get", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/get"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRegister, s.Get))))
+
+	// Operators declare planned maintenance so that nodes are not expired.
+	mux.HandleFunc("/autojoin/v0/node/maintenance", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/maintenance"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRegister, s.Maintenance))))
+
+	mux.HandleFunc("/autojoin/v0/node/delete", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/delete"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeDelete, handler.WithIdempotency(idem, s.Delete)))))
+
+	mux.HandleFunc("/autojoin/v0/node/delete-site", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/delete-site"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeDelete, s.DeleteSite))))
+
+	// Operators manage extra records of their organization zone.
+	mux.HandleFunc("/autojoin/v0/org/records", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/org/records"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeRecords, s.Records))))
+
+	// Clients poll the status of asynchronous requests.
+	mux.HandleFunc("/autojoin/v0/operation", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/operation"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeDelete, s.Operation))))
+
+	// New organizations apply to join, and are created once approved.
+	mux.HandleFunc("/autojoin/v0/org/apply", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/org/apply"}),
+		http.HandlerFunc(s.Apply)))
+
+	// Organizations verify their contact email with the emailed link.
+	mux.HandleFunc("/autojoin/v0/org/verify", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/org/verify"}),
+		http.HandlerFunc(s.Verify)))
+
+	// Dashboards stream node changes instead of polling List.
+	mux.HandleFunc("/autojoin/v0/node/events", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/events"}),
+		http.HandlerFunc(s.Events)))
+
+	mux.HandleFunc("/autojoin/v0/node/list", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/node/list"}),
+		http.HandlerFunc(s.List)))
+
+	// ADMIN APIs
+	// Admin endpoints manage every organization, so they require keys
+	// granted the admin scope.
+	mux.HandleFunc("/autojoin/v0/admin/config", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/config"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Config))))
+
+	mux.HandleFunc("/autojoin/v0/admin/org", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/org"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Org))))
+
+	mux.HandleFunc("/autojoin/v0/admin/org/history", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/org/history"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.OrgHistory))))
+
+	mux.HandleFunc("/autojoin/v0/admin/applications", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/applications"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Applications))))
+
+	mux.HandleFunc("/autojoin/v0/admin/keys", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/keys"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Keys))))
+
+	mux.HandleFunc("/autojoin/v0/admin/rotate", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/rotate"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Rotate))))
+
+	mux.HandleFunc("/autojoin/v0/admin/override", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/override"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Override))))
+
+	mux.HandleFunc("/autojoin/v0/admin/expire", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/expire"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Expire))))
+
+	mux.HandleFunc("/autojoin/v0/admin/client-versions", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/client-versions"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.ClientVersions))))
+
+	mux.HandleFunc("/autojoin/v0/admin/datasets", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/admin/datasets"}),
+		handler.WithAPIKeyValidation(validator, handler.RequireScope(keys.ScopeAdmin, s.Datasets))))
+
+	mux.HandleFunc("/autojoin/v0/spec", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/spec"}),
+		http.HandlerFunc(s.Spec)))
+
+	mux.HandleFunc("/autojoin/v0/version", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(prometheus.Labels{"path": "/autojoin/v0/version"}),
+		http.HandlerFunc(s.Version)))
+
+	// Liveness and Readiness checks to support deployments.
+	mux.HandleFunc("/v0/live", s.Live)
+	mux.HandleFunc("/v0/ready", s.Ready)
+	return mux
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m-lab/autojoin/handler"
+	"github.com/m-lab/autojoin/internal/keys"
+)
+
+type fakeValidator struct {
+	scopes []string
+}
+
+func (f *fakeValidator) ValidateKey(ctx context.Context, key string) (*keys.Info, error) {
+	return &keys.Info{ID: "autojoin-key-foo", Org: "foo", Scopes: f.scopes}, nil
+}
+
+func TestRoutes_Admin(t *testing.T) {
+	paths := []string{
+		"/autojoin/v0/admin/config",
+		"/autojoin/v0/admin/org",
+		"/autojoin/v0/admin/org/history",
+		"/autojoin/v0/admin/applications",
+		"/autojoin/v0/admin/keys",
+		"/autojoin/v0/admin/rotate",
+		"/autojoin/v0/admin/override",
+		"/autojoin/v0/admin/expire",
+		"/autojoin/v0/admin/client-versions",
+		"/autojoin/v0/admin/datasets",
+	}
+	tests := []struct {
+		name     string
+		query    string
+		scopes   []string
+		wantCode int
+	}{
+		{
+			name:     "error-missing-key",
+			scopes:   keys.AllScopes,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-register-key",
+			query:    "?key=abc",
+			scopes:   []string{keys.ScopeRegister},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "error-default-scopes",
+			query:    "?key=abc",
+			scopes:   keys.DefaultScopes,
+			wantCode: http.StatusForbidden,
+		},
+	}
+	s := handler.NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := routes(s, &fakeValidator{scopes: tt.scopes}, nil)
+			for _, path := range paths {
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, path+tt.query, nil)
+				mux.ServeHTTP(rw, req)
+				if rw.Code != tt.wantCode {
+					t.Errorf("GET %s returned %d, want %d", path, rw.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+
+	// Admin keys reach the handler, where key management is not enabled.
+	mux := routes(s, &fakeValidator{scopes: []string{keys.ScopeAdmin}}, nil)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/autojoin/v0/admin/keys?key=abc&org=foo", nil)
+	mux.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotImplemented {
+		t.Errorf("GET /autojoin/v0/admin/keys returned %d, want %d", rw.Code, http.StatusNotImplemented)
+	}
+}
+
+func TestRoutes_Register(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		scopes   []string
+		wantCode int
+	}{
+		{
+			name:     "error-missing-key",
+			scopes:   keys.DefaultScopes,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "error-records-key",
+			query:    "?key=abc",
+			scopes:   []string{keys.ScopeRecords},
+			wantCode: http.StatusForbidden,
+		},
+	}
+	s := handler.NewServer("mlab-sandbox", nil, nil, nil, nil, nil, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := routes(s, &fakeValidator{scopes: tt.scopes}, nil)
+			for _, path := range []string{"/autojoin/v0/node/register", "/autojoin/v0/node/diff"} {
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, path+tt.query, nil)
+				mux.ServeHTTP(rw, req)
+				if rw.Code != tt.wantCode {
+					t.Errorf("POST %s returned %d, want %d", path, rw.Code, tt.wantCode)
+				}
+			}
+		})
+	}
+}